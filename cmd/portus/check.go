@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/amscotti/portus/internal/capability"
+	"github.com/amscotti/portus/internal/config"
+)
+
+// runCheck implements "portus check": it loads and validates the
+// configuration as the server would, then prints each alias setting that is
+// likely to fail at request time. It accepts the server's flags and exits
+// non-zero only when the configuration is invalid.
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	if err := config.ParseFlags(args, stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	store, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "check: %v\n", err)
+		return 1
+	}
+	validationErrors := config.ValidateConfig(store)
+	for _, err := range validationErrors {
+		fmt.Fprintf(stdout, "error: %v\n", err)
+	}
+
+	warnings := 0
+	for _, alias := range store.ModelAliases() {
+		model, _ := store.Model(alias)
+		for _, w := range capability.Check(model) {
+			warnings++
+			fmt.Fprintf(stdout, "warning: %s: %s\n", alias, w)
+		}
+	}
+
+	fmt.Fprintf(stdout, "checked %d aliases: %d errors, %d warnings\n", len(store.Models), len(validationErrors), warnings)
+	if len(validationErrors) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/amscotti/portus/internal/capability"
+	"github.com/amscotti/portus/internal/config"
+	"github.com/amscotti/portus/internal/doctor"
+	"github.com/amscotti/portus/internal/remoteconfig"
+	"github.com/amscotti/portus/internal/tlsreload"
+)
+
+// doctorTimeout bounds the gateway check.
+const doctorTimeout = 10 * time.Second
+
+// runDoctor implements "portus doctor": it checks the environment, config
+// directory, configuration, gateway reachability and TLS trust, clock skew
+// and listener, printing a fix for each problem found. It accepts the
+// server's flags and exits non-zero when any check fails.
+func runDoctor(args []string, stdout, stderr io.Writer) int {
+	if err := config.ParseFlags(args, stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	settings := make([]string, len(config.Settings))
+	for i, s := range config.Settings {
+		settings[i] = s.Env
+	}
+	findings := doctor.Environment(config.UnknownSettings(), settings)
+	remote := remoteconfig.IsRemote(config.ConfigPath())
+	if !remote {
+		findings = append(findings, doctor.ConfigDir(config.ConfigPath())...)
+	}
+
+	store, err := config.LoadConfig()
+	if err != nil {
+		findings = append(findings, doctor.Finding{Check: "configuration", Status: doctor.StatusFail, Message: err.Error()})
+		return printFindings(stdout, findings)
+	}
+	// A remote config path is checked once it has been downloaded
+	if remote {
+		findings = append(findings, doctor.ConfigDir(store.ConfigPath)...)
+	}
+
+	validationErrors := config.ValidateConfig(store)
+	for _, err := range validationErrors {
+		findings = append(findings, doctor.Finding{Check: "configuration", Status: doctor.StatusFail, Message: err.Error()})
+	}
+	if len(validationErrors) == 0 {
+		findings = append(findings, doctor.Finding{Check: "configuration", Status: doctor.StatusOK,
+			Message: fmt.Sprintf("%d aliases and %d keys loaded", len(store.Models), len(store.ProxyKeys))})
+	}
+	for _, name := range config.OrphanedSettings(store) {
+		findings = append(findings, doctor.Finding{Check: "environment", Status: doctor.StatusWarn,
+			Message: name + " names an application without a key and is ignored",
+			Fix:     "match the application name of a PORTUS_KEY_* variable"})
+	}
+	now := time.Now()
+	for _, pk := range store.ProxyKeys {
+		if pk.Expired(now) {
+			findings = append(findings, doctor.Finding{Check: "keys", Status: doctor.StatusWarn,
+				Message: "key for " + pk.Application + " expired on " + pk.ExpiresAt.Format(time.DateOnly),
+				Fix:     "issue a new key and remove the expired one"})
+		}
+	}
+	for _, alias := range store.ModelAliases() {
+		model, _ := store.Model(alias)
+		for _, w := range capability.Check(model) {
+			findings = append(findings, doctor.Finding{Check: "model " + alias, Status: doctor.StatusWarn, Message: w,
+				Fix: "adjust the alias's settings for its provider"})
+		}
+	}
+
+	// Reach the gateway the way the server would, including mutual TLS
+	if store.MockMode != "" {
+		findings = append(findings, doctor.Finding{Check: "gateway", Status: doctor.StatusOK, Message: "skipped in mock mode"})
+	} else {
+		transport := &http.Transport{}
+		if store.GatewayTLSCert != "" || store.GatewayTLSCA != "" {
+			reloader, err := tlsreload.New(store.GatewayTLSCert, store.GatewayTLSKey, store.GatewayTLSCA, slog.New(slog.DiscardHandler))
+			if err != nil {
+				findings = append(findings, doctor.Finding{Check: "gateway TLS", Status: doctor.StatusFail, Message: err.Error(),
+					Fix: "check PORTUS_GATEWAY_TLS_CERT, PORTUS_GATEWAY_TLS_KEY and PORTUS_GATEWAY_TLS_CA name readable PEM files"})
+			} else {
+				transport.TLSClientConfig = reloader.ClientConfig()
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+		for _, gatewayURL := range store.GatewayURLs() {
+			findings = append(findings, doctor.Gateway(ctx, &http.Client{Transport: transport}, gatewayURL)...)
+		}
+		cancel()
+	}
+
+	if store.TLSCert != "" {
+		findings = append(findings, doctor.Certificate(store.TLSCert, store.TLSKey, now))
+	}
+	findings = append(findings, doctor.Port("listener", fmt.Sprintf(":%d", store.ServerPort)))
+	if store.AdminAddr != "" {
+		findings = append(findings, doctor.Port("admin listener", store.AdminAddr))
+	}
+	return printFindings(stdout, findings)
+}
+
+// printFindings prints a line per finding, with its fix beneath, and a
+// summary. It returns the exit status: 1 when any check failed.
+func printFindings(stdout io.Writer, findings []doctor.Finding) int {
+	warnings, failures := 0, 0
+	for _, f := range findings {
+		fmt.Fprintf(stdout, "%-4s  %s: %s\n", f.Status, f.Check, f.Message)
+		if f.Status != doctor.StatusOK && f.Fix != "" {
+			fmt.Fprintf(stdout, "      fix: %s\n", f.Fix)
+		}
+		switch f.Status {
+		case doctor.StatusWarn:
+			warnings++
+		case doctor.StatusFail:
+			failures++
+		}
+	}
+	fmt.Fprintf(stdout, "%d checks: %d warnings, %d failures\n", len(findings), warnings, failures)
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
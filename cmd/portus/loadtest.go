@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/amscotti/portus/internal/config"
+	"github.com/amscotti/portus/internal/handlers"
+	"github.com/amscotti/portus/internal/loadtest"
+)
+
+// runLoadTest implements "portus loadtest": it loads the configuration as
+// the server would and sends the traffic mix described in a profile file
+// straight to the gateway, then prints latency and error statistics per
+// alias. The profile file comes first, followed by any of the server's
+// flags. It exits non-zero when the configuration or profile is invalid or
+// any request fails.
+func runLoadTest(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(stderr, "Usage: portus loadtest PROFILE_FILE [server flags]")
+		return 2
+	}
+	if err := config.ParseFlags(args[1:], stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	profile, err := loadtest.Load(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "loadtest: %v\n", err)
+		return 1
+	}
+	store, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "loadtest: %v\n", err)
+		return 1
+	}
+	if errs := config.ValidateConfig(store); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(stderr, "loadtest: %v\n", err)
+		}
+		return 1
+	}
+	for _, alias := range profile.AliasNames() {
+		if _, ok := store.Model(alias); !ok {
+			fmt.Fprintf(stderr, "loadtest: unknown model alias: %s\n", alias)
+			return 1
+		}
+	}
+	if store.MockMode != "" {
+		fmt.Fprintln(stderr, "loadtest: mock mode is set, but generated traffic always goes to the gateway")
+	}
+
+	// Interrupting stops new requests and still prints the report
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report := loadtest.Run(ctx, profile, handlers.LoadRequest(store), func(r loadtest.Result) {
+		if r.Err != nil {
+			fmt.Fprintf(stderr, "loadtest: %s: %v\n", r.Alias, r.Err)
+		}
+	})
+
+	report.Write(stdout, profile.AliasNames())
+	if report.Failed() > 0 {
+		return 1
+	}
+	return 0
+}
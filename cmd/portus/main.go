@@ -3,25 +3,106 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/amscotti/portus/internal/acmecert"
+	"github.com/amscotti/portus/internal/admin"
+	"github.com/amscotti/portus/internal/apiversion"
+	"github.com/amscotti/portus/internal/breaker"
+	"github.com/amscotti/portus/internal/budget"
+	"github.com/amscotti/portus/internal/canary"
+	"github.com/amscotti/portus/internal/capability"
+	"github.com/amscotti/portus/internal/concurrency"
 	"github.com/amscotti/portus/internal/config"
+	"github.com/amscotti/portus/internal/controlplane"
+	"github.com/amscotti/portus/internal/conversation"
+	"github.com/amscotti/portus/internal/credguard"
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/freeze"
 	"github.com/amscotti/portus/internal/handlers"
+	"github.com/amscotti/portus/internal/health"
+	"github.com/amscotti/portus/internal/jobs"
+	"github.com/amscotti/portus/internal/loglevel"
 	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/mock"
+	"github.com/amscotti/portus/internal/modellist"
 	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/otlp"
+	"github.com/amscotti/portus/internal/pii"
+	"github.com/amscotti/portus/internal/privacy"
+	"github.com/amscotti/portus/internal/progress"
+	"github.com/amscotti/portus/internal/provenance"
+	"github.com/amscotti/portus/internal/quota"
+	"github.com/amscotti/portus/internal/recording"
+	"github.com/amscotti/portus/internal/redact"
+	"github.com/amscotti/portus/internal/redis"
+	"github.com/amscotti/portus/internal/report"
+	"github.com/amscotti/portus/internal/semcache"
+	"github.com/amscotti/portus/internal/statsd"
+	"github.com/amscotti/portus/internal/storage"
+	"github.com/amscotti/portus/internal/streamlimit"
+	"github.com/amscotti/portus/internal/tlsreload"
+	"github.com/amscotti/portus/internal/usage"
+	"github.com/amscotti/portus/internal/usagestore"
+	"github.com/amscotti/portus/internal/webhook"
 )
 
 func main() {
-	// Setup structured logging
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: getLogLevel(),
-	}))
+	// "portus replay" re-sends recorded requests instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	// "portus check" reports config problems without serving
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	// "portus verify" runs the aliases' declared test cases against the gateway
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	// "portus doctor" diagnoses setup problems and suggests fixes
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	// "portus loadtest" sends a synthetic traffic mix to the gateway
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		os.Exit(runLoadTest(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	// "portus migrate-config" upgrades an old config directory into a new one
+	if len(os.Args) > 1 && os.Args[1] == "migrate-config" {
+		os.Exit(runMigrateConfig(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	// Flags override environment variables, which may be namespaced by PORTUS_ENV_PREFIX
+	if err := config.ParseFlags(os.Args[1:], os.Stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+
+	// Setup structured logging with secret redaction
+	// The level can be changed at runtime through signals or the admin API
+	redactor := redact.NewRedactor(strings.Split(config.Getenv("PORTUS_LOG_REDACT_KEYS"), ",")...)
+	levels := loglevel.New(getLogLevel())
+	console := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: levels,
+	})
+	logger := slog.New(redact.NewHandler(console, redactor))
+	levels.SetLogger(logger)
 
 	logger.Info("starting Portus", "version", models.Version)
 
@@ -48,47 +129,707 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Settings the provider is likely to reject are reported but not fatal
+	for _, alias := range store.ModelAliases() {
+		model, _ := store.Model(alias)
+		for _, warning := range capability.Check(model) {
+			logger.Warn("model config may fail at request time", "model_alias", alias, "warning", warning)
+		}
+	}
+
+	registerSecrets(redactor, store)
+
+	// Export logs and metrics to an OpenTelemetry collector
+	var otlpExporter *otlp.Exporter
+	var otlpLogs *otlp.LogHandler
+	if store.OTLPEndpoint != "" {
+		// Export failures go to the console only, so they cannot feed back
+		otlpExporter = otlp.NewExporter(store.OTLPEndpoint, store.OTLPHeaders, store.OTLPServiceName, logger)
+		if store.OTLPLogs {
+			otlpLogs = otlp.NewLogHandler(otlpExporter, levels)
+			logger = slog.New(redact.NewHandler(otlp.Tee(console, otlpLogs), redactor))
+			levels.SetLogger(logger)
+		}
+		logger.Info("exporting to OpenTelemetry collector", "endpoint", store.OTLPEndpoint, "logs", store.OTLPLogs, "metrics", store.OTLPMetrics)
+	}
+
+	// Send request metrics to a StatsD or DogStatsD agent
+	var statsdClient *statsd.Client
+	if store.StatsDAddr != "" {
+		statsdClient, err = statsd.New(store.StatsDAddr, store.StatsDPrefix, store.StatsDFormat)
+		if err != nil {
+			logger.Error("failed to configure StatsD", "address", store.StatsDAddr, "error", err)
+			os.Exit(1)
+		}
+		defer statsdClient.Close()
+		logger.Info("sending metrics to StatsD", "address", store.StatsDAddr, "format", store.StatsDFormat)
+	}
+
 	logger.Info("configuration loaded successfully",
 		"models", len(store.Models),
 		"proxy_keys", len(store.ProxyKeys),
 		"port", store.ServerPort,
 	)
 
+	// Runtime subsystems shared across proxy handlers
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	// SIGUSR1 enables debug logging for a while; SIGUSR2 restores the level
+	go levels.HandleSignals(ctx, store.LogLevelTimeout)
+
+	// TLS to the gateway, with client certificates reloaded on rotation
+	var gatewayTLS *tls.Config
+	if store.GatewayTLSCert != "" || store.GatewayTLSCA != "" {
+		reloader, err := tlsreload.New(store.GatewayTLSCert, store.GatewayTLSKey, store.GatewayTLSCA, logger)
+		if err != nil {
+			logger.Error("failed to load gateway TLS credentials", "error", err)
+			os.Exit(1)
+		}
+		gatewayTLS = reloader.ClientConfig()
+		handlers.SetGatewayTLSConfig(gatewayTLS)
+		go reloader.Run(ctx, store.TLSReloadInterval)
+		logger.Info("gateway TLS configured", "mutual_tls", store.GatewayTLSCert != "")
+	}
+	if store.GatewayH2C {
+		handlers.UseGatewayH2C()
+	}
+
+	// Mock mode answers requests locally so clients can be tested without keys
+	if store.MockMode != "" {
+		handlers.UseMockGateway(mock.Transport(mock.New(store.MockMode, store.MockResponse)))
+		logger.Warn("mock mode enabled, the gateway is not contacted", "mode", store.MockMode)
+	}
+
+	// Optional persistent per-request usage records
+	var records *usagestore.Store
+	if store.UsageDBPath != "" {
+		records, err = usagestore.Open(store.UsageDBPath, logger)
+		if err != nil {
+			logger.Error("failed to open usage database", "error", err, "path", store.UsageDBPath)
+			os.Exit(1)
+		}
+		logger.Info("persisting usage records", "path", store.UsageDBPath)
+	}
+
+	var redisClient *redis.Client
+	if store.RedisURL != "" {
+		client, err := redis.NewClient(store.RedisURL)
+		if err != nil {
+			logger.Error("invalid Redis configuration", "error", err)
+			os.Exit(1)
+		}
+		defer client.Close()
+		redisClient = client
+	}
+
+	// Budgets, request quotas, stream limits, usage totals, circuit state and
+	// the semantic cache live in the PORTUS_STORAGE backend
+	state, err := storage.Open(store.Storage, store.StoragePath, redisClient)
+	if err != nil {
+		logger.Error("failed to open state storage", "error", err, "backend", store.Storage)
+		os.Exit(1)
+	}
+	defer state.Close()
+	if store.Storage != storage.KindMemory {
+		logger.Info("keeping state in shared storage", "backend", store.Storage)
+	}
+
+	// Stream limits count in process unless leases are kept in a shared
+	// backend, where a crashed replica's slots expire
+	streams := streamlimit.New()
+	if store.Storage != storage.KindMemory {
+		streams = streamlimit.NewShared(state, store.RedisStreamLease, logger)
+		logger.Info("sharing stream limits through storage", "backend", store.Storage, "lease", store.RedisStreamLease)
+	}
+
+	// Semantic cache for aliases that opt in; the in-memory store is bounded
+	// by entry count, shared ones only by TTL
+	var cache *semcache.Cache
+	if store.SemanticCacheAlias != "" {
+		var entries semcache.Store = semcache.NewMemory(store.SemanticCacheMaxEntries, store.SemanticCacheTTL)
+		if store.Storage != storage.KindMemory {
+			entries = semcache.NewShared(state, store.SemanticCacheTTL)
+		}
+		cache = semcache.New(
+			handlers.SemanticCacheEmbedder(store, store.SemanticCacheAlias),
+			entries,
+			store.SemanticCacheThreshold,
+		)
+		logger.Info("semantic cache enabled", "embedding_alias", store.SemanticCacheAlias, "threshold", store.SemanticCacheThreshold)
+	}
+
+	// Optional recording of sanitized exchanges for "portus replay"
+	record := func(h http.Handler) http.Handler { return h }
+	if store.RecordFile != "" {
+		recorder, err := recording.Open(store.RecordFile, redactor, logger)
+		if err != nil {
+			logger.Error("failed to open recording file", "error", err, "path", store.RecordFile)
+			os.Exit(1)
+		}
+		defer recorder.Close()
+		record = recorder.Middleware()
+		logger.Warn("recording requests and responses", "path", store.RecordFile)
+	}
+
+	// Optional PII scanning of request content, per application
+	piiFilter := func(h http.Handler) http.Handler { return h }
+	if piiEnabled(store) {
+		scanner, err := pii.NewScanner(store.PIIPatterns, store.PIICustomPatterns)
+		if err != nil {
+			logger.Error("invalid PII patterns", "error", err)
+			os.Exit(1)
+		}
+		piiFilter = pii.NewPolicy(scanner, store.PIIAction, store.PIIApplicationActions).Middleware(logger)
+		logger.Info("scanning request content for PII", "action", store.PIIAction, "applications", store.PIIApplicationActions)
+	}
+
+	svc := &handlers.Services{
+		Usage:       usage.NewTrackerWithBackend(state),
+		History:     usage.NewHistory(store.UsageRetention),
+		Records:     records,
+		Progress:    progress.NewMonitor(store.StreamProgressInterval, logger),
+		Streams:     streams,
+		Report:      report.NewRecorder(),
+		Cache:       cache,
+		Privacy:     privacy.New(store.MetricsMode, store.MetricsAppBuckets, store.MetricsMinCount),
+		Breaker:     breaker.NewWithBackend(state, store.CircuitBreakerThreshold, store.CircuitBreakerCooldown, logger),
+		Catalog:     modellist.New(handlers.ProviderModels(store), store.ModelListTTL, logger),
+		Concurrency: concurrency.New(),
+		Quotas:      quota.NewWithBackend(state, logger),
+		// Created even without caps so keys pushed later can set them
+		Conversations: conversation.NewWithBackend(state, store.ConversationIdleTimeout, logger),
+		StatsD:        statsdClient,
+	}
+	if svc.Privacy.Aggregate() {
+		logger.Info("usage metrics in aggregate-only mode", "app_buckets", store.MetricsAppBuckets, "min_count", store.MetricsMinCount)
+	}
+	go svc.Progress.Run(ctx)
+	if store.StreamMaxAge > 0 {
+		go svc.Progress.Reap(ctx, store.StreamMaxAge, store.StreamProgressInterval)
+		logger.Info("reaping streams exceeding maximum age", "max_age", store.StreamMaxAge.String())
+	}
+	go svc.History.Run(ctx, time.Minute)
+
+	// Signed, retried webhook deliveries of job, alert and guardrail events
+	webhooks, err := webhook.New(webhook.Config{
+		Global:         store.Webhook,
+		Applications:   store.ApplicationWebhooks,
+		MaxAttempts:    store.WebhookMaxAttempts,
+		Backoff:        store.WebhookRetryBackoff,
+		DeadLetterFile: store.WebhookDeadLetterFile,
+	}, logger)
+	if err != nil {
+		logger.Error("failed to start webhook dispatcher", "error", err)
+		os.Exit(1)
+	}
+	if len(store.Webhook.URLs) > 0 || len(store.ApplicationWebhooks) > 0 {
+		logger.Info("webhooks enabled", "urls", len(store.Webhook.URLs), "applications", len(store.ApplicationWebhooks))
+	}
+
+	// Asynchronous jobs for requests sent with ?async=1
+	jobManager := jobs.New(state, store.JobsTTL, store.JobsMaxActive, store.JobsWebhookHosts, webhooks, logger)
+	go jobManager.Run(ctx, 5*time.Second)
+	if otlpLogs != nil {
+		go otlpLogs.Run(ctx, 5*time.Second)
+	}
+	if otlpExporter != nil && store.OTLPMetrics {
+		svc.Latency = otlp.NewLatency()
+		go otlpExporter.RunMetrics(ctx, store.OTLPMetricInterval, func() otlp.Metrics {
+			return otlp.Metrics{
+				Totals:        svc.Privacy.Totals(svc.Usage.Snapshot("")),
+				ActiveStreams: svc.Report.ActiveStreams(),
+				Latency:       svc.Latency,
+			}
+		})
+	}
+
+	if time.Now().Before(store.LimitsGraceUntil) {
+		logger.Warn("stream and concurrency limits are log-only during the grace period", "until", store.LimitsGraceUntil)
+	}
+
+	// Accepted proxy keys; the control plane can replace them at runtime.
+	// Keys disabled through the admin API are shared through storage.
+	keyring := middleware.NewKeyring(store.ProxyKeys)
+	if store.Storage != storage.KindMemory {
+		keyring, err = middleware.NewSharedKeyring(store.ProxyKeys, state, logger)
+		if err != nil {
+			logger.Error("failed to load key state", "error", err)
+			os.Exit(1)
+		}
+		go keyring.Run(ctx, 5*time.Second)
+	}
+	plane := controlplane.New(store, keyring)
+
+	// Sign responses with the configuration version in force
+	if store.ProvenanceKey != "" {
+		svc.Provenance = provenance.NewSigner([]byte(store.ProvenanceKey), plane.Version)
+		logger.Info("signing responses with provenance header")
+	}
+
+	// Admin changes are streamed to operators subscribed to /admin/events
+	// and sent to webhooks
+	hub := events.NewHub()
+	svc.Events = hub
+	go webhooks.Run(ctx, hub)
+
+	// Read-only mode refuses runtime configuration changes while serving traffic
+	readOnly := freeze.New(store.ReadOnly)
+	if store.ReadOnly {
+		logger.Warn("read-only mode enabled, runtime configuration changes are refused until restart")
+	}
+	writable := middleware.RequireWritable(readOnly, logger)
+
+	// Aliases whose upstream keeps rejecting their credentials fail fast,
+	// alerting on the event stream
+	svc.Credentials = credguard.New(store.CredentialFailureThreshold, store.CredentialFailureProbeInterval, store.CredentialFailureHideModels, hub, logger)
+	if svc.Credentials != nil {
+		logger.Info("degrading aliases on credential failures", "threshold", store.CredentialFailureThreshold, "probe_interval", store.CredentialFailureProbeInterval.String(), "hide_models", store.CredentialFailureHideModels)
+	}
+
+	// Per-application spend budgets, alerting on the event stream
+	if len(store.Budgets) > 0 {
+		svc.Budgets = budget.NewWithBackend(state, hub, logger)
+		logger.Info("spend budgets enabled", "applications", len(store.Budgets))
+	}
+
+	// Warn about proxy keys approaching expiry, at startup and hourly
+	middleware.WarnExpiringKeys(keyring.Keys(), time.Now(), store.KeyExpiryWarning, logger)
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				middleware.WarnExpiringKeys(keyring.Keys(), now, store.KeyExpiryWarning, logger)
+			}
+		}
+	}()
+
+	// Secret references are fetched again so rotated credentials take effect
+	if store.SecretsRefreshInterval > 0 && len(config.SecretValues()) > 0 {
+		go func() {
+			ticker := time.NewTicker(store.SecretsRefreshInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if readOnly.Frozen() {
+						logger.Debug("read-only mode, skipping secret refresh")
+						continue
+					}
+					updated, err := config.RefreshSecrets(ctx, store)
+					// Register new values before anything can log them
+					redactor.AddSecrets(config.SecretValues()...)
+					if err != nil {
+						logger.Error("failed to refresh secrets, keeping previous values", "error", err)
+					}
+					if updated {
+						logger.Info("secrets rotated, model configs reloaded")
+					}
+				}
+			}
+		}()
+	}
+
+	// A remote config path is watched (Consul, etcd) or polled (S3, GCS) so
+	// every instance picks up edits to the shared config
+	if store.RemoteConfigURL != "" && store.RemoteConfigRefreshInterval > 0 {
+		logger.Info("watching remote config", "url", store.RemoteConfigURL, "interval", store.RemoteConfigRefreshInterval.String())
+		go func() {
+			for {
+				if err := config.WaitRemoteConfig(ctx, store.RemoteConfigRefreshInterval); err != nil && ctx.Err() == nil {
+					logger.Warn("failed to watch remote config, retrying", "url", store.RemoteConfigURL, "error", err)
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				if readOnly.Frozen() {
+					logger.Debug("read-only mode, skipping remote config refresh")
+					continue
+				}
+				updated, err := config.RefreshRemoteConfig(ctx, store)
+				if err != nil {
+					logger.Error("failed to refresh remote config, keeping previous models", "url", store.RemoteConfigURL, "error", err)
+				}
+				if !updated {
+					continue
+				}
+				registerSecrets(redactor, store)
+				logger.Info("remote config changed, model configs reloaded", "url", store.RemoteConfigURL, "aliases", len(store.ModelAliases()))
+				reloadProxyKeys(store, keyring, redactor, logger.With("url", store.RemoteConfigURL))
+			}
+		}()
+	}
+
+	// A local config directory is polled so edits, including Kubernetes
+	// swapping the files of a mounted ConfigMap or Secret, take effect
+	if store.ConfigWatchInterval > 0 {
+		logger.Info("watching config directory", "path", store.ConfigPath, "interval", store.ConfigWatchInterval.String())
+		go func() {
+			ticker := time.NewTicker(store.ConfigWatchInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				if readOnly.Frozen() {
+					logger.Debug("read-only mode, skipping config directory refresh")
+					continue
+				}
+				updated, err := config.RefreshConfigDir(store)
+				if err != nil {
+					logger.Error("failed to reload config directory, keeping previous models", "path", store.ConfigPath, "error", err)
+				}
+				if !updated {
+					continue
+				}
+				registerSecrets(redactor, store)
+				logger.Info("config directory changed, model configs reloaded", "path", store.ConfigPath, "aliases", len(store.ModelAliases()))
+				reloadProxyKeys(store, keyring, redactor, logger.With("path", store.ConfigPath))
+			}
+		}()
+	}
+
+	// Synthetic canaries
+	var canaries *canary.Runner
+	if store.CanaryInterval > 0 {
+		canaryAliases := store.ModelAliases
+		if len(store.CanaryAliases) > 0 {
+			canaryAliases = func() []string { return store.CanaryAliases }
+		}
+		canaries = canary.NewRunner(handlers.CanaryProbe(store), canaryAliases, store.CanaryInterval, 30*time.Second, logger)
+		go canaries.Run(ctx)
+		logger.Info("synthetic canaries enabled", "interval", store.CanaryInterval.String())
+	}
+
 	// Setup HTTP router
 	mux := http.NewServeMux()
 
+	// Health, stats, pprof and admin endpoints get their own listener when
+	// one is configured, so they are never reachable through the proxy port
+	opsMux := mux
+	if store.AdminAddr != "" {
+		opsMux = http.NewServeMux()
+	}
+
 	// Health endpoint (no auth required)
-	mux.HandleFunc("/health", handlers.HealthHandler(store))
+	opsMux.HandleFunc("/health", handlers.HealthHandler(store))
+
+	// Kubernetes-style probes (no auth required)
+	var draining atomic.Bool
+	configLoaded := func() bool { return !config.ModelsStale() }
+
+	livez := health.NewRegistry("livez")
+	livez.Register("ping", func(ctx context.Context) error { return nil })
+
+	startupz := health.NewRegistry("startupz")
+	startupz.Register("config-loaded", health.FlagCheck(configLoaded, "changed config failed to load, serving previous models"))
+
+	readyz := health.NewRegistry("readyz")
+	readyz.Register("config-loaded", health.FlagCheck(configLoaded, "changed config failed to load, serving previous models"))
+	if store.MockMode == "" {
+		gatewayClient := &http.Client{
+			Timeout:   2 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: gatewayTLS},
+		}
+		readyz.Register("gateway", health.GatewayCheck(gatewayClient, store.GatewayURL, 5*time.Second))
+		// Routed gateways are checked under their own names
+		for _, route := range store.GatewayRoutes {
+			readyz.Register("gateway "+route.Pattern, health.GatewayCheck(gatewayClient, route.URL, 5*time.Second))
+		}
+	}
+	readyz.Register("drain", health.FlagCheck(func() bool { return !draining.Load() }, "server is draining"))
+
+	opsMux.HandleFunc("/livez", livez.Handler())
+	opsMux.HandleFunc("/readyz", readyz.Handler())
+	opsMux.HandleFunc("/startupz", startupz.Handler())
 
 	// Protected endpoints
-	authMiddleware := middleware.AuthMiddleware(store.ProxyKeys, logger)
+	authMiddleware := middleware.AuthMiddleware(keyring, hub, logger)
 	requestIDMiddleware := middleware.RequestIDMiddleware()
+	inferenceOnly := middleware.RequireScope(logger, models.ScopeInference)
+	statsAccess := middleware.RequireScope(logger, models.ScopeInference, models.ScopeObservability)
+	adminOnly := middleware.RequireScope(logger, models.ScopeAdmin)
+	apiVersion := apiversion.Negotiate(store.APIVersion, logger)
+	async := jobManager.Middleware()
 
 	// Models endpoint
 	mux.Handle("/v1/models", chain(
-		handlers.ModelsHandler(store),
+		handlers.ModelsHandler(store, svc),
 		authMiddleware,
+		inferenceOnly,
+		apiVersion,
 		requestIDMiddleware,
 	))
 
 	// Chat completions endpoint
 	mux.Handle("/v1/chat/completions", chain(
-		handlers.ChatCompletionsHandler(store, logger),
+		handlers.ChatCompletionsHandler(store, svc, logger),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+		piiFilter,
+		async,
+		record,
+	))
+
+	// OpenAI Responses API endpoint
+	mux.Handle("/v1/responses", chain(
+		handlers.ResponsesHandler(store, svc, logger),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+		piiFilter,
+		async,
+		record,
+	))
+
+	// Legacy text completions endpoint
+	mux.Handle("/v1/completions", chain(
+		handlers.CompletionsHandler(store, svc, logger),
 		authMiddleware,
+		inferenceOnly,
+		apiVersion,
 		requestIDMiddleware,
+		piiFilter,
+		async,
+		record,
 	))
 
 	// Anthropic messages endpoint
 	mux.Handle("/v1/messages", chain(
-		handlers.MessagesHandler(store, logger),
+		handlers.MessagesHandler(store, svc, logger),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+		piiFilter,
+		async,
+		record,
+	))
+
+	// Embeddings endpoint
+	mux.Handle("/v1/embeddings", chain(
+		handlers.EmbeddingsHandler(store, svc, logger),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+		piiFilter,
+		record,
+	))
+
+	// Moderations endpoint
+	mux.Handle("/v1/moderations", chain(
+		handlers.ModerationsHandler(store, svc, logger),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+		piiFilter,
+		record,
+	))
+
+	// Image generation endpoint
+	mux.Handle("/v1/images/generations", chain(
+		handlers.ImageGenerationsHandler(store, svc, logger),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+		piiFilter,
+		async,
+		record,
+	))
+
+	// Audio endpoints
+	mux.Handle("/v1/audio/transcriptions", chain(
+		handlers.AudioTranscriptionsHandler(store, svc, logger),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+	))
+	mux.Handle("/v1/audio/translations", chain(
+		handlers.AudioTranslationsHandler(store, svc, logger),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+	))
+	mux.Handle("/v1/audio/speech", chain(
+		handlers.AudioSpeechHandler(store, svc, logger),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+		piiFilter,
+		record,
+	))
+
+	// Caller limits and remaining quota
+	mux.Handle("/v1/limits", chain(
+		handlers.LimitsHandler(store, svc),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+	))
+
+	// Asynchronous job results
+	jobsHandler := chain(
+		jobManager.Handler(),
+		authMiddleware,
+		inferenceOnly,
+		apiVersion,
+		requestIDMiddleware,
+	)
+	mux.Handle("/v1/jobs", jobsHandler)
+	mux.Handle("/v1/jobs/", jobsHandler)
+
+	// Later API versions are served by the /v1 handlers. v2 has no behavior
+	// of its own yet, so it only differs in the X-Portus-API-Version header
+	mux.Handle("/v2/", apiversion.Prefix(apiversion.V2, mux))
+
+	// Credential debugging endpoint
+	mux.Handle("/debug/whoami", chain(
+		handlers.WhoamiHandler(store, svc),
+		authMiddleware,
+		statsAccess,
+		requestIDMiddleware,
+	))
+
+	// Usage statistics endpoint
+	opsMux.Handle("/stats", chain(
+		handlers.StatsHandler(svc),
+		authMiddleware,
+		statsAccess,
+		requestIDMiddleware,
+	))
+
+	// Admin API
+	opsMux.Handle("/admin/models", chain(
+		admin.ModelsHandler(store, hub, logger),
+		authMiddleware,
+		adminOnly,
+		writable,
+		requestIDMiddleware,
+	))
+
+	opsMux.Handle("/admin/keys", chain(
+		admin.KeysHandler(keyring, hub, logger),
+		authMiddleware,
+		adminOnly,
+		writable,
+		requestIDMiddleware,
+	))
+
+	opsMux.Handle("/admin/events", chain(
+		admin.EventsHandler(hub),
+		authMiddleware,
+		adminOnly,
+		requestIDMiddleware,
+	))
+
+	opsMux.Handle("/admin/log-level", chain(
+		admin.LogLevelHandler(levels, store.LogLevelTimeout),
+		authMiddleware,
+		adminOnly,
+		requestIDMiddleware,
+	))
+
+	opsMux.Handle("/admin/freeze", chain(
+		admin.FreezeHandler(readOnly, hub, logger),
+		authMiddleware,
+		adminOnly,
+		requestIDMiddleware,
+	))
+
+	// Published JSON Schemas of the admin API request bodies
+	opsMux.Handle("/admin/schemas", chain(
+		admin.SchemasHandler(),
+		authMiddleware,
+		adminOnly,
+		requestIDMiddleware,
+	))
+
+	opsMux.Handle("/admin/canaries", chain(
+		admin.CanariesHandler(canaries),
+		authMiddleware,
+		adminOnly,
+		requestIDMiddleware,
+	))
+
+	opsMux.Handle("/admin/degraded", chain(
+		admin.DegradedHandler(svc.Credentials),
 		authMiddleware,
+		adminOnly,
+		requestIDMiddleware,
+	))
+
+	// Profiles expose the command line and memory, where keys live, so they
+	// need an admin key and are only served on the admin listener
+	if store.AdminAddr != "" {
+		for path, profile := range map[string]http.HandlerFunc{
+			"/debug/pprof/":        pprof.Index,
+			"/debug/pprof/cmdline": pprof.Cmdline,
+			"/debug/pprof/profile": pprof.Profile,
+			"/debug/pprof/symbol":  pprof.Symbol,
+			"/debug/pprof/trace":   pprof.Trace,
+		} {
+			opsMux.Handle(path, chain(
+				profile,
+				authMiddleware,
+				adminOnly,
+				requestIDMiddleware,
+			))
+		}
+	}
+
+	// Control plane: fleet managers push and roll back config bundles
+	opsMux.Handle("/admin/config", chain(
+		admin.ConfigHandler(plane, hub, logger),
+		authMiddleware,
+		adminOnly,
+		writable,
+		requestIDMiddleware,
+	))
+	opsMux.Handle("/admin/config/rollback", chain(
+		admin.ConfigRollbackHandler(plane, hub, logger),
+		authMiddleware,
+		adminOnly,
+		writable,
 		requestIDMiddleware,
 	))
 
 	// Apply global middleware
 	handler := middleware.RecoverMiddleware(logger)(
-		middleware.LoggingMiddleware(logger)(mux),
+		middleware.LoggingMiddleware(logger)(
+			middleware.GzipMiddleware(store.GzipResponses)(mux),
+		),
 	)
+	var adminServer *http.Server
+	if store.AdminAddr != "" {
+		adminServer = &http.Server{
+			Addr: store.AdminAddr,
+			Handler: middleware.RecoverMiddleware(logger)(
+				middleware.LoggingMiddleware(logger)(
+					middleware.GzipMiddleware(store.GzipResponses)(opsMux),
+				),
+			),
+			ReadTimeout: 30 * time.Second,
+			IdleTimeout: 120 * time.Second,
+		}
+	}
 
 	// Create HTTP server
 	server := &http.Server{
@@ -98,33 +839,165 @@ func main() {
 		WriteTimeout: 60 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
+	// HTTP/2 is negotiated over TLS; h2c serves it on plaintext to clients
+	// with prior knowledge
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	server.Protocols.SetUnencryptedHTTP2(store.H2C)
+
+	// Terminate TLS natively when a certificate is configured or obtained
+	// over ACME
+	if store.TLSCert != "" {
+		reloader, err := tlsreload.New(store.TLSCert, store.TLSKey, "", logger)
+		if err != nil {
+			logger.Error("failed to load TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = reloader.ServerConfig()
+		go reloader.Run(ctx, store.TLSReloadInterval)
+	} else if len(store.ACMEDomains) > 0 {
+		server.TLSConfig, err = acmecert.ServerConfig(store.ACMEDomains, store.ACMEEmail, store.ACMECacheDir, store.ACMEDirectoryURL, logger)
+		if err != nil {
+			logger.Error("failed to set up ACME certificates", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("obtaining TLS certificates over ACME", "domains", store.ACMEDomains)
+	}
+
+	// Fleet managers may also push bundles over gRPC, with the proxy's TLS
+	var grpcServer *controlplane.GRPCServer
+	var grpcListener net.Listener
+	if store.ControlPlaneGRPCAddr != "" {
+		grpcServer, err = controlplane.NewGRPCServer(plane, keyring, readOnly, hub, server.TLSConfig, logger)
+		if err != nil {
+			logger.Error("failed to create gRPC control plane", "error", err)
+			os.Exit(1)
+		}
+		grpcListener, err = net.Listen("tcp", store.ControlPlaneGRPCAddr)
+		if err != nil {
+			logger.Error("failed to listen for gRPC control plane", "error", err, "addr", store.ControlPlaneGRPCAddr)
+			os.Exit(1)
+		}
+	}
 
 	// Start server in a goroutine
 	go func() {
-		logger.Info("server listening", "addr", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("server listening", "addr", server.Addr, "tls", server.TLSConfig != nil)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("server failed", "error", err)
 			os.Exit(1)
 		}
 	}()
+	if adminServer != nil {
+		go func() {
+			logger.Info("admin server listening", "addr", adminServer.Addr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("admin server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if grpcServer != nil {
+		go func() {
+			logger.Info("gRPC control plane listening", "addr", grpcListener.Addr().String(), "tls", server.TLSConfig != nil)
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				logger.Error("gRPC control plane failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	// Wait for shutdown signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
+	// Fail readiness first so load balancers stop routing new traffic
+	draining.Store(true)
+	if store.ShutdownDrainDelay > 0 {
+		logger.Info("draining before shutdown", "delay", store.ShutdownDrainDelay.String())
+		time.Sleep(store.ShutdownDrainDelay)
+	}
+
 	logger.Info("shutting down server...")
 
 	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
-		logger.Error("server shutdown error", "error", err)
+	streamsAtShutdown := svc.Report.ActiveStreams()
+	var forcedClosed int64
+	shutdownErr := server.Shutdown(shutdownCtx)
+	if shutdownErr != nil {
+		logger.Error("server shutdown error", "error", shutdownErr)
+		forcedClosed = svc.Report.ActiveStreams()
+		server.Close()
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("admin server shutdown error", "error", err)
+			adminServer.Close()
+		}
+	}
+	if grpcServer != nil {
+		grpcServer.Stop(shutdownCtx)
+	}
+
+	// Flush queued usage records once no more requests can arrive
+	if err := svc.Records.Close(); err != nil {
+		logger.Error("failed to close usage database", "error", err)
+	}
+
+	// Give queued webhook deliveries a moment; the rest are dead-lettered
+	webhookCtx, cancelWebhooks := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := webhooks.Close(webhookCtx); err != nil {
+		logger.Error("failed to close webhook dead-letter file", "error", err)
+	}
+	cancelWebhooks()
+
+	// Summarize the process lifetime for batch deployments
+	summary := svc.Report.Report(store.StartTime, time.Now(), streamsAtShutdown, forcedClosed)
+	logger.Info("shutdown report", summary.LogAttrs()...)
+	if store.ReportDir != "" {
+		if path, err := report.Write(store.ReportDir, summary); err != nil {
+			logger.Error("failed to write shutdown report", "error", err, "dir", store.ReportDir)
+		} else {
+			logger.Info("shutdown report written", "path", path)
+		}
+	}
+
+	if shutdownErr == nil {
+		logger.Info("server stopped")
+	}
+	if otlpLogs != nil {
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+		otlpLogs.Flush(flushCtx)
+		cancelFlush()
+	}
+	if shutdownErr != nil {
 		os.Exit(1)
 	}
+}
 
-	logger.Info("server stopped")
+// piiEnabled reports whether any application's requests are scanned for PII.
+func piiEnabled(store *models.ConfigStore) bool {
+	if store.PIIAction != pii.ActionOff {
+		return true
+	}
+	for _, action := range store.PIIApplicationActions {
+		if action != pii.ActionOff {
+			return true
+		}
+	}
+	return false
 }
 
 // chain applies middleware to a handler in reverse order.
@@ -135,9 +1008,51 @@ func chain(h http.Handler, middleware ...func(http.Handler) http.Handler) http.H
 	return h
 }
 
+// reloadProxyKeys replaces the active proxy keys with those in the config
+// path's key files after it changed. When the reload fails, or there are no
+// key files, the current keys stay in place.
+func reloadProxyKeys(store *models.ConfigStore, keyring *middleware.Keyring, redactor *redact.Redactor, logger *slog.Logger) {
+	keys, err := config.ReloadProxyKeys(store)
+	if err != nil {
+		logger.Error("failed to reload proxy keys, keeping previous keys", "error", err)
+		return
+	}
+	if keys == nil {
+		return
+	}
+	// Register new keys before anything can log them
+	for _, pk := range keys {
+		redactor.AddSecrets(pk.Key)
+	}
+	keyring.Replace(keys)
+	logger.Info("proxy keys reloaded", "keys", len(keys))
+}
+
+// registerSecrets adds every configured credential to the log redactor so exact
+// values are scrubbed even when they don't match a known key pattern.
+func registerSecrets(redactor *redact.Redactor, store *models.ConfigStore) {
+	for _, pk := range store.ProxyKeys {
+		redactor.AddSecrets(pk.Key)
+	}
+	for _, value := range store.OTLPHeaders {
+		redactor.AddSecrets(value)
+	}
+	redactor.AddSecrets(store.ProvenanceKey, store.Webhook.Secret)
+	for _, target := range store.ApplicationWebhooks {
+		redactor.AddSecrets(target.Secret)
+	}
+	redactor.AddSecrets(config.SecretValues()...)
+	for _, model := range store.Models {
+		redactor.AddSecrets(model.APIKey, model.AWSSecretAccessKey, model.AWSSessionToken)
+		for _, target := range model.Targets {
+			redactor.AddSecrets(target.APIKey, target.AWSSecretAccessKey, target.AWSSessionToken)
+		}
+	}
+}
+
 // getLogLevel returns the configured log level.
 func getLogLevel() slog.Level {
-	level := os.Getenv("PORTUS_LOG_LEVEL")
+	level := config.Getenv("PORTUS_LOG_LEVEL")
 	switch level {
 	case "debug":
 		return slog.LevelDebug
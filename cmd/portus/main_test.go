@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// smokeChildEnv marks the re-executed test binary that runs the real server.
+const smokeChildEnv = "PORTUS_SMOKE_CHILD"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(smokeChildEnv) == "1" {
+		os.Args = []string{"portus"}
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func waitHealthy(t *testing.T, url string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("server at %s never became healthy", url)
+}
+
+// TestServerWiring boots the real main() in mock mode and checks that the
+// proxy, auth, admin listener and profiling routes are wired together.
+func TestServerWiring(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a server process")
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "models"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	model := `{"provider":"openai","api_key":"sk-test"}`
+	if err := os.WriteFile(filepath.Join(dir, "models", "gpt.json"), []byte(model), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	port, adminPort := freePort(t), freePort(t)
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		smokeChildEnv+"=1",
+		"PORTUS_CONFIG_PATH="+dir,
+		"PORTUS_KEY_BACKEND=pk-backend",
+		"PORTUS_ADMIN_KEY_OPS=admin-ops",
+		"PORTUS_MOCK_MODE=echo",
+		fmt.Sprintf("PORTUS_PORT=%d", port),
+		fmt.Sprintf("PORTUS_ADMIN_ADDR=127.0.0.1:%d", adminPort),
+	)
+	var logs strings.Builder
+	cmd.Stdout = &logs
+	cmd.Stderr = &logs
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start server: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	exited := false
+	defer func() {
+		if !exited {
+			cmd.Process.Kill()
+			<-done
+		}
+		if t.Failed() {
+			t.Logf("server output:\n%s", logs.String())
+		}
+	}()
+
+	base := fmt.Sprintf("http://127.0.0.1:%d", port)
+	admin := fmt.Sprintf("http://127.0.0.1:%d", adminPort)
+	waitHealthy(t, admin+"/health")
+
+	do := func(t *testing.T, method, url, key, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	chat := `{"model":"gpt","messages":[{"role":"user","content":"hello"}]}`
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		key        string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"proxy with key", http.MethodPost, base + "/v1/chat/completions", "pk-backend", chat, http.StatusOK, "hello"},
+		{"proxy without key", http.MethodPost, base + "/v1/chat/completions", "", chat, http.StatusUnauthorized, ""},
+		{"proxy with unknown key", http.MethodPost, base + "/v1/chat/completions", "pk-nope", chat, http.StatusUnauthorized, ""},
+		{"pprof without key", http.MethodGet, admin + "/debug/pprof/", "", "", http.StatusUnauthorized, ""},
+		{"pprof with inference key", http.MethodGet, admin + "/debug/pprof/", "pk-backend", "", http.StatusForbidden, ""},
+		{"pprof with admin key", http.MethodGet, admin + "/debug/pprof/", "admin-ops", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := do(t, tt.method, tt.url, tt.key, tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", status, tt.wantStatus, body)
+			}
+			if tt.wantBody != "" && !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("signal: %v", err)
+	}
+	select {
+	case err := <-done:
+		exited = true
+		if err != nil {
+			t.Fatalf("server exited with %v", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("server did not shut down after SIGTERM")
+	}
+	if !strings.Contains(logs.String(), "server stopped") {
+		t.Errorf("missing shutdown log, got:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/amscotti/portus/internal/migrate"
+)
+
+// runMigrateConfig implements "portus migrate-config": it upgrades a
+// configuration directory written for an older layout, renaming deprecated
+// fields and splitting combined files, and writes the result to a new
+// directory. The source is never modified. It prints each change and a diff
+// of every changed file, and exits non-zero when the source cannot be
+// migrated.
+func runMigrateConfig(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("portus migrate-config", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("out", "", "directory to write the migrated configuration to; it must not exist")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: portus migrate-config -out NEW_CONFIG_DIR CONFIG_DIR")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 1 || *out == "" {
+		fs.Usage()
+		return 2
+	}
+
+	files, err := migrate.Dir(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "migrate-config: %v\n", err)
+		return 1
+	}
+
+	// Refuse to merge into or overwrite an existing directory
+	if _, err := os.Stat(*out); err == nil {
+		fmt.Fprintf(stderr, "migrate-config: %s already exists\n", *out)
+		return 1
+	}
+	for _, file := range files {
+		path := filepath.Join(*out, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			fmt.Fprintf(stderr, "migrate-config: %v\n", err)
+			return 1
+		}
+		if err := os.WriteFile(path, file.New, file.Mode); err != nil {
+			fmt.Fprintf(stderr, "migrate-config: %v\n", err)
+			return 1
+		}
+	}
+
+	changed := 0
+	for _, file := range files {
+		if !file.Changed() {
+			continue
+		}
+		changed++
+		fmt.Fprintf(stdout, "%s:\n", file.Path)
+		for _, change := range file.Changes {
+			fmt.Fprintf(stdout, "  %s\n", change)
+		}
+	}
+	for _, file := range files {
+		fmt.Fprint(stdout, migrate.Diff(file))
+	}
+	fmt.Fprintf(stdout, "migrated %d files to %s: %d changed\n", len(files), *out, changed)
+	if changed > 0 {
+		fmt.Fprintf(stdout, "run \"portus check --config-path %s\" to validate the result\n", *out)
+	}
+	return 0
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/amscotti/portus/internal/recording"
+	"github.com/amscotti/portus/internal/redact"
+)
+
+// runReplay implements "portus replay": it re-sends the requests in a
+// recording file to a Portus instance and reports how each response compares
+// with the recorded one. With -from, the requests and their order are taken
+// from a Portus access log instead, with bodies looked up in the recording by
+// request ID. With -speed, requests are sent at their original pacing, scaled
+// by the factor. It exits non-zero when a request fails or its status
+// changes.
+func runReplay(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("portus replay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	target := fs.String("target", "http://localhost:8080", "Portus instance to replay against")
+	key := fs.String("key", os.Getenv("PORTUS_REPLAY_KEY"), "proxy key sent with every request (default $PORTUS_REPLAY_KEY)")
+	out := fs.String("out", "", "write the replayed exchanges to this recording file")
+	timeout := fs.Duration("timeout", 5*time.Minute, "timeout for each replayed request")
+	from := fs.String("from", "", "Portus access log selecting the requests to replay, in order")
+	speed := fs.Float64("speed", 0, "replay at the original pacing scaled by this factor (0 sends requests back to back)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: portus replay [flags] RECORDING_FILE")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 1 || *speed < 0 {
+		fs.Usage()
+		return 2
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %v\n", err)
+		return 1
+	}
+	exchanges, err := recording.Read(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+
+	// Each request is sent at its offset from the first one
+	type job struct {
+		exchange recording.Exchange
+		at       time.Time
+	}
+	var jobs []job
+	if *from == "" {
+		for _, ex := range exchanges {
+			jobs = append(jobs, job{exchange: ex, at: ex.Time})
+		}
+	} else {
+		file, err := os.Open(*from)
+		if err != nil {
+			fmt.Fprintf(stderr, "replay: %v\n", err)
+			return 1
+		}
+		entries, err := recording.ReadAccessLog(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(stderr, "replay: %s: %v\n", *from, err)
+			return 1
+		}
+		byID := make(map[string]recording.Exchange, len(exchanges))
+		for _, ex := range exchanges {
+			if ex.RequestID != "" {
+				byID[ex.RequestID] = ex
+			}
+		}
+		missing := 0
+		for _, entry := range entries {
+			ex, ok := byID[entry.RequestID]
+			if !ok {
+				missing++
+				continue
+			}
+			jobs = append(jobs, job{exchange: ex, at: entry.Start})
+		}
+		if missing > 0 {
+			fmt.Fprintf(stderr, "replay: %d logged requests have no recorded body and are skipped\n", missing)
+		}
+	}
+
+	var results *recording.Recorder
+	if *out != "" {
+		results, err = recording.Open(*out, redact.NewRedactor(), slog.New(slog.NewTextHandler(stderr, nil)))
+		if err != nil {
+			fmt.Fprintf(stderr, "replay: %v\n", err)
+			return 1
+		}
+		defer results.Close()
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	var mu sync.Mutex
+	var failed, changed int
+	replay := func(i int, recorded recording.Exchange) {
+		replayed, err := recording.Replay(context.Background(), client, *target, *key, recorded)
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(stdout, "#%d %s %s: ", i+1, recorded.Method, recorded.Path)
+		if err != nil {
+			failed++
+			fmt.Fprintf(stdout, "error: %v\n", err)
+			return
+		}
+		if replayed.Status != recorded.Status {
+			changed++
+		}
+		fmt.Fprintf(stdout, "status %d -> %d, model %q -> %q, %dms -> %dms\n",
+			recorded.Status, replayed.Status, recorded.Model(), replayed.Model(), recorded.DurationMS, replayed.DurationMS)
+		if results != nil {
+			if err := results.Record(replayed); err != nil {
+				fmt.Fprintf(stderr, "replay: %v\n", err)
+			}
+		}
+	}
+
+	if *speed == 0 {
+		for i, j := range jobs {
+			replay(i, j.exchange)
+		}
+	} else {
+		// Requests overlap as they did originally
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i, j := range jobs {
+			offset := time.Duration(float64(j.at.Sub(jobs[0].at)) / *speed)
+			time.Sleep(time.Until(start.Add(offset)))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				replay(i, j.exchange)
+			}()
+		}
+		wg.Wait()
+	}
+
+	fmt.Fprintf(stdout, "replayed %d exchanges: %d status changes, %d errors\n", len(jobs), changed, failed)
+	if failed > 0 || changed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/amscotti/portus/internal/config"
+	"github.com/amscotti/portus/internal/handlers"
+	"github.com/amscotti/portus/internal/verify"
+)
+
+// verifyTimeout bounds each test request.
+const verifyTimeout = 2 * time.Minute
+
+// runVerify implements "portus verify": it loads the configuration as the
+// server would and sends each alias's declared test cases straight to the
+// gateway, printing a line per case. It accepts the server's flags and exits
+// non-zero when the configuration is invalid or any case fails.
+func runVerify(args []string, stdout, stderr io.Writer) int {
+	if err := config.ParseFlags(args, stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	store, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "verify: %v\n", err)
+		return 1
+	}
+	if errs := config.ValidateConfig(store); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(stderr, "verify: %v\n", err)
+		}
+		return 1
+	}
+	if store.MockMode != "" {
+		fmt.Fprintln(stderr, "verify: mock mode is set, but test cases always go to the gateway")
+	}
+
+	send := handlers.VerifyRequest(store)
+	passed, failed := 0, 0
+	for _, alias := range store.ModelAliases() {
+		model, _ := store.Model(alias)
+		for i, test := range model.Tests {
+			name := alias + "/" + verify.Name(test, i)
+			ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+			start := time.Now()
+			status, content, err := send(ctx, alias, test.Prompt)
+			latency := time.Since(start)
+			cancel()
+
+			var failures []string
+			switch {
+			case err != nil:
+				failures = []string{err.Error()}
+			case status != http.StatusOK:
+				failures = []string{fmt.Sprintf("status %d: %s", status, content)}
+			default:
+				failures = verify.Check(test, content, latency)
+			}
+			if len(failures) == 0 {
+				passed++
+				fmt.Fprintf(stdout, "PASS %s (%dms)\n", name, latency.Milliseconds())
+				continue
+			}
+			failed++
+			fmt.Fprintf(stdout, "FAIL %s (%dms)\n", name, latency.Milliseconds())
+			for _, f := range failures {
+				fmt.Fprintf(stdout, "     %s\n", f)
+			}
+		}
+	}
+
+	fmt.Fprintf(stdout, "verified %d tests: %d passed, %d failed\n", passed+failed, passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,17 @@
+// Package acmecert obtains and renews the listener's certificate from an ACME
+// certificate authority such as Let's Encrypt.
+package acmecert
+
+import (
+	"crypto/tls"
+	"log/slog"
+)
+
+// ServerConfig returns a listener TLS config that obtains a certificate for
+// each of domains on first use, caches it in cacheDir and renews it before it
+// expires. Challenges are answered with TLS-ALPN-01 on the listener itself.
+// An empty directoryURL uses Let's Encrypt. Binaries built without the acme
+// tag return an error.
+func ServerConfig(domains []string, email, cacheDir, directoryURL string, logger *slog.Logger) (*tls.Config, error) {
+	return serverConfig(domains, email, cacheDir, directoryURL, logger)
+}
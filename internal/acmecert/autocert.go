@@ -0,0 +1,43 @@
+//go:build acme
+
+package acmecert
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func serverConfig(domains []string, email, cacheDir, directoryURL string, logger *slog.Logger) (*tls.Config, error) {
+	// The cache holds the account key and private keys
+	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+		return nil, fmt.Errorf("create ACME cache directory: %w", err)
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	if directoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: directoryURL}
+	}
+
+	config := m.TLSConfig()
+	getCertificate := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := getCertificate(hello)
+		// Other names are refused by the host policy; only failures for
+		// configured domains are worth an operator's attention
+		if err != nil && slices.Contains(domains, hello.ServerName) {
+			logger.Warn("failed to obtain ACME certificate", "domain", hello.ServerName, "error", err)
+		}
+		return cert, err
+	}
+	return config, nil
+}
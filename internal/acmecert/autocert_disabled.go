@@ -0,0 +1,13 @@
+//go:build !acme
+
+package acmecert
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+)
+
+func serverConfig(domains []string, email, cacheDir, directoryURL string, logger *slog.Logger) (*tls.Config, error) {
+	return nil, errors.New("this binary was built without ACME support; rebuild with -tags acme")
+}
@@ -0,0 +1,37 @@
+//go:build acme
+
+package acmecert
+
+import (
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestServerConfig(t *testing.T) {
+	t.Parallel()
+
+	cacheDir := filepath.Join(t.TempDir(), "acme")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	config, err := ServerConfig([]string{"portus.example.com"}, "ops@example.com", cacheDir, "", logger)
+	if err != nil {
+		t.Fatalf("ServerConfig() error: %v", err)
+	}
+	if info, err := os.Stat(cacheDir); err != nil || !info.IsDir() {
+		t.Errorf("expected cache directory to be created, got %v", err)
+	}
+	if !slices.Contains(config.NextProtos, acme.ALPNProto) {
+		t.Errorf("expected %s in NextProtos for TLS-ALPN-01 challenges, got %v", acme.ALPNProto, config.NextProtos)
+	}
+
+	// Names outside the domain list are refused before contacting the CA
+	if _, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Error("expected a certificate for an unlisted name to be refused")
+	}
+}
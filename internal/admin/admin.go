@@ -0,0 +1,542 @@
+// Package admin implements the operator-facing admin API.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/amscotti/portus/internal/canary"
+	"github.com/amscotti/portus/internal/config"
+	"github.com/amscotti/portus/internal/controlplane"
+	"github.com/amscotti/portus/internal/credguard"
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/freeze"
+	"github.com/amscotti/portus/internal/loglevel"
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/verify"
+)
+
+// maxPayloadSize limits admin API request bodies.
+const maxPayloadSize = 1024 * 1024 // 1 MB
+
+// maxBundleSize limits pushed config bundles, which carry every alias.
+const maxBundleSize = 10 * 1024 * 1024 // 10 MB
+
+// eventKeepAlive is how often an idle event stream sends a comment so proxies
+// do not close it.
+const eventKeepAlive = 30 * time.Second
+
+// ModelSummary describes a model alias without exposing credentials.
+type ModelSummary struct {
+	Alias          string   `json:"alias"`
+	Provider       string   `json:"provider"`
+	Strategy       string   `json:"strategy,omitempty"`
+	Targets        int      `json:"targets,omitempty"`
+	RequestTimeout int      `json:"request_timeout,omitempty"`
+	RetryAttempts  int      `json:"retry_attempts,omitempty"`
+	Match          []string `json:"match,omitempty"`
+}
+
+// KeySummary describes a proxy key without exposing the key itself.
+type KeySummary struct {
+	ID          string          `json:"id"`
+	Application string          `json:"application"`
+	Scope       models.KeyScope `json:"scope"`
+	MaxStreams  int             `json:"max_streams,omitempty"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+	// RequestQuota is written as "limit/window", e.g. "10000/day".
+	RequestQuota       string            `json:"request_quota,omitempty"`
+	ConversationTokens int               `json:"conversation_tokens,omitempty"`
+	APIVersion         string            `json:"api_version,omitempty"`
+	ExpiresAt          *time.Time        `json:"expires_at,omitempty"`
+	Disabled           bool              `json:"disabled,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+}
+
+// PatchKeyRequest is the body of PATCH /admin/keys.
+type PatchKeyRequest struct {
+	ID       string `json:"id"`
+	Disabled bool   `json:"disabled"`
+}
+
+// PatchModelsRequest is the body of PATCH /admin/models.
+type PatchModelsRequest struct {
+	Selector config.ModelSelector `json:"selector"`
+	// Patch is an RFC 7386 JSON merge patch applied to each matching config file.
+	Patch  map[string]interface{} `json:"patch"`
+	DryRun bool                   `json:"dry_run,omitempty"`
+}
+
+// PatchModelsResponse reports which aliases a patch was applied to.
+type PatchModelsResponse struct {
+	Updated []string `json:"updated"`
+	DryRun  bool     `json:"dry_run"`
+}
+
+// writeJSON writes a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeState writes a resource's state with its ETag, for use in If-Match.
+func writeState(w http.ResponseWriter, state any) {
+	w.Header().Set("ETag", etag(state))
+	writeJSON(w, http.StatusOK, state)
+}
+
+// writeJSONError writes a JSON-formatted error response with proper escaping.
+func writeJSONError(w http.ResponseWriter, msg string, code int) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// ModelsHandler returns the admin models endpoint handler. GET lists aliases
+// and PATCH applies a merge patch to every alias matching a selector. The
+// ETag covers every alias's full config.
+func ModelsHandler(store *models.ConfigStore, hub *events.Hub, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("ETag", etag(store.ModelsSnapshot()))
+			listModels(w, store)
+		case http.MethodPatch:
+			patchModels(w, r, store, hub, logger)
+		default:
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// CanariesHandler returns the admin endpoint reporting synthetic canary results.
+func CanariesHandler(runner *canary.Runner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"canaries": runner.Snapshot()})
+	}
+}
+
+// DegradedHandler returns the admin endpoint reporting aliases degraded by
+// upstream credential failures.
+func DegradedHandler(guard *credguard.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		degraded := guard.Snapshot()
+		if degraded == nil {
+			degraded = []credguard.Status{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"degraded": degraded})
+	}
+}
+
+// LogLevelRequest temporarily changes the log level.
+type LogLevelRequest struct {
+	Level string `json:"level"`
+	// Duration is how long the level lasts, e.g. "10m"; empty uses the
+	// server's PORTUS_LOG_LEVEL_TIMEOUT.
+	Duration string `json:"duration,omitempty"`
+}
+
+// LogLevelHandler returns the admin endpoint that reports (GET), temporarily
+// changes (PUT) and resets (DELETE) the log level.
+func LogLevelHandler(levels *loglevel.Controller, defaultTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		operator, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		source := "admin:" + operator
+
+		switch r.Method {
+		case http.MethodGet:
+			writeState(w, levels.Status())
+		case http.MethodPut:
+			var req LogLevelRequest
+			if !decodePayload(w, r, "PUT /admin/log-level", maxPayloadSize, false, &req) {
+				return
+			}
+			level, err := loglevel.ParseLevel(req.Level)
+			if err != nil {
+				writeFieldErrors(w, []verify.FieldError{{Field: "level", Message: fmt.Sprintf("invalid log level %q", req.Level)}})
+				return
+			}
+			timeout := defaultTimeout
+			if req.Duration != "" {
+				timeout, err = time.ParseDuration(req.Duration)
+				if err != nil || timeout <= 0 {
+					writeFieldErrors(w, []verify.FieldError{{Field: "duration", Message: fmt.Sprintf("invalid duration %q", req.Duration)}})
+					return
+				}
+			}
+			writes.Lock()
+			defer writes.Unlock()
+			if ifMatch(w, r, etag(levels.Status())) {
+				writeState(w, levels.Set(level, timeout, source))
+			}
+		case http.MethodDelete:
+			writes.Lock()
+			defer writes.Unlock()
+			if ifMatch(w, r, etag(levels.Status())) {
+				writeState(w, levels.Reset(source))
+			}
+		default:
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// FreezeRequest is the body of PUT /admin/freeze.
+type FreezeRequest struct {
+	// Reason is recorded with the freeze, e.g. a change-freeze ticket.
+	Reason string `json:"reason,omitempty"`
+}
+
+// FreezeHandler returns the admin endpoint that reports (GET), switches on
+// (PUT) and lifts (DELETE) read-only mode. A freeze set by PORTUS_READ_ONLY
+// cannot be lifted here.
+func FreezeHandler(readOnly *freeze.Switch, hub *events.Hub, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		operator, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+
+		switch r.Method {
+		case http.MethodGet:
+			writeState(w, readOnly.Status())
+		case http.MethodPut:
+			var req FreezeRequest
+			if !decodePayload(w, r, "PUT /admin/freeze", maxPayloadSize, true, &req) {
+				return
+			}
+			writes.Lock()
+			defer writes.Unlock()
+			if !ifMatch(w, r, etag(readOnly.Status())) {
+				return
+			}
+			status := readOnly.Freeze(req.Reason, "admin:"+operator)
+			logger.Warn("configuration frozen", "operator", operator, "reason", req.Reason)
+			hub.Publish(events.ConfigFrozen, operator, map[string]any{"reason": req.Reason})
+			writeState(w, status)
+		case http.MethodDelete:
+			writes.Lock()
+			defer writes.Unlock()
+			if !ifMatch(w, r, etag(readOnly.Status())) {
+				return
+			}
+			status, err := readOnly.Unfreeze()
+			if err != nil {
+				writeJSONError(w, err.Error(), http.StatusConflict)
+				return
+			}
+			logger.Warn("configuration unfrozen", "operator", operator)
+			hub.Publish(events.ConfigUnfrozen, operator, nil)
+			writeState(w, status)
+		default:
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// KeysHandler returns the admin keys endpoint handler. GET lists the accepted
+// proxy keys, without their values, and PATCH disables or re-enables one by ID.
+func KeysHandler(keyring *middleware.Keyring, hub *events.Hub, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			summaries := keySummaries(keyring)
+			w.Header().Set("ETag", etag(summaries))
+			writeJSON(w, http.StatusOK, map[string]interface{}{"keys": summaries})
+		case http.MethodPatch:
+			patchKey(w, r, keyring, hub, logger)
+		default:
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func patchKey(w http.ResponseWriter, r *http.Request, keyring *middleware.Keyring, hub *events.Hub, logger *slog.Logger) {
+	var req PatchKeyRequest
+	if !decodePayload(w, r, "PATCH /admin/keys", maxPayloadSize, false, &req) {
+		return
+	}
+
+	// Disabling the calling key would lock the operator out mid-incident
+	operator, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+	caller, _ := r.Context().Value(middleware.ContextKeyProxyKey).(models.ProxyKey)
+	if req.Disabled && caller.Key != "" && caller.ID() == req.ID {
+		writeJSONError(w, "Cannot disable the key used for this request", http.StatusConflict)
+		return
+	}
+
+	writes.Lock()
+	defer writes.Unlock()
+	if !ifMatch(w, r, etag(keySummaries(keyring))) {
+		return
+	}
+	key, ok, err := keyring.SetDisabled(req.ID, req.Disabled)
+	if err != nil {
+		logger.Error("failed to store key override", "operator", operator, "key_id", req.ID, "error", err)
+		writeJSONError(w, "Failed to store key state", http.StatusServiceUnavailable)
+		return
+	}
+	if !ok {
+		writeJSONError(w, "Unknown key ID", http.StatusNotFound)
+		return
+	}
+
+	eventType, message := events.KeyEnabled, "admin enabled proxy key"
+	if req.Disabled {
+		eventType, message = events.KeyDisabled, "admin disabled proxy key"
+	}
+	logger.Warn(message, "operator", operator, "key_id", req.ID, "application", key.Application)
+	hub.Publish(eventType, operator, map[string]any{"key_id": req.ID, "application": key.Application})
+	w.Header().Set("ETag", etag(keySummaries(keyring)))
+	writeJSON(w, http.StatusOK, keySummary(key))
+}
+
+// keySummaries describes every key in the keyring.
+func keySummaries(keyring *middleware.Keyring) []KeySummary {
+	keys := keyring.Keys()
+	summaries := make([]KeySummary, 0, len(keys))
+	for _, key := range keys {
+		summaries = append(summaries, keySummary(key))
+	}
+	return summaries
+}
+
+func keySummary(key models.ProxyKey) KeySummary {
+	summary := KeySummary{ID: key.ID(), Application: key.Application, Scope: key.Scope, MaxStreams: key.MaxStreams, MaxTokens: key.MaxTokens, RequestQuota: key.RequestQuota.String(), ConversationTokens: key.ConversationTokens, APIVersion: key.APIVersion, Disabled: key.Disabled, Tags: key.Tags}
+	if summary.Scope == "" {
+		summary.Scope = models.ScopeInference
+	}
+	if !key.ExpiresAt.IsZero() {
+		expiresAt := key.ExpiresAt
+		summary.ExpiresAt = &expiresAt
+	}
+	return summary
+}
+
+// EventsHandler returns the admin endpoint streaming configuration change
+// events as server-sent events until the client disconnects.
+func EventsHandler(hub *events.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The stream outlives the server's write timeout
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+
+		ch, unsubscribe := hub.Subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+
+		keepAlive := time.NewTicker(eventKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case ev := <-ch:
+				data, err := json.Marshal(ev)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ConfigHandler returns the control plane endpoint. GET reports the active
+// bundle version and rollback history; POST pushes a new bundle, validating
+// only when the dry_run query parameter is true.
+func ConfigHandler(plane *controlplane.Plane, hub *events.Hub, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeState(w, plane.Status())
+		case http.MethodPost:
+			pushConfig(w, r, plane, hub, logger)
+		default:
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// ConfigRollbackHandler returns the endpoint that reactivates the previous bundle.
+func ConfigRollbackHandler(plane *controlplane.Plane, hub *events.Hub, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+
+		writes.Lock()
+		defer writes.Unlock()
+		if !ifMatch(w, r, etag(plane.Status())) {
+			return
+		}
+		version, err := plane.Rollback()
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logger.Info("config bundle rolled back", "operator", operator, "version", version)
+		hub.Publish(events.ConfigRolledBack, operator, map[string]any{"version": version})
+		writeState(w, plane.Status())
+	}
+}
+
+func pushConfig(w http.ResponseWriter, r *http.Request, plane *controlplane.Plane, hub *events.Hub, logger *slog.Logger) {
+	data, ok := readPayload(w, r, maxBundleSize)
+	if !ok {
+		return
+	}
+	// The published schema describes the supported versions; other versions
+	// go on to be refused with the list, so managers can negotiate
+	var bundle controlplane.Bundle
+	var version struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if json.Unmarshal(data, &version) == nil && !slices.Contains(controlplane.SupportedSchemaVersions, version.SchemaVersion) {
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	} else if !validatePayload(w, "POST /admin/config", data, &bundle) {
+		return
+	}
+
+	operator, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	writes.Lock()
+	defer writes.Unlock()
+	if !ifMatch(w, r, etag(plane.Status())) {
+		return
+	}
+
+	if err := plane.Apply(bundle, dryRun); err != nil {
+		logger.Warn("config bundle rejected",
+			"operator", operator,
+			"version", bundle.Version,
+			"schema_version", bundle.SchemaVersion,
+			"error", err,
+		)
+		var schemaErr *controlplane.SchemaError
+		if errors.As(err, &schemaErr) {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":                     err.Error(),
+				"supported_schema_versions": controlplane.SupportedSchemaVersions,
+			})
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	logger.Info("config bundle applied",
+		"operator", operator,
+		"version", bundle.Version,
+		"models", len(bundle.Models),
+		"keys_updated", bundle.Keys != nil,
+		"dry_run", dryRun,
+	)
+	if !dryRun {
+		hub.Publish(events.ConfigApplied, operator, map[string]any{
+			"version":      bundle.Version,
+			"models":       len(bundle.Models),
+			"keys_updated": bundle.Keys != nil,
+		})
+	}
+	writeState(w, plane.Status())
+}
+
+func listModels(w http.ResponseWriter, store *models.ConfigStore) {
+	aliases := store.ModelAliases()
+	summaries := make([]ModelSummary, 0, len(aliases))
+	for _, alias := range aliases {
+		model, _ := store.Model(alias)
+		summary := ModelSummary{
+			Alias:          alias,
+			Provider:       model.Provider,
+			Targets:        len(model.Targets),
+			RequestTimeout: model.RequestTimeout,
+			Match:          model.Match,
+		}
+		if model.Strategy != nil {
+			summary.Strategy = model.Strategy.Mode
+			if summary.Provider == "" && len(model.Targets) > 0 {
+				summary.Provider = model.Targets[0].Provider
+			}
+		}
+		if model.Retry != nil {
+			summary.RetryAttempts = model.Retry.Attempts
+		}
+		summaries = append(summaries, summary)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"models": summaries})
+}
+
+func patchModels(w http.ResponseWriter, r *http.Request, store *models.ConfigStore, hub *events.Hub, logger *slog.Logger) {
+	var req PatchModelsRequest
+	if !decodePayload(w, r, "PATCH /admin/models", maxPayloadSize, false, &req) {
+		return
+	}
+
+	operator, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+
+	writes.Lock()
+	defer writes.Unlock()
+	if !ifMatch(w, r, etag(store.ModelsSnapshot())) {
+		return
+	}
+
+	updated, err := config.PatchModels(store, req.Selector, req.Patch, req.DryRun)
+	if err != nil {
+		logger.Warn("admin model patch rejected",
+			"operator", operator,
+			"error", err,
+		)
+		writeJSONError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	fields := make([]string, 0, len(req.Patch))
+	for field := range req.Patch {
+		fields = append(fields, field)
+	}
+	logger.Info("admin model patch applied",
+		"operator", operator,
+		"aliases", updated,
+		"fields", fields,
+		"dry_run", req.DryRun,
+	)
+	if !req.DryRun {
+		hub.Publish(events.ModelsPatched, operator, map[string]any{"aliases": updated, "fields": fields})
+	}
+
+	w.Header().Set("ETag", etag(store.ModelsSnapshot()))
+	writeJSON(w, http.StatusOK, PatchModelsResponse{Updated: updated, DryRun: req.DryRun})
+}
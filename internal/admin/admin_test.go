@@ -0,0 +1,460 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/controlplane"
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/freeze"
+	"github.com/amscotti/portus/internal/loglevel"
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/models"
+)
+
+func TestModelsHandler_PatchAndList(t *testing.T) {
+	dir := t.TempDir()
+	modelsDir := filepath.Join(dir, "models")
+	if err := os.MkdirAll(modelsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(modelsDir, "gpt4.json"), []byte(`{"provider": "openai", "api_key": "sk-secret"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-secret"}},
+		ConfigPath: dir,
+	}
+	hub := events.NewHub()
+	changes, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	handler := ModelsHandler(store, hub, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	body := `{"selector": {"aliases": ["gpt4"]}, "patch": {"retry": {"attempts": 4}}}`
+	req := httptest.NewRequest(http.MethodPatch, "/admin/models", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var patchResp PatchModelsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &patchResp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(patchResp.Updated) != 1 || patchResp.Updated[0] != "gpt4" {
+		t.Errorf("expected gpt4 to be updated, got %v", patchResp.Updated)
+	}
+	if ev := <-changes; ev.Type != events.ModelsPatched {
+		t.Errorf("expected a models.patched event, got %+v", ev)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/models", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if strings.Contains(rec.Body.String(), "sk-secret") {
+		t.Error("model listing must not expose credentials")
+	}
+	if !strings.Contains(rec.Body.String(), `"retry_attempts":4`) {
+		t.Errorf("expected patched retry attempts in listing, got %s", rec.Body.String())
+	}
+}
+
+func TestModelsHandler_PatchRejected(t *testing.T) {
+	t.Parallel()
+
+	store := &models.ConfigStore{Models: map[string]models.ModelConfig{}}
+	handler := ModelsHandler(store, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	req := httptest.NewRequest(http.MethodPatch, "/admin/models", strings.NewReader(`{"patch": {"request_timeout": 1}}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for empty selector, got %d", rec.Code)
+	}
+}
+
+func TestConfigHandler_SchemaNegotiation(t *testing.T) {
+	t.Parallel()
+
+	store := &models.ConfigStore{Models: map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-x"}}}
+	plane := controlplane.New(store, middleware.NewKeyring([]models.ProxyKey{{Key: "pk", Application: "web"}}))
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := ConfigHandler(plane, nil, logger)
+
+	body := `{"schema_version": 7, "version": "v1", "models": {"gpt4": {"provider": "openai", "api_key": "sk-x"}}}`
+	req := httptest.NewRequest(http.MethodPost, "/admin/config", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"supported_schema_versions":[1]`) {
+		t.Errorf("expected supported versions in response, got %s", rec.Body.String())
+	}
+
+	body = `{"schema_version": 1, "version": "v2", "models": {"mini": {"provider": "openai", "api_key": "sk-x"}}}`
+	req = httptest.NewRequest(http.MethodPost, "/admin/config", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var status controlplane.Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if rec.Code != http.StatusOK || status.Version != "v2" {
+		t.Fatalf("expected v2 to be applied, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rollback := ConfigRollbackHandler(plane, nil, logger)
+	rec = httptest.NewRecorder()
+	rollback.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/config/rollback", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"version":"local"`) {
+		t.Errorf("expected rollback to local, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestKeysHandler(t *testing.T) {
+	t.Parallel()
+
+	expires := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	keyring := middleware.NewKeyring([]models.ProxyKey{
+		{Key: "pk-backend-secret", Application: "BACKEND", MaxStreams: 4, ExpiresAt: expires},
+		{Key: "admin-secret", Application: "OPS", Scope: models.ScopeAdmin},
+	})
+
+	rec := httptest.NewRecorder()
+	KeysHandler(keyring, nil, slog.New(slog.NewTextHandler(io.Discard, nil))).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/keys", nil))
+
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Fatalf("key listing must not expose key values: %s", rec.Body.String())
+	}
+	var resp struct {
+		Keys []KeySummary `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(resp.Keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(resp.Keys))
+	}
+	for _, key := range resp.Keys {
+		switch key.Application {
+		case "BACKEND":
+			if key.Scope != models.ScopeInference || key.MaxStreams != 4 || key.ExpiresAt == nil || !key.ExpiresAt.Equal(expires) {
+				t.Errorf("unexpected BACKEND summary: %+v", key)
+			}
+		case "OPS":
+			if key.Scope != models.ScopeAdmin || key.ExpiresAt != nil {
+				t.Errorf("unexpected OPS summary: %+v", key)
+			}
+		}
+	}
+}
+
+func TestKeysHandler_Disable(t *testing.T) {
+	t.Parallel()
+
+	backend := models.ProxyKey{Key: "pk-backend", Application: "BACKEND"}
+	operator := models.ProxyKey{Key: "admin-key", Application: "OPS", Scope: models.ScopeAdmin}
+	keyring := middleware.NewKeyring([]models.ProxyKey{backend, operator})
+	hub := events.NewHub()
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	handler := KeysHandler(keyring, hub, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	patch := func(id string, disabled bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(PatchKeyRequest{ID: id, Disabled: disabled})
+		req := httptest.NewRequest(http.MethodPatch, "/admin/keys", bytes.NewReader(body))
+		ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "OPS")
+		ctx = context.WithValue(ctx, middleware.ContextKeyProxyKey, operator)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(ctx))
+		return rec
+	}
+
+	if rec := patch(backend.ID(), true); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"disabled":true`) {
+		t.Fatalf("expected key to be disabled, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ev := <-ch; ev.Type != events.KeyDisabled || ev.Operator != "OPS" || ev.Data["key_id"] != backend.ID() {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	// A config push must not revive the disabled key
+	keyring.Replace([]models.ProxyKey{backend, operator})
+	if pk, _ := keyring.Lookup("pk-backend"); !pk.Disabled {
+		t.Error("expected key to stay disabled after the keys were replaced")
+	}
+
+	if rec := patch(operator.ID(), true); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 when disabling the calling key, got %d", rec.Code)
+	}
+	if rec := patch("000000000000", true); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown key ID, got %d", rec.Code)
+	}
+
+	if rec := patch(backend.ID(), false); rec.Code != http.StatusOK {
+		t.Fatalf("expected key to be re-enabled, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if pk, _ := keyring.Lookup("pk-backend"); pk.Disabled {
+		t.Error("expected key to be enabled again")
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	t.Parallel()
+
+	hub := events.NewHub()
+	server := httptest.NewServer(EventsHandler(hub))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected event stream, got %q", ct)
+	}
+
+	// The subscription is registered before the headers are flushed
+	hub.Publish(events.ConfigApplied, "ops", map[string]any{"version": "v2"})
+
+	buf := make([]byte, 4096)
+	n, err := resp.Body.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	if !strings.HasPrefix(got, "id: 1\nevent: config.applied\ndata: ") || !strings.Contains(got, `"version":"v2"`) {
+		t.Errorf("unexpected event: %q", got)
+	}
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	t.Parallel()
+
+	levels := loglevel.New(slog.LevelInfo)
+	handler := LogLevelHandler(levels, 15*time.Minute)
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantLevel  slog.Level
+	}{
+		{name: "set debug", method: http.MethodPut, body: `{"level":"debug","duration":"5m"}`, wantStatus: http.StatusOK, wantLevel: slog.LevelDebug},
+		{name: "unknown level", method: http.MethodPut, body: `{"level":"verbose"}`, wantStatus: http.StatusBadRequest, wantLevel: slog.LevelDebug},
+		{name: "invalid duration", method: http.MethodPut, body: `{"level":"warn","duration":"-1m"}`, wantStatus: http.StatusBadRequest, wantLevel: slog.LevelDebug},
+		{name: "status", method: http.MethodGet, wantStatus: http.StatusOK, wantLevel: slog.LevelDebug},
+		{name: "reset", method: http.MethodDelete, wantStatus: http.StatusOK, wantLevel: slog.LevelInfo},
+		{name: "default duration", method: http.MethodPut, body: `{"level":"error"}`, wantStatus: http.StatusOK, wantLevel: slog.LevelError},
+		{name: "wrong method", method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed, wantLevel: slog.LevelError},
+	}
+
+	// Steps share the controller, so they run in order
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/admin/log-level", strings.NewReader(tt.body)))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.wantStatus, rec.Code, rec.Body.String())
+		}
+		if levels.Level() != tt.wantLevel {
+			t.Errorf("%s: expected level %v, got %v", tt.name, tt.wantLevel, levels.Level())
+		}
+	}
+
+	var status loglevel.Status
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/log-level", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Level != "error" || status.Base != "info" || status.RevertsAt == nil || time.Until(*status.RevertsAt) < 14*time.Minute {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestFreezeHandler(t *testing.T) {
+	t.Parallel()
+
+	readOnly := freeze.New(false)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := FreezeHandler(readOnly, events.NewHub(), logger)
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantFrozen bool
+	}{
+		{name: "status", method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "freeze", method: http.MethodPut, body: `{"reason":"incident 42"}`, wantStatus: http.StatusOK, wantFrozen: true},
+		{name: "invalid body", method: http.MethodPut, body: `{`, wantStatus: http.StatusBadRequest, wantFrozen: true},
+		{name: "unfreeze", method: http.MethodDelete, wantStatus: http.StatusOK},
+		{name: "freeze without a reason", method: http.MethodPut, wantStatus: http.StatusOK, wantFrozen: true},
+		{name: "wrong method", method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed, wantFrozen: true},
+	}
+
+	// Steps share the switch, so they run in order
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/admin/freeze", strings.NewReader(tt.body)))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.wantStatus, rec.Code, rec.Body.String())
+		}
+		if readOnly.Frozen() != tt.wantFrozen {
+			t.Errorf("%s: expected frozen %v", tt.name, tt.wantFrozen)
+		}
+	}
+
+	// A freeze set at startup cannot be lifted through the API
+	pinned := FreezeHandler(freeze.New(true), events.NewHub(), logger)
+	rec := httptest.NewRecorder()
+	pinned.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/admin/freeze", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected a pinned freeze to be refused, got %d", rec.Code)
+	}
+}
+
+func TestPayloadValidation(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := &models.ConfigStore{Models: map[string]models.ModelConfig{}}
+	keyring := middleware.NewKeyring([]models.ProxyKey{{Key: "pk", Application: "web"}})
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		method     string
+		path       string
+		body       string
+		wantFields []string
+	}{
+		{name: "models patch missing", handler: ModelsHandler(store, nil, logger), method: http.MethodPatch, path: "/admin/models", body: `{"selector": {"aliases": ["gpt4", 3]}}`, wantFields: []string{"", "selector.aliases[1]"}},
+		{name: "models unknown field", handler: ModelsHandler(store, nil, logger), method: http.MethodPatch, path: "/admin/models", body: `{"patch": {}, "dryrun": true}`, wantFields: []string{""}},
+		{name: "keys empty id", handler: KeysHandler(keyring, nil, logger), method: http.MethodPatch, path: "/admin/keys", body: `{"id": "", "disabled": "yes"}`, wantFields: []string{"disabled", "id"}},
+		{name: "log level unknown", handler: LogLevelHandler(loglevel.New(slog.LevelInfo), time.Minute), method: http.MethodPut, path: "/admin/log-level", body: `{"level": "verbose"}`, wantFields: []string{"level"}},
+		{name: "freeze reason type", handler: FreezeHandler(freeze.New(false), events.NewHub(), logger), method: http.MethodPut, path: "/admin/freeze", body: `{"reason": 42}`, wantFields: []string{"reason"}},
+		{name: "config bundle keys", handler: ConfigHandler(controlplane.New(store, keyring), nil, logger), method: http.MethodPost, path: "/admin/config", body: `{"schema_version": 1.5, "models": {}, "keys": [{"application": "web", "scope": "root"}]}`, wantFields: []string{"keys[0]", "keys[0].scope", "schema_version"}},
+		{name: "malformed", handler: KeysHandler(keyring, nil, logger), method: http.MethodPatch, path: "/admin/keys", body: `{`, wantFields: []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400, got %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp struct {
+				Error  string `json:"error"`
+				Fields []struct {
+					Field   string `json:"field"`
+					Message string `json:"message"`
+				} `json:"fields"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var fields []string
+			for _, f := range resp.Fields {
+				if f.Message == "" {
+					t.Errorf("field %q has no message", f.Field)
+				}
+				fields = append(fields, f.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("expected fields %q, got %q: %s", tt.wantFields, fields, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSchemasHandler(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	SchemasHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/schemas", nil))
+	var resp struct {
+		Schemas map[string]json.RawMessage `json:"schemas"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for name := range payloadSchemas {
+		if len(resp.Schemas[name]) == 0 {
+			t.Errorf("expected schema %q to be published", name)
+		}
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	t.Parallel()
+
+	backend := models.ProxyKey{Key: "pk-backend", Application: "BACKEND"}
+	keyring := middleware.NewKeyring([]models.ProxyKey{backend})
+	handler := KeysHandler(keyring, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/keys", nil))
+	read := rec.Header().Get("ETag")
+	if read == "" {
+		t.Fatal("expected an ETag on the listing")
+	}
+
+	patch := func(ifMatch string, disabled bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(PatchKeyRequest{ID: backend.ID(), Disabled: disabled})
+		req := httptest.NewRequest(http.MethodPatch, "/admin/keys", bytes.NewReader(body))
+		req.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The first writer wins; a second one holding the same ETag is refused
+	first := patch(read, true)
+	if first.Code != http.StatusOK || first.Header().Get("ETag") == read {
+		t.Fatalf("expected the update to apply with a new ETag, got %d %q", first.Code, first.Header().Get("ETag"))
+	}
+	stale := patch(read, false)
+	if stale.Code != http.StatusPreconditionFailed || stale.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Fatalf("expected 412 with the current ETag, got %d %q", stale.Code, stale.Header().Get("ETag"))
+	}
+	if pk, _ := keyring.Lookup("pk-backend"); !pk.Disabled {
+		t.Error("expected the stale update not to apply")
+	}
+
+	if rec := patch(`"other", `+first.Header().Get("ETag"), false); rec.Code != http.StatusOK {
+		t.Errorf("expected a matching tag in a list to pass, got %d", rec.Code)
+	}
+	if rec := patch("*", true); rec.Code != http.StatusOK {
+		t.Errorf("expected * to match, got %d", rec.Code)
+	}
+
+	// Model ETags cover config the listing does not show
+	store := &models.ConfigStore{Models: map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-1"}}}
+	before := etag(store.ModelsSnapshot())
+	store.SetModels(map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-2"}})
+	if etag(store.ModelsSnapshot()) == before {
+		t.Error("expected the models ETag to change with the api_key")
+	}
+}
@@ -0,0 +1,211 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/amscotti/portus/internal/verify"
+)
+
+// payloadSchemas are the published JSON Schemas of admin API request
+// bodies, keyed by "METHOD path".
+var payloadSchemas = map[string]string{
+	"PATCH /admin/models": `{
+		"type": "object",
+		"required": ["patch"],
+		"additionalProperties": false,
+		"properties": {
+			"selector": {
+				"type": "object",
+				"additionalProperties": false,
+				"properties": {
+					"aliases": {"type": "array", "items": {"type": "string", "minLength": 1}},
+					"pattern": {"type": "string"},
+					"provider": {"type": "string"}
+				}
+			},
+			"patch": {"type": "object"},
+			"dry_run": {"type": "boolean"}
+		}
+	}`,
+	"PATCH /admin/keys": `{
+		"type": "object",
+		"required": ["id"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "string", "minLength": 1},
+			"disabled": {"type": "boolean"}
+		}
+	}`,
+	"PUT /admin/log-level": `{
+		"type": "object",
+		"required": ["level"],
+		"additionalProperties": false,
+		"properties": {
+			"level": {"type": "string", "minLength": 1},
+			"duration": {"type": "string"}
+		}
+	}`,
+	"PUT /admin/freeze": `{
+		"type": "object",
+		"additionalProperties": false,
+		"properties": {
+			"reason": {"type": "string"}
+		}
+	}`,
+	"POST /admin/config": `{
+		"type": "object",
+		"required": ["schema_version", "models"],
+		"additionalProperties": false,
+		"properties": {
+			"schema_version": {"type": "integer"},
+			"version": {"type": "string"},
+			"models": {"type": "object"},
+			"keys": {
+				"type": "array",
+				"items": {
+					"type": "object",
+					"required": ["application", "key"],
+					"additionalProperties": false,
+					"properties": {
+						"application": {"type": "string", "minLength": 1},
+						"key": {"type": "string", "minLength": 1},
+						"scope": {"enum": ["inference", "observability", "admin"]},
+						"expires_at": {"type": "string"},
+						"max_streams": {"type": "integer"},
+						"max_tokens": {"type": "integer"},
+						"request_quota": {"type": "string"},
+						"conversation_tokens": {"type": "integer"},
+						"api_version": {"type": "string"},
+						"disabled": {"type": "boolean"},
+						"tags": {"type": "object"}
+					}
+				}
+			}
+		}
+	}`,
+}
+
+// schemas holds payloadSchemas parsed.
+var schemas = func() map[string]*verify.Schema {
+	parsed := make(map[string]*verify.Schema, len(payloadSchemas))
+	for name, raw := range payloadSchemas {
+		s, err := verify.ParseSchema(json.RawMessage(raw))
+		if err != nil {
+			panic(name + ": " + err.Error())
+		}
+		parsed[name] = s
+	}
+	return parsed
+}()
+
+// SchemasHandler returns the endpoint publishing the JSON Schema of every
+// admin API request body.
+func SchemasHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		published := make(map[string]json.RawMessage, len(payloadSchemas))
+		for name, raw := range payloadSchemas {
+			var compact bytes.Buffer
+			json.Compact(&compact, []byte(raw))
+			published[name] = compact.Bytes()
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"schemas": published})
+	}
+}
+
+// writeFieldErrors rejects a request body with the fields that are wrong.
+func writeFieldErrors(w http.ResponseWriter, fields []verify.FieldError) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "Invalid request body", "fields": fields})
+}
+
+// decodePayload reads a request body of at most limit bytes, validates it
+// against the named published schema and decodes it into dst. An empty body
+// is accepted when allowEmpty is set. On failure it writes the response and
+// returns false.
+func decodePayload(w http.ResponseWriter, r *http.Request, schema string, limit int64, allowEmpty bool, dst any) bool {
+	data, ok := readPayload(w, r, limit)
+	if !ok {
+		return false
+	}
+	if allowEmpty && len(bytes.TrimSpace(data)) == 0 {
+		return true
+	}
+	return validatePayload(w, schema, data, dst)
+}
+
+// readPayload reads a request body of at most limit bytes.
+func readPayload(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
+}
+
+// validatePayload checks data against the named schema, reporting every
+// failing field, and decodes it into dst.
+func validatePayload(w http.ResponseWriter, schema string, data []byte, dst any) bool {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		writeFieldErrors(w, []verify.FieldError{{Message: "malformed JSON: " + err.Error()}})
+		return false
+	}
+	if fields := schemas[schema].Errors(value); len(fields) > 0 {
+		writeFieldErrors(w, fields)
+		return false
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		// Only values the schema cannot express, such as out-of-range
+		// numbers, get here
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			writeFieldErrors(w, []verify.FieldError{{Field: typeErr.Field, Message: "expected " + typeErr.Type.String()}})
+			return false
+		}
+		writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writes serializes admin changes, so an If-Match check and the change it
+// guards happen atomically.
+var writes sync.Mutex
+
+// etag returns a strong entity tag for the JSON form of a resource's state.
+func etag(state any) string {
+	data, _ := json.Marshal(state)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// ifMatch checks a write's If-Match header against the resource's current
+// entity tag. A request without one always passes. On a mismatch it writes
+// 412 with the current tag and returns false.
+func ifMatch(w http.ResponseWriter, r *http.Request, current string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	w.Header().Set("ETag", current)
+	writeJSONError(w, "Resource has changed since it was read; fetch it again and retry", http.StatusPreconditionFailed)
+	return false
+}
@@ -0,0 +1,107 @@
+// Package annotate attaches Portus's routing decision to responses, so
+// client-side telemetry can see which alias, provider and target actually
+// served each request.
+package annotate
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Field is the top-level extension field carrying the annotation.
+const Field = "portus"
+
+// TargetIndexHeader is the gateway response header naming the target of a
+// multi-target alias that served the request.
+const TargetIndexHeader = "X-Portkey-Last-Used-Option-Index"
+
+// Annotation describes how a request was routed.
+type Annotation struct {
+	Alias    string `json:"alias"`
+	Provider string `json:"provider"`
+	// TargetIndex is the index into the alias's targets; it is omitted for
+	// single-provider aliases.
+	TargetIndex *int `json:"target_index,omitempty"`
+	// Attempt counts gateway attempts made by Portus, including edge retries;
+	// zero when no gateway request was made.
+	Attempt int  `json:"attempt"`
+	Cached  bool `json:"cached"`
+}
+
+// JSON sets the annotation on a JSON object response, replacing any earlier
+// one. It reports whether payload was changed; non-object payloads are not.
+func JSON(payload []byte, a Annotation) ([]byte, bool) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &doc); err != nil || doc == nil {
+		return payload, false
+	}
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return payload, false
+	}
+	doc[Field] = raw
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return payload, false
+	}
+	return out, true
+}
+
+// Carry copies the annotation from one JSON object onto another, such as a
+// response translated into the client's format. to is returned unchanged
+// when from has no annotation.
+func Carry(from, to []byte) []byte {
+	var src map[string]json.RawMessage
+	if json.Unmarshal(from, &src) != nil {
+		return to
+	}
+	raw, ok := src[Field]
+	if !ok {
+		return to
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(to, &doc); err != nil || doc == nil {
+		return to
+	}
+	doc[Field] = raw
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return to
+	}
+	return out
+}
+
+// Comment renders the annotation as a server-sent event comment, which
+// conforming clients ignore, to end an event stream.
+func Comment(a Annotation) []byte {
+	raw, _ := json.Marshal(a)
+	return []byte(": " + Field + " " + string(raw) + "\n\n")
+}
+
+// Line renders the annotation as a final NDJSON line.
+func Line(a Annotation) []byte {
+	raw, _ := json.Marshal(map[string]Annotation{Field: a})
+	return append(raw, '\n')
+}
+
+// ParseTargetIndex reads the gateway's last used option index, given either
+// as a bare index or as a config path such as "config.targets[1]".
+func ParseTargetIndex(value string) (int, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if i := strings.LastIndex(value, "targets["); i >= 0 {
+		value = value[i+len("targets["):]
+		end := strings.IndexByte(value, ']')
+		if end < 0 {
+			return 0, false
+		}
+		value = value[:end]
+	}
+	index, err := strconv.Atoi(value)
+	if err != nil || index < 0 {
+		return 0, false
+	}
+	return index, true
+}
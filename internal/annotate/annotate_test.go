@@ -0,0 +1,90 @@
+package annotate
+
+import "testing"
+
+func TestJSON(t *testing.T) {
+	t.Parallel()
+
+	index := 1
+	a := Annotation{Alias: "m", Provider: "bedrock", TargetIndex: &index, Attempt: 2}
+	tests := []struct {
+		name        string
+		payload     string
+		want        string
+		wantChanged bool
+	}{
+		{
+			name:        "object",
+			payload:     `{"id":"x"}`,
+			want:        `{"id":"x","portus":{"alias":"m","provider":"bedrock","target_index":1,"attempt":2,"cached":false}}`,
+			wantChanged: true,
+		},
+		{
+			name:        "replaces earlier annotation",
+			payload:     `{"portus":{"alias":"old"}}`,
+			want:        `{"portus":{"alias":"m","provider":"bedrock","target_index":1,"attempt":2,"cached":false}}`,
+			wantChanged: true,
+		},
+		{name: "array", payload: `[1,2]`, want: `[1,2]`},
+		{name: "null", payload: `null`, want: `null`},
+		{name: "not json", payload: `oops`, want: `oops`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			out, changed := JSON([]byte(tt.payload), a)
+			if string(out) != tt.want || changed != tt.wantChanged {
+				t.Errorf("JSON(%s) = %s, %v; want %s, %v", tt.payload, out, changed, tt.want, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestCarry(t *testing.T) {
+	t.Parallel()
+
+	got := Carry([]byte(`{"type":"message","portus":{"alias":"m"}}`), []byte(`{"object":"chat.completion"}`))
+	if string(got) != `{"object":"chat.completion","portus":{"alias":"m"}}` {
+		t.Errorf("unexpected carried payload: %s", got)
+	}
+	if got := Carry([]byte(`{"type":"message"}`), []byte(`{"a":1}`)); string(got) != `{"a":1}` {
+		t.Errorf("expected payload without annotation to be unchanged, got %s", got)
+	}
+}
+
+func TestStreamTrailers(t *testing.T) {
+	t.Parallel()
+
+	a := Annotation{Alias: "m", Provider: "openai", Attempt: 1, Cached: true}
+	if got := string(Comment(a)); got != ": portus {\"alias\":\"m\",\"provider\":\"openai\",\"attempt\":1,\"cached\":true}\n\n" {
+		t.Errorf("unexpected comment: %q", got)
+	}
+	if got := string(Line(a)); got != "{\"portus\":{\"alias\":\"m\",\"provider\":\"openai\",\"attempt\":1,\"cached\":true}}\n" {
+		t.Errorf("unexpected line: %q", got)
+	}
+}
+
+func TestParseTargetIndex(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value  string
+		want   int
+		wantOK bool
+	}{
+		{value: "2", want: 2, wantOK: true},
+		{value: "config.targets[1]", want: 1, wantOK: true},
+		{value: "config.targets[0].targets[3]", want: 3, wantOK: true},
+		{value: ""},
+		{value: "-1"},
+		{value: "config.targets["},
+	}
+	for _, tt := range tests {
+		got, ok := ParseTargetIndex(tt.value)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("ParseTargetIndex(%q) = %d, %v; want %d, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
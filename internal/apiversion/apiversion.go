@@ -0,0 +1,113 @@
+// Package apiversion names the versions of the Portus API and negotiates
+// which one a request is served under. /v1 keeps its current behavior for
+// good; changes that would break v1 clients, such as a new error format, are
+// made only under a later version. No such change has been made yet, so v2
+// currently serves exactly as v1.
+package apiversion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/models"
+)
+
+// Header asks for a version on a request and reports the version served on
+// the response.
+const Header = "X-Portus-API-Version"
+
+// Versions, oldest first.
+const (
+	V1 = "v1"
+	V2 = "v2"
+)
+
+// Supported lists every version this build serves.
+var Supported = []string{V1, V2}
+
+// Parse validates a version name; empty means V1.
+func Parse(value string) (string, error) {
+	if value == "" {
+		return V1, nil
+	}
+	value = strings.ToLower(value)
+	for _, v := range Supported {
+		if v == value {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("unknown API version %q (supported: %s)", value, strings.Join(Supported, ", "))
+}
+
+type contextKey struct{}
+
+// FromContext returns the version a request is served under, V1 when none
+// was negotiated. No handler calls it yet, since v2 has no behavior of its
+// own; it is where a version-specific change will branch.
+func FromContext(ctx context.Context) string {
+	if v, ok := ctx.Value(contextKey{}).(string); ok {
+		return v
+	}
+	return V1
+}
+
+// Prefix serves requests under /<version>/ with the /v1/ handlers registered
+// on next, so endpoint logic is shared. The version is recorded in the
+// request context for Negotiate and FromContext.
+func Prefix(version string, next http.Handler) http.Handler {
+	prefix := "/" + version + "/"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		r = r.Clone(context.WithValue(r.Context(), contextKey{}, version))
+		r.URL.Path = "/v1/" + rest
+		r.URL.RawPath = ""
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Negotiate picks the version for an authenticated request: the one named
+// by its path prefix, else the X-Portus-API-Version header, else the key's
+// default, else serverDefault. The choice is echoed in the response header.
+// A request for an unknown version is rejected with 400.
+func Negotiate(serverDefault string, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			version, fromPath := r.Context().Value(contextKey{}).(string)
+			if !fromPath {
+				proxyKey, _ := r.Context().Value(middleware.ContextKeyProxyKey).(models.ProxyKey)
+				switch requested := r.Header.Get(Header); {
+				case requested != "":
+					v, err := Parse(requested)
+					if err != nil {
+						application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+						logger.Warn("unsupported API version requested", "application", application, "version", requested)
+						w.Header().Set("Content-Type", "application/json")
+						w.WriteHeader(http.StatusBadRequest)
+						json.NewEncoder(w).Encode(models.APIVersionError{
+							Error:     "Unsupported API version: " + requested,
+							Supported: Supported,
+						})
+						return
+					}
+					version = v
+				case proxyKey.APIVersion != "":
+					version = proxyKey.APIVersion
+				default:
+					version = serverDefault
+				}
+				r = r.WithContext(context.WithValue(r.Context(), contextKey{}, version))
+			}
+			w.Header().Set(Header, version)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
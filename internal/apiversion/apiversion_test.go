@@ -0,0 +1,100 @@
+package apiversion
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/models"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: V1},
+		{value: "v1", want: V1},
+		{value: "V2", want: V2},
+		{value: "v3", wantErr: true},
+		{value: "1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Parallel()
+			got, err := Parse(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Parse(%q) = %q, %v", tt.value, got, err)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var gotPath, gotVersion string
+	mux := http.NewServeMux()
+	mux.Handle("/v1/chat/completions", Negotiate(V1, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotVersion = FromContext(r.Context())
+	})))
+	mux.Handle("/v2/", Prefix(V2, mux))
+
+	tests := []struct {
+		name       string
+		path       string
+		header     string
+		keyVersion string
+		want       string
+		wantStatus int
+	}{
+		{name: "server default", path: "/v1/chat/completions", want: V1, wantStatus: http.StatusOK},
+		{name: "key default", path: "/v1/chat/completions", keyVersion: V2, want: V2, wantStatus: http.StatusOK},
+		{name: "header beats key", path: "/v1/chat/completions", header: "v1", keyVersion: V2, want: V1, wantStatus: http.StatusOK},
+		{name: "path beats header", path: "/v2/chat/completions", header: "v1", want: V2, wantStatus: http.StatusOK},
+		{name: "unknown version", path: "/v1/chat/completions", header: "v9", wantStatus: http.StatusBadRequest},
+		{name: "unknown v2 endpoint", path: "/v2/nope", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotVersion = "", ""
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(Header, tt.header)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyProxyKey, models.ProxyKey{APIVersion: tt.keyVersion}))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			switch tt.wantStatus {
+			case http.StatusOK:
+				if gotVersion != tt.want || rec.Header().Get(Header) != tt.want {
+					t.Errorf("expected version %s, got %q (header %q)", tt.want, gotVersion, rec.Header().Get(Header))
+				}
+				if gotPath != "/v1/chat/completions" {
+					t.Errorf("expected the /v1 handler path, got %q", gotPath)
+				}
+			case http.StatusBadRequest:
+				var body models.APIVersionError
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body.Supported) != len(Supported) {
+					t.Errorf("unexpected error body %s", rec.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Package breaker implements per-alias circuit breakers so requests to a
+// failing provider fail fast instead of each waiting for the full timeout.
+package breaker
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/amscotti/portus/internal/storage"
+)
+
+// State is the state of one circuit.
+type State string
+
+const (
+	// Closed lets every request through.
+	Closed State = "closed"
+	// Open rejects requests until the cooldown has passed.
+	Open State = "open"
+	// HalfOpen lets a single probe request through to test recovery.
+	HalfOpen State = "half_open"
+)
+
+// Breaker tracks consecutive upstream failures per key. It is safe for
+// concurrent use; a nil Breaker allows everything.
+type Breaker struct {
+	backend   storage.Backend
+	threshold int
+	cooldown  time.Duration
+	logger    *slog.Logger
+	now       func() time.Time
+}
+
+// New creates a breaker that opens a circuit after threshold consecutive
+// failures and probes it again after cooldown. A threshold of zero or less
+// returns nil, disabling circuit breaking. Circuit state is held in memory.
+func New(threshold int, cooldown time.Duration, logger *slog.Logger) *Breaker {
+	return NewWithBackend(storage.NewMemory(), threshold, cooldown, logger)
+}
+
+// NewWithBackend is New with circuit state held in backend. With a shared
+// backend, a circuit opened by one replica fails fast on all of them and only
+// one replica probes it. If the backend is unavailable, requests are allowed.
+func NewWithBackend(backend storage.Backend, threshold int, cooldown time.Duration, logger *slog.Logger) *Breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &Breaker{
+		backend:   backend,
+		threshold: threshold,
+		cooldown:  cooldown,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+// Ticket is a permitted request. Exactly one of Success, Failure or Abandon
+// must be called once its outcome is known.
+type Ticket struct {
+	b     *Breaker
+	key   string
+	probe bool
+}
+
+// Allow reports whether a request for key may proceed. When the circuit is
+// open it returns false and how long until the next probe is allowed.
+func (b *Breaker) Allow(key string) (Ticket, time.Duration, bool) {
+	if b == nil {
+		return Ticket{}, 0, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	openedAt, open, err := b.openedAt(ctx, key)
+	if err != nil {
+		b.logger.Warn("circuit state unavailable, allowing request", "key", key, "error", err)
+		return Ticket{b: b, key: key}, 0, true
+	}
+	if !open {
+		return Ticket{b: b, key: key}, 0, true
+	}
+	if wait := openedAt.Add(b.cooldown).Sub(b.now()); wait > 0 {
+		return Ticket{}, wait, false
+	}
+
+	// The probe slot is a lease, so a replica that dies mid-probe frees it
+	probes, err := b.backend.Add(ctx, circuitKey(key, "probe"), 1, b.cooldown)
+	if err != nil {
+		b.logger.Warn("circuit state unavailable, allowing request", "key", key, "error", err)
+		return Ticket{b: b, key: key}, 0, true
+	}
+	if probes > 1 {
+		return Ticket{}, b.cooldown, false
+	}
+	b.logger.Info("circuit half-open, probing upstream", "key", key)
+	return Ticket{b: b, key: key, probe: true}, 0, true
+}
+
+// Success records a healthy upstream response, closing the circuit.
+func (t Ticket) Success() {
+	if t.b == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	// A circuit only opens after a failure was counted, so a closed circuit
+	// without failures is skipped rather than deleted on every request
+	if !t.probe {
+		if _, failed, err := t.b.backend.Get(ctx, circuitKey(t.key, "failures")); err == nil && !failed {
+			return
+		}
+	}
+	_, open, _ := t.b.openedAt(ctx, t.key)
+	if err := t.b.backend.Delete(ctx, circuitKey(t.key, "failures"), circuitKey(t.key, "opened"), circuitKey(t.key, "probe")); err != nil {
+		t.b.logger.Warn("failed to record circuit success", "key", t.key, "error", err)
+		return
+	}
+	if open {
+		t.b.logger.Info("circuit closed", "key", t.key)
+	}
+}
+
+// Failure records an upstream failure, opening the circuit once the threshold
+// is reached or when a probe fails.
+func (t Ticket) Failure() {
+	if t.b == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	if t.probe {
+		t.b.release(ctx, t.key)
+	}
+	failures, err := t.b.backend.Add(ctx, circuitKey(t.key, "failures"), 1, 0)
+	if err != nil {
+		t.b.logger.Warn("failed to record circuit failure", "key", t.key, "error", err)
+		return
+	}
+	if !t.probe {
+		_, open, _ := t.b.openedAt(ctx, t.key)
+		if open || failures < float64(t.b.threshold) {
+			return
+		}
+	}
+	now := t.b.now()
+	if err := t.b.backend.Set(ctx, circuitKey(t.key, "opened"), []byte(strconv.FormatInt(now.UnixMilli(), 10)), 0); err != nil {
+		t.b.logger.Warn("failed to open circuit", "key", t.key, "error", err)
+		return
+	}
+	t.b.logger.Warn("circuit opened", "key", t.key, "consecutive_failures", int(failures), "cooldown", t.b.cooldown.String())
+}
+
+// Abandon releases the ticket without an outcome, e.g. when the client went
+// away before the upstream answered.
+func (t Ticket) Abandon() {
+	if t.b == nil || !t.probe {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+	t.b.release(ctx, t.key)
+}
+
+// State returns the current state of the circuit for key.
+func (b *Breaker) State(key string) State {
+	if b == nil {
+		return Closed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	openedAt, open, err := b.openedAt(ctx, key)
+	switch {
+	case err != nil || !open:
+		return Closed
+	case b.now().Before(openedAt.Add(b.cooldown)):
+		return Open
+	}
+	return HalfOpen
+}
+
+// openedAt returns when the circuit for key was last opened, and whether it
+// is open at all.
+func (b *Breaker) openedAt(ctx context.Context, key string) (time.Time, bool, error) {
+	value, ok, err := b.backend.Get(ctx, circuitKey(key, "opened"))
+	if err != nil || !ok {
+		return time.Time{}, false, err
+	}
+	millis, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMilli(millis), true, nil
+}
+
+// release frees the probe slot of the circuit for key.
+func (b *Breaker) release(ctx context.Context, key string) {
+	if err := b.backend.Delete(ctx, circuitKey(key, "probe")); err != nil {
+		b.logger.Warn("failed to release circuit probe", "key", key, "error", err)
+	}
+}
+
+func circuitKey(key, field string) string {
+	return storage.Key("breaker", key, field)
+}
@@ -0,0 +1,169 @@
+package breaker
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/storage"
+)
+
+func TestBreaker_Lifecycle(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	b := New(3, 30*time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	b.now = func() time.Time { return now }
+
+	fail := func() {
+		t.Helper()
+		ticket, _, ok := b.Allow("claude")
+		if !ok {
+			t.Fatal("expected request to be allowed")
+		}
+		ticket.Failure()
+	}
+
+	// A success resets the consecutive failure count
+	fail()
+	fail()
+	ticket, _, _ := b.Allow("claude")
+	ticket.Success()
+	fail()
+	fail()
+	if b.State("claude") != Closed {
+		t.Fatalf("expected circuit to stay closed below the threshold, got %s", b.State("claude"))
+	}
+
+	fail()
+	if b.State("claude") != Open {
+		t.Fatalf("expected circuit to open at the threshold, got %s", b.State("claude"))
+	}
+	if _, wait, ok := b.Allow("claude"); ok || wait != 30*time.Second {
+		t.Fatalf("expected fast failure with 30s wait, got ok=%v wait=%v", ok, wait)
+	}
+	if _, _, ok := b.Allow("gpt4"); !ok {
+		t.Error("expected other aliases to be unaffected")
+	}
+
+	// After the cooldown a single probe is allowed; a failed probe reopens
+	now = now.Add(31 * time.Second)
+	probe, _, ok := b.Allow("claude")
+	if !ok || b.State("claude") != HalfOpen {
+		t.Fatalf("expected half-open probe, got ok=%v state=%s", ok, b.State("claude"))
+	}
+	if _, _, ok := b.Allow("claude"); ok {
+		t.Error("expected only one concurrent probe")
+	}
+	probe.Failure()
+	if b.State("claude") != Open {
+		t.Fatalf("expected failed probe to reopen circuit, got %s", b.State("claude"))
+	}
+
+	// An abandoned probe frees the slot; a successful one closes the circuit
+	now = now.Add(31 * time.Second)
+	probe, _, _ = b.Allow("claude")
+	probe.Abandon()
+	probe, _, ok = b.Allow("claude")
+	if !ok {
+		t.Fatal("expected a new probe after the previous one was abandoned")
+	}
+	probe.Success()
+	if b.State("claude") != Closed {
+		t.Errorf("expected successful probe to close circuit, got %s", b.State("claude"))
+	}
+}
+
+func TestBreaker_SharedBackend(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	backend := storage.NewMemory()
+	first := NewWithBackend(backend, 2, 30*time.Second, logger)
+	second := NewWithBackend(backend, 2, 30*time.Second, logger)
+	first.now = func() time.Time { return now }
+	second.now = func() time.Time { return now }
+
+	// Failures on either replica count towards the same circuit
+	ticket, _, _ := first.Allow("claude")
+	ticket.Failure()
+	ticket, _, _ = second.Allow("claude")
+	ticket.Failure()
+	if _, _, ok := first.Allow("claude"); ok {
+		t.Fatal("expected the circuit opened by another replica to fail fast")
+	}
+
+	// Only one replica gets the probe
+	now = now.Add(31 * time.Second)
+	probe, _, ok := second.Allow("claude")
+	if !ok {
+		t.Fatal("expected a probe after the cooldown")
+	}
+	if _, _, ok := first.Allow("claude"); ok {
+		t.Error("expected the probe to be shared across replicas")
+	}
+	probe.Success()
+	if first.State("claude") != Closed {
+		t.Errorf("expected the probe to close the circuit everywhere, got %s", first.State("claude"))
+	}
+}
+
+// deleteCounter counts Delete calls on a memory backend.
+type deleteCounter struct {
+	*storage.Memory
+	deletes atomic.Int32
+}
+
+func (d *deleteCounter) Delete(ctx context.Context, keys ...string) error {
+	d.deletes.Add(1)
+	return d.Memory.Delete(ctx, keys...)
+}
+
+func TestBreaker_SuccessSkipsClosedCircuit(t *testing.T) {
+	t.Parallel()
+
+	backend := &deleteCounter{Memory: storage.NewMemory()}
+	b := NewWithBackend(backend, 3, 30*time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for range 3 {
+		ticket, _, _ := b.Allow("claude")
+		ticket.Success()
+	}
+	if got := backend.deletes.Load(); got != 0 {
+		t.Fatalf("expected no deletes while the circuit has no failures, got %d", got)
+	}
+
+	ticket, _, _ := b.Allow("claude")
+	ticket.Failure()
+	ticket, _, _ = b.Allow("claude")
+	ticket.Success()
+	if got := backend.deletes.Load(); got != 1 {
+		t.Errorf("expected a success after a failure to reset the circuit, got %d deletes", got)
+	}
+	if _, ok, _ := backend.Get(context.Background(), circuitKey("claude", "failures")); ok {
+		t.Error("expected the failure count to be cleared")
+	}
+}
+
+func TestBreaker_Disabled(t *testing.T) {
+	t.Parallel()
+
+	b := New(0, time.Second, nil)
+	if b != nil {
+		t.Fatal("expected zero threshold to disable the breaker")
+	}
+	ticket, _, ok := b.Allow("claude")
+	if !ok {
+		t.Fatal("expected nil breaker to allow requests")
+	}
+	ticket.Failure()
+	ticket.Success()
+	ticket.Abandon()
+	if b.State("claude") != Closed {
+		t.Error("expected nil breaker to report closed")
+	}
+}
@@ -0,0 +1,186 @@
+// Package budget tracks each application's estimated spend over UTC daily
+// and monthly windows and reports when a budget is exhausted, publishing an
+// alert as spend crosses the warning threshold and the limit.
+package budget
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/storage"
+)
+
+// Budget windows.
+const (
+	Daily   = "daily"
+	Monthly = "monthly"
+)
+
+// WarnFraction is the share of a budget at which a warning is published.
+const WarnFraction = 0.8
+
+// Exceeded describes an exhausted budget window.
+type Exceeded struct {
+	Window   string
+	Limit    float64
+	Spent    float64
+	ResetsAt time.Time
+}
+
+// Tracker accumulates spend per application in a storage backend. It is safe
+// for concurrent use; a nil Tracker never limits.
+type Tracker struct {
+	backend storage.Backend
+	hub     *events.Hub
+	logger  *slog.Logger
+}
+
+// New creates a tracker holding spend in memory, so it starts from zero when
+// the process restarts. Budget alerts are published to hub.
+func New(hub *events.Hub, logger *slog.Logger) *Tracker {
+	return NewWithBackend(storage.NewMemory(), hub, logger)
+}
+
+// NewWithBackend creates a tracker holding spend in backend. With a shared
+// backend, every replica counts against the same budget and each alert is
+// published once, by the replica whose spend crossed the threshold. If the
+// backend is unavailable, requests are allowed rather than failing.
+func NewWithBackend(backend storage.Backend, hub *events.Hub, logger *slog.Logger) *Tracker {
+	return &Tracker{backend: backend, hub: hub, logger: logger}
+}
+
+// Check returns the first exhausted window of application's budget at now,
+// daily before monthly, or nil when the application may spend.
+func (t *Tracker) Check(application string, budget models.BudgetConfig, now time.Time) *Exceeded {
+	if t == nil {
+		return nil
+	}
+	for _, w := range windows(budget) {
+		if w.limit <= 0 {
+			continue
+		}
+		start, resets := bounds(w.name, now)
+		if spent := t.spent(application, w.name, start); spent >= w.limit {
+			return &Exceeded{Window: w.name, Limit: w.limit, Spent: spent, ResetsAt: resets}
+		}
+	}
+	return nil
+}
+
+// Usage is the spend in one budget window.
+type Usage struct {
+	Window   string
+	Limit    float64
+	Spent    float64
+	ResetsAt time.Time
+}
+
+// Status returns application's spend at now for each window with a limit.
+func (t *Tracker) Status(application string, budget models.BudgetConfig, now time.Time) []Usage {
+	var usage []Usage
+	for _, w := range windows(budget) {
+		if w.limit <= 0 {
+			continue
+		}
+		start, resets := bounds(w.name, now)
+		u := Usage{Window: w.name, Limit: w.limit, ResetsAt: resets}
+		if t != nil {
+			u.Spent = t.spent(application, w.name, start)
+		}
+		usage = append(usage, u)
+	}
+	return usage
+}
+
+// Add records cost against application's windows at now, alerting once per
+// window when spend reaches WarnFraction of the limit and again when it
+// reaches the limit.
+func (t *Tracker) Add(application string, budget models.BudgetConfig, cost float64, now time.Time) {
+	if t == nil || cost <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	for _, w := range windows(budget) {
+		if w.limit <= 0 {
+			continue
+		}
+		start, resets := bounds(w.name, now)
+		// The counter outlives its window by a day so late readers see it
+		spent, err := t.backend.Add(ctx, spendKey(application, w.name, start), cost, resets.Sub(now)+24*time.Hour)
+		if err != nil {
+			t.logger.Warn("failed to record budget spend", "application", application, "window", w.name, "error", err)
+			continue
+		}
+
+		// Only the add that crosses a threshold alerts
+		before := spent - cost
+		switch {
+		case spent >= w.limit && before < w.limit:
+			t.alert(events.BudgetExceeded, application, w.name, w.limit, spent, resets)
+		case spent >= w.limit*WarnFraction && before < w.limit*WarnFraction:
+			t.alert(events.BudgetWarning, application, w.name, w.limit, spent, resets)
+		}
+	}
+}
+
+// spent returns application's spend in the window starting at start.
+func (t *Tracker) spent(application, window string, start time.Time) float64 {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	value, _, err := t.backend.Get(ctx, spendKey(application, window, start))
+	if err != nil {
+		t.logger.Warn("budget spend unavailable, allowing request", "application", application, "window", window, "error", err)
+	}
+	return storage.Number(value)
+}
+
+// spendKey identifies one window of an application's spend. Windows are keyed
+// by their start, so a new window starts from zero.
+func spendKey(application, window string, start time.Time) string {
+	return storage.Key("budget", application, window, strconv.FormatInt(start.Unix(), 10))
+}
+
+func (t *Tracker) alert(eventType, application, window string, limit, spent float64, resets time.Time) {
+	t.logger.Warn("application spend budget threshold reached",
+		"event", eventType,
+		"application", application,
+		"window", window,
+		"limit_usd", limit,
+		"spent_usd", spent,
+		"resets_at", resets,
+	)
+	t.hub.Publish(eventType, "", map[string]any{
+		"application": application,
+		"window":      window,
+		"limit_usd":   limit,
+		"spent_usd":   spent,
+		"resets_at":   resets,
+	})
+}
+
+type window struct {
+	name  string
+	limit float64
+}
+
+func windows(budget models.BudgetConfig) []window {
+	return []window{{Daily, budget.DailyUSD}, {Monthly, budget.MonthlyUSD}}
+}
+
+// bounds returns the start of the window containing now and when it ends.
+func bounds(window string, now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	if window == Daily {
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1)
+	}
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
@@ -0,0 +1,122 @@
+package budget
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/storage"
+)
+
+func TestTracker(t *testing.T) {
+	t.Parallel()
+
+	hub := events.NewHub()
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	tracker := New(hub, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	budget := models.BudgetConfig{DailyUSD: 10, MonthlyUSD: 25}
+	day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tracker.Add("search", budget, 7, day)
+	if exceeded := tracker.Check("search", budget, day); exceeded != nil {
+		t.Fatalf("unexpected exceeded %+v", exceeded)
+	}
+	tracker.Add("search", budget, 1, day)
+	if ev := <-ch; ev.Type != events.BudgetWarning || ev.Data["window"] != Daily {
+		t.Errorf("expected a daily warning, got %+v", ev)
+	}
+
+	tracker.Add("search", budget, 3, day)
+	if ev := <-ch; ev.Type != events.BudgetExceeded || ev.Data["window"] != Daily {
+		t.Errorf("expected daily exceeded, got %+v", ev)
+	}
+	exceeded := tracker.Check("search", budget, day)
+	if exceeded == nil || exceeded.Window != Daily || exceeded.Spent != 11 || !exceeded.ResetsAt.Equal(time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected exceeded %+v", exceeded)
+	}
+	if other := tracker.Check("batch", budget, day); other != nil {
+		t.Errorf("expected other applications unaffected, got %+v", other)
+	}
+
+	// The next day the daily window resets but the monthly one carries over
+	nextDay := day.AddDate(0, 0, 1)
+	if exceeded := tracker.Check("search", budget, nextDay); exceeded != nil {
+		t.Fatalf("expected the daily window to reset, got %+v", exceeded)
+	}
+	tracker.Add("search", budget, 10, nextDay)
+	exceeded = tracker.Check("search", budget, nextDay)
+	if exceeded == nil || exceeded.Window != Daily {
+		t.Fatalf("expected the daily budget exhausted, got %+v", exceeded)
+	}
+	tracker.Add("search", budget, 4, nextDay)
+	if exceeded := tracker.Check("search", budget, nextDay.AddDate(0, 0, 1)); exceeded == nil || exceeded.Window != Monthly || exceeded.Spent != 25 {
+		t.Errorf("expected the monthly budget exhausted, got %+v", exceeded)
+	}
+
+	// A new month resets both windows
+	if exceeded := tracker.Check("search", budget, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)); exceeded != nil {
+		t.Errorf("expected a new month to reset the budget, got %+v", exceeded)
+	}
+}
+
+func TestTracker_SharedBackend(t *testing.T) {
+	t.Parallel()
+
+	hub := events.NewHub()
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	backend := storage.NewMemory()
+	first, second := NewWithBackend(backend, hub, logger), NewWithBackend(backend, hub, logger)
+	budget := models.BudgetConfig{DailyUSD: 10}
+	day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	// Spend on one replica counts against the budget on the other, and only
+	// the replica crossing the limit alerts
+	first.Add("search", budget, 6, day)
+	second.Add("search", budget, 6, day)
+	if ev := <-ch; ev.Type != events.BudgetExceeded {
+		t.Errorf("expected daily exceeded, got %+v", ev)
+	}
+	if exceeded := first.Check("search", budget, day); exceeded == nil || exceeded.Spent != 12 {
+		t.Fatalf("expected shared spend of 12, got %+v", exceeded)
+	}
+	first.Add("search", budget, 1, day)
+	select {
+	case ev := <-ch:
+		t.Errorf("expected no further alerts, got %+v", ev)
+	default:
+	}
+}
+
+func TestTracker_Status(t *testing.T) {
+	t.Parallel()
+
+	tracker := New(nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	budget := models.BudgetConfig{MonthlyUSD: 100}
+	now := time.Date(2024, 2, 10, 8, 0, 0, 0, time.UTC)
+	tracker.Add("search", budget, 12.5, now)
+
+	usage := tracker.Status("search", budget, now)
+	want := Usage{Window: Monthly, Limit: 100, Spent: 12.5, ResetsAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	if len(usage) != 1 || usage[0] != want {
+		t.Errorf("Status() = %+v, want [%+v]", usage, want)
+	}
+	if usage := tracker.Status("search", budget, now.AddDate(0, 1, 0)); len(usage) != 1 || usage[0].Spent != 0 {
+		t.Errorf("expected no spend in the next month, got %+v", usage)
+	}
+}
+
+func TestTracker_Nil(t *testing.T) {
+	t.Parallel()
+
+	var tracker *Tracker
+	tracker.Add("search", models.BudgetConfig{DailyUSD: 1}, 5, time.Now())
+	if exceeded := tracker.Check("search", models.BudgetConfig{DailyUSD: 1}, time.Now()); exceeded != nil {
+		t.Errorf("expected a nil tracker never to limit, got %+v", exceeded)
+	}
+}
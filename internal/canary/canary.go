@@ -0,0 +1,160 @@
+// Package canary periodically sends a tiny synthetic request through model
+// aliases so broken aliases are detected before users report them.
+package canary
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ProbeFunc sends one canary request through an alias and returns the upstream
+// status code. A non-nil error or non-2xx status counts as a failure.
+type ProbeFunc func(ctx context.Context, alias string) (int, error)
+
+// Result is the outcome of a single canary run.
+type Result struct {
+	Success   bool      `json:"success"`
+	Status    int       `json:"status,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Stats aggregates canary results for one alias.
+type Stats struct {
+	Alias               string  `json:"alias"`
+	Runs                int64   `json:"runs"`
+	Failures            int64   `json:"failures"`
+	ConsecutiveFailures int64   `json:"consecutive_failures"`
+	AvgLatencyMs        float64 `json:"avg_latency_ms"`
+	Last                Result  `json:"last"`
+
+	totalLatencyMs int64
+}
+
+// Runner schedules canary probes.
+type Runner struct {
+	probe    ProbeFunc
+	interval time.Duration
+	timeout  time.Duration
+	aliases  func() []string
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	stats map[string]*Stats
+}
+
+// NewRunner creates a runner that probes the aliases returned by aliases every
+// interval. Each probe is bounded by timeout.
+func NewRunner(probe ProbeFunc, aliases func() []string, interval, timeout time.Duration, logger *slog.Logger) *Runner {
+	return &Runner{
+		probe:    probe,
+		interval: interval,
+		timeout:  timeout,
+		aliases:  aliases,
+		logger:   logger,
+		stats:    make(map[string]*Stats),
+	}
+}
+
+// Run probes every alias immediately and then on each interval until ctx is canceled.
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce probes every alias sequentially, so canaries never add bursts of load.
+func (r *Runner) RunOnce(ctx context.Context) {
+	for _, alias := range r.aliases() {
+		if ctx.Err() != nil {
+			return
+		}
+		r.probeAlias(ctx, alias)
+	}
+}
+
+func (r *Runner) probeAlias(ctx context.Context, alias string) {
+	probeCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	status, err := r.probe(probeCtx, alias)
+	result := Result{
+		Status:    status,
+		LatencyMs: time.Since(start).Milliseconds(),
+		Time:      start,
+	}
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case status < 200 || status >= 300:
+		result.Error = "unexpected upstream status"
+	default:
+		result.Success = true
+	}
+
+	r.record(alias, result)
+
+	if result.Success {
+		r.logger.Info("canary succeeded",
+			"model_alias", alias,
+			"status", status,
+			"latency_ms", result.LatencyMs,
+		)
+	} else {
+		r.logger.Warn("canary failed",
+			"model_alias", alias,
+			"status", status,
+			"latency_ms", result.LatencyMs,
+			"error", result.Error,
+		)
+	}
+}
+
+func (r *Runner) record(alias string, result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.stats[alias]
+	if !ok {
+		s = &Stats{Alias: alias}
+		r.stats[alias] = s
+	}
+	s.Runs++
+	s.totalLatencyMs += result.LatencyMs
+	s.AvgLatencyMs = float64(s.totalLatencyMs) / float64(s.Runs)
+	if result.Success {
+		s.ConsecutiveFailures = 0
+	} else {
+		s.Failures++
+		s.ConsecutiveFailures++
+	}
+	s.Last = result
+}
+
+// Snapshot returns the canary stats of every probed alias, sorted by alias.
+func (r *Runner) Snapshot() []Stats {
+	if r == nil {
+		return []Stats{}
+	}
+	r.mu.Lock()
+	result := make([]Stats, 0, len(r.stats))
+	for _, s := range r.stats {
+		result = append(result, *s)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Alias < result[j].Alias })
+	return result
+}
@@ -0,0 +1,61 @@
+package canary
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRunner_RunOnce(t *testing.T) {
+	t.Parallel()
+
+	probe := func(ctx context.Context, alias string) (int, error) {
+		switch alias {
+		case "healthy":
+			return http.StatusOK, nil
+		case "rate-limited":
+			return http.StatusTooManyRequests, nil
+		default:
+			return 0, errors.New("connection refused")
+		}
+	}
+	aliases := func() []string { return []string{"healthy", "rate-limited", "down"} }
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	runner := NewRunner(probe, aliases, time.Minute, time.Second, logger)
+	runner.RunOnce(context.Background())
+	runner.RunOnce(context.Background())
+
+	stats := runner.Snapshot()
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 aliases, got %d", len(stats))
+	}
+
+	byAlias := make(map[string]Stats)
+	for _, s := range stats {
+		byAlias[s.Alias] = s
+	}
+
+	if s := byAlias["healthy"]; s.Runs != 2 || s.Failures != 0 || !s.Last.Success {
+		t.Errorf("unexpected healthy stats: %+v", s)
+	}
+	if s := byAlias["rate-limited"]; s.Failures != 2 || s.Last.Status != http.StatusTooManyRequests {
+		t.Errorf("unexpected rate-limited stats: %+v", s)
+	}
+	if s := byAlias["down"]; s.ConsecutiveFailures != 2 || s.Last.Error != "connection refused" {
+		t.Errorf("unexpected down stats: %+v", s)
+	}
+}
+
+func TestRunner_NilSnapshot(t *testing.T) {
+	t.Parallel()
+
+	var runner *Runner
+	if stats := runner.Snapshot(); len(stats) != 0 {
+		t.Errorf("expected empty snapshot, got %+v", stats)
+	}
+}
@@ -0,0 +1,229 @@
+// Package capability knows the request constraints of common provider models
+// (parameter ranges, unsupported parameters, output token limits) and checks
+// alias configurations against them, so configs that would fail at request
+// time are caught at load.
+package capability
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+// maxOutputTokens lists the largest max_tokens each model family accepts,
+// keyed by model name prefix. The longest matching prefix wins.
+var maxOutputTokens = map[string]int{
+	"claude-3-haiku":    4096,
+	"claude-3-opus":     4096,
+	"claude-3-5-haiku":  8192,
+	"claude-3-5-sonnet": 8192,
+	"claude-3-7-sonnet": 64000,
+	"claude-haiku-4":    64000,
+	"claude-sonnet-4":   64000,
+	"claude-opus-4":     32000,
+	"claude-opus-4-5":   64000,
+	"gpt-3.5-turbo":     4096,
+	"gpt-4-turbo":       4096,
+	"gpt-4o":            16384,
+	"gpt-4.1":           32768,
+	"gpt-5":             128000,
+	"o1":                100000,
+	"o1-mini":           65536,
+	"o3":                100000,
+	"o4-mini":           100000,
+	"gemini-1.5":        8192,
+	"gemini-2.0":        8192,
+	"gemini-2.5":        65536,
+}
+
+// reasoningModel matches OpenAI reasoning models, which reject sampling
+// parameters and max_tokens.
+var reasoningModel = regexp.MustCompile(`^(o\d|gpt-5)`)
+
+// reasoningUnsupported are parameters OpenAI reasoning models reject.
+var reasoningUnsupported = []string{"temperature", "top_p", "presence_penalty", "frequency_penalty", "logprobs", "top_logprobs", "logit_bias"}
+
+// MaxOutputTokens returns the largest max_tokens a model accepts, or zero
+// when the model is not in the built-in table. Provider prefixes such as
+// Bedrock's "anthropic." are ignored.
+func MaxOutputTokens(model string) int {
+	model = baseModel(model)
+	best, limit := 0, 0
+	for prefix, n := range maxOutputTokens {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, limit = len(prefix), n
+		}
+	}
+	return limit
+}
+
+// IsReasoningModel reports whether model is an OpenAI reasoning model.
+func IsReasoningModel(model string) bool {
+	return reasoningModel.MatchString(baseModel(model))
+}
+
+// baseModel strips region and vendor prefixes, e.g. Bedrock's
+// "us.anthropic.claude-sonnet-4-..." becomes "claude-sonnet-4-...".
+func baseModel(model string) string {
+	if i := strings.Index(model, "claude-"); i > 0 {
+		return model[i:]
+	}
+	return model
+}
+
+// route is one provider and parameter set an alias can send to.
+type route struct {
+	name     string
+	provider string
+	params   map[string]interface{}
+}
+
+// routes returns the routes of an alias. With a strategy only the targets
+// are used, each with its own params.
+func routes(model models.ModelConfig) []route {
+	if model.Strategy == nil {
+		return []route{{provider: model.Provider, params: model.OverrideParams}}
+	}
+	var routes []route
+	for i, target := range model.Targets {
+		routes = append(routes, route{name: fmt.Sprintf("targets[%d]", i), provider: target.Provider, params: target.OverrideParams})
+	}
+	return routes
+}
+
+// FallbackMaxTokens is the max_tokens sent to Anthropic when nothing is known
+// about the alias's model.
+const FallbackMaxTokens = 4096
+
+// Sources of DefaultMaxTokens.
+const (
+	SourceOverrideParams  = "override_params"
+	SourceMaxOutputTokens = "max_output_tokens"
+	SourceTable           = "capability_table"
+	SourceFallback        = "fallback"
+)
+
+// DefaultMaxTokens returns the max_tokens for an Anthropic request that sets
+// none, and its source: the alias's override_params max_tokens, then its
+// max_output_tokens, then the built-in output limit of its model. An alias
+// with several targets gets the smallest limit among them, and the table is
+// only used when it knows every target's model, so the value is accepted
+// whichever target serves the request. Otherwise FallbackMaxTokens is used.
+func DefaultMaxTokens(model models.ModelConfig) (int, string) {
+	if n, ok := number(model.OverrideParams, "max_tokens"); ok && n > 0 {
+		return int(n), SourceOverrideParams
+	}
+	if model.MaxOutputTokens > 0 {
+		return model.MaxOutputTokens, SourceMaxOutputTokens
+	}
+	smallest := 0
+	for _, r := range routes(model) {
+		name, _ := r.params["model"].(string)
+		limit := MaxOutputTokens(name)
+		if limit == 0 {
+			return FallbackMaxTokens, SourceFallback
+		}
+		if smallest == 0 || limit < smallest {
+			smallest = limit
+		}
+	}
+	if smallest == 0 {
+		return FallbackMaxTokens, SourceFallback
+	}
+	return smallest, SourceTable
+}
+
+// Check compares an alias's provider settings against the known constraints
+// and returns a warning for each likely request-time failure, in order.
+func Check(model models.ModelConfig) []string {
+	var warnings []string
+	for _, r := range routes(model) {
+		for _, w := range checkRoute(model, r) {
+			if r.name != "" {
+				w = r.name + ": " + w
+			}
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
+
+func checkRoute(model models.ModelConfig, r route) []string {
+	var warnings []string
+	warn := func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	name, _ := r.params["model"].(string)
+	anthropic := r.provider == "anthropic" || strings.Contains(name, "claude")
+
+	if temperature, ok := number(r.params, "temperature"); ok {
+		upper := 2.0
+		if anthropic {
+			upper = 1.0
+		}
+		if temperature < 0 || temperature > upper {
+			warn("temperature %g is outside the provider's range of 0 to %g", temperature, upper)
+		}
+	}
+	if topP, ok := number(r.params, "top_p"); ok && (topP < 0 || topP > 1) {
+		warn("top_p %g is outside the range of 0 to 1", topP)
+	}
+
+	if IsReasoningModel(name) {
+		var unsupported []string
+		for _, param := range reasoningUnsupported {
+			if _, ok := r.params[param]; ok {
+				unsupported = append(unsupported, param)
+			}
+		}
+		if len(unsupported) > 0 {
+			warn("%s does not support %s", name, strings.Join(unsupported, ", "))
+		}
+		if _, ok := r.params["max_tokens"]; ok {
+			warn("%s does not support max_tokens; use max_completion_tokens", name)
+		}
+	} else if model.ReasoningEffort != "" && name != "" && !anthropic {
+		warn("reasoning_effort is set but %s is not a reasoning model", name)
+	}
+
+	// The alias's own max_output_tokens overrides the built-in table
+	limit := MaxOutputTokens(name)
+	if model.MaxOutputTokens > 0 {
+		limit = model.MaxOutputTokens
+	}
+	if limit > 0 {
+		label := name
+		if label == "" {
+			label = "the model"
+		}
+		for _, param := range []string{"max_tokens", "max_completion_tokens"} {
+			if n, ok := number(r.params, param); ok && int(n) > limit {
+				warn("%s %d exceeds the %d output tokens %s supports", param, int(n), limit, label)
+			}
+		}
+	}
+
+	if t := model.Thinking; t != nil && t.Type == "enabled" && anthropic {
+		if t.BudgetTokens < 1024 {
+			warn("thinking budget_tokens %d is below the minimum of 1024", t.BudgetTokens)
+		}
+		if n, ok := number(r.params, "max_tokens"); ok && t.BudgetTokens >= int(n) {
+			warn("thinking budget_tokens %d must be less than max_tokens %d", t.BudgetTokens, int(n))
+		}
+		if temperature, ok := number(r.params, "temperature"); ok && temperature != 1 {
+			warn("extended thinking requires temperature 1, got %g", temperature)
+		}
+		if _, ok := r.params["top_k"]; ok {
+			warn("extended thinking does not support top_k")
+		}
+	}
+	return warnings
+}
+
+// number returns a numeric parameter.
+func number(params map[string]interface{}, key string) (float64, bool) {
+	n, ok := params[key].(float64)
+	return n, ok
+}
@@ -0,0 +1,172 @@
+package capability
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		model models.ModelConfig
+		want  []string
+	}{
+		{
+			name:  "valid",
+			model: models.ModelConfig{Provider: "openai", OverrideParams: map[string]interface{}{"model": "gpt-4o", "temperature": 1.5, "max_tokens": 4096.0}},
+		},
+		{
+			name:  "anthropic temperature",
+			model: models.ModelConfig{Provider: "anthropic", OverrideParams: map[string]interface{}{"model": "claude-sonnet-4-5", "temperature": 1.5}},
+			want:  []string{"temperature 1.5 is outside the provider's range of 0 to 1"},
+		},
+		{
+			name:  "reasoning model parameters",
+			model: models.ModelConfig{Provider: "openai", OverrideParams: map[string]interface{}{"model": "o3-mini", "temperature": 0.2, "top_p": 0.9, "max_tokens": 1000.0}},
+			want:  []string{"o3-mini does not support temperature, top_p", "o3-mini does not support max_tokens; use max_completion_tokens"},
+		},
+		{
+			name:  "max tokens above model limit",
+			model: models.ModelConfig{Provider: "bedrock", OverrideParams: map[string]interface{}{"model": "us.anthropic.claude-3-5-sonnet-20241022-v2:0", "max_tokens": 16000.0}},
+			want:  []string{"max_tokens 16000 exceeds the 8192 output tokens us.anthropic.claude-3-5-sonnet-20241022-v2:0 supports"},
+		},
+		{
+			name:  "reasoning effort on non-reasoning model",
+			model: models.ModelConfig{Provider: "openai", ReasoningEffort: "high", OverrideParams: map[string]interface{}{"model": "gpt-4o"}},
+			want:  []string{"reasoning_effort is set but gpt-4o is not a reasoning model"},
+		},
+		{
+			name: "thinking constraints",
+			model: models.ModelConfig{
+				Provider:       "anthropic",
+				Thinking:       &models.ThinkingConfig{Type: "enabled", BudgetTokens: 8000},
+				OverrideParams: map[string]interface{}{"model": "claude-opus-4-1", "max_tokens": 4096.0, "temperature": 0.5},
+			},
+			want: []string{"thinking budget_tokens 8000 must be less than max_tokens 4096", "extended thinking requires temperature 1, got 0.5"},
+		},
+		{
+			name:  "max tokens above configured max_output_tokens",
+			model: models.ModelConfig{Provider: "openai", CustomHost: "http://vllm:8000/v1", MaxOutputTokens: 2048, OverrideParams: map[string]interface{}{"max_tokens": 4096.0}},
+			want:  []string{"max_tokens 4096 exceeds the 2048 output tokens the model supports"},
+		},
+		{
+			name: "strategy targets",
+			model: models.ModelConfig{
+				Strategy:       &models.StrategyConfig{Mode: "fallback"},
+				OverrideParams: map[string]interface{}{"temperature": 5.0},
+				Targets: []models.TargetConfig{
+					{Provider: "openai", OverrideParams: map[string]interface{}{"model": "gpt-4o"}},
+					{Provider: "anthropic", OverrideParams: map[string]interface{}{"model": "claude-3-haiku-20240307", "max_tokens": 8192.0}},
+				},
+			},
+			want: []string{"targets[1]: max_tokens 8192 exceeds the 4096 output tokens claude-3-haiku-20240307 supports"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := Check(tt.model)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxOutputTokens(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"claude-opus-4-20250514", 32000},
+		{"claude-opus-4-5-20251101", 64000},
+		{"anthropic.claude-3-haiku-20240307-v1:0", 4096},
+		{"gpt-4o-mini", 16384},
+		{"o1-mini", 65536},
+		{"llama-3-70b", 0},
+	}
+	for _, tt := range tests {
+		if got := MaxOutputTokens(tt.model); got != tt.want {
+			t.Errorf("MaxOutputTokens(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultMaxTokens(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		model      models.ModelConfig
+		want       int
+		wantSource string
+	}{
+		{
+			name:       "override params",
+			model:      models.ModelConfig{Provider: "anthropic", MaxOutputTokens: 32000, OverrideParams: map[string]interface{}{"model": "claude-sonnet-4-5", "max_tokens": 1024.0}},
+			want:       1024,
+			wantSource: SourceOverrideParams,
+		},
+		{
+			name:       "configured max_output_tokens",
+			model:      models.ModelConfig{Provider: "anthropic", MaxOutputTokens: 32000, OverrideParams: map[string]interface{}{"model": "claude-sonnet-4-5"}},
+			want:       32000,
+			wantSource: SourceMaxOutputTokens,
+		},
+		{
+			name:       "built-in table",
+			model:      models.ModelConfig{Provider: "bedrock", OverrideParams: map[string]interface{}{"model": "us.anthropic.claude-sonnet-4-20250514-v1:0"}},
+			want:       64000,
+			wantSource: SourceTable,
+		},
+		{
+			name: "smallest limit across targets",
+			model: models.ModelConfig{
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				Targets: []models.TargetConfig{
+					{Provider: "anthropic", OverrideParams: map[string]interface{}{"model": "claude-sonnet-4-5"}},
+					{Provider: "anthropic", OverrideParams: map[string]interface{}{"model": "claude-3-5-haiku-latest"}},
+				},
+			},
+			want:       8192,
+			wantSource: SourceTable,
+		},
+		{
+			name: "unknown target model",
+			model: models.ModelConfig{
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				Targets: []models.TargetConfig{
+					{Provider: "anthropic", OverrideParams: map[string]interface{}{"model": "claude-sonnet-4-5"}},
+					{Provider: "anthropic", OverrideParams: map[string]interface{}{"model": "claude-next"}},
+				},
+			},
+			want:       FallbackMaxTokens,
+			wantSource: SourceFallback,
+		},
+		{
+			name:       "no model",
+			model:      models.ModelConfig{Provider: "anthropic"},
+			want:       FallbackMaxTokens,
+			wantSource: SourceFallback,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, source := DefaultMaxTokens(tt.model)
+			if got != tt.want || source != tt.wantSource {
+				t.Errorf("DefaultMaxTokens() = %d, %s, want %d, %s", got, source, tt.want, tt.wantSource)
+			}
+		})
+	}
+}
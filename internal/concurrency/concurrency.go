@@ -0,0 +1,123 @@
+// Package concurrency caps in-flight requests per model alias. Requests over
+// the cap wait in a bounded first-in, first-out queue for a free slot.
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull is returned when every slot is busy and the wait queue is full.
+var ErrQueueFull = errors.New("concurrency queue full")
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+type slots struct {
+	active  int
+	limit   int
+	waiting []*waiter
+}
+
+// Limiter tracks in-flight requests per key. It is safe for concurrent use;
+// a nil Limiter never limits.
+type Limiter struct {
+	mu   sync.Mutex
+	keys map[string]*slots
+}
+
+// New creates an empty limiter.
+func New() *Limiter {
+	return &Limiter{keys: make(map[string]*slots)}
+}
+
+// Acquire reserves one of limit slots for key, waiting behind at most queue
+// other requests until ctx is done. A limit of zero or less means unlimited.
+// It returns ErrQueueFull when the queue is full, or ctx's error if ctx ends
+// while waiting. On success, release must be called exactly once.
+func (l *Limiter) Acquire(ctx context.Context, key string, limit, queue int) (release func(), err error) {
+	if l == nil || limit <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	s, ok := l.keys[key]
+	if !ok {
+		s = &slots{}
+		l.keys[key] = s
+	}
+	// Limits come from config and may change between requests
+	s.limit = limit
+	if s.active < limit && len(s.waiting) == 0 {
+		s.active++
+		l.mu.Unlock()
+		return l.releaser(key, s), nil
+	}
+	if len(s.waiting) >= queue {
+		l.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	w := &waiter{ready: make(chan struct{})}
+	s.waiting = append(s.waiting, w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return l.releaser(key, s), nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		if w.granted {
+			// The slot was handed over as ctx ended; pass it on
+			l.mu.Unlock()
+			l.releaser(key, s)()
+			return nil, ctx.Err()
+		}
+		for i, other := range s.waiting {
+			if other == w {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				break
+			}
+		}
+		l.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// releaser returns a function that frees a slot and hands free slots to the
+// longest waiters.
+func (l *Limiter) releaser(key string, s *slots) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			s.active--
+			for len(s.waiting) > 0 && s.active < s.limit {
+				w := s.waiting[0]
+				s.waiting = s.waiting[1:]
+				w.granted = true
+				s.active++
+				close(w.ready)
+			}
+			if s.active == 0 && len(s.waiting) == 0 {
+				delete(l.keys, key)
+			}
+		})
+	}
+}
+
+// Stats returns the in-flight and queued request counts for key.
+func (l *Limiter) Stats(key string) (active, queued int) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.keys[key]; ok {
+		return s.active, len(s.waiting)
+	}
+	return 0, 0
+}
@@ -0,0 +1,91 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiter_QueuesAndHandsOff(t *testing.T) {
+	t.Parallel()
+
+	l := New()
+	ctx := context.Background()
+
+	first, err := l.Acquire(ctx, "gpt4", 1, 1)
+	if err != nil {
+		t.Fatalf("expected first request to run, got %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		release, err := l.Acquire(ctx, "gpt4", 1, 1)
+		if err != nil {
+			t.Errorf("expected queued request to run, got %v", err)
+		}
+		acquired <- release
+	}()
+	waitFor(t, func() bool { _, queued := l.Stats("gpt4"); return queued == 1 })
+
+	if _, err := l.Acquire(ctx, "gpt4", 1, 1); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+	if release, err := l.Acquire(ctx, "claude", 1, 0); err != nil {
+		t.Errorf("expected other aliases to be unaffected, got %v", err)
+	} else {
+		release()
+	}
+
+	first()
+	first() // releasing twice is harmless
+	second := <-acquired
+	if active, queued := l.Stats("gpt4"); active != 1 || queued != 0 {
+		t.Errorf("expected slot handed to the waiter, got active=%d queued=%d", active, queued)
+	}
+	second()
+	if active, _ := l.Stats("gpt4"); active != 0 {
+		t.Errorf("expected no active requests, got %d", active)
+	}
+}
+
+func TestLimiter_WaitEndsWithContext(t *testing.T) {
+	t.Parallel()
+
+	l := New()
+	release, _ := l.Acquire(context.Background(), "gpt4", 1, 5)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "gpt4", 1, 5); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if _, queued := l.Stats("gpt4"); queued != 0 {
+		t.Errorf("expected abandoned waiter to leave the queue, got %d queued", queued)
+	}
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	t.Parallel()
+
+	var nilLimiter *Limiter
+	for _, l := range []*Limiter{nilLimiter, New()} {
+		for range 3 {
+			if _, err := l.Acquire(context.Background(), "gpt4", 0, 0); err != nil {
+				t.Errorf("expected unlimited acquire, got %v", err)
+			}
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,346 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+// EnvPrefixVar namespaces every Portus environment variable. With
+// PORTUS_ENV_PREFIX=MYORG_, PORTUS_PORT is read from MYORG_PORTUS_PORT and
+// keys are discovered from MYORG_PORTUS_KEY_*.
+const EnvPrefixVar = "PORTUS_ENV_PREFIX"
+
+// Setting is a scalar configuration setting.
+type Setting struct {
+	Env   string
+	Usage string
+}
+
+// Settings lists every scalar setting by its environment variable name. Each
+// has a flag equivalent named by FlagName.
+var Settings = []Setting{
+	{"PORTUS_PORT", "HTTP listen port"},
+	{"PORTUS_ADMIN_ADDR", "host:port serving health, stats, pprof and admin endpoints apart from proxy traffic"},
+	{"PORTUS_CONTROL_PLANE_GRPC_ADDR", "host:port serving the config control plane over gRPC (binaries built with -tags grpc)"},
+	{"PORTUS_CONFIG_PATH", "directory containing models/ and pricing files, or an s3://, gs://, consul:// or etcd:// URL"},
+	{"PORTUS_CONFIG_WATCH_INTERVAL", "how often a local config directory is checked for changed model and key files, e.g. mounted ConfigMaps and Secrets (0 disables)"},
+	{"PORTUS_REMOTE_CONFIG_REFRESH_INTERVAL", "how often an s3:// or gs:// config path is checked for changes, or a failed Consul or etcd watch retried (0 disables)"},
+	{"PORTKEY_GATEWAY_URL", "Portkey Gateway base URL"},
+	{"PORTUS_GATEWAY_ROUTES", "comma-separated pattern=url rules sending matching aliases to another gateway, e.g. eu-*=https://gateway.eu:8787"},
+	{"PORTUS_TLS_CERT", "HTTPS listener certificate file"},
+	{"PORTUS_TLS_KEY", "HTTPS listener private key file"},
+	{"PORTUS_TLS_RELOAD_INTERVAL", "how often TLS certificate files are checked for rotation"},
+	{"PORTUS_ACME_DOMAINS", "comma-separated hostnames to obtain HTTPS listener certificates for over ACME (binaries built with -tags acme)"},
+	{"PORTUS_ACME_CACHE_DIR", "directory keeping ACME certificates and the account key across restarts"},
+	{"PORTUS_ACME_EMAIL", "contact address for the ACME account"},
+	{"PORTUS_ACME_DIRECTORY_URL", "ACME directory URL (default Let's Encrypt)"},
+	{"PORTUS_SECRETS_REFRESH_INTERVAL", "how often secret references in model configs are fetched again (0 disables)"},
+	{"PORTUS_GATEWAY_TLS_CERT", "client certificate for mutual TLS to the gateway"},
+	{"PORTUS_GATEWAY_TLS_KEY", "client private key for mutual TLS to the gateway"},
+	{"PORTUS_GATEWAY_TLS_CA", "CA bundle used to verify the gateway"},
+	{"PORTUS_H2C", "accept HTTP/2 without TLS (h2c) on the plaintext listener"},
+	{"PORTUS_GATEWAY_H2C", "use HTTP/2 without TLS (h2c) to a plaintext gateway"},
+	{"PORTUS_READ_ONLY", "freeze admin writes, pushed bundles and secret reloads until restart"},
+	{"PORTUS_LOG_LEVEL", "log level (debug, info, warn, error)"},
+	{"PORTUS_LOG_LEVEL_TIMEOUT", "how long a runtime log level change lasts"},
+	{"PORTUS_LOG_REDACT_KEYS", "extra comma-separated log attribute keys to redact"},
+	{"PORTUS_LOG_RESPONSE_HASH", "log a SHA-256 of every relayed response body"},
+	{"PORTUS_KEY_EXPIRY_WARNING", "warn this long before a proxy key expires"},
+	{"PORTUS_DISABLED_KEYS", "comma-separated IDs of proxy keys to reject as disabled"},
+	{"PORTUS_MAX_STREAMS", "concurrent streaming responses per key (0 = unlimited)"},
+	{"PORTUS_MAX_STREAMS_BURST", "extra streams per key admitted above the cap, logged"},
+	{"PORTUS_LIMITS_GRACE_UNTIL", "date until which stream and concurrency limits only log"},
+	{"PORTUS_MAX_TOKENS", "completion tokens a request may ask for, per key (0 = unlimited)"},
+	{"PORTUS_MAX_TOKENS_ACTION", "handling of requests above the token cap: clamp or reject"},
+	{"PORTUS_CONVERSATION_TOKENS", "cumulative tokens per conversation, per key (0 = unlimited)"},
+	{"PORTUS_CONVERSATION_TOKENS_ACTION", "handling of conversations past the token cap: reject or warn"},
+	{"PORTUS_CONVERSATION_IDLE_TIMEOUT", "how long an idle conversation's token count is kept"},
+	{"PORTUS_REQUEST_QUOTA", "requests allowed per application and window, e.g. 10000/day (hour, day or month)"},
+	{"PORTUS_API_VERSION", "API version for requests that do not name one: v1 or v2"},
+	{"PORTUS_MODEL_LIST_TTL", "how long live provider model lists are cached"},
+	{"PORTUS_PROXY_RETRIES", "retries of gateway connection failures and 5xx responses (0 disables)"},
+	{"PORTUS_PROXY_RETRY_BACKOFF", "delay before the first proxy retry, doubling each time"},
+	{"PORTUS_CIRCUIT_BREAKER_THRESHOLD", "consecutive upstream failures that open an alias's circuit (0 disables)"},
+	{"PORTUS_CIRCUIT_BREAKER_COOLDOWN", "how long an open circuit fails fast before probing"},
+	{"PORTUS_CREDENTIAL_FAILURE_THRESHOLD", "consecutive upstream 401/403 responses that degrade an alias (0 disables)"},
+	{"PORTUS_CREDENTIAL_FAILURE_PROBE_INTERVAL", "how often a degraded alias lets one request through (0 never probes)"},
+	{"PORTUS_CREDENTIAL_FAILURE_HIDE_MODELS", "leave aliases degraded by credential failures out of /v1/models"},
+	{"PORTUS_MAX_REQUEST_TIMEOUT", "ceiling for request timeouts (0 disables)"},
+	{"PORTUS_STREAM_PROGRESS_INTERVAL", "how often in-flight streams are sampled"},
+	{"PORTUS_STREAM_MAX_AGE", "age at which open streams are force-closed (0 disables)"},
+	{"PORTUS_USAGE_DB", "SQLite database for per-request usage records"},
+	{"PORTUS_USAGE_RAW_RETENTION", "retention of minute usage buckets"},
+	{"PORTUS_USAGE_HOURLY_RETENTION", "retention of hourly usage buckets"},
+	{"PORTUS_USAGE_DAILY_RETENTION", "retention of daily usage buckets"},
+	{"PORTUS_METRICS_MODE", "usage metrics detail: standard or aggregate"},
+	{"PORTUS_METRICS_APP_BUCKETS", "number of application buckets in aggregate metrics mode"},
+	{"PORTUS_METRICS_MIN_COUNT", "requests below which aggregates are folded in aggregate metrics mode"},
+	{"PORTUS_REPORT_DIR", "directory receiving the shutdown report"},
+	{"PORTUS_REDIS_URL", "Redis URL for state shared across replicas"},
+	{"PORTUS_REDIS_STREAM_LEASE", "lifetime of a stream slot in shared storage"},
+	{"PORTUS_STORAGE", "state storage backend: memory, sqlite or redis"},
+	{"PORTUS_STORAGE_PATH", "database file of the sqlite storage backend"},
+	{"PORTUS_CANARY_INTERVAL", "synthetic canary interval (0 disables)"},
+	{"PORTUS_CANARY_ALIASES", "comma-separated aliases probed by canaries"},
+	{"PORTUS_SEMANTIC_CACHE_EMBEDDING_ALIAS", "embeddings alias enabling the semantic cache"},
+	{"PORTUS_SEMANTIC_CACHE_THRESHOLD", "cosine similarity at which cached answers are reused"},
+	{"PORTUS_SEMANTIC_CACHE_TTL", "how long semantic cache entries are kept"},
+	{"PORTUS_SEMANTIC_CACHE_MAX_ENTRIES", "maximum number of semantic cache entries"},
+	{"PORTUS_SHUTDOWN_DRAIN_DELAY", "how long /readyz fails before shutdown begins"},
+	{"PORTUS_FALLBACK_MESSAGE", "static completion served when the gateway fails"},
+	{"PORTUS_NORMALIZE_FINISH_REASONS", "map provider finish reasons onto the OpenAI vocabulary"},
+	{"PORTUS_MESSAGE_STREAM_VALIDATION", "check Anthropic stream event order: log or repair"},
+	{"PORTUS_GZIP_RESPONSES", "gzip JSON responses for clients that accept it"},
+	{"PORTUS_ANNOTATE_RESPONSES", "add the routing decision to every response"},
+	{"PORTUS_DEFAULT_MODEL", "alias serving requests for empty or unknown models"},
+	{"PORTUS_BANNED_MODELS", "comma-separated provider model globs no alias may resolve to"},
+	{"PORTUS_MOCK_MODE", "answer requests locally without the gateway: echo or canned"},
+	{"PORTUS_MOCK_RESPONSE", "reply text for canned mock responses"},
+	{"PORTUS_RECORD_FILE", "file receiving sanitized request/response pairs for replay"},
+	{"PORTUS_BODY_SPILL_THRESHOLD", "bytes of an upload body held in memory before it spills to a temp file (0 disables)"},
+	{"PORTUS_BODY_SPILL_DIR", "directory receiving spilled upload bodies"},
+	{"PORTUS_JOBS_TTL", "how long finished asynchronous jobs are kept"},
+	{"PORTUS_JOBS_MAX_ACTIVE", "maximum asynchronous jobs running at once per replica (0 is unlimited)"},
+	{"PORTUS_JOBS_WEBHOOK_HOSTS", "comma-separated host globs a job's X-Portus-Webhook URL may point at (empty disables per-job webhooks)"},
+	{"PORTUS_WEBHOOK_URLS", "comma-separated URLs receiving job, alert and guardrail events"},
+	{"PORTUS_WEBHOOK_SECRET", "HMAC key signing webhook payloads"},
+	{"PORTUS_WEBHOOK_EVENTS", "comma-separated event type globs sent to PORTUS_WEBHOOK_URLS (default all)"},
+	{"PORTUS_WEBHOOK_RATE_LIMIT", "webhook deliveries per minute to each URL (0 is unlimited)"},
+	{"PORTUS_WEBHOOK_MAX_ATTEMPTS", "times a webhook delivery is tried before it is dead-lettered"},
+	{"PORTUS_WEBHOOK_RETRY_BACKOFF", "delay before the first webhook retry, doubled after each"},
+	{"PORTUS_WEBHOOK_DEAD_LETTER_FILE", "file receiving failed webhook deliveries as JSON lines"},
+	{"PORTUS_PROVENANCE_KEY", "HMAC key signing the X-Portus-Provenance response header"},
+	{"PORTUS_PII_ACTION", "handling of PII in request content: off, mask or reject"},
+	{"PORTUS_PII_PATTERNS", "comma-separated built-in PII patterns: email, ssn, credit_card, or none"},
+	{"PORTUS_STATSD_ADDR", "host:port of a StatsD or DogStatsD agent receiving request metrics"},
+	{"PORTUS_STATSD_PREFIX", "prefix for StatsD metric names"},
+	{"PORTUS_STATSD_FORMAT", "StatsD wire format: statsd or dogstatsd"},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTLP/HTTP collector receiving logs and metrics"},
+	{"OTEL_EXPORTER_OTLP_HEADERS", "headers sent to the collector as key=value pairs"},
+	{"OTEL_SERVICE_NAME", "service name reported to the collector"},
+	{"PORTUS_OTEL_SIGNALS", "comma-separated signals to export: logs, metrics"},
+	{"PORTUS_OTEL_METRIC_INTERVAL", "how often metrics are exported to the collector"},
+}
+
+// keyedFlags are repeatable NAME=value flags for settings discovered by prefix.
+var keyedFlags = []struct {
+	flag   string
+	prefix string
+	usage  string
+}{
+	{"key", "PORTUS_KEY_", "proxy key as APP_NAME=key (repeatable)"},
+	{"admin-key", "PORTUS_ADMIN_KEY_", "admin key as OPERATOR_NAME=key (repeatable)"},
+	{"obs-key", "PORTUS_OBS_KEY_", "observability token as APP_NAME=token (repeatable)"},
+	{"max-streams-for", "PORTUS_MAX_STREAMS_", "per-key stream cap as APP_NAME=n (repeatable)"},
+	{"max-tokens-for", "PORTUS_MAX_TOKENS_", "per-key completion token cap as APP_NAME=n (repeatable)"},
+	{"conversation-tokens-for", "PORTUS_CONVERSATION_TOKENS_", "per-key conversation token cap as APP_NAME=n (repeatable)"},
+	{"request-quota-for", "PORTUS_REQUEST_QUOTA_", "per-key request quota as APP_NAME=limit/window (repeatable)"},
+	{"api-version-for", "PORTUS_API_VERSION_", "per-key default API version as APP_NAME=version (repeatable)"},
+	{"tags-for", "PORTUS_TAGS_", "per-key telemetry tags as APP_NAME=name=value,... (repeatable)"},
+	{"pii-action-for", "PORTUS_PII_ACTION_", "per-application PII action as APP_NAME=action (repeatable)"},
+	{"pii-pattern", "PORTUS_PII_PATTERN_", "custom PII pattern as NAME=regex (repeatable)"},
+	{"webhook-urls-for", "PORTUS_WEBHOOK_URLS_", "per-application webhook URLs as APP_NAME=url,... (repeatable)"},
+	{"webhook-secret-for", "PORTUS_WEBHOOK_SECRET_", "per-application webhook signing key as APP_NAME=key (repeatable)"},
+	{"webhook-events-for", "PORTUS_WEBHOOK_EVENTS_", "per-application webhook event globs as APP_NAME=glob,... (repeatable)"},
+	{"webhook-rate-limit-for", "PORTUS_WEBHOOK_RATE_LIMIT_", "per-application webhook deliveries per minute as APP_NAME=n (repeatable)"},
+}
+
+// FlagName returns the flag equivalent of a setting, e.g. PORTUS_MAX_STREAMS
+// becomes max-streams.
+func FlagName(env string) string {
+	name := strings.TrimPrefix(env, "PORTUS_")
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+// source resolves settings from flags first, then prefixed environment variables.
+type source struct {
+	prefix    string
+	overrides map[string]string
+}
+
+var settings = &source{}
+
+// Getenv returns a setting by its unprefixed variable name.
+func Getenv(name string) string {
+	return settings.get(name)
+}
+
+func (s *source) get(name string) string {
+	if value, ok := s.overrides[name]; ok {
+		return value
+	}
+	return os.Getenv(s.prefix + name)
+}
+
+// environ returns every setting as NAME=value with the prefix removed.
+// Variables outside the prefix are omitted when a prefix is set.
+func (s *source) environ() []string {
+	var result []string
+	for _, env := range os.Environ() {
+		name, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(name, s.prefix) {
+			continue
+		}
+		name = strings.TrimPrefix(name, s.prefix)
+		if _, overridden := s.overrides[name]; overridden {
+			continue
+		}
+		result = append(result, name+"="+value)
+	}
+	for name, value := range s.overrides {
+		result = append(result, name+"="+value)
+	}
+	return result
+}
+
+// perApplicationPrefixes are the keyed prefixes naming an application.
+var perApplicationPrefixes = []string{
+	"PORTUS_MAX_STREAMS_",
+	"PORTUS_MAX_TOKENS_",
+	"PORTUS_CONVERSATION_TOKENS_",
+	"PORTUS_REQUEST_QUOTA_",
+	"PORTUS_API_VERSION_",
+	"PORTUS_TAGS_",
+	"PORTUS_PII_ACTION_",
+	"PORTUS_WEBHOOK_URLS_",
+	"PORTUS_WEBHOOK_SECRET_",
+	"PORTUS_WEBHOOK_EVENTS_",
+	"PORTUS_WEBHOOK_RATE_LIMIT_",
+}
+
+// UnknownSettings returns the PORTUS_ variables in effect that match neither
+// a setting nor a keyed prefix, which are usually misspellings.
+func UnknownSettings() []string {
+	var unknown []string
+	for _, env := range settings.environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, "PORTUS_") || name == EnvPrefixVar || isSetting(name) || isKeyed(name) {
+			continue
+		}
+		unknown = append(unknown, name)
+	}
+	slices.Sort(unknown)
+	return unknown
+}
+
+// OrphanedSettings returns the per-application variables in effect that
+// name an application without a key. They are ignored, usually because the
+// application name is misspelled.
+func OrphanedSettings(store *models.ConfigStore) []string {
+	applications := make(map[string]bool, len(store.ProxyKeys))
+	for _, pk := range store.ProxyKeys {
+		applications[pk.Application] = true
+	}
+	var orphaned []string
+	for _, env := range settings.environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if isSetting(name) {
+			continue
+		}
+		for _, prefix := range perApplicationPrefixes {
+			if application, ok := strings.CutPrefix(name, prefix); ok && application != "" && !applications[application] {
+				orphaned = append(orphaned, name)
+			}
+		}
+	}
+	slices.Sort(orphaned)
+	return orphaned
+}
+
+// ConfigPath returns the directory containing models/ and the optional
+// config files.
+func ConfigPath() string {
+	if path := Getenv("PORTUS_CONFIG_PATH"); path != "" {
+		return path
+	}
+	return defaultConfigPath
+}
+
+// isKeyed reports whether name falls under a keyed setting prefix.
+func isKeyed(name string) bool {
+	for _, k := range keyedFlags {
+		if strings.HasPrefix(name, k.prefix) && len(name) > len(k.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSetting reports whether name is a registered scalar setting.
+func isSetting(name string) bool {
+	for _, s := range Settings {
+		if s.Env == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFlags reads command-line flags and PORTUS_ENV_PREFIX. Flags take
+// precedence over environment variables. It must be called before LoadConfig.
+func ParseFlags(args []string, output io.Writer) error {
+	fs := flag.NewFlagSet("portus", flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	prefix := fs.String("env-prefix", os.Getenv(EnvPrefixVar), "namespace prepended to every Portus environment variable")
+	values := make(map[string]*string, len(Settings))
+	for _, s := range Settings {
+		values[s.Env] = fs.String(FlagName(s.Env), "", s.Usage+" ("+s.Env+")")
+	}
+	keyed := make(map[string]*keyedFlag, len(keyedFlags))
+	for _, k := range keyedFlags {
+		f := &keyedFlag{prefix: k.prefix}
+		keyed[k.flag] = f
+		fs.Var(f, k.flag, k.usage)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	overrides := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		for env, value := range values {
+			if FlagName(env) == f.Name {
+				overrides[env] = *value
+			}
+		}
+		if k, ok := keyed[f.Name]; ok {
+			for name, value := range k.values {
+				overrides[name] = value
+			}
+		}
+	})
+
+	settings = &source{prefix: *prefix, overrides: overrides}
+	return nil
+}
+
+// keyedFlag collects repeatable NAME=value flags into prefixed settings.
+type keyedFlag struct {
+	prefix string
+	values map[string]string
+}
+
+func (k *keyedFlag) String() string { return "" }
+
+func (k *keyedFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected NAME=value, got %q", s)
+	}
+	if k.values == nil {
+		k.values = make(map[string]string)
+	}
+	k.values[k.prefix+strings.ToUpper(name)] = value
+	return nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+func TestFlagName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"PORTUS_PORT":                           "port",
+		"PORTUS_MAX_STREAMS":                    "max-streams",
+		"PORTKEY_GATEWAY_URL":                   "portkey-gateway-url",
+		"PORTUS_SEMANTIC_CACHE_EMBEDDING_ALIAS": "semantic-cache-embedding-alias",
+	}
+	for env, want := range tests {
+		if got := FlagName(env); got != want {
+			t.Errorf("FlagName(%s) = %s, want %s", env, got, want)
+		}
+	}
+}
+
+func TestParseFlags_EnvPrefix(t *testing.T) {
+	t.Cleanup(func() { settings = &source{} })
+	t.Setenv("PORTUS_ENV_PREFIX", "SIDECAR_")
+	t.Setenv("PORTUS_PORT", "3000")
+	t.Setenv("PORTUS_KEY_MAINAPP", "pk-belongs-to-main-app")
+	t.Setenv("SIDECAR_PORTUS_PORT", "9090")
+	t.Setenv("SIDECAR_PORTUS_KEY_AGENT", "pk-agent")
+	t.Setenv("SIDECAR_PORTUS_MAX_STREAMS_AGENT", "3")
+
+	if err := ParseFlags(nil, io.Discard); err != nil {
+		t.Fatalf("ParseFlags() error: %v", err)
+	}
+
+	store := &models.ConfigStore{}
+	if err := loadServerConfig(store); err != nil {
+		t.Fatalf("loadServerConfig() error: %v", err)
+	}
+	if store.ServerPort != 9090 {
+		t.Errorf("expected prefixed port 9090, got %d", store.ServerPort)
+	}
+
+	if err := loadProxyKeys(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadStreamLimits(store); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.ProxyKeys) != 1 || store.ProxyKeys[0].Application != "AGENT" || store.ProxyKeys[0].MaxStreams != 3 {
+		t.Errorf("expected only the prefixed AGENT key, got %+v", store.ProxyKeys)
+	}
+}
+
+func TestParseFlags_Overrides(t *testing.T) {
+	t.Cleanup(func() { settings = &source{} })
+	t.Setenv("PORTUS_PORT", "3000")
+	t.Setenv("PORTUS_LOG_LEVEL", "debug")
+
+	args := []string{"--port", "9191", "--key", "batch=pk-batch", "--admin-key", "ops=admin-secret", "--max-streams-for", "batch=1"}
+	if err := ParseFlags(args, io.Discard); err != nil {
+		t.Fatalf("ParseFlags() error: %v", err)
+	}
+
+	if got := Getenv("PORTUS_PORT"); got != "9191" {
+		t.Errorf("expected flag to win over environment, got %s", got)
+	}
+	if got := Getenv("PORTUS_LOG_LEVEL"); got != "debug" {
+		t.Errorf("expected unflagged setting from environment, got %s", got)
+	}
+
+	store := &models.ConfigStore{}
+	if err := loadProxyKeys(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadStreamLimits(store); err != nil {
+		t.Fatal(err)
+	}
+	scopes := map[string]models.ProxyKey{}
+	for _, pk := range store.ProxyKeys {
+		scopes[pk.Key] = pk
+	}
+	if pk := scopes["pk-batch"]; pk.Application != "BATCH" || pk.Scope != models.ScopeInference || pk.MaxStreams != 1 {
+		t.Errorf("unexpected flag key: %+v", pk)
+	}
+	if pk := scopes["admin-secret"]; pk.Application != "OPS" || pk.Scope != models.ScopeAdmin {
+		t.Errorf("unexpected flag admin key: %+v", pk)
+	}
+
+	if err := ParseFlags([]string{"--key", "no-value"}, io.Discard); err == nil {
+		t.Error("expected malformed keyed flag to fail")
+	}
+	if err := ParseFlags([]string{"serve"}, io.Discard); err == nil {
+		t.Error("expected positional arguments to fail")
+	}
+}
+
+func TestLoadProxyKeys_SkipsSettings(t *testing.T) {
+	t.Setenv("PORTUS_KEY_EXPIRY_WARNING", "24h")
+
+	store := &models.ConfigStore{}
+	if err := loadProxyKeys(store); err != nil {
+		t.Fatal(err)
+	}
+	for _, pk := range store.ProxyKeys {
+		if pk.Application == "EXPIRY_WARNING" {
+			t.Errorf("expected PORTUS_KEY_EXPIRY_WARNING not to be treated as a proxy key")
+		}
+	}
+}
+
+func TestUnknownSettings(t *testing.T) {
+	t.Cleanup(func() { settings = &source{} })
+	t.Setenv("PORTUS_MAX_STREMS", "3")
+	t.Setenv("PORTUS_MAX_STREAMS", "3")
+	t.Setenv("PORTUS_KEY_BACKEND", "pk-backend")
+	t.Setenv("PORTUS_KEY_", "pk-nameless")
+	settings = &source{}
+
+	got := UnknownSettings()
+	if strings.Join(got, ",") != "PORTUS_KEY_,PORTUS_MAX_STREMS" {
+		t.Errorf("expected the misspelled and nameless variables, got %v", got)
+	}
+}
+
+func TestOrphanedSettings(t *testing.T) {
+	t.Cleanup(func() { settings = &source{} })
+	t.Setenv("PORTUS_MAX_STREAMS_BACKEND", "3")
+	t.Setenv("PORTUS_MAX_STREAMS_BAKEND", "3")
+	t.Setenv("PORTUS_MAX_TOKENS_ACTION", "reject")
+	settings = &source{}
+
+	store := &models.ConfigStore{ProxyKeys: []models.ProxyKey{{Application: "BACKEND"}}}
+	got := OrphanedSettings(store)
+	if strings.Join(got, ",") != "PORTUS_MAX_STREAMS_BAKEND" {
+		t.Errorf("expected only the misspelled application, got %v", got)
+	}
+}
@@ -2,16 +2,43 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/amscotti/portus/internal/apiversion"
+	"github.com/amscotti/portus/internal/conversation"
+	"github.com/amscotti/portus/internal/dirwatch"
+	"github.com/amscotti/portus/internal/guardrail"
+	"github.com/amscotti/portus/internal/messagestream"
+	"github.com/amscotti/portus/internal/mock"
 	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/otlp"
+	"github.com/amscotti/portus/internal/pii"
+	"github.com/amscotti/portus/internal/postprocess"
+	"github.com/amscotti/portus/internal/privacy"
+	"github.com/amscotti/portus/internal/quota"
+	"github.com/amscotti/portus/internal/remoteconfig"
+	"github.com/amscotti/portus/internal/secrets"
+	"github.com/amscotti/portus/internal/statsd"
+	"github.com/amscotti/portus/internal/storage"
+	"github.com/amscotti/portus/internal/tokenlimit"
+	"github.com/amscotti/portus/internal/translate"
+	"github.com/amscotti/portus/internal/usage"
+	"github.com/amscotti/portus/internal/verify"
+	"github.com/amscotti/portus/internal/webhook"
 )
 
 const (
@@ -19,10 +46,66 @@ const (
 	defaultConfigPath = "./config"
 	defaultGatewayURL = "http://localhost:8787"
 	defaultLogLevel   = "info"
+
+	defaultLogLevelTimeout = 15 * time.Minute
+
+	defaultStreamProgressInterval = 5 * time.Second
+	defaultTLSReloadInterval      = time.Minute
+	defaultSecretsRefreshInterval = 5 * time.Minute
+	secretsResolveTimeout         = 30 * time.Second
+	defaultRedisStreamLease       = 10 * time.Minute
+	defaultMaxRequestTimeout      = 10 * time.Minute
+
+	defaultMetricsAppBuckets = 8
+	defaultMetricsMinCount   = 5
+
+	defaultProxyRetryBackoff = 200 * time.Millisecond
+
+	defaultCircuitBreakerThreshold = 5
+	defaultCircuitBreakerCooldown  = 30 * time.Second
+
+	defaultCredentialFailureProbeInterval = 5 * time.Minute
+
+	defaultSemanticCacheThreshold  = 0.95
+	defaultSemanticCacheTTL        = time.Hour
+	defaultSemanticCacheMaxEntries = 10000
+
+	defaultKeyExpiryWarning = 7 * 24 * time.Hour
+
+	defaultModelListTTL = 5 * time.Minute
+
+	defaultConversationIdleTimeout = time.Hour
+
+	defaultBodySpillThreshold = 1 << 20
+
+	defaultJobsTTL       = 24 * time.Hour
+	defaultJobsMaxActive = 100
+
+	defaultWebhookRateLimit    = 60
+	defaultWebhookMaxAttempts  = 5
+	defaultWebhookRetryBackoff = time.Second
+
+	defaultRemoteConfigRefreshInterval = time.Minute
+	remoteConfigTimeout                = 30 * time.Second
+
+	defaultOTLPServiceName    = "portus"
+	defaultOTLPMetricInterval = time.Minute
+	defaultStatsDPrefix       = "portus."
+
+	defaultUsageRawRetention    = 24 * time.Hour
+	defaultUsageHourlyRetention = 30 * 24 * time.Hour
+	defaultUsageDailyRetention  = 400 * 24 * time.Hour
+
+	// minProvenanceKeyLength keeps provenance signatures from being forged by
+	// guessing a short key.
+	minProvenanceKeyLength = 32
 )
 
 var (
-	envVarRegex = regexp.MustCompile(`\$\{([^}]+)\}`)
+	// envVarRegex matches ${VAR}; ${scheme:reference} is a secret reference.
+	envVarRegex = regexp.MustCompile(`\$\{([^}:]+)\}`)
+	// tagNameRegex matches the tag names telemetry backends accept.
+	tagNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_.-]*$`)
 )
 
 // LoadConfig loads all configuration from files and environment variables.
@@ -39,14 +122,48 @@ func LoadConfig() (*models.ConfigStore, error) {
 		return nil, fmt.Errorf("failed to load server config: %w", err)
 	}
 
-	// Load proxy keys from environment
-	loadProxyKeys(store)
+	// Download a config path kept in S3, GCS, Consul or etcd, which may
+	// hold key files
+	if err := loadRemoteConfig(store); err != nil {
+		return nil, fmt.Errorf("failed to download remote config: %w", err)
+	}
+	// A watched directory is fingerprinted before it is read, so a change
+	// made while loading is picked up on the first check
+	if err := watchConfigDir(store); err != nil {
+		return nil, fmt.Errorf("failed to watch config directory: %w", err)
+	}
+
+	// Load proxy keys and their limits
+	if err := loadKeys(store); err != nil {
+		return nil, err
+	}
+	if err := loadPIIPolicy(store); err != nil {
+		return nil, fmt.Errorf("failed to load PII policy: %w", err)
+	}
+	if err := loadWebhooks(store); err != nil {
+		return nil, fmt.Errorf("failed to load webhooks: %w", err)
+	}
 
 	// Load model configurations from files
 	if err := loadModelConfigs(store); err != nil {
 		return nil, fmt.Errorf("failed to load model configs: %w", err)
 	}
 
+	// Load the optional global pricing table
+	if err := loadPricing(store); err != nil {
+		return nil, fmt.Errorf("failed to load pricing: %w", err)
+	}
+
+	// Load the optional per-application guardrails
+	if err := loadGuardrails(store); err != nil {
+		return nil, fmt.Errorf("failed to load guardrails: %w", err)
+	}
+
+	// Load the optional per-application spend budgets
+	if err := loadBudgets(store); err != nil {
+		return nil, fmt.Errorf("failed to load budgets: %w", err)
+	}
+
 	return store, nil
 }
 
@@ -54,8 +171,14 @@ func LoadConfig() (*models.ConfigStore, error) {
 func ValidateConfig(store *models.ConfigStore) []error {
 	var errors []error
 
-	// Validate proxy keys
-	if len(store.ProxyKeys) == 0 {
+	// Validate proxy keys (observability tokens cannot call models, so they don't count)
+	inferenceKeys := 0
+	for _, pk := range store.ProxyKeys {
+		if pk.Scope == models.ScopeInference {
+			inferenceKeys++
+		}
+	}
+	if inferenceKeys == 0 {
 		errors = append(errors, fmt.Errorf("no proxy keys configured: at least one PORTUS_KEY_* environment variable is required"))
 	}
 
@@ -64,6 +187,70 @@ func ValidateConfig(store *models.ConfigStore) []error {
 		errors = append(errors, fmt.Errorf("no model configurations found in %s", store.ConfigPath))
 	}
 
+	// Validate TLS
+	if (store.TLSCert == "") != (store.TLSKey == "") {
+		errors = append(errors, fmt.Errorf("PORTUS_TLS_CERT and PORTUS_TLS_KEY must be set together"))
+	}
+	if (store.GatewayTLSCert == "") != (store.GatewayTLSKey == "") {
+		errors = append(errors, fmt.Errorf("PORTUS_GATEWAY_TLS_CERT and PORTUS_GATEWAY_TLS_KEY must be set together"))
+	}
+
+	// Validate canary aliases
+	for _, alias := range store.CanaryAliases {
+		if _, ok := store.Models[alias]; !ok {
+			errors = append(errors, fmt.Errorf("PORTUS_CANARY_ALIASES references unknown model alias: %s", alias))
+		}
+	}
+
+	// Validate canary aliases, which receive traffic but do not pass it on
+	for alias, model := range store.Models {
+		if model.Canary == nil {
+			continue
+		}
+		if canary, ok := store.Models[model.Canary.Alias]; !ok {
+			errors = append(errors, fmt.Errorf("model %s canary references unknown model alias: %s", alias, model.Canary.Alias))
+		} else if canary.Canary != nil {
+			errors = append(errors, fmt.Errorf("model %s canary alias %s has its own canary", alias, model.Canary.Alias))
+		}
+	}
+
+	// Validate experiment variants, which do not run experiments of their own
+	for alias, model := range store.Models {
+		if model.Experiment == nil {
+			continue
+		}
+		for _, variant := range []string{model.Experiment.A, model.Experiment.B} {
+			if variant == alias {
+				continue
+			}
+			if v, ok := store.Models[variant]; !ok {
+				errors = append(errors, fmt.Errorf("model %s experiment references unknown model alias: %s", alias, variant))
+			} else if v.Experiment != nil {
+				errors = append(errors, fmt.Errorf("model %s experiment variant %s has its own experiment", alias, variant))
+			}
+		}
+	}
+
+	// Validate default model alias
+	if store.DefaultModel != "" {
+		if _, ok := store.Models[store.DefaultModel]; !ok {
+			errors = append(errors, fmt.Errorf("PORTUS_DEFAULT_MODEL references unknown model alias: %s", store.DefaultModel))
+		}
+	}
+
+	// Validate semantic cache aliases
+	if store.SemanticCacheAlias != "" {
+		if _, ok := store.Models[store.SemanticCacheAlias]; !ok {
+			errors = append(errors, fmt.Errorf("PORTUS_SEMANTIC_CACHE_EMBEDDING_ALIAS references unknown model alias: %s", store.SemanticCacheAlias))
+		}
+	} else {
+		for alias, model := range store.Models {
+			if model.SemanticCache {
+				errors = append(errors, fmt.Errorf("model %s enables semantic_cache but PORTUS_SEMANTIC_CACHE_EMBEDDING_ALIAS is not set", alias))
+			}
+		}
+	}
+
 	// Check for missing environment variables using stored raw configs
 	missingVars := make(map[string][]string) // var name -> list of files referencing it
 
@@ -71,7 +258,8 @@ func ValidateConfig(store *models.ConfigStore) []error {
 		checkMissingEnvVars(alias, rawContent, missingVars)
 	}
 
-	if len(missingVars) > 0 {
+	// Mock mode never contacts a provider, so credentials may be left unset
+	if len(missingVars) > 0 && store.MockMode == "" {
 		for varName, files := range missingVars {
 			errors = append(errors, fmt.Errorf("missing environment variable: %s (referenced in: %s)",
 				varName, strings.Join(files, ", ")))
@@ -80,7 +268,36 @@ func ValidateConfig(store *models.ConfigStore) []error {
 
 	// Validate each model configuration
 	for alias, model := range store.Models {
-		if err := validateModelConfig(alias, model); err != nil {
+		if err := validateModelConfig(alias, model); err != nil && !(store.MockMode != "" && isCredentialsError(err)) {
+			errors = append(errors, err)
+		}
+		if err := CheckBannedModels(alias, model, store.BannedModels); err != nil {
+			errors = append(errors, err)
+		}
+		if model.Pricing != nil {
+			if err := validatePricing(alias+".json", *model.Pricing); err != nil {
+				errors = append(errors, err)
+			}
+		}
+	}
+
+	// Validate per-application guardrails
+	for application, rules := range store.Guardrails {
+		if err := guardrail.Validate(rules); err != nil {
+			errors = append(errors, fmt.Errorf("guardrails.json entry %s: %w", application, err))
+		}
+	}
+
+	// Validate per-application budgets
+	for application, budget := range store.Budgets {
+		if budget.DailyUSD < 0 || budget.MonthlyUSD < 0 {
+			errors = append(errors, fmt.Errorf("budgets.json entry %s: budgets must not be negative", application))
+		}
+	}
+
+	// Validate global pricing entries
+	for name, pricing := range store.Pricing {
+		if err := validatePricing("pricing.json entry "+name, pricing); err != nil {
 			errors = append(errors, err)
 		}
 	}
@@ -93,7 +310,7 @@ func ValidateConfig(store *models.ConfigStore) []error {
 
 func loadServerConfig(store *models.ConfigStore) error {
 	// Port
-	portStr := os.Getenv("PORTUS_PORT")
+	portStr := Getenv("PORTUS_PORT")
 	if portStr == "" {
 		store.ServerPort = defaultPort
 	} else {
@@ -104,155 +321,1885 @@ func loadServerConfig(store *models.ConfigStore) error {
 		store.ServerPort = port
 	}
 
+	// Separate listener for health, stats, pprof and admin endpoints
+	store.AdminAddr = Getenv("PORTUS_ADMIN_ADDR")
+	if store.AdminAddr != "" {
+		_, port, err := net.SplitHostPort(store.AdminAddr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_ADMIN_ADDR value: %s", store.AdminAddr)
+		}
+		if port == strconv.Itoa(store.ServerPort) {
+			return fmt.Errorf("invalid PORTUS_ADMIN_ADDR value: port %s is the proxy port", port)
+		}
+	}
+
+	// gRPC listener for the control plane, in binaries built with -tags grpc
+	store.ControlPlaneGRPCAddr = Getenv("PORTUS_CONTROL_PLANE_GRPC_ADDR")
+	if store.ControlPlaneGRPCAddr != "" {
+		_, port, err := net.SplitHostPort(store.ControlPlaneGRPCAddr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_CONTROL_PLANE_GRPC_ADDR value: %s", store.ControlPlaneGRPCAddr)
+		}
+		if port == strconv.Itoa(store.ServerPort) {
+			return fmt.Errorf("invalid PORTUS_CONTROL_PLANE_GRPC_ADDR value: port %s is the proxy port", port)
+		}
+		if store.AdminAddr != "" {
+			if _, adminPort, _ := net.SplitHostPort(store.AdminAddr); port == adminPort {
+				return fmt.Errorf("invalid PORTUS_CONTROL_PLANE_GRPC_ADDR value: port %s is the admin port", port)
+			}
+		}
+	}
+
 	// Config path
-	store.ConfigPath = os.Getenv("PORTUS_CONFIG_PATH")
-	if store.ConfigPath == "" {
-		store.ConfigPath = defaultConfigPath
+	store.ConfigPath = ConfigPath()
+	store.RemoteConfigRefreshInterval = defaultRemoteConfigRefreshInterval
+	if intervalStr := Getenv("PORTUS_REMOTE_CONFIG_REFRESH_INTERVAL"); intervalStr != "" {
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid PORTUS_REMOTE_CONFIG_REFRESH_INTERVAL value: %s", intervalStr)
+		}
+		store.RemoteConfigRefreshInterval = interval
+	}
+	if intervalStr := Getenv("PORTUS_CONFIG_WATCH_INTERVAL"); intervalStr != "" {
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid PORTUS_CONFIG_WATCH_INTERVAL value: %s", intervalStr)
+		}
+		if interval > 0 && remoteconfig.IsRemote(store.ConfigPath) {
+			return errors.New("PORTUS_CONFIG_WATCH_INTERVAL watches a local config directory; remote config paths use PORTUS_REMOTE_CONFIG_REFRESH_INTERVAL")
+		}
+		store.ConfigWatchInterval = interval
 	}
 
 	// Gateway URL
-	store.GatewayURL = os.Getenv("PORTKEY_GATEWAY_URL")
+	store.GatewayURL = Getenv("PORTKEY_GATEWAY_URL")
 	if store.GatewayURL == "" {
 		store.GatewayURL = defaultGatewayURL
 	}
+	for _, rule := range splitList(Getenv("PORTUS_GATEWAY_ROUTES")) {
+		pattern, gatewayURL, ok := strings.Cut(rule, "=")
+		pattern, gatewayURL = strings.TrimSpace(pattern), strings.TrimRight(strings.TrimSpace(gatewayURL), "/")
+		if u, err := url.Parse(gatewayURL); !ok || pattern == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid PORTUS_GATEWAY_ROUTES value: %q is not pattern=http(s)://host", rule)
+		}
+		route, err := models.NewGatewayRoute(pattern, gatewayURL)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_GATEWAY_ROUTES value: pattern %q: %w", pattern, err)
+		}
+		store.GatewayRoutes = append(store.GatewayRoutes, route)
+	}
+
+	// Listener and gateway TLS
+	store.TLSCert = Getenv("PORTUS_TLS_CERT")
+	store.TLSKey = Getenv("PORTUS_TLS_KEY")
+	store.GatewayTLSCert = Getenv("PORTUS_GATEWAY_TLS_CERT")
+	store.GatewayTLSKey = Getenv("PORTUS_GATEWAY_TLS_KEY")
+	store.GatewayTLSCA = Getenv("PORTUS_GATEWAY_TLS_CA")
+	store.TLSReloadInterval = defaultTLSReloadInterval
+	if reloadStr := Getenv("PORTUS_TLS_RELOAD_INTERVAL"); reloadStr != "" {
+		interval, err := time.ParseDuration(reloadStr)
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid PORTUS_TLS_RELOAD_INTERVAL value: %s", reloadStr)
+		}
+		store.TLSReloadInterval = interval
+	}
+
+	// Certificates obtained over ACME, in binaries built with -tags acme
+	if domainsStr := Getenv("PORTUS_ACME_DOMAINS"); domainsStr != "" {
+		if store.TLSCert != "" {
+			return fmt.Errorf("PORTUS_ACME_DOMAINS cannot be used with PORTUS_TLS_CERT")
+		}
+		for _, domain := range strings.Split(domainsStr, ",") {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			// TLS-ALPN-01 cannot validate wildcards
+			if domain == "" || strings.ContainsAny(domain, "*:/") {
+				return fmt.Errorf("invalid PORTUS_ACME_DOMAINS value: %q", domain)
+			}
+			store.ACMEDomains = append(store.ACMEDomains, domain)
+		}
+		store.ACMECacheDir = Getenv("PORTUS_ACME_CACHE_DIR")
+		if store.ACMECacheDir == "" {
+			return fmt.Errorf("PORTUS_ACME_CACHE_DIR is required with PORTUS_ACME_DOMAINS, so certificates survive restarts")
+		}
+		store.ACMEEmail = Getenv("PORTUS_ACME_EMAIL")
+		store.ACMEDirectoryURL = Getenv("PORTUS_ACME_DIRECTORY_URL")
+		if store.ACMEDirectoryURL != "" && !strings.HasPrefix(store.ACMEDirectoryURL, "https://") {
+			return fmt.Errorf("invalid PORTUS_ACME_DIRECTORY_URL value: %s", store.ACMEDirectoryURL)
+		}
+	}
+
+	// Secret references are fetched again to pick up rotations
+	store.SecretsRefreshInterval = defaultSecretsRefreshInterval
+	if refreshStr := Getenv("PORTUS_SECRETS_REFRESH_INTERVAL"); refreshStr != "" {
+		interval, err := time.ParseDuration(refreshStr)
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid PORTUS_SECRETS_REFRESH_INTERVAL value: %s", refreshStr)
+		}
+		store.SecretsRefreshInterval = interval
+	}
+
+	// HTTP/2 without TLS, with prior knowledge
+	if h2cStr := Getenv("PORTUS_H2C"); h2cStr != "" {
+		enabled, err := strconv.ParseBool(h2cStr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_H2C value: %s", h2cStr)
+		}
+		if enabled && store.TLSCert != "" {
+			return fmt.Errorf("PORTUS_H2C cannot be used with PORTUS_TLS_CERT; the HTTPS listener already serves HTTP/2")
+		}
+		if enabled && len(store.ACMEDomains) > 0 {
+			return fmt.Errorf("PORTUS_H2C cannot be used with PORTUS_ACME_DOMAINS; the HTTPS listener already serves HTTP/2")
+		}
+		store.H2C = enabled
+	}
+	if h2cStr := Getenv("PORTUS_GATEWAY_H2C"); h2cStr != "" {
+		enabled, err := strconv.ParseBool(h2cStr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_GATEWAY_H2C value: %s", h2cStr)
+		}
+		for _, gatewayURL := range store.GatewayURLs() {
+			if enabled && !strings.HasPrefix(gatewayURL, "http://") {
+				return fmt.Errorf("PORTUS_GATEWAY_H2C requires http:// gateway URLs, got %s; HTTPS gateways negotiate HTTP/2 themselves", gatewayURL)
+			}
+		}
+		store.GatewayH2C = enabled
+	}
+
+	// Read-only mode freezes runtime configuration changes from startup
+	if readOnlyStr := Getenv("PORTUS_READ_ONLY"); readOnlyStr != "" {
+		readOnly, err := strconv.ParseBool(readOnlyStr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_READ_ONLY value: %s", readOnlyStr)
+		}
+		store.ReadOnly = readOnly
+	}
 
 	// Log level
-	store.LogLevel = os.Getenv("PORTUS_LOG_LEVEL")
+	store.LogLevel = Getenv("PORTUS_LOG_LEVEL")
 	if store.LogLevel == "" {
 		store.LogLevel = defaultLogLevel
 	}
 
-	return nil
-}
-
-func loadProxyKeys(store *models.ConfigStore) {
-	for _, env := range os.Environ() {
-		parts := strings.SplitN(env, "=", 2)
-		if len(parts) != 2 {
-			continue
+	// How long a runtime log level change lasts before reverting
+	store.LogLevelTimeout = defaultLogLevelTimeout
+	if timeoutStr := Getenv("PORTUS_LOG_LEVEL_TIMEOUT"); timeoutStr != "" {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid PORTUS_LOG_LEVEL_TIMEOUT value: %s", timeoutStr)
 		}
+		store.LogLevelTimeout = timeout
+	}
 
-		key := parts[0]
-		value := parts[1]
-
-		if strings.HasPrefix(key, "PORTUS_KEY_") {
-			appName := strings.TrimPrefix(key, "PORTUS_KEY_")
-			store.ProxyKeys = append(store.ProxyKeys, models.ProxyKey{
-				Key:         value,
-				Application: appName,
-			})
+	// Proxy key expiry warning window
+	store.KeyExpiryWarning = defaultKeyExpiryWarning
+	if warnStr := Getenv("PORTUS_KEY_EXPIRY_WARNING"); warnStr != "" {
+		warning, err := time.ParseDuration(warnStr)
+		if err != nil || warning < 0 {
+			return fmt.Errorf("invalid PORTUS_KEY_EXPIRY_WARNING value: %s", warnStr)
 		}
+		store.KeyExpiryWarning = warning
 	}
-}
 
-func loadModelConfigs(store *models.ConfigStore) error {
-	modelsDir := filepath.Join(store.ConfigPath, "models")
+	// Persistent usage records
+	store.UsageDBPath = Getenv("PORTUS_USAGE_DB")
 
-	entries, err := os.ReadDir(modelsDir)
+	// Metrics privacy mode
+	mode, err := privacy.ParseMode(Getenv("PORTUS_METRICS_MODE"))
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Models directory doesn't exist, which is ok - we'll just have no models
-			return nil
+		return fmt.Errorf("invalid PORTUS_METRICS_MODE value: %w", err)
+	}
+	store.MetricsMode = mode
+	store.MetricsAppBuckets = defaultMetricsAppBuckets
+	if Getenv("PORTUS_METRICS_APP_BUCKETS") != "" {
+		buckets, err := parseNonNegativeInt("PORTUS_METRICS_APP_BUCKETS")
+		if err != nil || buckets == 0 {
+			return fmt.Errorf("invalid PORTUS_METRICS_APP_BUCKETS value: %s", Getenv("PORTUS_METRICS_APP_BUCKETS"))
+		}
+		store.MetricsAppBuckets = buckets
+	}
+	store.MetricsMinCount = defaultMetricsMinCount
+	if Getenv("PORTUS_METRICS_MIN_COUNT") != "" {
+		if store.MetricsMinCount, err = parseNonNegativeInt("PORTUS_METRICS_MIN_COUNT"); err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to read models directory: %w", err)
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
-			continue
+	// Shutdown report destination
+	store.ReportDir = Getenv("PORTUS_REPORT_DIR")
+
+	// Shared limit state
+	store.RedisURL = Getenv("PORTUS_REDIS_URL")
+	store.RedisStreamLease = defaultRedisStreamLease
+	if leaseStr := Getenv("PORTUS_REDIS_STREAM_LEASE"); leaseStr != "" {
+		lease, err := time.ParseDuration(leaseStr)
+		if err != nil || lease <= 0 {
+			return fmt.Errorf("invalid PORTUS_REDIS_STREAM_LEASE value: %s", leaseStr)
 		}
+		store.RedisStreamLease = lease
+	}
 
-		alias := strings.TrimSuffix(entry.Name(), ".json")
-		path := filepath.Join(modelsDir, entry.Name())
+	// State storage backend; Redis when a Redis URL is set, so limits keep
+	// being shared across replicas without also setting PORTUS_STORAGE
+	kind, err := storage.ParseKind(Getenv("PORTUS_STORAGE"))
+	if err != nil {
+		return fmt.Errorf("invalid PORTUS_STORAGE value: %s", Getenv("PORTUS_STORAGE"))
+	}
+	if Getenv("PORTUS_STORAGE") == "" && store.RedisURL != "" {
+		kind = storage.KindRedis
+	}
+	store.Storage = kind
+	store.StoragePath = Getenv("PORTUS_STORAGE_PATH")
+	switch {
+	case kind == storage.KindRedis && store.RedisURL == "":
+		return fmt.Errorf("PORTUS_STORAGE=redis requires PORTUS_REDIS_URL")
+	case kind == storage.KindSQLite && store.StoragePath == "":
+		return fmt.Errorf("PORTUS_STORAGE=sqlite requires PORTUS_STORAGE_PATH")
+	}
 
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("failed to read model config %s: %w", path, err)
+	// Usage history retention
+	store.UsageRetention = usage.Retention{
+		Raw:    defaultUsageRawRetention,
+		Hourly: defaultUsageHourlyRetention,
+		Daily:  defaultUsageDailyRetention,
+	}
+	retentions := []struct {
+		env   string
+		value *time.Duration
+	}{
+		{"PORTUS_USAGE_RAW_RETENTION", &store.UsageRetention.Raw},
+		{"PORTUS_USAGE_HOURLY_RETENTION", &store.UsageRetention.Hourly},
+		{"PORTUS_USAGE_DAILY_RETENTION", &store.UsageRetention.Daily},
+	}
+	for _, r := range retentions {
+		if str := Getenv(r.env); str != "" {
+			retention, err := time.ParseDuration(str)
+			if err != nil || retention <= 0 {
+				return fmt.Errorf("invalid %s value: %s", r.env, str)
+			}
+			*r.value = retention
 		}
+	}
 
-		// Store raw content before expansion for env var checking during validation
-		store.RawConfigs[alias] = string(data)
+	// Stream progress sampling interval
+	intervalStr := Getenv("PORTUS_STREAM_PROGRESS_INTERVAL")
+	if intervalStr == "" {
+		store.StreamProgressInterval = defaultStreamProgressInterval
+	} else {
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid PORTUS_STREAM_PROGRESS_INTERVAL value: %s", intervalStr)
+		}
+		store.StreamProgressInterval = interval
+	}
 
-		// Expand environment variables
-		expandedData := expandEnvVars(string(data))
+	// Absolute stream age limit enforced by the reaper
+	if maxAgeStr := Getenv("PORTUS_STREAM_MAX_AGE"); maxAgeStr != "" {
+		maxAge, err := time.ParseDuration(maxAgeStr)
+		if err != nil || maxAge < 0 {
+			return fmt.Errorf("invalid PORTUS_STREAM_MAX_AGE value: %s", maxAgeStr)
+		}
+		store.StreamMaxAge = maxAge
+	}
 
-		var config models.ModelConfig
-		if err := json.Unmarshal([]byte(expandedData), &config); err != nil {
-			return fmt.Errorf("failed to parse model config %s: %w", path, err)
+	// Synthetic canaries
+	if canaryStr := Getenv("PORTUS_CANARY_INTERVAL"); canaryStr != "" {
+		interval, err := time.ParseDuration(canaryStr)
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid PORTUS_CANARY_INTERVAL value: %s", canaryStr)
 		}
+		store.CanaryInterval = interval
+	}
+	store.CanaryAliases = splitList(Getenv("PORTUS_CANARY_ALIASES"))
 
-		store.Models[alias] = config
+	// Semantic cache
+	store.SemanticCacheAlias = Getenv("PORTUS_SEMANTIC_CACHE_EMBEDDING_ALIAS")
+	store.SemanticCacheThreshold = defaultSemanticCacheThreshold
+	if thresholdStr := Getenv("PORTUS_SEMANTIC_CACHE_THRESHOLD"); thresholdStr != "" {
+		threshold, err := strconv.ParseFloat(thresholdStr, 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			return fmt.Errorf("invalid PORTUS_SEMANTIC_CACHE_THRESHOLD value: %s (must be in (0, 1])", thresholdStr)
+		}
+		store.SemanticCacheThreshold = threshold
+	}
+	store.SemanticCacheTTL = defaultSemanticCacheTTL
+	if ttlStr := Getenv("PORTUS_SEMANTIC_CACHE_TTL"); ttlStr != "" {
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid PORTUS_SEMANTIC_CACHE_TTL value: %s", ttlStr)
+		}
+		store.SemanticCacheTTL = ttl
+	}
+	store.SemanticCacheMaxEntries = defaultSemanticCacheMaxEntries
+	if Getenv("PORTUS_SEMANTIC_CACHE_MAX_ENTRIES") != "" {
+		maxEntries, err := parseNonNegativeInt("PORTUS_SEMANTIC_CACHE_MAX_ENTRIES")
+		if err != nil {
+			return err
+		}
+		store.SemanticCacheMaxEntries = maxEntries
 	}
 
-	return nil
-}
+	// Live provider model lists
+	store.ModelListTTL = defaultModelListTTL
+	if ttlStr := Getenv("PORTUS_MODEL_LIST_TTL"); ttlStr != "" {
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid PORTUS_MODEL_LIST_TTL value: %s", ttlStr)
+		}
+		store.ModelListTTL = ttl
+	}
 
-func expandEnvVars(content string) string {
-	return envVarRegex.ReplaceAllStringFunc(content, func(match string) string {
-		// Extract variable name from ${VAR_NAME}
-		varName := match[2 : len(match)-1]
-		value := os.Getenv(varName)
-		return value
-	})
-}
+	// Edge retries
+	if store.ProxyRetries, err = parseNonNegativeInt("PORTUS_PROXY_RETRIES"); err != nil {
+		return err
+	}
+	store.ProxyRetryBackoff = defaultProxyRetryBackoff
+	if backoffStr := Getenv("PORTUS_PROXY_RETRY_BACKOFF"); backoffStr != "" {
+		backoff, err := time.ParseDuration(backoffStr)
+		if err != nil || backoff < 0 {
+			return fmt.Errorf("invalid PORTUS_PROXY_RETRY_BACKOFF value: %s", backoffStr)
+		}
+		store.ProxyRetryBackoff = backoff
+	}
 
-func checkMissingEnvVars(alias string, rawContent string, missingVars map[string][]string) {
-	matches := envVarRegex.FindAllStringSubmatch(rawContent, -1)
+	// Per-alias circuit breaker
+	store.CircuitBreakerThreshold = defaultCircuitBreakerThreshold
+	if Getenv("PORTUS_CIRCUIT_BREAKER_THRESHOLD") != "" {
+		threshold, err := parseNonNegativeInt("PORTUS_CIRCUIT_BREAKER_THRESHOLD")
+		if err != nil {
+			return err
+		}
+		store.CircuitBreakerThreshold = threshold
+	}
+	store.CircuitBreakerCooldown = defaultCircuitBreakerCooldown
+	if cooldownStr := Getenv("PORTUS_CIRCUIT_BREAKER_COOLDOWN"); cooldownStr != "" {
+		cooldown, err := time.ParseDuration(cooldownStr)
+		if err != nil || cooldown <= 0 {
+			return fmt.Errorf("invalid PORTUS_CIRCUIT_BREAKER_COOLDOWN value: %s", cooldownStr)
+		}
+		store.CircuitBreakerCooldown = cooldown
+	}
 
-	for _, match := range matches {
-		if len(match) >= 2 {
-			varName := match[1]
-			if os.Getenv(varName) == "" {
-				missingVars[varName] = append(missingVars[varName], alias+".json")
-			}
+	// Aliases degraded by upstream credential failures
+	if Getenv("PORTUS_CREDENTIAL_FAILURE_THRESHOLD") != "" {
+		threshold, err := parseNonNegativeInt("PORTUS_CREDENTIAL_FAILURE_THRESHOLD")
+		if err != nil {
+			return err
 		}
+		store.CredentialFailureThreshold = threshold
+	}
+	store.CredentialFailureProbeInterval = defaultCredentialFailureProbeInterval
+	if intervalStr := Getenv("PORTUS_CREDENTIAL_FAILURE_PROBE_INTERVAL"); intervalStr != "" {
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid PORTUS_CREDENTIAL_FAILURE_PROBE_INTERVAL value: %s", intervalStr)
+		}
+		store.CredentialFailureProbeInterval = interval
+	}
+	if hideStr := Getenv("PORTUS_CREDENTIAL_FAILURE_HIDE_MODELS"); hideStr != "" {
+		hide, err := strconv.ParseBool(hideStr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_CREDENTIAL_FAILURE_HIDE_MODELS value: %s", hideStr)
+		}
+		store.CredentialFailureHideModels = hide
 	}
-}
 
-func validateModelConfig(alias string, model models.ModelConfig) error {
-	// Check if using strategy/targets or single provider
-	if model.Strategy != nil {
-		// Multi-target configuration
-		if len(model.Targets) == 0 {
-			return fmt.Errorf("model %s has strategy but no targets", alias)
+	// Server-wide request timeout ceiling
+	store.MaxRequestTimeout = defaultMaxRequestTimeout
+	if maxStr := Getenv("PORTUS_MAX_REQUEST_TIMEOUT"); maxStr != "" {
+		ceiling, err := time.ParseDuration(maxStr)
+		if err != nil || ceiling < 0 {
+			return fmt.Errorf("invalid PORTUS_MAX_REQUEST_TIMEOUT value: %s", maxStr)
 		}
-		if model.Strategy.Mode != "fallback" && model.Strategy.Mode != "loadbalance" {
-			return fmt.Errorf("model %s has invalid strategy mode: %s (must be 'fallback' or 'loadbalance')", alias, model.Strategy.Mode)
+		store.MaxRequestTimeout = ceiling
+	}
+
+	// Shutdown drain delay
+	if delayStr := Getenv("PORTUS_SHUTDOWN_DRAIN_DELAY"); delayStr != "" {
+		delay, err := time.ParseDuration(delayStr)
+		if err != nil || delay < 0 {
+			return fmt.Errorf("invalid PORTUS_SHUTDOWN_DRAIN_DELAY value: %s", delayStr)
 		}
+		store.ShutdownDrainDelay = delay
+	}
 
-		// Validate each target
-		for i, target := range model.Targets {
-			if target.Provider == "" {
-				return fmt.Errorf("model %s target %d has no provider", alias, i)
-			}
-			if err := validateProviderConfig(alias, target.Provider, i, target); err != nil {
-				return err
-			}
+	// Response hash logging
+	if hashStr := Getenv("PORTUS_LOG_RESPONSE_HASH"); hashStr != "" {
+		enabled, err := strconv.ParseBool(hashStr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_LOG_RESPONSE_HASH value: %s", hashStr)
 		}
-	} else {
-		// Single provider configuration
-		if model.Provider == "" {
-			return fmt.Errorf("model %s has no provider (and no strategy/targets)", alias)
+		store.LogResponseHash = enabled
+	}
+
+	// Static fallback completion
+	store.FallbackMessage = Getenv("PORTUS_FALLBACK_MESSAGE")
+
+	// Finish reason normalization
+	if normalizeStr := Getenv("PORTUS_NORMALIZE_FINISH_REASONS"); normalizeStr != "" {
+		enabled, err := strconv.ParseBool(normalizeStr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_NORMALIZE_FINISH_REASONS value: %s", normalizeStr)
 		}
-		if err := validateSingleProviderConfig(alias, model); err != nil {
-			return err
+		store.NormalizeFinishReasons = enabled
+	}
+
+	// Anthropic stream event sequence validation
+	validation, err := messagestream.ParseMode(Getenv("PORTUS_MESSAGE_STREAM_VALIDATION"))
+	if err != nil {
+		return fmt.Errorf("invalid PORTUS_MESSAGE_STREAM_VALIDATION value: %w", err)
+	}
+	store.MessageStreamValidation = validation
+
+	// Response compression
+	if gzipStr := Getenv("PORTUS_GZIP_RESPONSES"); gzipStr != "" {
+		enabled, err := strconv.ParseBool(gzipStr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_GZIP_RESPONSES value: %s", gzipStr)
 		}
+		store.GzipResponses = enabled
 	}
 
-	return nil
+	// Routing decision annotation
+	if annotateStr := Getenv("PORTUS_ANNOTATE_RESPONSES"); annotateStr != "" {
+		enabled, err := strconv.ParseBool(annotateStr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_ANNOTATE_RESPONSES value: %s", annotateStr)
+		}
+		store.AnnotateResponses = enabled
+	}
+
+	// Default model alias
+	store.DefaultModel = Getenv("PORTUS_DEFAULT_MODEL")
+
+	// Provider models no alias may resolve to
+	store.BannedModels = splitList(Getenv("PORTUS_BANNED_MODELS"))
+	for _, pattern := range store.BannedModels {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid PORTUS_BANNED_MODELS value: %s", pattern)
+		}
+	}
+
+	// Mock gateway for client integration tests
+	mockMode, err := mock.ParseMode(Getenv("PORTUS_MOCK_MODE"))
+	if err != nil {
+		return fmt.Errorf("invalid PORTUS_MOCK_MODE value: %w", err)
+	}
+	store.MockMode = mockMode
+	store.MockResponse = Getenv("PORTUS_MOCK_RESPONSE")
+
+	// Request/response recording for replay
+	store.RecordFile = Getenv("PORTUS_RECORD_FILE")
+
+	// Temp-file spillover for large upload bodies
+	if err := loadBodySpillSettings(store); err != nil {
+		return err
+	}
+
+	// Asynchronous jobs
+	if err := loadJobsSettings(store); err != nil {
+		return err
+	}
+
+	// Signed provenance header on responses
+	store.ProvenanceKey = Getenv("PORTUS_PROVENANCE_KEY")
+	if store.ProvenanceKey != "" && len(store.ProvenanceKey) < minProvenanceKeyLength {
+		return fmt.Errorf("invalid PORTUS_PROVENANCE_KEY value: must be at least %d characters", minProvenanceKeyLength)
+	}
+
+	if err := loadStatsDSettings(store); err != nil {
+		return err
+	}
+	return loadOTLPSettings(store)
+}
+
+// loadBodySpillSettings reads the size past which request bodies are buffered
+// in a temporary file, and the directory receiving them.
+func loadBodySpillSettings(store *models.ConfigStore) error {
+	store.BodySpillThreshold = defaultBodySpillThreshold
+	if Getenv("PORTUS_BODY_SPILL_THRESHOLD") != "" {
+		threshold, err := parseNonNegativeInt("PORTUS_BODY_SPILL_THRESHOLD")
+		if err != nil {
+			return err
+		}
+		store.BodySpillThreshold = int64(threshold)
+	}
+
+	store.BodySpillDir = Getenv("PORTUS_BODY_SPILL_DIR")
+	if store.BodySpillDir != "" {
+		if info, err := os.Stat(store.BodySpillDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("invalid PORTUS_BODY_SPILL_DIR value: %s is not a directory", store.BodySpillDir)
+		}
+	}
+	return nil
+}
+
+// loadJobsSettings reads how asynchronous jobs are kept and limited.
+func loadJobsSettings(store *models.ConfigStore) error {
+	// Jobs moved into the state storage backend
+	if Getenv("PORTUS_JOBS_FILE") != "" {
+		return fmt.Errorf("PORTUS_JOBS_FILE is no longer supported; asynchronous jobs are kept in the PORTUS_STORAGE backend, set PORTUS_STORAGE=sqlite to keep them across restarts")
+	}
+
+	store.JobsTTL = defaultJobsTTL
+	if ttlStr := Getenv("PORTUS_JOBS_TTL"); ttlStr != "" {
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid PORTUS_JOBS_TTL value: %s", ttlStr)
+		}
+		store.JobsTTL = ttl
+	}
+
+	store.JobsMaxActive = defaultJobsMaxActive
+	if Getenv("PORTUS_JOBS_MAX_ACTIVE") != "" {
+		maxActive, err := parseNonNegativeInt("PORTUS_JOBS_MAX_ACTIVE")
+		if err != nil {
+			return err
+		}
+		store.JobsMaxActive = maxActive
+	}
+
+	store.JobsWebhookHosts = splitList(Getenv("PORTUS_JOBS_WEBHOOK_HOSTS"))
+	for _, pattern := range store.JobsWebhookHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid PORTUS_JOBS_WEBHOOK_HOSTS value: %s", pattern)
+		}
+	}
+	return nil
+}
+
+// loadStatsDSettings reads the StatsD metrics sink settings.
+func loadStatsDSettings(store *models.ConfigStore) error {
+	store.StatsDAddr = Getenv("PORTUS_STATSD_ADDR")
+	if store.StatsDAddr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(store.StatsDAddr); err != nil {
+		return fmt.Errorf("invalid PORTUS_STATSD_ADDR value: %s", store.StatsDAddr)
+	}
+
+	store.StatsDPrefix = defaultStatsDPrefix
+	if prefix := Getenv("PORTUS_STATSD_PREFIX"); prefix != "" {
+		store.StatsDPrefix = prefix
+	}
+
+	format, err := statsd.ParseFormat(Getenv("PORTUS_STATSD_FORMAT"))
+	if err != nil {
+		return fmt.Errorf("invalid PORTUS_STATSD_FORMAT value: %w", err)
+	}
+	store.StatsDFormat = format
+	return nil
+}
+
+// loadOTLPSettings reads the OpenTelemetry export settings. Logs and metrics
+// are both exported once a collector endpoint is set, unless
+// PORTUS_OTEL_SIGNALS narrows them.
+func loadOTLPSettings(store *models.ConfigStore) error {
+	store.OTLPEndpoint = Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if store.OTLPEndpoint == "" {
+		return nil
+	}
+	if u, err := url.Parse(store.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid OTEL_EXPORTER_OTLP_ENDPOINT value: %s", store.OTLPEndpoint)
+	}
+
+	headers, err := otlp.ParseHeaders(Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS value: %w", err)
+	}
+	store.OTLPHeaders = headers
+
+	store.OTLPServiceName = defaultOTLPServiceName
+	if name := Getenv("OTEL_SERVICE_NAME"); name != "" {
+		store.OTLPServiceName = name
+	}
+
+	store.OTLPLogs, store.OTLPMetrics = true, true
+	if signals := Getenv("PORTUS_OTEL_SIGNALS"); signals != "" {
+		store.OTLPLogs, store.OTLPMetrics, err = otlp.ParseSignals(signals)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_OTEL_SIGNALS value: %w", err)
+		}
+	}
+
+	store.OTLPMetricInterval = defaultOTLPMetricInterval
+	if intervalStr := Getenv("PORTUS_OTEL_METRIC_INTERVAL"); intervalStr != "" {
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid PORTUS_OTEL_METRIC_INTERVAL value: %s", intervalStr)
+		}
+		store.OTLPMetricInterval = interval
+	}
+	return nil
+}
+
+// splitList parses a comma-separated environment value, dropping empty entries.
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// loadKeys loads the proxy keys from the environment and the config path's
+// key files, then applies each key's limits, versions and tags.
+func loadKeys(store *models.ConfigStore) error {
+	if err := loadProxyKeys(store); err != nil {
+		return fmt.Errorf("failed to load proxy keys: %w", err)
+	}
+	if err := loadKeyFiles(store); err != nil {
+		return fmt.Errorf("failed to load key files: %w", err)
+	}
+	if err := loadStreamLimits(store); err != nil {
+		return fmt.Errorf("failed to load stream limits: %w", err)
+	}
+	if err := loadTokenLimits(store); err != nil {
+		return fmt.Errorf("failed to load token limits: %w", err)
+	}
+	if err := loadConversationLimits(store); err != nil {
+		return fmt.Errorf("failed to load conversation limits: %w", err)
+	}
+	if err := loadRequestQuotas(store); err != nil {
+		return fmt.Errorf("failed to load request quotas: %w", err)
+	}
+	if err := loadAPIVersions(store); err != nil {
+		return fmt.Errorf("failed to load API versions: %w", err)
+	}
+	if err := loadDisabledKeys(store); err != nil {
+		return fmt.Errorf("failed to load disabled keys: %w", err)
+	}
+	if err := loadKeyTags(store); err != nil {
+		return fmt.Errorf("failed to load key tags: %w", err)
+	}
+	return nil
+}
+
+// keyDirs maps the config path's key directories to the scope of their
+// keys. Each file is named after its application or operator and holds the
+// key as its environment variable would, e.g. keys/BACKEND for PORTUS_KEY_BACKEND.
+var keyDirs = []struct {
+	dir   string
+	scope models.KeyScope
+}{
+	{"keys", models.ScopeInference},
+	{"admin-keys", models.ScopeAdmin},
+	{"obs-keys", models.ScopeObservability},
+}
+
+// loadKeyFiles adds the keys found in the config path's key directories,
+// which are usually written by a Consul or etcd backend or mounted from a
+// Kubernetes Secret. Names starting with a dot, including the ..data link of
+// a mounted volume, are skipped.
+func loadKeyFiles(store *models.ConfigStore) error {
+	for _, kd := range keyDirs {
+		dir := filepath.Join(store.ConfigPath, kd.dir)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			keys, err := parseKeyValue(path, strings.TrimSpace(string(data)))
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				k.Application = entry.Name()
+				k.Scope = kd.scope
+				store.ProxyKeys = append(store.ProxyKeys, k)
+			}
+		}
+	}
+	return nil
+}
+
+// hasKeyFiles reports whether the config path has any key directory.
+func hasKeyFiles(configPath string) bool {
+	for _, kd := range keyDirs {
+		if info, err := os.Stat(filepath.Join(configPath, kd.dir)); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func loadProxyKeys(store *models.ConfigStore) error {
+	prefixes := []struct {
+		prefix string
+		scope  models.KeyScope
+	}{
+		// Inference keys (Format: PORTUS_KEY_APP_NAME=key)
+		{"PORTUS_KEY_", models.ScopeInference},
+		// Admin API keys (Format: PORTUS_ADMIN_KEY_OPERATOR_NAME=key)
+		{"PORTUS_ADMIN_KEY_", models.ScopeAdmin},
+		// Read-only observability tokens (Format: PORTUS_OBS_KEY_APP_NAME=token)
+		{"PORTUS_OBS_KEY_", models.ScopeObservability},
+	}
+
+	for _, env := range settings.environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := parts[0]
+		value := parts[1]
+
+		for _, p := range prefixes {
+			// Settings such as PORTUS_KEY_EXPIRY_WARNING share the key prefix
+			if !strings.HasPrefix(key, p.prefix) || isSetting(key) {
+				continue
+			}
+			keys, err := parseKeyValue(key, value)
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				k.Application = strings.TrimPrefix(key, p.prefix)
+				k.Scope = p.scope
+				store.ProxyKeys = append(store.ProxyKeys, k)
+			}
+		}
+	}
+	return nil
+}
+
+// parseKeyValue parses a key variable's value. During rotation several
+// comma-separated keys may be valid at once, and each may carry an expiry as
+// key@<RFC 3339 timestamp>, e.g. "pk-new,pk-old@2026-04-01T00:00:00Z".
+func parseKeyValue(name, value string) ([]models.ProxyKey, error) {
+	var keys []models.ProxyKey
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pk := models.ProxyKey{Key: entry}
+		if i := strings.LastIndex(entry, "@"); i >= 0 {
+			expiresAt, err := time.Parse(time.RFC3339, entry[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid expiry in %s: %s", name, entry[i+1:])
+			}
+			pk.Key = entry[:i]
+			pk.ExpiresAt = expiresAt
+		}
+		keys = append(keys, pk)
+	}
+	return keys, nil
+}
+
+// loadStreamLimits applies concurrent stream caps to proxy keys. PORTUS_MAX_STREAMS
+// sets the default for every key and PORTUS_MAX_STREAMS_<APP> overrides it. It
+// also reads the burst headroom and the grace period shared by all limits.
+func loadStreamLimits(store *models.ConfigStore) error {
+	defaultLimit, err := parseNonNegativeInt("PORTUS_MAX_STREAMS")
+	if err != nil {
+		return err
+	}
+
+	for i, pk := range store.ProxyKeys {
+		limit := defaultLimit
+		if Getenv("PORTUS_MAX_STREAMS_"+pk.Application) != "" {
+			limit, err = parseNonNegativeInt("PORTUS_MAX_STREAMS_" + pk.Application)
+			if err != nil {
+				return err
+			}
+		}
+		store.ProxyKeys[i].MaxStreams = limit
+	}
+
+	store.StreamBurst, err = parseNonNegativeInt("PORTUS_MAX_STREAMS_BURST")
+	if err != nil {
+		return err
+	}
+
+	if graceStr := Getenv("PORTUS_LIMITS_GRACE_UNTIL"); graceStr != "" {
+		until, err := parseDateOrTime(graceStr)
+		if err != nil {
+			return fmt.Errorf("invalid PORTUS_LIMITS_GRACE_UNTIL value: %s", graceStr)
+		}
+		store.LimitsGraceUntil = until
+	}
+	return nil
+}
+
+// loadPIIPolicy reads the PII action, its per-application overrides and the
+// patterns scanned for.
+func loadPIIPolicy(store *models.ConfigStore) error {
+	action, err := pii.ParseAction(Getenv("PORTUS_PII_ACTION"))
+	if err != nil {
+		return fmt.Errorf("invalid PORTUS_PII_ACTION value: %w", err)
+	}
+	store.PIIAction = action
+
+	store.PIIApplicationActions = make(map[string]string)
+	for _, pk := range store.ProxyKeys {
+		name := "PORTUS_PII_ACTION_" + pk.Application
+		value := Getenv(name)
+		if value == "" {
+			continue
+		}
+		action, err := pii.ParseAction(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s value: %w", name, err)
+		}
+		store.PIIApplicationActions[pk.Application] = action
+	}
+
+	store.PIIPatterns = pii.Builtins
+	if patterns := Getenv("PORTUS_PII_PATTERNS"); patterns != "" {
+		store.PIIPatterns = nil
+		if patterns != "none" {
+			store.PIIPatterns = splitList(patterns)
+		}
+	}
+
+	// Custom patterns (Format: PORTUS_PII_PATTERN_NAME=regex)
+	store.PIICustomPatterns = make(map[string]string)
+	for _, env := range settings.environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if name, ok := strings.CutPrefix(key, "PORTUS_PII_PATTERN_"); ok && name != "" && value != "" {
+			store.PIICustomPatterns[name] = value
+		}
+	}
+
+	if _, err := pii.NewScanner(store.PIIPatterns, store.PIICustomPatterns); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadTokenLimits reads the completion token cap of each key and what
+// happens to requests above it.
+func loadTokenLimits(store *models.ConfigStore) error {
+	defaultLimit, err := parseNonNegativeInt("PORTUS_MAX_TOKENS")
+	if err != nil {
+		return err
+	}
+
+	for i, pk := range store.ProxyKeys {
+		limit := defaultLimit
+		if Getenv("PORTUS_MAX_TOKENS_"+pk.Application) != "" {
+			limit, err = parseNonNegativeInt("PORTUS_MAX_TOKENS_" + pk.Application)
+			if err != nil {
+				return err
+			}
+		}
+		store.ProxyKeys[i].MaxTokens = limit
+	}
+
+	action, err := tokenlimit.ParseAction(Getenv("PORTUS_MAX_TOKENS_ACTION"))
+	if err != nil {
+		return fmt.Errorf("invalid PORTUS_MAX_TOKENS_ACTION value: %w", err)
+	}
+	store.MaxTokensAction = action
+	return nil
+}
+
+// loadConversationLimits applies PORTUS_CONVERSATION_TOKENS to every key,
+// overridden per application by PORTUS_CONVERSATION_TOKENS_<APP>.
+func loadConversationLimits(store *models.ConfigStore) error {
+	defaultLimit, err := parseNonNegativeInt("PORTUS_CONVERSATION_TOKENS")
+	if err != nil {
+		return err
+	}
+
+	for i, pk := range store.ProxyKeys {
+		limit := defaultLimit
+		if Getenv("PORTUS_CONVERSATION_TOKENS_"+pk.Application) != "" {
+			limit, err = parseNonNegativeInt("PORTUS_CONVERSATION_TOKENS_" + pk.Application)
+			if err != nil {
+				return err
+			}
+		}
+		store.ProxyKeys[i].ConversationTokens = limit
+	}
+
+	action, err := conversation.ParseAction(Getenv("PORTUS_CONVERSATION_TOKENS_ACTION"))
+	if err != nil {
+		return fmt.Errorf("invalid PORTUS_CONVERSATION_TOKENS_ACTION value: %w", err)
+	}
+	store.ConversationTokensAction = action
+
+	store.ConversationIdleTimeout = defaultConversationIdleTimeout
+	if idleStr := Getenv("PORTUS_CONVERSATION_IDLE_TIMEOUT"); idleStr != "" {
+		idle, err := time.ParseDuration(idleStr)
+		if err != nil || idle <= 0 {
+			return fmt.Errorf("invalid PORTUS_CONVERSATION_IDLE_TIMEOUT value: %s", idleStr)
+		}
+		store.ConversationIdleTimeout = idle
+	}
+	return nil
+}
+
+// loadAPIVersions reads the server's default API version and each key's
+// override from PORTUS_API_VERSION_<APP>.
+func loadAPIVersions(store *models.ConfigStore) error {
+	version, err := apiversion.Parse(Getenv("PORTUS_API_VERSION"))
+	if err != nil {
+		return fmt.Errorf("invalid PORTUS_API_VERSION value: %w", err)
+	}
+	store.APIVersion = version
+
+	for i, pk := range store.ProxyKeys {
+		value := Getenv("PORTUS_API_VERSION_" + pk.Application)
+		if value == "" {
+			continue
+		}
+		if store.ProxyKeys[i].APIVersion, err = apiversion.Parse(value); err != nil {
+			return fmt.Errorf("invalid PORTUS_API_VERSION_%s value: %w", pk.Application, err)
+		}
+	}
+	return nil
+}
+
+// loadRequestQuotas applies PORTUS_REQUEST_QUOTA to every key, overridden per
+// application by PORTUS_REQUEST_QUOTA_<APP>.
+func loadRequestQuotas(store *models.ConfigStore) error {
+	defaultQuota, err := quota.Parse(Getenv("PORTUS_REQUEST_QUOTA"))
+	if err != nil {
+		return fmt.Errorf("invalid PORTUS_REQUEST_QUOTA value: %w", err)
+	}
+
+	for i, pk := range store.ProxyKeys {
+		q := defaultQuota
+		if value := Getenv("PORTUS_REQUEST_QUOTA_" + pk.Application); value != "" {
+			if q, err = quota.Parse(value); err != nil {
+				return fmt.Errorf("invalid PORTUS_REQUEST_QUOTA_%s value: %w", pk.Application, err)
+			}
+		}
+		store.ProxyKeys[i].RequestQuota = q
+	}
+
+	// Quota counts moved into the state storage backend
+	if Getenv("PORTUS_QUOTA_FILE") != "" {
+		return fmt.Errorf("PORTUS_QUOTA_FILE is no longer supported; request quota counts are kept in the PORTUS_STORAGE backend, set PORTUS_STORAGE=sqlite to keep them across restarts")
+	}
+	return nil
+}
+
+// parseDateOrTime parses an RFC 3339 time or a date, which means midnight UTC
+// at its start.
+func parseDateOrTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// loadDisabledKeys marks the keys listed by ID in PORTUS_DISABLED_KEYS as
+// disabled, so a leaked key can be cut off without removing it.
+func loadDisabledKeys(store *models.ConfigStore) error {
+	for _, id := range splitList(Getenv("PORTUS_DISABLED_KEYS")) {
+		found := false
+		for i, pk := range store.ProxyKeys {
+			if pk.ID() == id {
+				store.ProxyKeys[i].Disabled = true
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("PORTUS_DISABLED_KEYS references unknown key ID: %s", id)
+		}
+	}
+	return nil
+}
+
+// reservedTags are the names Portus already labels telemetry with, which a
+// tag must not shadow.
+var reservedTags = map[string]bool{"application": true, "model_alias": true, "status": true, "token_type": true, "reason": true}
+
+// ValidateTags checks tag names are lowercase identifiers that telemetry
+// backends accept and that values are single-line.
+func ValidateTags(tags map[string]string) error {
+	for name, value := range tags {
+		if !tagNameRegex.MatchString(name) || reservedTags[name] {
+			return fmt.Errorf("invalid tag name: %q", name)
+		}
+		if value == "" || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid value for tag %s", name)
+		}
+	}
+	return nil
+}
+
+// loadKeyTags reads each key's tags from PORTUS_TAGS_<APP>, a comma-separated
+// list of name=value pairs.
+func loadKeyTags(store *models.ConfigStore) error {
+	for i, pk := range store.ProxyKeys {
+		value := Getenv("PORTUS_TAGS_" + pk.Application)
+		if value == "" {
+			continue
+		}
+		tags := make(map[string]string)
+		for _, pair := range splitList(value) {
+			name, tag, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("invalid PORTUS_TAGS_%s value: %q is not name=value", pk.Application, pair)
+			}
+			tags[strings.TrimSpace(name)] = strings.TrimSpace(tag)
+		}
+		if err := ValidateTags(tags); err != nil {
+			return fmt.Errorf("invalid PORTUS_TAGS_%s value: %w", pk.Application, err)
+		}
+		store.ProxyKeys[i].Tags = tags
+	}
+	return nil
+}
+
+// loadWebhooks reads the global webhook destinations, their per-application
+// counterparts PORTUS_WEBHOOK_*_<APP>, and the delivery settings shared by
+// both. An application's secret and rate limit default to the global ones.
+func loadWebhooks(store *models.ConfigStore) error {
+	global, err := loadWebhookTarget("", models.WebhookTarget{RateLimit: defaultWebhookRateLimit})
+	if err != nil {
+		return err
+	}
+	store.Webhook = global
+
+	store.ApplicationWebhooks = make(map[string]models.WebhookTarget)
+	for _, pk := range store.ProxyKeys {
+		suffix := "_" + pk.Application
+		if _, ok := store.ApplicationWebhooks[pk.Application]; ok {
+			continue
+		}
+		if Getenv("PORTUS_WEBHOOK_URLS"+suffix) == "" && Getenv("PORTUS_WEBHOOK_SECRET"+suffix) == "" &&
+			Getenv("PORTUS_WEBHOOK_EVENTS"+suffix) == "" && Getenv("PORTUS_WEBHOOK_RATE_LIMIT"+suffix) == "" {
+			continue
+		}
+		target, err := loadWebhookTarget(suffix, models.WebhookTarget{Secret: global.Secret, RateLimit: global.RateLimit})
+		if err != nil {
+			return err
+		}
+		store.ApplicationWebhooks[pk.Application] = target
+	}
+
+	store.WebhookMaxAttempts = defaultWebhookMaxAttempts
+	if Getenv("PORTUS_WEBHOOK_MAX_ATTEMPTS") != "" {
+		attempts, err := parseNonNegativeInt("PORTUS_WEBHOOK_MAX_ATTEMPTS")
+		if err != nil || attempts == 0 {
+			return fmt.Errorf("invalid PORTUS_WEBHOOK_MAX_ATTEMPTS value: %s", Getenv("PORTUS_WEBHOOK_MAX_ATTEMPTS"))
+		}
+		store.WebhookMaxAttempts = attempts
+	}
+
+	store.WebhookRetryBackoff = defaultWebhookRetryBackoff
+	if backoffStr := Getenv("PORTUS_WEBHOOK_RETRY_BACKOFF"); backoffStr != "" {
+		backoff, err := time.ParseDuration(backoffStr)
+		if err != nil || backoff < 0 {
+			return fmt.Errorf("invalid PORTUS_WEBHOOK_RETRY_BACKOFF value: %s", backoffStr)
+		}
+		store.WebhookRetryBackoff = backoff
+	}
+
+	store.WebhookDeadLetterFile = Getenv("PORTUS_WEBHOOK_DEAD_LETTER_FILE")
+	return nil
+}
+
+// loadWebhookTarget reads PORTUS_WEBHOOK_URLS, _SECRET, _EVENTS and
+// _RATE_LIMIT with the given suffix, keeping the defaults for unset ones.
+func loadWebhookTarget(suffix string, target models.WebhookTarget) (models.WebhookTarget, error) {
+	urlsName := "PORTUS_WEBHOOK_URLS" + suffix
+	target.URLs = splitList(Getenv(urlsName))
+	for _, u := range target.URLs {
+		if err := webhook.ValidateURL(u); err != nil {
+			return target, fmt.Errorf("invalid %s value: %w", urlsName, err)
+		}
+	}
+
+	if secret := Getenv("PORTUS_WEBHOOK_SECRET" + suffix); secret != "" {
+		target.Secret = secret
+	}
+
+	eventsName := "PORTUS_WEBHOOK_EVENTS" + suffix
+	target.Events = splitList(Getenv(eventsName))
+	for _, pattern := range target.Events {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return target, fmt.Errorf("invalid %s value: bad pattern %q", eventsName, pattern)
+		}
+	}
+
+	if Getenv("PORTUS_WEBHOOK_RATE_LIMIT"+suffix) != "" {
+		limit, err := parseNonNegativeInt("PORTUS_WEBHOOK_RATE_LIMIT" + suffix)
+		if err != nil {
+			return target, err
+		}
+		target.RateLimit = limit
+	}
+	return target, nil
+}
+
+// parseNonNegativeInt reads an optional non-negative integer environment variable.
+func parseNonNegativeInt(name string) (int, error) {
+	value := Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s value: %s", name, value)
+	}
+	return n, nil
+}
+
+func loadModelConfigs(store *models.ConfigStore) error {
+	modelsDir := filepath.Join(store.ConfigPath, "models")
+
+	entries, err := os.ReadDir(modelsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Models directory doesn't exist, which is ok - we'll just have no models
+			return nil
+		}
+		return fmt.Errorf("failed to read models directory: %w", err)
+	}
+
+	defaults, err := readModelDefaults(modelsDir)
+	if err != nil {
+		return err
+	}
+	if defaults != nil {
+		// Checked for missing env vars like an alias
+		store.RawConfigs[modelDefaultsName] = string(defaults)
+	}
+	blocks, err := readProviderBlocks(store.ConfigPath)
+	if err != nil {
+		return err
+	}
+	for name, block := range blocks {
+		store.RawConfigs[providersDir+"/"+name] = string(block)
+	}
+
+	for _, entry := range entries {
+		// Names starting with an underscore, such as _defaults.json, are not aliases
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), "_") {
+			continue
+		}
+		alias, templated := strings.CutSuffix(entry.Name(), templateSuffix)
+		if !templated {
+			var ok bool
+			if alias, ok = strings.CutSuffix(entry.Name(), ".json"); !ok {
+				continue
+			}
+		}
+		path := filepath.Join(modelsDir, entry.Name())
+		if _, ok := store.RawConfigs[alias]; ok {
+			return fmt.Errorf("model %s is defined by both %s.json and %s%s", alias, alias, alias, templateSuffix)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read model config %s: %w", path, err)
+		}
+		if templated {
+			if data, err = renderModelTemplate(alias, path, data); err != nil {
+				return err
+			}
+		}
+
+		// Store raw content before expansion for env var checking during validation
+		store.RawConfigs[alias] = string(data)
+
+		data, err = mergeModelDefaults(defaults, data)
+		if err != nil {
+			return fmt.Errorf("failed to parse model config %s: %w", path, err)
+		}
+		data, err = applyProviderBlocks(blocks, data)
+		if err != nil {
+			return fmt.Errorf("failed to resolve model config %s: %w", path, err)
+		}
+
+		// Resolve secret references
+		data, err = resolveSecrets(data)
+		if err != nil {
+			return fmt.Errorf("failed to resolve model config %s: %w", path, err)
+		}
+
+		// Expand environment variables
+		expandedData := expandEnvVars(string(data))
+
+		var config models.ModelConfig
+		if err := json.Unmarshal([]byte(expandedData), &config); err != nil {
+			return fmt.Errorf("failed to parse model config %s: %w", path, err)
+		}
+
+		store.Models[alias] = config
+	}
+
+	return nil
+}
+
+// modelDefaultsName is the models directory file, without its extension,
+// whose fields every alias inherits.
+const modelDefaultsName = "_defaults"
+
+// readModelDefaults returns the raw content of the models directory's
+// _defaults.json, or nil when there is none.
+func readModelDefaults(modelsDir string) ([]byte, error) {
+	path := filepath.Join(modelsDir, modelDefaultsName+".json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read model defaults %s: %w", path, err)
+	}
+	var check map[string]interface{}
+	if err := json.Unmarshal(data, &check); err != nil {
+		return nil, fmt.Errorf("failed to parse model defaults %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// mergeModelDefaults returns a raw alias config with the defaults merged in.
+// Fields set by the alias win; nested objects are merged field by field, and
+// an explicit null in the alias removes an inherited field. Both are merged
+// before environment variable expansion.
+func mergeModelDefaults(defaults, data []byte) ([]byte, error) {
+	if defaults == nil {
+		return data, nil
+	}
+	merged := make(map[string]interface{})
+	if err := json.Unmarshal(defaults, &merged); err != nil {
+		return nil, err
+	}
+	alias := make(map[string]interface{})
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return nil, err
+	}
+	mergePatch(merged, alias)
+	return json.Marshal(merged)
+}
+
+// ParseModelConfig expands ${VAR} and secret references in a raw model config
+// and validates the result. Unset variables are an error.
+func ParseModelConfig(alias string, raw []byte) (models.ModelConfig, error) {
+	if ref := unresolvedProviderRef(raw); ref != "" {
+		return models.ModelConfig{}, fmt.Errorf("model %s uses provider block %q, which only model files in the config directory can use", alias, ref)
+	}
+	missingVars := make(map[string][]string)
+	checkMissingEnvVars(alias, string(raw), missingVars)
+	for varName := range missingVars {
+		return models.ModelConfig{}, fmt.Errorf("model %s references missing environment variable: %s", alias, varName)
+	}
+	raw, err := resolveSecrets(raw)
+	if err != nil {
+		return models.ModelConfig{}, fmt.Errorf("model %s: %w", alias, err)
+	}
+
+	var config models.ModelConfig
+	if err := json.Unmarshal([]byte(expandEnvVars(string(raw))), &config); err != nil {
+		return models.ModelConfig{}, fmt.Errorf("model %s is invalid: %w", alias, err)
+	}
+	if err := validateModelConfig(alias, config); err != nil {
+		return models.ModelConfig{}, err
+	}
+	return config, nil
+}
+
+// loadPricing reads the optional pricing.json from the config directory. The file
+// maps model aliases or resolved provider model names to per-1K-token prices.
+func loadPricing(store *models.ConfigStore) error {
+	path := filepath.Join(store.ConfigPath, "pricing.json")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read pricing file: %w", err)
+	}
+
+	pricing := make(map[string]models.PricingConfig)
+	if err := json.Unmarshal(data, &pricing); err != nil {
+		return fmt.Errorf("failed to parse pricing file %s: %w", path, err)
+	}
+
+	store.Pricing = pricing
+	return nil
+}
+
+// loadGuardrails reads the optional guardrails.json from the config
+// directory. The file maps application names to the rules their requests
+// must pass, in addition to each alias's own guardrails.
+func loadGuardrails(store *models.ConfigStore) error {
+	path := filepath.Join(store.ConfigPath, "guardrails.json")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read guardrails file: %w", err)
+	}
+
+	guardrails := make(map[string]models.GuardrailConfig)
+	if err := json.Unmarshal(data, &guardrails); err != nil {
+		return fmt.Errorf("failed to parse guardrails file %s: %w", path, err)
+	}
+
+	store.Guardrails = guardrails
+	return nil
+}
+
+// loadBudgets reads the optional budgets.json from the config directory,
+// mapping application names to daily and monthly spend limits.
+func loadBudgets(store *models.ConfigStore) error {
+	path := filepath.Join(store.ConfigPath, "budgets.json")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read budgets file: %w", err)
+	}
+
+	budgets := make(map[string]models.BudgetConfig)
+	if err := json.Unmarshal(data, &budgets); err != nil {
+		return fmt.Errorf("failed to parse budgets file %s: %w", path, err)
+	}
+
+	store.Budgets = budgets
+	return nil
+}
+
+func validatePricing(source string, pricing models.PricingConfig) error {
+	if pricing.InputPer1K < 0 || pricing.OutputPer1K < 0 {
+		return fmt.Errorf("pricing in %s must not be negative", source)
+	}
+	return nil
+}
+
+// secretResolver expands ${awssm:...}, ${ssm:...} and ${gcpsm:...}
+// references in model configs. Values are cached for the life of the process and refreshed by
+// RefreshSecrets.
+var secretResolver = newSecretResolver()
+
+// Cloud clients shared by secret references and remote config.
+var (
+	awsClient = secrets.NewAWS()
+	gcpClient = secrets.NewGCP()
+)
+
+func newSecretResolver() *secrets.Resolver {
+	return secrets.NewResolver(map[string]secrets.Source{
+		"awssm": awsClient.SecretsManager(),
+		"ssm":   awsClient.ParameterStore(),
+		"gcpsm": gcpClient.SecretManager(),
+	})
+}
+
+// resolveSecrets replaces the secret references in a raw model config.
+func resolveSecrets(data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretsResolveTimeout)
+	defer cancel()
+	expanded, err := secretResolver.Expand(ctx, string(data))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(expanded), nil
+}
+
+// SecretValues returns every value resolved from a secret reference, so they
+// can be redacted from logs.
+func SecretValues() []string {
+	return secretResolver.Values()
+}
+
+// RefreshSecrets fetches every secret reference again. When a value changed,
+// the models directory is reloaded and its aliases replace those in store; a
+// failed reload leaves the current models in place. It reports whether the
+// models were updated.
+func RefreshSecrets(ctx context.Context, store *models.ConfigStore) (bool, error) {
+	changed, err := secretResolver.Refresh(ctx)
+	if !changed {
+		return false, err
+	}
+	reloaded, loadErr := reloadModelConfigs(store)
+	if loadErr != nil {
+		return false, loadErr
+	}
+	store.SetModels(reloaded)
+	return true, err
+}
+
+// reloadModelConfigs loads and validates the models directory again without
+// touching store.
+func reloadModelConfigs(store *models.ConfigStore) (map[string]models.ModelConfig, error) {
+	reloaded := &models.ConfigStore{
+		ConfigPath: store.ConfigPath,
+		Models:     make(map[string]models.ModelConfig),
+		RawConfigs: make(map[string]string),
+	}
+	if err := loadModelConfigs(reloaded); err != nil {
+		return nil, err
+	}
+	for alias, model := range reloaded.Models {
+		if err := validateModelConfig(alias, model); err != nil {
+			return nil, err
+		}
+		if err := CheckBannedModels(alias, model, store.BannedModels); err != nil {
+			return nil, err
+		}
+	}
+	return reloaded.Models, nil
+}
+
+// remoteConfig mirrors a remote PORTUS_CONFIG_PATH, such as s3:// or
+// consul://; nil for a local directory.
+var remoteConfig *remoteconfig.Mirror
+
+// modelsStale is set while the models in use are older than the config
+// files, because reloading them after a change failed.
+var modelsStale atomic.Bool
+
+// ModelsStale reports whether the last reload of changed model configs
+// failed, so the models being served no longer match the config files. It
+// clears once a later change loads.
+func ModelsStale() bool {
+	return modelsStale.Load()
+}
+
+// loadRemoteConfig downloads a remote config path into a temporary
+// directory, which becomes store.ConfigPath.
+func loadRemoteConfig(store *models.ConfigStore) error {
+	if !remoteconfig.IsRemote(store.ConfigPath) {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "portus-config-*")
+	if err != nil {
+		return err
+	}
+	mirror, err := remoteconfig.New(store.ConfigPath, dir, awsClient, gcpClient)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), remoteConfigTimeout)
+	defer cancel()
+	if _, err := mirror.Sync(ctx); err != nil {
+		return err
+	}
+	remoteConfig = mirror
+	store.RemoteConfigURL = store.ConfigPath
+	store.ConfigPath = dir
+	return nil
+}
+
+// RefreshRemoteConfig downloads the remote config path's changes. When any
+// file changed, the models directory is reloaded and replaces the aliases in
+// store, so deleted files remove their aliases; a failed reload leaves the
+// current models in place. It reports whether the models were updated.
+func RefreshRemoteConfig(ctx context.Context, store *models.ConfigStore) (bool, error) {
+	if remoteConfig == nil {
+		return false, nil
+	}
+	changed, err := remoteConfig.Sync(ctx)
+	if err != nil || !changed {
+		return false, err
+	}
+	reloaded, err := reloadModelConfigs(store)
+	modelsStale.Store(err != nil)
+	if err != nil {
+		return false, err
+	}
+	store.ReplaceModels(reloaded)
+	return true, nil
+}
+
+// WaitRemoteConfig blocks until the remote config path may have changed:
+// until a Consul or etcd watch fires, or for interval when the path is a
+// bucket and can only be polled. A failed watch waits interval before
+// returning its error, so callers can retry in a loop.
+func WaitRemoteConfig(ctx context.Context, interval time.Duration) error {
+	if remoteConfig != nil && remoteConfig.Watches() {
+		err := remoteConfig.Wait(ctx)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		sleep(ctx, interval)
+		return err
+	}
+	sleep(ctx, interval)
+	return nil
+}
+
+// sleep waits for d or until ctx ends.
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// configWatcher watches a local config directory when
+// PORTUS_CONFIG_WATCH_INTERVAL is set; nil otherwise.
+var configWatcher *dirwatch.Watcher
+
+// watchConfigDir starts watching store.ConfigPath for changes.
+func watchConfigDir(store *models.ConfigStore) error {
+	if store.ConfigWatchInterval <= 0 {
+		return nil
+	}
+	watcher, err := dirwatch.New(store.ConfigPath)
+	if err != nil {
+		return err
+	}
+	configWatcher = watcher
+	return nil
+}
+
+// RefreshConfigDir checks the watched config directory for changes. When any
+// file changed, the models directory is reloaded and replaces the aliases in
+// store, so deleted files remove their aliases; a failed reload leaves the
+// current models in place. It reports whether the models were updated.
+func RefreshConfigDir(store *models.ConfigStore) (bool, error) {
+	if configWatcher == nil {
+		return false, nil
+	}
+	changed, err := configWatcher.Changed()
+	if err != nil || !changed {
+		return false, err
+	}
+	reloaded, err := reloadModelConfigs(store)
+	modelsStale.Store(err != nil)
+	if err != nil {
+		return false, err
+	}
+	store.ReplaceModels(reloaded)
+	return true, nil
+}
+
+// ReloadProxyKeys loads the proxy keys again from the environment and the
+// config path's key files, with their limits applied, for replacing the
+// active keys after the remote config or watched directory changed. It returns nil when the
+// config path has no key files, since the environment alone cannot have
+// changed.
+func ReloadProxyKeys(store *models.ConfigStore) ([]models.ProxyKey, error) {
+	if !hasKeyFiles(store.ConfigPath) {
+		return nil, nil
+	}
+	reloaded := &models.ConfigStore{ConfigPath: store.ConfigPath}
+	if err := loadKeys(reloaded); err != nil {
+		return nil, err
+	}
+	for _, pk := range reloaded.ProxyKeys {
+		if pk.Scope == models.ScopeInference {
+			return reloaded.ProxyKeys, nil
+		}
+	}
+	return nil, errors.New("no proxy keys configured: the key files must include at least one inference key")
+}
+
+func expandEnvVars(content string) string {
+	return envVarRegex.ReplaceAllStringFunc(content, func(match string) string {
+		// Extract variable name from ${VAR_NAME}
+		varName := match[2 : len(match)-1]
+		value := os.Getenv(varName)
+		return value
+	})
+}
+
+func checkMissingEnvVars(alias string, rawContent string, missingVars map[string][]string) {
+	matches := envVarRegex.FindAllStringSubmatch(rawContent, -1)
+
+	for _, match := range matches {
+		if len(match) >= 2 {
+			varName := match[1]
+			if os.Getenv(varName) == "" {
+				missingVars[varName] = append(missingVars[varName], alias+".json")
+			}
+		}
+	}
+}
+
+// CheckBannedModels reports an error when the alias's banned_models are not
+// valid globs or when the alias can reach a provider model banned by them or
+// by the global patterns. Aliases without an override_params model are
+// checked under their own name, which is what clients usually request.
+func CheckBannedModels(alias string, model models.ModelConfig, global []string) error {
+	for _, pattern := range model.BannedModels {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("model %s has an invalid banned_models pattern %q: %w", alias, pattern, err)
+		}
+	}
+	if banned := models.BannedModel(model, alias, global); banned != "" {
+		return fmt.Errorf("model %s resolves to banned model %s", alias, banned)
+	}
+	return nil
+}
+
+func validateModelConfig(alias string, model models.ModelConfig) error {
+	if model.RequestTimeout < 0 || model.MaxRequestTimeout < 0 {
+		return fmt.Errorf("model %s has a negative request timeout", alias)
+	}
+	if model.MaxOutputTokens < 0 {
+		return fmt.Errorf("model %s has a negative max_output_tokens", alias)
+	}
+	if model.ProxyRetries != nil && *model.ProxyRetries < 0 {
+		return fmt.Errorf("model %s has negative proxy_retries", alias)
+	}
+	if model.MaxConcurrent < 0 || model.MaxQueue < 0 || model.QueueTimeout < 0 || model.MaxConcurrentBurst < 0 {
+		return fmt.Errorf("model %s has a negative concurrency limit", alias)
+	}
+	if model.MaxConcurrentBurst > 0 && model.MaxConcurrent == 0 {
+		return fmt.Errorf("model %s sets max_concurrent_burst without max_concurrent", alias)
+	}
+	if err := validateUpstreamOptions(alias, "", model.CustomHost, model.ForwardHeaders, model.CustomHeaders); err != nil {
+		return err
+	}
+	if err := CheckBannedModels(alias, model, nil); err != nil {
+		return err
+	}
+	if model.HedgeAfter < 0 {
+		return fmt.Errorf("model %s has a negative hedge_after", alias)
+	}
+	if model.HedgeAfter > 0 && (model.Strategy == nil || model.Strategy.Mode != "fallback" || len(model.Targets) < 2) {
+		return fmt.Errorf("model %s sets hedge_after without a fallback strategy of two or more targets", alias)
+	}
+	if _, err := translate.ParseFormat(model.APIFormat); err != nil {
+		return fmt.Errorf("model %s: %w", alias, err)
+	}
+	if model.Guardrails != nil {
+		if err := guardrail.Validate(*model.Guardrails); err != nil {
+			return fmt.Errorf("model %s guardrails: %w", alias, err)
+		}
+	}
+	if model.PostProcess != nil {
+		if err := postprocess.Validate(*model.PostProcess); err != nil {
+			return fmt.Errorf("model %s post_process: %w", alias, err)
+		}
+	}
+	if c := model.Canary; c != nil {
+		if c.Alias == "" || c.Alias == alias {
+			return fmt.Errorf("model %s canary must name another alias", alias)
+		}
+		if c.Percent < 0 || c.Percent > 100 {
+			return fmt.Errorf("model %s canary percent must be between 0 and 100", alias)
+		}
+	}
+	if e := model.Experiment; e != nil {
+		if e.Name == "" || e.A == "" || e.B == "" || e.A == e.B {
+			return fmt.Errorf("model %s experiment needs a name and two different variant aliases", alias)
+		}
+		if model.Canary != nil {
+			return fmt.Errorf("model %s cannot have both a canary and an experiment", alias)
+		}
+	}
+	for name, value := range model.UpstreamHeaders {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("model %s has invalid upstream header: %q", alias, name)
+		}
+	}
+	if err := ValidateTags(model.Tags); err != nil {
+		return fmt.Errorf("model %s: %w", alias, err)
+	}
+	if t := model.UpstreamTLS; t != nil {
+		if (t.CertFile == "") != (t.KeyFile == "") {
+			return fmt.Errorf("model %s upstream_tls cert_file and key_file must be set together", alias)
+		}
+		if t.CertFile == "" && t.CAFile == "" {
+			return fmt.Errorf("model %s upstream_tls needs a certificate or a CA file", alias)
+		}
+	}
+	for i, test := range model.Tests {
+		if test.Prompt == "" {
+			return fmt.Errorf("model %s %s has no prompt", alias, verify.Name(test, i))
+		}
+		if test.MaxLatency < 0 {
+			return fmt.Errorf("model %s %s has a negative max_latency", alias, verify.Name(test, i))
+		}
+		if len(test.Schema) > 0 {
+			if _, err := verify.ParseSchema(test.Schema); err != nil {
+				return fmt.Errorf("model %s %s: %w", alias, verify.Name(test, i), err)
+			}
+		}
+	}
+	for _, pattern := range model.Match {
+		if _, err := models.CompileMatch(pattern); err != nil || pattern == "" {
+			return fmt.Errorf("model %s has invalid match pattern: %q", alias, pattern)
+		}
+	}
+	if err := validateBYOK(alias, model); err != nil {
+		return err
+	}
+
+	// Check if using strategy/targets or single provider
+	if model.Strategy != nil {
+		// Multi-target configuration
+		if len(model.Targets) == 0 {
+			return fmt.Errorf("model %s has strategy but no targets", alias)
+		}
+		if model.Strategy.Mode != "fallback" && model.Strategy.Mode != "loadbalance" {
+			return fmt.Errorf("model %s has invalid strategy mode: %s (must be 'fallback' or 'loadbalance')", alias, model.Strategy.Mode)
+		}
+
+		// Validate each target. Custom headers travel on the one gateway
+		// request, so targets cannot give the same header different values.
+		customHeaders := make(map[string]string)
+		for i, target := range model.Targets {
+			if target.Provider == "" {
+				return fmt.Errorf("model %s target %d has no provider", alias, i)
+			}
+			if err := validateUpstreamOptions(alias, fmt.Sprintf(" target %d", i), target.CustomHost, target.ForwardHeaders, target.CustomHeaders); err != nil {
+				return err
+			}
+			for name, value := range target.CustomHeaders {
+				name = http.CanonicalHeaderKey(name)
+				if other, ok := customHeaders[name]; ok && other != value {
+					return fmt.Errorf("model %s targets set different values for custom header %s", alias, name)
+				}
+				customHeaders[name] = value
+			}
+			if err := validateBaseURL(alias, fmt.Sprintf(" target %d", i), target.Provider, target.BaseURL, target.CustomHost); err != nil {
+				return err
+			}
+			if err := validateProviderConfig(alias, target.Provider, i, target); err != nil && !(model.BYOK == models.BYOKRequired && isCredentialsError(err)) {
+				return err
+			}
+		}
+	} else {
+		// Single provider configuration
+		if model.Provider == "" {
+			return fmt.Errorf("model %s has no provider (and no strategy/targets)", alias)
+		}
+		if err := validateBaseURL(alias, "", model.Provider, model.BaseURL, model.CustomHost); err != nil {
+			return err
+		}
+		// Clients bring the key of a byok "required" alias
+		if err := validateSingleProviderConfig(alias, model); err != nil && !(model.BYOK == models.BYOKRequired && isCredentialsError(err)) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateBYOK checks an alias's byok mode. A client's key replaces api_key,
+// so every provider of the alias must authenticate with one, and all targets
+// must share a provider so the key is never sent to another provider.
+func validateBYOK(alias string, model models.ModelConfig) error {
+	switch model.BYOK {
+	case "":
+		return nil
+	case models.BYOKOptional, models.BYOKRequired:
+	default:
+		return fmt.Errorf("model %s has invalid byok: %s (must be 'optional' or 'required')", alias, model.BYOK)
+	}
+	providers := []string{model.Provider}
+	if model.Strategy != nil {
+		providers = providers[:0]
+		for _, target := range model.Targets {
+			providers = append(providers, target.Provider)
+		}
+	}
+	for _, provider := range providers {
+		switch provider {
+		case "anthropic", "openai", "google":
+		default:
+			return fmt.Errorf("model %s sets byok, but provider %s does not use an api_key", alias, provider)
+		}
+		if provider != providers[0] {
+			return fmt.Errorf("model %s sets byok, but its targets use more than one provider (%s and %s)", alias, providers[0], provider)
+		}
+	}
+	return nil
+}
+
+// validateUpstreamOptions checks an alias's or target's custom_host,
+// forward_headers and custom_headers. where names the target in errors, or is
+// empty for the alias.
+func validateUpstreamOptions(alias, where, customHost string, forwardHeaders []string, customHeaders map[string]string) error {
+	if customHost != "" {
+		if u, err := url.Parse(customHost); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("model %s%s has invalid custom_host: %q (must be an http or https URL)", alias, where, customHost)
+		}
+	}
+	for _, name := range forwardHeaders {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("model %s%s has invalid forward header: %q", alias, where, name)
+		}
+		// The client's Portus key and own provider key are never sent to the
+		// gateway as headers
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "X-Api-Key", models.ProviderKeyHeader:
+			return fmt.Errorf("model %s%s cannot forward the %s header", alias, where, name)
+		}
+	}
+	for name, value := range customHeaders {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("model %s%s has invalid custom header: %q", alias, where, name)
+		}
+		// Custom headers reach the gateway as request headers, where these
+		// would be taken for the client's credentials or Portus's routing
+		switch canonical := http.CanonicalHeaderKey(name); {
+		case canonical == "Authorization", canonical == "X-Api-Key", canonical == models.ProviderKeyHeader:
+			return fmt.Errorf("model %s%s cannot set the %s header as a custom header", alias, where, name)
+		case strings.HasPrefix(canonical, "X-Portkey-"):
+			return fmt.Errorf("model %s%s cannot set Portkey header %s as a custom header", alias, where, name)
+		}
+	}
+	return nil
+}
+
+// validateBaseURL checks that base_url is set, as an http or https URL, exactly
+// when the provider is local. Local servers are reached at their base_url, so
+// they cannot set custom_host as well.
+func validateBaseURL(alias, where, provider, baseURL, customHost string) error {
+	if provider != models.ProviderLocal {
+		if baseURL != "" {
+			return fmt.Errorf("model %s%s sets base_url, which is only used by provider %s; use custom_host for a self-hosted %s endpoint", alias, where, models.ProviderLocal, provider)
+		}
+		return nil
+	}
+	if customHost != "" {
+		return fmt.Errorf("model %s%s sets custom_host; provider %s uses base_url", alias, where, models.ProviderLocal)
+	}
+	if baseURL == "" {
+		return fmt.Errorf("model %s%s (provider %s) missing base_url", alias, where, models.ProviderLocal)
+	}
+	if u, err := url.Parse(baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("model %s%s has invalid base_url: %q (must be an http or https URL)", alias, where, baseURL)
+	}
+	return nil
+}
+
+// credentialsError reports missing provider credentials, which mock mode
+// tolerates since no provider is contacted.
+type credentialsError struct {
+	error
+}
+
+func isCredentialsError(err error) bool {
+	_, ok := err.(credentialsError)
+	return ok
 }
 
 func validateProviderConfig(alias string, provider string, targetIndex int, target models.TargetConfig) error {
 	switch provider {
 	case "anthropic", "openai", "google":
-		// These providers need an API key
-		if target.APIKey == "" {
-			return fmt.Errorf("model %s target %d (provider %s) missing api_key", alias, targetIndex, provider)
+		// These providers need an API key, unless self-hosted
+		if target.APIKey == "" && target.CustomHost == "" {
+			return credentialsError{fmt.Errorf("model %s target %d (provider %s) missing api_key", alias, targetIndex, provider)}
 		}
 	case "bedrock":
 		// Bedrock needs AWS credentials
 		if target.AWSAccessKeyID == "" || target.AWSSecretAccessKey == "" || target.AWSRegion == "" {
-			return fmt.Errorf("model %s target %d (provider bedrock) missing AWS credentials", alias, targetIndex)
+			return credentialsError{fmt.Errorf("model %s target %d (provider bedrock) missing AWS credentials", alias, targetIndex)}
 		}
 	case "vertex-ai":
 		// Vertex AI needs project, region, and service account
@@ -265,17 +2212,19 @@ func validateProviderConfig(alias string, provider string, targetIndex int, targ
 func validateSingleProviderConfig(alias string, model models.ModelConfig) error {
 	switch model.Provider {
 	case "anthropic", "openai", "google":
-		if model.APIKey == "" {
-			return fmt.Errorf("model %s (provider %s) missing api_key", alias, model.Provider)
+		if model.APIKey == "" && model.CustomHost == "" {
+			return credentialsError{fmt.Errorf("model %s (provider %s) missing api_key", alias, model.Provider)}
 		}
 	case "bedrock":
 		if model.AWSAccessKeyID == "" || model.AWSSecretAccessKey == "" || model.AWSRegion == "" {
-			return fmt.Errorf("model %s (provider bedrock) missing AWS credentials", alias)
+			return credentialsError{fmt.Errorf("model %s (provider bedrock) missing AWS credentials", alias)}
 		}
 	case "vertex-ai":
 		if model.VertexProjectID == "" || model.VertexRegion == "" || model.VertexServiceAccountJSON == "" {
-			return fmt.Errorf("model %s (provider vertex-ai) missing Vertex AI configuration", alias)
+			return credentialsError{fmt.Errorf("model %s (provider vertex-ai) missing Vertex AI configuration", alias)}
 		}
+	case models.ProviderLocal:
+		// Local servers need no credentials; base_url is checked by validateBaseURL
 	default:
 		return fmt.Errorf("model %s has unknown provider: %s", alias, model.Provider)
 	}
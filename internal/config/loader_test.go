@@ -1,11 +1,23 @@
 package config
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/secrets"
 )
 
 func TestExpandEnvVars(t *testing.T) {
@@ -75,6 +87,108 @@ func TestValidateModelConfig_SingleProvider(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name:  "invalid post_process whitespace",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider:    "openai",
+				APIKey:      "sk-test",
+				PostProcess: &models.PostProcessConfig{Whitespace: "squash"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "negative max_output_tokens",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider:        "openai",
+				APIKey:          "sk-test",
+				MaxOutputTokens: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name:  "self-hosted without api_key",
+			alias: "llama",
+			model: models.ModelConfig{
+				Provider:       "openai",
+				CustomHost:     "http://vllm.internal:8000/v1",
+				ForwardHeaders: []string{"x-tenant-id"},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "local without api_key",
+			alias: "llama",
+			model: models.ModelConfig{
+				Provider: models.ProviderLocal,
+				BaseURL:  "http://localhost:11434/v1",
+			},
+			wantErr: false,
+		},
+		{
+			name:    "local without base_url",
+			alias:   "llama",
+			model:   models.ModelConfig{Provider: models.ProviderLocal},
+			wantErr: true,
+		},
+		{
+			name:    "local with invalid base_url",
+			alias:   "llama",
+			model:   models.ModelConfig{Provider: models.ProviderLocal, BaseURL: "localhost:11434"},
+			wantErr: true,
+		},
+		{
+			name:    "local with custom_host",
+			alias:   "llama",
+			model:   models.ModelConfig{Provider: models.ProviderLocal, BaseURL: "http://localhost:11434/v1", CustomHost: "http://localhost:11434/v1"},
+			wantErr: true,
+		},
+		{
+			name:    "base_url on a hosted provider",
+			alias:   "gpt4",
+			model:   models.ModelConfig{Provider: "openai", APIKey: "sk-test", BaseURL: "http://localhost:11434/v1"},
+			wantErr: true,
+		},
+		{
+			name:  "test case without prompt",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider: "openai",
+				APIKey:   "sk-test",
+				Tests:    []models.AliasTest{{Name: "capital", Expect: "Paris"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "test case with invalid schema",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider: "openai",
+				APIKey:   "sk-test",
+				Tests:    []models.AliasTest{{Prompt: "hi", Schema: json.RawMessage(`{"type":"text"}`)}},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "custom_host without scheme",
+			alias: "llama",
+			model: models.ModelConfig{
+				Provider:   "openai",
+				CustomHost: "vllm.internal:8000",
+			},
+			wantErr: true,
+		},
+		{
+			name:  "forwarding the authorization header",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider:       "openai",
+				APIKey:         "sk-test",
+				ForwardHeaders: []string{"authorization"},
+			},
+			wantErr: true,
+		},
 		{
 			name:  "missing provider",
 			alias: "test",
@@ -122,6 +236,227 @@ func TestValidateModelConfig_SingleProvider(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:  "valid match patterns",
+			alias: "gpt4-family",
+			model: models.ModelConfig{
+				Provider: "openai",
+				APIKey:   "sk-test",
+				Match:    []string{"gpt-4*", `re:gpt-4o-\d{4}-\d{2}-\d{2}`},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "invalid match regex",
+			alias: "gpt4-family",
+			model: models.ModelConfig{
+				Provider: "openai",
+				APIKey:   "sk-test",
+				Match:    []string{"re:gpt-(4"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "invalid match glob",
+			alias: "gpt4-family",
+			model: models.ModelConfig{
+				Provider: "openai",
+				APIKey:   "sk-test",
+				Match:    []string{"gpt-[4"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "valid canary",
+			alias: "sonnet",
+			model: models.ModelConfig{
+				Provider: "anthropic",
+				APIKey:   "sk-test",
+				Canary:   &models.CanaryConfig{Alias: "sonnet-next", Percent: 5},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "canary to itself",
+			alias: "sonnet",
+			model: models.ModelConfig{
+				Provider: "anthropic",
+				APIKey:   "sk-test",
+				Canary:   &models.CanaryConfig{Alias: "sonnet", Percent: 5},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "canary percent out of range",
+			alias: "sonnet",
+			model: models.ModelConfig{
+				Provider: "anthropic",
+				APIKey:   "sk-test",
+				Canary:   &models.CanaryConfig{Alias: "sonnet-next", Percent: 150},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "valid experiment",
+			alias: "sonnet",
+			model: models.ModelConfig{
+				Provider:   "anthropic",
+				APIKey:     "sk-test",
+				Experiment: &models.ExperimentConfig{Name: "prompt-v2", A: "sonnet", B: "sonnet-v2"},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "experiment with one variant",
+			alias: "sonnet",
+			model: models.ModelConfig{
+				Provider:   "anthropic",
+				APIKey:     "sk-test",
+				Experiment: &models.ExperimentConfig{Name: "prompt-v2", A: "sonnet", B: "sonnet"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "experiment and canary",
+			alias: "sonnet",
+			model: models.ModelConfig{
+				Provider:   "anthropic",
+				APIKey:     "sk-test",
+				Canary:     &models.CanaryConfig{Alias: "sonnet-next", Percent: 5},
+				Experiment: &models.ExperimentConfig{Name: "prompt-v2", A: "sonnet", B: "sonnet-v2"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "valid upstream headers and TLS",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider:        "openai",
+				APIKey:          "sk-test",
+				UpstreamHeaders: map[string]string{"X-Gateway-Token": "secret"},
+				UpstreamTLS:     &models.UpstreamTLSConfig{CertFile: "client.crt", KeyFile: "client.key"},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "invalid upstream header name",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider:        "openai",
+				APIKey:          "sk-test",
+				UpstreamHeaders: map[string]string{"X Gateway": "secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "valid custom headers",
+			alias: "llama",
+			model: models.ModelConfig{
+				Provider:      "openai",
+				CustomHost:    "http://vllm.internal:8000/v1",
+				CustomHeaders: map[string]string{"X-Deployment": "blue"},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "custom header replacing Portkey routing",
+			alias: "llama",
+			model: models.ModelConfig{
+				Provider:      "openai",
+				CustomHost:    "http://vllm.internal:8000/v1",
+				CustomHeaders: map[string]string{"x-portkey-provider": "anthropic"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "custom authorization header",
+			alias: "llama",
+			model: models.ModelConfig{
+				Provider:      "openai",
+				CustomHost:    "http://vllm.internal:8000/v1",
+				CustomHeaders: map[string]string{"Authorization": "Bearer secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "targets with conflicting custom headers",
+			alias: "llama",
+			model: models.ModelConfig{
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				Targets: []models.TargetConfig{
+					{Provider: "openai", CustomHost: "http://a.internal/v1", CustomHeaders: map[string]string{"X-Deployment": "blue"}},
+					{Provider: "openai", CustomHost: "http://b.internal/v1", CustomHeaders: map[string]string{"x-deployment": "green"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "invalid tag name",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider: "openai",
+				APIKey:   "sk-test",
+				Tags:     map[string]string{"model_alias": "other"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "upstream TLS cert without key",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider:    "openai",
+				APIKey:      "sk-test",
+				UpstreamTLS: &models.UpstreamTLSConfig{CertFile: "client.crt"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "byok required without api_key",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider: "openai",
+				BYOK:     models.BYOKRequired,
+			},
+			wantErr: false,
+		},
+		{
+			name:  "byok optional without api_key",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider: "openai",
+				BYOK:     models.BYOKOptional,
+			},
+			wantErr: true,
+		},
+		{
+			name:  "invalid byok mode",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider: "openai",
+				APIKey:   "sk-test",
+				BYOK:     "always",
+			},
+			wantErr: true,
+		},
+		{
+			name:  "byok on bedrock",
+			alias: "claude",
+			model: models.ModelConfig{
+				Provider: "bedrock",
+				BYOK:     models.BYOKRequired,
+			},
+			wantErr: true,
+		},
+		{
+			name:  "forwarding the provider key header",
+			alias: "gpt4",
+			model: models.ModelConfig{
+				Provider:       "openai",
+				APIKey:         "sk-test",
+				ForwardHeaders: []string{"x-portus-provider-key"},
+			},
+			wantErr: true,
+		},
 		{
 			name:  "vertex-ai missing service account",
 			alias: "vertex-model",
@@ -187,54 +522,244 @@ func TestValidateModelConfig_MultiTarget(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name:  "target missing provider",
+			name:  "self-hosted target",
 			alias: "multi",
 			model: models.ModelConfig{
 				Strategy: &models.StrategyConfig{Mode: "fallback"},
 				Targets: []models.TargetConfig{
-					{APIKey: "sk-1"},
+					{Provider: "openai", CustomHost: "https://llm.internal/v1"},
+					{Provider: "openai", APIKey: "sk-1"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "local target",
+			alias: "multi",
+			model: models.ModelConfig{
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				Targets: []models.TargetConfig{
+					{Provider: models.ProviderLocal, BaseURL: "http://localhost:1234/v1"},
+					{Provider: "openai", APIKey: "sk-1"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "local target without base_url",
+			alias: "multi",
+			model: models.ModelConfig{
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				Targets: []models.TargetConfig{
+					{Provider: models.ProviderLocal},
 				},
 			},
 			wantErr: true,
 		},
+		{
+			name:  "target with invalid forward header",
+			alias: "multi",
+			model: models.ModelConfig{
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				Targets: []models.TargetConfig{
+					{Provider: "openai", APIKey: "sk-1", ForwardHeaders: []string{"x tenant"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "byok required targets without api_key",
+			alias: "multi",
+			model: models.ModelConfig{
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				BYOK:     models.BYOKRequired,
+				Targets: []models.TargetConfig{
+					{Provider: "openai"},
+					{Provider: "openai", CustomHost: "https://eu.openai.example.com/v1"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "byok with targets of different providers",
+			alias: "multi",
+			model: models.ModelConfig{
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				BYOK:     models.BYOKRequired,
+				Targets: []models.TargetConfig{
+					{Provider: "openai"},
+					{Provider: "anthropic"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "byok with a bedrock target",
+			alias: "multi",
+			model: models.ModelConfig{
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				BYOK:     models.BYOKOptional,
+				Targets: []models.TargetConfig{
+					{Provider: "openai", APIKey: "sk-1"},
+					{Provider: "bedrock", AWSAccessKeyID: "id", AWSSecretAccessKey: "secret", AWSRegion: "us-east-1"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "target missing provider",
+			alias: "multi",
+			model: models.ModelConfig{
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				Targets: []models.TargetConfig{
+					{APIKey: "sk-1"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "fallback with hedging",
+			alias: "multi",
+			model: models.ModelConfig{
+				Strategy:   &models.StrategyConfig{Mode: "fallback"},
+				HedgeAfter: 1500,
+				Targets: []models.TargetConfig{
+					{Provider: "openai", APIKey: "sk-1"},
+					{Provider: "anthropic", APIKey: "sk-2"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "hedging a load balanced alias",
+			alias: "multi",
+			model: models.ModelConfig{
+				Strategy:   &models.StrategyConfig{Mode: "loadbalance"},
+				HedgeAfter: 1500,
+				Targets: []models.TargetConfig{
+					{Provider: "openai", APIKey: "sk-1"},
+					{Provider: "anthropic", APIKey: "sk-2"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "hedging a single target",
+			alias: "multi",
+			model: models.ModelConfig{
+				Strategy:   &models.StrategyConfig{Mode: "fallback"},
+				HedgeAfter: 1500,
+				Targets: []models.TargetConfig{
+					{Provider: "openai", APIKey: "sk-1"},
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateModelConfig(tt.alias, tt.model)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateModelConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadProxyKeys(t *testing.T) {
+	t.Setenv("PORTUS_KEY_BACKEND", "pk-backend-123")
+	t.Setenv("PORTUS_KEY_FRONTEND", "pk-frontend-456")
+
+	store := &models.ConfigStore{
+		ProxyKeys: []models.ProxyKey{},
+	}
+
+	loadProxyKeys(store)
+
+	if len(store.ProxyKeys) < 2 {
+		t.Fatalf("expected at least 2 proxy keys, got %d", len(store.ProxyKeys))
+	}
+
+	found := make(map[string]string)
+	for _, pk := range store.ProxyKeys {
+		found[pk.Application] = pk.Key
+	}
+
+	if found["BACKEND"] != "pk-backend-123" {
+		t.Errorf("expected BACKEND key 'pk-backend-123', got %q", found["BACKEND"])
+	}
+	if found["FRONTEND"] != "pk-frontend-456" {
+		t.Errorf("expected FRONTEND key 'pk-frontend-456', got %q", found["FRONTEND"])
+	}
+}
+
+func TestLoadProxyKeys_ObservabilityTokens(t *testing.T) {
+	t.Setenv("PORTUS_KEY_DASHBOARD_APP", "pk-inference")
+	t.Setenv("PORTUS_OBS_KEY_DASHBOARD_APP", "obs-token")
+
+	store := &models.ConfigStore{ProxyKeys: []models.ProxyKey{}}
+	loadProxyKeys(store)
+
+	scopes := make(map[string]models.KeyScope)
+	for _, pk := range store.ProxyKeys {
+		scopes[pk.Key] = pk.Scope
+	}
+
+	if scopes["pk-inference"] != models.ScopeInference {
+		t.Errorf("expected inference scope, got %q", scopes["pk-inference"])
+	}
+	if scopes["obs-token"] != models.ScopeObservability {
+		t.Errorf("expected observability scope, got %q", scopes["obs-token"])
+	}
+}
+
+func TestReloadProxyKeys_KeyFiles(t *testing.T) {
+	t.Setenv("PORTUS_KEY_ENVAPP", "pk-env")
+	t.Setenv("PORTUS_MAX_STREAMS_SEARCH", "3")
+
+	dir := t.TempDir()
+	store := &models.ConfigStore{ConfigPath: dir}
+	if keys, err := ReloadProxyKeys(store); keys != nil || err != nil {
+		t.Fatalf("expected nothing to reload without key files, got %v, %v", keys, err)
+	}
+
+	writeFile := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("admin-keys/OPS", "admin-ops\n")
+	if _, err := ReloadProxyKeys(&models.ConfigStore{ConfigPath: dir}); err != nil {
+		t.Fatalf("expected the environment's inference key to count, got %v", err)
+	}
+	writeFile("keys/SEARCH", "pk-search-new,pk-search-old@2026-01-01T00:00:00Z\n")
+
+	keys, err := ReloadProxyKeys(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]models.ProxyKey)
+	for _, pk := range keys {
+		got[pk.Key] = pk
+	}
+	if len(keys) != 4 || got["pk-env"].Application != "ENVAPP" || got["admin-ops"].Scope != models.ScopeAdmin {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	if search := got["pk-search-old"]; search.Application != "SEARCH" || search.Scope != models.ScopeInference || search.ExpiresAt.IsZero() || search.MaxStreams != 3 {
+		t.Errorf("expected the file key with its expiry and stream cap, got %+v", search)
+	}
+
+	writeFile("keys/BROKEN", "pk-broken@tomorrow")
+	if _, err := ReloadProxyKeys(store); err == nil || !strings.Contains(err.Error(), "BROKEN") {
+		t.Errorf("expected an error naming the key file, got %v", err)
 	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-			err := validateModelConfig(tt.alias, tt.model)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("validateModelConfig() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
-func TestLoadProxyKeys(t *testing.T) {
-	t.Setenv("PORTUS_KEY_BACKEND", "pk-backend-123")
-	t.Setenv("PORTUS_KEY_FRONTEND", "pk-frontend-456")
-
-	store := &models.ConfigStore{
-		ProxyKeys: []models.ProxyKey{},
-	}
-
-	loadProxyKeys(store)
-
-	if len(store.ProxyKeys) < 2 {
-		t.Fatalf("expected at least 2 proxy keys, got %d", len(store.ProxyKeys))
-	}
-
-	found := make(map[string]string)
-	for _, pk := range store.ProxyKeys {
-		found[pk.Application] = pk.Key
-	}
-
-	if found["BACKEND"] != "pk-backend-123" {
-		t.Errorf("expected BACKEND key 'pk-backend-123', got %q", found["BACKEND"])
-	}
-	if found["FRONTEND"] != "pk-frontend-456" {
-		t.Errorf("expected FRONTEND key 'pk-frontend-456', got %q", found["FRONTEND"])
-	}
 }
 
 func TestCheckMissingEnvVars(t *testing.T) {
@@ -253,6 +778,172 @@ func TestCheckMissingEnvVars(t *testing.T) {
 	} else if len(files) != 1 || files[0] != "test-model.json" {
 		t.Errorf("unexpected files for MISSING_VAR_XYZ: %v", files)
 	}
+
+	// Secret references are not environment variables
+	checkMissingEnvVars("test-model", `{"api_key": "${awssm:prod/openai}"}`, missingVars)
+	if len(missingVars) != 1 {
+		t.Errorf("expected secret references to be ignored, got %v", missingVars)
+	}
+}
+
+// useSecretValues swaps the secret resolver for one serving values, for the
+// duration of a test.
+func useSecretValues(t *testing.T, values map[string]string) {
+	t.Helper()
+	previous := secretResolver
+	fetch := secrets.SourceFunc(func(_ context.Context, ref string) (string, error) {
+		value, ok := values[ref]
+		if !ok {
+			return "", errors.New("not found")
+		}
+		return value, nil
+	})
+	secretResolver = secrets.NewResolver(map[string]secrets.Source{"awssm": fetch, "ssm": fetch})
+	t.Cleanup(func() { secretResolver = previous })
+}
+
+func TestRemoteConfig(t *testing.T) {
+	objects := map[string]string{
+		"portus/models/gpt.json":    `{"provider": "openai", "api_key": "sk-1"}`,
+		"portus/models/claude.json": `{"provider": "anthropic", "api_key": "sk-2"}`,
+	}
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if key := strings.TrimPrefix(r.URL.Path, "/cfg/"); key != "" {
+			w.Write([]byte(objects[key]))
+			return
+		}
+		w.Write([]byte("<ListBucketResult>"))
+		for key, data := range objects {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><ETag>%q</ETag><Size>%d</Size></Contents>", key, data, len(data))
+		}
+		w.Write([]byte("</ListBucketResult>"))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ENDPOINT_URL_S3", server.URL)
+	t.Cleanup(func() { remoteConfig = nil })
+
+	store := &models.ConfigStore{
+		ConfigPath: "s3://cfg/portus",
+		Models:     make(map[string]models.ModelConfig),
+		RawConfigs: make(map[string]string),
+	}
+	if err := loadRemoteConfig(store); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(store.ConfigPath) })
+	if err := loadModelConfigs(store); err != nil {
+		t.Fatal(err)
+	}
+	if store.RemoteConfigURL != "s3://cfg/portus" || len(store.Models) != 2 {
+		t.Fatalf("expected two aliases from the bucket, got %v from %s", store.ModelAliases(), store.RemoteConfigURL)
+	}
+	if _, err := PatchModels(store, ModelSelector{Aliases: []string{"gpt"}}, map[string]interface{}{"tags": nil}, false); err == nil {
+		t.Error("expected patching a remote config to be refused")
+	}
+
+	// Unchanged objects leave the models alone
+	if updated, err := RefreshRemoteConfig(context.Background(), store); updated || err != nil {
+		t.Errorf("RefreshRemoteConfig() = %v, %v; want no update", updated, err)
+	}
+
+	// An invalid edit keeps the current models
+	mu.Lock()
+	objects["portus/models/gpt.json"] = `{"provider": "openai", "api_key": "sk-1", "request_timeout": -1}`
+	mu.Unlock()
+	if updated, err := RefreshRemoteConfig(context.Background(), store); updated || err == nil {
+		t.Errorf("RefreshRemoteConfig() = %v, %v; want an error", updated, err)
+	}
+	if model, _ := store.Model("gpt"); model.RequestTimeout != 0 {
+		t.Errorf("expected the invalid edit to be ignored, got %+v", model)
+	}
+
+	// A deleted file removes its alias
+	mu.Lock()
+	objects["portus/models/gpt.json"] = `{"provider": "openai", "api_key": "sk-3"}`
+	delete(objects, "portus/models/claude.json")
+	mu.Unlock()
+	if updated, err := RefreshRemoteConfig(context.Background(), store); !updated || err != nil {
+		t.Fatalf("RefreshRemoteConfig() = %v, %v; want an update", updated, err)
+	}
+	if aliases := store.ModelAliases(); len(aliases) != 1 {
+		t.Errorf("expected the deleted alias to be removed, got %v", aliases)
+	}
+	if model, _ := store.Model("gpt"); model.APIKey != "sk-3" {
+		t.Errorf("expected the edited alias, got %+v", model)
+	}
+}
+
+func TestLoadModelConfigs_SecretReferences(t *testing.T) {
+	values := map[string]string{"prod/openai": "sk-1", "/portus/region": "us-east-1"}
+	useSecretValues(t, values)
+
+	store := writeModelFiles(t, map[string]string{
+		"gpt-4o":  `{"provider": "openai", "api_key": "${awssm:prod/openai}"}`,
+		"bedrock": `{"provider": "bedrock", "aws_region": "${ssm:/portus/region}", "aws_access_key_id": "AKID", "aws_secret_access_key": "secret"}`,
+	})
+	if got := store.Models["gpt-4o"].APIKey; got != "sk-1" {
+		t.Errorf("expected the secret to be resolved, got %q", got)
+	}
+	if got := store.Models["bedrock"].AWSRegion; got != "us-east-1" {
+		t.Errorf("expected the parameter to be resolved, got %q", got)
+	}
+
+	// Unchanged values leave the models alone
+	if updated, err := RefreshSecrets(context.Background(), store); updated || err != nil {
+		t.Errorf("RefreshSecrets() = %v, %v; want no update", updated, err)
+	}
+
+	values["prod/openai"] = "sk-2"
+	if updated, err := RefreshSecrets(context.Background(), store); !updated || err != nil {
+		t.Fatalf("RefreshSecrets() = %v, %v; want an update", updated, err)
+	}
+	if model, _ := store.Model("gpt-4o"); model.APIKey != "sk-2" {
+		t.Errorf("expected the rotated secret, got %q", model.APIKey)
+	}
+	if got := SecretValues(); !slices.Contains(got, "sk-2") {
+		t.Errorf("expected the rotated secret to be redactable, got %v", got)
+	}
+
+	// A failed fetch keeps the last value
+	delete(values, "prod/openai")
+	if _, err := RefreshSecrets(context.Background(), store); err == nil {
+		t.Error("expected the failed refresh to be reported")
+	}
+	if model, _ := store.Model("gpt-4o"); model.APIKey != "sk-2" {
+		t.Errorf("expected the last value to be kept, got %q", model.APIKey)
+	}
+}
+
+func TestLoadModelConfigs_MissingSecret(t *testing.T) {
+	useSecretValues(t, nil)
+
+	dir := t.TempDir()
+	modelsDir := filepath.Join(dir, "models")
+	if err := os.MkdirAll(modelsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(modelsDir, "gpt-4o.json"), []byte(`{"provider": "openai", "api_key": "${awssm:prod/openai}"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &models.ConfigStore{
+		Models:     make(map[string]models.ModelConfig),
+		RawConfigs: make(map[string]string),
+		ConfigPath: dir,
+	}
+	if err := loadModelConfigs(store); err == nil || !strings.Contains(err.Error(), "${awssm:prod/openai}") {
+		t.Errorf("expected an error naming the reference, got %v", err)
+	}
 }
 
 func TestLoadModelConfigs(t *testing.T) {
@@ -299,3 +990,1146 @@ func TestLoadModelConfigs(t *testing.T) {
 		t.Error("expected raw config to be stored for 'gpt4'")
 	}
 }
+
+func TestLoadModelConfigs_Defaults(t *testing.T) {
+	t.Setenv("DEFAULTS_TEST_BETA", "prompt-caching-2024-07-31")
+
+	store := writeModelFiles(t, map[string]string{
+		"_defaults": `{
+			"retry": {"attempts": 3, "on_status_codes": [429, 503]},
+			"request_timeout": 60000,
+			"beta_headers": ["${DEFAULTS_TEST_BETA}"]
+		}`,
+		"claude": `{"provider": "anthropic", "api_key": "sk-ant", "retry": {"attempts": 5}}`,
+		"gpt-4o": `{"provider": "openai", "api_key": "sk-1", "request_timeout": 30000, "beta_headers": null}`,
+	})
+
+	if _, ok := store.Models["_defaults"]; ok || len(store.Models) != 2 {
+		t.Fatalf("expected _defaults not to be loaded as an alias, got %d models", len(store.Models))
+	}
+
+	claude := store.Models["claude"]
+	if claude.Retry == nil || claude.Retry.Attempts != 5 || len(claude.Retry.OnStatusCodes) != 2 {
+		t.Errorf("expected retry objects to be merged field by field, got %+v", claude.Retry)
+	}
+	if claude.RequestTimeout != 60000 || len(claude.BetaHeaders) != 1 || claude.BetaHeaders[0] != "prompt-caching-2024-07-31" {
+		t.Errorf("expected inherited defaults, got %+v", claude)
+	}
+
+	gpt := store.Models["gpt-4o"]
+	if gpt.RequestTimeout != 30000 || gpt.BetaHeaders != nil || gpt.Retry == nil {
+		t.Errorf("expected alias fields to override defaults, got %+v", gpt)
+	}
+
+	if _, ok := store.RawConfigs["_defaults"]; !ok {
+		t.Error("expected defaults to be checked for missing env vars")
+	}
+}
+
+func TestLoadModelConfigs_InvalidDefaults(t *testing.T) {
+	dir := t.TempDir()
+	modelsDir := filepath.Join(dir, "models")
+	if err := os.MkdirAll(modelsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(modelsDir, "_defaults.json"), []byte(`["not", "an", "object"]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &models.ConfigStore{
+		Models:     make(map[string]models.ModelConfig),
+		RawConfigs: make(map[string]string),
+		ConfigPath: dir,
+	}
+	if err := loadModelConfigs(store); err == nil || !strings.Contains(err.Error(), "_defaults.json") {
+		t.Errorf("expected an error naming _defaults.json, got %v", err)
+	}
+}
+
+func TestLoadPricing(t *testing.T) {
+	dir := t.TempDir()
+	pricingJSON := `{
+		"claude-sonnet": {"input_per_1k": 0.003, "output_per_1k": 0.015},
+		"gpt-4o": {"input_per_1k": 0.0025, "output_per_1k": 0.01}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "pricing.json"), []byte(pricingJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &models.ConfigStore{ConfigPath: dir}
+	if err := loadPricing(store); err != nil {
+		t.Fatalf("loadPricing() error: %v", err)
+	}
+
+	if len(store.Pricing) != 2 {
+		t.Fatalf("expected 2 pricing entries, got %d", len(store.Pricing))
+	}
+	if store.Pricing["gpt-4o"].OutputPer1K != 0.01 {
+		t.Errorf("expected gpt-4o output price 0.01, got %v", store.Pricing["gpt-4o"].OutputPer1K)
+	}
+
+	// Missing file is not an error
+	empty := &models.ConfigStore{ConfigPath: t.TempDir()}
+	if err := loadPricing(empty); err != nil {
+		t.Errorf("expected no error for missing pricing file, got %v", err)
+	}
+}
+
+func TestLoadGuardrails(t *testing.T) {
+	dir := t.TempDir()
+	guardrailsJSON := `{
+		"KIDS": {"banned_phrases": ["casino"], "max_messages": 20},
+		"SUPPORT": {"patterns": ["("]}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "guardrails.json"), []byte(guardrailsJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &models.ConfigStore{ConfigPath: dir}
+	if err := loadGuardrails(store); err != nil {
+		t.Fatalf("loadGuardrails() error: %v", err)
+	}
+	if kids := store.Guardrails["KIDS"]; len(kids.BannedPhrases) != 1 || kids.MaxMessages != 20 {
+		t.Errorf("unexpected KIDS rules %+v", kids)
+	}
+
+	found := false
+	for _, err := range ValidateConfig(store) {
+		if strings.Contains(err.Error(), "guardrails.json entry SUPPORT") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected the invalid SUPPORT pattern to be reported")
+	}
+
+	// Missing file is not an error
+	empty := &models.ConfigStore{ConfigPath: t.TempDir()}
+	if err := loadGuardrails(empty); err != nil || empty.Guardrails != nil {
+		t.Errorf("expected no guardrails without a file, got %v %v", empty.Guardrails, err)
+	}
+}
+
+func TestLoadBudgets(t *testing.T) {
+	dir := t.TempDir()
+	budgetsJSON := `{
+		"SEARCH": {"daily_usd": 50, "monthly_usd": 1000},
+		"BATCH": {"monthly_usd": -1}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "budgets.json"), []byte(budgetsJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &models.ConfigStore{ConfigPath: dir}
+	if err := loadBudgets(store); err != nil {
+		t.Fatalf("loadBudgets() error: %v", err)
+	}
+	if search := store.Budgets["SEARCH"]; search.DailyUSD != 50 || search.MonthlyUSD != 1000 {
+		t.Errorf("unexpected SEARCH budget %+v", search)
+	}
+
+	found := false
+	for _, err := range ValidateConfig(store) {
+		if strings.Contains(err.Error(), "budgets.json entry BATCH") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected the negative BATCH budget to be reported")
+	}
+}
+
+func TestLoadPIIPolicy(t *testing.T) {
+	t.Setenv("PORTUS_PII_ACTION", "mask")
+	t.Setenv("PORTUS_PII_ACTION_AGENT", "reject")
+	t.Setenv("PORTUS_PII_PATTERNS", "email,ssn")
+	t.Setenv("PORTUS_PII_PATTERN_EMPLOYEE_ID", `EMP-\d{6}`)
+
+	store := &models.ConfigStore{
+		ProxyKeys: []models.ProxyKey{
+			{Key: "k1", Application: "AGENT"},
+			{Key: "k2", Application: "WEB"},
+		},
+	}
+	if err := loadPIIPolicy(store); err != nil {
+		t.Fatalf("loadPIIPolicy() error: %v", err)
+	}
+	if store.PIIAction != "mask" || store.PIIApplicationActions["AGENT"] != "reject" || len(store.PIIApplicationActions) != 1 {
+		t.Errorf("unexpected actions %q %v", store.PIIAction, store.PIIApplicationActions)
+	}
+	if strings.Join(store.PIIPatterns, ",") != "email,ssn" || store.PIICustomPatterns["EMPLOYEE_ID"] != `EMP-\d{6}` {
+		t.Errorf("unexpected patterns %v %v", store.PIIPatterns, store.PIICustomPatterns)
+	}
+
+	t.Setenv("PORTUS_PII_PATTERN_EMPLOYEE_ID", `EMP-(`)
+	if err := loadPIIPolicy(store); err == nil {
+		t.Error("expected invalid custom pattern to be rejected")
+	}
+	t.Setenv("PORTUS_PII_PATTERN_EMPLOYEE_ID", "")
+	t.Setenv("PORTUS_PII_ACTION_AGENT", "block")
+	if err := loadPIIPolicy(store); err == nil || !strings.Contains(err.Error(), "PORTUS_PII_ACTION_AGENT") {
+		t.Errorf("expected error naming PORTUS_PII_ACTION_AGENT, got %v", err)
+	}
+}
+
+func TestLoadWebhooks(t *testing.T) {
+	t.Setenv("PORTUS_WEBHOOK_URLS", "https://hooks.example.com/a, https://hooks.example.com/b")
+	t.Setenv("PORTUS_WEBHOOK_SECRET", "global-secret")
+	t.Setenv("PORTUS_WEBHOOK_EVENTS", "budget.*,guardrail.blocked")
+	t.Setenv("PORTUS_WEBHOOK_URLS_AGENT", "https://agent.example.com/hook")
+	t.Setenv("PORTUS_WEBHOOK_RATE_LIMIT_AGENT", "10")
+	t.Setenv("PORTUS_WEBHOOK_MAX_ATTEMPTS", "3")
+
+	store := &models.ConfigStore{
+		ProxyKeys: []models.ProxyKey{
+			{Key: "k1", Application: "AGENT"},
+			{Key: "k2", Application: "WEB"},
+		},
+	}
+	if err := loadWebhooks(store); err != nil {
+		t.Fatalf("loadWebhooks() error: %v", err)
+	}
+	if len(store.Webhook.URLs) != 2 || store.Webhook.RateLimit != defaultWebhookRateLimit || len(store.Webhook.Events) != 2 {
+		t.Errorf("unexpected global webhook %+v", store.Webhook)
+	}
+	agent := store.ApplicationWebhooks["AGENT"]
+	if len(store.ApplicationWebhooks) != 1 || agent.Secret != "global-secret" || agent.RateLimit != 10 || agent.Events != nil {
+		t.Errorf("expected AGENT to inherit the secret only, got %v", store.ApplicationWebhooks)
+	}
+	if store.WebhookMaxAttempts != 3 || store.WebhookRetryBackoff != defaultWebhookRetryBackoff {
+		t.Errorf("unexpected delivery settings %d, %v", store.WebhookMaxAttempts, store.WebhookRetryBackoff)
+	}
+
+	for name, value := range map[string]string{
+		"PORTUS_WEBHOOK_URLS_AGENT":    "ftp://agent.example.com",
+		"PORTUS_WEBHOOK_EVENTS":        "budget.[",
+		"PORTUS_WEBHOOK_MAX_ATTEMPTS":  "0",
+		"PORTUS_WEBHOOK_RETRY_BACKOFF": "soon",
+		"PORTUS_WEBHOOK_RATE_LIMIT":    "-1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if err := loadWebhooks(store); err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("expected error naming %s, got %v", name, err)
+			}
+		})
+	}
+}
+
+func TestLoadTokenLimits(t *testing.T) {
+	t.Setenv("PORTUS_MAX_TOKENS", "8000")
+	t.Setenv("PORTUS_MAX_TOKENS_TOOL", "1000")
+	t.Setenv("PORTUS_MAX_TOKENS_ACTION", "reject")
+
+	store := &models.ConfigStore{
+		ProxyKeys: []models.ProxyKey{
+			{Key: "k1", Application: "TOOL"},
+			{Key: "k2", Application: "WEB"},
+		},
+	}
+	if err := loadTokenLimits(store); err != nil {
+		t.Fatalf("loadTokenLimits() error: %v", err)
+	}
+	if store.ProxyKeys[0].MaxTokens != 1000 || store.ProxyKeys[1].MaxTokens != 8000 {
+		t.Errorf("unexpected limits %d and %d", store.ProxyKeys[0].MaxTokens, store.ProxyKeys[1].MaxTokens)
+	}
+	if store.MaxTokensAction != "reject" {
+		t.Errorf("expected reject action, got %q", store.MaxTokensAction)
+	}
+
+	t.Setenv("PORTUS_MAX_TOKENS_ACTION", "truncate")
+	if err := loadTokenLimits(store); err == nil {
+		t.Error("expected invalid action to be rejected")
+	}
+	t.Setenv("PORTUS_MAX_TOKENS_ACTION", "")
+	t.Setenv("PORTUS_MAX_TOKENS_TOOL", "-1")
+	if err := loadTokenLimits(store); err == nil {
+		t.Error("expected negative limit to be rejected")
+	}
+}
+
+func TestLoadConversationLimits(t *testing.T) {
+	t.Setenv("PORTUS_CONVERSATION_TOKENS", "200000")
+	t.Setenv("PORTUS_CONVERSATION_TOKENS_AGENT", "50000")
+	t.Setenv("PORTUS_CONVERSATION_TOKENS_ACTION", "warn")
+	t.Setenv("PORTUS_CONVERSATION_IDLE_TIMEOUT", "30m")
+
+	store := &models.ConfigStore{
+		ProxyKeys: []models.ProxyKey{
+			{Key: "k1", Application: "AGENT"},
+			{Key: "k2", Application: "WEB"},
+		},
+	}
+	if err := loadConversationLimits(store); err != nil {
+		t.Fatalf("loadConversationLimits() error: %v", err)
+	}
+	if store.ProxyKeys[0].ConversationTokens != 50000 || store.ProxyKeys[1].ConversationTokens != 200000 {
+		t.Errorf("unexpected limits %d and %d", store.ProxyKeys[0].ConversationTokens, store.ProxyKeys[1].ConversationTokens)
+	}
+	if store.ConversationTokensAction != "warn" {
+		t.Errorf("expected warn action, got %q", store.ConversationTokensAction)
+	}
+	if store.ConversationIdleTimeout != 30*time.Minute {
+		t.Errorf("expected 30m idle timeout, got %v", store.ConversationIdleTimeout)
+	}
+
+	t.Setenv("PORTUS_CONVERSATION_TOKENS_ACTION", "truncate")
+	if err := loadConversationLimits(store); err == nil {
+		t.Error("expected invalid action to be rejected")
+	}
+	t.Setenv("PORTUS_CONVERSATION_TOKENS_ACTION", "")
+	t.Setenv("PORTUS_CONVERSATION_IDLE_TIMEOUT", "0s")
+	if err := loadConversationLimits(store); err == nil {
+		t.Error("expected zero idle timeout to be rejected")
+	}
+	t.Setenv("PORTUS_CONVERSATION_IDLE_TIMEOUT", "")
+	t.Setenv("PORTUS_CONVERSATION_TOKENS_AGENT", "-1")
+	if err := loadConversationLimits(store); err == nil {
+		t.Error("expected negative limit to be rejected")
+	}
+}
+
+func TestLoadAPIVersions(t *testing.T) {
+	t.Setenv("PORTUS_API_VERSION", "v1")
+	t.Setenv("PORTUS_API_VERSION_NEXT", "v2")
+
+	store := &models.ConfigStore{
+		ProxyKeys: []models.ProxyKey{
+			{Key: "k1", Application: "NEXT"},
+			{Key: "k2", Application: "WEB"},
+		},
+	}
+	if err := loadAPIVersions(store); err != nil {
+		t.Fatalf("loadAPIVersions() error: %v", err)
+	}
+	if store.APIVersion != "v1" || store.ProxyKeys[0].APIVersion != "v2" || store.ProxyKeys[1].APIVersion != "" {
+		t.Errorf("unexpected versions %q, %q, %q", store.APIVersion, store.ProxyKeys[0].APIVersion, store.ProxyKeys[1].APIVersion)
+	}
+
+	t.Setenv("PORTUS_API_VERSION_NEXT", "v3")
+	if err := loadAPIVersions(store); err == nil || !strings.Contains(err.Error(), "PORTUS_API_VERSION_NEXT") {
+		t.Errorf("expected error naming PORTUS_API_VERSION_NEXT, got %v", err)
+	}
+}
+
+func TestLoadKeyTags(t *testing.T) {
+	t.Setenv("PORTUS_TAGS_SEARCH", "team=search, cost_center=cc-42")
+
+	store := &models.ConfigStore{
+		ProxyKeys: []models.ProxyKey{
+			{Key: "k1", Application: "SEARCH"},
+			{Key: "k2", Application: "WEB"},
+		},
+	}
+	if err := loadKeyTags(store); err != nil {
+		t.Fatalf("loadKeyTags() error: %v", err)
+	}
+	want := map[string]string{"team": "search", "cost_center": "cc-42"}
+	if !maps.Equal(store.ProxyKeys[0].Tags, want) || store.ProxyKeys[1].Tags != nil {
+		t.Errorf("unexpected tags %v, %v", store.ProxyKeys[0].Tags, store.ProxyKeys[1].Tags)
+	}
+
+	for _, value := range []string{"team", "Team=search", "application=other", "team="} {
+		t.Setenv("PORTUS_TAGS_SEARCH", value)
+		if err := loadKeyTags(store); err == nil || !strings.Contains(err.Error(), "PORTUS_TAGS_SEARCH") {
+			t.Errorf("%q: expected error naming PORTUS_TAGS_SEARCH, got %v", value, err)
+		}
+	}
+}
+
+func TestLoadRequestQuotas(t *testing.T) {
+	t.Setenv("PORTUS_REQUEST_QUOTA", "10000/day")
+	t.Setenv("PORTUS_REQUEST_QUOTA_BATCH", "500/hour")
+
+	store := &models.ConfigStore{
+		ProxyKeys: []models.ProxyKey{
+			{Key: "k1", Application: "BATCH"},
+			{Key: "k2", Application: "WEB"},
+		},
+	}
+	if err := loadRequestQuotas(store); err != nil {
+		t.Fatalf("loadRequestQuotas() error: %v", err)
+	}
+	if got := store.ProxyKeys[0].RequestQuota.String() + " " + store.ProxyKeys[1].RequestQuota.String(); got != "500/hour 10000/day" {
+		t.Errorf("unexpected quotas %s", got)
+	}
+
+	t.Setenv("PORTUS_REQUEST_QUOTA_BATCH", "500/week")
+	if err := loadRequestQuotas(store); err == nil || !strings.Contains(err.Error(), "PORTUS_REQUEST_QUOTA_BATCH") {
+		t.Errorf("expected error naming PORTUS_REQUEST_QUOTA_BATCH, got %v", err)
+	}
+
+	// Counts are kept in the storage backend rather than a file of their own
+	t.Setenv("PORTUS_REQUEST_QUOTA_BATCH", "")
+	t.Setenv("PORTUS_QUOTA_FILE", "/data/quota.json")
+	if err := loadRequestQuotas(store); err == nil || !strings.Contains(err.Error(), "PORTUS_STORAGE") {
+		t.Errorf("expected error pointing at PORTUS_STORAGE, got %v", err)
+	}
+}
+
+func TestLoadStreamLimits(t *testing.T) {
+	t.Setenv("PORTUS_MAX_STREAMS", "10")
+	t.Setenv("PORTUS_MAX_STREAMS_AGENT", "2")
+
+	store := &models.ConfigStore{
+		ProxyKeys: []models.ProxyKey{
+			{Key: "k1", Application: "AGENT"},
+			{Key: "k2", Application: "WEB"},
+		},
+	}
+	if err := loadStreamLimits(store); err != nil {
+		t.Fatalf("loadStreamLimits() error: %v", err)
+	}
+	if store.ProxyKeys[0].MaxStreams != 2 {
+		t.Errorf("expected AGENT override of 2, got %d", store.ProxyKeys[0].MaxStreams)
+	}
+	if store.ProxyKeys[1].MaxStreams != 10 {
+		t.Errorf("expected WEB default of 10, got %d", store.ProxyKeys[1].MaxStreams)
+	}
+
+	t.Setenv("PORTUS_MAX_STREAMS_BURST", "3")
+	t.Setenv("PORTUS_LIMITS_GRACE_UNTIL", "2026-11-15")
+	if err := loadStreamLimits(store); err != nil {
+		t.Fatalf("loadStreamLimits() error: %v", err)
+	}
+	if store.StreamBurst != 3 || !store.LimitsGraceUntil.Equal(time.Date(2026, 11, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected burst %d or grace %v", store.StreamBurst, store.LimitsGraceUntil)
+	}
+
+	t.Setenv("PORTUS_LIMITS_GRACE_UNTIL", "in two weeks")
+	if err := loadStreamLimits(store); err == nil {
+		t.Error("expected error for invalid grace date")
+	}
+	t.Setenv("PORTUS_LIMITS_GRACE_UNTIL", "")
+
+	t.Setenv("PORTUS_MAX_STREAMS", "-1")
+	if err := loadStreamLimits(store); err == nil {
+		t.Error("expected error for negative limit")
+	}
+}
+
+func TestLoadOTLPSettings(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "authorization=Bearer%20token,x-tenant=ops")
+	t.Setenv("PORTUS_OTEL_SIGNALS", "metrics")
+
+	store := &models.ConfigStore{}
+	if err := loadOTLPSettings(store); err != nil {
+		t.Fatalf("loadOTLPSettings() error: %v", err)
+	}
+	if store.OTLPHeaders["authorization"] != "Bearer token" || store.OTLPHeaders["x-tenant"] != "ops" {
+		t.Errorf("unexpected headers: %v", store.OTLPHeaders)
+	}
+	if store.OTLPLogs || !store.OTLPMetrics {
+		t.Errorf("expected only metrics, got logs=%v metrics=%v", store.OTLPLogs, store.OTLPMetrics)
+	}
+	if store.OTLPServiceName != defaultOTLPServiceName || store.OTLPMetricInterval != defaultOTLPMetricInterval {
+		t.Errorf("unexpected defaults: %q, %v", store.OTLPServiceName, store.OTLPMetricInterval)
+	}
+
+	t.Setenv("PORTUS_OTEL_SIGNALS", "traces")
+	if err := loadOTLPSettings(store); err == nil {
+		t.Error("expected error for unknown signal")
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "collector:4318")
+	if err := loadOTLPSettings(store); err == nil {
+		t.Error("expected error for endpoint without scheme")
+	}
+}
+
+func TestLoadServerConfig_AdminAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr bool
+	}{
+		{name: "unset", addr: ""},
+		{name: "loopback port", addr: "127.0.0.1:9090"},
+		{name: "all interfaces", addr: ":9090"},
+		{name: "missing port", addr: "127.0.0.1", wantErr: true},
+		{name: "proxy port", addr: "127.0.0.1:8080", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORTUS_PORT", "8080")
+			t.Setenv("PORTUS_ADMIN_ADDR", tt.addr)
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && store.AdminAddr != tt.addr {
+				t.Errorf("expected AdminAddr %q, got %q", tt.addr, store.AdminAddr)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_ControlPlaneGRPCAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr bool
+	}{
+		{name: "unset", addr: ""},
+		{name: "loopback port", addr: "127.0.0.1:9443"},
+		{name: "missing port", addr: "127.0.0.1", wantErr: true},
+		{name: "proxy port", addr: ":8080", wantErr: true},
+		{name: "admin port", addr: ":9090", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORTUS_PORT", "8080")
+			t.Setenv("PORTUS_ADMIN_ADDR", "127.0.0.1:9090")
+			t.Setenv("PORTUS_CONTROL_PLANE_GRPC_ADDR", tt.addr)
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && store.ControlPlaneGRPCAddr != tt.addr {
+				t.Errorf("expected ControlPlaneGRPCAddr %q, got %q", tt.addr, store.ControlPlaneGRPCAddr)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_SecretsRefreshInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		want     time.Duration
+		wantErr  bool
+	}{
+		{name: "unset", interval: "", want: defaultSecretsRefreshInterval},
+		{name: "custom", interval: "1h", want: time.Hour},
+		{name: "disabled", interval: "0", want: 0},
+		{name: "negative", interval: "-1m", wantErr: true},
+		{name: "invalid", interval: "often", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORTUS_SECRETS_REFRESH_INTERVAL", tt.interval)
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && store.SecretsRefreshInterval != tt.want {
+				t.Errorf("expected SecretsRefreshInterval %v, got %v", tt.want, store.SecretsRefreshInterval)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_CredentialFailures(t *testing.T) {
+	tests := []struct {
+		name          string
+		threshold     string
+		probe         string
+		hide          string
+		wantThreshold int
+		wantProbe     time.Duration
+		wantHide      bool
+		wantErr       bool
+	}{
+		{name: "unset", wantProbe: defaultCredentialFailureProbeInterval},
+		{name: "enabled", threshold: "3", probe: "1m", hide: "true", wantThreshold: 3, wantProbe: time.Minute, wantHide: true},
+		{name: "never probes", threshold: "3", probe: "0", wantThreshold: 3},
+		{name: "negative threshold", threshold: "-1", wantErr: true},
+		{name: "invalid probe interval", probe: "-5m", wantErr: true},
+		{name: "invalid hide", hide: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORTUS_CREDENTIAL_FAILURE_THRESHOLD", tt.threshold)
+			t.Setenv("PORTUS_CREDENTIAL_FAILURE_PROBE_INTERVAL", tt.probe)
+			t.Setenv("PORTUS_CREDENTIAL_FAILURE_HIDE_MODELS", tt.hide)
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if store.CredentialFailureThreshold != tt.wantThreshold || store.CredentialFailureProbeInterval != tt.wantProbe || store.CredentialFailureHideModels != tt.wantHide {
+				t.Errorf("expected threshold %d, probe %v, hide %v, got %d, %v, %v", tt.wantThreshold, tt.wantProbe, tt.wantHide,
+					store.CredentialFailureThreshold, store.CredentialFailureProbeInterval, store.CredentialFailureHideModels)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_ReadOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{name: "unset", value: ""},
+		{name: "enabled", value: "true", want: true},
+		{name: "disabled", value: "false"},
+		{name: "invalid", value: "frozen", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORTUS_READ_ONLY", tt.value)
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if store.ReadOnly != tt.want {
+				t.Errorf("expected ReadOnly %v, got %v", tt.want, store.ReadOnly)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_RemoteConfigRefreshInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default", value: "", want: time.Minute},
+		{name: "custom", value: "5m", want: 5 * time.Minute},
+		{name: "disabled", value: "0", want: 0},
+		{name: "negative", value: "-1m", wantErr: true},
+		{name: "invalid", value: "often", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORTUS_REMOTE_CONFIG_REFRESH_INTERVAL", tt.value)
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && store.RemoteConfigRefreshInterval != tt.want {
+				t.Errorf("expected RemoteConfigRefreshInterval %v, got %v", tt.want, store.RemoteConfigRefreshInterval)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_ConfigWatchInterval(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		configPath string
+		want       time.Duration
+		wantErr    bool
+	}{
+		{name: "default", value: "", want: 0},
+		{name: "custom", value: "10s", want: 10 * time.Second},
+		{name: "negative", value: "-1s", wantErr: true},
+		{name: "invalid", value: "often", wantErr: true},
+		{name: "remote config path", value: "10s", configPath: "s3://cfg/portus", wantErr: true},
+		{name: "disabled with remote config path", value: "0", configPath: "s3://cfg/portus", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORTUS_CONFIG_WATCH_INTERVAL", tt.value)
+			t.Setenv("PORTUS_CONFIG_PATH", tt.configPath)
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && store.ConfigWatchInterval != tt.want {
+				t.Errorf("expected ConfigWatchInterval %v, got %v", tt.want, store.ConfigWatchInterval)
+			}
+		})
+	}
+}
+
+func TestRefreshConfigDir(t *testing.T) {
+	t.Setenv("PORTUS_KEY_ENVAPP", "pk-env")
+	t.Cleanup(func() {
+		configWatcher = nil
+		modelsStale.Store(false)
+	})
+
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("models/gpt.json", `{"provider": "openai", "api_key": "sk-1"}`)
+	writeFile("keys/BACKEND", "pk-backend-1")
+
+	store := &models.ConfigStore{
+		ConfigPath:          dir,
+		ConfigWatchInterval: time.Second,
+		Models:              make(map[string]models.ModelConfig),
+		RawConfigs:          make(map[string]string),
+	}
+	if err := watchConfigDir(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadModelConfigs(store); err != nil {
+		t.Fatal(err)
+	}
+	if updated, err := RefreshConfigDir(store); updated || err != nil {
+		t.Fatalf("expected no change, got %v, %v", updated, err)
+	}
+
+	writeFile("models/claude.json", `{"provider": "anthropic", "api_key": "sk-2"}`)
+	writeFile("keys/BACKEND", "pk-backend-2")
+	os.Remove(filepath.Join(dir, "models/gpt.json"))
+	if updated, err := RefreshConfigDir(store); !updated || err != nil {
+		t.Fatalf("expected the models to reload, got %v, %v", updated, err)
+	}
+	if aliases := store.ModelAliases(); len(aliases) != 1 || aliases[0] != "claude" {
+		t.Errorf("expected only claude after the reload, got %v", aliases)
+	}
+	keys, err := ReloadProxyKeys(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || (keys[0].Key != "pk-backend-2" && keys[1].Key != "pk-backend-2") {
+		t.Errorf("expected the rotated key file, got %+v", keys)
+	}
+
+	// An invalid file leaves the current models in place
+	writeFile("models/claude.json", `{"provider": "anthropic"`)
+	if updated, err := RefreshConfigDir(store); updated || err == nil {
+		t.Fatalf("expected the reload to fail, got %v, %v", updated, err)
+	}
+	if _, ok := store.Model("claude"); !ok {
+		t.Error("expected claude to stay after a failed reload")
+	}
+	if !ModelsStale() {
+		t.Error("expected the models to be reported stale after a failed reload")
+	}
+
+	// Unchanged files don't clear it; the next change that loads does
+	if _, err := RefreshConfigDir(store); err != nil || !ModelsStale() {
+		t.Errorf("expected the models to stay stale, got %v", err)
+	}
+	writeFile("models/claude.json", `{"provider": "anthropic", "api_key": "sk-3"}`)
+	if updated, err := RefreshConfigDir(store); !updated || err != nil || ModelsStale() {
+		t.Errorf("expected the fixed file to load, got %v, %v", updated, err)
+	}
+}
+
+func TestLoadServerConfig_BannedModels(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "unset", value: ""},
+		{name: "list", value: "gpt-3.5-turbo, claude-2*", want: []string{"gpt-3.5-turbo", "claude-2*"}},
+		{name: "invalid glob", value: "gpt-[", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORTUS_BANNED_MODELS", tt.value)
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(store.BannedModels, tt.want) {
+				t.Errorf("expected BannedModels %v, got %v", tt.want, store.BannedModels)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_JobsFile(t *testing.T) {
+	t.Setenv("PORTUS_JOBS_FILE", "/data/jobs.json")
+
+	err := loadServerConfig(&models.ConfigStore{})
+	if err == nil || !strings.Contains(err.Error(), "PORTUS_STORAGE") {
+		t.Errorf("expected error pointing at PORTUS_STORAGE, got %v", err)
+	}
+}
+
+func TestLoadServerConfig_JobsWebhookHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "unset", value: ""},
+		{name: "list", value: "hooks.example.com, *.internal.example.com", want: []string{"hooks.example.com", "*.internal.example.com"}},
+		{name: "invalid glob", value: "hooks-[", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORTUS_JOBS_WEBHOOK_HOSTS", tt.value)
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(store.JobsWebhookHosts, tt.want) {
+				t.Errorf("expected JobsWebhookHosts %v, got %v", tt.want, store.JobsWebhookHosts)
+			}
+		})
+	}
+}
+
+func TestCheckBannedModels(t *testing.T) {
+	t.Parallel()
+
+	targets := []models.TargetConfig{
+		{Provider: "openai", APIKey: "sk-1", OverrideParams: map[string]interface{}{"model": "gpt-4o"}},
+		{Provider: "openai", APIKey: "sk-2", OverrideParams: map[string]interface{}{"model": "GPT-3.5-Turbo"}},
+	}
+	tests := []struct {
+		name    string
+		alias   string
+		model   models.ModelConfig
+		global  []string
+		wantErr string
+	}{
+		{
+			name:   "allowed",
+			alias:  "gpt",
+			model:  models.ModelConfig{Provider: "openai", OverrideParams: map[string]interface{}{"model": "gpt-4o"}},
+			global: []string{"gpt-3.5-*"},
+		},
+		{
+			name:    "global ban on override_params model",
+			alias:   "gpt",
+			model:   models.ModelConfig{Provider: "openai", OverrideParams: map[string]interface{}{"model": "gpt-3.5-turbo"}},
+			global:  []string{"gpt-3.5-*"},
+			wantErr: "model gpt resolves to banned model gpt-3.5-turbo",
+		},
+		{
+			name:    "alias name forwarded without an override",
+			alias:   "claude-2.1",
+			model:   models.ModelConfig{Provider: "anthropic"},
+			global:  []string{"claude-2*"},
+			wantErr: "resolves to banned model claude-2.1",
+		},
+		{
+			name:    "per-alias ban on a target, case-insensitive",
+			alias:   "multi",
+			model:   models.ModelConfig{Strategy: &models.StrategyConfig{Mode: "fallback"}, Targets: targets, BannedModels: []string{"gpt-3.5-turbo"}},
+			wantErr: "resolves to banned model GPT-3.5-Turbo",
+		},
+		{
+			name:    "invalid per-alias pattern",
+			alias:   "gpt",
+			model:   models.ModelConfig{Provider: "openai", BannedModels: []string{"gpt-["}},
+			wantErr: "invalid banned_models pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := CheckBannedModels(tt.alias, tt.model, tt.global)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckBannedModels() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckBannedModels() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_GatewayRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "unset", value: ""},
+		{name: "rules", value: "eu-*=https://gateway.eu:8787/, re:ap-.*=http://gateway.apac:8787", want: []string{"eu-*=https://gateway.eu:8787", "re:ap-.*=http://gateway.apac:8787"}},
+		{name: "missing url", value: "eu-*", wantErr: true},
+		{name: "missing pattern", value: "=https://gateway.eu:8787", wantErr: true},
+		{name: "not http", value: "eu-*=gateway.eu:8787", wantErr: true},
+		{name: "invalid glob", value: "eu-[=https://gateway.eu:8787", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORTUS_GATEWAY_ROUTES", tt.value)
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, route := range store.GatewayRoutes {
+				got = append(got, route.Pattern+"="+route.URL)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("expected gateway routes %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_Storage(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantErr  bool
+		wantKind string
+	}{
+		{name: "unset", env: map[string]string{}, wantKind: "memory"},
+		{name: "sqlite", env: map[string]string{"PORTUS_STORAGE": "sqlite", "PORTUS_STORAGE_PATH": "state.db"}, wantKind: "sqlite"},
+		{name: "sqlite without path", env: map[string]string{"PORTUS_STORAGE": "sqlite"}, wantErr: true},
+		{name: "redis", env: map[string]string{"PORTUS_STORAGE": "redis", "PORTUS_REDIS_URL": "redis://localhost:6379/0"}, wantKind: "redis"},
+		{name: "redis without URL", env: map[string]string{"PORTUS_STORAGE": "redis"}, wantErr: true},
+		{name: "redis URL alone", env: map[string]string{"PORTUS_REDIS_URL": "redis://localhost:6379/0"}, wantKind: "redis"},
+		{name: "memory with redis URL", env: map[string]string{"PORTUS_STORAGE": "memory", "PORTUS_REDIS_URL": "redis://localhost:6379/0"}, wantKind: "memory"},
+		{name: "unknown", env: map[string]string{"PORTUS_STORAGE": "etcd"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"PORTUS_STORAGE", "PORTUS_STORAGE_PATH", "PORTUS_REDIS_URL"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && store.Storage != tt.wantKind {
+				t.Errorf("expected storage %q, got %q", tt.wantKind, store.Storage)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_H2C(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            map[string]string
+		wantErr        bool
+		wantH2C        bool
+		wantGatewayH2C bool
+	}{
+		{name: "unset", env: map[string]string{}},
+		{name: "plaintext listener", env: map[string]string{"PORTUS_H2C": "true"}, wantH2C: true},
+		{name: "with TLS listener", env: map[string]string{"PORTUS_H2C": "true", "PORTUS_TLS_CERT": "server.crt"}, wantErr: true},
+		{name: "invalid", env: map[string]string{"PORTUS_H2C": "sometimes"}, wantErr: true},
+		{name: "plaintext gateway", env: map[string]string{"PORTUS_GATEWAY_H2C": "true", "PORTKEY_GATEWAY_URL": "http://gateway:8787"}, wantGatewayH2C: true},
+		{name: "HTTPS gateway", env: map[string]string{"PORTUS_GATEWAY_H2C": "true", "PORTKEY_GATEWAY_URL": "https://gateway:8787"}, wantErr: true},
+		{name: "HTTPS routed gateway", env: map[string]string{"PORTUS_GATEWAY_H2C": "true", "PORTKEY_GATEWAY_URL": "http://gateway:8787", "PORTUS_GATEWAY_ROUTES": "eu-*=https://gateway.eu:8787"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"PORTUS_H2C", "PORTUS_GATEWAY_H2C", "PORTUS_TLS_CERT", "PORTKEY_GATEWAY_URL", "PORTUS_GATEWAY_ROUTES"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (store.H2C != tt.wantH2C || store.GatewayH2C != tt.wantGatewayH2C) {
+				t.Errorf("expected H2C %v and GatewayH2C %v, got %v and %v", tt.wantH2C, tt.wantGatewayH2C, store.H2C, store.GatewayH2C)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfig_ACME(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		wantErr     bool
+		wantDomains []string
+	}{
+		{name: "unset", env: map[string]string{}},
+		{
+			name:        "domains",
+			env:         map[string]string{"PORTUS_ACME_DOMAINS": "Portus.example.com, api.example.com", "PORTUS_ACME_CACHE_DIR": "/var/lib/portus/acme"},
+			wantDomains: []string{"portus.example.com", "api.example.com"},
+		},
+		{name: "missing cache dir", env: map[string]string{"PORTUS_ACME_DOMAINS": "portus.example.com"}, wantErr: true},
+		{name: "wildcard", env: map[string]string{"PORTUS_ACME_DOMAINS": "*.example.com", "PORTUS_ACME_CACHE_DIR": "acme"}, wantErr: true},
+		{name: "with port", env: map[string]string{"PORTUS_ACME_DOMAINS": "portus.example.com:443", "PORTUS_ACME_CACHE_DIR": "acme"}, wantErr: true},
+		{name: "with certificate", env: map[string]string{"PORTUS_ACME_DOMAINS": "portus.example.com", "PORTUS_ACME_CACHE_DIR": "acme", "PORTUS_TLS_CERT": "server.crt"}, wantErr: true},
+		{name: "with h2c", env: map[string]string{"PORTUS_ACME_DOMAINS": "portus.example.com", "PORTUS_ACME_CACHE_DIR": "acme", "PORTUS_H2C": "true"}, wantErr: true},
+		{name: "plaintext directory", env: map[string]string{"PORTUS_ACME_DOMAINS": "portus.example.com", "PORTUS_ACME_CACHE_DIR": "acme", "PORTUS_ACME_DIRECTORY_URL": "http://ca.internal/directory"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"PORTUS_ACME_DOMAINS", "PORTUS_ACME_CACHE_DIR", "PORTUS_ACME_DIRECTORY_URL", "PORTUS_TLS_CERT", "PORTUS_H2C"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			store := &models.ConfigStore{}
+			err := loadServerConfig(store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !slices.Equal(store.ACMEDomains, tt.wantDomains) {
+				t.Errorf("expected ACMEDomains %v, got %v", tt.wantDomains, store.ACMEDomains)
+			}
+		})
+	}
+}
+
+func TestLoadBodySpillSettings(t *testing.T) {
+	store := &models.ConfigStore{}
+	if err := loadBodySpillSettings(store); err != nil {
+		t.Fatalf("loadBodySpillSettings() error: %v", err)
+	}
+	if store.BodySpillThreshold != defaultBodySpillThreshold || store.BodySpillDir != "" {
+		t.Errorf("unexpected defaults: %d, %q", store.BodySpillThreshold, store.BodySpillDir)
+	}
+
+	dir := t.TempDir()
+	t.Setenv("PORTUS_BODY_SPILL_THRESHOLD", "0")
+	t.Setenv("PORTUS_BODY_SPILL_DIR", dir)
+	if err := loadBodySpillSettings(store); err != nil {
+		t.Fatalf("loadBodySpillSettings() error: %v", err)
+	}
+	if store.BodySpillThreshold != 0 || store.BodySpillDir != dir {
+		t.Errorf("unexpected settings: %d, %q", store.BodySpillThreshold, store.BodySpillDir)
+	}
+
+	t.Setenv("PORTUS_BODY_SPILL_THRESHOLD", "-1")
+	if err := loadBodySpillSettings(store); err == nil {
+		t.Error("expected error for negative threshold")
+	}
+
+	t.Setenv("PORTUS_BODY_SPILL_THRESHOLD", "")
+	t.Setenv("PORTUS_BODY_SPILL_DIR", filepath.Join(dir, "missing"))
+	if err := loadBodySpillSettings(store); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
+
+func TestLoadStatsDSettings(t *testing.T) {
+	t.Setenv("PORTUS_STATSD_ADDR", "localhost:8125")
+
+	store := &models.ConfigStore{}
+	if err := loadStatsDSettings(store); err != nil {
+		t.Fatalf("loadStatsDSettings() error: %v", err)
+	}
+	if store.StatsDPrefix != defaultStatsDPrefix || store.StatsDFormat != "dogstatsd" {
+		t.Errorf("unexpected defaults: %q, %q", store.StatsDPrefix, store.StatsDFormat)
+	}
+
+	t.Setenv("PORTUS_STATSD_FORMAT", "influx")
+	if err := loadStatsDSettings(store); err == nil {
+		t.Error("expected error for unknown format")
+	}
+
+	t.Setenv("PORTUS_STATSD_FORMAT", "")
+	t.Setenv("PORTUS_STATSD_ADDR", "localhost")
+	if err := loadStatsDSettings(store); err == nil {
+		t.Error("expected error for address without port")
+	}
+}
+
+func TestParseKeyValue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   string
+		want    []models.ProxyKey
+		wantErr bool
+	}{
+		{
+			name:  "single key",
+			value: "pk-current",
+			want:  []models.ProxyKey{{Key: "pk-current"}},
+		},
+		{
+			name:  "rotating pair",
+			value: "pk-new, pk-old@2026-04-01T00:00:00Z",
+			want: []models.ProxyKey{
+				{Key: "pk-new"},
+				{Key: "pk-old", ExpiresAt: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:    "invalid expiry",
+			value:   "pk-old@next-quarter",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseKeyValue("PORTUS_KEY_APP", tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKeyValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d keys, got %+v", len(tt.want), got)
+			}
+			for i := range got {
+				if got[i].Key != tt.want[i].Key || !got[i].ExpiresAt.Equal(tt.want[i].ExpiresAt) {
+					t.Errorf("key %d: expected %+v, got %+v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateConfig_MockModeToleratesMissingCredentials(t *testing.T) {
+	t.Parallel()
+
+	newStore := func(mockMode string) *models.ConfigStore {
+		return &models.ConfigStore{
+			ProxyKeys:  []models.ProxyKey{{Key: "pk-test", Application: "TESTS", Scope: models.ScopeInference}},
+			Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai"}},
+			RawConfigs: map[string]string{"gpt4": `{"provider": "openai", "api_key": "${PORTUS_TEST_UNSET_OPENAI_KEY}"}`},
+			MockMode:   mockMode,
+		}
+	}
+
+	if errs := ValidateConfig(newStore("")); len(errs) != 2 {
+		t.Errorf("expected missing variable and api_key errors, got %v", errs)
+	}
+	if errs := ValidateConfig(newStore("echo")); len(errs) != 0 {
+		t.Errorf("expected mock mode to tolerate missing credentials, got %v", errs)
+	}
+}
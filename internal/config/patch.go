@@ -0,0 +1,227 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+// patchMu serializes model patches so concurrent operators can't interleave
+// file writes for the same aliases.
+var patchMu sync.Mutex
+
+// writeModelFile writes a patched model config; tests replace it to fail
+// part way through a patch.
+var writeModelFile = writeFileAtomic
+
+// ModelSelector chooses the aliases a patch applies to. All non-empty criteria
+// must match.
+type ModelSelector struct {
+	// Aliases lists exact alias names.
+	Aliases []string `json:"aliases,omitempty"`
+	// Pattern is a glob such as "gpt-*" matched against alias names.
+	Pattern string `json:"pattern,omitempty"`
+	// Provider matches the alias provider (or first target provider).
+	Provider string `json:"provider,omitempty"`
+}
+
+// IsEmpty reports whether the selector has no criteria.
+func (s ModelSelector) IsEmpty() bool {
+	return len(s.Aliases) == 0 && s.Pattern == "" && s.Provider == ""
+}
+
+// Matches reports whether an alias satisfies the selector.
+func (s ModelSelector) Matches(alias string, model models.ModelConfig) bool {
+	if len(s.Aliases) > 0 && !slices.Contains(s.Aliases, alias) {
+		return false
+	}
+	if s.Pattern != "" {
+		if ok, err := path.Match(s.Pattern, alias); err != nil || !ok {
+			return false
+		}
+	}
+	if s.Provider != "" {
+		provider := model.Provider
+		if provider == "" && len(model.Targets) > 0 {
+			provider = model.Targets[0].Provider
+		}
+		if provider != s.Provider {
+			return false
+		}
+	}
+	return true
+}
+
+// PatchModels applies a JSON merge patch (RFC 7386) to every alias matching the
+// selector. The patch is applied to the raw config files, so ${VAR} references
+// are preserved and secrets are never written back. Every patched config is
+// validated before anything is written; if one fails, nothing changes. With
+// dryRun, the patch is validated but neither files nor the store are updated.
+// Configs mirrored from S3 or GCS, and aliases rendered from templates,
+// cannot be patched. It returns the patched aliases in sorted order.
+func PatchModels(store *models.ConfigStore, selector ModelSelector, patch map[string]interface{}, dryRun bool) ([]string, error) {
+	if selector.IsEmpty() {
+		return nil, fmt.Errorf("selector must specify at least one of aliases, pattern or provider")
+	}
+	if _, err := path.Match(selector.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid selector pattern %q: %w", selector.Pattern, err)
+	}
+	if len(patch) == 0 {
+		return nil, fmt.Errorf("patch must not be empty")
+	}
+	if store.RemoteConfigURL != "" {
+		return nil, fmt.Errorf("model configs are loaded from %s; change them there", store.RemoteConfigURL)
+	}
+
+	patchMu.Lock()
+	defer patchMu.Unlock()
+
+	modelsDir := filepath.Join(store.ConfigPath, "models")
+	rawUpdates := make(map[string][]byte)
+	originals := make(map[string][]byte)
+	updates := make(map[string]models.ModelConfig)
+	var matched []string
+
+	defaults, err := readModelDefaults(modelsDir)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := readProviderBlocks(store.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, alias := range store.ModelAliases() {
+		model, _ := store.Model(alias)
+		if !selector.Matches(alias, model) {
+			continue
+		}
+		matched = append(matched, alias)
+
+		// A merge patch cannot be written back into a template
+		if template := filepath.Join(modelsDir, alias+templateSuffix); isFile(template) {
+			return nil, fmt.Errorf("model %s is rendered from %s; edit the template instead", alias, template)
+		}
+		file := filepath.Join(modelsDir, alias+".json")
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read model config %s: %w", file, err)
+		}
+
+		raw := make(map[string]interface{})
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse model config %s: %w", file, err)
+		}
+		mergePatch(raw, patch)
+
+		patched, err := json.MarshalIndent(raw, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode model config %s: %w", alias, err)
+		}
+		patched = append(patched, '\n')
+
+		effective, err := mergeModelDefaults(defaults, patched)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge model defaults into %s: %w", alias, err)
+		}
+		if effective, err = applyProviderBlocks(blocks, effective); err != nil {
+			return nil, fmt.Errorf("patched model %s: %w", alias, err)
+		}
+		config, err := ParseModelConfig(alias, effective)
+		if err != nil {
+			return nil, fmt.Errorf("patched %w", err)
+		}
+		if err := CheckBannedModels(alias, config, store.BannedModels); err != nil {
+			return nil, fmt.Errorf("patched %w", err)
+		}
+
+		originals[alias] = data
+		rawUpdates[alias] = patched
+		updates[alias] = config
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("selector matched no model aliases")
+	}
+	if dryRun {
+		return matched, nil
+	}
+
+	// Restore the files already written if a later one fails, so the files
+	// keep matching the store
+	for i, alias := range matched {
+		if err := writeModelFile(filepath.Join(modelsDir, alias+".json"), rawUpdates[alias]); err != nil {
+			for _, written := range matched[:i] {
+				if restoreErr := writeFileAtomic(filepath.Join(modelsDir, written+".json"), originals[written]); restoreErr != nil {
+					return nil, fmt.Errorf("%w; restoring %s also failed: %v", err, written, restoreErr)
+				}
+			}
+			return nil, err
+		}
+	}
+	store.SetModels(updates)
+
+	return matched, nil
+}
+
+// isFile reports whether path exists and is not a directory.
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// mergePatch applies an RFC 7386 JSON merge patch to target in place.
+func mergePatch(target map[string]interface{}, patch map[string]interface{}) {
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+		if patchObj, ok := value.(map[string]interface{}); ok {
+			targetObj, ok := target[key].(map[string]interface{})
+			if !ok {
+				targetObj = make(map[string]interface{})
+			}
+			mergePatch(targetObj, patchObj)
+			target[key] = targetObj
+			continue
+		}
+		target[key] = value
+	}
+}
+
+// writeFileAtomic replaces a file via a temporary file and rename, keeping the
+// original file mode.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("failed to set mode on %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+func writeModelFiles(t *testing.T, files map[string]string) *models.ConfigStore {
+	t.Helper()
+	dir := t.TempDir()
+	modelsDir := filepath.Join(dir, "models")
+	if err := os.MkdirAll(modelsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for alias, content := range files {
+		if err := os.WriteFile(filepath.Join(modelsDir, alias+".json"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := &models.ConfigStore{
+		Models:     make(map[string]models.ModelConfig),
+		RawConfigs: make(map[string]string),
+		ConfigPath: dir,
+	}
+	if err := loadModelConfigs(store); err != nil {
+		t.Fatalf("loadModelConfigs() error: %v", err)
+	}
+	return store
+}
+
+func TestPatchModels(t *testing.T) {
+	t.Setenv("PATCH_TEST_OPENAI_KEY", "sk-from-env")
+
+	store := writeModelFiles(t, map[string]string{
+		"gpt-4":  `{"provider": "openai", "api_key": "${PATCH_TEST_OPENAI_KEY}", "retry": {"attempts": 1}}`,
+		"gpt-4o": `{"provider": "openai", "api_key": "${PATCH_TEST_OPENAI_KEY}"}`,
+		"claude": `{"provider": "anthropic", "api_key": "sk-ant"}`,
+	})
+
+	patch := map[string]interface{}{
+		"retry":           map[string]interface{}{"attempts": float64(5)},
+		"request_timeout": float64(30000),
+	}
+	updated, err := PatchModels(store, ModelSelector{Pattern: "gpt-*"}, patch, false)
+	if err != nil {
+		t.Fatalf("PatchModels() error: %v", err)
+	}
+	if strings.Join(updated, ",") != "gpt-4,gpt-4o" {
+		t.Fatalf("expected gpt-4 and gpt-4o to be updated, got %v", updated)
+	}
+
+	model, _ := store.Model("gpt-4o")
+	if model.Retry == nil || model.Retry.Attempts != 5 || model.RequestTimeout != 30000 {
+		t.Errorf("expected patched config in store, got %+v", model)
+	}
+	if model.APIKey != "sk-from-env" {
+		t.Errorf("expected api_key to be expanded in store, got %q", model.APIKey)
+	}
+	if claude, _ := store.Model("claude"); claude.Retry != nil {
+		t.Error("expected unmatched alias to be unchanged")
+	}
+
+	// Files keep the env var reference rather than the secret
+	data, err := os.ReadFile(filepath.Join(store.ConfigPath, "models", "gpt-4.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "${PATCH_TEST_OPENAI_KEY}") || strings.Contains(string(data), "sk-from-env") {
+		t.Errorf("expected raw env reference to be persisted, got %s", data)
+	}
+}
+
+func TestPatchModels_InvalidPatchChangesNothing(t *testing.T) {
+	store := writeModelFiles(t, map[string]string{
+		"gpt-4":   `{"provider": "openai", "api_key": "sk-1"}`,
+		"bedrock": `{"provider": "bedrock", "aws_access_key_id": "a", "aws_secret_access_key": "b", "aws_region": "us-east-1"}`,
+	})
+	before, _ := os.ReadFile(filepath.Join(store.ConfigPath, "models", "gpt-4.json"))
+
+	// Removing api_key is valid for bedrock but not for openai
+	patch := map[string]interface{}{"api_key": nil}
+	if _, err := PatchModels(store, ModelSelector{Pattern: "*"}, patch, false); err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	after, _ := os.ReadFile(filepath.Join(store.ConfigPath, "models", "gpt-4.json"))
+	if string(before) != string(after) {
+		t.Error("expected config file to be unchanged after failed patch")
+	}
+	if model, _ := store.Model("gpt-4"); model.APIKey != "sk-1" {
+		t.Error("expected store to be unchanged after failed patch")
+	}
+}
+
+func TestPatchModels_FailedWriteRestoresFiles(t *testing.T) {
+	store := writeModelFiles(t, map[string]string{
+		"gpt-4":  `{"provider": "openai", "api_key": "sk-1"}`,
+		"gpt-4o": `{"provider": "openai", "api_key": "sk-2"}`,
+	})
+	before, _ := os.ReadFile(filepath.Join(store.ConfigPath, "models", "gpt-4.json"))
+
+	// The second write fails after the first file was replaced
+	writes := 0
+	writeModelFile = func(path string, data []byte) error {
+		if writes++; writes == 2 {
+			return errors.New("disk full")
+		}
+		return writeFileAtomic(path, data)
+	}
+	t.Cleanup(func() { writeModelFile = writeFileAtomic })
+
+	patch := map[string]interface{}{"request_timeout": 30000}
+	if _, err := PatchModels(store, ModelSelector{Pattern: "gpt-*"}, patch, false); err == nil {
+		t.Fatal("expected write error")
+	}
+
+	after, _ := os.ReadFile(filepath.Join(store.ConfigPath, "models", "gpt-4.json"))
+	if string(before) != string(after) {
+		t.Errorf("expected gpt-4.json to be restored, got %s", after)
+	}
+	if model, _ := store.Model("gpt-4"); model.RequestTimeout != 0 {
+		t.Error("expected store to be unchanged after failed patch")
+	}
+}
+
+func TestPatchModels_DryRunAndSelectors(t *testing.T) {
+	store := writeModelFiles(t, map[string]string{
+		"gpt-4":  `{"provider": "openai", "api_key": "sk-1"}`,
+		"claude": `{"provider": "anthropic", "api_key": "sk-2"}`,
+	})
+
+	patch := map[string]interface{}{"request_timeout": float64(1000)}
+
+	updated, err := PatchModels(store, ModelSelector{Provider: "anthropic"}, patch, true)
+	if err != nil {
+		t.Fatalf("PatchModels() error: %v", err)
+	}
+	if len(updated) != 1 || updated[0] != "claude" {
+		t.Errorf("expected only claude to match, got %v", updated)
+	}
+	if model, _ := store.Model("claude"); model.RequestTimeout != 0 {
+		t.Error("expected dry run to leave store unchanged")
+	}
+
+	if _, err := PatchModels(store, ModelSelector{}, patch, false); err == nil {
+		t.Error("expected error for empty selector")
+	}
+	if _, err := PatchModels(store, ModelSelector{Aliases: []string{"missing"}}, patch, false); err == nil {
+		t.Error("expected error when selector matches nothing")
+	}
+}
+
+func TestPatchModels_KeepsDefaults(t *testing.T) {
+	store := writeModelFiles(t, map[string]string{
+		"_defaults": `{"request_timeout": 60000}`,
+		"gpt-4":     `{"provider": "openai", "api_key": "sk-1"}`,
+	})
+
+	patch := map[string]interface{}{"retry": map[string]interface{}{"attempts": float64(2)}}
+	if _, err := PatchModels(store, ModelSelector{Aliases: []string{"gpt-4"}}, patch, false); err != nil {
+		t.Fatalf("PatchModels() error: %v", err)
+	}
+
+	model, _ := store.Model("gpt-4")
+	if model.RequestTimeout != 60000 || model.Retry == nil || model.Retry.Attempts != 2 {
+		t.Errorf("expected patched config to keep inherited defaults, got %+v", model)
+	}
+
+	// Defaults stay in _defaults.json rather than being copied into the alias
+	data, err := os.ReadFile(filepath.Join(store.ConfigPath, "models", "gpt-4.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "request_timeout") {
+		t.Errorf("expected defaults not to be written to the alias file, got %s", data)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	t.Parallel()
+
+	target := map[string]interface{}{
+		"retry":    map[string]interface{}{"attempts": 1.0, "on_status_codes": []interface{}{429.0}},
+		"provider": "openai",
+		"drop":     "me",
+	}
+	mergePatch(target, map[string]interface{}{
+		"retry": map[string]interface{}{"attempts": 3.0},
+		"drop":  nil,
+		"new":   "value",
+	})
+
+	retry := target["retry"].(map[string]interface{})
+	if retry["attempts"] != 3.0 || retry["on_status_codes"] == nil {
+		t.Errorf("expected nested merge, got %v", retry)
+	}
+	if _, ok := target["drop"]; ok {
+		t.Error("expected null to delete field")
+	}
+	if target["new"] != "value" || target["provider"] != "openai" {
+		t.Errorf("unexpected merge result: %v", target)
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// providersDir is the config directory subdirectory holding shared provider
+// blocks, e.g. providers/bedrock-prod.json.
+const providersDir = "providers"
+
+// providerRefField names the provider block an alias or target inherits.
+const providerRefField = "provider_ref"
+
+// providerBlockFields are the fields a provider block may set: the provider,
+// its credentials and where to reach it.
+var providerBlockFields = map[string]bool{
+	"provider":                    true,
+	"api_key":                     true,
+	"custom_host":                 true,
+	"base_url":                    true,
+	"forward_headers":             true,
+	"custom_headers":              true,
+	"override_params":             true,
+	"aws_access_key_id":           true,
+	"aws_secret_access_key":       true,
+	"aws_region":                  true,
+	"aws_session_token":           true,
+	"vertex_project_id":           true,
+	"vertex_region":               true,
+	"vertex_service_account_json": true,
+}
+
+// readProviderBlocks returns the raw content of each provider block in the
+// config directory, keyed by name, or nil when there are none.
+func readProviderBlocks(configPath string) (map[string][]byte, error) {
+	dir := filepath.Join(configPath, providersDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read providers directory: %w", err)
+	}
+
+	blocks := make(map[string][]byte)
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read provider block %s: %w", path, err)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("failed to parse provider block %s: %w", path, err)
+		}
+		for field := range fields {
+			if !providerBlockFields[field] {
+				return nil, fmt.Errorf("provider block %s cannot set %s", path, field)
+			}
+		}
+		blocks[name] = data
+	}
+	return blocks, nil
+}
+
+// applyProviderBlocks returns a raw model config with the provider block named
+// by its provider_ref, and by each target's, merged in. Fields set by the
+// alias or target win, and an explicit null removes an inherited field, as
+// with _defaults.json. Blocks are merged before environment variable
+// expansion, so ${VAR} and secret references in them work as in model files.
+func applyProviderBlocks(blocks map[string][]byte, data []byte) ([]byte, error) {
+	config := make(map[string]interface{})
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if err := inheritProviderBlock(blocks, config); err != nil {
+		return nil, err
+	}
+	if targets, ok := config["targets"].([]interface{}); ok {
+		for i, target := range targets {
+			obj, ok := target.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := inheritProviderBlock(blocks, obj); err != nil {
+				return nil, fmt.Errorf("target %d: %w", i, err)
+			}
+		}
+	}
+	return json.Marshal(config)
+}
+
+// inheritProviderBlock merges the block named by obj's provider_ref into obj.
+func inheritProviderBlock(blocks map[string][]byte, obj map[string]interface{}) error {
+	ref, ok := obj[providerRefField]
+	if !ok {
+		return nil
+	}
+	delete(obj, providerRefField)
+	name, _ := ref.(string)
+	block, ok := blocks[name]
+	if !ok {
+		return fmt.Errorf("unknown provider block %q; blocks are defined in %s/<name>.json", ref, providersDir)
+	}
+	inherited := make(map[string]interface{})
+	if err := json.Unmarshal(block, &inherited); err != nil {
+		return err
+	}
+	mergePatch(inherited, obj)
+	clear(obj)
+	for k, v := range inherited {
+		obj[k] = v
+	}
+	return nil
+}
+
+// unresolvedProviderRef reports the provider_ref left in a raw model config
+// that was not loaded from the models directory, such as a pushed bundle's.
+func unresolvedProviderRef(raw []byte) string {
+	var refs struct {
+		ProviderRef string `json:"provider_ref"`
+		Targets     []struct {
+			ProviderRef string `json:"provider_ref"`
+		} `json:"targets"`
+	}
+	json.Unmarshal(raw, &refs)
+	if refs.ProviderRef != "" {
+		return refs.ProviderRef
+	}
+	for _, target := range refs.Targets {
+		if target.ProviderRef != "" {
+			return target.ProviderRef
+		}
+	}
+	return ""
+}
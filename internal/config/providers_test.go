@@ -0,0 +1,136 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadModelConfigs_ProviderBlocks(t *testing.T) {
+	t.Setenv("BLOCK_AWS_KEY", "AKID")
+	t.Setenv("BLOCK_OPENAI_KEY", "sk-shared")
+
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"providers/bedrock-prod.json": `{"provider": "bedrock", "aws_access_key_id": "${BLOCK_AWS_KEY}", "aws_secret_access_key": "secret", "aws_region": "us-east-1"}`,
+		"providers/openai.json":       `{"provider": "openai", "api_key": "${BLOCK_OPENAI_KEY}", "override_params": {"temperature": 0.2}}`,
+		"models/gpt.json":             `{"provider_ref": "openai", "override_params": {"model": "gpt-4o"}}`,
+		"models/claude.json": `{
+			"strategy": {"mode": "fallback"},
+			"targets": [
+				{"provider_ref": "bedrock-prod", "aws_region": "eu-west-1"},
+				{"provider_ref": "bedrock-prod"},
+				{"provider_ref": "openai", "api_key": null, "custom_host": "http://vllm.internal:8000/v1"}
+			]
+		}`,
+	})
+
+	store := &models.ConfigStore{ConfigPath: dir, Models: make(map[string]models.ModelConfig), RawConfigs: make(map[string]string)}
+	if err := loadModelConfigs(store); err != nil {
+		t.Fatal(err)
+	}
+
+	gpt := store.Models["gpt"]
+	if gpt.Provider != "openai" || gpt.APIKey != "sk-shared" {
+		t.Errorf("expected gpt to inherit the openai block, got %+v", gpt)
+	}
+	if gpt.OverrideParams["model"] != "gpt-4o" || gpt.OverrideParams["temperature"] != 0.2 {
+		t.Errorf("expected override_params merged field by field, got %v", gpt.OverrideParams)
+	}
+
+	claude := store.Models["claude"]
+	if len(claude.Targets) != 3 {
+		t.Fatalf("expected 3 targets, got %+v", claude.Targets)
+	}
+	if first := claude.Targets[0]; first.Provider != "bedrock" || first.AWSAccessKeyID != "AKID" || first.AWSRegion != "eu-west-1" {
+		t.Errorf("expected the first target to override the block's region, got %+v", first)
+	}
+	if second := claude.Targets[1]; second.AWSRegion != "us-east-1" {
+		t.Errorf("expected the second target to keep the block's region, got %+v", second)
+	}
+	if third := claude.Targets[2]; third.Provider != "openai" || third.APIKey != "" || third.CustomHost == "" {
+		t.Errorf("expected null to drop the block's api_key, got %+v", third)
+	}
+	if _, ok := store.RawConfigs["providers/openai"]; !ok {
+		t.Error("expected provider blocks to be checked for missing environment variables")
+	}
+	if err := validateModelConfig("gpt", gpt); err != nil {
+		t.Errorf("expected the inherited config to validate, got %v", err)
+	}
+}
+
+func TestLoadModelConfigs_ProviderBlockErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "unknown block",
+			files:   map[string]string{"models/gpt.json": `{"provider_ref": "openai"}`},
+			wantErr: `unknown provider block "openai"`,
+		},
+		{
+			name: "unknown block in a target",
+			files: map[string]string{
+				"providers/openai.json": `{"provider": "openai", "api_key": "sk"}`,
+				"models/gpt.json":       `{"strategy": {"mode": "fallback"}, "targets": [{"provider_ref": "openai"}, {"provider_ref": "azure"}]}`,
+			},
+			wantErr: "target 1",
+		},
+		{
+			name: "block with routing fields",
+			files: map[string]string{
+				"providers/openai.json": `{"provider": "openai", "request_timeout": 1000}`,
+				"models/gpt.json":       `{"provider_ref": "openai"}`,
+			},
+			wantErr: "cannot set request_timeout",
+		},
+		{
+			name:    "malformed block",
+			files:   map[string]string{"providers/openai.json": `{"provider": `, "models/gpt.json": `{}`},
+			wantErr: "failed to parse provider block",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			writeConfigFiles(t, dir, tt.files)
+			store := &models.ConfigStore{ConfigPath: dir, Models: make(map[string]models.ModelConfig), RawConfigs: make(map[string]string)}
+			err := loadModelConfigs(store)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseModelConfig_ProviderRef(t *testing.T) {
+	t.Parallel()
+
+	// Pushed bundles have no providers directory to resolve against
+	_, err := ParseModelConfig("gpt", []byte(`{"strategy": {"mode": "fallback"}, "targets": [{"provider_ref": "openai"}]}`))
+	if err == nil || !strings.Contains(err.Error(), `provider block "openai"`) {
+		t.Errorf("expected an unresolved provider_ref to be rejected, got %v", err)
+	}
+}
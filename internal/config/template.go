@@ -0,0 +1,78 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// templateSuffix marks a model config rendered with text/template before it
+// is parsed, e.g. models/claude.json.tmpl for the alias claude.
+const templateSuffix = ".json.tmpl"
+
+// templateData is the dot of a model config template.
+type templateData struct {
+	// Alias is the alias the file defines.
+	Alias string
+}
+
+// templateFuncs are available in model config templates, on top of the
+// text/template builtins such as eq, and, or and not.
+var templateFuncs = template.FuncMap{
+	// env returns an environment variable, empty when unset. Like ${VAR},
+	// it ignores PORTUS_ENV_PREFIX.
+	"env": os.Getenv,
+	// default returns value, or fallback when value is empty, so it reads
+	// well at the end of a pipeline: {{env "REGION" | default "us-east-1"}}.
+	"default": func(fallback, value any) any {
+		if value == nil || value == "" {
+			return fallback
+		}
+		return value
+	},
+	// required fails rendering with message when value is empty.
+	"required": func(message string, value any) (any, error) {
+		if value == nil || value == "" {
+			return nil, errors.New(message)
+		}
+		return value, nil
+	},
+	// split splits s on sep, trimming spaces and dropping empty parts:
+	// {{range split "," (env "REGIONS")}}.
+	"split": func(sep, s string) []string {
+		var parts []string
+		for _, part := range strings.Split(s, sep) {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		return parts
+	},
+	// list builds a list in place: {{range list "us-east-1" "eu-west-1"}}.
+	"list": func(items ...any) []any {
+		return items
+	},
+	// json encodes a value as JSON, quoting and escaping strings.
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// renderModelTemplate renders a model config template for alias. A missing
+// map key or an error from a function fails rendering.
+func renderModelTemplate(alias, path string, data []byte) ([]byte, error) {
+	tmpl, err := template.New(path).Funcs(templateFuncs).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse model template %s: %w", path, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, templateData{Alias: alias}); err != nil {
+		return nil, fmt.Errorf("failed to render model template %s: %w", path, err)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+func TestRenderModelTemplate(t *testing.T) {
+	t.Setenv("TEMPLATE_REGIONS", "us-east-1, eu-west-1,")
+	t.Setenv("TEMPLATE_TIER", "prod")
+	t.Setenv("TEMPLATE_NAME", `say "hi"`)
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  string
+	}{
+		{name: "plain JSON", template: `{"provider": "openai"}`, want: `{"provider": "openai"}`},
+		{name: "default used", template: `{{env "TEMPLATE_UNSET" | default "gpt-4o-mini"}}`, want: `gpt-4o-mini`},
+		{name: "default skipped", template: `{{env "TEMPLATE_TIER" | default "dev"}}`, want: `prod`},
+		{name: "if else on env", template: `{{if eq (env "TEMPLATE_TIER") "prod"}}5{{else}}1{{end}}`, want: `5`},
+		{name: "loop over regions", template: `[{{range $i, $r := split "," (env "TEMPLATE_REGIONS")}}{{if $i}}, {{end}}"{{$r}}"{{end}}]`, want: `["us-east-1", "eu-west-1"]`},
+		{name: "list", template: `{{range list "a" "b"}}{{.}}{{end}}`, want: `ab`},
+		{name: "json quoting", template: `{"name": {{json (env "TEMPLATE_NAME")}}}`, want: `{"name": "say \"hi\""}`},
+		{name: "alias", template: `{{.Alias}}`, want: `claude`},
+		{name: "required missing", template: `{{env "TEMPLATE_UNSET" | required "TEMPLATE_UNSET must be set"}}`, wantErr: "TEMPLATE_UNSET must be set"},
+		{name: "syntax error", template: `{{if}}`, wantErr: "failed to parse model template"},
+		{name: "unknown field", template: `{{.Region}}`, wantErr: "failed to render model template"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderModelTemplate("claude", "models/claude.json.tmpl", []byte(tt.template))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadModelConfigs_Templates(t *testing.T) {
+	t.Setenv("TEMPLATE_REGIONS", "us-east-1,eu-west-1")
+	t.Setenv("TEMPLATE_AWS_KEY", "AKID")
+
+	dir := t.TempDir()
+	modelsDir := filepath.Join(dir, "models")
+	if err := os.MkdirAll(modelsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeModel := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(modelsDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeModel("claude.json.tmpl", `{
+  "strategy": {"mode": "loadbalance"},
+  "targets": [
+    {{- range $i, $region := split "," (env "TEMPLATE_REGIONS")}}{{if $i}},{{end}}
+    {"provider": "bedrock", "aws_access_key_id": "${TEMPLATE_AWS_KEY}", "aws_secret_access_key": "secret", "aws_region": "{{$region}}"}
+    {{- end}}
+  ]
+}`)
+	writeModel("gpt.json", `{"provider": "openai", "api_key": "sk-1"}`)
+
+	store := &models.ConfigStore{ConfigPath: dir, Models: make(map[string]models.ModelConfig), RawConfigs: make(map[string]string)}
+	if err := loadModelConfigs(store); err != nil {
+		t.Fatal(err)
+	}
+	claude, ok := store.Models["claude"]
+	if !ok || len(claude.Targets) != 2 {
+		t.Fatalf("expected claude with a target per region, got %+v", claude)
+	}
+	if claude.Targets[1].AWSRegion != "eu-west-1" || claude.Targets[0].AWSAccessKeyID != "AKID" {
+		t.Errorf("expected rendered and expanded targets, got %+v", claude.Targets)
+	}
+	if _, ok := store.Models["gpt"]; !ok {
+		t.Error("expected plain model files to load alongside templates")
+	}
+
+	// A template cannot be patched in place
+	if _, err := PatchModels(store, ModelSelector{Aliases: []string{"claude"}}, map[string]interface{}{"request_timeout": 1}, true); err == nil || !strings.Contains(err.Error(), "edit the template") {
+		t.Errorf("expected patching a template to be refused, got %v", err)
+	}
+
+	writeModel("gpt.json.tmpl", `{"provider": "openai", "api_key": "sk-2"}`)
+	store = &models.ConfigStore{ConfigPath: dir, Models: make(map[string]models.ModelConfig), RawConfigs: make(map[string]string)}
+	if err := loadModelConfigs(store); err == nil || !strings.Contains(err.Error(), "defined by both") {
+		t.Errorf("expected an alias defined twice to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+// Package controlplane accepts configuration bundles pushed by a central fleet
+// manager, applying them atomically and keeping recent versions for rollback.
+package controlplane
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/amscotti/portus/internal/apiversion"
+	"github.com/amscotti/portus/internal/config"
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/quota"
+)
+
+// SupportedSchemaVersions lists the bundle schema versions this build accepts.
+var SupportedSchemaVersions = []int{1}
+
+// historySize is how many previous bundles are kept for rollback.
+const historySize = 5
+
+// LocalVersion identifies the configuration loaded from files at startup.
+const LocalVersion = "local"
+
+// Bundle is a complete configuration pushed by the fleet manager.
+type Bundle struct {
+	// SchemaVersion must be one of SupportedSchemaVersions.
+	SchemaVersion int `json:"schema_version"`
+	// Version is an opaque identifier assigned by the manager.
+	Version string `json:"version"`
+	// Models replaces every alias. Values are raw model configs and may use
+	// ${VAR} references, expanded from this instance's environment.
+	Models map[string]json.RawMessage `json:"models"`
+	// Keys replaces every proxy key when present; omit to keep current keys.
+	Keys []Key `json:"keys,omitempty"`
+}
+
+// Key is a proxy key definition within a bundle.
+type Key struct {
+	Application string          `json:"application"`
+	Key         string          `json:"key"`
+	Scope       models.KeyScope `json:"scope,omitempty"`
+	ExpiresAt   time.Time       `json:"expires_at,omitempty"`
+	MaxStreams  int             `json:"max_streams,omitempty"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+	// RequestQuota is written as "limit/window", e.g. "10000/day".
+	RequestQuota       string            `json:"request_quota,omitempty"`
+	ConversationTokens int               `json:"conversation_tokens,omitempty"`
+	APIVersion         string            `json:"api_version,omitempty"`
+	Disabled           bool              `json:"disabled,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+}
+
+// SchemaError reports a bundle whose schema version this build cannot read.
+type SchemaError struct {
+	SchemaVersion int
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("unsupported schema_version %d", e.SchemaVersion)
+}
+
+// ErrNoHistory is returned by Rollback when there is nothing to roll back to.
+var ErrNoHistory = errors.New("no previous configuration to roll back to")
+
+// Status describes the active configuration and available rollbacks.
+type Status struct {
+	Version                 string    `json:"version"`
+	AppliedAt               time.Time `json:"applied_at"`
+	Models                  int       `json:"models"`
+	Keys                    int       `json:"keys"`
+	History                 []string  `json:"history"`
+	SupportedSchemaVersions []int     `json:"supported_schema_versions"`
+}
+
+type snapshot struct {
+	version   string
+	appliedAt time.Time
+	models    map[string]models.ModelConfig
+	keys      []models.ProxyKey
+}
+
+// Plane applies bundles to the config store and keyring. It is safe for
+// concurrent use.
+type Plane struct {
+	store   *models.ConfigStore
+	keyring *middleware.Keyring
+
+	mu      sync.Mutex
+	current snapshot
+	history []snapshot
+}
+
+// New creates a control plane whose baseline is the current configuration.
+func New(store *models.ConfigStore, keyring *middleware.Keyring) *Plane {
+	return &Plane{
+		store:   store,
+		keyring: keyring,
+		current: snapshot{
+			version:   LocalVersion,
+			appliedAt: time.Now(),
+			models:    store.ModelsSnapshot(),
+			keys:      keyring.Keys(),
+		},
+	}
+}
+
+// Apply validates a bundle and, unless dryRun, makes it the active
+// configuration. Nothing changes if any part of the bundle is invalid.
+func (p *Plane) Apply(bundle Bundle, dryRun bool) error {
+	if !slices.Contains(SupportedSchemaVersions, bundle.SchemaVersion) {
+		return &SchemaError{SchemaVersion: bundle.SchemaVersion}
+	}
+	if bundle.Version == "" {
+		return errors.New("version is required")
+	}
+	if len(bundle.Models) == 0 {
+		return errors.New("bundle must contain at least one model")
+	}
+
+	parsed := make(map[string]models.ModelConfig, len(bundle.Models))
+	for alias, raw := range bundle.Models {
+		model, err := config.ParseModelConfig(alias, raw)
+		if err != nil {
+			return err
+		}
+		if err := config.CheckBannedModels(alias, model, p.store.BannedModels); err != nil {
+			return err
+		}
+		parsed[alias] = model
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := p.current.keys
+	if bundle.Keys != nil {
+		var err error
+		if keys, err = parseKeys(bundle.Keys); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		return nil
+	}
+
+	p.history = append(p.history, p.current)
+	if len(p.history) > historySize {
+		p.history = p.history[len(p.history)-historySize:]
+	}
+	p.activate(snapshot{version: bundle.Version, appliedAt: time.Now(), models: parsed, keys: keys})
+	return nil
+}
+
+// Rollback reactivates the previously applied configuration and returns its version.
+func (p *Plane) Rollback() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.history) == 0 {
+		return "", ErrNoHistory
+	}
+	previous := p.history[len(p.history)-1]
+	p.history = p.history[:len(p.history)-1]
+	previous.appliedAt = time.Now()
+	p.activate(previous)
+	return previous.version, nil
+}
+
+// Status returns the active version and the versions available for rollback,
+// most recent first.
+func (p *Plane) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	history := make([]string, 0, len(p.history))
+	for i := len(p.history) - 1; i >= 0; i-- {
+		history = append(history, p.history[i].version)
+	}
+	return Status{
+		Version:                 p.current.version,
+		AppliedAt:               p.current.appliedAt,
+		Models:                  len(p.current.models),
+		Keys:                    len(p.current.keys),
+		History:                 history,
+		SupportedSchemaVersions: SupportedSchemaVersions,
+	}
+}
+
+// Version returns the active configuration version.
+func (p *Plane) Version() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current.version
+}
+
+// activate installs s. The caller must hold p.mu.
+func (p *Plane) activate(s snapshot) {
+	p.store.ReplaceModels(s.models)
+	p.keyring.Replace(s.keys)
+	p.current = s
+}
+
+func parseKeys(bundleKeys []Key) ([]models.ProxyKey, error) {
+	keys := make([]models.ProxyKey, 0, len(bundleKeys))
+	seen := make(map[string]bool, len(bundleKeys))
+	counts := make(map[models.KeyScope]int)
+	for i, k := range bundleKeys {
+		if k.Application == "" || k.Key == "" {
+			return nil, fmt.Errorf("keys[%d]: application and key are required", i)
+		}
+		if seen[k.Key] {
+			return nil, fmt.Errorf("keys[%d]: duplicate key for application %s", i, k.Application)
+		}
+		seen[k.Key] = true
+
+		scope := k.Scope
+		switch scope {
+		case "":
+			scope = models.ScopeInference
+		case models.ScopeInference, models.ScopeObservability, models.ScopeAdmin:
+		default:
+			return nil, fmt.Errorf("keys[%d]: unknown scope %q", i, scope)
+		}
+		requestQuota, err := quota.Parse(k.RequestQuota)
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d]: %w", i, err)
+		}
+		if k.APIVersion != "" {
+			if k.APIVersion, err = apiversion.Parse(k.APIVersion); err != nil {
+				return nil, fmt.Errorf("keys[%d]: %w", i, err)
+			}
+		}
+		if err := config.ValidateTags(k.Tags); err != nil {
+			return nil, fmt.Errorf("keys[%d]: %w", i, err)
+		}
+		counts[scope]++
+		keys = append(keys, models.ProxyKey{
+			Key:                k.Key,
+			Application:        k.Application,
+			Scope:              scope,
+			ExpiresAt:          k.ExpiresAt,
+			MaxStreams:         k.MaxStreams,
+			MaxTokens:          k.MaxTokens,
+			RequestQuota:       requestQuota,
+			ConversationTokens: k.ConversationTokens,
+			APIVersion:         k.APIVersion,
+			Disabled:           k.Disabled,
+			Tags:               k.Tags,
+		})
+	}
+	if counts[models.ScopeInference] == 0 {
+		return nil, errors.New("bundle keys must include at least one inference key")
+	}
+	// Without an admin key the instance could no longer be managed or rolled back
+	if counts[models.ScopeAdmin] == 0 {
+		return nil, errors.New("bundle keys must include at least one admin key")
+	}
+	return keys, nil
+}
@@ -0,0 +1,141 @@
+package controlplane
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/models"
+)
+
+func newTestPlane() (*Plane, *models.ConfigStore, *middleware.Keyring) {
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-local"}},
+	}
+	keyring := middleware.NewKeyring([]models.ProxyKey{{Key: "pk-local", Application: "web"}})
+	return New(store, keyring), store, keyring
+}
+
+func TestPlane_ApplyAndRollback(t *testing.T) {
+	t.Setenv("BUNDLE_TEST_ANTHROPIC_KEY", "sk-ant-test")
+	plane, store, keyring := newTestPlane()
+
+	bundle := Bundle{
+		SchemaVersion: 1,
+		Version:       "v42",
+		Models: map[string]json.RawMessage{
+			"claude": json.RawMessage(`{"provider": "anthropic", "api_key": "${BUNDLE_TEST_ANTHROPIC_KEY}"}`),
+		},
+		Keys: []Key{
+			{Application: "web", Key: "pk-pushed"},
+			{Application: "ops", Key: "pk-admin", Scope: models.ScopeAdmin},
+		},
+	}
+	if err := plane.Apply(bundle, false); err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+
+	if _, ok := store.Model("gpt4"); ok {
+		t.Error("expected bundle to replace every alias")
+	}
+	if model, ok := store.Model("claude"); !ok || model.APIKey != "sk-ant-test" {
+		t.Errorf("expected claude with expanded key, got %+v", model)
+	}
+	if _, ok := keyring.Lookup("pk-local"); ok {
+		t.Error("expected pushed keys to replace local keys")
+	}
+	if _, ok := keyring.Lookup("pk-pushed"); !ok {
+		t.Error("expected pushed key to be accepted")
+	}
+	if status := plane.Status(); status.Version != "v42" || len(status.History) != 1 || status.History[0] != LocalVersion {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	version, err := plane.Rollback()
+	if err != nil || version != LocalVersion {
+		t.Fatalf("Rollback() = %q, %v", version, err)
+	}
+	if _, ok := store.Model("gpt4"); !ok {
+		t.Error("expected rollback to restore local models")
+	}
+	if _, ok := keyring.Lookup("pk-local"); !ok {
+		t.Error("expected rollback to restore local keys")
+	}
+	if _, err := plane.Rollback(); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("expected ErrNoHistory, got %v", err)
+	}
+}
+
+func TestPlane_ApplyRejects(t *testing.T) {
+	t.Parallel()
+
+	validModels := map[string]json.RawMessage{"gpt4": json.RawMessage(`{"provider": "openai", "api_key": "sk-x"}`)}
+
+	tests := []struct {
+		name   string
+		bundle Bundle
+	}{
+		{
+			name:   "unsupported schema",
+			bundle: Bundle{SchemaVersion: 99, Version: "v1", Models: validModels},
+		},
+		{
+			name:   "missing version",
+			bundle: Bundle{SchemaVersion: 1, Models: validModels},
+		},
+		{
+			name: "invalid model",
+			bundle: Bundle{SchemaVersion: 1, Version: "v1", Models: map[string]json.RawMessage{
+				"broken": json.RawMessage(`{"provider": "openai"}`),
+			}},
+		},
+		{
+			name: "missing env var",
+			bundle: Bundle{SchemaVersion: 1, Version: "v1", Models: map[string]json.RawMessage{
+				"gpt4": json.RawMessage(`{"provider": "openai", "api_key": "${BUNDLE_TEST_UNSET_VAR}"}`),
+			}},
+		},
+		{
+			name: "keys without admin",
+			bundle: Bundle{SchemaVersion: 1, Version: "v1", Models: validModels, Keys: []Key{
+				{Application: "web", Key: "pk-1"},
+			}},
+		},
+		{
+			name: "invalid request quota",
+			bundle: Bundle{SchemaVersion: 1, Version: "v1", Models: validModels, Keys: []Key{
+				{Application: "web", Key: "pk-1", RequestQuota: "100/week"},
+				{Application: "ops", Key: "ak-1", Scope: models.ScopeAdmin},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			plane, store, _ := newTestPlane()
+			if err := plane.Apply(tt.bundle, false); err == nil {
+				t.Fatal("expected bundle to be rejected")
+			}
+			if _, ok := store.Model("gpt4"); !ok || plane.Status().Version != LocalVersion {
+				t.Error("rejected bundle must not change the active configuration")
+			}
+		})
+	}
+}
+
+func TestPlane_DryRun(t *testing.T) {
+	t.Parallel()
+
+	plane, store, _ := newTestPlane()
+	bundle := Bundle{SchemaVersion: 1, Version: "v2", Models: map[string]json.RawMessage{
+		"mini": json.RawMessage(`{"provider": "openai", "api_key": "sk-x"}`),
+	}}
+	if err := plane.Apply(bundle, true); err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	if _, ok := store.Model("mini"); ok || plane.Status().Version != LocalVersion {
+		t.Error("dry run must not change the active configuration")
+	}
+}
@@ -0,0 +1,34 @@
+//go:build ignore
+
+// Buildtag adds a build constraint to generated files, which protoc cannot do:
+//
+//	go run buildtag.go <constraint> <file>...
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "usage: go run buildtag.go <constraint> <file>...")
+		os.Exit(2)
+	}
+	line := []byte("//go:build " + os.Args[1] + "\n\n")
+	for _, path := range os.Args[2:] {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if bytes.HasPrefix(data, line) {
+			continue
+		}
+		if err := os.WriteFile(path, append(line, data...), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
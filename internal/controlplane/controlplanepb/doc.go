@@ -0,0 +1,8 @@
+// Package controlplanepb holds the gRPC definition of the control plane,
+// generated from controlplane.proto with protoc 29.3, protoc-gen-go v1.36.11
+// and protoc-gen-go-grpc v1.5.1. The generated code is only compiled into
+// binaries built with the grpc build tag.
+package controlplanepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative controlplane.proto
+//go:generate go run buildtag.go grpc controlplane.pb.go controlplane_grpc.pb.go
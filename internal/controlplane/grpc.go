@@ -0,0 +1,250 @@
+//go:build grpc
+
+package controlplane
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/amscotti/portus/internal/controlplane/controlplanepb"
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/freeze"
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/models"
+)
+
+// maxMessageSize limits pushed bundles, matching the admin API's limit.
+const maxMessageSize = 10 * 1024 * 1024 // 10 MB
+
+type operatorKey struct{}
+
+// GRPCServer serves the control plane over gRPC to fleet managers. Calls
+// authenticate with an admin-scoped proxy key, sent as "authorization:
+// Bearer <key>" or "x-api-key" metadata.
+type GRPCServer struct {
+	controlplanepb.UnimplementedControlPlaneServer
+
+	plane    *Plane
+	keyring  *middleware.Keyring
+	readOnly *freeze.Switch
+	hub      *events.Hub
+	logger   *slog.Logger
+	server   *grpc.Server
+}
+
+// NewGRPCServer creates a gRPC server for plane. tlsConfig enables TLS; nil
+// serves plaintext.
+func NewGRPCServer(plane *Plane, keyring *middleware.Keyring, readOnly *freeze.Switch, hub *events.Hub, tlsConfig *tls.Config, logger *slog.Logger) (*GRPCServer, error) {
+	s := &GRPCServer{plane: plane, keyring: keyring, readOnly: readOnly, hub: hub, logger: logger}
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxMessageSize),
+		grpc.UnaryInterceptor(s.authenticate),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s.server = grpc.NewServer(opts...)
+	controlplanepb.RegisterControlPlaneServer(s.server, s)
+	return s, nil
+}
+
+// Serve accepts connections on lis until Stop is called.
+func (s *GRPCServer) Serve(lis net.Listener) error {
+	err := s.server.Serve(lis)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return nil
+	}
+	return err
+}
+
+// Stop waits for in-flight calls to finish, closing them once ctx is done.
+func (s *GRPCServer) Stop(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.server.Stop()
+	}
+}
+
+// GetStatus implements controlplanepb.ControlPlaneServer.
+func (s *GRPCServer) GetStatus(context.Context, *controlplanepb.GetStatusRequest) (*controlplanepb.Status, error) {
+	return statusProto(s.plane.Status()), nil
+}
+
+// ApplyBundle implements controlplanepb.ControlPlaneServer.
+func (s *GRPCServer) ApplyBundle(ctx context.Context, req *controlplanepb.ApplyBundleRequest) (*controlplanepb.Status, error) {
+	operator, _ := ctx.Value(operatorKey{}).(string)
+	if err := s.writable(operator, "ApplyBundle"); err != nil {
+		return nil, err
+	}
+	if req.GetBundle() == nil {
+		return nil, status.Error(codes.InvalidArgument, "bundle is required")
+	}
+
+	bundle := bundleFromProto(req.GetBundle())
+	dryRun := req.GetDryRun()
+	if err := s.plane.Apply(bundle, dryRun); err != nil {
+		s.logger.Warn("config bundle rejected",
+			"operator", operator,
+			"version", bundle.Version,
+			"schema_version", bundle.SchemaVersion,
+			"error", err,
+		)
+		var schemaErr *SchemaError
+		if errors.As(err, &schemaErr) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	s.logger.Info("config bundle applied",
+		"operator", operator,
+		"version", bundle.Version,
+		"models", len(bundle.Models),
+		"keys_updated", bundle.Keys != nil,
+		"dry_run", dryRun,
+	)
+	if !dryRun {
+		s.hub.Publish(events.ConfigApplied, operator, map[string]any{
+			"version":      bundle.Version,
+			"models":       len(bundle.Models),
+			"keys_updated": bundle.Keys != nil,
+		})
+	}
+	return statusProto(s.plane.Status()), nil
+}
+
+// Rollback implements controlplanepb.ControlPlaneServer.
+func (s *GRPCServer) Rollback(ctx context.Context, _ *controlplanepb.RollbackRequest) (*controlplanepb.Status, error) {
+	operator, _ := ctx.Value(operatorKey{}).(string)
+	if err := s.writable(operator, "Rollback"); err != nil {
+		return nil, err
+	}
+	version, err := s.plane.Rollback()
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	s.logger.Info("config bundle rolled back", "operator", operator, "version", version)
+	s.hub.Publish(events.ConfigRolledBack, operator, map[string]any{"version": version})
+	return statusProto(s.plane.Status()), nil
+}
+
+// authenticate admits calls made with an enabled, unexpired admin key, as the
+// admin API does, and records the key's application as the operator.
+func (s *GRPCServer) authenticate(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if values := md.Get("authorization"); len(values) > 0 {
+		token = values[0]
+		if strings.HasPrefix(strings.ToLower(token), "bearer ") {
+			token = token[7:]
+		}
+	} else if values := md.Get("x-api-key"); len(values) > 0 {
+		token = values[0]
+	}
+	if token == "" {
+		s.logger.Warn("missing authorization metadata", "method", info.FullMethod, "remote_addr", remoteAddr)
+		return nil, status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+
+	proxyKey, ok := s.keyring.Lookup(token)
+	if !ok || proxyKey.Disabled || proxyKey.Expired(time.Now()) {
+		s.logger.Warn("invalid authorization key",
+			"method", info.FullMethod,
+			"remote_addr", remoteAddr,
+			"application", proxyKey.Application,
+		)
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization key")
+	}
+	if proxyKey.Scope != models.ScopeAdmin {
+		s.logger.Warn("key scope not permitted",
+			"method", info.FullMethod,
+			"application", proxyKey.Application,
+			"scope", proxyKey.Scope,
+		)
+		return nil, status.Error(codes.PermissionDenied, "key is not permitted to access the control plane")
+	}
+	return handler(context.WithValue(ctx, operatorKey{}, proxyKey.Application), req)
+}
+
+// writable refuses configuration changes while read-only mode is on.
+func (s *GRPCServer) writable(operator, method string) error {
+	if !s.readOnly.Frozen() {
+		return nil
+	}
+	s.logger.Warn("configuration change refused in read-only mode", "method", method, "application", operator)
+	return status.Error(codes.FailedPrecondition, "configuration is frozen: Portus is in read-only mode")
+}
+
+func bundleFromProto(pb *controlplanepb.Bundle) Bundle {
+	bundle := Bundle{
+		SchemaVersion: int(pb.GetSchemaVersion()),
+		Version:       pb.GetVersion(),
+		Models:        make(map[string]json.RawMessage, len(pb.GetModels())),
+	}
+	for alias, raw := range pb.GetModels() {
+		bundle.Models[alias] = json.RawMessage(raw)
+	}
+	if pb.GetKeys() == nil {
+		return bundle
+	}
+	bundle.Keys = make([]Key, 0, len(pb.GetKeys().GetKeys()))
+	for _, k := range pb.GetKeys().GetKeys() {
+		key := Key{
+			Application:        k.GetApplication(),
+			Key:                k.GetKey(),
+			Scope:              models.KeyScope(k.GetScope()),
+			MaxStreams:         int(k.GetMaxStreams()),
+			MaxTokens:          int(k.GetMaxTokens()),
+			RequestQuota:       k.GetRequestQuota(),
+			ConversationTokens: int(k.GetConversationTokens()),
+			APIVersion:         k.GetApiVersion(),
+			Disabled:           k.GetDisabled(),
+			Tags:               k.GetTags(),
+		}
+		if k.GetExpiresAt() != nil {
+			key.ExpiresAt = k.GetExpiresAt().AsTime()
+		}
+		bundle.Keys = append(bundle.Keys, key)
+	}
+	return bundle
+}
+
+func statusProto(st Status) *controlplanepb.Status {
+	versions := make([]int32, len(st.SupportedSchemaVersions))
+	for i, v := range st.SupportedSchemaVersions {
+		versions[i] = int32(v)
+	}
+	return &controlplanepb.Status{
+		Version:                 st.Version,
+		AppliedAt:               timestamppb.New(st.AppliedAt),
+		Models:                  int32(st.Models),
+		Keys:                    int32(st.Keys),
+		History:                 st.History,
+		SupportedSchemaVersions: versions,
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !grpc
+
+package controlplane
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/freeze"
+	"github.com/amscotti/portus/internal/middleware"
+)
+
+// GRPCServer serves the control plane over gRPC. Builds without the grpc tag
+// cannot create one.
+type GRPCServer struct{}
+
+// NewGRPCServer reports that gRPC support is not compiled in.
+func NewGRPCServer(*Plane, *middleware.Keyring, *freeze.Switch, *events.Hub, *tls.Config, *slog.Logger) (*GRPCServer, error) {
+	return nil, errors.New("this binary was built without gRPC support; rebuild with -tags grpc")
+}
+
+// Serve does nothing without gRPC support.
+func (s *GRPCServer) Serve(net.Listener) error { return nil }
+
+// Stop does nothing without gRPC support.
+func (s *GRPCServer) Stop(context.Context) {}
@@ -0,0 +1,177 @@
+//go:build grpc
+
+package controlplane
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/amscotti/portus/internal/controlplane/controlplanepb"
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/freeze"
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/models"
+)
+
+func newTestGRPC(t *testing.T, readOnly *freeze.Switch) (controlplanepb.ControlPlaneClient, *models.ConfigStore, *events.Hub) {
+	t.Helper()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-local"}},
+	}
+	keyring := middleware.NewKeyring([]models.ProxyKey{
+		{Key: "pk-local", Application: "web"},
+		{Key: "ak-local", Application: "fleet", Scope: models.ScopeAdmin},
+		{Key: "ak-disabled", Application: "old-fleet", Scope: models.ScopeAdmin, Disabled: true},
+	})
+	hub := events.NewHub()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	server, err := NewGRPCServer(New(store, keyring), keyring, readOnly, hub, nil, logger)
+	if err != nil {
+		t.Fatalf("NewGRPCServer() error: %v", err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	go server.Serve(lis)
+	t.Cleanup(func() { server.Stop(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return controlplanepb.NewControlPlaneClient(conn), store, hub
+}
+
+func withKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+}
+
+func TestGRPCServer_ApplyAndRollback(t *testing.T) {
+	client, store, hub := newTestGRPC(t, freeze.New(false))
+	received, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	bundle := &controlplanepb.Bundle{
+		SchemaVersion: 1,
+		Version:       "v7",
+		Models:        map[string]string{"mini": `{"provider": "openai", "api_key": "sk-pushed"}`},
+	}
+	st, err := client.ApplyBundle(withKey("ak-local"), &controlplanepb.ApplyBundleRequest{Bundle: bundle, DryRun: true})
+	if err != nil {
+		t.Fatalf("ApplyBundle(dry run) error: %v", err)
+	}
+	if st.GetVersion() != LocalVersion {
+		t.Errorf("dry run must not change the version, got %q", st.GetVersion())
+	}
+
+	st, err = client.ApplyBundle(withKey("ak-local"), &controlplanepb.ApplyBundleRequest{Bundle: bundle})
+	if err != nil {
+		t.Fatalf("ApplyBundle() error: %v", err)
+	}
+	if st.GetVersion() != "v7" || len(st.GetHistory()) != 1 || st.GetHistory()[0] != LocalVersion {
+		t.Errorf("unexpected status: %v", st)
+	}
+	if model, ok := store.Model("mini"); !ok || model.APIKey != "sk-pushed" {
+		t.Errorf("expected pushed alias, got %+v", model)
+	}
+	if ev := <-received; ev.Type != events.ConfigApplied || ev.Operator != "fleet" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	st, err = client.Rollback(withKey("ak-local"), &controlplanepb.RollbackRequest{})
+	if err != nil || st.GetVersion() != LocalVersion {
+		t.Fatalf("Rollback() = %v, %v", st, err)
+	}
+	if _, ok := store.Model("gpt4"); !ok {
+		t.Error("expected rollback to restore local models")
+	}
+	if _, err := client.Rollback(withKey("ak-local"), &controlplanepb.RollbackRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition with no history, got %v", err)
+	}
+}
+
+func TestGRPCServer_Errors(t *testing.T) {
+	client, _, _ := newTestGRPC(t, freeze.New(false))
+	valid := &controlplanepb.Bundle{
+		SchemaVersion: 1,
+		Version:       "v2",
+		Models:        map[string]string{"gpt4": `{"provider": "openai", "api_key": "sk-x"}`},
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *controlplanepb.ApplyBundleRequest
+		want codes.Code
+	}{
+		{name: "missing key", ctx: context.Background(), req: &controlplanepb.ApplyBundleRequest{Bundle: valid}, want: codes.Unauthenticated},
+		{name: "unknown key", ctx: withKey("ak-unknown"), req: &controlplanepb.ApplyBundleRequest{Bundle: valid}, want: codes.Unauthenticated},
+		{name: "disabled key", ctx: withKey("ak-disabled"), req: &controlplanepb.ApplyBundleRequest{Bundle: valid}, want: codes.Unauthenticated},
+		{name: "inference key", ctx: withKey("pk-local"), req: &controlplanepb.ApplyBundleRequest{Bundle: valid}, want: codes.PermissionDenied},
+		{name: "missing bundle", ctx: withKey("ak-local"), req: &controlplanepb.ApplyBundleRequest{}, want: codes.InvalidArgument},
+		{
+			name: "unsupported schema",
+			ctx:  withKey("ak-local"),
+			req:  &controlplanepb.ApplyBundleRequest{Bundle: &controlplanepb.Bundle{SchemaVersion: 99, Version: "v2", Models: valid.Models}},
+			want: codes.FailedPrecondition,
+		},
+		{
+			name: "keys without admin",
+			ctx:  withKey("ak-local"),
+			req: &controlplanepb.ApplyBundleRequest{Bundle: &controlplanepb.Bundle{
+				SchemaVersion: 1,
+				Version:       "v2",
+				Models:        valid.Models,
+				Keys:          &controlplanepb.KeyList{Keys: []*controlplanepb.Key{{Application: "web", Key: "pk-1"}}},
+			}},
+			want: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.ApplyBundle(tt.ctx, tt.req)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("ApplyBundle() code = %v, want %v (error: %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestGRPCServer_ReadOnly(t *testing.T) {
+	client, store, _ := newTestGRPC(t, freeze.New(true))
+
+	st, err := client.GetStatus(withKey("ak-local"), &controlplanepb.GetStatusRequest{})
+	if err != nil {
+		t.Fatalf("GetStatus() error: %v", err)
+	}
+	if got := st.GetSupportedSchemaVersions(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("unexpected supported schema versions: %v", got)
+	}
+
+	_, err = client.ApplyBundle(withKey("ak-local"), &controlplanepb.ApplyBundleRequest{Bundle: &controlplanepb.Bundle{
+		SchemaVersion: 1,
+		Version:       "v2",
+		Models:        map[string]string{"mini": `{"provider": "openai", "api_key": "sk-x"}`},
+	}})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition while frozen, got %v", err)
+	}
+	if _, ok := store.Model("mini"); ok {
+		t.Error("frozen configuration must not change")
+	}
+}
@@ -0,0 +1,99 @@
+// Package conversation accumulates the tokens used by each client-identified
+// conversation, so a runaway agent loop that keeps growing one conversation
+// can be stopped or flagged.
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/amscotti/portus/internal/storage"
+)
+
+// Header carries the client's conversation ID.
+const Header = "X-Portus-Conversation-ID"
+
+// Actions taken when a conversation passes its key's token cap.
+const (
+	// ActionReject refuses further requests in the conversation.
+	ActionReject = "reject"
+	// ActionWarn logs the conversation and lets it continue.
+	ActionWarn = "warn"
+)
+
+// ParseAction validates a PORTUS_CONVERSATION_TOKENS_ACTION value; empty
+// means reject.
+func ParseAction(value string) (string, error) {
+	switch value {
+	case "":
+		return ActionReject, nil
+	case ActionReject, ActionWarn:
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown action %q (must be %q or %q)", value, ActionReject, ActionWarn)
+}
+
+// Tracker holds token totals per application and conversation ID in a
+// storage backend. It is safe for concurrent use; a nil Tracker tracks
+// nothing. Conversations idle for longer than the idle timeout are
+// forgotten.
+type Tracker struct {
+	backend storage.Backend
+	idle    time.Duration
+	logger  *slog.Logger
+}
+
+// New creates a tracker holding totals in memory and forgetting
+// conversations idle for longer than idle.
+func New(idle time.Duration, logger *slog.Logger) *Tracker {
+	return NewWithBackend(storage.NewMemory(), idle, logger)
+}
+
+// NewWithBackend creates a tracker holding totals in backend. With a shared
+// backend, a conversation spread over replicas is capped as a whole. If the
+// backend is unavailable, requests are allowed rather than failing.
+func NewWithBackend(backend storage.Backend, idle time.Duration, logger *slog.Logger) *Tracker {
+	return &Tracker{backend: backend, idle: idle, logger: logger}
+}
+
+// Tokens returns the tokens used so far by an application's conversation.
+func (t *Tracker) Tokens(application, id string) int {
+	if t == nil || id == "" {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	value, _, err := t.backend.Get(ctx, tokensKey(application, id))
+	if err != nil {
+		t.logger.Warn("conversation tokens unavailable, allowing request", "application", application, "conversation_id", id, "error", err)
+	}
+	return int(storage.Number(value))
+}
+
+// Add records tokens used by an application's conversation and returns the
+// conversation's new total.
+func (t *Tracker) Add(application, id string, tokens int) int {
+	if t == nil || id == "" {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	// Add only sets the expiry of a new total, so each use pushes it back
+	key := tokensKey(application, id)
+	total, err := t.backend.Add(ctx, key, float64(tokens), t.idle)
+	if err == nil {
+		err = t.backend.Expire(ctx, key, t.idle)
+	}
+	if err != nil {
+		t.logger.Warn("failed to record conversation tokens", "application", application, "conversation_id", id, "error", err)
+	}
+	return int(total)
+}
+
+func tokensKey(application, id string) string {
+	return storage.Key("conversation", application, id)
+}
@@ -0,0 +1,84 @@
+package conversation
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/storage"
+)
+
+func TestTracker(t *testing.T) {
+	t.Parallel()
+
+	tracker := New(time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if total := tracker.Add("agent", "c1", 1000); total != 1000 {
+		t.Errorf("Add() = %d, want 1000", total)
+	}
+	if total := tracker.Add("agent", "c1", 500); total != 1500 {
+		t.Errorf("Add() = %d, want 1500", total)
+	}
+	if tokens := tracker.Tokens("agent", "c1"); tokens != 1500 {
+		t.Errorf("Tokens() = %d, want 1500", tokens)
+	}
+
+	// Conversations are scoped to the application
+	if tokens := tracker.Tokens("other", "c1"); tokens != 0 {
+		t.Errorf("expected another application's conversation to be separate, got %d", tokens)
+	}
+
+	var nilTracker *Tracker
+	if total := nilTracker.Add("agent", "c1", 10); total != 0 {
+		t.Errorf("expected a nil tracker to track nothing, got %d", total)
+	}
+}
+
+func TestTracker_Idle(t *testing.T) {
+	t.Parallel()
+
+	tracker := New(100*time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Each use keeps the conversation alive for another idle timeout
+	tracker.Add("agent", "c1", 1000)
+	time.Sleep(60 * time.Millisecond)
+	tracker.Add("agent", "c1", 500)
+	time.Sleep(60 * time.Millisecond)
+	if tokens := tracker.Tokens("agent", "c1"); tokens != 1500 {
+		t.Errorf("expected an active conversation to be kept, got %d", tokens)
+	}
+
+	// Idle conversations start over
+	time.Sleep(150 * time.Millisecond)
+	if tokens := tracker.Tokens("agent", "c1"); tokens != 0 {
+		t.Errorf("expected an idle conversation to be forgotten, got %d", tokens)
+	}
+}
+
+func TestTracker_SharedBackend(t *testing.T) {
+	t.Parallel()
+
+	// Replicas sharing a backend add up one conversation
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	backend := storage.NewMemory()
+	a, b := NewWithBackend(backend, time.Hour, logger), NewWithBackend(backend, time.Hour, logger)
+	a.Add("agent", "c1", 1000)
+	if total := b.Add("agent", "c1", 500); total != 1500 {
+		t.Errorf("expected the total across replicas, got %d", total)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	t.Parallel()
+
+	if action, err := ParseAction(""); err != nil || action != ActionReject {
+		t.Errorf("expected empty to mean reject, got %q %v", action, err)
+	}
+	if _, err := ParseAction(ActionWarn); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := ParseAction("truncate"); err == nil {
+		t.Error("expected unknown action to be rejected")
+	}
+}
@@ -0,0 +1,29 @@
+// Package cost estimates request cost from token usage and configured pricing.
+package cost
+
+import (
+	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/usage"
+)
+
+// Lookup returns the pricing for a model alias. Pricing defined on the alias
+// itself wins; otherwise the global pricing table is consulted by alias and
+// then by resolved provider model name.
+func Lookup(store *models.ConfigStore, alias string, model models.ModelConfig, resolvedModel string) (models.PricingConfig, bool) {
+	if model.Pricing != nil {
+		return *model.Pricing, true
+	}
+	if p, ok := store.Pricing[alias]; ok {
+		return p, true
+	}
+	if p, ok := store.Pricing[resolvedModel]; ok {
+		return p, true
+	}
+	return models.PricingConfig{}, false
+}
+
+// Estimate returns the estimated cost of the given usage.
+func Estimate(pricing models.PricingConfig, u usage.Usage) float64 {
+	return float64(u.PromptTokens)/1000*pricing.InputPer1K +
+		float64(u.CompletionTokens)/1000*pricing.OutputPer1K
+}
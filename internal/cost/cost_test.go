@@ -0,0 +1,82 @@
+package cost
+
+import (
+	"math"
+	"testing"
+
+	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/usage"
+)
+
+func TestEstimate(t *testing.T) {
+	t.Parallel()
+
+	pricing := models.PricingConfig{InputPer1K: 0.003, OutputPer1K: 0.015}
+	got := Estimate(pricing, usage.Usage{PromptTokens: 2000, CompletionTokens: 500})
+
+	want := 0.006 + 0.0075
+	if math.Abs(got-want) > 1e-12 {
+		t.Errorf("expected %f, got %f", want, got)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	store := &models.ConfigStore{
+		Pricing: map[string]models.PricingConfig{
+			"claude":     {InputPer1K: 1},
+			"gpt-4o":     {InputPer1K: 2},
+			"unused-key": {InputPer1K: 3},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		alias    string
+		model    models.ModelConfig
+		resolved string
+		want     float64
+		wantOK   bool
+	}{
+		{
+			name:   "alias pricing wins",
+			alias:  "claude",
+			model:  models.ModelConfig{Pricing: &models.PricingConfig{InputPer1K: 9}},
+			want:   9,
+			wantOK: true,
+		},
+		{
+			name:   "global by alias",
+			alias:  "claude",
+			want:   1,
+			wantOK: true,
+		},
+		{
+			name:     "global by resolved model",
+			alias:    "fast",
+			resolved: "gpt-4o",
+			want:     2,
+			wantOK:   true,
+		},
+		{
+			name:     "no pricing",
+			alias:    "other",
+			resolved: "other-model",
+			wantOK:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := Lookup(store, tt.alias, tt.model, tt.resolved)
+			if ok != tt.wantOK {
+				t.Fatalf("expected ok=%v, got %v", tt.wantOK, ok)
+			}
+			if got.InputPer1K != tt.want {
+				t.Errorf("expected input price %v, got %v", tt.want, got.InputPer1K)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+// Package credguard marks a model alias degraded when its upstream keeps
+// rejecting Portus's credentials with 401 or 403, so requests fail fast with
+// a clear error until the credentials are fixed instead of each waiting on a
+// provider that will refuse it.
+package credguard
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amscotti/portus/internal/events"
+)
+
+// Status describes a degraded alias.
+type Status struct {
+	Alias string `json:"alias"`
+	// LastStatus is the upstream status code that degraded the alias.
+	LastStatus int       `json:"last_status"`
+	Failures   int       `json:"consecutive_failures"`
+	Since      time.Time `json:"since"`
+}
+
+// Guard counts consecutive credential rejections per alias. It is safe for
+// concurrent use; a nil Guard allows everything.
+type Guard struct {
+	threshold     int
+	probeInterval time.Duration
+	hide          bool
+	hub           *events.Hub
+	logger        *slog.Logger
+	now           func() time.Time
+
+	mu      sync.Mutex
+	aliases map[string]*aliasState
+}
+
+type aliasState struct {
+	failures   int
+	lastStatus int
+	degraded   bool
+	since      time.Time
+	nextProbe  time.Time
+	// config fingerprints the alias's config when it was degraded; a
+	// different one means the credentials may have been fixed
+	config [sha256.Size]byte
+}
+
+// New creates a guard that degrades an alias after threshold consecutive
+// 401 or 403 responses and lets one probe request through every
+// probeInterval (zero never probes). When hide is set, degraded aliases are
+// left out of model lists. Degraded and recovered aliases are published to
+// hub. A threshold of zero or less returns nil, disabling the guard.
+func New(threshold int, probeInterval time.Duration, hide bool, hub *events.Hub, logger *slog.Logger) *Guard {
+	if threshold <= 0 {
+		return nil
+	}
+	return &Guard{
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		hide:          hide,
+		hub:           hub,
+		logger:        logger,
+		now:           time.Now,
+		aliases:       make(map[string]*aliasState),
+	}
+}
+
+// IsCredentialFailure reports whether an upstream status code means the
+// provider rejected the credentials.
+func IsCredentialFailure(code int) bool {
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+// Allow reports whether a request for alias may proceed. config is the
+// alias's current model config: when it differs from the config the alias
+// was degraded with, the alias recovers at once. Otherwise a degraded alias
+// lets one probe through per probe interval.
+func (g *Guard) Allow(alias string, config any) bool {
+	if g == nil {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s, ok := g.aliases[alias]
+	if !ok || !s.degraded {
+		return true
+	}
+	if fingerprint(config) != s.config {
+		g.recover(alias, s, "config_changed")
+		return true
+	}
+	if g.probeInterval > 0 && !g.now().Before(s.nextProbe) {
+		s.nextProbe = g.now().Add(g.probeInterval)
+		g.logger.Info("probing alias degraded by credential failures", "model_alias", alias)
+		return true
+	}
+	return false
+}
+
+// Record records the upstream status code of a request for alias. 401 and
+// 403 count towards the threshold; any other response below 500 shows the
+// credentials work and resets the count, recovering a degraded alias. Server
+// errors say nothing about the credentials and are ignored.
+func (g *Guard) Record(alias string, config any, code int) {
+	if g == nil || code >= http.StatusInternalServerError {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s, ok := g.aliases[alias]
+	if !IsCredentialFailure(code) {
+		if ok {
+			if s.degraded {
+				g.recover(alias, s, "upstream_accepted")
+			}
+			delete(g.aliases, alias)
+		}
+		return
+	}
+	if !ok {
+		s = &aliasState{}
+		g.aliases[alias] = s
+	}
+	s.failures++
+	s.lastStatus = code
+	if s.degraded || s.failures < g.threshold {
+		return
+	}
+	s.degraded = true
+	s.since = g.now()
+	s.nextProbe = s.since.Add(g.probeInterval)
+	s.config = fingerprint(config)
+	g.logger.Error("alias degraded: upstream is rejecting its credentials",
+		"model_alias", alias,
+		"status", code,
+		"consecutive_failures", s.failures,
+	)
+	g.hub.Publish(events.AliasDegraded, "", map[string]any{
+		"alias":                alias,
+		"status":               code,
+		"consecutive_failures": s.failures,
+	})
+}
+
+// Hidden reports whether alias should be left out of model lists.
+func (g *Guard) Hidden(alias string) bool {
+	return g != nil && g.hide && g.Degraded(alias)
+}
+
+// Degraded reports whether alias is degraded.
+func (g *Guard) Degraded(alias string) bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, ok := g.aliases[alias]
+	return ok && s.degraded
+}
+
+// Snapshot returns the degraded aliases, sorted by alias.
+func (g *Guard) Snapshot() []Status {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var degraded []Status
+	for alias, s := range g.aliases {
+		if s.degraded {
+			degraded = append(degraded, Status{Alias: alias, LastStatus: s.lastStatus, Failures: s.failures, Since: s.since})
+		}
+	}
+	sort.Slice(degraded, func(i, j int) bool { return degraded[i].Alias < degraded[j].Alias })
+	return degraded
+}
+
+// recover clears a degraded alias. The caller must hold g.mu.
+func (g *Guard) recover(alias string, s *aliasState, reason string) {
+	g.logger.Info("alias recovered from credential failures", "model_alias", alias, "reason", reason)
+	g.hub.Publish(events.AliasRecovered, "", map[string]any{
+		"alias":  alias,
+		"reason": reason,
+	})
+	*s = aliasState{}
+}
+
+func fingerprint(config any) [sha256.Size]byte {
+	data, _ := json.Marshal(config)
+	return sha256.Sum256(data)
+}
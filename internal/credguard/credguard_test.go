@@ -0,0 +1,141 @@
+package credguard
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/events"
+)
+
+type config struct {
+	APIKey string `json:"api_key"`
+}
+
+func newGuard(t *testing.T, hide bool) (*Guard, *time.Time, <-chan events.Event) {
+	t.Helper()
+	hub := events.NewHub()
+	ch, unsubscribe := hub.Subscribe()
+	t.Cleanup(unsubscribe)
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	g := New(3, time.Minute, hide, hub, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	g.now = func() time.Time { return now }
+	return g, &now, ch
+}
+
+func expectEvent(t *testing.T, ch <-chan events.Event, eventType string) events.Event {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		if ev.Type != eventType {
+			t.Fatalf("expected %s event, got %s", eventType, ev.Type)
+		}
+		return ev
+	default:
+		t.Fatalf("expected %s event, got none", eventType)
+	}
+	return events.Event{}
+}
+
+func TestGuard_Lifecycle(t *testing.T) {
+	t.Parallel()
+
+	g, now, ch := newGuard(t, false)
+	cfg := config{APIKey: "sk-old"}
+
+	// Server errors neither count nor reset, other responses reset
+	g.Record("gpt", cfg, 401)
+	g.Record("gpt", cfg, 502)
+	g.Record("gpt", cfg, 403)
+	g.Record("gpt", cfg, 200)
+	g.Record("gpt", cfg, 401)
+	g.Record("gpt", cfg, 401)
+	if g.Degraded("gpt") {
+		t.Fatal("expected alias to stay healthy below the threshold")
+	}
+
+	g.Record("gpt", cfg, 403)
+	if !g.Degraded("gpt") {
+		t.Fatal("expected alias to be degraded at the threshold")
+	}
+	ev := expectEvent(t, ch, events.AliasDegraded)
+	if ev.Data["alias"] != "gpt" || ev.Data["status"] != 403 {
+		t.Errorf("unexpected event data %v", ev.Data)
+	}
+	if g.Allow("gpt", cfg) {
+		t.Error("expected a degraded alias to be rejected")
+	}
+	if !g.Allow("claude", cfg) {
+		t.Error("expected other aliases to be allowed")
+	}
+
+	// One probe per interval
+	*now = now.Add(time.Minute)
+	if !g.Allow("gpt", cfg) {
+		t.Error("expected a probe once the interval has passed")
+	}
+	if g.Allow("gpt", cfg) {
+		t.Error("expected only one probe per interval")
+	}
+	g.Record("gpt", cfg, 401)
+	if !g.Degraded("gpt") {
+		t.Error("expected a rejected probe to keep the alias degraded")
+	}
+	select {
+	case ev := <-ch:
+		t.Errorf("expected no event for a failed probe, got %s", ev.Type)
+	default:
+	}
+
+	*now = now.Add(time.Minute)
+	g.Allow("gpt", cfg)
+	g.Record("gpt", cfg, 200)
+	if g.Degraded("gpt") {
+		t.Error("expected an accepted probe to recover the alias")
+	}
+	if ev := expectEvent(t, ch, events.AliasRecovered); ev.Data["reason"] != "upstream_accepted" {
+		t.Errorf("unexpected event data %v", ev.Data)
+	}
+}
+
+func TestGuard_ConfigChangeRecovers(t *testing.T) {
+	t.Parallel()
+
+	g, _, ch := newGuard(t, true)
+	old := config{APIKey: "sk-old"}
+	for range 3 {
+		g.Record("gpt", old, 401)
+	}
+	expectEvent(t, ch, events.AliasDegraded)
+	if !g.Hidden("gpt") {
+		t.Error("expected a degraded alias to be hidden")
+	}
+	if got := g.Snapshot(); len(got) != 1 || got[0].Alias != "gpt" || got[0].Failures != 3 || got[0].LastStatus != 401 {
+		t.Errorf("unexpected snapshot %+v", got)
+	}
+
+	if !g.Allow("gpt", config{APIKey: "sk-new"}) {
+		t.Fatal("expected a changed config to be allowed")
+	}
+	if g.Degraded("gpt") || g.Hidden("gpt") {
+		t.Error("expected a changed config to recover the alias")
+	}
+	if ev := expectEvent(t, ch, events.AliasRecovered); ev.Data["reason"] != "config_changed" {
+		t.Errorf("unexpected event data %v", ev.Data)
+	}
+}
+
+func TestGuard_Disabled(t *testing.T) {
+	t.Parallel()
+
+	g := New(0, time.Minute, true, nil, nil)
+	if g != nil {
+		t.Fatal("expected a zero threshold to disable the guard")
+	}
+	g.Record("gpt", nil, 401)
+	if !g.Allow("gpt", nil) || g.Hidden("gpt") || g.Snapshot() != nil {
+		t.Error("expected a nil guard to allow everything")
+	}
+}
@@ -0,0 +1,121 @@
+// Package dirwatch detects changes to the files below a directory by
+// polling, including Kubernetes ConfigMap and Secret volumes, which are
+// updated by swapping a symlink rather than writing the files in place.
+package dirwatch
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxDepth bounds how far symlinked directories are followed, in case they
+// form a loop.
+const maxDepth = 8
+
+// Fingerprint hashes the names and contents of every file below dir,
+// following symlinks. Names starting with "..", such as ..data and the
+// timestamped directories behind a mounted ConfigMap or Secret, are
+// skipped: their files are reached through the visible symlinks. A missing
+// dir has an empty fingerprint.
+func Fingerprint(dir string) ([]byte, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	h := sha256.New()
+	if err := hashDir(h, dir, "", 0); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func hashDir(h hash.Hash, dir, rel string, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("%s: directories nested too deeply", filepath.Join(dir, rel))
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, rel))
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "..") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(rel, name)
+		info, err := os.Stat(filepath.Join(dir, path))
+		if err != nil {
+			// A dangling symlink, or a file removed while listing
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if info.IsDir() {
+			if err := hashDir(h, dir, path, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, path))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", path, info.Size())
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watcher reports when the files below a directory differ from the last
+// time it looked. It is safe for concurrent use.
+type Watcher struct {
+	dir string
+
+	mu   sync.Mutex
+	last []byte
+}
+
+// New returns a watcher for dir, taking its current contents as unchanged.
+func New(dir string) (*Watcher, error) {
+	fp, err := Fingerprint(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{dir: dir, last: fp}, nil
+}
+
+// Changed reports whether the files changed since the previous call, or
+// since New. A reload that reads the files after Changed returns true may
+// see a later swap as well; Changed then reports it again next time, so
+// nothing is missed.
+func (w *Watcher) Changed() (bool, error) {
+	fp, err := Fingerprint(w.dir)
+	if err != nil {
+		return false, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if string(fp) == string(w.last) {
+		return false, nil
+	}
+	w.last = fp
+	return true, nil
+}
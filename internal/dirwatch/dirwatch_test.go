@@ -0,0 +1,122 @@
+package dirwatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// projectVolume lays out files the way the kubelet does for a ConfigMap or
+// Secret volume: the data lives in a timestamped directory, ..data points
+// at it and each visible file is a symlink through ..data. Later calls swap
+// ..data atomically and remove the previous version.
+func projectVolume(t *testing.T, dir, version string, files map[string]string) {
+	t.Helper()
+	versioned := filepath.Join(dir, "..2026_10_16_"+version)
+	if err := os.MkdirAll(versioned, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(versioned, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous, _ := os.Readlink(filepath.Join(dir, "..data"))
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(versioned), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink != 0 && entry.Name()[0] != '.' {
+			if _, ok := files[entry.Name()]; !ok {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	for name := range files {
+		os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name))
+	}
+	if previous != "" {
+		os.RemoveAll(filepath.Join(dir, previous))
+	}
+}
+
+func TestWatcher_ProjectedVolume(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	models := filepath.Join(dir, "models")
+	if err := os.Mkdir(models, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	projectVolume(t, models, "1", map[string]string{"gpt.json": `{"provider": "openai"}`})
+
+	w, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name    string
+		change  func()
+		changed bool
+	}{
+		{name: "untouched", change: func() {}},
+		{name: "file edited by a swap", change: func() {
+			projectVolume(t, models, "2", map[string]string{"gpt.json": `{"provider": "azure-openai"}`})
+		}, changed: true},
+		{name: "checked again", change: func() {}},
+		{name: "file added by a swap", change: func() {
+			projectVolume(t, models, "3", map[string]string{"gpt.json": `{"provider": "azure-openai"}`, "claude.json": `{}`})
+		}, changed: true},
+		{name: "same content swapped in", change: func() {
+			projectVolume(t, models, "4", map[string]string{"gpt.json": `{"provider": "azure-openai"}`, "claude.json": `{}`})
+		}},
+		{name: "file removed by a swap", change: func() {
+			projectVolume(t, models, "5", map[string]string{"claude.json": `{}`})
+		}, changed: true},
+		{name: "plain file written", change: func() {
+			os.WriteFile(filepath.Join(dir, "pricing.json"), []byte(`{}`), 0o644)
+		}, changed: true},
+	}
+
+	// Steps share the directory, so they run in order
+	for _, step := range steps {
+		step.change()
+		changed, err := w.Changed()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if changed != step.changed {
+			t.Errorf("%s: expected changed %v, got %v", step.name, step.changed, changed)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+
+	if fp, err := Fingerprint(filepath.Join(t.TempDir(), "missing")); fp != nil || err != nil {
+		t.Errorf("expected an empty fingerprint for a missing directory, got %x, %v", fp, err)
+	}
+
+	// Renaming a file with the same content is a change
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.json"), []byte(`{}`), 0o644)
+	before, _ := Fingerprint(dir)
+	os.Rename(filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json"))
+	if after, _ := Fingerprint(dir); string(after) == string(before) {
+		t.Error("expected a renamed file to change the fingerprint")
+	}
+
+	// A dangling symlink is ignored rather than failing the check
+	os.Symlink(filepath.Join(dir, "nowhere"), filepath.Join(dir, "dangling.json"))
+	if _, err := Fingerprint(dir); err != nil {
+		t.Errorf("expected a dangling symlink to be skipped, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+// Package doctor diagnoses the setup problems behind most failed Portus
+// deployments for "portus doctor", pairing each problem with a fix.
+package doctor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Statuses, in increasing severity.
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// Finding is the outcome of one check.
+type Finding struct {
+	Check   string
+	Status  string
+	Message string
+	// Fix suggests how to resolve a warning or failure.
+	Fix string
+}
+
+// maxClockSkew is the difference from the gateway's clock past which key
+// expiry, quota windows and signed provider requests become unreliable.
+const maxClockSkew = 30 * time.Second
+
+// certificateExpiryWarning is how far ahead of expiry a certificate is
+// reported.
+const certificateExpiryWarning = 14 * 24 * time.Hour
+
+// Environment reports variables that are not Portus settings, suggesting the
+// closest setting name for each.
+func Environment(unknown, settings []string) []Finding {
+	if len(unknown) == 0 {
+		return []Finding{{Check: "environment", Status: StatusOK, Message: "every PORTUS_ variable is a known setting"}}
+	}
+	findings := make([]Finding, 0, len(unknown))
+	for _, name := range unknown {
+		fix := "remove it, or check the setting name in example.env"
+		if suggestion := closest(name, settings); suggestion != "" {
+			fix = "did you mean " + suggestion + "?"
+		}
+		findings = append(findings, Finding{
+			Check:   "environment",
+			Status:  StatusWarn,
+			Message: name + " is not a Portus setting and is ignored",
+			Fix:     fix,
+		})
+	}
+	return findings
+}
+
+// ConfigDir checks that the config directory and the model files in its
+// models/ directory exist and can be read.
+func ConfigDir(path string) []Finding {
+	fail := func(message, fix string) []Finding {
+		return []Finding{{Check: "config directory", Status: StatusFail, Message: message, Fix: fix}}
+	}
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fail(path+" does not exist", "set PORTUS_CONFIG_PATH to the directory containing models/")
+	case err != nil:
+		return fail(err.Error(), "make "+path+" readable by the user running Portus")
+	case !info.IsDir():
+		return fail(path+" is not a directory", "set PORTUS_CONFIG_PATH to the directory containing models/")
+	}
+
+	modelsDir := filepath.Join(path, "models")
+	entries, err := os.ReadDir(modelsDir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fail(modelsDir+" does not exist", "create it with one <alias>.json file per model alias")
+	case err != nil:
+		return fail(err.Error(), "make "+modelsDir+" readable by the user running Portus")
+	}
+
+	var findings []Finding
+	files := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !(strings.HasSuffix(entry.Name(), ".json") || strings.HasSuffix(entry.Name(), ".json.tmpl")) {
+			continue
+		}
+		files++
+		file := filepath.Join(modelsDir, entry.Name())
+		f, err := os.Open(file)
+		if err != nil {
+			findings = append(findings, Finding{
+				Check:   "config directory",
+				Status:  StatusFail,
+				Message: file + " cannot be read: " + err.Error(),
+				Fix:     "make it readable by the user running Portus",
+			})
+			continue
+		}
+		f.Close()
+	}
+	if files == 0 {
+		return fail(modelsDir+" contains no .json model files", "add one <alias>.json file per model alias")
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{Check: "config directory", Status: StatusOK, Message: fmt.Sprintf("%s is readable, %d model files", path, files)})
+	}
+	return findings
+}
+
+// Gateway checks that the gateway answers over client, that its TLS
+// certificate is trusted, and that its clock agrees with this host's.
+func Gateway(ctx context.Context, client *http.Client, gatewayURL string) []Finding {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gatewayURL, nil)
+	if err != nil {
+		return []Finding{{Check: "gateway", Status: StatusFail, Message: "invalid gateway URL: " + err.Error(), Fix: "set PORTKEY_GATEWAY_URL to e.g. http://localhost:8787"}}
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return []Finding{gatewayError(gatewayURL, err)}
+	}
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	findings := []Finding{{Check: "gateway", Status: StatusOK, Message: fmt.Sprintf("%s answered in %dms", gatewayURL, latency.Milliseconds())}}
+	if resp.TLS != nil {
+		findings = append(findings, Finding{Check: "gateway TLS", Status: StatusOK, Message: "gateway certificate is trusted"})
+	}
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		// The Date header has one-second resolution and was set mid-request
+		skew := time.Until(date.Add(latency / 2)).Round(time.Second)
+		if skew.Abs() > maxClockSkew {
+			findings = append(findings, Finding{
+				Check:   "clock skew",
+				Status:  StatusWarn,
+				Message: fmt.Sprintf("this host's clock is %s from the gateway's", skew.Abs()),
+				Fix:     "sync the clock with NTP; skew breaks key expiry, quota windows and AWS request signing",
+			})
+		} else {
+			findings = append(findings, Finding{Check: "clock skew", Status: StatusOK, Message: "clock agrees with the gateway's"})
+		}
+	}
+	return findings
+}
+
+// gatewayError explains a failed gateway request.
+func gatewayError(gatewayURL string, err error) Finding {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return Finding{Check: "gateway TLS", Status: StatusFail, Message: "gateway certificate is signed by an unknown authority",
+			Fix: "set PORTUS_GATEWAY_TLS_CA to the CA bundle that issued it"}
+	case errors.As(err, &hostname):
+		return Finding{Check: "gateway TLS", Status: StatusFail, Message: "gateway certificate does not match the host: " + hostname.Error(),
+			Fix: "use the host name the certificate was issued for in PORTKEY_GATEWAY_URL"}
+	case errors.As(err, &invalid):
+		return Finding{Check: "gateway TLS", Status: StatusFail, Message: "gateway certificate is invalid: " + invalid.Error(),
+			Fix: "renew the gateway certificate, or check this host's clock"}
+	case strings.Contains(err.Error(), "certificate required"), strings.Contains(err.Error(), "bad certificate"):
+		return Finding{Check: "gateway TLS", Status: StatusFail, Message: "gateway rejected the client certificate",
+			Fix: "set PORTUS_GATEWAY_TLS_CERT and PORTUS_GATEWAY_TLS_KEY to a certificate the gateway trusts"}
+	case errors.As(err, &dnsErr):
+		return Finding{Check: "gateway", Status: StatusFail, Message: "cannot resolve " + dnsErr.Name,
+			Fix: "check the host in PORTKEY_GATEWAY_URL and this host's DNS"}
+	}
+	return Finding{Check: "gateway", Status: StatusFail, Message: gatewayURL + " is unreachable: " + err.Error(),
+		Fix: "start the Portkey Gateway, or set PORTKEY_GATEWAY_URL to where it listens"}
+}
+
+// Certificate checks the listener's certificate and key load and are not
+// expired or about to expire.
+func Certificate(certFile, keyFile string, now time.Time) Finding {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return Finding{Check: "listener TLS", Status: StatusFail, Message: "cannot load certificate: " + err.Error(),
+			Fix: "check PORTUS_TLS_CERT and PORTUS_TLS_KEY name a matching PEM certificate and key"}
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return Finding{Check: "listener TLS", Status: StatusFail, Message: "cannot parse certificate: " + err.Error(),
+			Fix: "check PORTUS_TLS_CERT is a PEM certificate"}
+	}
+	switch left := leaf.NotAfter.Sub(now); {
+	case left <= 0:
+		return Finding{Check: "listener TLS", Status: StatusFail, Message: "certificate expired on " + leaf.NotAfter.Format(time.DateOnly),
+			Fix: "renew the certificate; Portus reloads it without a restart"}
+	case left < certificateExpiryWarning:
+		return Finding{Check: "listener TLS", Status: StatusWarn, Message: "certificate expires on " + leaf.NotAfter.Format(time.DateOnly),
+			Fix: "renew the certificate; Portus reloads it without a restart"}
+	}
+	return Finding{Check: "listener TLS", Status: StatusOK, Message: "certificate valid until " + leaf.NotAfter.Format(time.DateOnly)}
+}
+
+// Port checks that addr is free to listen on.
+func Port(check, addr string) Finding {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return Finding{Check: check, Status: StatusWarn, Message: "cannot listen on " + addr + ": " + err.Error(),
+			Fix: "stop the process using it (ignore this if it is Portus itself), or choose another port"}
+	}
+	listener.Close()
+	return Finding{Check: check, Status: StatusOK, Message: addr + " is free"}
+}
+
+// closest returns the candidate within a few edits of name, if any.
+func closest(name string, candidates []string) string {
+	best, bestDistance := "", 4
+	for _, c := range candidates {
+		if d := distance(name, c); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// distance is the Levenshtein edit distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,253 @@
+package doctor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnvironment(t *testing.T) {
+	t.Parallel()
+
+	settings := []string{"PORTUS_PORT", "PORTUS_MAX_STREAMS", "PORTUS_CONFIG_PATH"}
+	tests := []struct {
+		name       string
+		unknown    []string
+		wantStatus string
+		wantFix    string
+	}{
+		{name: "nothing unknown", wantStatus: StatusOK},
+		{name: "typo is matched", unknown: []string{"PORTUS_MAX_STREMS"}, wantStatus: StatusWarn, wantFix: "did you mean PORTUS_MAX_STREAMS?"},
+		{name: "unrelated name", unknown: []string{"PORTUS_SOMETHING_ELSE"}, wantStatus: StatusWarn, wantFix: "remove it, or check the setting name in example.env"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			findings := Environment(tt.unknown, settings)
+			if len(findings) != max(1, len(tt.unknown)) {
+				t.Fatalf("expected a finding per unknown variable, got %+v", findings)
+			}
+			if findings[0].Status != tt.wantStatus || findings[0].Fix != tt.wantFix {
+				t.Errorf("got %q with fix %q, want %q with fix %q", findings[0].Status, findings[0].Fix, tt.wantStatus, tt.wantFix)
+			}
+		})
+	}
+}
+
+func TestConfigDir(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		setup      func(dir string) string
+		wantStatus string
+	}{
+		{
+			name: "readable model files",
+			setup: func(dir string) string {
+				os.Mkdir(filepath.Join(dir, "models"), 0o755)
+				os.WriteFile(filepath.Join(dir, "models", "gpt4.json"), []byte("{}"), 0o644)
+				return dir
+			},
+			wantStatus: StatusOK,
+		},
+		{
+			name:       "missing directory",
+			setup:      func(dir string) string { return filepath.Join(dir, "missing") },
+			wantStatus: StatusFail,
+		},
+		{
+			name: "file instead of directory",
+			setup: func(dir string) string {
+				path := filepath.Join(dir, "config")
+				os.WriteFile(path, nil, 0o644)
+				return path
+			},
+			wantStatus: StatusFail,
+		},
+		{
+			name:       "missing models directory",
+			setup:      func(dir string) string { return dir },
+			wantStatus: StatusFail,
+		},
+		{
+			name: "no model files",
+			setup: func(dir string) string {
+				os.Mkdir(filepath.Join(dir, "models"), 0o755)
+				os.WriteFile(filepath.Join(dir, "models", "README.md"), nil, 0o644)
+				return dir
+			},
+			wantStatus: StatusFail,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			findings := ConfigDir(tt.setup(t.TempDir()))
+			if len(findings) != 1 || findings[0].Status != tt.wantStatus {
+				t.Errorf("expected one %q finding, got %+v", tt.wantStatus, findings)
+			}
+		})
+	}
+}
+
+func TestGateway(t *testing.T) {
+	t.Parallel()
+
+	serve := func(date time.Time) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Date", date.UTC().Format(http.TimeFormat))
+		}
+	}
+	tests := []struct {
+		name   string
+		server func() *httptest.Server
+		client func(server *httptest.Server) *http.Client
+		want   map[string]string
+	}{
+		{
+			name:   "reachable with matching clock",
+			server: func() *httptest.Server { return httptest.NewServer(serve(time.Now())) },
+			want:   map[string]string{"gateway": StatusOK, "clock skew": StatusOK},
+		},
+		{
+			name:   "clock skew",
+			server: func() *httptest.Server { return httptest.NewServer(serve(time.Now().Add(-5 * time.Minute))) },
+			want:   map[string]string{"gateway": StatusOK, "clock skew": StatusWarn},
+		},
+		{
+			name:   "trusted TLS",
+			server: func() *httptest.Server { return httptest.NewTLSServer(serve(time.Now())) },
+			client: func(server *httptest.Server) *http.Client { return server.Client() },
+			want:   map[string]string{"gateway": StatusOK, "gateway TLS": StatusOK, "clock skew": StatusOK},
+		},
+		{
+			name:   "untrusted TLS",
+			server: func() *httptest.Server { return httptest.NewTLSServer(serve(time.Now())) },
+			want:   map[string]string{"gateway TLS": StatusFail},
+		},
+		{
+			name: "unreachable",
+			server: func() *httptest.Server {
+				server := httptest.NewServer(serve(time.Now()))
+				server.Close()
+				return server
+			},
+			want: map[string]string{"gateway": StatusFail},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := tt.server()
+			defer server.Close()
+			client := &http.Client{}
+			if tt.client != nil {
+				client = tt.client(server)
+			}
+
+			got := make(map[string]string)
+			for _, f := range Gateway(context.Background(), client, server.URL) {
+				got[f.Check] = f.Status
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got findings %v, want %v", got, tt.want)
+			}
+			for check, status := range tt.want {
+				if got[check] != status {
+					t.Errorf("%s: got %q, want %q", check, got[check], status)
+				}
+			}
+		})
+	}
+}
+
+// writeCertificate writes a self-signed certificate valid until notAfter
+// and its key, returning their paths.
+func writeCertificate(t *testing.T, notAfter time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "portus"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestCertificate(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	tests := []struct {
+		name       string
+		notAfter   time.Time
+		wantStatus string
+	}{
+		{name: "valid", notAfter: now.Add(90 * 24 * time.Hour), wantStatus: StatusOK},
+		{name: "expiring soon", notAfter: now.Add(3 * 24 * time.Hour), wantStatus: StatusWarn},
+		{name: "expired", notAfter: now.Add(-time.Hour), wantStatus: StatusFail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			certFile, keyFile := writeCertificate(t, tt.notAfter)
+			if got := Certificate(certFile, keyFile, now); got.Status != tt.wantStatus {
+				t.Errorf("got %+v, want status %q", got, tt.wantStatus)
+			}
+		})
+	}
+
+	if got := Certificate(filepath.Join(t.TempDir(), "missing.crt"), "missing.key", now); got.Status != StatusFail {
+		t.Errorf("expected missing certificate to fail, got %+v", got)
+	}
+}
+
+func TestPort(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	if got := Port("listener", addr); got.Status != StatusWarn {
+		t.Errorf("expected busy port to warn, got %+v", got)
+	}
+	listener.Close()
+	if got := Port("listener", addr); got.Status != StatusOK {
+		t.Errorf("expected free port to pass, got %+v", got)
+	}
+}
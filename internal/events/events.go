@@ -0,0 +1,114 @@
+// Package events fans out operator-visible configuration changes, such as
+// model patches and pushed bundles, key audit events, budget alerts,
+// guardrail blocks, credential failures and request overrides to subscribers
+// of the admin event stream and to webhooks.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	ModelsPatched    = "models.patched"
+	ConfigApplied    = "config.applied"
+	ConfigRolledBack = "config.rolled_back"
+	KeyDisabled      = "key.disabled"
+	KeyEnabled       = "key.enabled"
+	// DisabledKeyUsed audits a request rejected for using a disabled key.
+	DisabledKeyUsed = "key.disabled_used"
+	// ConfigFrozen and ConfigUnfrozen report read-only mode being switched on
+	// and off through the admin API.
+	ConfigFrozen   = "config.frozen"
+	ConfigUnfrozen = "config.unfrozen"
+	// BudgetWarning and BudgetExceeded report an application crossing 80%
+	// and 100% of a spend budget window.
+	BudgetWarning  = "budget.warning"
+	BudgetExceeded = "budget.exceeded"
+	// GuardrailBlocked reports a request rejected by a guardrail.
+	GuardrailBlocked = "guardrail.blocked"
+	// AliasDegraded and AliasRecovered report an alias's upstream starting
+	// and ceasing to reject its credentials.
+	AliasDegraded  = "alias.degraded"
+	AliasRecovered = "alias.recovered"
+	// OverrideApplied audits a request whose retries or routing an operator
+	// overrode.
+	OverrideApplied = "override.applied"
+)
+
+// Asynchronous job events. They are sent to webhooks only, not to the event
+// stream, since every job produces one.
+const (
+	JobSucceeded = "job.succeeded"
+	JobFailed    = "job.failed"
+	JobCancelled = "job.cancelled"
+)
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before further events are dropped for it.
+const subscriberBuffer = 64
+
+// Event describes one change.
+type Event struct {
+	ID       uint64         `json:"id,omitempty"`
+	Type     string         `json:"type"`
+	Time     time.Time      `json:"time"`
+	Operator string         `json:"operator,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// Hub delivers published events to every current subscriber. It is safe for
+// concurrent use; a nil Hub discards events.
+type Hub struct {
+	mu     sync.Mutex
+	nextID uint64
+	subs   map[chan Event]struct{}
+}
+
+// NewHub creates a hub with no subscribers.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan Event]struct{})}
+}
+
+// Publish sends an event to every subscriber without blocking. Subscribers
+// whose buffer is full miss the event, which they can detect from a gap in
+// IDs.
+func (h *Hub) Publish(eventType, operator string, data map[string]any) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	ev := Event{ID: h.nextID, Type: eventType, Time: time.Now().UTC(), Operator: operator, Data: data}
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving events published from now on and a
+// function that unsubscribes and closes the channel.
+func (h *Hub) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	if h == nil {
+		return ch, func() {}
+	}
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, ch)
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package events
+
+import "testing"
+
+func TestHub_PublishSubscribe(t *testing.T) {
+	t.Parallel()
+
+	h := NewHub()
+	h.Publish(ConfigApplied, "ops", nil) // no subscribers yet
+
+	ch, unsubscribe := h.Subscribe()
+	h.Publish(ModelsPatched, "ops", map[string]any{"aliases": []string{"gpt4"}})
+
+	ev := <-ch
+	if ev.Type != ModelsPatched || ev.Operator != "ops" || ev.ID != 2 || ev.Time.IsZero() {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	unsubscribe()
+	unsubscribe() // safe to call twice
+	if _, ok := <-ch; ok {
+		t.Error("expected channel to be closed after unsubscribe")
+	}
+	h.Publish(ConfigRolledBack, "ops", nil) // must not panic on the closed channel
+}
+
+func TestHub_SlowSubscriberDoesNotBlock(t *testing.T) {
+	t.Parallel()
+
+	h := NewHub()
+	ch, unsubscribe := h.Subscribe()
+	defer unsubscribe()
+
+	for range subscriberBuffer + 10 {
+		h.Publish(ConfigApplied, "", nil)
+	}
+	if len(ch) != subscriberBuffer {
+		t.Errorf("expected %d buffered events, got %d", subscriberBuffer, len(ch))
+	}
+}
+
+func TestHub_Nil(t *testing.T) {
+	t.Parallel()
+
+	var h *Hub
+	h.Publish(ConfigApplied, "", nil)
+	_, unsubscribe := h.Subscribe()
+	unsubscribe()
+}
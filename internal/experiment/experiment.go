@@ -0,0 +1,27 @@
+// Package experiment assigns requests to the variants of an A/B experiment.
+// Assignment is a stable hash of the experiment name, application and sticky
+// key, so a caller keeps its variant across requests, restarts and replicas.
+package experiment
+
+import "crypto/sha256"
+
+// Variant labels.
+const (
+	VariantA = "a"
+	VariantB = "b"
+)
+
+// Assign returns VariantA or VariantB for a caller of the named experiment.
+// Callers without a sticky key are assigned by application alone; renaming
+// the experiment reshuffles every assignment.
+func Assign(name, application, stickyKey string) string {
+	h := sha256.New()
+	for _, part := range []string{name, application, stickyKey} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	if h.Sum(nil)[0]&1 == 0 {
+		return VariantA
+	}
+	return VariantB
+}
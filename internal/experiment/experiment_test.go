@@ -0,0 +1,40 @@
+package experiment
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAssign(t *testing.T) {
+	t.Parallel()
+
+	counts := map[string]int{}
+	for i := range 1000 {
+		key := "user-" + strconv.Itoa(i)
+		variant := Assign("prompt-v2", "BACKEND", key)
+		if again := Assign("prompt-v2", "BACKEND", key); again != variant {
+			t.Fatalf("assignment for %s is not stable: %s then %s", key, variant, again)
+		}
+		counts[variant]++
+	}
+	if len(counts) != 2 || counts[VariantA] < 400 || counts[VariantB] < 400 {
+		t.Errorf("expected a roughly even split, got %v", counts)
+	}
+}
+
+func TestAssign_FieldsAreSeparated(t *testing.T) {
+	t.Parallel()
+
+	// Moving a character between fields must not reuse the same hash input
+	differs := false
+	for i := range 20 {
+		key := strconv.Itoa(i)
+		if Assign("exp", "AB", key) != Assign("exp", "A", "B"+key) {
+			differs = true
+			break
+		}
+	}
+	if !differs {
+		t.Error("expected field boundaries to affect assignment")
+	}
+}
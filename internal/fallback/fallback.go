@@ -0,0 +1,117 @@
+// Package fallback builds static "sorry" completions served in place of a raw
+// gateway error when every routing option for an alias has failed.
+package fallback
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Header marks responses that were served from the static fallback.
+const Header = "X-Portus-Fallback"
+
+// Response renders message in the response format of targetPath. It reports
+// false for endpoints that have no completion shape to fall back to.
+func Response(targetPath, model, requestID, message string, stream bool) (contentType string, body []byte, ok bool) {
+	id := "portus-fallback-" + requestID
+	created := time.Now().Unix()
+
+	switch targetPath {
+	case "/v1/chat/completions":
+		if stream {
+			chunk := map[string]any{
+				"id":      id,
+				"object":  "chat.completion.chunk",
+				"created": created,
+				"model":   model,
+				"choices": []any{map[string]any{
+					"index":         0,
+					"delta":         map[string]any{"role": "assistant", "content": message},
+					"finish_reason": "stop",
+				}},
+			}
+			return eventStream(event{data: chunk}, event{data: "[DONE]"})
+		}
+		return jsonBody(map[string]any{
+			"id":      id,
+			"object":  "chat.completion",
+			"created": created,
+			"model":   model,
+			"choices": []any{map[string]any{
+				"index":         0,
+				"message":       map[string]any{"role": "assistant", "content": message},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]int{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0},
+		})
+
+	case "/v1/completions":
+		completion := map[string]any{
+			"id":      id,
+			"object":  "text_completion",
+			"created": created,
+			"model":   model,
+			"choices": []any{map[string]any{"index": 0, "text": message, "finish_reason": "stop"}},
+		}
+		if stream {
+			return eventStream(event{data: completion}, event{data: "[DONE]"})
+		}
+		completion["usage"] = map[string]int{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0}
+		return jsonBody(completion)
+
+	case "/v1/messages":
+		msg := map[string]any{
+			"id":            id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         model,
+			"content":       []any{map[string]any{"type": "text", "text": message}},
+			"stop_reason":   "end_turn",
+			"stop_sequence": nil,
+			"usage":         map[string]int{"input_tokens": 0, "output_tokens": 0},
+		}
+		if !stream {
+			return jsonBody(msg)
+		}
+		msg["content"] = []any{}
+		msg["stop_reason"] = nil
+		return eventStream(
+			event{name: "message_start", data: map[string]any{"type": "message_start", "message": msg}},
+			event{name: "content_block_start", data: map[string]any{"type": "content_block_start", "index": 0, "content_block": map[string]any{"type": "text", "text": ""}}},
+			event{name: "content_block_delta", data: map[string]any{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "text_delta", "text": message}}},
+			event{name: "content_block_stop", data: map[string]any{"type": "content_block_stop", "index": 0}},
+			event{name: "message_delta", data: map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": "end_turn", "stop_sequence": nil}, "usage": map[string]int{"output_tokens": 0}}},
+			event{name: "message_stop", data: map[string]any{"type": "message_stop"}},
+		)
+	}
+	return "", nil, false
+}
+
+type event struct {
+	name string
+	// data is JSON-encoded unless it is a string, which is written verbatim.
+	data any
+}
+
+func eventStream(events ...event) (string, []byte, bool) {
+	var buf bytes.Buffer
+	for _, e := range events {
+		if e.name != "" {
+			fmt.Fprintf(&buf, "event: %s\n", e.name)
+		}
+		data, ok := e.data.(string)
+		if !ok {
+			encoded, _ := json.Marshal(e.data)
+			data = string(encoded)
+		}
+		fmt.Fprintf(&buf, "data: %s\n\n", data)
+	}
+	return "text/event-stream", buf.Bytes(), true
+}
+
+func jsonBody(v any) (string, []byte, bool) {
+	body, _ := json.Marshal(v)
+	return "application/json", body, true
+}
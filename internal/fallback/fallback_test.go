@@ -0,0 +1,83 @@
+package fallback
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestResponse(t *testing.T) {
+	t.Parallel()
+
+	const message = "We're having trouble reaching our AI provider. Please try again shortly."
+
+	tests := []struct {
+		name            string
+		targetPath      string
+		stream          bool
+		wantContentType string
+		wantContains    []string
+	}{
+		{
+			name:            "chat completion",
+			targetPath:      "/v1/chat/completions",
+			wantContentType: "application/json",
+			wantContains:    []string{`"object":"chat.completion"`, `"finish_reason":"stop"`},
+		},
+		{
+			name:            "chat completion stream",
+			targetPath:      "/v1/chat/completions",
+			stream:          true,
+			wantContentType: "text/event-stream",
+			wantContains:    []string{`"object":"chat.completion.chunk"`, "data: [DONE]\n\n"},
+		},
+		{
+			name:            "legacy completion",
+			targetPath:      "/v1/completions",
+			wantContentType: "application/json",
+			wantContains:    []string{`"object":"text_completion"`, `"text":"We're having trouble`},
+		},
+		{
+			name:            "anthropic message",
+			targetPath:      "/v1/messages",
+			wantContentType: "application/json",
+			wantContains:    []string{`"type":"message"`, `"stop_reason":"end_turn"`},
+		},
+		{
+			name:            "anthropic message stream",
+			targetPath:      "/v1/messages",
+			stream:          true,
+			wantContentType: "text/event-stream",
+			wantContains:    []string{"event: message_start\n", `"type":"text_delta"`, "event: message_stop\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			contentType, body, ok := Response(tt.targetPath, "claude-sonnet", "abc123", message, tt.stream)
+			if !ok {
+				t.Fatal("expected a fallback response")
+			}
+			if contentType != tt.wantContentType {
+				t.Errorf("expected content type %s, got %s", tt.wantContentType, contentType)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("expected body to contain %q, got %s", want, body)
+				}
+			}
+			if !tt.stream && !json.Valid(body) {
+				t.Errorf("expected valid JSON, got %s", body)
+			}
+		})
+	}
+}
+
+func TestResponse_UnsupportedEndpoint(t *testing.T) {
+	t.Parallel()
+
+	if _, _, ok := Response("/v1/embeddings", "embed", "abc123", "sorry", false); ok {
+		t.Error("expected no fallback for embeddings")
+	}
+}
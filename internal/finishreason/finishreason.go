@@ -0,0 +1,177 @@
+// Package finishreason normalizes provider-specific finish and stop reasons
+// into the OpenAI vocabulary, keeping the provider's value alongside.
+package finishreason
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// Normalized finish reasons.
+const (
+	Stop          = "stop"
+	Length        = "length"
+	ToolCalls     = "tool_calls"
+	ContentFilter = "content_filter"
+)
+
+// NativeField is the extension field carrying the provider's original value.
+const NativeField = "native_finish_reason"
+
+var vocabulary = map[string]string{
+	// OpenAI
+	"stop":           Stop,
+	"length":         Length,
+	"tool_calls":     ToolCalls,
+	"function_call":  ToolCalls,
+	"content_filter": ContentFilter,
+	// Anthropic
+	"end_turn":      Stop,
+	"stop_sequence": Stop,
+	"max_tokens":    Length,
+	"tool_use":      ToolCalls,
+	"refusal":       ContentFilter,
+	// Google Gemini / Vertex AI
+	"safety":             ContentFilter,
+	"recitation":         ContentFilter,
+	"blocklist":          ContentFilter,
+	"prohibited_content": ContentFilter,
+	"spii":               ContentFilter,
+	// Amazon Bedrock
+	"guardrail_intervened": ContentFilter,
+	"content_filtered":     ContentFilter,
+	// Cohere
+	"complete":    Stop,
+	"error_toxic": ContentFilter,
+}
+
+// Normalize maps a provider finish reason onto the normalized set. Unknown
+// reasons are returned unchanged.
+func Normalize(reason string) string {
+	if normalized, ok := vocabulary[strings.ToLower(reason)]; ok {
+		return normalized
+	}
+	return reason
+}
+
+// Rewrite normalizes finish reasons in a JSON response or stream chunk. It
+// handles OpenAI-style choices[].finish_reason, which is replaced and the
+// original kept in native_finish_reason, and Anthropic-style stop_reason on
+// messages and message_delta events, which is kept and a normalized
+// finish_reason added next to it. It reports whether payload was changed.
+func Rewrite(payload []byte) ([]byte, bool) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return payload, false
+	}
+
+	changed := false
+	if raw, ok := doc["choices"]; ok {
+		var choices []map[string]json.RawMessage
+		if json.Unmarshal(raw, &choices) == nil {
+			for _, choice := range choices {
+				if reason, ok := stringField(choice, "finish_reason"); ok {
+					choice["finish_reason"] = marshalString(Normalize(reason))
+					choice[NativeField] = marshalString(reason)
+					changed = true
+				}
+			}
+			if changed {
+				doc["choices"], _ = json.Marshal(choices)
+			}
+		}
+	}
+	if addFinishReason(doc) {
+		changed = true
+	}
+	if raw, ok := doc["delta"]; ok {
+		var delta map[string]json.RawMessage
+		if json.Unmarshal(raw, &delta) == nil && addFinishReason(delta) {
+			doc["delta"], _ = json.Marshal(delta)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return payload, false
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return payload, false
+	}
+	return out, true
+}
+
+// addFinishReason adds a normalized finish_reason next to an Anthropic stop_reason.
+func addFinishReason(obj map[string]json.RawMessage) bool {
+	reason, ok := stringField(obj, "stop_reason")
+	if !ok {
+		return false
+	}
+	obj["finish_reason"] = marshalString(Normalize(reason))
+	return true
+}
+
+func stringField(obj map[string]json.RawMessage, key string) (string, bool) {
+	raw, ok := obj[key]
+	if !ok {
+		return "", false
+	}
+	var value string
+	if json.Unmarshal(raw, &value) != nil || value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+func marshalString(s string) json.RawMessage {
+	raw, _ := json.Marshal(s)
+	return raw
+}
+
+// NewStreamReader wraps a server-sent event stream, rewriting the JSON payload
+// of every data line. All other lines pass through unchanged.
+func NewStreamReader(r io.Reader) io.Reader {
+	return &streamReader{src: bufio.NewReader(r)}
+}
+
+type streamReader struct {
+	src     *bufio.Reader
+	pending []byte
+	err     error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		var line []byte
+		line, s.err = s.src.ReadBytes('\n')
+		s.pending = rewriteLine(line)
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// rewriteLine rewrites a single "data:" line, preserving its line ending.
+func rewriteLine(line []byte) []byte {
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return line
+	}
+	body := bytes.TrimRight(data, "\r\n")
+	ending := data[len(body):]
+	out, changed := Rewrite(bytes.TrimSpace(body))
+	if !changed {
+		return line
+	}
+	rewritten := make([]byte, 0, len(out)+len(ending)+6)
+	rewritten = append(rewritten, "data: "...)
+	rewritten = append(rewritten, out...)
+	return append(rewritten, ending...)
+}
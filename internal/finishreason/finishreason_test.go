@@ -0,0 +1,130 @@
+package finishreason
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{"stop", Stop},
+		{"end_turn", Stop},
+		{"STOP", Stop},
+		{"max_tokens", Length},
+		{"MAX_TOKENS", Length},
+		{"tool_use", ToolCalls},
+		{"function_call", ToolCalls},
+		{"SAFETY", ContentFilter},
+		{"guardrail_intervened", ContentFilter},
+		{"pause_turn", "pause_turn"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.reason); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.reason, got, tt.want)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		payload     string
+		wantChanged bool
+		check       func(t *testing.T, doc map[string]any)
+	}{
+		{
+			name:        "openai choices",
+			payload:     `{"choices":[{"index":0,"finish_reason":"end_turn"},{"index":1,"finish_reason":null}]}`,
+			wantChanged: true,
+			check: func(t *testing.T, doc map[string]any) {
+				choice := doc["choices"].([]any)[0].(map[string]any)
+				if choice["finish_reason"] != Stop || choice[NativeField] != "end_turn" {
+					t.Errorf("unexpected choice: %v", choice)
+				}
+				if _, ok := doc["choices"].([]any)[1].(map[string]any)[NativeField]; ok {
+					t.Error("null finish_reason should be left alone")
+				}
+			},
+		},
+		{
+			name:        "anthropic message",
+			payload:     `{"type":"message","stop_reason":"max_tokens"}`,
+			wantChanged: true,
+			check: func(t *testing.T, doc map[string]any) {
+				if doc["stop_reason"] != "max_tokens" || doc["finish_reason"] != Length {
+					t.Errorf("unexpected message: %v", doc)
+				}
+			},
+		},
+		{
+			name:        "anthropic message_delta",
+			payload:     `{"type":"message_delta","delta":{"stop_reason":"tool_use"}}`,
+			wantChanged: true,
+			check: func(t *testing.T, doc map[string]any) {
+				delta := doc["delta"].(map[string]any)
+				if delta["finish_reason"] != ToolCalls {
+					t.Errorf("unexpected delta: %v", delta)
+				}
+			},
+		},
+		{
+			name:    "no finish reason",
+			payload: `{"choices":[{"delta":{"content":"hi"}}]}`,
+		},
+		{
+			name:    "not json",
+			payload: `[DONE]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			out, changed := Rewrite([]byte(tt.payload))
+			if changed != tt.wantChanged {
+				t.Fatalf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !changed {
+				if string(out) != tt.payload {
+					t.Errorf("unchanged payload was modified: %s", out)
+				}
+				return
+			}
+			var doc map[string]any
+			if err := json.Unmarshal(out, &doc); err != nil {
+				t.Fatalf("rewritten payload is not JSON: %v", err)
+			}
+			tt.check(t, doc)
+		})
+	}
+}
+
+func TestNewStreamReader(t *testing.T) {
+	t.Parallel()
+
+	stream := "event: message_delta\r\n" +
+		"data: {\"delta\":{\"stop_reason\":\"end_turn\"}}\r\n\r\n" +
+		": ping\n\n" +
+		"data: [DONE]\n\n"
+	got, err := io.ReadAll(NewStreamReader(strings.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("ReadAll() error: %v", err)
+	}
+
+	want := "event: message_delta\r\n" +
+		"data: {\"delta\":{\"finish_reason\":\"stop\",\"stop_reason\":\"end_turn\"}}\r\n\r\n" +
+		": ping\n\n" +
+		"data: [DONE]\n\n"
+	if string(got) != want {
+		t.Errorf("unexpected stream:\n%q\nwant:\n%q", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+// Package freeze implements read-only mode: a switch that stops every runtime
+// configuration change, such as admin writes, pushed bundles and secret
+// reloads, while traffic continues to be served. It is meant for
+// change-freeze windows and forensic investigations.
+package freeze
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// EnvSource is the source recorded for a freeze set at startup.
+const EnvSource = "PORTUS_READ_ONLY"
+
+// ErrPinned is returned when lifting a freeze set at startup, which only a
+// restart can lift so a leaked admin key cannot end an investigation.
+var ErrPinned = errors.New("read-only mode was set by PORTUS_READ_ONLY and is lifted only by restarting without it")
+
+// Status reports whether configuration is frozen.
+type Status struct {
+	Frozen bool       `json:"frozen"`
+	Reason string     `json:"reason,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+	// Source is PORTUS_READ_ONLY or "admin:<operator>".
+	Source string `json:"source,omitempty"`
+	// Pinned is set when the freeze came from PORTUS_READ_ONLY.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// Switch holds the read-only state. A nil Switch is never frozen. It is safe
+// for concurrent use.
+type Switch struct {
+	mu     sync.Mutex
+	status Status
+}
+
+// New creates a switch, frozen and pinned when readOnly is set.
+func New(readOnly bool) *Switch {
+	s := &Switch{}
+	if readOnly {
+		now := time.Now().UTC()
+		s.status = Status{Frozen: true, Since: &now, Source: EnvSource, Pinned: true}
+	}
+	return s
+}
+
+// Frozen reports whether configuration changes are refused.
+func (s *Switch) Frozen() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.Frozen
+}
+
+// Freeze refuses configuration changes until Unfreeze. Freezing again
+// replaces the reason but keeps the original source and time.
+func (s *Switch) Freeze(reason, source string) Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.status.Frozen {
+		now := time.Now().UTC()
+		s.status = Status{Frozen: true, Since: &now, Source: source}
+	}
+	s.status.Reason = reason
+	return s.status
+}
+
+// Unfreeze allows configuration changes again. A pinned freeze returns
+// ErrPinned.
+func (s *Switch) Unfreeze() (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.Pinned {
+		return s.status, ErrPinned
+	}
+	s.status = Status{}
+	return s.status, nil
+}
+
+// Status reports the current state.
+func (s *Switch) Status() Status {
+	if s == nil {
+		return Status{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
@@ -0,0 +1,58 @@
+package freeze
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSwitch(t *testing.T) {
+	t.Parallel()
+
+	s := New(false)
+	if s.Frozen() {
+		t.Fatal("expected a new switch to be unfrozen")
+	}
+
+	status := s.Freeze("quarter-end change freeze", "admin:ops")
+	if !status.Frozen || status.Source != "admin:ops" || status.Since == nil || status.Pinned {
+		t.Errorf("unexpected status after freeze: %+v", status)
+	}
+	since := *status.Since
+
+	// Freezing again updates the reason only
+	status = s.Freeze("incident 42", "admin:security")
+	if status.Reason != "incident 42" || status.Source != "admin:ops" || !status.Since.Equal(since) {
+		t.Errorf("unexpected status after second freeze: %+v", status)
+	}
+
+	if status, err := s.Unfreeze(); err != nil || status.Frozen {
+		t.Errorf("Unfreeze() = %+v, %v", status, err)
+	}
+	if s.Frozen() {
+		t.Error("expected the switch to be unfrozen")
+	}
+}
+
+func TestSwitch_Pinned(t *testing.T) {
+	t.Parallel()
+
+	s := New(true)
+	if !s.Frozen() || !s.Status().Pinned || s.Status().Source != EnvSource {
+		t.Fatalf("expected a pinned freeze, got %+v", s.Status())
+	}
+	if _, err := s.Unfreeze(); !errors.Is(err, ErrPinned) {
+		t.Errorf("expected ErrPinned, got %v", err)
+	}
+	if !s.Frozen() {
+		t.Error("expected a pinned freeze to stay in place")
+	}
+}
+
+func TestSwitch_Nil(t *testing.T) {
+	t.Parallel()
+
+	var s *Switch
+	if s.Frozen() || s.Status().Frozen {
+		t.Error("expected a nil switch to be unfrozen")
+	}
+}
@@ -0,0 +1,140 @@
+// Package guardrail evaluates request policy rules (banned phrases, regular
+// expressions and a message count cap) before a request is proxied.
+package guardrail
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+// Rule names reported in violations.
+const (
+	RuleBannedPhrase = "banned_phrase"
+	RulePattern      = "pattern"
+	RuleMaxMessages  = "max_messages"
+)
+
+// contentKeys are the JSON fields holding message content. Strings are
+// checked when their field, or the array containing them, has one of these
+// names.
+var contentKeys = map[string]bool{
+	"content":      true,
+	"text":         true,
+	"prompt":       true,
+	"input":        true,
+	"system":       true,
+	"instructions": true,
+}
+
+// compiled caches patterns by expression, since the same rules are checked on
+// every request.
+var compiled sync.Map // string -> *regexp.Regexp
+
+// Violation describes the first rule a request broke.
+type Violation struct {
+	Rule string
+	// Detail identifies the rule for logs, e.g. which phrase matched.
+	Detail string
+}
+
+// Validate checks that rules are well-formed.
+func Validate(rules models.GuardrailConfig) error {
+	if rules.MaxMessages < 0 {
+		return fmt.Errorf("max_messages must not be negative")
+	}
+	for _, phrase := range rules.BannedPhrases {
+		if strings.TrimSpace(phrase) == "" {
+			return fmt.Errorf("banned_phrases must not contain empty phrases")
+		}
+	}
+	for _, expr := range rules.Patterns {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", expr, err)
+		}
+	}
+	return nil
+}
+
+// Check evaluates rules against a JSON request body and returns the first
+// violation, or nil. Bodies that are not JSON objects pass.
+func Check(rules models.GuardrailConfig, body []byte) *Violation {
+	var root map[string]any
+	if json.Unmarshal(body, &root) != nil {
+		return nil
+	}
+
+	if rules.MaxMessages > 0 {
+		if n := messageCount(root); n > rules.MaxMessages {
+			return &Violation{Rule: RuleMaxMessages, Detail: fmt.Sprintf("%d messages, limit %d", n, rules.MaxMessages)}
+		}
+	}
+
+	if len(rules.BannedPhrases) == 0 && len(rules.Patterns) == 0 {
+		return nil
+	}
+	var texts []string
+	collect(root, false, &texts)
+	for _, text := range texts {
+		lower := strings.ToLower(text)
+		for _, phrase := range rules.BannedPhrases {
+			if strings.Contains(lower, strings.ToLower(phrase)) {
+				return &Violation{Rule: RuleBannedPhrase, Detail: phrase}
+			}
+		}
+		for _, expr := range rules.Patterns {
+			if re := pattern(expr); re != nil && re.MatchString(text) {
+				return &Violation{Rule: RulePattern, Detail: expr}
+			}
+		}
+	}
+	return nil
+}
+
+// messageCount counts chat and Messages API messages, or Responses API input
+// items.
+func messageCount(root map[string]any) int {
+	if messages, ok := root["messages"].([]any); ok {
+		return len(messages)
+	}
+	if input, ok := root["input"].([]any); ok {
+		return len(input)
+	}
+	return 0
+}
+
+// collect appends the strings below v that sit under a content field.
+func collect(v any, inContent bool, texts *[]string) {
+	switch v := v.(type) {
+	case string:
+		if inContent {
+			*texts = append(*texts, v)
+		}
+	case []any:
+		for _, item := range v {
+			collect(item, inContent, texts)
+		}
+	case map[string]any:
+		for key, item := range v {
+			collect(item, contentKeys[key], texts)
+		}
+	}
+}
+
+// pattern returns the compiled expression, or nil if it does not compile;
+// Validate rejects such rules at load.
+func pattern(expr string) *regexp.Regexp {
+	if re, ok := compiled.Load(expr); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil
+	}
+	compiled.Store(expr, re)
+	return re
+}
@@ -0,0 +1,74 @@
+package guardrail
+
+import (
+	"testing"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	rules := models.GuardrailConfig{
+		BannedPhrases: []string{"Secret Project"},
+		Patterns:      []string{`\bDROP\s+TABLE\b`},
+		MaxMessages:   2,
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantRule string
+	}{
+		{name: "clean", body: `{"messages":[{"role":"user","content":"hello"}]}`},
+		{name: "banned phrase in content part", body: `{"messages":[{"role":"user","content":[{"type":"text","text":"about the secret project"}]}]}`, wantRule: RuleBannedPhrase},
+		{name: "pattern in system", body: `{"system":"DROP  TABLE users","messages":[]}`, wantRule: RulePattern},
+		{name: "pattern in prompt", body: `{"prompt":["DROP TABLE users"]}`, wantRule: RulePattern},
+		{name: "too many messages", body: `{"messages":[{"role":"user","content":"a"},{"role":"assistant","content":"b"},{"role":"user","content":"c"}]}`, wantRule: RuleMaxMessages},
+		{name: "too many input items", body: `{"input":[{"role":"user","content":"a"},{"role":"user","content":"b"},{"role":"user","content":"c"}]}`, wantRule: RuleMaxMessages},
+		{name: "outside content", body: `{"model":"secret project","user":"DROP TABLE"}`},
+		{name: "not JSON", body: `secret project`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			violation := Check(rules, []byte(tt.body))
+			if tt.wantRule == "" {
+				if violation != nil {
+					t.Errorf("unexpected violation %+v", violation)
+				}
+				return
+			}
+			if violation == nil || violation.Rule != tt.wantRule {
+				t.Errorf("violation = %+v, want rule %s", violation, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		rules   models.GuardrailConfig
+		wantErr bool
+	}{
+		{name: "valid", rules: models.GuardrailConfig{BannedPhrases: []string{"x"}, Patterns: []string{`^a+$`}, MaxMessages: 10}},
+		{name: "negative max messages", rules: models.GuardrailConfig{MaxMessages: -1}, wantErr: true},
+		{name: "empty phrase", rules: models.GuardrailConfig{BannedPhrases: []string{" "}}, wantErr: true},
+		{name: "invalid pattern", rules: models.GuardrailConfig{Patterns: []string{`(`}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := Validate(tt.rules); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -4,37 +4,135 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/amscotti/portus/internal/annotate"
+	"github.com/amscotti/portus/internal/breaker"
+	"github.com/amscotti/portus/internal/budget"
+	"github.com/amscotti/portus/internal/capability"
+	"github.com/amscotti/portus/internal/concurrency"
+	"github.com/amscotti/portus/internal/conversation"
+	"github.com/amscotti/portus/internal/cost"
+	"github.com/amscotti/portus/internal/credguard"
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/experiment"
+	"github.com/amscotti/portus/internal/fallback"
+	"github.com/amscotti/portus/internal/finishreason"
+	"github.com/amscotti/portus/internal/guardrail"
+	"github.com/amscotti/portus/internal/hedge"
+	"github.com/amscotti/portus/internal/messagestream"
 	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/modellist"
 	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/otlp"
+	"github.com/amscotti/portus/internal/override"
+	"github.com/amscotti/portus/internal/postprocess"
+	"github.com/amscotti/portus/internal/privacy"
+	"github.com/amscotti/portus/internal/progress"
+	"github.com/amscotti/portus/internal/provenance"
+	"github.com/amscotti/portus/internal/quota"
+	"github.com/amscotti/portus/internal/report"
+	"github.com/amscotti/portus/internal/semcache"
+	"github.com/amscotti/portus/internal/spool"
+	"github.com/amscotti/portus/internal/statsd"
+	"github.com/amscotti/portus/internal/streamlimit"
+	"github.com/amscotti/portus/internal/streamusage"
+	"github.com/amscotti/portus/internal/tlsreload"
+	"github.com/amscotti/portus/internal/tokenlimit"
+	"github.com/amscotti/portus/internal/translate"
+	"github.com/amscotti/portus/internal/usage"
+	"github.com/amscotti/portus/internal/usagestore"
 )
 
 const maxBodySize = 10 * 1024 * 1024 // 10 MB
 
+// maxAudioBodySize allows for uploaded audio files in multipart requests.
+const maxAudioBodySize = 25 * 1024 * 1024 // 25 MB
+
+// maxImageResponseSize is the larger capture limit for image responses, which can
+// carry several base64-encoded images.
+const maxImageResponseSize = 64 * 1024 * 1024 // 64 MB
+
+// Semantic cache response headers and the time allowed to embed a prompt.
+const (
+	semanticCacheHeader           = "X-Portus-Cache"
+	semanticCacheSimilarityHeader = "X-Portus-Cache-Similarity"
+	semanticCacheLookupTimeout    = 10 * time.Second
+)
+
+// Experiment headers: the client's sticky key for variant assignment, and
+// the "<experiment>=<variant>" tag on responses.
+const (
+	stickyKeyHeader  = "X-Portus-Sticky-Key"
+	experimentHeader = "X-Portus-Experiment"
+)
+
+// requestTimeoutHeader is the Portkey header clients use to request a timeout
+// in milliseconds.
+const requestTimeoutHeader = "X-Portkey-Request-Timeout"
+
+// deadlineHeader carries an absolute RFC 3339 deadline by which the client
+// needs the response, tightening the request's timeout.
+const deadlineHeader = "X-Portus-Deadline"
+
+// statusClientClosedRequest records a request whose client went away before
+// the response; nothing is sent. The code follows nginx.
+const statusClientClosedRequest = 499
+
+// errClientGone reports that the client stopped accepting a response.
+var errClientGone = errors.New("client disconnected")
+
+// portkeyMetadataHeader carries a JSON object of labels into Portkey's logs.
+const portkeyMetadataHeader = "X-Portkey-Metadata"
+
+// defaultQueueTimeout is how long a request waits for an alias concurrency
+// slot when the alias does not set queue_timeout.
+const defaultQueueTimeout = 30 * time.Second
+
+// modelListTimeout bounds each live model list fetch, so a slow provider only
+// delays /v1/models rather than hanging it.
+const modelListTimeout = 10 * time.Second
+
 // hopByHopHeaders are headers that should not be forwarded by proxies.
 var hopByHopHeaders = map[string]struct{}{
-	"Connection":          {},
-	"Keep-Alive":          {},
-	"Proxy-Authenticate":  {},
-	"Proxy-Authorization": {},
-	"Te":                  {},
-	"Trailers":            {},
-	"Transfer-Encoding":   {},
-	"Upgrade":             {},
-	"Authorization":       {},
-	"X-Api-Key":           {},
+	"Connection":            {},
+	"Keep-Alive":            {},
+	"Proxy-Authenticate":    {},
+	"Proxy-Authorization":   {},
+	"Te":                    {},
+	"Trailers":              {},
+	"Transfer-Encoding":     {},
+	"Upgrade":               {},
+	"Authorization":         {},
+	"X-Api-Key":             {},
+	"X-Portus-Provider-Key": {},
 }
 
 // gatewayTransport is a shared transport for connection pooling to the gateway.
+// HTTPS gateways that offer HTTP/2 multiplex concurrent requests over fewer
+// connections.
 var gatewayTransport = &http.Transport{
+	ForceAttemptHTTP2:   true,
 	MaxIdleConns:        100,
 	MaxIdleConnsPerHost: 100,
 	IdleConnTimeout:     90 * time.Second,
@@ -50,6 +148,101 @@ var gatewayClient = &http.Client{
 	Transport: gatewayTransport,
 }
 
+// SetGatewayTLSConfig configures TLS, including client certificates, for
+// connections to the gateway. It must be called before serving requests.
+func SetGatewayTLSConfig(cfg *tls.Config) {
+	gatewayTransport.TLSClientConfig = cfg
+}
+
+// UseGatewayH2C sends gateway requests over HTTP/2 without TLS, with prior
+// knowledge, for a plaintext gateway that accepts h2c. It must be called
+// before serving requests.
+func UseGatewayH2C() {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	gatewayTransport.Protocols = protocols
+}
+
+// mockGateway is set when gateway requests are answered locally, which
+// per-alias upstream TLS must not bypass.
+var mockGateway bool
+
+// UseMockGateway answers every gateway request with rt instead of the
+// network, for PORTUS_MOCK_MODE. It must be called before serving requests.
+func UseMockGateway(rt http.RoundTripper) {
+	gatewayClient.Transport = rt
+	mockGateway = true
+}
+
+// aliasClients caches gateway clients for aliases with their own upstream TLS
+// credentials, keyed by the credential files so aliases sharing them share
+// connections.
+var aliasClients = struct {
+	sync.Mutex
+	m map[models.UpstreamTLSConfig]*http.Client
+}{m: make(map[models.UpstreamTLSConfig]*http.Client)}
+
+// gatewayClientFor returns the client for an alias's gateway requests: the
+// shared client, or one presenting the alias's upstream TLS credentials. The
+// credentials are loaded on first use and reloaded when rotated on disk.
+func gatewayClientFor(store *models.ConfigStore, model models.ModelConfig) (*http.Client, error) {
+	if model.UpstreamTLS == nil || mockGateway {
+		return gatewayClient, nil
+	}
+	files := *model.UpstreamTLS
+	if files.CAFile == "" {
+		files.CAFile = store.GatewayTLSCA
+	}
+
+	aliasClients.Lock()
+	defer aliasClients.Unlock()
+	if client, ok := aliasClients.m[files]; ok {
+		return client, nil
+	}
+	reloader, err := tlsreload.New(files.CertFile, files.KeyFile, files.CAFile, slog.Default())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upstream TLS credentials: %w", err)
+	}
+	go reloader.Run(context.Background(), store.TLSReloadInterval)
+	transport := gatewayTransport.Clone()
+	transport.TLSClientConfig = reloader.ClientConfig()
+	client := &http.Client{Transport: transport}
+	aliasClients.m[files] = client
+	return client, nil
+}
+
+// Services bundles the runtime subsystems shared by the proxy handlers.
+// Optional subsystems may be left nil.
+type Services struct {
+	Usage    *usage.Tracker
+	History  *usage.History
+	Records  *usagestore.Store
+	Progress *progress.Monitor
+	Streams  *streamlimit.Limiter
+	Report   *report.Recorder
+	Cache    *semcache.Cache
+	Breaker  *breaker.Breaker
+	// Credentials degrades aliases whose upstream keeps rejecting their
+	// credentials.
+	Credentials *credguard.Guard
+	Privacy     *privacy.Policy
+	Catalog     *modellist.Cache
+	Concurrency *concurrency.Limiter
+	Provenance  *provenance.Signer
+	Budgets     *budget.Tracker
+	Quotas      *quota.Counter
+	// Conversations holds token totals per client conversation ID.
+	Conversations *conversation.Tracker
+	// StatsD receives per-request metrics when a StatsD agent is configured.
+	StatsD *statsd.Client
+	// Latency collects request durations, with trace exemplars, for OTLP
+	// metrics export.
+	Latency *otlp.Latency
+	// Events receives guardrail blocks for the admin event stream and
+	// webhooks.
+	Events *events.Hub
+}
+
 // writeJSONError writes a JSON-formatted error response with proper escaping.
 func writeJSONError(w http.ResponseWriter, msg string, code int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -79,8 +272,11 @@ func HealthHandler(store *models.ConfigStore) http.HandlerFunc {
 	}
 }
 
-// ModelsHandler returns the models list endpoint handler.
-func ModelsHandler(store *models.ConfigStore) http.HandlerFunc {
+// ModelsHandler returns the models list endpoint handler. Configured aliases
+// are listed first, followed by the live models of aliases with list_models
+// set, skipping IDs already listed. Aliases hidden by the credential guard
+// are left out, live models included.
+func ModelsHandler(store *models.ConfigStore, svc *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -90,257 +286,2707 @@ func ModelsHandler(store *models.ConfigStore) http.HandlerFunc {
 		// Build model list using server start time as "created" timestamp
 		created := store.StartTime.Unix()
 
-		data := make([]models.ModelObject, 0, len(store.Models))
-		for alias := range store.Models {
+		aliases := store.ModelAliases()
+		data := make([]models.ModelObject, 0, len(aliases))
+		seen := make(map[string]bool, len(aliases))
+		var listed []string
+		for _, alias := range aliases {
+			if svc.Credentials.Hidden(alias) {
+				continue
+			}
 			data = append(data, models.ModelObject{
 				ID:      alias,
 				Object:  "model",
 				Created: created,
 				OwnedBy: "portus",
 			})
+			seen[alias] = true
+			if modelConfig, ok := store.Model(alias); ok && modelConfig.ListModels {
+				listed = append(listed, alias)
+			}
+		}
+
+		live := svc.Catalog.List(r.Context(), listed)
+		for _, alias := range listed {
+			for _, m := range live[alias] {
+				if seen[m.ID] {
+					continue
+				}
+				seen[m.ID] = true
+				data = append(data, models.ModelObject{
+					ID:      m.ID,
+					Object:  "model",
+					Created: created,
+					OwnedBy: m.OwnedBy,
+				})
+			}
+		}
+
+		response := models.ModelsListResponse{
+			Object: "list",
+			Data:   data,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// StatsHandler returns the usage statistics endpoint handler. Callers only see
+// the usage recorded for their own application.
+func StatsHandler(svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+
+		// Aggregate-only mode reports deployment-wide figures with bucketed
+		// applications and no per-request detail
+		activeStreams := svc.Progress.ActiveStreams(application)
+		openStreams := svc.Progress.Streams(application)
+		if svc.Privacy.Aggregate() {
+			application = ""
+			activeStreams = nil
+			openStreams = nil
+		}
+
+		// Optional time series, e.g. ?resolution=hour&since=2026-01-01T00:00:00Z
+		var history []usage.Bucket
+		if res := r.URL.Query().Get("resolution"); res != "" {
+			resolution, err := usage.ParseResolution(res)
+			if err != nil {
+				writeJSONError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var since time.Time
+			if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+				since, err = time.Parse(time.RFC3339, sinceStr)
+				if err != nil {
+					writeJSONError(w, "Invalid since: must be an RFC 3339 timestamp", http.StatusBadRequest)
+					return
+				}
+			}
+			history = svc.Privacy.History(svc.History.Series(application, resolution, since))
+		}
+
+		totals := svc.Privacy.Totals(svc.Usage.Snapshot(application))
+		var totalCost float64
+		for _, t := range totals {
+			totalCost += t.EstimatedCostUSD
+		}
+
+		response := models.StatsResponse{
+			Application:      application,
+			EstimatedCostUSD: totalCost,
+			Usage:            totals,
+			ActiveStreams:    activeStreams,
+			OpenStreams:      openStreams,
+			ReapedStreams:    svc.Progress.Reaped(),
+			Providers:        svc.Progress.Providers(),
+			History:          history,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// WhoamiHandler returns the debug endpoint that echoes how Portus resolved the
+// caller's credentials, so developers can self-diagnose auth and access issues.
+func WhoamiHandler(store *models.ConfigStore, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		scope, _ := r.Context().Value(middleware.ContextKeyScope).(models.KeyScope)
+		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+		response := models.WhoamiResponse{
+			Application:   application,
+			Scope:         scope,
+			RequestID:     requestID,
+			AllowedModels: []string{},
+		}
+
+		// Observability tokens cannot call any model. Aliases the proxy would
+		// refuse, for a banned model or rejected credentials, are left out.
+		if scope == models.ScopeInference {
+			for _, alias := range store.ModelAliases() {
+				modelConfig, ok := store.Model(alias)
+				if !ok || models.BannedModel(modelConfig, alias, store.BannedModels) != "" || svc.Credentials.Degraded(alias) {
+					continue
+				}
+				response.AllowedModels = append(response.AllowedModels, alias)
+			}
+			limits := callerLimits(r, store, svc, time.Now())
+			response.Limits = &limits
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// LimitsHandler returns the caller's current limits, remaining headroom,
+// reset times and budget spend.
+func LimitsHandler(store *models.ConfigStore, svc *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(callerLimits(r, store, svc, time.Now()))
+	}
+}
+
+// callerLimits returns the limits of the request's key at now, with the
+// headroom left in each.
+func callerLimits(r *http.Request, store *models.ConfigStore, svc *Services, now time.Time) models.LimitsResponse {
+	application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+	proxyKey, _ := r.Context().Value(middleware.ContextKeyProxyKey).(models.ProxyKey)
+
+	response := models.LimitsResponse{
+		Application: application,
+		Budgets:     []models.BudgetUsage{},
+	}
+	if proxyKey.MaxStreams > 0 {
+		active := svc.Streams.Active(application)
+		response.Streams = &models.StreamLimits{
+			Limit:     proxyKey.MaxStreams,
+			Burst:     store.StreamBurst,
+			Active:    active,
+			Remaining: max(proxyKey.MaxStreams-active, 0),
+		}
+	}
+	if proxyKey.MaxTokens > 0 {
+		response.MaxTokens = &models.MaxTokensLimit{Limit: proxyKey.MaxTokens, Action: store.MaxTokensAction}
+	}
+	for _, u := range svc.Budgets.Status(application, store.Budgets[application], now) {
+		response.Budgets = append(response.Budgets, models.BudgetUsage{
+			Window:       u.Window,
+			LimitUSD:     u.Limit,
+			SpentUSD:     u.Spent,
+			RemainingUSD: max(u.Limit-u.Spent, 0),
+			ResetsAt:     u.ResetsAt,
+		})
+	}
+	if proxyKey.RequestQuota.Limit > 0 {
+		used := svc.Quotas.Status(application, proxyKey.RequestQuota, now)
+		response.Requests = &models.RequestQuotaUsage{
+			Limit:     used.Limit,
+			Window:    used.Window,
+			Used:      used.Used,
+			Remaining: used.Remaining,
+			ResetsAt:  used.ResetsAt,
+		}
+	}
+	if now.Before(store.LimitsGraceUntil) {
+		response.GraceUntil = &store.LimitsGraceUntil
+	}
+	return response
+}
+
+// ChatCompletionsHandler returns the chat completions endpoint handler.
+func ChatCompletionsHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Buffer request body with size limit
+		spooled, ok := spoolRequestBody(w, r, store, logger, maxBodySize)
+		if !ok {
+			return
 		}
+		defer spooled.Close()
+		body, r, ok := jsonRequestBody(w, r, spooled, logger)
+		if !ok {
+			return
+		}
+
+		var req models.ChatCompletionRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Error("failed to parse request body", "error", err)
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// Validate model alias
+		modelConfig, ok := resolveModelAlias(w, r, store, logger, &req.Model)
+		if !ok {
+			return
+		}
+
+		// Get context values
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+		// Serve near-duplicate prompts from the semantic cache, once the
+		// request has passed the guardrails a cached answer would skip
+		if svc.Cache != nil && modelConfig.SemanticCache && !req.Stream {
+			if !checkGuardrails(w, body, modelConfig, store, svc.Events, logger, requestID, application, req.Model) {
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), guardrailsCheckedKey{}, true))
+			began := time.Now()
+			scope := semanticCacheScope(application, req.Model, body)
+			lookupCtx, cancel := context.WithTimeout(r.Context(), semanticCacheLookupTimeout)
+			match, err := svc.Cache.Lookup(lookupCtx, scope, promptText(req.Messages))
+			cancel()
+			if err != nil {
+				logger.Warn("semantic cache lookup failed", "request_id", requestID, "model_alias", req.Model, "error", err)
+			} else if match.Hit {
+				logger.Info("semantic cache hit",
+					"request_id", requestID,
+					"application", application,
+					"model_alias", req.Model,
+					"similarity", match.Similarity,
+				)
+				svc.Usage.RecordCacheHit(svc.Privacy.Label(application), req.Model)
+				svc.Report.RecordRequest(req.Model, http.StatusOK)
+				recordStatsDRequest(svc, application, req.Model, requestTags(r, modelConfig), http.StatusOK, time.Since(began))
+				signProvenance(w, svc, requestID, req.Model)
+				w.Header().Set("Content-Type", match.Entry.ContentType)
+				w.Header().Set(semanticCacheHeader, "hit")
+				w.Header().Set(semanticCacheSimilarityHeader, strconv.FormatFloat(match.Similarity, 'f', 4, 64))
+				cached := match.Entry.Body
+				if store.AnnotateResponses {
+					cached, _ = annotate.JSON(cached, annotate.Annotation{
+						Alias:    req.Model,
+						Provider: getProviderFromConfig(modelConfig),
+						Cached:   true,
+					})
+				}
+				w.WriteHeader(http.StatusOK)
+				w.Write(cached)
+				return
+			} else {
+				// Cache the upstream answer for the next similar prompt
+				w.Header().Set(semanticCacheHeader, "miss")
+				capture := &responseCapture{ResponseWriter: w, buf: cappedBuffer{limit: maxBodySize}}
+				proxyTranslated(capture, r, body, translate.OpenAI, "/v1/chat/completions", modelConfig, store, svc, logger, requestID, application, req.Model)
+				if capture.cacheable() {
+					svc.Cache.Add(scope, match.Vector, semcache.Entry{
+						Body:        capture.buf.buf.Bytes(),
+						ContentType: capture.Header().Get("Content-Type"),
+					})
+				}
+				return
+			}
+		}
+
+		// Delegate to shared proxy handler
+		proxyTranslated(w, r, body, translate.OpenAI, "/v1/chat/completions", modelConfig, store, svc, logger, requestID, application, req.Model)
+	}
+}
+
+// SemanticCacheEmbedder returns an embedder that embeds text through the
+// gateway using the given embeddings alias.
+func SemanticCacheEmbedder(store *models.ConfigStore, alias string) semcache.Embedder {
+	return func(ctx context.Context, text string) ([]float64, error) {
+		modelConfig, ok := store.Model(alias)
+		if !ok {
+			return nil, fmt.Errorf("unknown embeddings alias: %s", alias)
+		}
+		if banned := models.BannedModel(modelConfig, alias, store.BannedModels); banned != "" {
+			return nil, fmt.Errorf("alias %s resolves to banned model %s", alias, banned)
+		}
+
+		body, err := json.Marshal(map[string]string{"model": alias, "input": text})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, store.GatewayURLFor(alias)+"/v1/embeddings", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := setPortkeyHeaders(req, buildPortkeyConfig(modelConfig), modelConfig); err != nil {
+			return nil, err
+		}
+		client, err := gatewayClientFor(store, modelConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(io.Discard, resp.Body)
+			return nil, fmt.Errorf("embeddings request returned status %d", resp.StatusCode)
+		}
+
+		var parsed struct {
+			Data []struct {
+				Embedding []float64 `json:"embedding"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&parsed); err != nil {
+			return nil, fmt.Errorf("failed to parse embeddings response: %w", err)
+		}
+		if len(parsed.Data) == 0 || len(parsed.Data[0].Embedding) == 0 {
+			return nil, fmt.Errorf("embeddings response contained no vector")
+		}
+		return parsed.Data[0].Embedding, nil
+	}
+}
+
+// semanticCacheScope partitions the cache by application, alias and every
+// request parameter other than the conversation itself, so a cached answer is
+// only reused for an equivalent request.
+func semanticCacheScope(application, modelAlias string, body []byte) string {
+	var params map[string]json.RawMessage
+	json.Unmarshal(body, &params)
+	for _, field := range []string{"messages", "model", "stream", "stream_options", "user"} {
+		delete(params, field)
+	}
+	encoded, _ := json.Marshal(params)
+	sum := sha256.Sum256(encoded)
+	return application + "\x00" + modelAlias + "\x00" + hex.EncodeToString(sum[:])
+}
+
+// promptText flattens the text of a conversation for embedding.
+func promptText(messages []models.Message) string {
+	var b strings.Builder
+	for _, msg := range messages {
+		b.WriteString(msg.Role)
+		b.WriteString(": ")
+		switch content := msg.Content.(type) {
+		case string:
+			b.WriteString(content)
+		case []interface{}:
+			for _, part := range content {
+				if p, ok := part.(map[string]interface{}); ok {
+					if text, ok := p["text"].(string); ok {
+						b.WriteString(text)
+					}
+				}
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// responseCapture keeps a copy of a relayed response so it can be cached.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	buf    cappedBuffer
+}
+
+func (c *responseCapture) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.buf.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher by delegating to the underlying writer.
+func (c *responseCapture) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (c *responseCapture) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// cacheable reports whether the captured response is a complete, successful,
+// uncompressed provider answer.
+func (c *responseCapture) cacheable() bool {
+	h := c.Header()
+	return c.status == http.StatusOK && !c.buf.truncated && c.buf.buf.Len() > 0 &&
+		isJSON(h) && h.Get("Content-Encoding") == "" && h.Get(fallback.Header) == ""
+}
+
+// proxyTranslated forwards a request that the client sent in clientFormat.
+// When the alias's api_format names the other format, the request is
+// converted and sent to that format's endpoint, and successful responses are
+// converted back; otherwise it is forwarded to targetPath unchanged.
+func proxyTranslated(w http.ResponseWriter, r *http.Request, body []byte, clientFormat translate.Format, targetPath string, modelConfig models.ModelConfig, store *models.ConfigStore, svc *Services, logger *slog.Logger, requestID, application, modelAlias string) {
+	upstream := translate.Format(modelConfig.APIFormat)
+	if upstream == "" || upstream == clientFormat {
+		handleProxyRequest(w, r, body, targetPath, modelConfig, store, svc, logger, requestID, application, modelAlias)
+		return
+	}
+
+	tw := &translatingWriter{ResponseWriter: w, logger: logger, requestID: requestID, annotated: store.AnnotateResponses}
+	var err error
+	switch upstream {
+	case translate.Anthropic:
+		var options struct {
+			StreamOptions struct {
+				IncludeUsage bool `json:"include_usage"`
+			} `json:"stream_options"`
+		}
+		json.Unmarshal(body, &options)
+		includeUsage := options.StreamOptions.IncludeUsage
+		var maxTokens int
+		if !setsMaxTokens(body) {
+			maxTokens = defaultMaxTokens(modelConfig, logger, requestID, modelAlias)
+		}
+		body, err = translate.ChatRequestToMessages(body, maxTokens)
+		targetPath = "/v1/messages"
+		tw.convert = translate.MessagesResponseToChat
+		tw.newStream = func(dst io.Writer) *translate.Stream { return translate.NewMessagesToChatStream(dst, includeUsage) }
+	case translate.OpenAI:
+		body, err = translate.MessagesRequestToChat(body)
+		targetPath = "/v1/chat/completions"
+		tw.convert = translate.ChatResponseToMessages
+		tw.newStream = translate.NewChatToMessagesStream
+	}
+	if err != nil {
+		logger.Error("failed to translate request", "request_id", requestID, "model_alias", modelAlias, "error", err)
+		writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Translation needs the upstream's own uncompressed SSE or JSON
+	r = r.Clone(r.Context())
+	r.Header.Del("Accept")
+	r.Header.Del("Accept-Encoding")
+
+	handleProxyRequest(tw, r, body, targetPath, modelConfig, store, svc, logger, requestID, application, modelAlias)
+	tw.finish()
+}
+
+// defaultMaxTokens returns the max_tokens injected into Anthropic requests
+// that set none, derived from the alias's config or the model's known output
+// limit, and logs the value and where it came from.
+func defaultMaxTokens(model models.ModelConfig, logger *slog.Logger, requestID, modelAlias string) int {
+	n, source := capability.DefaultMaxTokens(model)
+	logger.Info("injected default max_tokens",
+		"request_id", requestID,
+		"model_alias", modelAlias,
+		"max_tokens", n,
+		"source", source,
+	)
+	return n
+}
+
+// setsMaxTokens reports whether a chat request sets max_tokens or
+// max_completion_tokens.
+func setsMaxTokens(body []byte) bool {
+	var limits struct {
+		MaxTokens           int `json:"max_tokens"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+	}
+	json.Unmarshal(body, &limits)
+	return limits.MaxTokens > 0 || limits.MaxCompletionTokens > 0
+}
+
+// translatingWriter converts successful JSON and event stream responses into
+// the client's format. Other responses, such as errors, are relayed as is.
+type translatingWriter struct {
+	http.ResponseWriter
+	convert   func([]byte) ([]byte, error)
+	newStream func(io.Writer) *translate.Stream
+	logger    *slog.Logger
+	requestID string
+	annotated bool
+
+	wroteHeader bool
+	buffered    bool
+	body        bytes.Buffer
+	stream      *translate.Stream
+}
+
+func (t *translatingWriter) WriteHeader(code int) {
+	if t.wroteHeader {
+		return
+	}
+	t.wroteHeader = true
+	if code == http.StatusOK {
+		h := t.Header()
+		switch {
+		case isEventStream(h):
+			t.stream = t.newStream(t.ResponseWriter)
+			h.Del("Content-Length")
+		case isJSON(h):
+			t.buffered = true
+			h.Del("Content-Length")
+		}
+	}
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *translatingWriter) Write(b []byte) (int, error) {
+	if !t.wroteHeader {
+		t.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case t.stream != nil:
+		return t.stream.Write(b)
+	case t.buffered:
+		return t.body.Write(b)
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher by delegating to the underlying writer.
+func (t *translatingWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (t *translatingWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// finish writes out a buffered response or completes a translated stream.
+func (t *translatingWriter) finish() {
+	if t.stream != nil {
+		if err := t.stream.Close(); err != nil {
+			t.logger.Warn("failed to translate response stream", "request_id", t.requestID, "error", err)
+		}
+		t.Flush()
+		return
+	}
+	if !t.buffered {
+		return
+	}
+	out, err := t.convert(t.body.Bytes())
+	if err != nil {
+		// Relay the upstream answer rather than nothing at all
+		t.logger.Warn("failed to translate response", "request_id", t.requestID, "error", err)
+		out = t.body.Bytes()
+	} else if t.annotated {
+		out = annotate.Carry(t.body.Bytes(), out)
+	}
+	t.ResponseWriter.Write(out)
+}
+
+// MessagesHandler returns the Anthropic messages endpoint handler.
+func MessagesHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Buffer request body with size limit
+		spooled, ok := spoolRequestBody(w, r, store, logger, maxBodySize)
+		if !ok {
+			return
+		}
+		defer spooled.Close()
+		body, r, ok := jsonRequestBody(w, r, spooled, logger)
+		if !ok {
+			return
+		}
+
+		var req models.MessagesRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Error("failed to parse request body", "error", err)
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// Validate model alias
+		modelConfig, ok := resolveModelAlias(w, r, store, logger, &req.Model)
+		if !ok {
+			return
+		}
+
+		// Get context values
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+		// Ensure max_tokens is set
+		if req.MaxTokens == 0 {
+			req.MaxTokens = defaultMaxTokens(modelConfig, logger, requestID, req.Model)
+			// Update the body with the injected max_tokens
+			bodyMap := make(map[string]interface{})
+			if err := json.Unmarshal(body, &bodyMap); err == nil {
+				bodyMap["max_tokens"] = req.MaxTokens
+				if updatedBody, err := json.Marshal(bodyMap); err == nil {
+					body = updatedBody
+				}
+			}
+		}
+
+		// Inject thinking configuration if present in model config
+		if modelConfig.Thinking != nil && req.Thinking == nil {
+			bodyMap := make(map[string]interface{})
+			if err := json.Unmarshal(body, &bodyMap); err == nil {
+				bodyMap["thinking"] = modelConfig.Thinking
+				if updatedBody, err := json.Marshal(bodyMap); err == nil {
+					body = updatedBody
+				}
+			}
+		}
+
+		// Delegate to shared proxy handler
+		proxyTranslated(w, r, body, translate.Anthropic, "/v1/messages", modelConfig, store, svc, logger, requestID, application, req.Model)
+	}
+}
+
+// EmbeddingsHandler returns the OpenAI embeddings endpoint handler.
+func EmbeddingsHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Buffer request body with size limit
+		spooled, ok := spoolRequestBody(w, r, store, logger, maxBodySize)
+		if !ok {
+			return
+		}
+		defer spooled.Close()
+		body, r, ok := jsonRequestBody(w, r, spooled, logger)
+		if !ok {
+			return
+		}
+
+		var req models.EmbeddingsRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Error("failed to parse request body", "error", err)
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// Validate model alias
+		modelConfig, ok := resolveModelAlias(w, r, store, logger, &req.Model)
+		if !ok {
+			return
+		}
+
+		// Get context values
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+		// Delegate to shared proxy handler
+		handleProxyRequest(w, r, body, "/v1/embeddings", modelConfig, store, svc, logger, requestID, application, req.Model)
+	}
+}
+
+// CompletionsHandler returns the legacy OpenAI text completions endpoint handler.
+func CompletionsHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Buffer request body with size limit
+		spooled, ok := spoolRequestBody(w, r, store, logger, maxBodySize)
+		if !ok {
+			return
+		}
+		defer spooled.Close()
+		body, r, ok := jsonRequestBody(w, r, spooled, logger)
+		if !ok {
+			return
+		}
+
+		var req models.CompletionRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Error("failed to parse request body", "error", err)
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// Validate model alias
+		modelConfig, ok := resolveModelAlias(w, r, store, logger, &req.Model)
+		if !ok {
+			return
+		}
+
+		// Get context values
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+		// Delegate to shared proxy handler
+		handleProxyRequest(w, r, body, "/v1/completions", modelConfig, store, svc, logger, requestID, application, req.Model)
+	}
+}
+
+// ResponsesHandler returns the OpenAI Responses API endpoint handler.
+func ResponsesHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Buffer request body with size limit
+		spooled, ok := spoolRequestBody(w, r, store, logger, maxBodySize)
+		if !ok {
+			return
+		}
+		defer spooled.Close()
+		body, r, ok := jsonRequestBody(w, r, spooled, logger)
+		if !ok {
+			return
+		}
+
+		var req models.ResponsesRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Error("failed to parse request body", "error", err)
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// Validate model alias
+		modelConfig, ok := resolveModelAlias(w, r, store, logger, &req.Model)
+		if !ok {
+			return
+		}
+
+		// Get context values
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+		// Delegate to shared proxy handler
+		handleProxyRequest(w, r, body, "/v1/responses", modelConfig, store, svc, logger, requestID, application, req.Model)
+	}
+}
+
+// ModerationsHandler returns the OpenAI moderations endpoint handler.
+func ModerationsHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Buffer request body with size limit
+		spooled, ok := spoolRequestBody(w, r, store, logger, maxBodySize)
+		if !ok {
+			return
+		}
+		defer spooled.Close()
+		body, r, ok := jsonRequestBody(w, r, spooled, logger)
+		if !ok {
+			return
+		}
+
+		var req models.ModerationRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Error("failed to parse request body", "error", err)
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// Validate model alias
+		modelConfig, ok := resolveModelAlias(w, r, store, logger, &req.Model)
+		if !ok {
+			return
+		}
+
+		// Get context values
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+		// Delegate to shared proxy handler
+		handleProxyRequest(w, r, body, "/v1/moderations", modelConfig, store, svc, logger, requestID, application, req.Model)
+	}
+}
+
+// ImageGenerationsHandler returns the OpenAI image generation endpoint handler.
+func ImageGenerationsHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Buffer request body with size limit
+		spooled, ok := spoolRequestBody(w, r, store, logger, maxBodySize)
+		if !ok {
+			return
+		}
+		defer spooled.Close()
+		body, r, ok := jsonRequestBody(w, r, spooled, logger)
+		if !ok {
+			return
+		}
+
+		var req models.ImageGenerationRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Error("failed to parse request body", "error", err)
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// Validate model alias
+		modelConfig, ok := resolveModelAlias(w, r, store, logger, &req.Model)
+		if !ok {
+			return
+		}
+
+		// Get context values
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+		// Delegate to shared proxy handler
+		handleProxyRequest(w, r, body, "/v1/images/generations", modelConfig, store, svc, logger, requestID, application, req.Model)
+	}
+}
+
+// AudioTranscriptionsHandler returns the OpenAI audio transcription endpoint handler.
+func AudioTranscriptionsHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger) http.HandlerFunc {
+	return multipartAudioHandler(store, svc, logger, "/v1/audio/transcriptions")
+}
+
+// AudioTranslationsHandler returns the OpenAI audio translation endpoint handler.
+func AudioTranslationsHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger) http.HandlerFunc {
+	return multipartAudioHandler(store, svc, logger, "/v1/audio/translations")
+}
+
+// multipartAudioHandler handles multipart/form-data audio uploads. The model alias
+// is read from the "model" form field and the original body, including the
+// uploaded file, is forwarded unchanged. Uploads over the configured spill
+// threshold are buffered in a temporary file and streamed from it.
+func multipartAudioHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger, targetPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Buffer request body with size limit
+		spooled, ok := spoolRequestBody(w, r, store, logger, maxAudioBodySize)
+		if !ok {
+			return
+		}
+		defer spooled.Close()
+
+		model, err := multipartFormValue(spooled.Open(), r.Header.Get("Content-Type"), "model")
+		if err != nil {
+			logger.Error("failed to parse multipart body", "error", err)
+			writeJSONError(w, "Invalid multipart/form-data body", http.StatusBadRequest)
+			return
+		}
+
+		// Validate model alias
+		modelConfig, ok := resolveModelAlias(w, r, store, logger, &model)
+		if !ok {
+			return
+		}
+
+		// Get context values
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+		// The JSON request stages have nothing to act on in a multipart body,
+		// so a spilled upload is left on disk and only sent from there
+		var body []byte
+		if spooled.Spilled() {
+			r = r.WithContext(context.WithValue(r.Context(), spooledBodyKey{}, spooled))
+		} else if body, err = spooled.Bytes(); err != nil {
+			logger.Error("failed to read request body", "error", err)
+			writeJSONError(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		// Delegate to shared proxy handler
+		handleProxyRequest(w, r, body, targetPath, modelConfig, store, svc, logger, requestID, application, model)
+	}
+}
+
+// AudioSpeechHandler returns the OpenAI text-to-speech endpoint handler. The
+// binary audio response is streamed to the client as it arrives.
+func AudioSpeechHandler(store *models.ConfigStore, svc *Services, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Buffer request body with size limit
+		spooled, ok := spoolRequestBody(w, r, store, logger, maxBodySize)
+		if !ok {
+			return
+		}
+		defer spooled.Close()
+		body, r, ok := jsonRequestBody(w, r, spooled, logger)
+		if !ok {
+			return
+		}
+
+		var req models.SpeechRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Error("failed to parse request body", "error", err)
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// Validate model alias
+		modelConfig, ok := resolveModelAlias(w, r, store, logger, &req.Model)
+		if !ok {
+			return
+		}
+
+		// Get context values
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+		// Delegate to shared proxy handler
+		handleProxyRequest(w, r, body, "/v1/audio/speech", modelConfig, store, svc, logger, requestID, application, req.Model)
+	}
+}
+
+// multipartFormValue returns the value of a non-file form field from a
+// multipart/form-data body.
+func multipartFormValue(body io.Reader, contentType, field string) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("invalid Content-Type: %w", err)
+	}
+	if mediaType != "multipart/form-data" {
+		return "", fmt.Errorf("expected multipart/form-data, got %s", mediaType)
+	}
+
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read multipart part: %w", err)
+		}
+		if part.FormName() == field && part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, 1024))
+			if err != nil {
+				return "", fmt.Errorf("failed to read field %s: %w", field, err)
+			}
+			return strings.TrimSpace(string(value)), nil
+		}
+	}
+}
+
+// CanaryProbe returns a probe that sends a tiny fixed chat request through an
+// alias directly to the gateway. Canary traffic is not recorded as usage.
+func CanaryProbe(store *models.ConfigStore) func(ctx context.Context, alias string) (int, error) {
+	return func(ctx context.Context, alias string) (int, error) {
+		modelConfig, ok := store.Model(alias)
+		if !ok {
+			return 0, fmt.Errorf("unknown model alias: %s", alias)
+		}
+		if banned := models.BannedModel(modelConfig, alias, store.BannedModels); banned != "" {
+			return 0, fmt.Errorf("alias %s resolves to banned model %s", alias, banned)
+		}
+
+		body, err := json.Marshal(models.ChatCompletionRequest{
+			Model:     alias,
+			Messages:  []models.Message{{Role: "user", Content: "Reply with OK."}},
+			MaxTokens: 5,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, store.GatewayURLFor(alias)+"/v1/chat/completions", bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := setPortkeyHeaders(req, buildPortkeyConfig(modelConfig), modelConfig); err != nil {
+			return 0, err
+		}
+		client, err := gatewayClientFor(store, modelConfig)
+		if err != nil {
+			return 0, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+
+		return resp.StatusCode, nil
+	}
+}
+
+// VerifyRequest returns a function that sends a test prompt through an alias
+// directly to the gateway, as "portus verify" does, and returns the response
+// status and the text of the first choice.
+func VerifyRequest(store *models.ConfigStore) func(ctx context.Context, alias, prompt string) (int, string, error) {
+	return func(ctx context.Context, alias, prompt string) (int, string, error) {
+		modelConfig, ok := store.Model(alias)
+		if !ok {
+			return 0, "", fmt.Errorf("unknown model alias: %s", alias)
+		}
+		if banned := models.BannedModel(modelConfig, alias, store.BannedModels); banned != "" {
+			return 0, "", fmt.Errorf("alias %s resolves to banned model %s", alias, banned)
+		}
+
+		body, err := json.Marshal(models.ChatCompletionRequest{
+			Model:    alias,
+			Messages: []models.Message{{Role: "user", Content: prompt}},
+		})
+		if err != nil {
+			return 0, "", err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, store.GatewayURLFor(alias)+"/v1/chat/completions", bytes.NewReader(body))
+		if err != nil {
+			return 0, "", err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := setPortkeyHeaders(req, buildPortkeyConfig(modelConfig), modelConfig); err != nil {
+			return 0, "", err
+		}
+		client, err := gatewayClientFor(store, modelConfig)
+		if err != nil {
+			return 0, "", err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+		if err != nil {
+			return resp.StatusCode, "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, string(respBody), nil
+		}
+
+		var completion struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal(respBody, &completion); err != nil {
+			return resp.StatusCode, "", fmt.Errorf("invalid completion: %w", err)
+		}
+		if len(completion.Choices) == 0 {
+			return resp.StatusCode, "", errors.New("completion has no choices")
+		}
+		return resp.StatusCode, completion.Choices[0].Message.Content, nil
+	}
+}
+
+// LoadRequest returns a function that sends a generated chat request directly
+// to the gateway, as "portus loadtest" does. It reads the whole response and
+// returns its status and the time until the first response byte.
+func LoadRequest(store *models.ConfigStore) func(ctx context.Context, chat models.ChatCompletionRequest) (int, time.Duration, error) {
+	return func(ctx context.Context, chat models.ChatCompletionRequest) (int, time.Duration, error) {
+		modelConfig, ok := store.Model(chat.Model)
+		if !ok {
+			return 0, 0, fmt.Errorf("unknown model alias: %s", chat.Model)
+		}
+		if banned := models.BannedModel(modelConfig, chat.Model, store.BannedModels); banned != "" {
+			return 0, 0, fmt.Errorf("alias %s resolves to banned model %s", chat.Model, banned)
+		}
+
+		body, err := json.Marshal(chat)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, store.GatewayURLFor(chat.Model)+"/v1/chat/completions", bytes.NewReader(body))
+		if err != nil {
+			return 0, 0, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := setPortkeyHeaders(req, buildPortkeyConfig(modelConfig), modelConfig); err != nil {
+			return 0, 0, err
+		}
+		client, err := gatewayClientFor(store, modelConfig)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer resp.Body.Close()
+		var first [1]byte
+		_, err = io.ReadFull(resp.Body, first[:])
+		firstByte := time.Since(start)
+		if err == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+		}
+		// An empty body is not a failure
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		return resp.StatusCode, firstByte, err
+	}
+}
+
+// ProviderModels returns a fetcher that lists the models reachable through an
+// alias by asking the gateway with the alias's provider credentials.
+func ProviderModels(store *models.ConfigStore) modellist.Fetcher {
+	return func(ctx context.Context, alias string) ([]modellist.Model, error) {
+		modelConfig, ok := store.Model(alias)
+		if !ok {
+			return nil, fmt.Errorf("unknown model alias: %s", alias)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, modelListTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, store.GatewayURLFor(alias)+"/v1/models", nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := setPortkeyHeaders(req, buildPortkeyConfig(modelConfig), modelConfig); err != nil {
+			return nil, err
+		}
+		client, err := gatewayClientFor(store, modelConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(io.Discard, resp.Body)
+			return nil, fmt.Errorf("gateway returned status %d", resp.StatusCode)
+		}
+
+		var list models.ModelsListResponse
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&list); err != nil {
+			return nil, fmt.Errorf("invalid model list: %w", err)
+		}
+
+		provider := getProviderFromConfig(modelConfig)
+		result := make([]modellist.Model, 0, len(list.Data))
+		for _, m := range list.Data {
+			if m.ID == "" {
+				continue
+			}
+			ownedBy := m.OwnedBy
+			if ownedBy == "" {
+				ownedBy = provider
+			}
+			result = append(result, modellist.Model{ID: m.ID, OwnedBy: ownedBy})
+		}
+		return result, nil
+	}
+}
+
+// spooledBodyKey carries a spilled request body to handleProxyRequest, which
+// streams it to the gateway: a multipart upload as is, and a JSON body as its
+// rewritten skeleton expanded from the file.
+type spooledBodyKey struct{}
+
+// spoolRequestBody buffers the request body up to limit bytes, spilling it to
+// a temporary file past store.BodySpillThreshold. On failure it writes the
+// error response and returns false; otherwise the caller must Close the body.
+func spoolRequestBody(w http.ResponseWriter, r *http.Request, store *models.ConfigStore, logger *slog.Logger, limit int64) (*spool.Body, bool) {
+	body, err := spool.Read(http.MaxBytesReader(w, r.Body, limit), store.BodySpillThreshold, store.BodySpillDir)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSONError(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		logger.Error("failed to read request body", "error", err)
+		writeJSONError(w, "Failed to read request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// jsonRequestBody returns the body the JSON request stages work on. A body
+// spilled to disk is reduced to its skeleton, leaving large base64 payloads in
+// the file, and the returned request carries it so handleProxyRequest sends
+// the rewritten skeleton with the payloads spliced back in. On failure it
+// writes the error response and returns false.
+func jsonRequestBody(w http.ResponseWriter, r *http.Request, spooled *spool.Body, logger *slog.Logger) ([]byte, *http.Request, bool) {
+	body, err := spooled.Skeleton()
+	if err != nil {
+		logger.Error("failed to read request body", "error", err)
+		writeJSONError(w, "Failed to read request body", http.StatusBadRequest)
+		return nil, r, false
+	}
+	if spooled.Spilled() {
+		r = r.WithContext(context.WithValue(r.Context(), spooledBodyKey{}, spooled))
+	}
+	return body, r, true
+}
+
+// resolveModelAlias looks up the configuration for a requested model alias.
+// Empty and unknown aliases are routed to the default alias when one is
+// configured, requests to an alias running an experiment to their assigned
+// variant, and requests picked for an alias's canary to the canary alias;
+// *alias is replaced with the alias actually used so the request is accounted
+// to it. Requests the resolved alias would send to a banned provider model
+// are refused. On failure it writes the error response and returns false.
+func resolveModelAlias(w http.ResponseWriter, r *http.Request, store *models.ConfigStore, logger *slog.Logger, alias *string) (models.ModelConfig, bool) {
+	modelConfig, ok := lookupModelAlias(w, r, store, logger, alias)
+	if !ok {
+		return modelConfig, false
+	}
+	// Validation rejects aliases that resolve to banned models, but names
+	// served through match patterns are only known per request
+	if banned := models.BannedModel(modelConfig, *alias, store.BannedModels); banned != "" {
+		logger.Error("request blocked, alias resolves to a banned model", "model_alias", *alias, "model", banned)
+		writeJSONError(w, "Model is prohibited by policy", http.StatusForbidden)
+		return models.ModelConfig{}, false
+	}
+	return modelConfig, true
+}
+
+// lookupModelAlias implements resolveModelAlias without the banned model check.
+func lookupModelAlias(w http.ResponseWriter, r *http.Request, store *models.ConfigStore, logger *slog.Logger, alias *string) (models.ModelConfig, bool) {
+	if *alias != "" {
+		if modelConfig, exists := store.Model(*alias); exists {
+			return routeAlias(w, r, store, logger, alias, modelConfig), true
+		}
+	}
+
+	if store.DefaultModel != "" {
+		if modelConfig, exists := store.Model(store.DefaultModel); exists {
+			logger.Warn("routing to default model alias", "alias", *alias, "default_alias", store.DefaultModel)
+			*alias = store.DefaultModel
+			return routeAlias(w, r, store, logger, alias, modelConfig), true
+		}
+	}
+
+	if *alias == "" {
+		writeJSONError(w, "Missing 'model' field in request", http.StatusBadRequest)
+		return models.ModelConfig{}, false
+	}
+	logger.Warn("unknown model alias", "alias", *alias)
+	writeJSONError(w, "Unknown model alias", http.StatusBadRequest)
+	return models.ModelConfig{}, false
+}
+
+// routeAlias applies the resolved alias's experiment and canary routing.
+func routeAlias(w http.ResponseWriter, r *http.Request, store *models.ConfigStore, logger *slog.Logger, alias *string, modelConfig models.ModelConfig) models.ModelConfig {
+	modelConfig = routeExperiment(w, r, store, logger, alias, modelConfig)
+	return routeCanary(store, logger, alias, modelConfig)
+}
+
+// routeExperiment sends the request to the variant of the alias's experiment
+// assigned to the caller and tags the response with it.
+func routeExperiment(w http.ResponseWriter, r *http.Request, store *models.ConfigStore, logger *slog.Logger, alias *string, modelConfig models.ModelConfig) models.ModelConfig {
+	e := modelConfig.Experiment
+	if e == nil {
+		return modelConfig
+	}
+	application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+	variant := experiment.Assign(e.Name, application, r.Header.Get(stickyKeyHeader))
+	variantAlias := e.A
+	if variant == experiment.VariantB {
+		variantAlias = e.B
+	}
+
+	variantConfig := modelConfig
+	if variantAlias != *alias {
+		var ok bool
+		if variantConfig, ok = store.Model(variantAlias); !ok {
+			logger.Warn("experiment variant alias not configured, using primary", "alias", *alias, "experiment", e.Name, "variant_alias", variantAlias)
+			return modelConfig
+		}
+	}
+	logger.Debug("assigned experiment variant", "alias", *alias, "experiment", e.Name, "variant", variant, "variant_alias", variantAlias)
+	w.Header().Set(experimentHeader, e.Name+"="+variant)
+	*alias = variantAlias
+	return variantConfig
+}
+
+// routeCanary sends the alias's configured share of requests to its canary
+// alias, replacing *alias with it.
+func routeCanary(store *models.ConfigStore, logger *slog.Logger, alias *string, modelConfig models.ModelConfig) models.ModelConfig {
+	c := modelConfig.Canary
+	if c == nil || c.Percent <= 0 || rand.Float64()*100 >= c.Percent {
+		return modelConfig
+	}
+	canaryConfig, ok := store.Model(c.Alias)
+	if !ok {
+		logger.Warn("canary alias not configured, using primary", "alias", *alias, "canary_alias", c.Alias)
+		return modelConfig
+	}
+	logger.Debug("routing to canary alias", "alias", *alias, "canary_alias", c.Alias)
+	*alias = c.Alias
+	return canaryConfig
+}
+
+// guardrailsCheckedKey marks a request whose guardrails were already
+// evaluated, before a semantic cache lookup, so handleProxyRequest does not
+// evaluate them again on a miss.
+type guardrailsCheckedKey struct{}
+
+// checkGuardrails evaluates the alias's and the application's guardrails
+// against the request body. On a violation it writes a 400 policy error,
+// publishes a guardrail.blocked event and returns false.
+func checkGuardrails(w http.ResponseWriter, body []byte, modelConfig models.ModelConfig, store *models.ConfigStore, hub *events.Hub, logger *slog.Logger, requestID, application, modelAlias string) bool {
+	var applicationRules *models.GuardrailConfig
+	if rules, ok := store.Guardrails[application]; ok {
+		applicationRules = &rules
+	}
+
+	for _, scope := range []struct {
+		name  string
+		rules *models.GuardrailConfig
+	}{
+		{"model", modelConfig.Guardrails},
+		{"application", applicationRules},
+	} {
+		if scope.rules == nil {
+			continue
+		}
+		violation := guardrail.Check(*scope.rules, body)
+		if violation == nil {
+			continue
+		}
+		logger.Warn("request blocked by guardrail",
+			"request_id", requestID,
+			"application", application,
+			"model_alias", modelAlias,
+			"scope", scope.name,
+			"rule", violation.Rule,
+			"detail", violation.Detail,
+		)
+		// The detail can quote request content, so only the rule is published
+		hub.Publish(events.GuardrailBlocked, "", map[string]any{
+			"application": application,
+			"model_alias": modelAlias,
+			"request_id":  requestID,
+			"scope":       scope.name,
+			"rule":        violation.Rule,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.PolicyViolationError{
+			Error: "Request violates a guardrail policy",
+			Type:  "policy_violation",
+			Rule:  violation.Rule,
+			Scope: scope.name,
+		})
+		return false
+	}
+	return true
+}
+
+// signProvenance adds the signed provenance header when a key is configured.
+func signProvenance(w http.ResponseWriter, svc *Services, requestID, modelAlias string) {
+	if svc.Provenance != nil {
+		w.Header().Set(provenance.Header, svc.Provenance.Sign(requestID, modelAlias, time.Now()))
+	}
+}
+
+// handleProxyRequest executes the shared proxy logic for all model endpoints.
+func handleProxyRequest(w http.ResponseWriter, r *http.Request, body []byte, targetPath string, modelConfig models.ModelConfig, store *models.ConfigStore, svc *Services, logger *slog.Logger, requestID, application, modelAlias string) {
+	// Count the request for the shutdown report once its status is known
+	status := &statusRecorder{ResponseWriter: w}
+	w = status
+	began := time.Now()
+	proxyKey, _ := r.Context().Value(middleware.ContextKeyProxyKey).(models.ProxyKey)
+	tags := models.RequestTags(modelConfig, proxyKey)
+	defer func() {
+		svc.Report.RecordRequest(modelAlias, status.code)
+		duration := time.Since(began)
+		recordStatsDRequest(svc, application, modelAlias, tags, status.code, duration)
+		svc.Latency.Record(svc.Privacy.Label(application), modelAlias, tags, duration, r.Header.Get("traceparent"))
+	}()
+	signProvenance(w, svc, requestID, modelAlias)
+
+	if checked, _ := r.Context().Value(guardrailsCheckedKey{}).(bool); !checked &&
+		!checkGuardrails(w, body, modelConfig, store, svc.Events, logger, requestID, application, modelAlias) {
+		return
+	}
+
+	// Aliases with byok send the client's own provider key, so its owner is
+	// billed; it must not silently go to an alias billed to Portus's key
+	clientKey := r.Header.Get(models.ProviderKeyHeader)
+	switch {
+	case clientKey != "" && modelConfig.BYOK == "":
+		writeJSONError(w, "This model does not accept a provider key in the "+models.ProviderKeyHeader+" header", http.StatusBadRequest)
+		return
+	case clientKey == "" && modelConfig.BYOK == models.BYOKRequired:
+		writeJSONError(w, "This model requires your own provider key in the "+models.ProviderKeyHeader+" header", http.StatusUnauthorized)
+		return
+	}
+
+	// Operators may change retries and routing for this request only
+	if operator, ok := r.Context().Value(middleware.ContextKeyOperator).(models.ProxyKey); ok {
+		o, err := override.Parse(r.Header)
+		if err == nil {
+			modelConfig, err = applyOverride(modelConfig, o)
+		}
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !o.IsZero() {
+			logger.Warn("operator override applied",
+				"request_id", requestID,
+				"application", application,
+				"model_alias", modelAlias,
+				"operator", operator.Application,
+				"override", o.String(),
+			)
+			svc.Events.Publish(events.OverrideApplied, operator.Application, map[string]any{
+				"request_id":  requestID,
+				"application": application,
+				"model_alias": modelAlias,
+				"override":    o.String(),
+			})
+		}
+	}
+
+	deadline, hasDeadline, err := requestDeadline(r.Header)
+	if err != nil {
+		writeJSONError(w, "Invalid "+deadlineHeader+" header", http.StatusBadRequest)
+		return
+	}
+	if hasDeadline && !time.Now().Before(deadline) {
+		recordTermination(svc, application, modelAlias, tags, usage.TerminationTimeout)
+		writeJSONError(w, "Request deadline has passed", http.StatusGatewayTimeout)
+		return
+	}
+
+	// Cap the completion tokens the request may ask for
+	if proxyKey.MaxTokens > 0 {
+		limited, requested := tokenlimit.Apply(body, targetPath, proxyKey.MaxTokens, capability.IsReasoningModel(getModelFromConfig(modelConfig)))
+		if requested > proxyKey.MaxTokens {
+			if store.MaxTokensAction == tokenlimit.ActionReject {
+				logger.Warn("max tokens limit exceeded",
+					"request_id", requestID,
+					"application", application,
+					"model_alias", modelAlias,
+					"requested", requested,
+					"max_tokens", proxyKey.MaxTokens,
+				)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(models.MaxTokensError{
+					Error:     "Requested max tokens exceed this key's limit",
+					Requested: requested,
+					MaxTokens: proxyKey.MaxTokens,
+				})
+				return
+			}
+			logger.Info("clamped max tokens to key limit",
+				"request_id", requestID,
+				"application", application,
+				"model_alias", modelAlias,
+				"requested", requested,
+				"max_tokens", proxyKey.MaxTokens,
+			)
+		}
+		body = limited
+	}
+
+	// Stop conversations that have grown past the key's token cap
+	conversationID := r.Header.Get(conversation.Header)
+	if proxyKey.ConversationTokens > 0 && store.ConversationTokensAction != conversation.ActionWarn {
+		if used := svc.Conversations.Tokens(application, conversationID); used >= proxyKey.ConversationTokens {
+			logger.Warn("conversation token limit reached",
+				"request_id", requestID,
+				"application", application,
+				"model_alias", modelAlias,
+				"conversation_id", conversationID,
+				"tokens", used,
+				"max_tokens", proxyKey.ConversationTokens,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ConversationLimitError{
+				Error:          "Conversation has used its token limit",
+				Type:           "conversation_token_limit",
+				ConversationID: conversationID,
+				Tokens:         used,
+				MaxTokens:      proxyKey.ConversationTokens,
+			})
+			return
+		}
+	}
+
+	// Refuse requests once the application's spend budget is exhausted
+	if exceeded := svc.Budgets.Check(application, store.Budgets[application], time.Now()); exceeded != nil {
+		logger.Warn("spend budget exhausted",
+			"request_id", requestID,
+			"application", application,
+			"model_alias", modelAlias,
+			"window", exceeded.Window,
+			"limit_usd", exceeded.Limit,
+			"spent_usd", exceeded.Spent,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(exceeded.ResetsAt).Seconds())+1))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(models.BudgetExceededError{
+			Error:    "Spend budget exceeded for this application",
+			Type:     "budget_exceeded",
+			Window:   exceeded.Window,
+			LimitUSD: exceeded.Limit,
+			SpentUSD: exceeded.Spent,
+			ResetsAt: exceeded.ResetsAt,
+		})
+		return
+	}
+
+	// Count the request against the application's quota
+	quotaTaken := time.Now()
+	if used, ok := svc.Quotas.Take(application, proxyKey.RequestQuota, quotaTaken); !ok {
+		logger.Warn("request quota exhausted",
+			"request_id", requestID,
+			"application", application,
+			"model_alias", modelAlias,
+			"quota", proxyKey.RequestQuota.String(),
+			"resets_at", used.ResetsAt,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(used.ResetsAt).Seconds())+1))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(models.QuotaExceededError{
+			Error:    "Request quota exceeded for this application",
+			Type:     "quota_exceeded",
+			Limit:    used.Limit,
+			Window:   used.Window,
+			ResetsAt: used.ResetsAt,
+		})
+		return
+	}
+	// Requests Portus turns away below, before contacting the gateway, give
+	// their quota back
+	admitted := false
+	defer func() {
+		if !admitted {
+			svc.Quotas.Refund(application, proxyKey.RequestQuota, quotaTaken)
+		}
+	}()
+
+	// During a grace period limits are logged but not enforced
+	grace := time.Now().Before(store.LimitsGraceUntil)
+
+	// Enforce the key's concurrent stream cap before contacting the gateway.
+	// Burst headroom above the cap is admitted but logged.
+	if isStreamingRequest(body) {
+		limit := proxyKey.MaxStreams
+		if limit > 0 {
+			limit += store.StreamBurst
+		}
+		release, active, ok := svc.Streams.Acquire(application, limit)
+		if !ok && grace {
+			logger.Warn("concurrent stream limit exceeded during grace period",
+				"request_id", requestID,
+				"application", application,
+				"active_streams", active,
+				"max_streams", limit,
+				"grace_until", store.LimitsGraceUntil,
+			)
+			release, active, ok = svc.Streams.Acquire(application, 0)
+		}
+		if !ok {
+			logger.Warn("concurrent stream limit reached",
+				"request_id", requestID,
+				"application", application,
+				"active_streams", active,
+				"max_streams", limit,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(models.StreamLimitError{
+				Error:         "Too many concurrent streams for this key",
+				ActiveStreams: active,
+				MaxStreams:    limit,
+			})
+			return
+		}
+		defer release()
+		if proxyKey.MaxStreams > 0 && active > proxyKey.MaxStreams && active <= limit {
+			logger.Info("stream burst allowance in use",
+				"request_id", requestID,
+				"application", application,
+				"active_streams", active,
+				"max_streams", proxyKey.MaxStreams,
+				"burst", store.StreamBurst,
+			)
+		}
+	}
+
+	// Hold one of the alias's concurrency slots, queueing for a bounded time.
+	// Burst headroom above the cap is admitted but logged.
+	if modelConfig.MaxConcurrent > 0 {
+		limit := modelConfig.MaxConcurrent + modelConfig.MaxConcurrentBurst
+		queue := modelConfig.MaxQueue
+		if grace {
+			// Nothing waits while limits only log
+			queue = 0
+		}
+		queueTimeout := defaultQueueTimeout
+		if modelConfig.QueueTimeout > 0 {
+			queueTimeout = time.Duration(modelConfig.QueueTimeout) * time.Millisecond
+		}
+		queueCtx, cancelQueue := context.WithTimeout(r.Context(), queueTimeout)
+		release, err := svc.Concurrency.Acquire(queueCtx, modelAlias, limit, queue)
+		cancelQueue()
+		exceeded := err != nil
+		if exceeded && grace && r.Context().Err() == nil {
+			logger.Warn("model concurrency limit exceeded during grace period",
+				"request_id", requestID,
+				"application", application,
+				"model_alias", modelAlias,
+				"max_concurrent", limit,
+				"grace_until", store.LimitsGraceUntil,
+			)
+			release, err = func() {}, nil
+		}
+		if err != nil {
+			if r.Context().Err() != nil {
+				status.code = statusClientClosedRequest
+				recordTermination(svc, application, modelAlias, tags, usage.TerminationClientCancelled)
+				return
+			}
+			logger.Warn("model concurrency limit reached",
+				"request_id", requestID,
+				"application", application,
+				"model_alias", modelAlias,
+				"max_concurrent", limit,
+				"max_queue", modelConfig.MaxQueue,
+				"queue_full", errors.Is(err, concurrency.ErrQueueFull),
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(models.ConcurrencyLimitError{
+				Error:         "Too many concurrent requests for this model",
+				MaxConcurrent: limit,
+				MaxQueue:      modelConfig.MaxQueue,
+			})
+			return
+		}
+		defer release()
+		if active, _ := svc.Concurrency.Stats(modelAlias); !exceeded && active > modelConfig.MaxConcurrent {
+			logger.Info("concurrency burst allowance in use",
+				"request_id", requestID,
+				"application", application,
+				"model_alias", modelAlias,
+				"active", active,
+				"max_concurrent", modelConfig.MaxConcurrent,
+				"burst", modelConfig.MaxConcurrentBurst,
+			)
+		}
+	}
+
+	// Fail fast while the alias's upstream rejects its credentials, until
+	// the config changes or a probe gets through. A client's own key is
+	// not the alias's to judge.
+	if clientKey == "" && !svc.Credentials.Allow(modelAlias, modelConfig) {
+		logger.Warn("alias degraded by credential failures, rejecting request",
+			"request_id", requestID,
+			"application", application,
+			"model_alias", modelAlias,
+		)
+		if writeFallback(w, body, targetPath, modelConfig, store, svc, logger, requestID, application, modelAlias, http.StatusServiceUnavailable) {
+			return
+		}
+		writeJSONError(w, "Upstream is rejecting the credentials configured for this model", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Fail fast while the alias's upstream is known to be failing
+	ticket, retryAfter, ok := svc.Breaker.Allow(modelAlias)
+	if !ok {
+		logger.Warn("circuit open, rejecting request",
+			"request_id", requestID,
+			"application", application,
+			"model_alias", modelAlias,
+			"retry_after_ms", retryAfter.Milliseconds(),
+		)
+		if writeFallback(w, body, targetPath, modelConfig, store, svc, logger, requestID, application, modelAlias, http.StatusServiceUnavailable) {
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeJSONError(w, "Upstream for this model is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	admitted = true
+
+	// Build Portkey configuration
+	if clientKey != "" {
+		modelConfig = withProviderKey(modelConfig, clientKey)
+	}
+	portkeyConfig := buildPortkeyConfig(modelConfig)
+
+	// Create proxy request to Portkey Gateway with per-request timeout, held
+	// under the alias and server-wide ceilings
+	timeout, requested, source := requestTimeout(r.Header, modelConfig, store.MaxRequestTimeout)
+	if timeout < requested {
+		logger.Warn("request timeout clamped to ceiling",
+			"request_id", requestID,
+			"application", application,
+			"model_alias", modelAlias,
+			"source", source,
+			"requested_ms", requested.Milliseconds(),
+			"timeout_ms", timeout.Milliseconds(),
+		)
+	}
+	if hasDeadline {
+		// Time spent queueing counts against the client's deadline
+		timeout = min(timeout, time.Until(deadline))
+		if timeout <= 0 {
+			ticket.Abandon()
+			recordTermination(svc, application, modelAlias, tags, usage.TerminationTimeout)
+			writeJSONError(w, "Request deadline has passed", http.StatusGatewayTimeout)
+			return
+		}
+	}
+	clampRequestTimeout(portkeyConfig, timeout)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	// Ask for end-of-stream usage so streams are accounted; the extra chunk is
+	// hidden again from clients that did not request it
+	usageInjected := false
+	if injectsStreamUsage(targetPath, modelConfig) {
+		body, usageInjected = streamusage.Inject(body)
+	}
+
+	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, store.GatewayURLFor(modelAlias)+targetPath, bytes.NewReader(body))
+	if err != nil {
+		ticket.Abandon()
+		logger.Error("failed to create proxy request", "error", err)
+		writeJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if spooled, ok := r.Context().Value(spooledBodyKey{}).(*spool.Body); ok {
+		open := func() (io.ReadCloser, int64) { return spooled.Open(), spooled.Len() }
+		// Multipart uploads come without a body and are sent as is
+		if body != nil {
+			open = func() (io.ReadCloser, int64) { return spooled.Expand(body) }
+		}
+		proxyReq.Body, proxyReq.ContentLength = open()
+		proxyReq.GetBody = func() (io.ReadCloser, error) {
+			rc, _ := open()
+			return rc, nil
+		}
+	}
+
+	// Copy headers from original request, skipping hop-by-hop headers
+	copyHeaders(r.Header, proxyReq.Header)
+	// Let the transport negotiate compression so responses arrive decoded for
+	// usage extraction and rewriting; clients are served by GzipMiddleware
+	proxyReq.Header.Del("Accept-Encoding")
+	setPortkeyMetadata(proxyReq.Header, tags)
+	proxyReq.Header.Del(deadlineHeader)
+	if proxyReq.Header.Get(requestTimeoutHeader) != "" || hasDeadline {
+		proxyReq.Header.Set(requestTimeoutHeader, strconv.FormatInt(timeout.Milliseconds(), 10))
+	}
+
+	// NDJSON is produced locally from the upstream event stream
+	ndjson := wantsNDJSON(r.Header)
+	if ndjson {
+		proxyReq.Header.Set("Accept", "text/event-stream")
+	}
+
+	// Set Portkey-specific headers
+	if err := setPortkeyHeaders(proxyReq, portkeyConfig, modelConfig); err != nil {
+		ticket.Abandon()
+		logger.Error("failed to set Portkey headers", "error", err)
+		writeJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	client, err := gatewayClientFor(store, modelConfig)
+	if err != nil {
+		ticket.Abandon()
+		logger.Error("failed to configure upstream TLS", "request_id", requestID, "model_alias", modelAlias, "error", err)
+		writeJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Execute proxy request, retrying at the edge before anything reaches the client
+	start := time.Now()
+	retries, backoff := proxyRetries(modelConfig, store)
+	var resp *http.Response
+	var attempts int
+	if hedges(modelConfig, body) {
+		resp, attempts, err = doHedged(client, proxyReq, modelConfig, timeout, logger, requestID, modelAlias)
+	} else {
+		resp, attempts, err = doWithRetries(client, proxyReq, retries, backoff, logger, requestID, modelAlias)
+	}
+	if err != nil {
+		reason := terminationReason(r, ctx, err)
+		recordTermination(svc, application, modelAlias, tags, reason)
+		if reason == usage.TerminationClientCancelled {
+			// The gateway request was cancelled with the client's
+			ticket.Abandon()
+			status.code = statusClientClosedRequest
+			logger.Info("request cancelled by client before the gateway responded",
+				"request_id", requestID,
+				"application", application,
+				"model_alias", modelAlias,
+				"waited_ms", time.Since(start).Milliseconds(),
+			)
+			return
+		}
+		ticket.Failure()
+		code, message := http.StatusBadGateway, "Failed to reach gateway"
+		if reason == usage.TerminationTimeout {
+			code, message = http.StatusGatewayTimeout, "Gateway request timed out"
+		}
+		logger.Error("failed to proxy request to gateway", "request_id", requestID, "model_alias", modelAlias, "reason", reason, "error", err)
+		if writeFallback(w, body, targetPath, modelConfig, store, svc, logger, requestID, application, modelAlias, code) {
+			return
+		}
+		writeJSONError(w, message, code)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		ticket.Failure()
+	} else {
+		ticket.Success()
+	}
+	if clientKey == "" {
+		svc.Credentials.Record(modelAlias, modelConfig, resp.StatusCode)
+	}
+
+	// Every routing option has failed; prefer a graceful static reply if configured
+	if resp.StatusCode >= http.StatusInternalServerError &&
+		writeFallback(w, body, targetPath, modelConfig, store, svc, logger, requestID, application, modelAlias, resp.StatusCode) {
+		return
+	}
+
+	// Copy response headers, keeping our own provenance
+	for key, values := range resp.Header {
+		if key == provenance.Header && svc.Provenance != nil {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	normalize := store.NormalizeFinishReasons && hasFinishReasons(targetPath)
+	if normalize {
+		// The rewritten body differs in length from the upstream one
+		w.Header().Del("Content-Length")
+	}
+
+	postProcess := modelConfig.PostProcess != nil && resp.StatusCode < http.StatusMultipleChoices &&
+		hasGeneratedText(targetPath) && isJSON(resp.Header)
+	if postProcess {
+		w.Header().Del("Content-Length")
+	}
+
+	validate := store.MessageStreamValidation != "" && targetPath == "/v1/messages" && isEventStream(resp.Header)
+	if validate {
+		// Repairs change the stream's length
+		w.Header().Del("Content-Length")
+	}
+
+	ndjson = ndjson && isEventStream(resp.Header)
+	if ndjson {
+		w.Header().Set("Content-Type", ndjsonContentType)
+		w.Header().Del("Content-Length")
+	}
+
+	// Optionally report the routing decision inside the response itself
+	var annotation *annotate.Annotation
+	if store.AnnotateResponses {
+		annotation = routingAnnotation(modelConfig, modelAlias, resp.Header, attempts)
+		if isJSON(resp.Header) {
+			w.Header().Del("Content-Length")
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	provider := getProviderFromConfig(modelConfig)
+	resolvedModel := getModelFromConfig(modelConfig)
+
+	// Optionally hash the body relayed to the client, before GzipMiddleware
+	// compresses it
+	var hasher hash.Hash
+	var digest io.Writer = io.Discard
+	if store.LogResponseHash {
+		hasher = sha256.New()
+		digest = hasher
+	}
+
+	// Finish reasons are normalized before anything observes the body
+	var respBody io.Reader = resp.Body
+
+	// Relay the response body while observing it for token usage
+	var tokens usage.Usage
+	var relayErr error
+	var validator *messagestream.Reader
+	if isEventStream(resp.Header) {
+		// Anthropic event sequences are checked before any other rewriting
+		if validate {
+			validator = messagestream.NewReader(respBody, store.MessageStreamValidation)
+			respBody = validator
+		}
+		if normalize {
+			respBody = finishreason.NewStreamReader(respBody)
+		}
+		parser := &usage.StreamParser{}
+		var usageObserver io.Writer = parser
+		if usageInjected {
+			// Read usage from the injected chunk before it is stripped
+			respBody = streamusage.NewStripReader(io.TeeReader(respBody, parser))
+			usageObserver = io.Discard
+		}
+		// The reaper force-closes streams that outlive the maximum age, even
+		// one blocked writing to a client that stopped reading
+		closer := func() {
+			cancel()
+			http.NewResponseController(w).SetWriteDeadline(time.Now())
+		}
+		stream := svc.Progress.Track(requestID, svc.Privacy.Label(application), modelAlias, provider, closer)
+		streamDone := svc.Report.StreamStarted()
+		if ndjson {
+			nw := newNDJSONWriter(w, digest)
+			relayErr = relayBody(nw, respBody, io.MultiWriter(usageObserver, stream), logger)
+			nw.Close()
+			if annotation != nil {
+				writeTrailer(w, annotate.Line(*annotation), digest)
+			}
+		} else {
+			relayErr = relayBody(w, respBody, io.MultiWriter(usageObserver, stream, digest), logger)
+			if annotation != nil {
+				writeTrailer(w, annotate.Comment(*annotation), digest)
+			}
+		}
+		stream.Close()
+		streamDone()
+		tokens = parser.Usage()
+	} else if isJSON(resp.Header) {
+		if normalize {
+			respBody = normalizeJSONBody(resp.Body)
+		}
+		if postProcess {
+			respBody = postProcessJSONBody(respBody, *modelConfig.PostProcess)
+		}
+		if annotation != nil {
+			respBody = annotateJSONBody(respBody, *annotation)
+		}
+		capture := &cappedBuffer{limit: responseCaptureLimit(targetPath)}
+		relayErr = relayBody(w, respBody, io.MultiWriter(capture, digest), logger)
+		if !capture.truncated {
+			tokens, _ = usage.ParseResponse(capture.buf.Bytes())
+		}
+	} else {
+		// Binary responses such as synthesized audio carry no usage
+		relayErr = relayBody(w, resp.Body, digest, logger)
+	}
+
+	duration := time.Since(start)
+	var terminated string
+	if relayErr != nil {
+		terminated = terminationReason(r, ctx, relayErr)
+		recordTermination(svc, application, modelAlias, tags, terminated)
+	}
+
+	if validator != nil && len(validator.Violations()) > 0 {
+		logger.Warn("invalid Anthropic event sequence from upstream",
+			"request_id", requestID,
+			"model_alias", modelAlias,
+			"provider", provider,
+			"violations", validator.Violations(),
+			"repaired", store.MessageStreamValidation == messagestream.ModeRepair,
+			"terminated", validator.Terminated(),
+		)
+	}
+
+	// Estimate cost from configured pricing and record usage
+	var estimatedCost float64
+	if pricing, ok := cost.Lookup(store, modelAlias, modelConfig, resolvedModel); ok {
+		estimatedCost = cost.Estimate(pricing, tokens)
+	}
+	metricsApp := svc.Privacy.Label(application)
+	svc.Usage.Record(metricsApp, modelAlias, tokens, estimatedCost)
+	svc.History.Record(metricsApp, modelAlias, tokens, estimatedCost, start)
+	if svc.StatsD != nil {
+		statsDTags := append([]string{"application:" + metricsApp, "model_alias:" + modelAlias}, tagPairs(tags, ":")...)
+		svc.StatsD.Count("tokens", int64(tokens.PromptTokens), append(statsDTags, "token_type:prompt")...)
+		svc.StatsD.Count("tokens", int64(tokens.CompletionTokens), append(statsDTags, "token_type:completion")...)
+	}
+	svc.Budgets.Add(application, store.Budgets[application], estimatedCost, time.Now())
+	if proxyKey.ConversationTokens > 0 {
+		total := svc.Conversations.Add(application, conversationID, tokens.TotalTokens())
+		if total >= proxyKey.ConversationTokens && total-tokens.TotalTokens() < proxyKey.ConversationTokens {
+			logger.Warn("conversation exceeded its token limit",
+				"request_id", requestID,
+				"application", application,
+				"model_alias", modelAlias,
+				"conversation_id", conversationID,
+				"tokens", total,
+				"max_tokens", proxyKey.ConversationTokens,
+				"action", store.ConversationTokensAction,
+			)
+		}
+	}
+
+	// Log the request
+	logAttrs := []any{
+		"request_id", requestID,
+		"application", application,
+		"endpoint", targetPath,
+		"model_alias", modelAlias,
+		"provider", provider,
+		"resolved_model", resolvedModel,
+		"status", resp.StatusCode,
+		"duration_ms", duration.Milliseconds(),
+		"prompt_tokens", tokens.PromptTokens,
+		"completion_tokens", tokens.CompletionTokens,
+		"total_tokens", tokens.TotalTokens(),
+		"estimated_cost_usd", estimatedCost,
+	}
+	if tag := w.Header().Get(experimentHeader); strings.Contains(tag, "=") {
+		i := strings.LastIndexByte(tag, '=')
+		logAttrs = append(logAttrs, "experiment", tag[:i], "variant", tag[i+1:])
+	}
+	if hasher != nil {
+		logAttrs = append(logAttrs, "response_sha256", hex.EncodeToString(hasher.Sum(nil)))
+	}
+	if len(tags) > 0 {
+		logAttrs = append(logAttrs, "tags", tags)
+	}
+	if terminated != "" {
+		logAttrs = append(logAttrs, "terminated", terminated)
+	}
+	if clientKey != "" {
+		logAttrs = append(logAttrs, "client_provider_key", true)
+	}
+	logger.Info("proxy request completed", logAttrs...)
+
+	record := usagestore.Record{
+		Timestamp:        start,
+		RequestID:        requestID,
+		Application:      metricsApp,
+		ModelAlias:       modelAlias,
+		Provider:         provider,
+		ResolvedModel:    resolvedModel,
+		Endpoint:         targetPath,
+		Status:           resp.StatusCode,
+		Duration:         duration,
+		PromptTokens:     tokens.PromptTokens,
+		CompletionTokens: tokens.CompletionTokens,
+		EstimatedCostUSD: estimatedCost,
+		Tags:             tags,
+	}
+	if svc.Privacy.Aggregate() {
+		record.RequestID = ""
+	}
+	svc.Records.Record(record)
+}
+
+// recordStatsDRequest sends a request's count and latency, tagged with its
+// application, alias, status and configured tags, to the StatsD sink.
+func recordStatsDRequest(svc *Services, application, modelAlias string, tags map[string]string, status int, duration time.Duration) {
+	if svc.StatsD == nil {
+		return
+	}
+	statsDTags := []string{"application:" + svc.Privacy.Label(application), "model_alias:" + modelAlias, "status:" + strconv.Itoa(status)}
+	statsDTags = append(statsDTags, tagPairs(tags, ":")...)
+	svc.StatsD.Count("requests", 1, statsDTags...)
+	svc.StatsD.Timing("request.duration", duration, statsDTags...)
+}
+
+// requestDeadline parses the client's deadline header, reporting whether one
+// was sent.
+func requestDeadline(header http.Header) (time.Time, bool, error) {
+	value := header.Get(deadlineHeader)
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	deadline, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return deadline, true, nil
+}
+
+// terminationReason classifies why a gateway request ended early: the client
+// went away, the request's timeout, deadline or maximum stream age passed, or
+// the upstream failed. ctx is the gateway request's context.
+func terminationReason(r *http.Request, ctx context.Context, err error) string {
+	switch {
+	case r.Context().Err() != nil || errors.Is(err, errClientGone):
+		return usage.TerminationClientCancelled
+	case ctx.Err() != nil:
+		return usage.TerminationTimeout
+	}
+	return usage.TerminationUpstreamError
+}
 
-		response := models.ModelsListResponse{
-			Object: "list",
-			Data:   data,
-		}
+// recordTermination counts a request that ended early in the usage totals
+// and, tagged with its reason, in StatsD.
+func recordTermination(svc *Services, application, modelAlias string, tags map[string]string, reason string) {
+	svc.Usage.RecordTermination(svc.Privacy.Label(application), modelAlias, reason)
+	if svc.StatsD != nil {
+		statsDTags := []string{"application:" + svc.Privacy.Label(application), "model_alias:" + modelAlias, "reason:" + reason}
+		svc.StatsD.Count("terminations", 1, append(statsDTags, tagPairs(tags, ":")...)...)
+	}
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
+// requestTags returns the tags of a request's alias and key.
+func requestTags(r *http.Request, modelConfig models.ModelConfig) map[string]string {
+	proxyKey, _ := r.Context().Value(middleware.ContextKeyProxyKey).(models.ProxyKey)
+	return models.RequestTags(modelConfig, proxyKey)
+}
+
+// tagPairs formats tags as name<sep>value pairs sorted by name.
+func tagPairs(tags map[string]string, sep string) []string {
+	pairs := make([]string, 0, len(tags))
+	for _, name := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, name+sep+tags[name])
 	}
+	return pairs
 }
 
-// ChatCompletionsHandler returns the chat completions endpoint handler.
-func ChatCompletionsHandler(store *models.ConfigStore, logger *slog.Logger) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+// setPortkeyMetadata merges tags into the request's x-portkey-metadata, so
+// they reach Portkey's logs. Tags replace client metadata of the same name;
+// metadata that is not a JSON object is replaced.
+func setPortkeyMetadata(h http.Header, tags map[string]string) {
+	if len(tags) == 0 {
+		return
+	}
+	metadata := make(map[string]any)
+	if existing := h.Get(portkeyMetadataHeader); existing != "" {
+		json.Unmarshal([]byte(existing), &metadata)
+		if metadata == nil {
+			metadata = make(map[string]any)
 		}
+	}
+	for name, value := range tags {
+		metadata[name] = value
+	}
+	// Maps of strings and decoded JSON always marshal
+	encoded, _ := json.Marshal(metadata)
+	h.Set(portkeyMetadataHeader, string(encoded))
+}
 
-		// Parse request body with size limit
-		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
-		if err != nil {
-			var maxBytesErr *http.MaxBytesError
-			if errors.As(err, &maxBytesErr) {
-				writeJSONError(w, "Request body too large", http.StatusRequestEntityTooLarge)
-				return
+// proxyRetries returns how many times a failed gateway attempt is retried by
+// Portus and the initial backoff. The alias's proxy_retries overrides the
+// server-wide setting.
+func proxyRetries(model models.ModelConfig, store *models.ConfigStore) (int, time.Duration) {
+	retries := store.ProxyRetries
+	if model.ProxyRetries != nil {
+		retries = *model.ProxyRetries
+	}
+	return retries, store.ProxyRetryBackoff
+}
+
+// doWithRetries sends req to the gateway with client, retrying connection
+// failures and 5xx responses up to retries times with exponential backoff.
+// Nothing has been written to the client yet, so retries are invisible to
+// it. The last response or error is returned once retries are exhausted or
+// the request's context ends, along with the number of attempts made.
+func doWithRetries(client *http.Client, req *http.Request, retries int, backoff time.Duration, logger *slog.Logger, requestID, modelAlias string) (*http.Response, int, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(ctx)
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, attempt, err
 			}
-			logger.Error("failed to read request body", "error", err)
-			writeJSONError(w, "Failed to read request body", http.StatusBadRequest)
-			return
+			attemptReq.Body = body
 		}
 
-		var req models.ChatCompletionRequest
-		if err := json.Unmarshal(body, &req); err != nil {
-			logger.Error("failed to parse request body", "error", err)
-			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
-			return
+		resp, err := client.Do(attemptReq)
+		if attempt >= retries || ctx.Err() != nil || (err == nil && resp.StatusCode < http.StatusInternalServerError) {
+			return resp, attempt + 1, err
 		}
 
-		// Validate model alias
-		if req.Model == "" {
-			writeJSONError(w, "Missing 'model' field in request", http.StatusBadRequest)
-			return
+		attrs := []any{"request_id", requestID, "model_alias", modelAlias, "attempt", attempt + 1}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		} else {
+			attrs = append(attrs, "status", resp.StatusCode)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
 		}
+		delay := backoff << attempt
+		logger.Warn("retrying gateway request", append(attrs, "backoff_ms", delay.Milliseconds())...)
 
-		modelConfig, exists := store.Models[req.Model]
-		if !exists {
-			logger.Warn("unknown model alias", "alias", req.Model)
-			writeJSONError(w, "Unknown model alias", http.StatusBadRequest)
-			return
+		select {
+		case <-ctx.Done():
+			return nil, attempt + 1, ctx.Err()
+		case <-time.After(delay):
 		}
+	}
+}
 
-		// Get context values
-		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
-		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+// hedges reports whether a request races the alias's fallback targets itself
+// rather than leaving fallback to the gateway.
+func hedges(model models.ModelConfig, body []byte) bool {
+	return model.HedgeAfter > 0 && model.Strategy != nil && model.Strategy.Mode == "fallback" &&
+		len(model.Targets) > 1 && isStreamingRequest(body)
+}
 
-		// Delegate to shared proxy handler
-		handleProxyRequest(w, r, body, "/v1/chat/completions", modelConfig, store, logger, requestID, application, req.Model)
+// doHedged sends req to each of the alias's fallback targets in turn, starting
+// the next when no data has arrived within the alias's hedge_after, and
+// returns the first response to deliver data. The serving target is reported
+// in the gateway's target index header. Each attempt's request_timeout is
+// held to timeout, as for unhedged requests.
+func doHedged(client *http.Client, req *http.Request, model models.ModelConfig, timeout time.Duration, logger *slog.Logger, requestID, modelAlias string) (*http.Response, int, error) {
+	attempts := make([]hedge.Attempt, len(model.Targets))
+	for i, target := range model.Targets {
+		targetModel := targetModelConfig(model, target)
+		attempts[i] = func(ctx context.Context) (*http.Response, error) {
+			attemptReq := req.Clone(ctx)
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+			config := buildPortkeyConfig(targetModel)
+			clampRequestTimeout(config, timeout)
+			if err := setPortkeyHeaders(attemptReq, config, targetModel); err != nil {
+				return nil, err
+			}
+			return client.Do(attemptReq)
+		}
+	}
+
+	result, err := hedge.Do(req.Context(), attempts, time.Duration(model.HedgeAfter)*time.Millisecond)
+	if err != nil {
+		return nil, result.Started, err
 	}
+	if result.Started > 1 {
+		logger.Info("hedged streaming request across fallback targets",
+			"request_id", requestID,
+			"model_alias", modelAlias,
+			"started", result.Started,
+			"target_index", result.Target,
+			"provider", model.Targets[result.Target].Provider,
+		)
+	}
+	result.Response.Header.Set(annotate.TargetIndexHeader, strconv.Itoa(result.Target))
+	return result.Response, result.Started, nil
 }
 
-// MessagesHandler returns the Anthropic messages endpoint handler.
-func MessagesHandler(store *models.ConfigStore, logger *slog.Logger) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+// applyOverride returns model with an operator's overrides applied. Pinning a
+// target, or turning fallback off, narrows the alias to that target, so the
+// gateway neither falls back nor hedges.
+func applyOverride(model models.ModelConfig, o override.Override) (models.ModelConfig, error) {
+	target := o.Target
+	if o.NoFallback {
+		target = new(int)
+	}
+	if target != nil {
+		switch {
+		case len(model.Targets) > 0 && *target < len(model.Targets):
+			model = targetModelConfig(model, model.Targets[*target])
+		case len(model.Targets) == 0 && *target == 0:
+		default:
+			return model, fmt.Errorf("%s %d is out of range for this model", override.TargetHeader, *target)
 		}
-
-		// Parse request body with size limit
-		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
-		if err != nil {
-			var maxBytesErr *http.MaxBytesError
-			if errors.As(err, &maxBytesErr) {
-				writeJSONError(w, "Request body too large", http.StatusRequestEntityTooLarge)
-				return
+	}
+	if o.Retry != nil {
+		if *o.Retry == 0 {
+			model.Retry = nil
+		} else {
+			retry := models.RetryConfig{Attempts: *o.Retry}
+			if model.Retry != nil {
+				retry.OnStatusCodes = model.Retry.OnStatusCodes
 			}
-			logger.Error("failed to read request body", "error", err)
-			writeJSONError(w, "Failed to read request body", http.StatusBadRequest)
-			return
+			model.Retry = &retry
 		}
+	}
+	return model, nil
+}
 
-		var req models.MessagesRequest
-		if err := json.Unmarshal(body, &req); err != nil {
-			logger.Error("failed to parse request body", "error", err)
-			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+// targetModelConfig returns the alias narrowed to a single one of its targets.
+func targetModelConfig(model models.ModelConfig, target models.TargetConfig) models.ModelConfig {
+	model.Strategy = nil
+	model.Targets = nil
+	model.Provider = target.Provider
+	model.APIKey = target.APIKey
+	model.OverrideParams = target.OverrideParams
+	model.CustomHost = target.CustomHost
+	model.BaseURL = target.BaseURL
+	model.ForwardHeaders = target.ForwardHeaders
+	model.CustomHeaders = target.CustomHeaders
+	model.AWSAccessKeyID = target.AWSAccessKeyID
+	model.AWSSecretAccessKey = target.AWSSecretAccessKey
+	model.AWSRegion = target.AWSRegion
+	model.AWSSessionToken = target.AWSSessionToken
+	return model
+}
 
-		// Validate model alias
-		if req.Model == "" {
-			writeJSONError(w, "Missing 'model' field in request", http.StatusBadRequest)
-			return
-		}
+// writeFallback serves the alias's (or the global) fallback message in place
+// of a failed gateway response. It reports false when no message is configured
+// or the endpoint has no completion format to fall back to.
+func writeFallback(w http.ResponseWriter, body []byte, targetPath string, modelConfig models.ModelConfig, store *models.ConfigStore, svc *Services, logger *slog.Logger, requestID, application, modelAlias string, upstreamStatus int) bool {
+	message := modelConfig.FallbackMessage
+	if message == "" {
+		message = store.FallbackMessage
+	}
+	if message == "" {
+		return false
+	}
 
-		modelConfig, exists := store.Models[req.Model]
-		if !exists {
-			logger.Warn("unknown model alias", "alias", req.Model)
-			writeJSONError(w, "Unknown model alias", http.StatusBadRequest)
-			return
-		}
+	contentType, payload, ok := fallback.Response(targetPath, modelAlias, requestID, message, isStreamingRequest(body))
+	if !ok {
+		return false
+	}
 
-		// Ensure max_tokens is set
-		if req.MaxTokens == 0 {
-			// Try to get from model config override params
-			if modelConfig.OverrideParams != nil {
-				if mt, ok := modelConfig.OverrideParams["max_tokens"].(float64); ok {
-					req.MaxTokens = int(mt)
-				}
-			}
-			// Default if still not set
-			if req.MaxTokens == 0 {
-				req.MaxTokens = 4096
+	logger.Warn("serving fallback response",
+		"request_id", requestID,
+		"application", application,
+		"endpoint", targetPath,
+		"model_alias", modelAlias,
+		"upstream_status", upstreamStatus,
+	)
+	svc.Usage.RecordFallback(svc.Privacy.Label(application), modelAlias)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set(fallback.Header, "true")
+	w.WriteHeader(http.StatusOK)
+	w.Write(payload)
+	return true
+}
+
+// relayBody copies the upstream body to the client, flushing after each chunk
+// when supported so streams are delivered incrementally. Every chunk written to
+// the client is also written to observer.
+func relayBody(w http.ResponseWriter, body io.Reader, observer io.Writer, logger *slog.Logger) error {
+	flusher, canFlush := w.(http.Flusher)
+	buf := make([]byte, 4096)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, wErr := w.Write(buf[:n]); wErr != nil {
+				logger.Warn("client disconnected during stream", "error", wErr)
+				return fmt.Errorf("%w: %w", errClientGone, wErr)
 			}
-			// Update the body with the injected max_tokens
-			bodyMap := make(map[string]interface{})
-			if err := json.Unmarshal(body, &bodyMap); err == nil {
-				bodyMap["max_tokens"] = req.MaxTokens
-				if updatedBody, err := json.Marshal(bodyMap); err == nil {
-					body = updatedBody
-				}
+			if canFlush {
+				flusher.Flush()
 			}
+			observer.Write(buf[:n])
 		}
-
-		// Inject thinking configuration if present in model config
-		if modelConfig.Thinking != nil && req.Thinking == nil {
-			bodyMap := make(map[string]interface{})
-			if err := json.Unmarshal(body, &bodyMap); err == nil {
-				bodyMap["thinking"] = modelConfig.Thinking
-				if updatedBody, err := json.Marshal(bodyMap); err == nil {
-					body = updatedBody
-				}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// Check for context cancellation error
+			if errors.Is(err, context.Canceled) {
+				logger.Warn("request canceled by client")
+			} else {
+				logger.Error("error reading stream", "error", err)
 			}
+			return err
 		}
+	}
+}
 
-		// Get context values
-		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
-		requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+// responseCaptureLimit returns how much of a non-streaming response body may be
+// buffered for usage extraction. Larger responses are still relayed in full.
+func responseCaptureLimit(targetPath string) int {
+	if strings.HasPrefix(targetPath, "/v1/images/") {
+		return maxImageResponseSize
+	}
+	return maxBodySize
+}
 
-		// Delegate to shared proxy handler
-		handleProxyRequest(w, r, body, "/v1/messages", modelConfig, store, logger, requestID, application, req.Model)
+// hasFinishReasons reports whether responses from targetPath carry finish or stop reasons.
+func hasFinishReasons(targetPath string) bool {
+	switch targetPath {
+	case "/v1/chat/completions", "/v1/completions", "/v1/messages":
+		return true
 	}
+	return false
 }
 
-// handleProxyRequest executes the shared proxy logic for both chat completions and messages endpoints.
-func handleProxyRequest(w http.ResponseWriter, r *http.Request, body []byte, targetPath string, modelConfig models.ModelConfig, store *models.ConfigStore, logger *slog.Logger, requestID, application, modelAlias string) {
-	// Build Portkey configuration
-	portkeyConfig := buildPortkeyConfig(modelConfig)
+// normalizeJSONBody buffers a JSON response and rewrites its finish reasons.
+// Bodies larger than maxBodySize are passed through unchanged.
+func normalizeJSONBody(body io.Reader) io.Reader {
+	buf, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil || len(buf) > maxBodySize {
+		return io.MultiReader(bytes.NewReader(buf), body)
+	}
+	out, _ := finishreason.Rewrite(buf)
+	return bytes.NewReader(out)
+}
 
-	// Create proxy request to Portkey Gateway with per-request timeout
-	timeout := time.Duration(getTimeout(modelConfig)) * time.Second
-	ctx, cancel := context.WithTimeout(r.Context(), timeout)
-	defer cancel()
+// hasGeneratedText reports whether responses from targetPath carry text that
+// an alias's post_process applies to.
+func hasGeneratedText(targetPath string) bool {
+	return hasFinishReasons(targetPath) || targetPath == "/v1/responses"
+}
 
-	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, store.GatewayURL+targetPath, bytes.NewReader(body))
-	if err != nil {
-		logger.Error("failed to create proxy request", "error", err)
-		writeJSONError(w, "Internal server error", http.StatusInternalServerError)
-		return
+// postProcessJSONBody buffers a JSON response and cleans up its generated
+// text. Bodies larger than maxBodySize are passed through unchanged.
+func postProcessJSONBody(body io.Reader, cfg models.PostProcessConfig) io.Reader {
+	buf, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil || len(buf) > maxBodySize {
+		return io.MultiReader(bytes.NewReader(buf), body)
 	}
+	out, _ := postprocess.Apply(cfg, buf)
+	return bytes.NewReader(out)
+}
 
-	// Copy headers from original request, skipping hop-by-hop headers
-	copyHeaders(r.Header, proxyReq.Header)
+// annotateJSONBody buffers a JSON response and adds the routing annotation.
+// Bodies larger than maxBodySize are passed through unchanged.
+func annotateJSONBody(body io.Reader, annotation annotate.Annotation) io.Reader {
+	buf, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil || len(buf) > maxBodySize {
+		return io.MultiReader(bytes.NewReader(buf), body)
+	}
+	out, _ := annotate.JSON(buf, annotation)
+	return bytes.NewReader(out)
+}
 
-	// Set Portkey-specific headers
-	if err := setPortkeyHeaders(proxyReq, portkeyConfig, modelConfig); err != nil {
-		logger.Error("failed to set Portkey headers", "error", err)
-		writeJSONError(w, "Internal server error", http.StatusInternalServerError)
-		return
+// routingAnnotation describes how the gateway served a request for the alias.
+// The serving target of a multi-target alias is taken from the gateway's
+// response headers.
+func routingAnnotation(model models.ModelConfig, modelAlias string, header http.Header, attempts int) *annotate.Annotation {
+	annotation := &annotate.Annotation{
+		Alias:    modelAlias,
+		Provider: getProviderFromConfig(model),
+		Attempt:  attempts,
+	}
+	if len(model.Targets) > 0 {
+		if index, ok := annotate.ParseTargetIndex(header.Get(annotate.TargetIndexHeader)); ok && index < len(model.Targets) {
+			annotation.TargetIndex = &index
+			annotation.Provider = model.Targets[index].Provider
+		}
 	}
+	return annotation
+}
 
-	// Execute proxy request
-	start := time.Now()
-	resp, err := gatewayClient.Do(proxyReq)
-	if err != nil {
-		logger.Error("failed to proxy request to gateway", "error", err)
-		writeJSONError(w, "Failed to reach gateway", http.StatusBadGateway)
-		return
+// writeTrailer appends a final annotation to a relayed stream and flushes it.
+func writeTrailer(w http.ResponseWriter, trailer []byte, observer io.Writer) {
+	w.Write(trailer)
+	observer.Write(trailer)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
 	}
-	defer resp.Body.Close()
+}
 
-	duration := time.Since(start)
+// isStreamingRequest reports whether a JSON request body asks for a streamed response.
+func isStreamingRequest(body []byte) bool {
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	return json.Unmarshal(body, &req) == nil && req.Stream
+}
 
-	// Log the request
-	provider := getProviderFromConfig(modelConfig)
-	resolvedModel := getModelFromConfig(modelConfig)
-	logger.Info("proxy request completed",
-		"request_id", requestID,
-		"application", application,
-		"endpoint", targetPath,
-		"model_alias", modelAlias,
-		"provider", provider,
-		"resolved_model", resolvedModel,
-		"status", resp.StatusCode,
-		"duration_ms", duration.Milliseconds(),
-	)
+// injectsStreamUsage reports whether streaming requests to targetPath should
+// ask for usage. It applies to OpenAI chat and legacy completions unless the
+// alias opts out.
+func injectsStreamUsage(targetPath string, model models.ModelConfig) bool {
+	if targetPath != "/v1/chat/completions" && targetPath != "/v1/completions" {
+		return false
+	}
+	return model.InjectStreamUsage == nil || *model.InjectStreamUsage
+}
 
-	// Copy response headers
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+// isEventStream reports whether the response is a server-sent event stream.
+func isEventStream(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+}
+
+// isJSON reports whether the response has a JSON body.
+func isJSON(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "application/json")
+}
+
+// ndjsonContentType is the media type for newline-delimited JSON streams.
+const ndjsonContentType = "application/x-ndjson"
+
+// wantsNDJSON reports whether the client asked for NDJSON instead of SSE framing.
+func wantsNDJSON(header http.Header) bool {
+	for _, value := range header.Values("Accept") {
+		for _, mediaType := range strings.Split(value, ",") {
+			mediaType, _, _ = strings.Cut(mediaType, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), ndjsonContentType) {
+				return true
+			}
 		}
 	}
+	return false
+}
 
-	w.WriteHeader(resp.StatusCode)
+// statusRecorder remembers the status code written to the client.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
 
-	// Stream or copy response body
-	if flusher, ok := w.(http.Flusher); ok {
-		buf := make([]byte, 4096)
-		for {
-			n, err := resp.Body.Read(buf)
-			if n > 0 {
-				if _, wErr := w.Write(buf[:n]); wErr != nil {
-					logger.Warn("client disconnected during stream", "error", wErr)
-					break
-				}
-				flusher.Flush()
-			}
-			if err == io.EOF {
-				break
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher by delegating to the underlying writer.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// ndjsonWriter converts a server-sent event stream into newline-delimited JSON.
+// Each event's data payload becomes one compacted JSON line; event names,
+// comments and non-JSON payloads such as "[DONE]" are dropped.
+type ndjsonWriter struct {
+	http.ResponseWriter
+	observer io.Writer
+	line     []byte
+	data     bytes.Buffer
+}
+
+func newNDJSONWriter(w http.ResponseWriter, observer io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{ResponseWriter: w, observer: observer}
+}
+
+// Write consumes raw SSE bytes and emits a JSON line for every complete event.
+func (n *ndjsonWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\n' {
+			n.line = append(n.line, b)
+			continue
+		}
+		line := strings.TrimSuffix(string(n.line), "\r")
+		n.line = n.line[:0]
+		if line == "" {
+			if err := n.emit(); err != nil {
+				return 0, err
 			}
-			if err != nil {
-				// Check for context cancellation error
-				if errors.Is(err, context.Canceled) {
-					logger.Warn("request canceled by client")
-				} else {
-					logger.Error("error reading stream", "error", err)
-				}
-				break
+			continue
+		}
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			if n.data.Len() > 0 {
+				n.data.WriteByte('\n')
 			}
+			n.data.WriteString(strings.TrimPrefix(data, " "))
 		}
-	} else {
-		io.Copy(w, resp.Body)
+	}
+	return len(p), nil
+}
+
+// Close emits any event left unterminated at the end of the stream.
+func (n *ndjsonWriter) Close() error {
+	if len(n.line) > 0 {
+		n.Write([]byte("\n"))
+	}
+	return n.emit()
+}
+
+func (n *ndjsonWriter) emit() error {
+	defer n.data.Reset()
+	if n.data.Len() == 0 || !json.Valid(n.data.Bytes()) {
+		return nil
+	}
+	var out bytes.Buffer
+	json.Compact(&out, n.data.Bytes())
+	out.WriteByte('\n')
+	n.observer.Write(out.Bytes())
+	_, err := n.ResponseWriter.Write(out.Bytes())
+	return err
+}
+
+// Flush forwards to the underlying writer when it supports flushing.
+func (n *ndjsonWriter) Flush() {
+	if flusher, ok := n.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// cappedBuffer captures up to limit bytes and records whether more were written.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if c.truncated {
+		return len(p), nil
+	}
+	if c.buf.Len()+len(p) > c.limit {
+		c.truncated = true
+		c.buf.Reset()
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+// withProviderKey returns model with key in place of the api_key of the alias
+// and of each of its targets.
+func withProviderKey(model models.ModelConfig, key string) models.ModelConfig {
+	model.APIKey = key
+	if len(model.Targets) > 0 {
+		targets := make([]models.TargetConfig, len(model.Targets))
+		for i, target := range model.Targets {
+			target.APIKey = key
+			targets[i] = target
+		}
+		model.Targets = targets
+	}
+	return model
+}
+
+// clampRequestTimeout holds the gateway's request_timeout in config to the
+// request's own timeout, which is already under the alias and server-wide
+// ceilings.
+func clampRequestTimeout(config *models.PortkeyConfig, timeout time.Duration) {
+	if config.RequestTimeout > 0 {
+		config.RequestTimeout = int(min(time.Duration(config.RequestTimeout)*time.Millisecond, timeout).Milliseconds())
 	}
 }
 
 // buildPortkeyConfig constructs the Portkey configuration from model config.
 func buildPortkeyConfig(model models.ModelConfig) *models.PortkeyConfig {
 	config := &models.PortkeyConfig{
-		Retry:          model.Retry,
-		RequestTimeout: model.RequestTimeout,
+		Retry:                  model.Retry,
+		RequestTimeout:         model.RequestTimeout,
+		StrictOpenAICompliance: model.StrictOpenAICompliance,
 	}
 
 	if model.Strategy != nil {
@@ -348,10 +2994,25 @@ func buildPortkeyConfig(model models.ModelConfig) *models.PortkeyConfig {
 		config.Strategy = model.Strategy
 		config.Targets = make([]models.TargetConfig, len(model.Targets))
 		copy(config.Targets, model.Targets)
+		for i, target := range config.Targets {
+			if target.Provider == models.ProviderLocal {
+				config.Targets[i].Provider = models.GatewayProvider(target.Provider)
+				config.Targets[i].CustomHost = target.BaseURL
+				config.Targets[i].BaseURL = ""
+			}
+			// Custom header values travel as request headers, see setPortkeyHeaders
+			config.Targets[i].ForwardHeaders = withCustomHeaders(target.ForwardHeaders, target.CustomHeaders)
+			config.Targets[i].CustomHeaders = nil
+		}
 	} else {
 		// Single provider configuration
-		config.Provider = model.Provider
+		config.Provider = models.GatewayProvider(model.Provider)
 		config.APIKey = model.APIKey
+		config.CustomHost = model.CustomHost
+		if model.Provider == models.ProviderLocal {
+			config.CustomHost = model.BaseURL
+		}
+		config.ForwardHeaders = withCustomHeaders(model.ForwardHeaders, model.CustomHeaders)
 		config.OverrideParams = make(map[string]interface{})
 
 		// Copy override params
@@ -378,8 +3039,40 @@ func buildPortkeyConfig(model models.ModelConfig) *models.PortkeyConfig {
 	return config
 }
 
-// setPortkeyHeaders sets the appropriate Portkey headers on the request.
+// withCustomHeaders returns forwardHeaders plus the names of customHeaders
+// not already in it, in sorted order.
+func withCustomHeaders(forwardHeaders []string, customHeaders map[string]string) []string {
+	if len(customHeaders) == 0 {
+		return forwardHeaders
+	}
+	result := slices.Clone(forwardHeaders)
+	for _, name := range slices.Sorted(maps.Keys(customHeaders)) {
+		if !slices.ContainsFunc(result, func(forwarded string) bool { return strings.EqualFold(forwarded, name) }) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// setPortkeyHeaders sets the appropriate Portkey headers on the request,
+// after the alias's static upstream headers so those cannot replace them.
+// Custom headers are set too, and the config names them in forward_headers so
+// the gateway passes them on to the provider.
 func setPortkeyHeaders(req *http.Request, config *models.PortkeyConfig, model models.ModelConfig) error {
+	for name, value := range model.UpstreamHeaders {
+		req.Header.Set(name, value)
+	}
+	if model.Strategy == nil {
+		for name, value := range model.CustomHeaders {
+			req.Header.Set(name, value)
+		}
+	}
+	for _, target := range model.Targets {
+		for name, value := range target.CustomHeaders {
+			req.Header.Set(name, value)
+		}
+	}
+
 	// Set the x-portkey-config header
 	configJSON, err := config.ToJSON()
 	if err != nil {
@@ -389,7 +3082,7 @@ func setPortkeyHeaders(req *http.Request, config *models.PortkeyConfig, model mo
 
 	// Set provider-specific headers
 	provider := getProviderFromConfig(model)
-	req.Header.Set("x-portkey-provider", provider)
+	req.Header.Set("x-portkey-provider", models.GatewayProvider(provider))
 
 	// Set Vertex-specific headers
 	if provider == "vertex-ai" {
@@ -439,6 +3132,31 @@ func getTimeout(model models.ModelConfig) int {
 	return 60 // Default 60 seconds
 }
 
+// requestTimeout returns the timeout for a request: the client's
+// x-portkey-request-timeout if valid, otherwise the alias's configured timeout,
+// clamped to the tighter of the alias and server-wide ceilings. It also returns
+// the unclamped value and where it came from ("client" or "config").
+func requestTimeout(header http.Header, model models.ModelConfig, serverCeiling time.Duration) (timeout, requested time.Duration, source string) {
+	requested = time.Duration(getTimeout(model)) * time.Second
+	source = "config"
+	if ms, err := strconv.Atoi(header.Get(requestTimeoutHeader)); err == nil && ms > 0 {
+		requested = time.Duration(ms) * time.Millisecond
+		source = "client"
+	}
+
+	ceiling := serverCeiling
+	if model.MaxRequestTimeout > 0 {
+		aliasCeiling := time.Duration(model.MaxRequestTimeout) * time.Millisecond
+		if ceiling <= 0 || aliasCeiling < ceiling {
+			ceiling = aliasCeiling
+		}
+	}
+	if ceiling > 0 && requested > ceiling {
+		return ceiling, requested, source
+	}
+	return requested, requested, source
+}
+
 // joinBetaHeaders joins beta headers with commas.
 func joinBetaHeaders(headers []string) string {
 	result := ""
@@ -461,4 +3179,4 @@ func copyHeaders(src, dst http.Header) {
 			dst.Add(key, value)
 		}
 	}
-}
\ No newline at end of file
+}
@@ -1,13 +1,56 @@
 package handlers
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/amscotti/portus/internal/annotate"
+	"github.com/amscotti/portus/internal/breaker"
+	"github.com/amscotti/portus/internal/budget"
+	"github.com/amscotti/portus/internal/concurrency"
+	"github.com/amscotti/portus/internal/conversation"
+	"github.com/amscotti/portus/internal/credguard"
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/experiment"
+	"github.com/amscotti/portus/internal/messagestream"
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/mock"
+	"github.com/amscotti/portus/internal/modellist"
 	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/override"
+	"github.com/amscotti/portus/internal/privacy"
+	"github.com/amscotti/portus/internal/progress"
+	"github.com/amscotti/portus/internal/provenance"
+	"github.com/amscotti/portus/internal/quota"
+	"github.com/amscotti/portus/internal/report"
+	"github.com/amscotti/portus/internal/semcache"
+	"github.com/amscotti/portus/internal/spool"
+	"github.com/amscotti/portus/internal/statsd"
+	"github.com/amscotti/portus/internal/streamlimit"
+	"github.com/amscotti/portus/internal/usage"
 )
 
 func TestWriteJSONError(t *testing.T) {
@@ -95,6 +138,110 @@ func TestBuildPortkeyConfig_SingleProvider(t *testing.T) {
 	}
 }
 
+func TestBuildPortkeyConfig_CustomHost(t *testing.T) {
+	t.Parallel()
+
+	strict := false
+	model := models.ModelConfig{
+		Provider:               "openai",
+		CustomHost:             "http://vllm.internal:8000/v1",
+		ForwardHeaders:         []string{"x-tenant-id"},
+		StrictOpenAICompliance: &strict,
+	}
+
+	configJSON, err := buildPortkeyConfig(model).ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"custom_host":"http://vllm.internal:8000/v1"`, `"forward_headers":["x-tenant-id"]`, `"strict_open_ai_compliance":false`} {
+		if !strings.Contains(configJSON, want) {
+			t.Errorf("expected %s in %s", want, configJSON)
+		}
+	}
+
+	// Unset compliance is left to the gateway default
+	configJSON, _ = buildPortkeyConfig(models.ModelConfig{Provider: "openai", APIKey: "sk-test"}).ToJSON()
+	if strings.Contains(configJSON, "strict_open_ai_compliance") || strings.Contains(configJSON, "custom_host") {
+		t.Errorf("expected unset options to be omitted, got %s", configJSON)
+	}
+}
+
+func TestBuildPortkeyConfig_CustomHeaders(t *testing.T) {
+	t.Parallel()
+
+	model := models.ModelConfig{
+		Provider:       "openai",
+		CustomHost:     "http://vllm.internal:8000/v1",
+		ForwardHeaders: []string{"x-tenant-id"},
+		CustomHeaders:  map[string]string{"X-Deployment": "blue", "x-tenant-id": "acme"},
+	}
+	config := buildPortkeyConfig(model)
+	if !slices.Equal(config.ForwardHeaders, []string{"x-tenant-id", "X-Deployment"}) {
+		t.Errorf("expected custom headers to be forwarded once each, got %v", config.ForwardHeaders)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	if err := setPortkeyHeaders(req, config, model); err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("X-Deployment") != "blue" || req.Header.Get("X-Tenant-Id") != "acme" {
+		t.Errorf("expected custom headers on the gateway request, got %v", req.Header)
+	}
+
+	// Target headers are forwarded by their own target only and kept out of
+	// the config sent to the gateway
+	multi := models.ModelConfig{
+		Strategy: &models.StrategyConfig{Mode: "fallback"},
+		Targets: []models.TargetConfig{
+			{Provider: "openai", CustomHost: "http://vllm.internal:8000/v1", CustomHeaders: map[string]string{"X-Deployment": "blue"}},
+			{Provider: "openai", APIKey: "sk-test"},
+		},
+	}
+	config = buildPortkeyConfig(multi)
+	if !slices.Equal(config.Targets[0].ForwardHeaders, []string{"X-Deployment"}) || config.Targets[1].ForwardHeaders != nil {
+		t.Errorf("unexpected target forward headers %v and %v", config.Targets[0].ForwardHeaders, config.Targets[1].ForwardHeaders)
+	}
+	if configJSON, _ := config.ToJSON(); strings.Contains(configJSON, "custom_headers") || multi.Targets[0].CustomHeaders == nil {
+		t.Errorf("expected custom headers removed from the gateway config only, got %s", configJSON)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	setPortkeyHeaders(req, config, multi)
+	if req.Header.Get("X-Deployment") != "blue" {
+		t.Errorf("expected target custom headers on the gateway request, got %v", req.Header)
+	}
+}
+
+func TestBuildPortkeyConfig_Local(t *testing.T) {
+	t.Parallel()
+
+	single := buildPortkeyConfig(models.ModelConfig{Provider: models.ProviderLocal, BaseURL: "http://localhost:11434/v1"})
+	if single.Provider != "openai" || single.CustomHost != "http://localhost:11434/v1" {
+		t.Errorf("expected a local alias to reach the gateway as openai at its base_url, got %+v", single)
+	}
+
+	configJSON, err := buildPortkeyConfig(models.ModelConfig{
+		Strategy: &models.StrategyConfig{Mode: "fallback"},
+		Targets: []models.TargetConfig{
+			{Provider: models.ProviderLocal, BaseURL: "http://localhost:1234/v1"},
+			{Provider: "openai", APIKey: "sk-1"},
+		},
+	}).ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(configJSON, `{"provider":"openai","custom_host":"http://localhost:1234/v1"}`) || strings.Contains(configJSON, "base_url") {
+		t.Errorf("expected local targets to be sent as openai with a custom_host, got %s", configJSON)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	model := models.ModelConfig{Provider: models.ProviderLocal, BaseURL: "http://localhost:11434/v1"}
+	if err := setPortkeyHeaders(req, buildPortkeyConfig(model), model); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("x-portkey-provider"); got != "openai" {
+		t.Errorf("expected x-portkey-provider openai, got %q", got)
+	}
+}
+
 func TestBuildPortkeyConfig_MultiTarget(t *testing.T) {
 	t.Parallel()
 
@@ -366,7 +513,7 @@ func TestModelsHandler(t *testing.T) {
 		StartTime: time.Now(),
 	}
 
-	handler := ModelsHandler(store)
+	handler := ModelsHandler(store, &Services{})
 
 	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
 	rec := httptest.NewRecorder()
@@ -389,6 +536,57 @@ func TestModelsHandler(t *testing.T) {
 	}
 }
 
+func TestModelsHandler_MergesLiveProviderModels(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/models" {
+			t.Errorf("unexpected gateway request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("x-portkey-provider") != "openai" {
+			t.Errorf("expected provider header, got %q", r.Header.Get("x-portkey-provider"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"gpt-4o","owned_by":"openai"},{"id":"gpt4"},{"id":"o3"}]}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{
+			"gpt4":   {Provider: "openai", APIKey: "sk-test", ListModels: true},
+			"claude": {Provider: "anthropic", APIKey: "sk-ant"},
+		},
+		GatewayURL: gateway.URL,
+		StartTime:  time.Now(),
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	svc := &Services{Catalog: modellist.New(ProviderModels(store), time.Minute, logger)}
+
+	rec := httptest.NewRecorder()
+	ModelsHandler(store, svc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+
+	var resp models.ModelsListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	owners := make(map[string]string)
+	for _, m := range resp.Data {
+		if _, dup := owners[m.ID]; dup {
+			t.Errorf("duplicate model %q", m.ID)
+		}
+		owners[m.ID] = m.OwnedBy
+	}
+	want := map[string]string{"claude": "portus", "gpt4": "portus", "gpt-4o": "openai", "o3": "openai"}
+	if len(owners) != len(want) {
+		t.Fatalf("expected models %v, got %v", want, owners)
+	}
+	for id, owner := range want {
+		if owners[id] != owner {
+			t.Errorf("expected %s owned by %q, got %q", id, owner, owners[id])
+		}
+	}
+}
+
 func TestHealthHandler_NoModelAliasesLeaked(t *testing.T) {
 	t.Parallel()
 
@@ -452,3 +650,3389 @@ func TestJoinBetaHeaders(t *testing.T) {
 		})
 	}
 }
+
+func TestChatCompletionsHandler_RecordsUsage(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","usage":{"prompt_tokens":9,"completion_tokens":3,"total_tokens":12}}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{"gpt4": {
+			Provider: "openai",
+			APIKey:   "sk-test",
+			Pricing:  &models.PricingConfig{InputPer1K: 1, OutputPer1K: 2},
+		}},
+		GatewayURL: gateway.URL,
+		StartTime:  time.Now(),
+	}
+	tracker := usage.NewTracker()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	handler := ChatCompletionsHandler(store, &Services{Usage: tracker}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "backend"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	totals := tracker.Snapshot("backend")
+	if len(totals) != 1 {
+		t.Fatalf("expected 1 usage entry, got %d", len(totals))
+	}
+	if totals[0].PromptTokens != 9 || totals[0].CompletionTokens != 3 {
+		t.Errorf("unexpected usage totals: %+v", totals[0])
+	}
+	if want := 0.009 + 0.006; math.Abs(totals[0].EstimatedCostUSD-want) > 1e-12 {
+		t.Errorf("expected estimated cost %v, got %v", want, totals[0].EstimatedCostUSD)
+	}
+}
+
+func TestStatsHandler_ScopedToApplication(t *testing.T) {
+	t.Parallel()
+
+	tracker := usage.NewTracker()
+	tracker.Record("backend", "gpt4", usage.Usage{PromptTokens: 1}, 0.5)
+	tracker.Record("frontend", "gpt4", usage.Usage{PromptTokens: 2}, 1)
+
+	handler := StatsHandler(&Services{Usage: tracker})
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "backend"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var resp models.StatsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(resp.Usage) != 1 || resp.Usage[0].Application != "backend" {
+		t.Errorf("expected only backend usage, got %+v", resp.Usage)
+	}
+	if resp.EstimatedCostUSD != 0.5 {
+		t.Errorf("expected estimated cost 0.5, got %v", resp.EstimatedCostUSD)
+	}
+}
+
+func TestStatsHandler_AggregateMode(t *testing.T) {
+	t.Parallel()
+
+	policy := privacy.New(privacy.Aggregate, 4, 5)
+	tracker := usage.NewTracker()
+	for i := 0; i < 6; i++ {
+		tracker.Record(policy.Label("backend"), "gpt4", usage.Usage{PromptTokens: 1}, 0.1)
+	}
+	tracker.Record(policy.Label("rare-app"), "claude", usage.Usage{PromptTokens: 2}, 1)
+
+	handler := StatsHandler(&Services{Usage: tracker, Privacy: policy})
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "backend"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var resp models.StatsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if resp.Application != "" {
+		t.Errorf("expected no application in aggregate mode, got %q", resp.Application)
+	}
+	if len(resp.Usage) != 2 || resp.Usage[0].Application != policy.Label("backend") || resp.Usage[1].Application != privacy.OtherLabel {
+		t.Errorf("expected a bucketed row and a folded row, got %+v", resp.Usage)
+	}
+	if strings.Contains(rec.Body.String(), "rare-app") || strings.Contains(rec.Body.String(), `"backend"`) {
+		t.Errorf("expected no application names in response: %s", rec.Body.String())
+	}
+}
+
+func TestStatsHandler_History(t *testing.T) {
+	t.Parallel()
+
+	history := usage.NewHistory(usage.Retention{Raw: time.Hour, Hourly: time.Hour, Daily: time.Hour})
+	at := time.Date(2026, 3, 10, 9, 15, 0, 0, time.UTC)
+	history.Record("backend", "gpt4", usage.Usage{PromptTokens: 3}, 0, at)
+	history.Record("frontend", "gpt4", usage.Usage{PromptTokens: 5}, 0, at)
+
+	handler := StatsHandler(&Services{Usage: usage.NewTracker(), History: history})
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantBuckets int
+	}{
+		{name: "no resolution", query: "", wantStatus: http.StatusOK},
+		{name: "hourly", query: "?resolution=hour", wantStatus: http.StatusOK, wantBuckets: 1},
+		{name: "since excludes", query: "?resolution=hour&since=2026-03-10T10:00:00Z", wantStatus: http.StatusOK},
+		{name: "bad resolution", query: "?resolution=week", wantStatus: http.StatusBadRequest},
+		{name: "bad since", query: "?resolution=day&since=yesterday", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodGet, "/stats"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "backend"))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp models.StatsResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			if len(resp.History) != tt.wantBuckets {
+				t.Fatalf("expected %d buckets, got %+v", tt.wantBuckets, resp.History)
+			}
+			if tt.wantBuckets > 0 && resp.History[0].PromptTokens != 3 {
+				t.Errorf("expected backend-only usage, got %+v", resp.History[0])
+			}
+		})
+	}
+}
+
+func TestEmbeddingsHandler(t *testing.T) {
+	t.Parallel()
+
+	var gotPath, gotConfig string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotConfig = r.Header.Get("x-portkey-config")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[],"usage":{"prompt_tokens":4,"total_tokens":4}}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{"embed": {
+			Provider:       "openai",
+			APIKey:         "sk-test",
+			OverrideParams: map[string]interface{}{"model": "text-embedding-3-small"},
+		}},
+		GatewayURL: gateway.URL,
+		StartTime:  time.Now(),
+	}
+	tracker := usage.NewTracker()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := EmbeddingsHandler(store, &Services{Usage: tracker}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(`{"model":"embed","input":"hello"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if gotPath != "/v1/embeddings" {
+		t.Errorf("expected gateway path /v1/embeddings, got %q", gotPath)
+	}
+	if !strings.Contains(gotConfig, "text-embedding-3-small") {
+		t.Errorf("expected portkey config to contain resolved model, got %q", gotConfig)
+	}
+	if totals := tracker.Snapshot(""); len(totals) != 1 || totals[0].PromptTokens != 4 {
+		t.Errorf("expected embedding usage to be recorded, got %+v", totals)
+	}
+
+	// Unknown aliases are rejected before reaching the gateway
+	req = httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(`{"model":"missing","input":"hello"}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for unknown alias, got %d", rec.Code)
+	}
+}
+
+func TestCompletionsHandler(t *testing.T) {
+	t.Parallel()
+
+	var gotPath string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"text_completion","choices":[{"text":"hi"}]}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"instruct": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+		StartTime:  time.Now(),
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := CompletionsHandler(store, &Services{Usage: usage.NewTracker()}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/completions", strings.NewReader(`{"model":"instruct","prompt":"Say hi"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if gotPath != "/v1/completions" {
+		t.Errorf("expected gateway path /v1/completions, got %q", gotPath)
+	}
+}
+
+func TestImageGenerationsHandler_LargeResponse(t *testing.T) {
+	t.Parallel()
+
+	// A response larger than maxBodySize must still be relayed and its usage parsed
+	image := strings.Repeat("A", maxBodySize+1024)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/images/generations" {
+			t.Errorf("expected gateway path /v1/images/generations, got %q", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":[{"b64_json":"` + image + `"}],"usage":{"input_tokens":20,"output_tokens":1000}}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"image-gen": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+		StartTime:  time.Now(),
+	}
+	tracker := usage.NewTracker()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := ImageGenerationsHandler(store, &Services{Usage: tracker}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/images/generations", strings.NewReader(`{"model":"image-gen","prompt":"a cat"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if rec.Body.Len() < maxBodySize {
+		t.Errorf("expected full image payload to be relayed, got %d bytes", rec.Body.Len())
+	}
+	if totals := tracker.Snapshot(""); len(totals) != 1 || totals[0].CompletionTokens != 1000 {
+		t.Errorf("expected image usage to be recorded, got %+v", totals)
+	}
+}
+
+func TestLimitsHandler(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := &models.ConfigStore{
+		StreamBurst:     2,
+		MaxTokensAction: "clamp",
+		Budgets:         map[string]models.BudgetConfig{"backend": {DailyUSD: 10}},
+	}
+	quotas := quota.New(logger)
+	svc := &Services{Streams: streamlimit.New(), Budgets: budget.New(nil, logger), Quotas: quotas}
+	requestQuota := models.RequestQuota{Limit: 100, Window: "day"}
+	svc.Quotas.Take("backend", requestQuota, time.Now())
+	release, _, _ := svc.Streams.Acquire("backend", 3)
+	defer release()
+	svc.Budgets.Add("backend", store.Budgets["backend"], 4, time.Now())
+	handler := LimitsHandler(store, svc)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/limits", nil)
+	ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "backend")
+	ctx = context.WithValue(ctx, middleware.ContextKeyProxyKey, models.ProxyKey{Application: "backend", MaxStreams: 3, MaxTokens: 4000, RequestQuota: requestQuota})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req.WithContext(ctx))
+
+	var resp models.LimitsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if resp.Streams == nil || *resp.Streams != (models.StreamLimits{Limit: 3, Burst: 2, Active: 1, Remaining: 2}) {
+		t.Errorf("unexpected streams %+v", resp.Streams)
+	}
+	if resp.MaxTokens == nil || resp.MaxTokens.Limit != 4000 || resp.MaxTokens.Action != "clamp" {
+		t.Errorf("unexpected max tokens %+v", resp.MaxTokens)
+	}
+	if resp.Requests == nil || resp.Requests.Used != 1 || resp.Requests.Remaining != 99 || resp.Requests.Window != "day" {
+		t.Errorf("unexpected request quota %+v", resp.Requests)
+	}
+	if len(resp.Budgets) != 1 || resp.Budgets[0].SpentUSD != 4 || resp.Budgets[0].RemainingUSD != 6 || resp.Budgets[0].ResetsAt.IsZero() {
+		t.Errorf("unexpected budgets %+v", resp.Budgets)
+	}
+	if resp.GraceUntil != nil {
+		t.Errorf("unexpected grace period %v", resp.GraceUntil)
+	}
+
+	// Without limits only the application is reported
+	req = httptest.NewRequest(http.MethodGet, "/v1/limits", nil)
+	ctx = context.WithValue(req.Context(), middleware.ContextKeyApplication, "other")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req.WithContext(ctx))
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"application":"other","budgets":[]}` {
+		t.Errorf("unexpected response %s", body)
+	}
+}
+
+func TestWhoamiHandler(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{
+			"gpt4":   {Provider: "openai"},
+			"claude": {Provider: "anthropic"},
+			"legacy": {Provider: "openai", OverrideParams: map[string]any{"model": "gpt-3.5-turbo"}},
+			"broken": {Provider: "openai"},
+		},
+		BannedModels: []string{"gpt-3.5*"},
+		Budgets:      map[string]models.BudgetConfig{"backend": {DailyUSD: 10}},
+	}
+	quotas := quota.New(logger)
+	svc := &Services{
+		Streams:     streamlimit.New(),
+		Budgets:     budget.New(nil, logger),
+		Quotas:      quotas,
+		Credentials: credguard.New(1, 0, false, nil, logger),
+	}
+	svc.Credentials.Record("broken", store.Models["broken"], http.StatusUnauthorized)
+	svc.Budgets.Add("backend", store.Budgets["backend"], 4, time.Now())
+	handler := WhoamiHandler(store, svc)
+
+	tests := []struct {
+		name       string
+		scope      models.KeyScope
+		wantModels []string
+		wantLimits bool
+	}{
+		{name: "inference key", scope: models.ScopeInference, wantModels: []string{"claude", "gpt4"}, wantLimits: true},
+		{name: "observability key", scope: models.ScopeObservability, wantModels: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodGet, "/debug/whoami", nil)
+			ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "backend")
+			ctx = context.WithValue(ctx, middleware.ContextKeyScope, tt.scope)
+			ctx = context.WithValue(ctx, middleware.ContextKeyRequestID, "req-123")
+			ctx = context.WithValue(ctx, middleware.ContextKeyProxyKey, models.ProxyKey{Application: "backend", Scope: tt.scope, MaxStreams: 3})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req.WithContext(ctx))
+
+			var resp models.WhoamiResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			if resp.Application != "backend" || resp.RequestID != "req-123" || resp.Scope != tt.scope {
+				t.Errorf("unexpected identity: %+v", resp)
+			}
+			if strings.Join(resp.AllowedModels, ",") != strings.Join(tt.wantModels, ",") {
+				t.Errorf("expected models %v, got %v", tt.wantModels, resp.AllowedModels)
+			}
+			if !tt.wantLimits {
+				if resp.Limits != nil {
+					t.Errorf("expected no limits, got %+v", resp.Limits)
+				}
+				return
+			}
+			if resp.Limits == nil || resp.Limits.Streams == nil || resp.Limits.Streams.Limit != 3 {
+				t.Fatalf("expected stream limits, got %+v", resp.Limits)
+			}
+			if len(resp.Limits.Budgets) != 1 || resp.Limits.Budgets[0].SpentUSD != 4 {
+				t.Errorf("unexpected budgets %+v", resp.Limits.Budgets)
+			}
+		})
+	}
+}
+
+func TestAudioTranscriptionsHandler_MultipartPassthrough(t *testing.T) {
+	t.Parallel()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("model", "whisper")
+	fw, _ := mw.CreateFormFile("file", "audio.mp3")
+	fw.Write([]byte("fake-audio-bytes"))
+	mw.Close()
+
+	var gotFile, gotContentType string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotContentType = r.Header.Get("Content-Type")
+		if file, _, err := r.FormFile("file"); err == nil {
+			data, _ := io.ReadAll(file)
+			gotFile = string(data)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text":"hello"}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"whisper": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+		StartTime:  time.Now(),
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := AudioTranscriptionsHandler(store, &Services{Usage: usage.NewTracker()}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if gotContentType != mw.FormDataContentType() {
+		t.Errorf("expected multipart Content-Type to be forwarded, got %q", gotContentType)
+	}
+	if gotFile != "fake-audio-bytes" {
+		t.Errorf("expected uploaded file to be forwarded, got %q", gotFile)
+	}
+}
+
+func TestAudioTranscriptionsHandler_SpilledUpload(t *testing.T) {
+	t.Parallel()
+
+	audio := strings.Repeat("fake-audio-bytes", 64)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("model", "whisper")
+	fw, _ := mw.CreateFormFile("file", "audio.mp3")
+	fw.Write([]byte(audio))
+	mw.Close()
+	size := int64(body.Len())
+
+	var gotFile string
+	var gotLength int64
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLength = r.ContentLength
+		if file, _, err := r.FormFile("file"); err == nil {
+			data, _ := io.ReadAll(file)
+			gotFile = string(data)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text":"hello"}`))
+	}))
+	defer gateway.Close()
+
+	spillDir := t.TempDir()
+	store := &models.ConfigStore{
+		Models:             map[string]models.ModelConfig{"whisper": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL:         gateway.URL,
+		BodySpillThreshold: 64,
+		BodySpillDir:       spillDir,
+		StartTime:          time.Now(),
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := AudioTranscriptionsHandler(store, &Services{Usage: usage.NewTracker()}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if gotFile != audio {
+		t.Errorf("expected spilled upload to be forwarded intact, got %d bytes", len(gotFile))
+	}
+	if gotLength != size {
+		t.Errorf("expected Content-Length %d, got %d", size, gotLength)
+	}
+	if entries, _ := os.ReadDir(spillDir); len(entries) != 0 {
+		t.Errorf("expected temporary file to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestChatCompletionsHandler_SpilledImageBody(t *testing.T) {
+	t.Parallel()
+
+	image := strings.Repeat("iVBORw0KGgo=", 2*spool.MinSpilledValue)
+	reqBody := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":[{"type":"text","text":"Describe"},{"type":"image_url","image_url":{"url":"data:image/png;base64,` + image + `"}}]}]}`
+
+	var gotImage string
+	var gotUsage bool
+	var gotLength int64
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLength = r.ContentLength
+		var sent struct {
+			StreamOptions struct {
+				IncludeUsage bool `json:"include_usage"`
+			} `json:"stream_options"`
+			Messages []struct {
+				Content []struct {
+					ImageURL struct {
+						URL string `json:"url"`
+					} `json:"image_url"`
+				} `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+			t.Errorf("gateway received invalid JSON: %v", err)
+		} else {
+			gotImage = sent.Messages[0].Content[1].ImageURL.URL
+			gotUsage = sent.StreamOptions.IncludeUsage
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"A cat\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer gateway.Close()
+
+	spillDir := t.TempDir()
+	store := &models.ConfigStore{
+		Models:             map[string]models.ModelConfig{"gpt-4o": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL:         gateway.URL,
+		BodySpillThreshold: 1024,
+		BodySpillDir:       spillDir,
+		StartTime:          time.Now(),
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := ChatCompletionsHandler(store, &Services{Usage: usage.NewTracker()}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if gotImage != "data:image/png;base64,"+image {
+		t.Errorf("expected spilled image to be forwarded intact, got %d bytes", len(gotImage))
+	}
+	if !gotUsage {
+		t.Error("expected stream usage to be injected into the spilled body")
+	}
+	if gotLength <= int64(len(reqBody)) {
+		t.Errorf("expected Content-Length of the rewritten body, got %d", gotLength)
+	}
+	if entries, _ := os.ReadDir(spillDir); len(entries) != 0 {
+		t.Errorf("expected temporary file to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestMultipartFormValue(t *testing.T) {
+	t.Parallel()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("language", "en")
+	mw.WriteField("model", " whisper ")
+	mw.Close()
+
+	got, err := multipartFormValue(bytes.NewReader(body.Bytes()), mw.FormDataContentType(), "model")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "whisper" {
+		t.Errorf("expected 'whisper', got %q", got)
+	}
+
+	if _, err := multipartFormValue(bytes.NewReader(body.Bytes()), "application/json", "model"); err == nil {
+		t.Error("expected error for non-multipart Content-Type")
+	}
+}
+
+func TestResponsesHandler_Streaming(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/responses" {
+			t.Errorf("expected gateway path /v1/responses, got %q", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Hi\"}\n\n"))
+		w.Write([]byte("event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"usage\":{\"input_tokens\":6,\"output_tokens\":2}}}\n\n"))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt-4o": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+		StartTime:  time.Now(),
+	}
+	tracker := usage.NewTracker()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := ResponsesHandler(store, &Services{Usage: tracker}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(`{"model":"gpt-4o","input":"Hi","stream":true}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "response.completed") {
+		t.Errorf("expected stream to be relayed, got %q", rec.Body.String())
+	}
+	if totals := tracker.Snapshot(""); len(totals) != 1 || totals[0].PromptTokens != 6 || totals[0].CompletionTokens != 2 {
+		t.Errorf("expected streamed usage to be recorded, got %+v", totals)
+	}
+}
+
+func TestChatCompletionsHandler_GzipUpstream(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("expected the transport to ask for gzip, got %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(`{"choices":[{"message":{"content":"hi"}}],"usage":{"prompt_tokens":5,"completion_tokens":3}}`))
+		gz.Close()
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt-4o": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+		StartTime:  time.Now(),
+	}
+	tracker := usage.NewTracker()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := ChatCompletionsHandler(store, &Services{Usage: tracker}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`))
+	req.Header.Set("Accept-Encoding", "br")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Encoding") != "" || !strings.Contains(rec.Body.String(), `"content":"hi"`) {
+		t.Errorf("expected a decoded response, got %q encoded %q", rec.Body.String(), rec.Header().Get("Content-Encoding"))
+	}
+	if totals := tracker.Snapshot(""); len(totals) != 1 || totals[0].PromptTokens != 5 || totals[0].CompletionTokens != 3 {
+		t.Errorf("expected usage from the compressed response, got %+v", totals)
+	}
+}
+
+func TestChatCompletionsHandler_Tags(t *testing.T) {
+	t.Parallel()
+
+	var metadata string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadata = r.Header.Get("X-Portkey-Metadata")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{"gpt4": {
+			Provider: "openai",
+			APIKey:   "sk-test",
+			Tags:     map[string]string{"team": "platform", "environment": "prod"},
+		}},
+		GatewayURL: gateway.URL,
+	}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := ChatCompletionsHandler(store, &Services{Usage: usage.NewTracker()}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+	req.Header.Set("X-Portkey-Metadata", `{"_user":"u-1","team":"client"}`)
+	ctx := context.WithValue(req.Context(), middleware.ContextKeyProxyKey, models.ProxyKey{Application: "search", Tags: map[string]string{"team": "search"}})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req.WithContext(ctx))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	var got map[string]string
+	if err := json.Unmarshal([]byte(metadata), &got); err != nil {
+		t.Fatalf("invalid metadata %q: %v", metadata, err)
+	}
+	want := map[string]string{"_user": "u-1", "team": "search", "environment": "prod"}
+	if !maps.Equal(got, want) {
+		t.Errorf("expected metadata %v, got %v", want, got)
+	}
+	if !strings.Contains(logs.String(), `"tags":{"environment":"prod","team":"search"}`) {
+		t.Errorf("expected tags in the completion log, got %s", logs.String())
+	}
+}
+
+func TestHandleProxyRequest_LogsResponseHash(t *testing.T) {
+	t.Parallel()
+
+	const responseBody = `{"id":"chatcmpl-1","choices":[]}`
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(responseBody))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:          map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL:      gateway.URL,
+		LogResponseHash: true,
+	}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := ChatCompletionsHandler(store, &Services{Usage: usage.NewTracker()}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	sum := sha256.Sum256([]byte(responseBody))
+	want := hex.EncodeToString(sum[:])
+	if !strings.Contains(logs.String(), `"response_sha256":"`+want+`"`) {
+		t.Errorf("expected response hash %s in logs, got %s", want, logs.String())
+	}
+}
+
+func TestModerationsHandler_AttributesApplication(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/moderations" {
+			t.Errorf("expected gateway path /v1/moderations, got %q", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"modr-1","results":[{"flagged":false}]}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"moderation": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+	}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := ModerationsHandler(store, &Services{Usage: usage.NewTracker()}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/moderations", strings.NewReader(`{"model":"moderation","input":"hello"}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "backend"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if !strings.Contains(logs.String(), `"application":"backend"`) || !strings.Contains(logs.String(), `"endpoint":"/v1/moderations"`) {
+		t.Errorf("expected moderation call to be attributed in logs, got %s", logs.String())
+	}
+}
+
+func TestCanaryProbe(t *testing.T) {
+	t.Parallel()
+
+	var gotBody models.ChatCompletionRequest
+	var gotConfig string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotConfig = r.Header.Get("x-portkey-config")
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+	}
+	probe := CanaryProbe(store)
+
+	status, err := probe(context.Background(), "gpt4")
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("expected successful probe, got status %d, err %v", status, err)
+	}
+	if gotBody.Model != "gpt4" || len(gotBody.Messages) != 1 {
+		t.Errorf("unexpected canary request: %+v", gotBody)
+	}
+	if !strings.Contains(gotConfig, `"provider":"openai"`) {
+		t.Errorf("expected portkey config header, got %q", gotConfig)
+	}
+
+	if _, err := probe(context.Background(), "missing"); err == nil {
+		t.Error("expected error for unknown alias")
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	t.Parallel()
+
+	var gotBody models.ChatCompletionRequest
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		if gotBody.Model == "broken" {
+			writeJSONError(w, "upstream down", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Paris"}}]}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{
+			"gpt4":   {Provider: "openai", APIKey: "sk-test"},
+			"broken": {Provider: "openai", APIKey: "sk-test"},
+		},
+		GatewayURL: gateway.URL,
+	}
+	send := VerifyRequest(store)
+
+	status, content, err := send(context.Background(), "gpt4", "What is the capital of France?")
+	if err != nil || status != http.StatusOK || content != "Paris" {
+		t.Fatalf("got %d %q %v", status, content, err)
+	}
+	if len(gotBody.Messages) != 1 || gotBody.Messages[0].Content != "What is the capital of France?" {
+		t.Errorf("unexpected verify request: %+v", gotBody)
+	}
+
+	status, content, err = send(context.Background(), "broken", "hi")
+	if err != nil || status != http.StatusBadGateway || !strings.Contains(content, "upstream down") {
+		t.Errorf("expected the error body with its status, got %d %q %v", status, content, err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		header        string
+		model         models.ModelConfig
+		serverCeiling time.Duration
+		wantTimeout   time.Duration
+		wantRequested time.Duration
+		wantSource    string
+	}{
+		{
+			name:          "config default under ceiling",
+			serverCeiling: 10 * time.Minute,
+			wantTimeout:   60 * time.Second,
+			wantRequested: 60 * time.Second,
+			wantSource:    "config",
+		},
+		{
+			name:          "config typo clamped by server ceiling",
+			model:         models.ModelConfig{RequestTimeout: 3600000},
+			serverCeiling: 10 * time.Minute,
+			wantTimeout:   10 * time.Minute,
+			wantRequested: time.Hour,
+			wantSource:    "config",
+		},
+		{
+			name:          "alias ceiling tighter than server ceiling",
+			header:        "900000",
+			model:         models.ModelConfig{MaxRequestTimeout: 120000},
+			serverCeiling: 10 * time.Minute,
+			wantTimeout:   2 * time.Minute,
+			wantRequested: 15 * time.Minute,
+			wantSource:    "client",
+		},
+		{
+			name:          "alias ceiling applies without server ceiling",
+			model:         models.ModelConfig{RequestTimeout: 300000, MaxRequestTimeout: 90000},
+			wantTimeout:   90 * time.Second,
+			wantRequested: 5 * time.Minute,
+			wantSource:    "config",
+		},
+		{
+			name:          "short client timeout is honored",
+			header:        "5000",
+			serverCeiling: 10 * time.Minute,
+			wantTimeout:   5 * time.Second,
+			wantRequested: 5 * time.Second,
+			wantSource:    "client",
+		},
+		{
+			name:          "invalid client value falls back to config",
+			header:        "soon",
+			wantTimeout:   60 * time.Second,
+			wantRequested: 60 * time.Second,
+			wantSource:    "config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("x-portkey-request-timeout", tt.header)
+			}
+			timeout, requested, source := requestTimeout(header, tt.model, tt.serverCeiling)
+			if timeout != tt.wantTimeout || requested != tt.wantRequested || source != tt.wantSource {
+				t.Errorf("got (%v, %v, %s), want (%v, %v, %s)", timeout, requested, source, tt.wantTimeout, tt.wantRequested, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestHandleProxyRequest_ClampsClientTimeout(t *testing.T) {
+	t.Parallel()
+
+	var gotTimeout, gotConfig string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTimeout = r.Header.Get("x-portkey-request-timeout")
+		gotConfig = r.Header.Get("x-portkey-config")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{
+			"gpt4": {Provider: "openai", APIKey: "sk-test", RequestTimeout: 3600000, MaxRequestTimeout: 120000},
+		},
+		GatewayURL:        gateway.URL,
+		MaxRequestTimeout: 10 * time.Minute,
+	}
+	svc := &Services{Usage: usage.NewTracker()}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+	req.Header.Set("x-portkey-request-timeout", "3600000")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "batch"))
+	rec := httptest.NewRecorder()
+	ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if gotTimeout != "120000" {
+		t.Errorf("expected client timeout clamped to 120000, got %q", gotTimeout)
+	}
+	if !strings.Contains(gotConfig, `"request_timeout":120000`) {
+		t.Errorf("expected gateway config timeout clamped, got %s", gotConfig)
+	}
+	if !strings.Contains(logs.String(), "request timeout clamped to ceiling") {
+		t.Errorf("expected clamp to be logged, got:\n%s", logs.String())
+	}
+}
+
+func TestHandleProxyRequest_Deadline(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	var gotTimeout, gotDeadline string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		gotTimeout = r.Header.Get("x-portkey-request-timeout")
+		gotDeadline = r.Header.Get(deadlineHeader)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+	}
+	svc := &Services{Usage: usage.NewTracker()}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	send := func(deadline string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+		req.Header.Set(deadlineHeader, deadline)
+		rec := httptest.NewRecorder()
+		ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(time.Now().Add(5 * time.Second).Format(time.RFC3339Nano)); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if ms, err := strconv.Atoi(gotTimeout); err != nil || ms <= 0 || ms > 5000 {
+		t.Errorf("expected the remaining time as the gateway timeout, got %q", gotTimeout)
+	}
+	if gotDeadline != "" {
+		t.Errorf("expected the deadline header to be consumed, got %q", gotDeadline)
+	}
+
+	if rec := send(time.Now().Add(-time.Second).Format(time.RFC3339Nano)); rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504 for a passed deadline, got %d", rec.Code)
+	}
+	if rec := send("tomorrow"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid deadline, got %d", rec.Code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected only the request within its deadline to reach the gateway, got %d calls", calls.Load())
+	}
+	if totals := svc.Usage.Snapshot(""); len(totals) != 1 || totals[0].Timeouts != 1 {
+		t.Errorf("expected one timeout termination, got %+v", totals)
+	}
+}
+
+func TestHandleProxyRequest_Terminations(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		timeout    string
+		cancel     bool
+		hangUp     bool
+		wantStatus int
+		wantReason string
+	}{
+		{name: "client cancels while waiting for headers", cancel: true, wantReason: usage.TerminationClientCancelled},
+		{name: "timeout while waiting for headers", timeout: "50", wantStatus: http.StatusGatewayTimeout, wantReason: usage.TerminationTimeout},
+		{name: "gateway drops the connection", hangUp: true, wantStatus: http.StatusBadGateway, wantReason: usage.TerminationUpstreamError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			upstreamCancelled := make(chan struct{})
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.hangUp {
+					conn, _, _ := http.NewResponseController(w).Hijack()
+					conn.Close()
+					return
+				}
+				// The server notices a closed connection once the body is read
+				io.Copy(io.Discard, r.Body)
+				<-r.Context().Done()
+				close(upstreamCancelled)
+			}))
+			defer gateway.Close()
+
+			store := &models.ConfigStore{
+				Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test", ProxyRetries: new(int)}},
+				GatewayURL: gateway.URL,
+			}
+			svc := &Services{Usage: usage.NewTracker()}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+			if tt.timeout != "" {
+				req.Header.Set("x-portkey-request-timeout", tt.timeout)
+			}
+			if tt.cancel {
+				time.AfterFunc(50*time.Millisecond, cancel)
+			}
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			if tt.wantStatus != 0 && rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if !tt.hangUp {
+				select {
+				case <-upstreamCancelled:
+				case <-time.After(2 * time.Second):
+					t.Error("expected the gateway request to be cancelled")
+				}
+			}
+			totals := svc.Usage.Snapshot("")
+			if len(totals) != 1 {
+				t.Fatalf("expected one usage row, got %+v", totals)
+			}
+			got := map[string]int64{
+				usage.TerminationClientCancelled: totals[0].ClientCancellations,
+				usage.TerminationTimeout:         totals[0].Timeouts,
+				usage.TerminationUpstreamError:   totals[0].UpstreamErrors,
+			}
+			for reason, n := range got {
+				want := int64(0)
+				if reason == tt.wantReason {
+					want = 1
+				}
+				if n != want {
+					t.Errorf("expected %d %s terminations, got %d", want, reason, n)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleProxyRequest_StreamLimit(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+	}
+	svc := &Services{Usage: usage.NewTracker(), Streams: streamlimit.New(), Report: report.NewRecorder()}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := ChatCompletionsHandler(store, svc, logger)
+
+	// Hold the only slot, as an in-flight stream would
+	release, _, _ := svc.Streams.Acquire("agent", 1)
+
+	newRequest := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "agent")
+		ctx = context.WithValue(ctx, middleware.ContextKeyProxyKey, models.ProxyKey{Application: "agent", MaxStreams: 1})
+		return req.WithContext(ctx)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest(`{"model":"gpt4","messages":[],"stream":true}`))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", rec.Code)
+	}
+	var limitErr models.StreamLimitError
+	if err := json.Unmarshal(rec.Body.Bytes(), &limitErr); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if limitErr.ActiveStreams != 1 || limitErr.MaxStreams != 1 {
+		t.Errorf("unexpected limit error: %+v", limitErr)
+	}
+
+	// Non-streaming requests are not counted
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest(`{"model":"gpt4","messages":[]}`))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected non-streaming request to pass, got %d", rec.Code)
+	}
+
+	// Once the slot frees up, streaming succeeds and releases its own slot
+	release()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest(`{"model":"gpt4","messages":[],"stream":true}`))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected stream to be allowed, got %d", rec.Code)
+	}
+	if svc.Streams.Active("agent") != 0 {
+		t.Errorf("expected slot to be released after stream, got %d active", svc.Streams.Active("agent"))
+	}
+
+	// Every request, including the rejected one, is in the shutdown report
+	summary := svc.Report.Report(time.Now(), time.Now(), 0, 0)
+	if summary.Requests != 3 || summary.Aliases["gpt4"] != 3 || summary.ClientErrors != 1 {
+		t.Errorf("unexpected shutdown report: %+v", summary)
+	}
+	if svc.Report.ActiveStreams() != 0 {
+		t.Errorf("expected no streams in flight, got %d", svc.Report.ActiveStreams())
+	}
+}
+
+func TestHandleProxyRequest_NDJSON(t *testing.T) {
+	t.Parallel()
+
+	var gotAccept string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: message_start\ndata: {\"type\": \"message_start\"}\n\n"))
+		w.Write([]byte(": keep-alive\n\ndata: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\r\n\r\n"))
+		w.Write([]byte("data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":1}}\n\ndata: [DONE]\n\n"))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+	}
+	svc := &Services{Usage: usage.NewTracker()}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := ChatCompletionsHandler(store, svc, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[],"stream":true,"stream_options":{"include_usage":true}}`))
+	req.Header.Set("Accept", "application/x-ndjson")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "shell"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if gotAccept != "text/event-stream" {
+		t.Errorf("expected gateway to be asked for SSE, got Accept %q", gotAccept)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected NDJSON content type, got %q", ct)
+	}
+	want := `{"type":"message_start"}` + "\n" +
+		`{"choices":[{"delta":{"content":"hi"}}]}` + "\n" +
+		`{"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":1}}` + "\n"
+	if rec.Body.String() != want {
+		t.Errorf("unexpected NDJSON body:\n%s", rec.Body.String())
+	}
+	if totals := svc.Usage.Snapshot("shell"); len(totals) != 1 || totals[0].PromptTokens != 3 {
+		t.Errorf("expected usage to be tracked from the upstream stream, got %+v", totals)
+	}
+}
+
+func TestHandleProxyRequest_InjectStreamUsage(t *testing.T) {
+	t.Parallel()
+
+	optOut := false
+	tests := []struct {
+		name         string
+		model        models.ModelConfig
+		body         string
+		wantInjected bool
+		wantChunk    bool
+	}{
+		{
+			name:         "injected and hidden",
+			model:        models.ModelConfig{Provider: "openai", APIKey: "sk-test"},
+			body:         `{"model":"gpt4","messages":[],"stream":true}`,
+			wantInjected: true,
+		},
+		{
+			name:      "client asked for usage",
+			model:     models.ModelConfig{Provider: "openai", APIKey: "sk-test"},
+			body:      `{"model":"gpt4","messages":[],"stream":true,"stream_options":{"include_usage":true}}`,
+			wantChunk: true,
+		},
+		{
+			name:  "alias opted out",
+			model: models.ModelConfig{Provider: "openai", APIKey: "sk-test", InjectStreamUsage: &optOut},
+			body:  `{"model":"gpt4","messages":[],"stream":true}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotBody []byte
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotBody, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}],\"usage\":null}\n\n"))
+				if bytes.Contains(gotBody, []byte(`"include_usage":true`)) {
+					w.Write([]byte("data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":1}}\n\n"))
+				}
+				w.Write([]byte("data: [DONE]\n\n"))
+			}))
+			defer gateway.Close()
+
+			store := &models.ConfigStore{
+				Models:     map[string]models.ModelConfig{"gpt4": tt.model},
+				GatewayURL: gateway.URL,
+			}
+			svc := &Services{Usage: usage.NewTracker()}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "chat"))
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			injected := bytes.Contains(gotBody, []byte(`"include_usage":true`)) && !strings.Contains(tt.body, "include_usage")
+			if injected != tt.wantInjected {
+				t.Errorf("expected injected=%v, gateway got %s", tt.wantInjected, gotBody)
+			}
+			if hasChunk := strings.Contains(rec.Body.String(), `"prompt_tokens"`); hasChunk != tt.wantChunk {
+				t.Errorf("expected usage chunk relayed=%v, got:\n%s", tt.wantChunk, rec.Body.String())
+			}
+			if !strings.HasSuffix(rec.Body.String(), "data: [DONE]\n\n") {
+				t.Errorf("expected stream to end with [DONE], got:\n%s", rec.Body.String())
+			}
+
+			wantTokens := int64(0)
+			if tt.wantInjected || tt.wantChunk {
+				wantTokens = 3
+			}
+			totals := svc.Usage.Snapshot("chat")
+			if len(totals) != 1 || totals[0].PromptTokens != wantTokens {
+				t.Errorf("expected %d prompt tokens tracked, got %+v", wantTokens, totals)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsHandler_SemanticCache(t *testing.T) {
+	t.Parallel()
+
+	var completions atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/embeddings" {
+			var req struct {
+				Input string `json:"input"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			vector := "[0, 1]"
+			if strings.Contains(req.Input, "refund") {
+				vector = "[1, 0.05]"
+			}
+			w.Write([]byte(`{"data":[{"embedding":` + vector + `}]}`))
+			return
+		}
+		n := completions.Add(1)
+		w.Write([]byte(fmt.Sprintf(`{"id":"chatcmpl-%d","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2}}`, n)))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{
+			"rag":   {Provider: "openai", APIKey: "sk-test", SemanticCache: true},
+			"embed": {Provider: "openai", APIKey: "sk-test"},
+		},
+		GatewayURL: gateway.URL,
+	}
+	svc := &Services{
+		Usage: usage.NewTracker(),
+		Cache: semcache.New(SemanticCacheEmbedder(store, "embed"), semcache.NewMemory(10, time.Hour), 0.95),
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := ChatCompletionsHandler(store, svc, logger)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "support"))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(`{"model":"rag","messages":[{"role":"user","content":"What is the refund policy?"}]}`)
+	if rec.Header().Get("X-Portus-Cache") != "miss" || !strings.Contains(rec.Body.String(), "chatcmpl-1") {
+		t.Fatalf("expected first request to miss, got %q: %s", rec.Header().Get("X-Portus-Cache"), rec.Body.String())
+	}
+
+	rec = send(`{"model":"rag","messages":[{"role":"user","content":"what's your refund policy"}]}`)
+	if rec.Header().Get("X-Portus-Cache") != "hit" || !strings.Contains(rec.Body.String(), "chatcmpl-1") {
+		t.Errorf("expected near-duplicate to be served from cache, got %q: %s", rec.Header().Get("X-Portus-Cache"), rec.Body.String())
+	}
+	if rec.Header().Get("X-Portus-Cache-Similarity") == "" {
+		t.Error("expected similarity header on cache hit")
+	}
+
+	// Different request parameters never share answers
+	rec = send(`{"model":"rag","messages":[{"role":"user","content":"What is the refund policy?"}],"temperature":1.5}`)
+	if rec.Header().Get("X-Portus-Cache") != "miss" {
+		t.Errorf("expected different parameters to miss, got %q", rec.Header().Get("X-Portus-Cache"))
+	}
+
+	// Unrelated prompts reach the provider
+	send(`{"model":"rag","messages":[{"role":"user","content":"How do I reset my password?"}]}`)
+	if completions.Load() != 3 {
+		t.Errorf("expected 3 provider calls, got %d", completions.Load())
+	}
+
+	totals := svc.Usage.Snapshot("support")
+	if len(totals) != 1 || totals[0].Requests != 4 || totals[0].CacheHits != 1 {
+		t.Errorf("unexpected usage totals: %+v", totals)
+	}
+}
+
+func TestHandleProxyRequest_CircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeJSONError(w, "provider down", http.StatusBadGateway)
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	svc := &Services{Usage: usage.NewTracker(), Breaker: breaker.New(2, time.Minute, logger)}
+	handler := ChatCompletionsHandler(store, svc, logger)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := send(); rec.Code != http.StatusBadGateway {
+			t.Fatalf("expected upstream 502 to be relayed, got %d", rec.Code)
+		}
+	}
+
+	rec := send()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected open circuit to fail fast with 503, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected Retry-After 60, got %q", rec.Header().Get("Retry-After"))
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected the gateway not to be called while open, got %d calls", calls.Load())
+	}
+}
+
+func TestHandleProxyRequest_CredentialFailures(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeJSONError(w, "invalid api key", http.StatusUnauthorized)
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-revoked"}, "claude": {Provider: "anthropic", APIKey: "sk-ant"}},
+		GatewayURL: gateway.URL,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	svc := &Services{Usage: usage.NewTracker(), Credentials: credguard.New(2, 0, true, nil, logger)}
+	handler := ChatCompletionsHandler(store, svc, logger)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := send(); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected upstream 401 to be relayed, got %d", rec.Code)
+		}
+	}
+	if rec := send(); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "rejecting the credentials") {
+		t.Fatalf("expected degraded alias to fail fast with 503, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected the gateway not to be called while degraded, got %d calls", calls.Load())
+	}
+
+	// Degraded aliases are hidden from the model list
+	rec := httptest.NewRecorder()
+	ModelsHandler(store, svc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	if strings.Contains(rec.Body.String(), `"gpt4"`) || !strings.Contains(rec.Body.String(), `"claude"`) {
+		t.Errorf("expected only the degraded alias to be hidden, got %s", rec.Body.String())
+	}
+
+	// Fixing the key lets requests through again
+	store.Models["gpt4"] = models.ModelConfig{Provider: "openai", APIKey: "sk-rotated"}
+	send()
+	if calls.Load() != 3 {
+		t.Errorf("expected a changed config to reach the gateway, got %d calls", calls.Load())
+	}
+}
+
+func TestHandleProxyRequest_ProxyRetries(t *testing.T) {
+	t.Parallel()
+
+	noRetries := 0
+	tests := []struct {
+		name         string
+		failures     int32
+		retries      int
+		aliasRetries *int
+		wantStatus   int
+		wantCalls    int32
+	}{
+		{name: "recovers after 5xx", failures: 2, retries: 2, wantStatus: http.StatusOK, wantCalls: 3},
+		{name: "retries exhausted", failures: 5, retries: 1, wantStatus: http.StatusServiceUnavailable, wantCalls: 2},
+		{name: "alias disables retries", failures: 1, retries: 3, aliasRetries: &noRetries, wantStatus: http.StatusServiceUnavailable, wantCalls: 1},
+		{name: "disabled by default", failures: 1, wantStatus: http.StatusServiceUnavailable, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int32
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if !strings.Contains(string(body), `"gpt4"`) {
+					t.Errorf("expected request body on every attempt, got %q", body)
+				}
+				if calls.Add(1) <= tt.failures {
+					writeJSONError(w, "overloaded", http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"choices":[]}`))
+			}))
+			defer gateway.Close()
+
+			store := &models.ConfigStore{
+				Models: map[string]models.ModelConfig{
+					"gpt4": {Provider: "openai", APIKey: "sk-test", ProxyRetries: tt.aliasRetries},
+				},
+				GatewayURL:        gateway.URL,
+				ProxyRetries:      tt.retries,
+				ProxyRetryBackoff: time.Millisecond,
+			}
+			svc := &Services{Usage: usage.NewTracker()}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("expected %d gateway calls, got %d", tt.wantCalls, calls.Load())
+			}
+		})
+	}
+}
+
+func TestHandleProxyRequest_ProxyRetriesConnectionFailure(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.NotFoundHandler())
+	gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:            map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL:        gateway.URL,
+		ProxyRetries:      2,
+		ProxyRetryBackoff: time.Millisecond,
+	}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+	rec := httptest.NewRecorder()
+	ChatCompletionsHandler(store, &Services{Usage: usage.NewTracker()}, logger).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 after retries, got %d", rec.Code)
+	}
+	if n := strings.Count(logs.String(), "retrying gateway request"); n != 2 {
+		t.Errorf("expected 2 retries to be logged, got %d", n)
+	}
+}
+
+func TestHandleProxyRequest_LimitBurstAndGrace(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		burst      int
+		graceUntil time.Time
+		wantStatus int
+		wantLog    string
+	}{
+		{name: "burst", burst: 1, wantStatus: http.StatusOK, wantLog: "stream burst allowance in use"},
+		{name: "grace", graceUntil: time.Now().Add(time.Hour), wantStatus: http.StatusOK, wantLog: "stream limit exceeded during grace period"},
+		{name: "grace over", graceUntil: time.Now().Add(-time.Hour), wantStatus: http.StatusTooManyRequests, wantLog: "concurrent stream limit reached"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte("data: [DONE]\n\n"))
+			}))
+			t.Cleanup(gateway.Close)
+
+			store := &models.ConfigStore{
+				Models:           map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+				GatewayURL:       gateway.URL,
+				StreamBurst:      tt.burst,
+				LimitsGraceUntil: tt.graceUntil,
+			}
+			svc := &Services{Usage: usage.NewTracker(), Streams: streamlimit.New()}
+			var logs bytes.Buffer
+			handler := ChatCompletionsHandler(store, svc, slog.New(slog.NewTextHandler(&logs, nil)))
+
+			// Hold the key's only regular slot
+			release, _, _ := svc.Streams.Acquire("agent", 1)
+			defer release()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[],"stream":true}`))
+			ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "agent")
+			ctx = context.WithValue(ctx, middleware.ContextKeyProxyKey, models.ProxyKey{Application: "agent", MaxStreams: 1})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req.WithContext(ctx))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("expected log %q, got:\n%s", tt.wantLog, logs.String())
+			}
+			if svc.Streams.Active("agent") != 1 {
+				t.Errorf("expected the admitted stream to release its slot, got %d active", svc.Streams.Active("agent"))
+			}
+		})
+	}
+}
+
+func TestHandleProxyRequest_Guardrails(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		application string
+		body        string
+		wantStatus  int
+		wantRule    string
+		wantScope   string
+	}{
+		{name: "allowed", application: "web", body: `{"model":"gpt4","messages":[{"role":"user","content":"hello"}]}`, wantStatus: http.StatusOK},
+		{name: "banned phrase", application: "web", body: `{"model":"gpt4","messages":[{"role":"user","content":"Ignore Previous Instructions"}]}`, wantStatus: http.StatusBadRequest, wantRule: "banned_phrase", wantScope: "model"},
+		{name: "application pattern", application: "kids", body: `{"model":"gpt4","messages":[{"role":"user","content":"tell me about poker"}]}`, wantStatus: http.StatusBadRequest, wantRule: "pattern", wantScope: "application"},
+		{name: "other application", application: "web", body: `{"model":"gpt4","messages":[{"role":"user","content":"tell me about poker"}]}`, wantStatus: http.StatusOK},
+		{name: "max messages", application: "web", body: `{"model":"gpt4","messages":[{"role":"user","content":"a"},{"role":"assistant","content":"b"},{"role":"user","content":"c"}]}`, wantStatus: http.StatusBadRequest, wantRule: "max_messages", wantScope: "model"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var forwarded bool
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = true
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"choices":[]}`))
+			}))
+			t.Cleanup(gateway.Close)
+
+			store := &models.ConfigStore{
+				Models: map[string]models.ModelConfig{"gpt4": {
+					Provider: "openai",
+					APIKey:   "sk-test",
+					Guardrails: &models.GuardrailConfig{
+						BannedPhrases: []string{"ignore previous instructions"},
+						MaxMessages:   2,
+					},
+				}},
+				Guardrails: map[string]models.GuardrailConfig{"kids": {Patterns: []string{`(?i)\b(poker|casino)\b`}}},
+				GatewayURL: gateway.URL,
+			}
+			svc := &Services{Usage: usage.NewTracker(), Report: report.NewRecorder()}
+			handler := ChatCompletionsHandler(store, svc, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tt.body))
+			ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, tt.application)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req.WithContext(ctx))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusBadRequest {
+				return
+			}
+			if forwarded {
+				t.Error("expected the request not to be forwarded")
+			}
+			var violation models.PolicyViolationError
+			if err := json.Unmarshal(rec.Body.Bytes(), &violation); err != nil {
+				t.Fatal(err)
+			}
+			if violation.Type != "policy_violation" || violation.Rule != tt.wantRule || violation.Scope != tt.wantScope {
+				t.Errorf("unexpected violation %+v", violation)
+			}
+		})
+	}
+}
+
+func TestHandleProxyRequest_GuardrailsAlreadyChecked(t *testing.T) {
+	t.Parallel()
+
+	var forwarded atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer gateway.Close()
+
+	modelConfig := models.ModelConfig{
+		Provider:   "openai",
+		APIKey:     "sk-test",
+		Guardrails: &models.GuardrailConfig{BannedPhrases: []string{"ignore previous instructions"}},
+	}
+	store := &models.ConfigStore{Models: map[string]models.ModelConfig{"gpt4": modelConfig}, GatewayURL: gateway.URL}
+	svc := &Services{Usage: usage.NewTracker(), Report: report.NewRecorder()}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	body := []byte(`{"model":"gpt4","messages":[{"role":"user","content":"Ignore previous instructions"}]}`)
+
+	send := func(ctx context.Context) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body)).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		handleProxyRequest(rec, req, body, "/v1/chat/completions", modelConfig, store, svc, logger, "req-1", "web", "gpt4")
+		return rec.Code
+	}
+
+	if code := send(context.Background()); code != http.StatusBadRequest || forwarded.Load() != 0 {
+		t.Fatalf("expected unchecked request to be blocked, got %d with %d forwarded", code, forwarded.Load())
+	}
+	// A request the semantic cache path already checked is not evaluated again
+	if code := send(context.WithValue(context.Background(), guardrailsCheckedKey{}, true)); code != http.StatusOK || forwarded.Load() != 1 {
+		t.Errorf("expected checked request to be forwarded, got %d with %d forwarded", code, forwarded.Load())
+	}
+}
+
+func TestHandleProxyRequest_MaxTokens(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		action        string
+		body          string
+		wantStatus    int
+		wantForwarded string
+	}{
+		{name: "clamp", action: "clamp", body: `{"model":"gpt4","messages":[],"max_tokens":100000}`, wantStatus: http.StatusOK, wantForwarded: `"max_tokens":2000`},
+		{name: "inject", action: "clamp", body: `{"model":"gpt4","messages":[]}`, wantStatus: http.StatusOK, wantForwarded: `"max_tokens":2000`},
+		{name: "within limit", action: "reject", body: `{"model":"gpt4","messages":[],"max_tokens":500}`, wantStatus: http.StatusOK, wantForwarded: `"max_tokens":500`},
+		{name: "reject", action: "reject", body: `{"model":"gpt4","messages":[],"max_tokens":100000}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var forwarded string
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				forwarded = string(body)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"choices":[]}`))
+			}))
+			t.Cleanup(gateway.Close)
+
+			store := &models.ConfigStore{
+				Models:          map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+				GatewayURL:      gateway.URL,
+				MaxTokensAction: tt.action,
+			}
+			svc := &Services{Usage: usage.NewTracker(), Report: report.NewRecorder()}
+			handler := ChatCompletionsHandler(store, svc, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tt.body))
+			ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "tool")
+			ctx = context.WithValue(ctx, middleware.ContextKeyProxyKey, models.ProxyKey{Application: "tool", MaxTokens: 2000})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req.WithContext(ctx))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				var limitErr models.MaxTokensError
+				if err := json.Unmarshal(rec.Body.Bytes(), &limitErr); err != nil || limitErr.Requested != 100000 || limitErr.MaxTokens != 2000 {
+					t.Errorf("unexpected error body %s", rec.Body.String())
+				}
+				if forwarded != "" {
+					t.Error("expected the request not to be forwarded")
+				}
+				return
+			}
+			if !strings.Contains(forwarded, tt.wantForwarded) {
+				t.Errorf("expected forwarded body to contain %s, got %s", tt.wantForwarded, forwarded)
+			}
+		})
+	}
+}
+
+func TestHandleProxyRequest_ModelConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		maxQueue   int
+		wantQueued int
+		wantSecond int
+	}{
+		{name: "queue full", maxQueue: 0, wantSecond: http.StatusTooManyRequests},
+		{name: "queued until slot frees", maxQueue: 1, wantQueued: 1, wantSecond: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			entered := make(chan struct{}, 2)
+			unblock := make(chan struct{})
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				entered <- struct{}{}
+				<-unblock
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"choices":[]}`))
+			}))
+			defer gateway.Close()
+
+			store := &models.ConfigStore{
+				Models: map[string]models.ModelConfig{
+					"gpt4": {Provider: "openai", APIKey: "sk-test", MaxConcurrent: 1, MaxQueue: tt.maxQueue},
+				},
+				GatewayURL: gateway.URL,
+			}
+			svc := &Services{Usage: usage.NewTracker(), Concurrency: concurrency.New()}
+			handler := ChatCompletionsHandler(store, svc, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			send := func() int {
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			first := make(chan int)
+			go func() { first <- send() }()
+			<-entered
+
+			second := make(chan int)
+			go func() { second <- send() }()
+			if tt.wantQueued > 0 {
+				for {
+					if _, queued := svc.Concurrency.Stats("gpt4"); queued == tt.wantQueued {
+						break
+					}
+					time.Sleep(time.Millisecond)
+				}
+				close(unblock)
+			} else {
+				if code := <-second; code != tt.wantSecond {
+					t.Errorf("expected second request status %d, got %d", tt.wantSecond, code)
+				}
+				close(unblock)
+				second = nil
+			}
+
+			if code := <-first; code != http.StatusOK {
+				t.Errorf("expected first request to succeed, got %d", code)
+			}
+			if second != nil {
+				if code := <-second; code != tt.wantSecond {
+					t.Errorf("expected second request status %d, got %d", tt.wantSecond, code)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyTranslated(t *testing.T) {
+	t.Parallel()
+
+	const (
+		anthropicJSON = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet","content":[{"type":"text","text":"Hello"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":2}}`
+		anthropicSSE  = "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude-sonnet\",\"usage\":{\"input_tokens\":3}}}\n\n" +
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hello\"}}\n\n" +
+			"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":2}}\n\n" +
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+		chatJSON = `{"id":"c1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`
+	)
+
+	tests := []struct {
+		name         string
+		apiFormat    string
+		handler      func(*models.ConfigStore, *Services, *slog.Logger) http.HandlerFunc
+		path         string
+		body         string
+		upstreamPath string
+		upstreamBody string
+		upstreamType string
+		status       int
+		wantContains []string
+	}{
+		{
+			name: "chat client to anthropic upstream", apiFormat: "anthropic",
+			handler: ChatCompletionsHandler, path: "/v1/chat/completions",
+			body:         `{"model":"claude","messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"Hi"}]}`,
+			upstreamPath: "/v1/messages", upstreamBody: anthropicJSON, upstreamType: "application/json", status: http.StatusOK,
+			wantContains: []string{`"object":"chat.completion"`, `"content":"Hello"`, `"finish_reason":"stop"`, `"total_tokens":5`},
+		},
+		{
+			name: "chat stream from anthropic upstream", apiFormat: "anthropic",
+			handler: ChatCompletionsHandler, path: "/v1/chat/completions",
+			body:         `{"model":"claude","stream":true,"messages":[{"role":"user","content":"Hi"}]}`,
+			upstreamPath: "/v1/messages", upstreamBody: anthropicSSE, upstreamType: "text/event-stream", status: http.StatusOK,
+			wantContains: []string{`"object":"chat.completion.chunk"`, `"content":"Hello"`, "data: [DONE]"},
+		},
+		{
+			name: "messages client to openai upstream", apiFormat: "openai",
+			handler: MessagesHandler, path: "/v1/messages",
+			body:         `{"model":"claude","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`,
+			upstreamPath: "/v1/chat/completions", upstreamBody: chatJSON, upstreamType: "application/json", status: http.StatusOK,
+			wantContains: []string{`"type":"message"`, `"text":"Hello"`, `"stop_reason":"end_turn"`, `"input_tokens":3`},
+		},
+		{
+			name: "upstream errors are relayed unchanged", apiFormat: "anthropic",
+			handler: ChatCompletionsHandler, path: "/v1/chat/completions",
+			body:         `{"model":"claude","messages":[{"role":"user","content":"Hi"}]}`,
+			upstreamPath: "/v1/messages", upstreamBody: `{"type":"error","error":{"type":"invalid_request_error"}}`, upstreamType: "application/json", status: http.StatusBadRequest,
+			wantContains: []string{`"invalid_request_error"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.upstreamPath {
+					t.Errorf("expected upstream path %s, got %s", tt.upstreamPath, r.URL.Path)
+				}
+				body, _ := io.ReadAll(r.Body)
+				if tt.upstreamPath == "/v1/messages" && !strings.Contains(string(body), `"max_tokens":4096`) {
+					t.Errorf("expected default max_tokens in translated request, got %s", body)
+				}
+				w.Header().Set("Content-Type", tt.upstreamType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.upstreamBody))
+			}))
+			defer gateway.Close()
+
+			store := &models.ConfigStore{
+				Models:     map[string]models.ModelConfig{"claude": {Provider: "anthropic", APIKey: "sk-test", APIFormat: tt.apiFormat}},
+				GatewayURL: gateway.URL,
+			}
+			svc := &Services{Usage: usage.NewTracker()}
+			handler := tt.handler(store, svc, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "app"))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("expected response to contain %s, got %s", want, rec.Body.String())
+				}
+			}
+			if tt.status == http.StatusOK {
+				if totals := svc.Usage.Snapshot("app"); len(totals) != 1 || totals[0].TotalTokens != 5 {
+					t.Errorf("expected usage to be recorded from the upstream response, got %+v", totals)
+				}
+			}
+		})
+	}
+}
+
+func TestWantsNDJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"text/event-stream", false},
+		{"application/x-ndjson", true},
+		{"application/json, application/x-ndjson;q=0.9", true},
+		{"Application/X-NDJSON", true},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.accept != "" {
+			header.Set("Accept", tt.accept)
+		}
+		if got := wantsNDJSON(header); got != tt.want {
+			t.Errorf("wantsNDJSON(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestHandleProxyRequest_NormalizeFinishReasons(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `{"choices":[{"index":0,"finish_reason":"max_tokens"}]}`,
+			want:        `"finish_reason":"length"`,
+		},
+		{
+			name:        "stream",
+			contentType: "text/event-stream",
+			body:        "data: {\"choices\":[{\"index\":0,\"finish_reason\":\"tool_use\"}]}\n\ndata: [DONE]\n\n",
+			want:        `"finish_reason":"tool_calls","index":0,"native_finish_reason":"tool_use"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				w.Write([]byte(tt.body))
+			}))
+			defer gateway.Close()
+
+			store := &models.ConfigStore{
+				Models:                 map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+				GatewayURL:             gateway.URL,
+				NormalizeFinishReasons: true,
+			}
+			svc := &Services{Usage: usage.NewTracker()}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("expected body to contain %s, got %s", tt.want, rec.Body.String())
+			}
+			if cl := rec.Header().Get("Content-Length"); cl != "" && cl != strconv.Itoa(rec.Body.Len()) {
+				t.Errorf("stale Content-Length %s for %d byte body", cl, rec.Body.Len())
+			}
+		})
+	}
+}
+
+func TestMessagesHandler_RepairsEventSequence(t *testing.T) {
+	t.Parallel()
+
+	stream := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":3,\"output_tokens\":1}}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"hi\"}}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":2}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(stream)))
+		w.Write([]byte(stream))
+	}))
+	t.Cleanup(gateway.Close)
+
+	store := &models.ConfigStore{
+		Models:                  map[string]models.ModelConfig{"claude": {Provider: "anthropic", APIKey: "sk-ant-test", APIFormat: "anthropic"}},
+		GatewayURL:              gateway.URL,
+		MessageStreamValidation: messagestream.ModeRepair,
+	}
+	svc := &Services{Usage: usage.NewTracker()}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	MessagesHandler(store, svc, logger).ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	stop := strings.Index(body, "event: content_block_stop")
+	if stop < 0 || stop > strings.Index(body, "event: message_delta") {
+		t.Errorf("expected content_block_stop before message_delta:\n%s", body)
+	}
+	if cl := rec.Header().Get("Content-Length"); cl != "" {
+		t.Errorf("expected no Content-Length on a repaired stream, got %s", cl)
+	}
+	if !strings.Contains(logs.String(), "invalid Anthropic event sequence") {
+		t.Errorf("expected violation to be logged:\n%s", logs.String())
+	}
+}
+
+func TestHandleProxyRequest_GatewayRoutes(t *testing.T) {
+	t.Parallel()
+
+	newGateway := func(name string) *httptest.Server {
+		gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"gateway":"` + name + `"}`))
+		}))
+		t.Cleanup(gateway.Close)
+		return gateway
+	}
+	defaultGateway, euGateway := newGateway("default"), newGateway("eu")
+
+	route, err := models.NewGatewayRoute("eu-*", euGateway.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := models.ModelConfig{Provider: "openai", APIKey: "sk-test"}
+	store := &models.ConfigStore{
+		Models:        map[string]models.ModelConfig{"gpt4": model, "eu-gpt4": model},
+		GatewayURL:    defaultGateway.URL,
+		GatewayRoutes: []models.GatewayRoute{route},
+	}
+	svc := &Services{Usage: usage.NewTracker()}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for alias, want := range map[string]string{"gpt4": "default", "eu-gpt4": "eu"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"`+alias+`","messages":[]}`))
+		rec := httptest.NewRecorder()
+		ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+		if !strings.Contains(rec.Body.String(), `"gateway":"`+want+`"`) {
+			t.Errorf("expected %s to be served by the %s gateway, got %s", alias, want, rec.Body.String())
+		}
+	}
+}
+
+func TestHandleProxyRequest_PostProcess(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			name:        "json",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"choices":[{"index":0,"message":{"role":"assistant","content":"<answer> 42 </answer>\nEND"}}]}`,
+			want:        `"content":"42"`,
+		},
+		{
+			name:        "stream untouched",
+			status:      http.StatusOK,
+			contentType: "text/event-stream",
+			body:        "data: {\"choices\":[{\"delta\":{\"content\":\" 42 END\"}}]}\n\ndata: [DONE]\n\n",
+			want:        `" 42 END"`,
+		},
+		{
+			name:        "error untouched",
+			status:      http.StatusBadRequest,
+			contentType: "application/json",
+			body:        `{"error":{"message":"bad"},"choices":[{"text":" END"}]}`,
+			want:        `" END"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer gateway.Close()
+
+			model := models.ModelConfig{
+				Provider: "openai",
+				APIKey:   "sk-test",
+				PostProcess: &models.PostProcessConfig{
+					StopSequences: []string{"END"},
+					StripTags:     []string{"answer"},
+					Whitespace:    "trim",
+				},
+			}
+			store := &models.ConfigStore{Models: map[string]models.ModelConfig{"gpt4": model}, GatewayURL: gateway.URL}
+			svc := &Services{Usage: usage.NewTracker()}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("expected body to contain %s, got %s", tt.want, rec.Body.String())
+			}
+			if cl := rec.Header().Get("Content-Length"); cl != "" && cl != strconv.Itoa(rec.Body.Len()) {
+				t.Errorf("stale Content-Length %s for %d byte body", cl, rec.Body.Len())
+			}
+		})
+	}
+}
+
+func TestHandleProxyRequest_Fallback(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		modelCfg   models.ModelConfig
+		global     string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "alias message",
+			modelCfg:   models.ModelConfig{Provider: "openai", APIKey: "sk-test", FallbackMessage: "alias sorry"},
+			global:     "global sorry",
+			wantStatus: http.StatusOK,
+			wantBody:   "alias sorry",
+		},
+		{
+			name:       "global message",
+			modelCfg:   models.ModelConfig{Provider: "openai", APIKey: "sk-test"},
+			global:     "global sorry",
+			wantStatus: http.StatusOK,
+			wantBody:   "global sorry",
+		},
+		{
+			name:       "not configured",
+			modelCfg:   models.ModelConfig{Provider: "openai", APIKey: "sk-test"},
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   "all targets failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"error":"all targets failed"}`, http.StatusServiceUnavailable)
+			}))
+			defer failing.Close()
+
+			store := &models.ConfigStore{
+				Models:          map[string]models.ModelConfig{"gpt4": tt.modelCfg},
+				GatewayURL:      failing.URL,
+				FallbackMessage: tt.global,
+			}
+			svc := &Services{Usage: usage.NewTracker()}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "web"))
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("expected body to contain %q, got %s", tt.wantBody, rec.Body.String())
+			}
+			wantFallback := tt.wantStatus == http.StatusOK
+			if (rec.Header().Get("X-Portus-Fallback") == "true") != wantFallback {
+				t.Errorf("unexpected X-Portus-Fallback header %q", rec.Header().Get("X-Portus-Fallback"))
+			}
+			totals := svc.Usage.Snapshot("web")
+			if wantFallback && (len(totals) != 1 || totals[0].FallbackResponses != 1) {
+				t.Errorf("expected fallback to be counted, got %+v", totals)
+			}
+		})
+	}
+}
+
+func TestHandleProxyRequest_AnnotateResponses(t *testing.T) {
+	t.Parallel()
+
+	multiTarget := models.ModelConfig{
+		Strategy: &models.StrategyConfig{Mode: "fallback"},
+		Targets:  []models.TargetConfig{{Provider: "anthropic"}, {Provider: "bedrock"}},
+	}
+	tests := []struct {
+		name     string
+		model    models.ModelConfig
+		body     string
+		accept   string
+		annotate bool
+		want     string
+	}{
+		{
+			name:     "json with target and retry",
+			model:    multiTarget,
+			body:     `{"model":"m","messages":[]}`,
+			annotate: true,
+			want:     `{"choices":[],"portus":{"alias":"m","provider":"bedrock","target_index":1,"attempt":2,"cached":false}}`,
+		},
+		{
+			name:     "event stream comment",
+			model:    models.ModelConfig{Provider: "openai"},
+			body:     `{"model":"m","messages":[],"stream":true}`,
+			annotate: true,
+			want:     "data: [DONE]\n\n: portus {\"alias\":\"m\",\"provider\":\"openai\",\"attempt\":2,\"cached\":false}\n\n",
+		},
+		{
+			name:     "ndjson line",
+			model:    models.ModelConfig{Provider: "openai"},
+			body:     `{"model":"m","messages":[],"stream":true}`,
+			accept:   "application/x-ndjson",
+			annotate: true,
+			want:     `{"portus":{"alias":"m","provider":"openai","attempt":2,"cached":false}}` + "\n",
+		},
+		{
+			name:  "disabled",
+			model: multiTarget,
+			body:  `{"model":"m","messages":[]}`,
+			want:  `{"choices":[]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int32
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					writeJSONError(w, "overloaded", http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set(annotate.TargetIndexHeader, "1")
+				if isStreamingRequest([]byte(tt.body)) {
+					w.Header().Set("Content-Type", "text/event-stream")
+					w.Write([]byte("data: [DONE]\n\n"))
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"choices":[]}`))
+			}))
+			defer gateway.Close()
+
+			store := &models.ConfigStore{
+				Models:            map[string]models.ModelConfig{"m": tt.model},
+				GatewayURL:        gateway.URL,
+				ProxyRetries:      1,
+				ProxyRetryBackoff: time.Millisecond,
+				AnnotateResponses: tt.annotate,
+			}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tt.body))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, &Services{Usage: usage.NewTracker()}, logger).ServeHTTP(rec, req)
+
+			if rec.Body.String() != tt.want {
+				t.Errorf("unexpected body:\n got %q\nwant %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyTranslated_CarriesAnnotation(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:            map[string]models.ModelConfig{"claude": {Provider: "anthropic", APIFormat: "anthropic"}},
+		GatewayURL:        gateway.URL,
+		AnnotateResponses: true,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"claude","messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	ChatCompletionsHandler(store, &Services{Usage: usage.NewTracker()}, logger).ServeHTTP(rec, req)
+
+	var resp struct {
+		Object string               `json:"object"`
+		Portus *annotate.Annotation `json:"portus"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Object != "chat.completion" || resp.Portus == nil || resp.Portus.Alias != "claude" || resp.Portus.Attempt != 1 {
+		t.Errorf("expected translated response to keep the annotation, got %s", rec.Body.String())
+	}
+}
+
+func TestChatCompletionsHandler_MatchPattern(t *testing.T) {
+	t.Parallel()
+
+	var gotModel string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		gotModel = req.Model
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4-family": {Provider: "openai", APIKey: "sk-test", Match: []string{"gpt-4*"}}},
+		GatewayURL: gateway.URL,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := ChatCompletionsHandler(store, &Services{Usage: usage.NewTracker()}, logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o-mini","messages":[]}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || gotModel != "gpt-4o-mini" {
+		t.Errorf("expected matched name to be forwarded unchanged, got status %d and model %q", rec.Code, gotModel)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"claude-3","messages":[]}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected unmatched name to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestChatCompletionsHandler_DefaultModel(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":2,"completion_tokens":1}}`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	tests := []struct {
+		name         string
+		model        string
+		defaultModel string
+		wantStatus   int
+	}{
+		{name: "unknown routed to default", model: "gpt-3.5-turbo", defaultModel: "fallback", wantStatus: http.StatusOK},
+		{name: "empty routed to default", model: "", defaultModel: "fallback", wantStatus: http.StatusOK},
+		{name: "known alias unaffected", model: "gpt4", defaultModel: "fallback", wantStatus: http.StatusOK},
+		{name: "no default", model: "gpt-3.5-turbo", wantStatus: http.StatusBadRequest},
+		{name: "default no longer configured", model: "gpt-3.5-turbo", defaultModel: "removed", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := &models.ConfigStore{
+				Models: map[string]models.ModelConfig{
+					"gpt4":     {Provider: "openai", APIKey: "sk-test"},
+					"fallback": {Provider: "openai", APIKey: "sk-test"},
+				},
+				GatewayURL:   gateway.URL,
+				DefaultModel: tt.defaultModel,
+			}
+			svc := &Services{Usage: usage.NewTracker()}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"`+tt.model+`","messages":[]}`))
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "tool"))
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			wantAlias := tt.model
+			if wantAlias != "gpt4" {
+				wantAlias = tt.defaultModel
+			}
+			if totals := svc.Usage.Snapshot("tool"); len(totals) != 1 || totals[0].ModelAlias != wantAlias {
+				t.Errorf("expected usage recorded under %q, got %+v", wantAlias, totals)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsHandler_CanaryRouting(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":2,"completion_tokens":1}}`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	tests := []struct {
+		name      string
+		canary    *models.CanaryConfig
+		wantAlias string
+	}{
+		{name: "all traffic to canary", canary: &models.CanaryConfig{Alias: "sonnet-next", Percent: 100}, wantAlias: "sonnet-next"},
+		{name: "canary paused", canary: &models.CanaryConfig{Alias: "sonnet-next", Percent: 0}, wantAlias: "sonnet"},
+		{name: "canary alias removed", canary: &models.CanaryConfig{Alias: "gone", Percent: 100}, wantAlias: "sonnet"},
+		{name: "no canary", wantAlias: "sonnet"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := &models.ConfigStore{
+				Models: map[string]models.ModelConfig{
+					"sonnet":      {Provider: "anthropic", APIKey: "sk-test", Canary: tt.canary},
+					"sonnet-next": {Provider: "anthropic", APIKey: "sk-test"},
+				},
+				GatewayURL: gateway.URL,
+			}
+			svc := &Services{Usage: usage.NewTracker()}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"sonnet","messages":[]}`))
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "web"))
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", rec.Code)
+			}
+			if totals := svc.Usage.Snapshot("web"); len(totals) != 1 || totals[0].ModelAlias != tt.wantAlias {
+				t.Errorf("expected usage recorded under %q, got %+v", tt.wantAlias, totals)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsHandler_BannedModel(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":2,"completion_tokens":1}}`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	tests := []struct {
+		name       string
+		model      string
+		banned     []string
+		wantStatus int
+	}{
+		{name: "allowed", model: "gpt4", banned: []string{"gpt-3.5-*"}, wantStatus: http.StatusOK},
+		{name: "override_params model banned", model: "legacy", banned: []string{"gpt-3.5-*"}, wantStatus: http.StatusForbidden},
+		{name: "alias-level ban", model: "pinned", wantStatus: http.StatusForbidden},
+		{name: "default alias banned", model: "unknown", banned: []string{"GPT-3.5-TURBO"}, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := &models.ConfigStore{
+				Models: map[string]models.ModelConfig{
+					"gpt4":   {Provider: "openai", APIKey: "sk-test", OverrideParams: map[string]interface{}{"model": "gpt-4o"}},
+					"legacy": {Provider: "openai", APIKey: "sk-test", OverrideParams: map[string]interface{}{"model": "gpt-3.5-turbo"}},
+					"pinned": {Provider: "openai", APIKey: "sk-test", OverrideParams: map[string]interface{}{"model": "gpt-4-0314"}, BannedModels: []string{"gpt-4-0314"}},
+				},
+				GatewayURL:   gateway.URL,
+				DefaultModel: "legacy",
+				BannedModels: tt.banned,
+			}
+			svc := &Services{Usage: usage.NewTracker()}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"`+tt.model+`","messages":[]}`))
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Code == http.StatusForbidden && !strings.Contains(rec.Body.String(), "prohibited by policy") {
+				t.Errorf("unexpected error body: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleProxyRequest_ReapsHungStream(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	defer close(release)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		// Hang without finishing the stream
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	svc := &Services{Usage: usage.NewTracker(), Progress: progress.NewMonitor(time.Hour, logger)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go svc.Progress.Reap(ctx, 50*time.Millisecond, 10*time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[],"stream":true}`))
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the hung stream to be reaped")
+	}
+	if svc.Progress.Reaped() != 1 || len(svc.Progress.Streams("")) != 0 {
+		t.Errorf("expected one reaped and no open streams, got %d and %+v", svc.Progress.Reaped(), svc.Progress.Streams(""))
+	}
+	if !strings.Contains(rec.Body.String(), `"hi"`) {
+		t.Errorf("expected the relayed chunk before the stream was closed, got %q", rec.Body.String())
+	}
+}
+
+func TestChatCompletionsHandler_ExperimentRouting(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":2,"completion_tokens":1}}`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{
+			"sonnet":    {Provider: "anthropic", APIKey: "sk-test", Experiment: &models.ExperimentConfig{Name: "prompt-v2", A: "sonnet", B: "sonnet-v2"}},
+			"sonnet-v2": {Provider: "anthropic", APIKey: "sk-test"},
+		},
+		GatewayURL: gateway.URL,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	seen := map[string]bool{}
+	for i := range 20 {
+		stickyKey := "user-" + strconv.Itoa(i)
+		variant := experiment.Assign("prompt-v2", "web", stickyKey)
+		wantAlias := map[string]string{experiment.VariantA: "sonnet", experiment.VariantB: "sonnet-v2"}[variant]
+		seen[variant] = true
+
+		// The same caller lands on the same variant every time
+		for range 2 {
+			svc := &Services{Usage: usage.NewTracker()}
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"sonnet","messages":[]}`))
+			req.Header.Set(stickyKeyHeader, stickyKey)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "web"))
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", rec.Code)
+			}
+			if got := rec.Header().Get(experimentHeader); got != "prompt-v2="+variant {
+				t.Errorf("expected experiment header prompt-v2=%s, got %q", variant, got)
+			}
+			if totals := svc.Usage.Snapshot("web"); len(totals) != 1 || totals[0].ModelAlias != wantAlias {
+				t.Errorf("expected usage recorded under %q, got %+v", wantAlias, totals)
+			}
+		}
+	}
+	if len(seen) != 2 {
+		t.Errorf("expected callers to be split across both variants, got %v", seen)
+	}
+}
+
+func TestChatCompletionsHandler_GatewayHTTP2(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Proto", r.Proto)
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	gateway.EnableHTTP2 = true
+	gateway.StartTLS()
+	t.Cleanup(gateway.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: gateway.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{"gpt4": {
+			Provider:    "openai",
+			APIKey:      "sk-test",
+			UpstreamTLS: &models.UpstreamTLSConfig{CAFile: caFile},
+		}},
+		GatewayURL: gateway.URL,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+	rec := httptest.NewRecorder()
+	ChatCompletionsHandler(store, &Services{Usage: usage.NewTracker()}, logger).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Proto"); got != "HTTP/2.0" {
+		t.Errorf("expected the gateway request over HTTP/2, got %q", got)
+	}
+}
+
+func TestChatCompletionsHandler_UpstreamHeadersAndTLS(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Gateway-Token") != "secret" || r.Header.Get("x-portkey-provider") != "openai" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: gateway.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := map[string]string{"X-Gateway-Token": "secret", "x-portkey-provider": "spoofed"}
+	tests := []struct {
+		name       string
+		tls        *models.UpstreamTLSConfig
+		wantStatus int
+	}{
+		{name: "alias trusts gateway CA", tls: &models.UpstreamTLSConfig{CAFile: caFile}, wantStatus: http.StatusOK},
+		{name: "gateway certificate untrusted", wantStatus: http.StatusBadGateway},
+		{name: "credentials missing", tls: &models.UpstreamTLSConfig{CAFile: caFile + ".missing"}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := &models.ConfigStore{
+				Models: map[string]models.ModelConfig{
+					"gpt4": {Provider: "openai", APIKey: "sk-test", UpstreamHeaders: headers, UpstreamTLS: tt.tls},
+				},
+				GatewayURL: gateway.URL,
+			}
+			svc := &Services{Usage: usage.NewTracker()}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+			rec := httptest.NewRecorder()
+			ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestChatCompletionsHandler_MockGateway(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(mock.New(mock.ModeEcho, ""))
+	t.Cleanup(gateway.Close)
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai"}},
+		GatewayURL: gateway.URL,
+	}
+	svc := &Services{Usage: usage.NewTracker()}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","stream":true,"messages":[{"role":"user","content":"ping pong"}]}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "tests"))
+	rec := httptest.NewRecorder()
+	ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"content":"pong"`) || !strings.Contains(rec.Body.String(), "[DONE]") {
+		t.Fatalf("unexpected mock stream %d: %s", rec.Code, rec.Body.String())
+	}
+	// The injected usage chunk is accounted and then hidden from the client
+	if strings.Contains(rec.Body.String(), `"usage"`) {
+		t.Errorf("expected the usage chunk to be hidden: %s", rec.Body.String())
+	}
+	if totals := svc.Usage.Snapshot("tests"); len(totals) != 1 || totals[0].CompletionTokens != 2 {
+		t.Errorf("expected mock usage to be recorded, got %+v", totals)
+	}
+}
+
+func TestHandleProxyRequest_Provenance(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(provenance.Header, "forged")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+	}
+	key := []byte("0123456789abcdef0123456789abcdef")
+	svc := &Services{
+		Usage:      usage.NewTracker(),
+		Report:     report.NewRecorder(),
+		Provenance: provenance.NewSigner(key, func() string { return "2024-06-01" }),
+	}
+	handler := ChatCompletionsHandler(store, svc, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+	ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "tool")
+	ctx = context.WithValue(ctx, middleware.ContextKeyRequestID, "req-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req.WithContext(ctx))
+
+	values := rec.Header().Values(provenance.Header)
+	if len(values) != 1 {
+		t.Fatalf("expected one provenance header, got %q", values)
+	}
+	record, err := provenance.Verify(key, values[0])
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if record.RequestID != "req-1" || record.Alias != "gpt4" || record.Policy != "2024-06-01" {
+		t.Errorf("unexpected provenance record %+v", record)
+	}
+}
+
+func TestHandleProxyRequest_Budget(t *testing.T) {
+	t.Parallel()
+
+	var forwarded int
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":1000,"completion_tokens":1000}}`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{"gpt4": {
+			Provider: "openai",
+			APIKey:   "sk-test",
+			Pricing:  &models.PricingConfig{InputPer1K: 1, OutputPer1K: 2},
+		}},
+		GatewayURL: gateway.URL,
+		Budgets:    map[string]models.BudgetConfig{"tool": {DailyUSD: 5}},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	svc := &Services{
+		Usage:   usage.NewTracker(),
+		History: usage.NewHistory(usage.Retention{}),
+		Report:  report.NewRecorder(),
+		Privacy: privacy.New("", 0, 0),
+		Budgets: budget.New(nil, logger),
+	}
+	handler := ChatCompletionsHandler(store, svc, logger)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+		ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "tool")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(ctx))
+		return rec
+	}
+
+	// Each request costs $3, so the second exhausts the $5 daily budget
+	for i := 0; i < 2; i++ {
+		if rec := send(); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d: %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	rec := send()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body models.BudgetExceededError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Type != "budget_exceeded" || body.Window != "daily" || body.SpentUSD != 6 || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("unexpected budget error %+v", body)
+	}
+	if forwarded != 2 {
+		t.Errorf("expected 2 requests forwarded, got %d", forwarded)
+	}
+}
+
+func TestHandleProxyRequest_RequestQuota(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	quotas := quota.New(logger)
+	svc := &Services{Usage: usage.NewTracker(), Report: report.NewRecorder(), Quotas: quotas}
+	handler := ChatCompletionsHandler(store, svc, logger)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+		ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "tool")
+		ctx = context.WithValue(ctx, middleware.ContextKeyProxyKey, models.ProxyKey{Application: "tool", RequestQuota: models.RequestQuota{Limit: 2, Window: "hour"}})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(ctx))
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := send(); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d: %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	rec := send()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body models.QuotaExceededError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Type != "quota_exceeded" || body.Limit != 2 || body.Window != "hour" || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("unexpected quota error %+v", body)
+	}
+}
+
+func TestHandleProxyRequest_RequestQuotaRefundedOnRejection(t *testing.T) {
+	t.Parallel()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: "http://127.0.0.1:1",
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	quotas := quota.New(logger)
+	svc := &Services{Usage: usage.NewTracker(), Report: report.NewRecorder(), Quotas: quotas, Streams: streamlimit.New()}
+	handler := ChatCompletionsHandler(store, svc, logger)
+	requestQuota := models.RequestQuota{Limit: 2, Window: "hour"}
+
+	// The application's only stream slot is taken
+	release, _, _ := svc.Streams.Acquire("tool", 1)
+	defer release()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","stream":true,"messages":[]}`))
+	ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "tool")
+	ctx = context.WithValue(ctx, middleware.ContextKeyProxyKey, models.ProxyKey{Application: "tool", MaxStreams: 1, RequestQuota: requestQuota})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req.WithContext(ctx))
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if used := svc.Quotas.Status("tool", requestQuota, time.Now()); used.Used != 0 {
+		t.Errorf("expected the rejected request to be given back, got %+v", used)
+	}
+}
+
+func TestHandleProxyRequest_ConversationTokens(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":50,"completion_tokens":10,"total_tokens":60}}`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	tests := []struct {
+		name   string
+		action string
+		want   int
+	}{
+		{name: "reject", action: conversation.ActionReject, want: http.StatusBadRequest},
+		{name: "warn", action: conversation.ActionWarn, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := &models.ConfigStore{
+				Models:                   map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+				GatewayURL:               gateway.URL,
+				ConversationTokensAction: tt.action,
+			}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			svc := &Services{Usage: usage.NewTracker(), Report: report.NewRecorder(), Conversations: conversation.New(time.Hour, logger)}
+			handler := ChatCompletionsHandler(store, svc, logger)
+
+			send := func(conversationID string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+				req.Header.Set(conversation.Header, conversationID)
+				ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "agent")
+				ctx = context.WithValue(ctx, middleware.ContextKeyProxyKey, models.ProxyKey{Application: "agent", ConversationTokens: 100})
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req.WithContext(ctx))
+				return rec
+			}
+
+			// 60 tokens, then 120: the cap is only known to be passed after the second
+			for i := 0; i < 2; i++ {
+				if rec := send("loop-1"); rec.Code != http.StatusOK {
+					t.Fatalf("request %d: expected status 200, got %d: %s", i+1, rec.Code, rec.Body.String())
+				}
+			}
+			rec := send("loop-1")
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.want == http.StatusBadRequest {
+				var body models.ConversationLimitError
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if body.Type != "conversation_token_limit" || body.ConversationID != "loop-1" || body.Tokens != 120 || body.MaxTokens != 100 {
+					t.Errorf("unexpected conversation error %+v", body)
+				}
+			}
+
+			// Other conversations and requests without an ID are unaffected
+			if rec := send("loop-2"); rec.Code != http.StatusOK {
+				t.Errorf("expected new conversation to pass, got %d", rec.Code)
+			}
+			if rec := send(""); rec.Code != http.StatusOK {
+				t.Errorf("expected request without conversation ID to pass, got %d", rec.Code)
+			}
+		})
+	}
+}
+
+func TestHandleProxyRequest_BYOK(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var gotKeys []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(models.ProviderKeyHeader) != "" {
+			t.Error("expected the provider key header not to be forwarded")
+		}
+		var config models.PortkeyConfig
+		json.Unmarshal([]byte(r.Header.Get("x-portkey-config")), &config)
+		key := config.APIKey
+		for _, target := range config.Targets {
+			key += "|" + target.APIKey
+		}
+		mu.Lock()
+		gotKeys = append(gotKeys, key)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{
+			"shared":   {Provider: "openai", APIKey: "sk-portus"},
+			"optional": {Provider: "openai", APIKey: "sk-portus", BYOK: models.BYOKOptional},
+			"required": {
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				BYOK:     models.BYOKRequired,
+				Targets:  []models.TargetConfig{{Provider: "openai"}, {Provider: "openai", CustomHost: "https://eu.example.com/v1"}},
+			},
+		},
+		GatewayURL: gateway.URL,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := ChatCompletionsHandler(store, &Services{Usage: usage.NewTracker()}, logger)
+
+	tests := []struct {
+		name       string
+		model      string
+		key        string
+		wantStatus int
+		wantKey    string
+	}{
+		{name: "optional with client key", model: "optional", key: "sk-team", wantStatus: http.StatusOK, wantKey: "sk-team"},
+		{name: "optional falls back to configured key", model: "optional", wantStatus: http.StatusOK, wantKey: "sk-portus"},
+		{name: "required replaces every target key", model: "required", key: "sk-team", wantStatus: http.StatusOK, wantKey: "|sk-team|sk-team"},
+		{name: "required without client key", model: "required", wantStatus: http.StatusUnauthorized},
+		{name: "client key for alias without byok", model: "shared", key: "sk-team", wantStatus: http.StatusBadRequest},
+	}
+
+	// Cases share the gateway's record of keys, so they run in order
+	for _, tt := range tests {
+		mu.Lock()
+		gotKeys = nil
+		mu.Unlock()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"`+tt.model+`","messages":[]}`))
+		if tt.key != "" {
+			req.Header.Set(models.ProviderKeyHeader, tt.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.wantStatus, rec.Code, rec.Body.String())
+			continue
+		}
+		mu.Lock()
+		if tt.wantKey == "" && len(gotKeys) != 0 {
+			t.Errorf("%s: expected the gateway not to be called, got %v", tt.name, gotKeys)
+		}
+		if tt.wantKey != "" && (len(gotKeys) != 1 || gotKeys[0] != tt.wantKey) {
+			t.Errorf("%s: expected key %q upstream, got %v", tt.name, tt.wantKey, gotKeys)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestHandleProxyRequest_HedgesSlowFirstToken(t *testing.T) {
+	t.Parallel()
+
+	var stalled atomic.Bool
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var config models.PortkeyConfig
+		json.Unmarshal([]byte(r.Header.Get("x-portkey-config")), &config)
+		if config.Strategy != nil || len(config.Targets) != 0 {
+			t.Errorf("expected a single-target config per hedged attempt, got %+v", config)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		if config.Provider == "openai" {
+			// A browned-out provider that never sends its first token
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			stalled.Store(true)
+			return
+		}
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{"smart": {
+			Strategy:   &models.StrategyConfig{Mode: "fallback"},
+			HedgeAfter: 20,
+			Targets: []models.TargetConfig{
+				{Provider: "openai", APIKey: "sk-1"},
+				{Provider: "anthropic", APIKey: "sk-2"},
+			},
+		}},
+		GatewayURL: gateway.URL,
+	}
+	svc := &Services{Usage: usage.NewTracker(), Streams: streamlimit.New(), Report: report.NewRecorder()}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"smart","messages":[],"stream":true}`))
+	rec := httptest.NewRecorder()
+	ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"hi"`) {
+		t.Fatalf("expected the second target's stream, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(annotate.TargetIndexHeader); got != "1" {
+		t.Errorf("expected target index 1, got %q", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !stalled.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !stalled.Load() {
+		t.Error("expected the stalled attempt to be cancelled")
+	}
+}
+
+func TestHandleProxyRequest_HedgedAttemptsClampRequestTimeout(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var timeouts []int
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var config models.PortkeyConfig
+		json.Unmarshal([]byte(r.Header.Get("x-portkey-config")), &config)
+		mu.Lock()
+		timeouts = append(timeouts, config.RequestTimeout)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		if config.Provider == "openai" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer gateway.Close()
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{"smart": {
+			Strategy:          &models.StrategyConfig{Mode: "fallback"},
+			HedgeAfter:        20,
+			RequestTimeout:    600000,
+			MaxRequestTimeout: 30000,
+			Targets: []models.TargetConfig{
+				{Provider: "openai", APIKey: "sk-1"},
+				{Provider: "anthropic", APIKey: "sk-2"},
+			},
+		}},
+		GatewayURL: gateway.URL,
+	}
+	svc := &Services{Usage: usage.NewTracker(), Streams: streamlimit.New(), Report: report.NewRecorder()}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"smart","messages":[],"stream":true}`))
+	rec := httptest.NewRecorder()
+	ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(timeouts) != 2 {
+		t.Fatalf("expected two hedged attempts, got %d", len(timeouts))
+	}
+	for i, got := range timeouts {
+		if got <= 0 || got > 30000 {
+			t.Errorf("attempt %d: expected request_timeout clamped to 30000, got %d", i, got)
+		}
+	}
+}
+
+func TestHandleProxyRequest_StatsD(t *testing.T) {
+	t.Parallel()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3}}`))
+	}))
+	defer gateway.Close()
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := statsd.New(listener.LocalAddr().String(), "portus.", statsd.FormatDogStatsD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	store := &models.ConfigStore{
+		Models:     map[string]models.ModelConfig{"gpt4": {Provider: "openai", APIKey: "sk-test"}},
+		GatewayURL: gateway.URL,
+	}
+	svc := &Services{Usage: usage.NewTracker(), StatsD: client}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt4","messages":[]}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, "web"))
+	rec := httptest.NewRecorder()
+	ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var lines []string
+	buf := make([]byte, 512)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	for len(lines) < 4 {
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read metric %d: %v", len(lines)+1, err)
+		}
+		lines = append(lines, string(buf[:n]))
+	}
+	got := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"portus.tokens:12|c|#application:web,model_alias:gpt4,token_type:prompt",
+		"portus.tokens:3|c|#application:web,model_alias:gpt4,token_type:completion",
+		"portus.requests:1|c|#application:web,model_alias:gpt4,status:200",
+		"portus.request.duration:",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected metric %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestChatCompletionsHandler_OperatorOverride(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var config models.PortkeyConfig
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		config = models.PortkeyConfig{}
+		json.Unmarshal([]byte(r.Header.Get("x-portkey-config")), &config)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{
+			"claude": {
+				Strategy: &models.StrategyConfig{Mode: "fallback"},
+				Retry:    &models.RetryConfig{Attempts: 3, OnStatusCodes: []int{429}},
+				Targets: []models.TargetConfig{
+					{Provider: "anthropic", APIKey: "sk-ant"},
+					{Provider: "bedrock", AWSAccessKeyID: "AKIA", AWSSecretAccessKey: "secret", AWSRegion: "us-east-1"},
+				},
+			},
+		},
+		GatewayURL: gateway.URL,
+	}
+	hub := events.NewHub()
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	svc := &Services{Usage: usage.NewTracker(), Events: hub}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	operator := models.ProxyKey{Key: "pk-ops", Application: "OPS", Scope: models.ScopeAdmin}
+
+	serve := func(headers map[string]string, withOperator bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"claude","messages":[]}`))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		if withOperator {
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyOperator, operator))
+		}
+		rec := httptest.NewRecorder()
+		ChatCompletionsHandler(store, svc, logger).ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Pinning the second target without retries sends only that target
+	rec := serve(map[string]string{override.TargetHeader: "1", override.RetryHeader: "0"}, true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	mu.Lock()
+	if config.Provider != "bedrock" || config.Strategy != nil || config.Retry != nil {
+		t.Errorf("expected a single bedrock target without retries, got %+v", config)
+	}
+	mu.Unlock()
+	if ev := <-ch; ev.Type != events.OverrideApplied || ev.Operator != "OPS" || ev.Data["override"] != "retry=0 target=1" {
+		t.Errorf("unexpected audit event %+v", ev)
+	}
+
+	// Overrides are ignored unless an admin key authorized them
+	serve(map[string]string{override.FallbackHeader: "off"}, false)
+	mu.Lock()
+	if config.Strategy == nil || len(config.Targets) != 2 || config.Retry == nil || config.Retry.Attempts != 3 {
+		t.Errorf("expected the configured strategy, got %+v", config)
+	}
+	mu.Unlock()
+
+	// Retries keep the alias's status codes; fallback off keeps the first target
+	serve(map[string]string{override.FallbackHeader: "off", override.RetryHeader: "5"}, true)
+	mu.Lock()
+	if config.Provider != "anthropic" || config.Retry == nil || config.Retry.Attempts != 5 || !slices.Equal(config.Retry.OnStatusCodes, []int{429}) {
+		t.Errorf("expected the first target with 5 retries on 429, got %+v", config)
+	}
+	mu.Unlock()
+
+	if rec := serve(map[string]string{override.TargetHeader: "2"}, true); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an out of range target to be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,154 @@
+// Package health implements Kubernetes-style probe endpoints backed by
+// registries of named checks.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds how long a single probe request may spend running checks.
+const checkTimeout = 5 * time.Second
+
+// CheckFunc returns nil when the check passes.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name string
+	Err  error
+}
+
+// Registry holds the checks for one probe. It is safe for concurrent use.
+type Registry struct {
+	name   string
+	mu     sync.RWMutex
+	checks []check
+}
+
+// NewRegistry creates an empty registry for the named probe (e.g. "readyz").
+func NewRegistry(name string) *Registry {
+	return &Registry{name: name}
+}
+
+// Register adds a named check.
+func (r *Registry) Register(name string, fn CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, check{name: name, fn: fn})
+}
+
+// Run executes every check not listed in exclude, in registration order.
+func (r *Registry) Run(ctx context.Context, exclude []string) []Result {
+	r.mu.RLock()
+	checks := slices.Clone(r.checks)
+	r.mu.RUnlock()
+
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		if slices.Contains(exclude, c.name) {
+			continue
+		}
+		results = append(results, Result{Name: c.name, Err: c.fn(ctx)})
+	}
+	return results
+}
+
+// Handler serves the probe. It responds 200 "ok" when every check passes and
+// 503 otherwise. With ?verbose, each check result is listed; ?exclude=name
+// (repeatable) skips individual checks.
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+
+		query := req.URL.Query()
+		results := r.Run(ctx, query["exclude"])
+		_, verbose := query["verbose"]
+
+		var out strings.Builder
+		failed := false
+		for _, res := range results {
+			if res.Err != nil {
+				failed = true
+				fmt.Fprintf(&out, "[-]%s failed: %v\n", res.Name, res.Err)
+			} else {
+				fmt.Fprintf(&out, "[+]%s ok\n", res.Name)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		if failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			// Failures always list the individual checks so operators see the cause
+			fmt.Fprintf(w, "%s%s check failed\n", out.String(), r.name)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if verbose {
+			fmt.Fprintf(w, "%s%s check passed\n", out.String(), r.name)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}
+}
+
+// FlagCheck returns a check that passes while flag reports true.
+func FlagCheck(flag func() bool, failure string) CheckFunc {
+	return func(ctx context.Context) error {
+		if !flag() {
+			return fmt.Errorf("%s", failure)
+		}
+		return nil
+	}
+}
+
+// GatewayCheck returns a check that the gateway accepts connections. Any HTTP
+// response counts as reachable. Results are cached for cacheFor so frequent
+// probes don't add load to the gateway.
+func GatewayCheck(client *http.Client, url string, cacheFor time.Duration) CheckFunc {
+	var (
+		mu      sync.Mutex
+		checked time.Time
+		lastErr error
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !checked.IsZero() && time.Since(checked) < cacheFor {
+			return lastErr
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("gateway unreachable: %w", err)
+		} else {
+			resp.Body.Close()
+			lastErr = nil
+		}
+		checked = time.Now()
+		return lastErr
+	}
+}
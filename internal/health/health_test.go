@@ -0,0 +1,103 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Handler(t *testing.T) {
+	t.Parallel()
+
+	registry := NewRegistry("readyz")
+	registry.Register("config", func(ctx context.Context) error { return nil })
+	registry.Register("gateway", func(ctx context.Context) error { return errors.New("connection refused") })
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantBody []string
+	}{
+		{
+			name:     "failing check lists results",
+			query:    "",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: []string{"[+]config ok", "[-]gateway failed: connection refused", "readyz check failed"},
+		},
+		{
+			name:     "excluded check",
+			query:    "?exclude=gateway",
+			wantCode: http.StatusOK,
+			wantBody: []string{"ok"},
+		},
+		{
+			name:     "verbose success",
+			query:    "?exclude=gateway&verbose",
+			wantCode: http.StatusOK,
+			wantBody: []string{"[+]config ok", "readyz check passed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodGet, "/readyz"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			registry.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected status %d, got %d", tt.wantCode, rec.Code)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("expected body to contain %q, got %q", want, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestFlagCheck(t *testing.T) {
+	t.Parallel()
+
+	var ready atomic.Bool
+	check := FlagCheck(ready.Load, "not ready")
+	if err := check(context.Background()); err == nil {
+		t.Error("expected failure while flag is false")
+	}
+	ready.Store(true)
+	if err := check(context.Background()); err != nil {
+		t.Errorf("expected success, got %v", err)
+	}
+}
+
+func TestGatewayCheck(t *testing.T) {
+	t.Parallel()
+
+	var hits atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	check := GatewayCheck(gateway.Client(), gateway.URL, time.Minute)
+	if err := check(context.Background()); err != nil {
+		t.Fatalf("expected any HTTP response to count as reachable, got %v", err)
+	}
+	check(context.Background())
+	if hits.Load() != 1 {
+		t.Errorf("expected cached result, gateway hit %d times", hits.Load())
+	}
+
+	gateway.Close()
+	unreachable := GatewayCheck(http.DefaultClient, gateway.URL, time.Minute)
+	if err := unreachable(context.Background()); err == nil {
+		t.Error("expected error for closed gateway")
+	}
+}
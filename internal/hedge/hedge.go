@@ -0,0 +1,164 @@
+// Package hedge races the targets of a fallback alias on streamed requests.
+// When the current target has produced no data within a threshold, the next
+// target is started alongside it; the first to deliver data wins and the
+// slower attempts are cancelled.
+package hedge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Attempt sends the request to one target.
+type Attempt func(ctx context.Context) (*http.Response, error)
+
+// Result is the response that won the race.
+type Result struct {
+	Response *http.Response
+	// Target is the index of the attempt that produced Response.
+	Target int
+	// Started is how many attempts were started.
+	Started int
+}
+
+// outcome is what one attempt produced: a response whose first data has been
+// read, a failed response, or an error.
+type outcome struct {
+	target int
+	resp   *http.Response
+	err    error
+	ok     bool
+}
+
+// Do runs attempts in order, starting the next one when the current ones have
+// produced no data after `after`, or as soon as one fails. A response counts
+// as failed when its status is not 2xx. The first response to deliver data is
+// returned with the data still readable; closing its body cancels it. When
+// every attempt fails, the last failed response (or error) is returned.
+func Do(ctx context.Context, attempts []Attempt, after time.Duration) (Result, error) {
+	if len(attempts) == 0 {
+		return Result{}, errors.New("hedge: no attempts")
+	}
+
+	results := make(chan outcome, len(attempts))
+	cancels := make([]context.CancelFunc, 0, len(attempts))
+	start := func() {
+		target := len(cancels)
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			results <- run(attemptCtx, attempts[target], target)
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(after)
+	defer timer.Stop()
+
+	var last outcome
+	finished := 0
+	for {
+		select {
+		case <-ctx.Done():
+			abandon(cancels, results, len(cancels)-finished, -1)
+			return Result{Started: len(cancels)}, ctx.Err()
+
+		case <-timer.C:
+			if len(cancels) < len(attempts) {
+				start()
+				timer.Reset(after)
+			}
+
+		case o := <-results:
+			finished++
+			if o.ok {
+				abandon(cancels, results, len(cancels)-finished, o.target)
+				if last.resp != nil {
+					last.resp.Body.Close()
+				}
+				o.resp.Body = &cancelBody{ReadCloser: o.resp.Body, cancel: cancels[o.target]}
+				return Result{Response: o.resp, Target: o.target, Started: len(cancels)}, nil
+			}
+
+			if last.resp != nil {
+				last.resp.Body.Close()
+			}
+			last = o
+			if len(cancels) < len(attempts) {
+				// A failed target hands over immediately
+				start()
+				timer.Reset(after)
+				continue
+			}
+			if finished == len(cancels) {
+				if last.resp == nil {
+					abandon(cancels, results, 0, -1)
+				} else {
+					abandon(cancels, results, 0, last.target)
+					last.resp.Body = &cancelBody{ReadCloser: last.resp.Body, cancel: cancels[last.target]}
+				}
+				return Result{Response: last.resp, Target: last.target, Started: len(cancels)}, last.err
+			}
+		}
+	}
+}
+
+// run sends one attempt and waits for its first data.
+func run(ctx context.Context, attempt Attempt, target int) outcome {
+	resp, err := attempt(ctx)
+	if err != nil {
+		return outcome{target: target, err: err}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return outcome{target: target, resp: resp}
+	}
+
+	buf := make([]byte, 4096)
+	n, err := resp.Body.Read(buf)
+	for n == 0 && err == nil {
+		n, err = resp.Body.Read(buf)
+	}
+	if n == 0 && err != io.EOF {
+		resp.Body.Close()
+		return outcome{target: target, err: err}
+	}
+	resp.Body = &prefixBody{Reader: io.MultiReader(bytes.NewReader(buf[:n]), resp.Body), Closer: resp.Body}
+	return outcome{target: target, resp: resp, ok: true}
+}
+
+// abandon cancels every attempt but keep and closes the responses of the
+// pending ones as they arrive.
+func abandon(cancels []context.CancelFunc, results <-chan outcome, pending, keep int) {
+	for i, cancel := range cancels {
+		if i != keep {
+			cancel()
+		}
+	}
+	go func() {
+		for ; pending > 0; pending-- {
+			if o := <-results; o.resp != nil {
+				o.resp.Body.Close()
+			}
+		}
+	}()
+}
+
+type prefixBody struct {
+	io.Reader
+	io.Closer
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,147 @@
+package hedge
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// respond returns an attempt that waits for delay before answering with
+// status and body, or gives up when its context is cancelled.
+func respond(delay time.Duration, status int, body string, cancelled chan<- int, target int) Attempt {
+	return func(ctx context.Context) (*http.Response, error) {
+		select {
+		case <-time.After(delay):
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body))}, nil
+		case <-ctx.Done():
+			if cancelled != nil {
+				cancelled <- target
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		attempts    func(cancelled chan<- int) []Attempt
+		wantTarget  int
+		wantStarted int
+		wantStatus  int
+		wantBody    string
+		wantErr     bool
+	}{
+		{
+			name: "fast first target is not hedged",
+			attempts: func(cancelled chan<- int) []Attempt {
+				return []Attempt{
+					respond(0, http.StatusOK, "data: first", cancelled, 0),
+					respond(0, http.StatusOK, "data: second", cancelled, 1),
+				}
+			},
+			wantTarget: 0, wantStarted: 1, wantStatus: http.StatusOK, wantBody: "data: first",
+		},
+		{
+			name: "slow first target is overtaken",
+			attempts: func(cancelled chan<- int) []Attempt {
+				return []Attempt{
+					respond(time.Hour, http.StatusOK, "data: first", cancelled, 0),
+					respond(0, http.StatusOK, "data: second", cancelled, 1),
+				}
+			},
+			wantTarget: 1, wantStarted: 2, wantStatus: http.StatusOK, wantBody: "data: second",
+		},
+		{
+			name: "failed target hands over immediately",
+			attempts: func(cancelled chan<- int) []Attempt {
+				return []Attempt{
+					respond(0, http.StatusServiceUnavailable, "down", cancelled, 0),
+					respond(0, http.StatusOK, "data: second", cancelled, 1),
+					respond(0, http.StatusOK, "data: third", cancelled, 2),
+				}
+			},
+			wantTarget: 1, wantStarted: 2, wantStatus: http.StatusOK, wantBody: "data: second",
+		},
+		{
+			name: "every target failing returns the last response",
+			attempts: func(cancelled chan<- int) []Attempt {
+				return []Attempt{
+					respond(0, http.StatusServiceUnavailable, "first down", cancelled, 0),
+					respond(0, http.StatusTooManyRequests, "second limited", cancelled, 1),
+				}
+			},
+			wantTarget: 1, wantStarted: 2, wantStatus: http.StatusTooManyRequests, wantBody: "second limited",
+		},
+		{
+			name: "every target erroring returns the last error",
+			attempts: func(cancelled chan<- int) []Attempt {
+				fail := func(ctx context.Context) (*http.Response, error) { return nil, errors.New("refused") }
+				return []Attempt{fail, fail}
+			},
+			wantTarget: 1, wantStarted: 2, wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cancelled := make(chan int, 3)
+			result, err := Do(context.Background(), tt.attempts(cancelled), 20*time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.Target != tt.wantTarget || result.Started != tt.wantStarted {
+				t.Errorf("got target %d of %d started, want %d of %d", result.Target, result.Started, tt.wantTarget, tt.wantStarted)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer result.Response.Body.Close()
+			body, _ := io.ReadAll(result.Response.Body)
+			if result.Response.StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", result.Response.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDo_CancelsSlowerAttempt(t *testing.T) {
+	t.Parallel()
+
+	cancelled := make(chan int, 1)
+	result, err := Do(context.Background(), []Attempt{
+		respond(time.Hour, http.StatusOK, "data: first", cancelled, 0),
+		respond(0, http.StatusOK, "data: second", cancelled, 1),
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Response.Body.Close()
+
+	select {
+	case target := <-cancelled:
+		if target != 0 {
+			t.Errorf("expected target 0 to be cancelled, got %d", target)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slower attempt was not cancelled")
+	}
+}
+
+func TestDo_ContextCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := Do(ctx, []Attempt{respond(time.Hour, http.StatusOK, "", nil, 0)}, time.Hour)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
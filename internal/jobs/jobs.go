@@ -0,0 +1,566 @@
+// Package jobs runs requests asynchronously. A request sent with ?async=1 is
+// accepted with a job ID straight away and proxied in the background; the
+// client polls /v1/jobs/{id} for the result or is notified through webhooks,
+// so generations can outlive serverless client timeouts. Jobs are kept in a
+// storage backend, so with a shared one any replica can answer for them.
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/storage"
+	"github.com/amscotti/portus/internal/webhook"
+)
+
+// Job states.
+const (
+	Running   = "running"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+	Cancelled = "cancelled"
+)
+
+// WebhookHeader names a URL notified when the job finishes, in addition to
+// the configured webhooks. Its host must be on the manager's allowlist.
+const WebhookHeader = "X-Portus-Webhook"
+
+// maxBodySize caps accepted request bodies and stored responses.
+const maxBodySize = 10 * 1024 * 1024
+
+// errInterrupted is recorded for running jobs whose replica stopped.
+const errInterrupted = "interrupted by a restart before it finished; submit the request again"
+
+// leaseTTL is how long a running job outlives its replica. Run renews the
+// leases of the replica's jobs well within it.
+const leaseTTL = time.Minute
+
+// errTooManyJobs is returned by submit when maxActive jobs are running.
+var errTooManyJobs = errors.New("too many asynchronous jobs are running")
+
+// Job is an asynchronous request and, once finished, its response.
+type Job struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Application string     `json:"application,omitempty"`
+	RequestID   string     `json:"request_id,omitempty"`
+	Path        string     `json:"path"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// StatusCode and Response are the upstream response. Non-JSON responses
+	// are stored as a JSON string.
+	StatusCode int             `json:"status_code,omitempty"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Webhook    string          `json:"webhook,omitempty"`
+}
+
+// Finished reports whether the job has stopped running.
+func (j Job) Finished() bool {
+	return j.Status != Running
+}
+
+// Manager runs jobs and tracks them in a storage backend. It is safe for
+// concurrent use.
+type Manager struct {
+	backend      storage.Backend
+	ttl          time.Duration
+	maxActive    int
+	webhookHosts []string
+	webhooks     *webhook.Dispatcher
+	logger       *slog.Logger
+
+	// running holds the jobs this replica is serving
+	mu      sync.Mutex
+	running map[string]runningJob
+
+	// updates serializes Cancel and finish, so a cancellation is not
+	// overwritten by the response of the job it stopped
+	updates sync.Mutex
+}
+
+type runningJob struct {
+	application string
+	cancel      context.CancelFunc
+}
+
+// New creates a manager keeping jobs in backend, finished ones for ttl, and
+// running at most maxActive at once on this replica (0 is unlimited).
+// Finished jobs are published through webhooks, and to a job's own webhook
+// URL when its host matches one of webhookHosts. A running job whose replica
+// stopped is reported failed once its lease expires, since its request cannot
+// be resumed.
+func New(backend storage.Backend, ttl time.Duration, maxActive int, webhookHosts []string, webhooks *webhook.Dispatcher, logger *slog.Logger) *Manager {
+	return &Manager{
+		backend:      backend,
+		ttl:          ttl,
+		maxActive:    maxActive,
+		webhookHosts: webhookHosts,
+		webhooks:     webhooks,
+		logger:       logger,
+		running:      make(map[string]runningJob),
+	}
+}
+
+// Get returns the application's job with the given ID.
+func (m *Manager) Get(application, id string) (Job, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+	return m.load(ctx, application, id)
+}
+
+// List returns the application's jobs, newest first, without their
+// responses.
+func (m *Manager) List(application string) ([]Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	stored, err := m.backend.Scan(ctx, storage.Prefix("jobs", application))
+	if err != nil {
+		return nil, err
+	}
+	var list []Job
+	for key, data := range stored {
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			m.logger.Warn("skipping unreadable job", "key", key, "error", err)
+			continue
+		}
+		job = m.checkLease(ctx, job)
+		job.Response = nil
+		list = append(list, job)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list, nil
+}
+
+// Cancel stops a running job, or deletes a finished one. It returns the
+// job's state afterwards and whether it was found. A job running on another
+// replica stops when that replica next renews its lease.
+func (m *Manager) Cancel(application, id string) (Job, bool, error) {
+	m.updates.Lock()
+	defer m.updates.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	job, ok, err := m.load(ctx, application, id)
+	if err != nil || !ok {
+		return Job{}, false, err
+	}
+	if job.Finished() {
+		return job, true, m.backend.Delete(ctx, jobKey(application, id))
+	}
+
+	m.mu.Lock()
+	if r, ok := m.running[id]; ok {
+		r.cancel()
+	}
+	m.mu.Unlock()
+	now := time.Now().UTC()
+	job.Status, job.CompletedAt = Cancelled, &now
+	if err := m.save(ctx, job); err != nil {
+		return Job{}, false, err
+	}
+	return job, true, m.backend.Delete(ctx, leaseKey(id))
+}
+
+// submit registers a running job for r, returning a context that ends when
+// the job is cancelled. It fails when maxActive jobs are already running.
+func (m *Manager) submit(r *http.Request, hook string) (*Job, context.Context, error) {
+	application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+	requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+
+	m.mu.Lock()
+	if m.maxActive > 0 && len(m.running) >= m.maxActive {
+		m.mu.Unlock()
+		return nil, nil, errTooManyJobs
+	}
+	job := &Job{
+		ID:          newJobID(),
+		Status:      Running,
+		Application: application,
+		RequestID:   requestID,
+		Path:        r.URL.Path,
+		CreatedAt:   time.Now().UTC(),
+		Webhook:     hook,
+	}
+	// The job outlives the client's connection but keeps its context values,
+	// such as the key, application and request ID
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	m.running[job.ID] = runningJob{application: application, cancel: cancel}
+	m.mu.Unlock()
+
+	// The lease goes first, so no reader sees the job without one
+	storeCtx, storeCancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer storeCancel()
+	err := m.backend.Set(storeCtx, leaseKey(job.ID), []byte{'1'}, leaseTTL)
+	if err == nil {
+		err = m.save(storeCtx, *job)
+	}
+	if err != nil {
+		m.mu.Lock()
+		delete(m.running, job.ID)
+		m.mu.Unlock()
+		cancel()
+		return nil, nil, err
+	}
+	return job, ctx, nil
+}
+
+// finish records a job's response unless it was cancelled meanwhile, and
+// returns the job's final state.
+func (m *Manager) finish(job Job, capture *jobWriter) Job {
+	m.updates.Lock()
+	defer m.updates.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	stored, found, err := m.load(ctx, job.Application, job.ID)
+	m.mu.Lock()
+	if r, ok := m.running[job.ID]; ok {
+		r.cancel()
+		delete(m.running, job.ID)
+	}
+	m.mu.Unlock()
+	switch {
+	case err == nil && !found:
+		return Job{}
+	case err == nil && stored.Finished():
+		return stored
+	}
+
+	now := time.Now().UTC()
+	job.CompletedAt = &now
+	job.StatusCode = capture.status
+	switch {
+	case capture.truncated:
+		job.Status, job.Error = Failed, fmt.Sprintf("response exceeded %d bytes", maxBodySize)
+	case json.Valid(capture.body.Bytes()):
+		job.Response = bytes.Clone(capture.body.Bytes())
+	default:
+		job.Response, _ = json.Marshal(capture.body.String())
+	}
+	if job.Status == Running {
+		job.Status = Succeeded
+		if capture.status >= http.StatusBadRequest {
+			job.Status = Failed
+		}
+	}
+	if err := m.save(ctx, job); err != nil {
+		m.logger.Warn("failed to store finished job", "job_id", job.ID, "error", err)
+	}
+	if err := m.backend.Delete(ctx, leaseKey(job.ID)); err != nil {
+		m.logger.Warn("failed to release job lease", "job_id", job.ID, "error", err)
+	}
+	return job
+}
+
+// Middleware runs requests carrying ?async=1 as jobs, answering 202 with the
+// job at once. Other requests pass through unchanged. Place it after
+// authentication and request screening, so rejected requests still fail
+// synchronously, and before recording, so the upstream exchange is recorded.
+func (m *Manager) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			async, _ := strconv.ParseBool(r.URL.Query().Get("async"))
+			if !async || r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			hook := r.Header.Get(WebhookHeader)
+			if hook != "" && webhook.ValidateURL(hook) != nil {
+				http.Error(w, `{"error": "`+WebhookHeader+` must be an http or https URL"}`, http.StatusBadRequest)
+				return
+			}
+			if hook != "" && !webhook.HostAllowed(hook, m.webhookHosts) {
+				http.Error(w, `{"error": "`+WebhookHeader+` host is not allowed"}`, http.StatusForbidden)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			if err != nil {
+				http.Error(w, `{"error": "Failed to read request body"}`, http.StatusBadRequest)
+				return
+			}
+			var options struct {
+				Stream bool `json:"stream"`
+			}
+			if json.Unmarshal(body, &options) == nil && options.Stream {
+				http.Error(w, `{"error": "Streaming requests cannot run asynchronously"}`, http.StatusBadRequest)
+				return
+			}
+
+			job, ctx, err := m.submit(r, hook)
+			if errors.Is(err, errTooManyJobs) {
+				http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusTooManyRequests)
+				return
+			}
+			if err != nil {
+				m.logger.Error("failed to store asynchronous job", "error", err)
+				http.Error(w, `{"error": "Job storage unavailable"}`, http.StatusServiceUnavailable)
+				return
+			}
+			req := r.Clone(ctx)
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+			query := req.URL.Query()
+			query.Del("async")
+			req.URL.RawQuery = query.Encode()
+
+			accepted := *job
+			go m.run(next, req, accepted)
+
+			m.logger.Info("accepted asynchronous job", "request_id", job.RequestID, "job_id", job.ID, "path", job.Path)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", "/v1/jobs/"+job.ID)
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(accepted)
+		})
+	}
+}
+
+// run serves a job's request in the background and records the result.
+func (m *Manager) run(next http.Handler, req *http.Request, job Job) {
+	id := job.ID
+	capture := &jobWriter{header: make(http.Header)}
+	func() {
+		defer func() {
+			if err := recover(); err != nil {
+				m.logger.Error("asynchronous job panicked", "job_id", id, "error", err)
+				capture.status = http.StatusInternalServerError
+			}
+		}()
+		next.ServeHTTP(capture, req)
+	}()
+	if capture.status == 0 {
+		capture.status = http.StatusOK
+	}
+
+	job = m.finish(job, capture)
+	m.logger.Info("asynchronous job finished", "request_id", job.RequestID, "job_id", id, "status", job.Status, "status_code", job.StatusCode)
+	m.publish(job)
+}
+
+// jobEvents maps a finished job's status to its event type.
+var jobEvents = map[string]string{
+	Succeeded: events.JobSucceeded,
+	Failed:    events.JobFailed,
+	Cancelled: events.JobCancelled,
+}
+
+// publish sends the finished job to the configured webhooks and to the one
+// named when it was submitted.
+func (m *Manager) publish(job Job) {
+	var extra []string
+	if job.Webhook != "" {
+		extra = append(extra, job.Webhook)
+	}
+	m.webhooks.Publish(events.Event{
+		Type: jobEvents[job.Status],
+		Time: time.Now().UTC(),
+		Data: map[string]any{"application": job.Application, "job": job},
+	}, extra...)
+}
+
+// Handler serves the job API: GET /v1/jobs lists the caller's jobs, GET
+// /v1/jobs/{id} returns one with its response, and DELETE /v1/jobs/{id}
+// cancels a running job or deletes a finished one. Jobs are visible only to
+// the application that submitted them.
+func (m *Manager) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/jobs"), "/")
+
+		if id == "" {
+			if r.Method != http.MethodGet {
+				http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+				return
+			}
+			jobs, err := m.List(application)
+			if err != nil {
+				m.logger.Warn("failed to list jobs", "application", application, "error", err)
+				http.Error(w, `{"error": "Job storage unavailable"}`, http.StatusServiceUnavailable)
+				return
+			}
+			if jobs == nil {
+				jobs = []Job{}
+			}
+			writeJSON(w, map[string][]Job{"jobs": jobs})
+			return
+		}
+
+		job, ok, err := m.Get(application, id)
+		if err != nil {
+			m.logger.Warn("failed to read job", "job_id", id, "error", err)
+			http.Error(w, `{"error": "Job storage unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		if !ok {
+			http.Error(w, `{"error": "Job not found"}`, http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, job)
+		case http.MethodDelete:
+			if job, _, err = m.Cancel(application, id); err != nil {
+				m.logger.Warn("failed to cancel job", "job_id", id, "error", err)
+				http.Error(w, `{"error": "Job storage unavailable"}`, http.StatusServiceUnavailable)
+				return
+			}
+			job.Response = nil
+			writeJSON(w, job)
+		default:
+			http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Run renews the leases of this replica's running jobs every interval until
+// ctx is done, stopping those cancelled through another replica.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.renew()
+		}
+	}
+}
+
+func (m *Manager) renew() {
+	m.mu.Lock()
+	running := maps.Clone(m.running)
+	m.mu.Unlock()
+
+	for id, r := range running {
+		ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+		if err := m.backend.Set(ctx, leaseKey(id), []byte{'1'}, leaseTTL); err != nil {
+			m.logger.Warn("failed to renew job lease", "job_id", id, "error", err)
+		} else if job, ok, err := m.load(ctx, r.application, id); err == nil && (!ok || job.Finished()) {
+			r.cancel()
+		}
+		cancel()
+	}
+}
+
+// load reads the application's job with the given ID.
+func (m *Manager) load(ctx context.Context, application, id string) (Job, bool, error) {
+	data, ok, err := m.backend.Get(ctx, jobKey(application, id))
+	if err != nil || !ok {
+		return Job{}, false, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return Job{}, false, fmt.Errorf("failed to parse job %s: %w", id, err)
+	}
+	return m.checkLease(ctx, job), true, nil
+}
+
+// checkLease marks a running job failed when no replica holds its lease any
+// more.
+func (m *Manager) checkLease(ctx context.Context, job Job) Job {
+	if job.Status != Running {
+		return job
+	}
+	m.mu.Lock()
+	_, local := m.running[job.ID]
+	m.mu.Unlock()
+	if local {
+		return job
+	}
+	if _, held, err := m.backend.Get(ctx, leaseKey(job.ID)); err != nil || held {
+		return job
+	}
+
+	now := time.Now().UTC()
+	job.Status, job.Error, job.CompletedAt = Failed, errInterrupted, &now
+	if err := m.save(ctx, job); err != nil {
+		m.logger.Warn("failed to store interrupted job", "job_id", job.ID, "error", err)
+	}
+	return job
+}
+
+// save stores job, keeping it for the TTL once it has finished.
+func (m *Manager) save(ctx context.Context, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if job.Finished() {
+		ttl = m.ttl
+	}
+	return m.backend.Set(ctx, jobKey(job.Application, job.ID), data, ttl)
+}
+
+func jobKey(application, id string) string {
+	return storage.Key("jobs", application, id)
+}
+
+func leaseKey(id string) string {
+	return storage.Key("job-leases", id)
+}
+
+// jobWriter captures a job's response in place of the client connection.
+type jobWriter struct {
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (j *jobWriter) Header() http.Header {
+	return j.header
+}
+
+func (j *jobWriter) WriteHeader(code int) {
+	if j.status == 0 {
+		j.status = code
+	}
+}
+
+func (j *jobWriter) Write(p []byte) (int, error) {
+	if j.status == 0 {
+		j.status = http.StatusOK
+	}
+	if room := maxBodySize - j.body.Len(); room < len(p) {
+		j.body.Write(p[:max(room, 0)])
+		j.truncated = true
+		return len(p), nil
+	}
+	return j.body.Write(p)
+}
+
+// Flush is a no-op; handlers that flush see the whole response buffered.
+func (j *jobWriter) Flush() {}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "job_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,331 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/storage"
+	"github.com/amscotti/portus/internal/webhook"
+)
+
+func newTestManager(t *testing.T, backend storage.Backend, maxActive int) *Manager {
+	t.Helper()
+	if backend == nil {
+		backend = storage.NewMemory()
+	}
+	return New(backend, time.Hour, maxActive, []string{"hooks.example.com"}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// submitAsync sends an async request for application through the middleware.
+func submitAsync(t *testing.T, h http.Handler, application, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions?async=1", strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, application))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// waitFinished polls until the application's job has finished.
+func waitFinished(t *testing.T, m *Manager, application, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok, _ := m.Get(application, id); ok && job.Finished() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	m := newTestManager(t, nil, 0)
+	release := make(chan struct{})
+	h := m.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		if r.Context().Err() != nil {
+			t.Error("expected the job to outlive the client request")
+		}
+		if r.URL.Query().Has("async") {
+			t.Error("expected the async parameter to be removed")
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"echo":` + string(body) + `}`))
+	}))
+
+	rec := submitAsync(t, h, "tool", `{"model":"gpt"}`, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var accepted Job
+	json.Unmarshal(rec.Body.Bytes(), &accepted)
+	if accepted.Status != Running || !strings.HasPrefix(accepted.ID, "job_") || rec.Header().Get("Location") != "/v1/jobs/"+accepted.ID {
+		t.Fatalf("unexpected accepted job: %+v, Location %q", accepted, rec.Header().Get("Location"))
+	}
+
+	close(release)
+	job := waitFinished(t, m, "tool", accepted.ID)
+	if job.Status != Succeeded || job.StatusCode != http.StatusOK || string(job.Response) != `{"echo":{"model":"gpt"}}` {
+		t.Errorf("unexpected finished job: %+v", job)
+	}
+}
+
+func TestMiddleware_PassThroughAndRejections(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		header     http.Header
+		full       bool
+		wantStatus int
+	}{
+		{name: "synchronous request", target: "/v1/chat/completions", body: `{}`, wantStatus: http.StatusTeapot},
+		{name: "async disabled", target: "/v1/chat/completions?async=0", body: `{}`, wantStatus: http.StatusTeapot},
+		{name: "streaming", target: "/v1/chat/completions?async=1", body: `{"stream":true}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid webhook", target: "/v1/chat/completions?async=1", body: `{}`, header: http.Header{WebhookHeader: {"ftp://example.com"}}, wantStatus: http.StatusBadRequest},
+		{name: "webhook host not allowed", target: "/v1/chat/completions?async=1", body: `{}`, header: http.Header{WebhookHeader: {"http://169.254.169.254/latest"}}, wantStatus: http.StatusForbidden},
+		{name: "too many jobs", target: "/v1/chat/completions?async=1", body: `{}`, full: true, wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			m := newTestManager(t, nil, 1)
+			if tt.full {
+				// Fill the only slot with a job that never finishes
+				m.running["job_busy"] = runningJob{cancel: func() {}}
+			}
+			h := m.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestMiddleware_FailedAndWebhook(t *testing.T) {
+	t.Parallel()
+
+	notified := make(chan Job, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev struct {
+			Type string `json:"type"`
+			Data struct {
+				Job Job `json:"job"`
+			} `json:"data"`
+		}
+		json.NewDecoder(r.Body).Decode(&ev)
+		if ev.Type != events.JobFailed {
+			t.Errorf("expected a %s event, got %q", events.JobFailed, ev.Type)
+		}
+		notified <- ev.Data.Job
+	}))
+	t.Cleanup(hook.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	webhooks, err := webhook.New(webhook.Config{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { webhooks.Close(context.Background()) })
+	m := New(storage.NewMemory(), time.Hour, 0, []string{"127.0.0.1"}, webhooks, logger)
+	h := m.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("upstream unavailable"))
+	}))
+
+	rec := submitAsync(t, h, "tool", `{}`, http.Header{WebhookHeader: {hook.URL}})
+	var accepted Job
+	json.Unmarshal(rec.Body.Bytes(), &accepted)
+
+	select {
+	case job := <-notified:
+		if job.ID != accepted.ID || job.Status != Failed || job.StatusCode != http.StatusBadGateway || string(job.Response) != `"upstream unavailable"` {
+			t.Errorf("unexpected webhook payload: %+v", job)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the webhook to be called")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	m := newTestManager(t, nil, 0)
+	release := make(chan struct{})
+	h := m.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+			w.Write([]byte(`{"ok":true}`))
+		case <-r.Context().Done():
+		}
+	}))
+	var done, running Job
+	json.Unmarshal(submitAsync(t, h, "tool", `{}`, nil).Body.Bytes(), &done)
+	close(release)
+	waitFinished(t, m, "tool", done.ID)
+	release = make(chan struct{})
+	json.Unmarshal(submitAsync(t, h, "tool", `{}`, nil).Body.Bytes(), &running)
+
+	serve := func(method, path, application string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, application))
+		rec := httptest.NewRecorder()
+		m.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		app        string
+		wantStatus int
+		wantJob    string
+	}{
+		{name: "get own job", method: http.MethodGet, path: "/v1/jobs/" + done.ID, app: "tool", wantStatus: http.StatusOK, wantJob: Succeeded},
+		{name: "other application", method: http.MethodGet, path: "/v1/jobs/" + done.ID, app: "other", wantStatus: http.StatusNotFound},
+		{name: "unknown job", method: http.MethodGet, path: "/v1/jobs/job_missing", app: "tool", wantStatus: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodPost, path: "/v1/jobs/" + done.ID, app: "tool", wantStatus: http.StatusMethodNotAllowed},
+		{name: "cancel running job", method: http.MethodDelete, path: "/v1/jobs/" + running.ID, app: "tool", wantStatus: http.StatusOK, wantJob: Cancelled},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.method, tt.path, tt.app)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.wantStatus, rec.Code)
+			continue
+		}
+		if tt.wantJob != "" {
+			var job Job
+			json.Unmarshal(rec.Body.Bytes(), &job)
+			if job.Status != tt.wantJob {
+				t.Errorf("%s: expected status %q, got %+v", tt.name, tt.wantJob, job)
+			}
+		}
+	}
+
+	// A cancelled job stays cancelled once its handler returns
+	if job := waitFinished(t, m, "tool", running.ID); job.Status != Cancelled {
+		t.Errorf("expected the job to stay cancelled, got %+v", job)
+	}
+
+	var list struct {
+		Jobs []Job `json:"jobs"`
+	}
+	json.Unmarshal(serve(http.MethodGet, "/v1/jobs", "tool").Body.Bytes(), &list)
+	if len(list.Jobs) != 2 || list.Jobs[0].Response != nil {
+		t.Errorf("expected two jobs without responses, got %+v", list.Jobs)
+	}
+
+	// Deleting a finished job removes it
+	serve(http.MethodDelete, "/v1/jobs/"+done.ID, "tool")
+	if _, ok, _ := m.Get("tool", done.ID); ok {
+		t.Error("expected the finished job to be deleted")
+	}
+}
+
+func TestManager_SharedBackend(t *testing.T) {
+	t.Parallel()
+
+	// Two replicas sharing a backend
+	backend := storage.NewMemory()
+	a, b := newTestManager(t, backend, 0), newTestManager(t, backend, 0)
+	h := a.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	var accepted Job
+	json.Unmarshal(submitAsync(t, h, "tool", `{}`, nil).Body.Bytes(), &accepted)
+
+	if job, ok, err := b.Get("tool", accepted.ID); err != nil || !ok || job.Status != Running {
+		t.Fatalf("expected the job visible as running on the other replica, got %+v, %v, %v", job, ok, err)
+	}
+	if list, _ := b.List("tool"); len(list) != 1 {
+		t.Errorf("expected the job listed on the other replica, got %+v", list)
+	}
+
+	// Cancelling through the other replica stops the job where it runs
+	if job, _, err := b.Cancel("tool", accepted.ID); err != nil || job.Status != Cancelled {
+		t.Fatalf("expected the job cancelled, got %+v, %v", job, err)
+	}
+	a.renew()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.mu.Lock()
+		left := len(a.running)
+		a.mu.Unlock()
+		if left == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the cancelled job to stop running")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if job, _, _ := a.Get("tool", accepted.ID); job.Status != Cancelled {
+		t.Errorf("expected the job to stay cancelled, got %+v", job)
+	}
+}
+
+func TestManager_Interrupted(t *testing.T) {
+	t.Parallel()
+
+	// A running job nobody holds the lease of was lost with its replica
+	backend := storage.NewMemory()
+	m := newTestManager(t, backend, 0)
+	ctx := context.Background()
+	m.save(ctx, Job{ID: "job_lost", Status: Running, Application: "tool"})
+	if job, _, _ := m.Get("tool", "job_lost"); job.Status != Failed || job.Error != errInterrupted {
+		t.Errorf("expected the interrupted job to be failed, got %+v", job)
+	}
+
+	backend.Set(ctx, leaseKey("job_alive"), []byte{'1'}, time.Minute)
+	m.save(ctx, Job{ID: "job_alive", Status: Running, Application: "tool"})
+	if job, _, _ := m.Get("tool", "job_alive"); job.Status != Running {
+		t.Errorf("expected a job with a live lease to keep running, got %+v", job)
+	}
+}
+
+func TestManager_FinishedJobsExpire(t *testing.T) {
+	t.Parallel()
+
+	m := New(storage.NewMemory(), 50*time.Millisecond, 0, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h := m.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	var accepted Job
+	json.Unmarshal(submitAsync(t, h, "tool", `{}`, nil).Body.Bytes(), &accepted)
+	waitFinished(t, m, "tool", accepted.ID)
+
+	time.Sleep(100 * time.Millisecond)
+	if _, ok, _ := m.Get("tool", accepted.ID); ok {
+		t.Error("expected the finished job to expire after the TTL")
+	}
+}
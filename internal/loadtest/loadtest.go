@@ -0,0 +1,383 @@
+// Package loadtest generates synthetic chat traffic from a profile and
+// collects latency and error statistics, for "portus loadtest".
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+// Placeholder is replaced in prompt templates with filler text of the drawn
+// prompt size.
+const Placeholder = "{{text}}"
+
+// Default profile values.
+const (
+	DefaultRate        = 1
+	DefaultConcurrency = 10
+	DefaultPromptSize  = 100
+)
+
+// Profile describes a traffic mix: which aliases are called and how often,
+// how many requests stream and how large prompts are.
+type Profile struct {
+	// Duration is how long to send traffic, in seconds.
+	Duration int `json:"duration"`
+	// Requests stops the run after this many requests.
+	Requests int `json:"requests"`
+	// Rate is the number of requests started per second.
+	Rate float64 `json:"rate"`
+	// Concurrency caps the requests in flight; a request due while all are
+	// busy is skipped.
+	Concurrency int `json:"concurrency"`
+	// Streaming is the percentage of requests sent with stream: true.
+	Streaming float64 `json:"streaming"`
+	// MaxTokens is sent as max_tokens when set.
+	MaxTokens int `json:"max_tokens"`
+	// Aliases maps each alias to its relative weight.
+	Aliases map[string]int `json:"aliases"`
+	// PromptSizes are weighted ranges of filler words per prompt.
+	PromptSizes []PromptSize `json:"prompt_sizes"`
+	// Templates are prompts picked at random; Placeholder is replaced with
+	// the filler text.
+	Templates []string `json:"templates"`
+	// Seed makes the generated traffic repeatable; zero picks a random one.
+	Seed uint64 `json:"seed"`
+}
+
+// PromptSize is a range of filler words, each roughly one token, drawn
+// uniformly.
+type PromptSize struct {
+	Min    int `json:"min"`
+	Max    int `json:"max"`
+	Weight int `json:"weight"`
+}
+
+// Load reads and validates a profile file, filling in defaults.
+func Load(path string) (Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Profile{}, err
+	}
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Profile{}, fmt.Errorf("invalid profile %s: %w", path, err)
+	}
+	if err := p.normalize(); err != nil {
+		return Profile{}, fmt.Errorf("invalid profile %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// normalize validates p and fills in defaults.
+func (p *Profile) normalize() error {
+	if p.Duration <= 0 && p.Requests <= 0 {
+		return errors.New("duration or requests is required")
+	}
+	if p.Duration < 0 || p.Requests < 0 || p.Rate < 0 || p.Concurrency < 0 || p.MaxTokens < 0 {
+		return errors.New("duration, requests, rate, concurrency and max_tokens must not be negative")
+	}
+	if p.Streaming < 0 || p.Streaming > 100 {
+		return fmt.Errorf("streaming must be a percentage, got %v", p.Streaming)
+	}
+	if len(p.Aliases) == 0 {
+		return errors.New("aliases is required")
+	}
+	for alias, weight := range p.Aliases {
+		if weight <= 0 {
+			return fmt.Errorf("alias %s: weight must be positive", alias)
+		}
+	}
+	for i, size := range p.PromptSizes {
+		if size.Min < 0 || size.Max < size.Min || size.Weight <= 0 {
+			return fmt.Errorf("prompt_sizes[%d]: need 0 <= min <= max and a positive weight", i)
+		}
+	}
+
+	if p.Rate == 0 {
+		p.Rate = DefaultRate
+	}
+	if p.Concurrency == 0 {
+		p.Concurrency = DefaultConcurrency
+	}
+	if len(p.PromptSizes) == 0 {
+		p.PromptSizes = []PromptSize{{Min: DefaultPromptSize, Max: DefaultPromptSize, Weight: 1}}
+	}
+	if len(p.Templates) == 0 {
+		p.Templates = []string{Placeholder}
+	}
+	return nil
+}
+
+// AliasNames returns the profile's aliases, sorted.
+func (p Profile) AliasNames() []string {
+	names := make([]string, 0, len(p.Aliases))
+	for alias := range p.Aliases {
+		names = append(names, alias)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// words fill prompts; most are a single token in common tokenizers.
+var words = strings.Fields(`the of and to in is that for it as was with be by on not he this are or
+his from at which but have an they you were her she there one all we their can has been if more when
+will would who so no time out up data system report value model market result number water city year`)
+
+// Generator draws requests from a profile.
+type Generator struct {
+	profile Profile
+	aliases []string
+	rng     *rand.Rand
+}
+
+// NewGenerator returns a generator for a validated profile, seeded from
+// its Seed.
+func NewGenerator(p Profile) *Generator {
+	seed := p.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &Generator{profile: p, aliases: p.AliasNames(), rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// Next returns the next generated chat request.
+func (g *Generator) Next() models.ChatCompletionRequest {
+	total := 0
+	for _, alias := range g.aliases {
+		total += g.profile.Aliases[alias]
+	}
+	n := g.rng.IntN(total)
+	var alias string
+	for _, alias = range g.aliases {
+		if n -= g.profile.Aliases[alias]; n < 0 {
+			break
+		}
+	}
+
+	total = 0
+	for _, size := range g.profile.PromptSizes {
+		total += size.Weight
+	}
+	n = g.rng.IntN(total)
+	var size PromptSize
+	for _, size = range g.profile.PromptSizes {
+		if n -= size.Weight; n < 0 {
+			break
+		}
+	}
+	count := size.Min + g.rng.IntN(size.Max-size.Min+1)
+	filler := make([]string, count)
+	for i := range filler {
+		filler[i] = words[g.rng.IntN(len(words))]
+	}
+
+	template := g.profile.Templates[g.rng.IntN(len(g.profile.Templates))]
+	return models.ChatCompletionRequest{
+		Model:     alias,
+		Messages:  []models.Message{{Role: "user", Content: strings.ReplaceAll(template, Placeholder, strings.Join(filler, " "))}},
+		Stream:    g.rng.Float64()*100 < g.profile.Streaming,
+		MaxTokens: g.profile.MaxTokens,
+	}
+}
+
+// Sender sends one request and returns the response status and the time
+// until its first byte.
+type Sender func(ctx context.Context, chat models.ChatCompletionRequest) (int, time.Duration, error)
+
+// Result is the outcome of one generated request.
+type Result struct {
+	Alias     string
+	Stream    bool
+	Status    int
+	Err       error
+	Latency   time.Duration
+	FirstByte time.Duration
+}
+
+// OK reports whether the request succeeded.
+func (r Result) OK() bool {
+	return r.Err == nil && r.Status == http.StatusOK
+}
+
+// Run sends the profile's traffic through send at its rate until its
+// duration or request count is reached, or ctx ends, then waits for the
+// requests in flight. Each finished request is passed to progress when set.
+func Run(ctx context.Context, p Profile, send Sender, progress func(Result)) *Report {
+	report := &Report{}
+	if p.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(p.Duration)*time.Second)
+		defer cancel()
+	}
+
+	gen := NewGenerator(p)
+	slots := make(chan struct{}, p.Concurrency)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / p.Rate))
+	defer ticker.Stop()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	start := time.Now()
+
+	for sent := 0; p.Requests == 0 || sent < p.Requests; sent++ {
+		if sent > 0 {
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		chat := gen.Next()
+		select {
+		case slots <- struct{}{}:
+		default:
+			// Open loop: a saturated gateway shows up as skipped requests
+			// rather than a lower rate
+			mu.Lock()
+			report.Skipped++
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			// Requests in flight finish even after the run's deadline
+			began := time.Now()
+			status, firstByte, err := send(context.WithoutCancel(ctx), chat)
+			result := Result{Alias: chat.Model, Stream: chat.Stream, Status: status, Err: err, Latency: time.Since(began), FirstByte: firstByte}
+			mu.Lock()
+			report.add(result)
+			if progress != nil {
+				progress(result)
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	return report
+}
+
+// Report aggregates the results of a run.
+type Report struct {
+	Results []Result
+	// Skipped counts requests not sent because every slot was busy.
+	Skipped int
+	Elapsed time.Duration
+}
+
+func (r *Report) add(result Result) {
+	r.Results = append(r.Results, result)
+}
+
+// Failed returns the number of requests that did not succeed.
+func (r *Report) Failed() int {
+	failed := 0
+	for _, result := range r.Results {
+		if !result.OK() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Summary holds the statistics of a group of results.
+type Summary struct {
+	Requests  int
+	Errors    int
+	Streaming int
+	// Statuses counts responses by status; transport errors count as 0.
+	Statuses map[int]int
+	// Latency and FirstByte percentiles of successful requests.
+	P50, P95, P99 time.Duration
+	FirstByteP50  time.Duration
+	FirstByteP95  time.Duration
+}
+
+// Summarize returns the statistics of the results matching alias, or of all
+// results when alias is empty.
+func (r *Report) Summarize(alias string) Summary {
+	s := Summary{Statuses: make(map[int]int)}
+	var latencies, firstBytes []time.Duration
+	for _, result := range r.Results {
+		if alias != "" && result.Alias != alias {
+			continue
+		}
+		s.Requests++
+		if result.Stream {
+			s.Streaming++
+		}
+		if result.Err != nil {
+			s.Statuses[0]++
+		} else {
+			s.Statuses[result.Status]++
+		}
+		if !result.OK() {
+			s.Errors++
+			continue
+		}
+		latencies = append(latencies, result.Latency)
+		firstBytes = append(firstBytes, result.FirstByte)
+	}
+	s.P50, s.P95, s.P99 = percentile(latencies, 50), percentile(latencies, 95), percentile(latencies, 99)
+	s.FirstByteP50, s.FirstByteP95 = percentile(firstBytes, 50), percentile(firstBytes, 95)
+	return s
+}
+
+// percentile returns the nearest-rank percentile of durations.
+func percentile(durations []time.Duration, p int) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// Write prints a line per alias and a total line.
+func (r *Report) Write(w io.Writer, aliases []string) {
+	line := func(name string, s Summary) {
+		statuses := make([]int, 0, len(s.Statuses))
+		for status := range s.Statuses {
+			statuses = append(statuses, status)
+		}
+		slices.Sort(statuses)
+		counts := make([]string, len(statuses))
+		for i, status := range statuses {
+			label := fmt.Sprint(status)
+			if status == 0 {
+				label = "error"
+			}
+			counts[i] = fmt.Sprintf("%s=%d", label, s.Statuses[status])
+		}
+		fmt.Fprintf(w, "%s: %d requests (%d streaming), %d errors, latency p50 %dms p95 %dms p99 %dms, first byte p50 %dms p95 %dms [%s]\n",
+			name, s.Requests, s.Streaming, s.Errors, s.P50.Milliseconds(), s.P95.Milliseconds(), s.P99.Milliseconds(),
+			s.FirstByteP50.Milliseconds(), s.FirstByteP95.Milliseconds(), strings.Join(counts, " "))
+	}
+	for _, alias := range aliases {
+		line(alias, r.Summarize(alias))
+	}
+	line("total", r.Summarize(""))
+	rate := 0.0
+	if r.Elapsed > 0 {
+		rate = float64(len(r.Results)) / r.Elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "sent %d requests in %s (%.2f/s), %d skipped at the concurrency limit\n",
+		len(r.Results), r.Elapsed.Round(time.Millisecond), rate, r.Skipped)
+}
@@ -0,0 +1,187 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		profile string
+		wantErr string
+	}{
+		{name: "valid", profile: `{"requests": 10, "aliases": {"fast": 1}}`},
+		{name: "no stop condition", profile: `{"aliases": {"fast": 1}}`, wantErr: "duration or requests"},
+		{name: "no aliases", profile: `{"duration": 5}`, wantErr: "aliases is required"},
+		{name: "zero weight", profile: `{"duration": 5, "aliases": {"fast": 0}}`, wantErr: "weight must be positive"},
+		{name: "streaming over 100", profile: `{"duration": 5, "streaming": 120, "aliases": {"fast": 1}}`, wantErr: "percentage"},
+		{name: "inverted prompt size", profile: `{"duration": 5, "aliases": {"fast": 1}, "prompt_sizes": [{"min": 10, "max": 5, "weight": 1}]}`, wantErr: "prompt_sizes[0]"},
+		{name: "malformed", profile: `{`, wantErr: "invalid profile"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "profile.json")
+			if err := os.WriteFile(path, []byte(tt.profile), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			p, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Rate != DefaultRate || p.Concurrency != DefaultConcurrency || len(p.Templates) != 1 || len(p.PromptSizes) != 1 {
+				t.Errorf("expected defaults to be filled in, got %+v", p)
+			}
+		})
+	}
+}
+
+func TestGenerator_Mix(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Requests:    1,
+		Streaming:   25,
+		MaxTokens:   64,
+		Aliases:     map[string]int{"big": 1, "small": 3},
+		PromptSizes: []PromptSize{{Min: 5, Max: 10, Weight: 1}},
+		Templates:   []string{"Summarize: " + Placeholder},
+		Seed:        7,
+	}
+	if err := p.normalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 4000
+	gen := NewGenerator(p)
+	counts := map[string]int{}
+	streaming := 0
+	for range n {
+		chat := gen.Next()
+		counts[chat.Model]++
+		if chat.Stream {
+			streaming++
+		}
+		prompt := chat.Messages[0].Content.(string)
+		words := len(strings.Fields(strings.TrimPrefix(prompt, "Summarize: ")))
+		if !strings.HasPrefix(prompt, "Summarize: ") || words < 5 || words > 10 {
+			t.Fatalf("unexpected prompt %q", prompt)
+		}
+		if chat.MaxTokens != 64 {
+			t.Fatalf("expected max_tokens 64, got %d", chat.MaxTokens)
+		}
+	}
+	if share := float64(counts["small"]) / n; share < 0.7 || share > 0.8 {
+		t.Errorf("expected about 75%% small, got %.2f", share)
+	}
+	if share := float64(streaming) / n; share < 0.2 || share > 0.3 {
+		t.Errorf("expected about 25%% streaming, got %.2f", share)
+	}
+
+	// The same seed generates the same traffic
+	first, again := NewGenerator(p).Next(), NewGenerator(p).Next()
+	if first.Model != again.Model || first.Messages[0].Content != again.Messages[0].Content {
+		t.Error("expected a seeded generator to be repeatable")
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{Requests: 20, Rate: 1000, Concurrency: 50, Aliases: map[string]int{"fast": 1, "flaky": 1}}
+	if err := p.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	send := func(ctx context.Context, chat models.ChatCompletionRequest) (int, time.Duration, error) {
+		calls.Add(1)
+		time.Sleep(time.Millisecond)
+		if chat.Model == "flaky" {
+			return 429, time.Millisecond, nil
+		}
+		return 200, time.Millisecond, nil
+	}
+
+	report := Run(context.Background(), p, send, nil)
+	if calls.Load() != 20 || len(report.Results) != 20 {
+		t.Fatalf("expected 20 requests, got %d calls and %d results", calls.Load(), len(report.Results))
+	}
+	fast, flaky := report.Summarize("fast"), report.Summarize("flaky")
+	if fast.Errors != 0 || fast.P50 <= 0 || fast.Statuses[200] != fast.Requests {
+		t.Errorf("unexpected fast summary: %+v", fast)
+	}
+	if flaky.Errors != flaky.Requests || flaky.Statuses[429] != flaky.Requests || flaky.P50 != 0 {
+		t.Errorf("unexpected flaky summary: %+v", flaky)
+	}
+	if report.Failed() != flaky.Requests {
+		t.Errorf("expected %d failures, got %d", flaky.Requests, report.Failed())
+	}
+
+	var out bytes.Buffer
+	report.Write(&out, p.AliasNames())
+	if !strings.Contains(out.String(), "flaky: ") || !strings.Contains(out.String(), "429=") || !strings.Contains(out.String(), "sent 20 requests") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
+
+func TestRun_SkipsAtConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{Requests: 5, Rate: 1000, Concurrency: 1, Aliases: map[string]int{"slow": 1}}
+	if err := p.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	send := func(ctx context.Context, chat models.ChatCompletionRequest) (int, time.Duration, error) {
+		<-release
+		return 0, 0, errors.New("connection refused")
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+
+	report := Run(context.Background(), p, send, nil)
+	if len(report.Results) != 1 || report.Skipped != 4 {
+		t.Fatalf("expected 1 result and 4 skipped, got %d and %d", len(report.Results), report.Skipped)
+	}
+	if s := report.Summarize(""); s.Statuses[0] != 1 || s.Errors != 1 {
+		t.Errorf("expected a transport error, got %+v", s)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+
+	durations := make([]time.Duration, 100)
+	for i := range durations {
+		durations[i] = time.Duration(100-i) * time.Millisecond
+	}
+	for p, want := range map[int]time.Duration{50: 50 * time.Millisecond, 95: 95 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond} {
+		if got := percentile(durations, p); got != want {
+			t.Errorf("p%d = %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("expected 0 for no durations, got %v", got)
+	}
+}
@@ -0,0 +1,139 @@
+// Package loglevel changes the log level of a running process, reverting to
+// the configured level after a timeout so verbose logging enabled during an
+// incident is never left on.
+package loglevel
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status reports the current and configured levels.
+type Status struct {
+	Level string `json:"level"`
+	Base  string `json:"base"`
+	// RevertsAt is when the level returns to Base; nil while at Base.
+	RevertsAt *time.Time `json:"reverts_at,omitempty"`
+}
+
+// Controller is a slog.Leveler whose level can be changed temporarily. It is
+// safe for concurrent use.
+type Controller struct {
+	level slog.LevelVar
+	base  slog.Level
+
+	mu       sync.Mutex
+	logger   *slog.Logger
+	timer    *time.Timer
+	revertAt time.Time
+	// changes counts level changes so a revert scheduled for an earlier
+	// change can tell it is stale.
+	changes uint64
+}
+
+// New creates a controller at the configured base level.
+func New(base slog.Level) *Controller {
+	c := &Controller{base: base}
+	c.level.Set(base)
+	return c
+}
+
+// SetLogger sets the logger that reports level changes. The logger is usually
+// built on the controller itself, so it is attached after construction.
+func (c *Controller) SetLogger(logger *slog.Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logger = logger
+}
+
+// Level implements slog.Leveler.
+func (c *Controller) Level() slog.Level {
+	return c.level.Level()
+}
+
+// Set changes the level until ttl has passed, then reverts to the base
+// level. Setting the base level cancels any pending revert.
+func (c *Controller) Set(level slog.Level, ttl time.Duration, source string) Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopTimer()
+	c.level.Set(level)
+	if level != c.base {
+		change := c.changes
+		c.timer = time.AfterFunc(ttl, func() { c.revert(change) })
+		c.revertAt = time.Now().Add(ttl).UTC()
+	}
+	c.log("log level changed", "level", levelName(level), "source", source, "reverts_in", ttl.String())
+	return c.status()
+}
+
+// Reset returns to the base level immediately.
+func (c *Controller) Reset(source string) Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopTimer()
+	c.level.Set(c.base)
+	c.log("log level reset", "level", levelName(c.base), "source", source)
+	return c.status()
+}
+
+// Status reports the current level and when it reverts.
+func (c *Controller) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status()
+}
+
+func (c *Controller) revert(change uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A later change replaced this revert
+	if c.changes != change {
+		return
+	}
+	c.timer = nil
+	c.revertAt = time.Time{}
+	c.level.Set(c.base)
+	c.log("log level reverted", "level", levelName(c.base))
+}
+
+func (c *Controller) stopTimer() {
+	c.changes++
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.revertAt = time.Time{}
+}
+
+// log reports a level change at warn so it is visible at every level but error.
+func (c *Controller) log(msg string, args ...any) {
+	if c.logger != nil {
+		c.logger.Warn(msg, args...)
+	}
+}
+
+func (c *Controller) status() Status {
+	s := Status{Level: levelName(c.level.Level()), Base: levelName(c.base)}
+	if !c.revertAt.IsZero() {
+		at := c.revertAt
+		s.RevertsAt = &at
+	}
+	return s
+}
+
+// ParseLevel parses a level name such as "debug" or "warn".
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+func levelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
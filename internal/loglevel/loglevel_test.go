@@ -0,0 +1,63 @@
+package loglevel
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestController_SetAndRevert(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	c := New(slog.LevelInfo)
+	c.SetLogger(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: c})))
+
+	status := c.Set(slog.LevelDebug, 20*time.Millisecond, "test")
+	if c.Level() != slog.LevelDebug || status.Level != "debug" || status.Base != "info" || status.RevertsAt == nil {
+		t.Fatalf("unexpected status after Set: %+v", status)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for c.Level() != slog.LevelInfo && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status := c.Status(); status.Level != "info" || status.RevertsAt != nil {
+		t.Errorf("expected level to revert to info, got %+v", status)
+	}
+	if !strings.Contains(logs.String(), "log level changed") || !strings.Contains(logs.String(), "log level reverted") {
+		t.Errorf("expected level changes to be logged:\n%s", logs.String())
+	}
+}
+
+func TestController_LaterChangeReplacesRevert(t *testing.T) {
+	t.Parallel()
+
+	c := New(slog.LevelWarn)
+	c.Set(slog.LevelDebug, 10*time.Millisecond, "test")
+	c.Set(slog.LevelInfo, time.Hour, "test")
+	time.Sleep(30 * time.Millisecond)
+	if c.Level() != slog.LevelInfo {
+		t.Errorf("expected the earlier revert to be cancelled, got %v", c.Level())
+	}
+
+	if status := c.Reset("test"); status.Level != "warn" || status.RevertsAt != nil {
+		t.Errorf("unexpected status after Reset: %+v", status)
+	}
+	if status := c.Set(slog.LevelWarn, time.Hour, "test"); status.RevertsAt != nil {
+		t.Errorf("setting the base level should not schedule a revert: %+v", status)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	t.Parallel()
+
+	if level, err := ParseLevel("debug"); err != nil || level != slog.LevelDebug {
+		t.Errorf("ParseLevel(debug) = %v, %v", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expected an unknown level to be rejected")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package loglevel
+
+import (
+	"context"
+	"time"
+)
+
+// HandleSignals is a no-op on platforms without SIGUSR1 and SIGUSR2; use the
+// admin API instead.
+func (c *Controller) HandleSignals(ctx context.Context, ttl time.Duration) {}
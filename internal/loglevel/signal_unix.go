@@ -0,0 +1,33 @@
+//go:build unix
+
+package loglevel
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// HandleSignals switches to debug logging for ttl on SIGUSR1 and back to the
+// base level on SIGUSR2 until ctx ends.
+func (c *Controller) HandleSignals(ctx context.Context, ttl time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			if sig == syscall.SIGUSR1 {
+				c.Set(slog.LevelDebug, ttl, "SIGUSR1")
+			} else {
+				c.Reset("SIGUSR2")
+			}
+		}
+	}
+}
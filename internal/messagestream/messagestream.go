@@ -0,0 +1,327 @@
+// Package messagestream validates the event sequence of Anthropic Messages
+// streams (message_start, then content blocks, then message_delta and
+// message_stop) and optionally repairs malformed sequences, so a misbehaving
+// upstream cannot crash clients that rely on the documented order.
+package messagestream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Modes.
+const (
+	// ModeLog reports violations but relays the stream unchanged.
+	ModeLog = "log"
+	// ModeRepair fixes violations where it can and otherwise ends the stream
+	// with an error event.
+	ModeRepair = "repair"
+)
+
+// ParseMode validates a PORTUS_MESSAGE_STREAM_VALIDATION value; empty
+// disables validation.
+func ParseMode(value string) (string, error) {
+	switch value {
+	case "", ModeLog, ModeRepair:
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown validation mode %q (must be %q or %q)", value, ModeLog, ModeRepair)
+}
+
+type state int
+
+const (
+	awaitingStart state = iota
+	inMessage
+	inBlock
+	delivered // message_delta seen
+	stopped   // message_stop or error seen
+)
+
+// event is one server-sent event.
+type event struct {
+	raw  []byte // the event as received, including its terminating blank line
+	typ  string
+	data map[string]json.RawMessage
+}
+
+// Reader relays an event stream while validating it. Read violations with
+// Violations once the stream has been consumed.
+type Reader struct {
+	src    *bufio.Reader
+	repair bool
+
+	pending      []byte
+	err          error
+	state        state
+	blocks       int         // content blocks started so far
+	open         int         // client index of the open block
+	indexes      map[int]int // upstream block index to client index
+	outputTokens json.RawMessage
+
+	violations []string
+	terminated bool
+}
+
+// NewReader wraps an Anthropic Messages event stream in the given mode.
+func NewReader(r io.Reader, mode string) *Reader {
+	return &Reader{src: bufio.NewReader(r), repair: mode == ModeRepair, indexes: make(map[int]int)}
+}
+
+// Violations describes each sequence violation seen, in order.
+func (r *Reader) Violations() []string {
+	return r.violations
+}
+
+// Terminated reports whether the stream was ended early with an error event
+// because it could not be repaired.
+func (r *Reader) Terminated() bool {
+	return r.terminated
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.next()
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// next reads and processes one event, or records the end of the stream.
+func (r *Reader) next() {
+	ev, err := r.readEvent()
+	if len(ev.raw) > 0 {
+		r.process(ev)
+	}
+	if err == nil {
+		return
+	}
+	if errors.Is(err, io.EOF) && r.state != stopped {
+		r.violate("stream ended before message_stop")
+		r.terminate("upstream stream ended unexpectedly")
+	}
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// readEvent reads lines up to and including the blank line ending an event.
+func (r *Reader) readEvent() (event, error) {
+	var ev event
+	for {
+		line, err := r.src.ReadBytes('\n')
+		ev.raw = append(ev.raw, line...)
+		content := bytes.TrimRight(line, "\r\n")
+		if field, ok := bytes.CutPrefix(content, []byte("event:")); ok {
+			ev.typ = string(bytes.TrimSpace(field))
+		} else if data, ok := bytes.CutPrefix(content, []byte("data:")); ok {
+			if json.Unmarshal(bytes.TrimSpace(data), &ev.data) == nil {
+				var typ string
+				if json.Unmarshal(ev.data["type"], &typ) == nil && typ != "" {
+					ev.typ = typ
+				}
+			}
+		}
+		if err != nil || (len(content) == 0 && len(line) > 0) {
+			return ev, err
+		}
+	}
+}
+
+func (r *Reader) violate(format string, args ...any) {
+	r.violations = append(r.violations, fmt.Sprintf(format, args...))
+}
+
+// emit queues an event as received.
+func (r *Reader) emit(ev event) {
+	r.pending = append(r.pending, ev.raw...)
+}
+
+// synthesize queues an event built by the repair, in repair mode only.
+func (r *Reader) synthesize(data map[string]any) {
+	if !r.repair {
+		return
+	}
+	payload, _ := json.Marshal(data)
+	r.pending = fmt.Appendf(r.pending, "event: %s\ndata: %s\n\n", data["type"], payload)
+}
+
+// drop discards an event in repair mode and relays it otherwise.
+func (r *Reader) drop(ev event) {
+	if !r.repair {
+		r.emit(ev)
+	}
+}
+
+// terminate ends the stream with an error event in repair mode.
+func (r *Reader) terminate(message string) {
+	if !r.repair {
+		return
+	}
+	r.synthesize(map[string]any{"type": "error", "error": map[string]string{"type": "api_error", "message": message}})
+	r.terminated = true
+	r.state = stopped
+	r.err = io.EOF
+}
+
+// closeBlock synthesizes the content_block_stop of the open block.
+func (r *Reader) closeBlock() {
+	r.synthesize(map[string]any{"type": "content_block_stop", "index": r.open})
+	r.state = inMessage
+}
+
+func (r *Reader) process(ev event) {
+	if ev.data == nil {
+		// Comments, keep-alives and undecodable events carry no sequence
+		if ev.typ != "" && r.state != stopped {
+			r.violate("%s event has no valid JSON data", ev.typ)
+			r.drop(ev)
+			return
+		}
+		r.emit(ev)
+		return
+	}
+
+	switch ev.typ {
+	case "ping":
+		r.emit(ev)
+		return
+	case "error":
+		r.emit(ev)
+		r.state = stopped
+		return
+	}
+
+	if r.state == stopped {
+		r.violate("%s after message_stop", ev.typ)
+		r.drop(ev)
+		return
+	}
+	if r.state == awaitingStart && ev.typ != "message_start" {
+		r.violate("%s before message_start", ev.typ)
+		if r.repair {
+			r.terminate("upstream stream did not start with message_start")
+			return
+		}
+		r.state = inMessage
+	}
+
+	switch ev.typ {
+	case "message_start":
+		if r.state != awaitingStart {
+			r.violate("duplicate message_start")
+			r.drop(ev)
+			return
+		}
+		var message struct {
+			Usage map[string]json.RawMessage `json:"usage"`
+		}
+		json.Unmarshal(ev.data["message"], &message)
+		r.outputTokens = message.Usage["output_tokens"]
+		r.emit(ev)
+		r.state = inMessage
+
+	case "content_block_start":
+		if r.state == delivered {
+			r.violate("content_block_start after message_delta")
+			r.drop(ev)
+			return
+		}
+		if r.state == inBlock {
+			r.violate("content_block_start while block %d is open", r.open)
+			r.closeBlock()
+		}
+		index := blockIndex(ev)
+		r.open, r.blocks = r.blocks, r.blocks+1
+		r.indexes[index] = r.open
+		if index != r.open {
+			r.violate("content block index %d out of sequence, expected %d", index, r.open)
+			r.emitIndexed(ev, r.open)
+		} else {
+			r.emit(ev)
+		}
+		r.state = inBlock
+
+	case "content_block_delta", "content_block_stop":
+		index := blockIndex(ev)
+		client, known := r.indexes[index]
+		if r.state != inBlock || !known || client != r.open {
+			r.violate("%s for block %d, which is not open", ev.typ, index)
+			r.drop(ev)
+			return
+		}
+		if client != index {
+			r.emitIndexed(ev, client)
+		} else {
+			r.emit(ev)
+		}
+		if ev.typ == "content_block_stop" {
+			r.state = inMessage
+		}
+
+	case "message_delta":
+		if r.state == delivered {
+			r.violate("duplicate message_delta")
+			r.drop(ev)
+			return
+		}
+		if r.state == inBlock {
+			r.violate("message_delta while block %d is open", r.open)
+			r.closeBlock()
+		}
+		r.emit(ev)
+		r.state = delivered
+
+	case "message_stop":
+		if r.state == inBlock {
+			r.violate("message_stop while block %d is open", r.open)
+			r.closeBlock()
+		}
+		if r.state == inMessage {
+			r.violate("message_stop without message_delta")
+			usage := map[string]json.RawMessage{"output_tokens": json.RawMessage("0")}
+			if r.outputTokens != nil {
+				usage["output_tokens"] = r.outputTokens
+			}
+			r.synthesize(map[string]any{
+				"type":  "message_delta",
+				"delta": map[string]any{"stop_reason": "end_turn", "stop_sequence": nil},
+				"usage": usage,
+			})
+		}
+		r.emit(ev)
+		r.state = stopped
+
+	default:
+		// Unknown event types are relayed for forward compatibility
+		r.emit(ev)
+	}
+}
+
+// emitIndexed queues ev with its content block index rewritten, in repair
+// mode, and as received otherwise.
+func (r *Reader) emitIndexed(ev event, index int) {
+	if !r.repair {
+		r.emit(ev)
+		return
+	}
+	ev.data["index"] = json.RawMessage(fmt.Sprint(index))
+	payload, _ := json.Marshal(ev.data)
+	r.pending = fmt.Appendf(r.pending, "event: %s\ndata: %s\n\n", ev.typ, payload)
+}
+
+// blockIndex returns the content block index of ev, zero when missing.
+func blockIndex(ev event) int {
+	var index int
+	json.Unmarshal(ev.data["index"], &index)
+	return index
+}
@@ -0,0 +1,176 @@
+package messagestream
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+// sse renders events as a stream, naming each after its data type.
+func sse(events ...string) string {
+	var b strings.Builder
+	for _, data := range events {
+		var ev struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(data), &ev)
+		b.WriteString("event: " + ev.Type + "\ndata: " + data + "\n\n")
+	}
+	return b.String()
+}
+
+const (
+	messageStart = `{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":5,"output_tokens":1}}}`
+	blockStart0  = `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`
+	blockDelta0  = `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`
+	blockStop0   = `{"type":"content_block_stop","index":0}`
+	blockStart1  = `{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`
+	blockDelta1  = `{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"there"}}`
+	blockStop1   = `{"type":"content_block_stop","index":1}`
+	messageDelta = `{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":2}}`
+	messageStop  = `{"type":"message_stop"}`
+	ping         = `{"type":"ping"}`
+)
+
+// types lists the event types of a stream in order.
+func types(stream string) []string {
+	var result []string
+	for _, line := range strings.Split(stream, "\n") {
+		if typ, ok := strings.CutPrefix(line, "event: "); ok {
+			result = append(result, typ)
+		}
+	}
+	return result
+}
+
+func TestReader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		stream         string
+		wantTypes      []string
+		wantViolations int
+		wantTerminated bool
+	}{
+		{
+			name:      "valid",
+			stream:    sse(messageStart, ping, blockStart0, blockDelta0, blockStop0, messageDelta, messageStop),
+			wantTypes: []string{"message_start", "ping", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+		},
+		{
+			name:           "missing block stop",
+			stream:         sse(messageStart, blockStart0, blockDelta0, blockStart1, blockDelta1, blockStop1, messageDelta, messageStop),
+			wantTypes:      []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+			wantViolations: 1,
+		},
+		{
+			name:           "delta after block stop",
+			stream:         sse(messageStart, blockStart0, blockStop0, blockDelta0, messageDelta, messageStop),
+			wantTypes:      []string{"message_start", "content_block_start", "content_block_stop", "message_delta", "message_stop"},
+			wantViolations: 1,
+		},
+		{
+			name:           "message delta while block open",
+			stream:         sse(messageStart, blockStart0, blockDelta0, messageDelta, messageStop),
+			wantTypes:      []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+			wantViolations: 1,
+		},
+		{
+			name:           "message stop without delta",
+			stream:         sse(messageStart, blockStart0, blockStop0, messageStop),
+			wantTypes:      []string{"message_start", "content_block_start", "content_block_stop", "message_delta", "message_stop"},
+			wantViolations: 1,
+		},
+		{
+			name:           "duplicate start and events after stop",
+			stream:         sse(messageStart, messageStart, blockStart0, blockStop0, messageDelta, messageStop, blockDelta0),
+			wantTypes:      []string{"message_start", "content_block_start", "content_block_stop", "message_delta", "message_stop"},
+			wantViolations: 2,
+		},
+		{
+			name:           "no message start",
+			stream:         sse(blockStart0, blockDelta0, blockStop0, messageDelta, messageStop),
+			wantTypes:      []string{"error"},
+			wantViolations: 1,
+			wantTerminated: true,
+		},
+		{
+			name:           "truncated",
+			stream:         sse(messageStart, blockStart0, blockDelta0),
+			wantTypes:      []string{"message_start", "content_block_start", "content_block_delta", "error"},
+			wantViolations: 1,
+			wantTerminated: true,
+		},
+		{
+			name:      "upstream error",
+			stream:    sse(messageStart, blockStart0, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`),
+			wantTypes: []string{"message_start", "content_block_start", "error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewReader(strings.NewReader(tt.stream), ModeRepair)
+			out, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := types(string(out)); strings.Join(got, ",") != strings.Join(tt.wantTypes, ",") {
+				t.Errorf("event types = %v, want %v\n%s", got, tt.wantTypes, out)
+			}
+			if len(r.Violations()) != tt.wantViolations {
+				t.Errorf("violations = %q, want %d", r.Violations(), tt.wantViolations)
+			}
+			if r.Terminated() != tt.wantTerminated {
+				t.Errorf("terminated = %v, want %v", r.Terminated(), tt.wantTerminated)
+			}
+		})
+	}
+}
+
+func TestReader_RenumbersBlocks(t *testing.T) {
+	t.Parallel()
+
+	r := NewReader(strings.NewReader(sse(messageStart, blockStart1, blockDelta1, blockStop1, messageDelta, messageStop)), ModeRepair)
+	out, _ := io.ReadAll(r)
+	if strings.Contains(string(out), `"index":1`) || strings.Count(string(out), `"index":0`) != 3 {
+		t.Errorf("expected block 1 to be renumbered to 0:\n%s", out)
+	}
+	if len(r.Violations()) != 1 {
+		t.Errorf("unexpected violations: %q", r.Violations())
+	}
+}
+
+func TestReader_LogModeRelaysUnchanged(t *testing.T) {
+	t.Parallel()
+
+	stream := sse(blockStart0, blockDelta0, blockStart1, messageStop) + ": trailing comment\n\n"
+	r := NewReader(strings.NewReader(stream), ModeLog)
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != stream {
+		t.Errorf("expected the stream unchanged, got:\n%s", out)
+	}
+	if len(r.Violations()) != 4 || r.Terminated() {
+		t.Errorf("unexpected violations %q, terminated %v", r.Violations(), r.Terminated())
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{"", ModeLog, ModeRepair} {
+		if _, err := ParseMode(value); err != nil {
+			t.Errorf("ParseMode(%q): unexpected error %v", value, err)
+		}
+	}
+	if _, err := ParseMode("strict"); err == nil {
+		t.Error("expected unknown mode to be rejected")
+	}
+}
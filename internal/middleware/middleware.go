@@ -3,13 +3,21 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"math/rand/v2"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/freeze"
 	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/override"
+	"github.com/amscotti/portus/internal/storage"
 )
 
 // contextKey is a custom type for context keys to avoid collisions.
@@ -20,16 +28,174 @@ const (
 	ContextKeyApplication contextKey = iota
 	// ContextKeyRequestID stores the request ID in the request context.
 	ContextKeyRequestID
+	// ContextKeyScope stores the authenticated key's scope in the request context.
+	ContextKeyScope
+	// ContextKeyProxyKey stores the authenticated models.ProxyKey in the request context.
+	ContextKeyProxyKey
+	// ContextKeyOperator stores the admin models.ProxyKey that authorized the
+	// request's overrides, when it carries any.
+	ContextKeyOperator
 )
 
-// AuthMiddleware validates proxy keys and adds application info to context.
-func AuthMiddleware(proxyKeys []models.ProxyKey, logger *slog.Logger) func(http.Handler) http.Handler {
+// Keyring holds the accepted proxy keys. The set can be replaced at runtime
+// without restarting, e.g. when the control plane pushes key updates.
+type Keyring struct {
+	keys atomic.Pointer[map[string]models.ProxyKey]
+
+	// overrides records keys disabled or re-enabled at runtime by key ID. They
+	// outlive Replace, so a config push cannot silently revive a cut-off key.
+	mu        sync.RWMutex
+	overrides map[string]bool
+
+	// Shared mode: overrides are kept in the backend and read back by Sync
+	backend storage.Backend
+	logger  *slog.Logger
+}
+
+// NewKeyring creates a keyring accepting the given keys. Runtime overrides
+// last until the process restarts.
+func NewKeyring(proxyKeys []models.ProxyKey) *Keyring {
+	k := &Keyring{}
+	k.Replace(proxyKeys)
+	return k
+}
+
+// NewSharedKeyring creates a keyring accepting the given keys whose runtime
+// overrides are kept in backend, so they apply to every replica using it and
+// survive restarts with a persistent one. The overrides already stored are
+// loaded before it returns.
+func NewSharedKeyring(proxyKeys []models.ProxyKey, backend storage.Backend, logger *slog.Logger) (*Keyring, error) {
+	k := &Keyring{backend: backend, logger: logger}
+	k.Replace(proxyKeys)
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+	if err := k.Sync(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load key overrides: %w", err)
+	}
+	return k, nil
+}
+
+// Replace atomically swaps the accepted keys.
+func (k *Keyring) Replace(proxyKeys []models.ProxyKey) {
 	// Build a map for quick lookup
-	keyMap := make(map[string]string) // key -> application name
+	keyMap := make(map[string]models.ProxyKey, len(proxyKeys))
 	for _, pk := range proxyKeys {
-		keyMap[pk.Key] = pk.Application
+		if pk.Scope == "" {
+			pk.Scope = models.ScopeInference
+		}
+		keyMap[pk.Key] = pk
+	}
+	k.keys.Store(&keyMap)
+}
+
+// Lookup returns the proxy key for a token.
+func (k *Keyring) Lookup(token string) (models.ProxyKey, bool) {
+	pk, ok := (*k.keys.Load())[token]
+	return k.withOverride(pk), ok
+}
+
+// SetDisabled disables or re-enables the key with the given ID, overriding
+// its configured state. It reports false when no key has that ID, and fails
+// without changing anything when a shared override cannot be stored.
+func (k *Keyring) SetDisabled(id string, disabled bool) (models.ProxyKey, bool, error) {
+	for _, pk := range *k.keys.Load() {
+		if pk.ID() != id {
+			continue
+		}
+		if k.backend != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+			defer cancel()
+			value := []byte{'0'}
+			if disabled {
+				value = []byte{'1'}
+			}
+			if err := k.backend.Set(ctx, storage.Key("key-overrides", id), value, 0); err != nil {
+				return models.ProxyKey{}, true, err
+			}
+		}
+		k.mu.Lock()
+		if k.overrides == nil {
+			k.overrides = make(map[string]bool)
+		}
+		k.overrides[id] = disabled
+		k.mu.Unlock()
+		pk.Disabled = disabled
+		return pk, true, nil
 	}
+	return models.ProxyKey{}, false, nil
+}
 
+// Sync replaces the runtime overrides with those stored in the backend, so
+// changes made through other replicas take effect here. It does nothing for
+// keyrings without a backend.
+func (k *Keyring) Sync(ctx context.Context) error {
+	if k.backend == nil {
+		return nil
+	}
+	stored, err := k.backend.Scan(ctx, storage.Prefix("key-overrides"))
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]bool, len(stored))
+	for key, value := range stored {
+		parts := storage.Split(key)
+		overrides[parts[len(parts)-1]] = string(value) == "1"
+	}
+	k.mu.Lock()
+	k.overrides = overrides
+	k.mu.Unlock()
+	return nil
+}
+
+// Run syncs the runtime overrides every interval until ctx is done.
+func (k *Keyring) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			syncCtx, cancel := context.WithTimeout(ctx, storage.Timeout)
+			if err := k.Sync(syncCtx); err != nil {
+				k.logger.Warn("failed to sync key overrides", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+func (k *Keyring) withOverride(pk models.ProxyKey) models.ProxyKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.overrides) == 0 {
+		return pk
+	}
+	if disabled, ok := k.overrides[pk.ID()]; ok {
+		pk.Disabled = disabled
+	}
+	return pk
+}
+
+// Keys returns the accepted keys sorted by application and key.
+func (k *Keyring) Keys() []models.ProxyKey {
+	keyMap := *k.keys.Load()
+	keys := make([]models.ProxyKey, 0, len(keyMap))
+	for _, pk := range keyMap {
+		keys = append(keys, k.withOverride(pk))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Application != keys[j].Application {
+			return keys[i].Application < keys[j].Application
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	return keys
+}
+
+// AuthMiddleware validates proxy keys and adds application info to context.
+// Every attempt to use a disabled key is published to hub for auditing.
+func AuthMiddleware(keyring *Keyring, hub *events.Hub, logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var token string
@@ -63,7 +229,7 @@ func AuthMiddleware(proxyKeys []models.ProxyKey, logger *slog.Logger) func(http.
 			}
 
 			// Validate the key
-			application, valid := keyMap[token]
+			proxyKey, valid := keyring.Lookup(token)
 			if !valid {
 				logger.Warn("invalid authorization key",
 					"path", r.URL.Path,
@@ -73,13 +239,60 @@ func AuthMiddleware(proxyKeys []models.ProxyKey, logger *slog.Logger) func(http.
 				http.Error(w, `{"error": "Invalid Authorization key"}`, http.StatusUnauthorized)
 				return
 			}
+			if proxyKey.Disabled {
+				logger.Warn("disabled authorization key used",
+					"path", r.URL.Path,
+					"remote_addr", r.RemoteAddr,
+					"application", proxyKey.Application,
+					"key_id", proxyKey.ID(),
+				)
+				hub.Publish(events.DisabledKeyUsed, "", map[string]any{
+					"key_id":      proxyKey.ID(),
+					"application": proxyKey.Application,
+					"path":        r.URL.Path,
+					"remote_addr": r.RemoteAddr,
+				})
+				http.Error(w, `{"error": "Authorization key has been disabled"}`, http.StatusUnauthorized)
+				return
+			}
+			if proxyKey.Expired(time.Now()) {
+				logger.Warn("expired authorization key",
+					"path", r.URL.Path,
+					"remote_addr", r.RemoteAddr,
+					"application", proxyKey.Application,
+					"expired_at", proxyKey.ExpiresAt,
+				)
+				http.Error(w, `{"error": "Authorization key has expired"}`, http.StatusUnauthorized)
+				return
+			}
+
+			application := proxyKey.Application
 
-			// Add application to context
+			// Add application and scope to context
 			ctx := context.WithValue(r.Context(), ContextKeyApplication, application)
+			ctx = context.WithValue(ctx, ContextKeyScope, proxyKey.Scope)
+			ctx = context.WithValue(ctx, ContextKeyProxyKey, proxyKey)
+
+			// Overrides need a second, admin key, which goes no further
+			if adminKey := r.Header.Get(override.KeyHeader); adminKey != "" || override.Requested(r.Header) {
+				operator, ok := keyring.Lookup(adminKey)
+				if !ok || operator.Disabled || operator.Expired(time.Now()) || operator.Scope != models.ScopeAdmin {
+					logger.Warn("override without a valid admin key",
+						"path", r.URL.Path,
+						"remote_addr", r.RemoteAddr,
+						"application", application,
+					)
+					http.Error(w, `{"error": "Overrides require a valid admin key in the `+override.KeyHeader+` header"}`, http.StatusForbidden)
+					return
+				}
+				r.Header.Del(override.KeyHeader)
+				ctx = context.WithValue(ctx, ContextKeyOperator, operator)
+			}
 			r = r.WithContext(ctx)
 
-			// Set application on responseWriter if available
-			if rw, ok := w.(*responseWriter); ok {
+			// Set application on the logging responseWriter, which may be
+			// wrapped, e.g. by GzipMiddleware
+			if rw := findResponseWriter(w); rw != nil {
 				rw.application = application
 			}
 
@@ -88,6 +301,79 @@ func AuthMiddleware(proxyKeys []models.ProxyKey, logger *slog.Logger) func(http.
 	}
 }
 
+// WarnExpiringKeys logs a warning for every key that expires within the given
+// window, and an error for keys that have already expired.
+func WarnExpiringKeys(proxyKeys []models.ProxyKey, now time.Time, within time.Duration, logger *slog.Logger) {
+	for _, pk := range proxyKeys {
+		switch {
+		case pk.ExpiresAt.IsZero():
+		case pk.Expired(now):
+			logger.Error("proxy key has expired and is rejected",
+				"application", pk.Application,
+				"scope", pk.Scope,
+				"expired_at", pk.ExpiresAt,
+			)
+		case pk.ExpiresAt.Sub(now) <= within:
+			logger.Warn("proxy key expires soon",
+				"application", pk.Application,
+				"scope", pk.Scope,
+				"expires_at", pk.ExpiresAt,
+				"expires_in", pk.ExpiresAt.Sub(now).Round(time.Minute).String(),
+			)
+		}
+	}
+}
+
+// RequireScope rejects authenticated requests whose key does not have one of the
+// allowed scopes. It must run after AuthMiddleware.
+func RequireScope(logger *slog.Logger, allowed ...models.KeyScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope, _ := r.Context().Value(ContextKeyScope).(models.KeyScope)
+			for _, s := range allowed {
+				if scope == s {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			application, _ := r.Context().Value(ContextKeyApplication).(string)
+			logger.Warn("key scope not permitted",
+				"path", r.URL.Path,
+				"application", application,
+				"scope", scope,
+			)
+			http.Error(w, `{"error": "Key is not permitted to access this endpoint"}`, http.StatusForbidden)
+		})
+	}
+}
+
+// RequireWritable refuses requests that change configuration while read-only
+// mode is on. GET, HEAD and OPTIONS requests always pass.
+func RequireWritable(readOnly *freeze.Switch, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !readOnly.Frozen() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			application, _ := r.Context().Value(ContextKeyApplication).(string)
+			logger.Warn("configuration change refused in read-only mode",
+				"path", r.URL.Path,
+				"method", r.Method,
+				"application", application,
+			)
+			http.Error(w, `{"error": "Configuration is frozen: Portus is in read-only mode"}`, http.StatusLocked)
+		})
+	}
+}
+
 // LoggingMiddleware logs all HTTP requests with structured logging.
 func LoggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -131,6 +417,21 @@ func RequestIDMiddleware() func(http.Handler) http.Handler {
 	}
 }
 
+// findResponseWriter follows Unwrap from w to the responseWriter created by
+// LoggingMiddleware, returning nil when there is none.
+func findResponseWriter(w http.ResponseWriter) *responseWriter {
+	for {
+		switch v := w.(type) {
+		case *responseWriter:
+			return v
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
 // responseWriter wraps http.ResponseWriter to capture the status code.
 type responseWriter struct {
 	http.ResponseWriter
@@ -1,13 +1,24 @@
 package middleware
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/freeze"
 	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/override"
+	"github.com/amscotti/portus/internal/storage"
 )
 
 func TestGenerateRandomString_Uniqueness(t *testing.T) {
@@ -88,7 +99,7 @@ func TestAuthMiddleware_ValidBearerToken(t *testing.T) {
 	logger := newTestLogger()
 	keys := []models.ProxyKey{{Key: "test-key-123", Application: "testapp"}}
 
-	handler := AuthMiddleware(keys, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(NewKeyring(keys), nil, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		app, _ := r.Context().Value(ContextKeyApplication).(string)
 		if app != "testapp" {
 			t.Errorf("expected application 'testapp', got %q", app)
@@ -111,7 +122,7 @@ func TestAuthMiddleware_ValidXAPIKey(t *testing.T) {
 	logger := newTestLogger()
 	keys := []models.ProxyKey{{Key: "api-key-456", Application: "apiapp"}}
 
-	handler := AuthMiddleware(keys, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(NewKeyring(keys), nil, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
@@ -130,7 +141,7 @@ func TestAuthMiddleware_MissingToken(t *testing.T) {
 	logger := newTestLogger()
 	keys := []models.ProxyKey{{Key: "test-key", Application: "app"}}
 
-	handler := AuthMiddleware(keys, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(NewKeyring(keys), nil, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Error("handler should not be called")
 	}))
 
@@ -148,7 +159,7 @@ func TestAuthMiddleware_InvalidToken(t *testing.T) {
 	logger := newTestLogger()
 	keys := []models.ProxyKey{{Key: "valid-key", Application: "app"}}
 
-	handler := AuthMiddleware(keys, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(NewKeyring(keys), nil, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Error("handler should not be called")
 	}))
 
@@ -176,7 +187,7 @@ func TestAuthMiddleware_SetsApplicationOnResponseWriter(t *testing.T) {
 	})
 
 	// Wrap with LoggingMiddleware (creates responseWriter) then AuthMiddleware
-	logging := LoggingMiddleware(logger)(AuthMiddleware(keys, logger)(inner))
+	logging := LoggingMiddleware(logger)(AuthMiddleware(NewKeyring(keys), nil, logger)(inner))
 
 	req := httptest.NewRequest(http.MethodGet, "/test", nil)
 	req.Header.Set("Authorization", "Bearer key1")
@@ -188,6 +199,41 @@ func TestAuthMiddleware_SetsApplicationOnResponseWriter(t *testing.T) {
 	}
 }
 
+func TestAuthMiddleware_LogsApplicationThroughGzip(t *testing.T) {
+	t.Parallel()
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	keys := []models.ProxyKey{{Key: "key1", Application: "myapp"}}
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok": true}`))
+	})
+	handler := LoggingMiddleware(logger)(GzipMiddleware(true)(AuthMiddleware(NewKeyring(keys), nil, logger)(inner)))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Authorization", "Bearer key1")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip response, got Content-Encoding %q", rec.Header().Get("Content-Encoding"))
+	}
+	var entry struct {
+		Msg         string `json:"msg"`
+		Application string `json:"application"`
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if err := json.Unmarshal([]byte(line), &entry); err == nil && entry.Msg == "request completed" {
+			break
+		}
+	}
+	if entry.Msg != "request completed" || entry.Application != "myapp" {
+		t.Errorf("expected access log with application 'myapp', got %+v", entry)
+	}
+}
+
 func TestRecoverMiddleware_PanicRecovery(t *testing.T) {
 	t.Parallel()
 	logger := newTestLogger()
@@ -224,3 +270,383 @@ func TestRequestIDMiddleware_SetsHeader(t *testing.T) {
 		t.Error("expected X-Request-ID response header")
 	}
 }
+
+func TestRequireScope(t *testing.T) {
+	t.Parallel()
+	logger := newTestLogger()
+	keys := []models.ProxyKey{
+		{Key: "inference-key", Application: "app"},
+		{Key: "obs-key", Application: "app", Scope: models.ScopeObservability},
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		allowed  []models.KeyScope
+		wantCode int
+	}{
+		{
+			name:     "inference key on inference endpoint",
+			key:      "inference-key",
+			allowed:  []models.KeyScope{models.ScopeInference},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "observability key on inference endpoint",
+			key:      "obs-key",
+			allowed:  []models.KeyScope{models.ScopeInference},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "observability key on stats endpoint",
+			key:      "obs-key",
+			allowed:  []models.KeyScope{models.ScopeInference, models.ScopeObservability},
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := AuthMiddleware(NewKeyring(keys), nil, logger)(RequireScope(logger, tt.allowed...)(ok))
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected status %d, got %d", tt.wantCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRequireWritable(t *testing.T) {
+	t.Parallel()
+	logger := newTestLogger()
+	frozen := freeze.New(true)
+
+	tests := []struct {
+		name     string
+		readOnly *freeze.Switch
+		method   string
+		wantCode int
+	}{
+		{name: "write allowed", readOnly: freeze.New(false), method: http.MethodPatch, wantCode: http.StatusOK},
+		{name: "no switch", readOnly: nil, method: http.MethodPost, wantCode: http.StatusOK},
+		{name: "read while frozen", readOnly: frozen, method: http.MethodGet, wantCode: http.StatusOK},
+		{name: "patch while frozen", readOnly: frozen, method: http.MethodPatch, wantCode: http.StatusLocked},
+		{name: "post while frozen", readOnly: frozen, method: http.MethodPost, wantCode: http.StatusLocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := RequireWritable(tt.readOnly, logger)(ok)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/admin/models", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected %d, got %d", tt.wantCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware_KeyExpiry(t *testing.T) {
+	t.Parallel()
+	logger := newTestLogger()
+	keys := []models.ProxyKey{
+		{Key: "pk-new", Application: "app"},
+		{Key: "pk-old", Application: "app", ExpiresAt: time.Now().Add(time.Hour)},
+		{Key: "pk-retired", Application: "app", ExpiresAt: time.Now().Add(-time.Hour)},
+	}
+
+	handler := AuthMiddleware(NewKeyring(keys), nil, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		key      string
+		wantCode int
+	}{
+		{"pk-new", http.StatusOK},
+		{"pk-old", http.StatusOK},
+		{"pk-retired", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantCode {
+			t.Errorf("key %s: expected status %d, got %d", tt.key, tt.wantCode, rec.Code)
+		}
+	}
+}
+
+func TestAuthMiddleware_DisabledKey(t *testing.T) {
+	t.Parallel()
+	logger := newTestLogger()
+	keyring := NewKeyring([]models.ProxyKey{
+		{Key: "pk-leaked", Application: "app", Disabled: true},
+		{Key: "pk-live", Application: "app"},
+	})
+	hub := events.NewHub()
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	handler := AuthMiddleware(keyring, hub, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("pk-leaked")
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "disabled") {
+		t.Errorf("expected disabled key error, got %d: %s", rec.Code, rec.Body.String())
+	}
+	leaked := models.ProxyKey{Key: "pk-leaked"}
+	if ev := <-ch; ev.Type != events.DisabledKeyUsed || ev.Data["key_id"] != leaked.ID() || ev.Data["path"] != "/test" {
+		t.Errorf("unexpected audit event: %+v", ev)
+	}
+
+	// Keys can be cut off and restored at runtime
+	live := models.ProxyKey{Key: "pk-live"}
+	if _, ok, _ := keyring.SetDisabled(live.ID(), true); !ok {
+		t.Fatal("expected key to be found by ID")
+	}
+	if rec := serve("pk-live"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected runtime-disabled key to be rejected, got %d", rec.Code)
+	}
+	keyring.SetDisabled(leaked.ID(), false)
+	if rec := serve("pk-leaked"); rec.Code != http.StatusOK {
+		t.Errorf("expected re-enabled key to be accepted, got %d", rec.Code)
+	}
+}
+
+func TestKeyring_SharedBackend(t *testing.T) {
+	t.Parallel()
+	logger := newTestLogger()
+	keys := []models.ProxyKey{{Key: "pk-live", Application: "app"}}
+	live := keys[0]
+
+	// Two replicas sharing a backend
+	backend := storage.NewMemory()
+	a, err := NewSharedKeyring(keys, backend, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSharedKeyring(keys, backend, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := a.SetDisabled(live.ID(), true); !ok || err != nil {
+		t.Fatalf("expected key to be disabled, got %v, %v", ok, err)
+	}
+	if err := b.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if pk, _ := b.Lookup("pk-live"); !pk.Disabled {
+		t.Error("expected the override to reach the other replica")
+	}
+
+	// A restarted replica starts with the stored overrides
+	restarted, err := NewSharedKeyring(keys, backend, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk, _ := restarted.Lookup("pk-live"); !pk.Disabled {
+		t.Error("expected the override to survive a restart")
+	}
+}
+
+func TestAuthMiddleware_OverrideKey(t *testing.T) {
+	t.Parallel()
+	keyring := NewKeyring([]models.ProxyKey{
+		{Key: "pk-app", Application: "app"},
+		{Key: "pk-ops", Application: "OPS", Scope: models.ScopeAdmin},
+		{Key: "pk-old-ops", Application: "OPS", Scope: models.ScopeAdmin, Disabled: true},
+	})
+
+	var operator models.ProxyKey
+	var forwarded string
+	handler := AuthMiddleware(keyring, nil, newTestLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, _ = r.Context().Value(ContextKeyOperator).(models.ProxyKey)
+		forwarded = r.Header.Get(override.KeyHeader)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name         string
+		adminKey     string
+		retry        string
+		wantCode     int
+		wantOperator string
+	}{
+		{name: "no override", wantCode: http.StatusOK},
+		{name: "admin key", adminKey: "pk-ops", retry: "0", wantCode: http.StatusOK, wantOperator: "OPS"},
+		{name: "override without admin key", retry: "0", wantCode: http.StatusForbidden},
+		{name: "inference key", adminKey: "pk-app", retry: "0", wantCode: http.StatusForbidden},
+		{name: "disabled admin key", adminKey: "pk-old-ops", retry: "0", wantCode: http.StatusForbidden},
+		{name: "unknown key", adminKey: "pk-guess", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		operator, forwarded = models.ProxyKey{}, ""
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.Header.Set("Authorization", "Bearer pk-app")
+		if tt.adminKey != "" {
+			req.Header.Set(override.KeyHeader, tt.adminKey)
+		}
+		if tt.retry != "" {
+			req.Header.Set(override.RetryHeader, tt.retry)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantCode || operator.Application != tt.wantOperator {
+			t.Errorf("%s: expected %d with operator %q, got %d with %q", tt.name, tt.wantCode, tt.wantOperator, rec.Code, operator.Application)
+		}
+		if forwarded != "" {
+			t.Errorf("%s: expected the admin key to be removed from the request", tt.name)
+		}
+	}
+}
+
+func TestWarnExpiringKeys(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2026, 3, 25, 0, 0, 0, 0, time.UTC)
+	keys := []models.ProxyKey{
+		{Key: "a", Application: "forever"},
+		{Key: "b", Application: "soon", ExpiresAt: now.Add(48 * time.Hour)},
+		{Key: "c", Application: "later", ExpiresAt: now.Add(30 * 24 * time.Hour)},
+		{Key: "d", Application: "gone", ExpiresAt: now.Add(-time.Hour)},
+	}
+
+	var buf bytes.Buffer
+	WarnExpiringKeys(keys, now, 7*24*time.Hour, slog.New(slog.NewTextHandler(&buf, nil)))
+
+	out := buf.String()
+	if !strings.Contains(out, "application=soon") || !strings.Contains(out, "application=gone") {
+		t.Errorf("expected warnings for soon and gone, got:\n%s", out)
+	}
+	if strings.Contains(out, "application=forever") || strings.Contains(out, "application=later") {
+		t.Errorf("unexpected warnings, got:\n%s", out)
+	}
+}
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(s))
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestGzipMiddleware_Requests(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "plain body", body: []byte(`{"model":"gpt4"}`), wantStatus: http.StatusOK, wantBody: `{"model":"gpt4"}`},
+		{name: "gzip body is decoded", encoding: "gzip", body: gzipBytes(t, `{"model":"gpt4"}`), wantStatus: http.StatusOK, wantBody: `{"model":"gpt4"}`},
+		{name: "invalid gzip", encoding: "gzip", body: []byte("not gzip"), wantStatus: http.StatusBadRequest},
+		{name: "unsupported encoding", encoding: "br", body: []byte("..."), wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var gotBody, gotEncoding string
+			handler := GzipMiddleware(false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				gotBody, gotEncoding = string(data), r.Header.Get("Content-Encoding")
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus == http.StatusOK && (gotBody != tt.wantBody || gotEncoding != "") {
+				t.Errorf("handler saw body %q with Content-Encoding %q", gotBody, gotEncoding)
+			}
+		})
+	}
+}
+
+func TestGzipMiddleware_Responses(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		compress       bool
+		acceptEncoding string
+		contentType    string
+		wantGzip       bool
+	}{
+		{name: "JSON is compressed", compress: true, acceptEncoding: "gzip, deflate", contentType: "application/json", wantGzip: true},
+		{name: "disabled", compress: false, acceptEncoding: "gzip", contentType: "application/json"},
+		{name: "client does not accept gzip", compress: true, acceptEncoding: "deflate", contentType: "application/json"},
+		{name: "client refuses gzip", compress: true, acceptEncoding: "gzip;q=0", contentType: "application/json"},
+		{name: "streams are not compressed", compress: true, acceptEncoding: "gzip", contentType: "text/event-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			const body = `{"choices":[{"message":{"content":"hello"}}]}`
+			handler := GzipMiddleware(tt.compress)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(body))
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("expected gzip %v, got Content-Encoding %q", tt.wantGzip, rec.Header().Get("Content-Encoding"))
+			}
+			got := rec.Body.String()
+			if gzipped {
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, _ := io.ReadAll(gz)
+				got = string(data)
+			}
+			if got != body {
+				t.Errorf("expected body %q, got %q", body, got)
+			}
+		})
+	}
+}
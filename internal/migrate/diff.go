@@ -0,0 +1,118 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// Diff returns a unified diff of a file's original and migrated content, or
+// "" when it is unchanged. A file split out of a combined one is shown as
+// added in full.
+func Diff(file File) string {
+	oldName, newName := "a/"+file.Path, "b/"+file.Path
+	if file.Old == nil {
+		oldName = "/dev/null"
+	}
+	oldLines, newLines := lines(file.Old), lines(file.New)
+	ops := diffLines(oldLines, newLines)
+
+	// Group changed lines, with their context, into hunks
+	var b strings.Builder
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		first := max(start-diffContext, 0)
+		end := start
+		for i := start; i < len(ops) && i-end <= 2*diffContext; i++ {
+			if ops[i].kind != ' ' {
+				end = i
+			}
+		}
+		last := min(end+diffContext, len(ops)-1)
+
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		oldStart, newStart := ops[first].oldLine, ops[first].newLine
+		var oldCount, newCount int
+		for _, op := range ops[first : last+1] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, op := range ops[first : last+1] {
+			fmt.Fprintf(&b, "%c%s\n", op.kind, op.text)
+		}
+		start = last + 1
+	}
+	return b.String()
+}
+
+// diffOp is one line of a diff: ' ' kept, '-' removed or '+' added. oldLine
+// and newLine are the 0-based positions it occupies or would occupy.
+type diffOp struct {
+	kind             byte
+	text             string
+	oldLine, newLine int
+}
+
+// diffLines computes a shortest edit script from the longest common
+// subsequence of the lines. Config files are small, so the quadratic table
+// is fine.
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
+
+// hunkRange formats a hunk header range from a 0-based start line.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func lines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
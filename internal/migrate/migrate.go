@@ -0,0 +1,280 @@
+// Package migrate upgrades configuration directories written for older config
+// layouts to the current one for "portus migrate-config". Deprecated field
+// names are renamed and combined files are split, producing the files of a new
+// directory along with a description of every change.
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// File is one file of the migrated directory.
+type File struct {
+	// Path is relative to the directory, with forward slashes.
+	Path string
+	// Old is the original content, or nil for a file split out of a combined
+	// one.
+	Old []byte
+	// New is the migrated content; equal to Old when nothing changed.
+	New []byte
+	// Mode is the original file's permissions.
+	Mode fs.FileMode
+	// Changes describes each change made, in order.
+	Changes []string
+}
+
+// Changed reports whether the migration altered or created the file.
+func (f File) Changed() bool {
+	return len(f.Changes) > 0
+}
+
+// renamedFields maps deprecated model and target field names to their current
+// names. They cover Portkey's legacy config schema, whose files are often
+// carried over, and the camelCase spellings the Portkey SDKs accept.
+var renamedFields = map[string]string{
+	"options":        "targets",
+	"retry_settings": "retry",
+	"apiKey":         "api_key",
+	"overrideParams": "override_params",
+	"customHost":     "custom_host",
+	"forwardHeaders": "forward_headers",
+	"requestTimeout": "request_timeout",
+	"onStatusCodes":  "on_status_codes",
+}
+
+// combinedSections maps the sections of a combined config.json to the files
+// they are split into. "models" holds one entry per alias.
+var combinedSections = map[string]string{
+	"defaults":   "models/_defaults.json",
+	"pricing":    "pricing.json",
+	"guardrails": "guardrails.json",
+	"budgets":    "budgets.json",
+}
+
+// Dir migrates the configuration directory at root. Files it does not
+// recognize are carried over unchanged. Nothing is written; the caller
+// writes the returned files, sorted by path, to the new directory.
+func Dir(root string) ([]File, error) {
+	files := make(map[string]*File)
+	var combined []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[rel] = &File{Path: rel, Old: data, New: data, Mode: info.Mode().Perm()}
+		if rel == "config.json" || rel == "models.json" {
+			combined = append(combined, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Split files first, so their models are migrated like the others
+	sort.Strings(combined)
+	for _, rel := range combined {
+		if err := split(files, rel); err != nil {
+			return nil, err
+		}
+	}
+
+	for rel, file := range files {
+		if !strings.HasPrefix(rel, "models/") || strings.Count(rel, "/") != 1 || !strings.HasSuffix(rel, ".json") {
+			continue
+		}
+		if err := migrateModelFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]File, 0, len(files))
+	for _, file := range files {
+		result = append(result, *file)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// split replaces a combined file with the files it is split into. models.json
+// maps aliases to model configs; config.json holds a "models" section like it
+// along with the sections in combinedSections.
+func split(files map[string]*File, rel string) error {
+	source := files[rel]
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(source.Old, &sections); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", rel, err)
+	}
+	if rel == "models.json" {
+		sections = map[string]json.RawMessage{"models": source.Old}
+	}
+
+	add := func(path string, raw json.RawMessage) error {
+		if _, ok := files[path]; ok {
+			return fmt.Errorf("%s would be split into %s, which already exists", rel, path)
+		}
+		content, err := indent(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s in %s: %w", path, rel, err)
+		}
+		files[path] = &File{Path: path, New: content, Mode: source.Mode, Changes: []string{"split out of " + rel}}
+		return nil
+	}
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "models" {
+			var aliases map[string]json.RawMessage
+			if err := json.Unmarshal(sections[name], &aliases); err != nil {
+				return fmt.Errorf("failed to parse models in %s: %w", rel, err)
+			}
+			for alias, raw := range aliases {
+				if alias == "" || strings.ContainsAny(alias, `/\`) || strings.HasPrefix(alias, "_") {
+					return fmt.Errorf("%s has an invalid alias name %q", rel, alias)
+				}
+				if err := add("models/"+alias+".json", raw); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		path, ok := combinedSections[name]
+		if !ok {
+			return fmt.Errorf("%s has an unknown section %q", rel, name)
+		}
+		if err := add(path, sections[name]); err != nil {
+			return err
+		}
+	}
+	delete(files, rel)
+	return nil
+}
+
+// migrateModelFile renames the deprecated fields of a model config file.
+func migrateModelFile(file *File) error {
+	var config map[string]interface{}
+	if err := json.Unmarshal(file.New, &config); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", file.Path, err)
+	}
+	changes, err := migrateModel(config, "")
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Path, err)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", file.Path, err)
+	}
+	file.New = append(content, '\n')
+	file.Changes = append(file.Changes, changes...)
+	return nil
+}
+
+// migrateModel renames deprecated fields in a model config or target, then
+// in its targets, retry and strategy. prefix locates the object in change
+// descriptions.
+func migrateModel(config map[string]interface{}, prefix string) ([]string, error) {
+	changes, err := renameFields(config, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// The legacy top-level routing mode moved into strategy
+	if mode, ok := config["mode"]; ok {
+		strategy, ok := config["strategy"].(map[string]interface{})
+		if !ok {
+			strategy = make(map[string]interface{})
+		}
+		if _, set := strategy["mode"]; set {
+			return nil, fmt.Errorf("both %smode and %sstrategy.mode are set", prefix, prefix)
+		}
+		strategy["mode"] = mode
+		config["strategy"] = strategy
+		delete(config, "mode")
+		changes = append(changes, fmt.Sprintf("moved %smode to %sstrategy.mode", prefix, prefix))
+	}
+
+	for _, name := range []string{"retry", "strategy"} {
+		if nested, ok := config[name].(map[string]interface{}); ok {
+			nestedChanges, err := renameFields(nested, prefix+name+".")
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, nestedChanges...)
+		}
+	}
+
+	targets, _ := config["targets"].([]interface{})
+	for i, target := range targets {
+		if target, ok := target.(map[string]interface{}); ok {
+			targetChanges, err := migrateModel(target, fmt.Sprintf("%stargets[%d].", prefix, i))
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, targetChanges...)
+		}
+	}
+	return changes, nil
+}
+
+// renameFields renames the deprecated fields of one object, in name order so
+// the changes are reported deterministically.
+func renameFields(object map[string]interface{}, prefix string) ([]string, error) {
+	var names []string
+	for name := range object {
+		if _, ok := renamedFields[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []string
+	for _, name := range names {
+		current := renamedFields[name]
+		if _, ok := object[current]; ok {
+			return nil, fmt.Errorf("both %s%s and %s%s are set", prefix, name, prefix, current)
+		}
+		object[current] = object[name]
+		delete(object, name)
+		changes = append(changes, fmt.Sprintf("renamed %s%s to %s%s", prefix, name, prefix, current))
+	}
+	return changes, nil
+}
+
+// indent formats a JSON value as the migrated files are written.
+func indent(raw json.RawMessage) ([]byte, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
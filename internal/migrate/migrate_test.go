@@ -0,0 +1,219 @@
+package migrate
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for path, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func filesByPath(files []File) map[string]File {
+	byPath := make(map[string]File, len(files))
+	for _, file := range files {
+		byPath[file.Path] = file
+	}
+	return byPath
+}
+
+func TestDir_RenamesFields(t *testing.T) {
+	t.Parallel()
+
+	dir := writeDir(t, map[string]string{
+		"models/fallback.json": `{
+			"mode": "fallback",
+			"retry_settings": {"attempts": 3, "onStatusCodes": [429]},
+			"options": [
+				{"provider": "openai", "apiKey": "${OPENAI_API_KEY}", "overrideParams": {"model": "gpt-4o"}},
+				{"provider": "anthropic", "api_key": "${ANTHROPIC_API_KEY}"}
+			]
+		}`,
+		"models/current.json": `{"provider": "openai", "api_key": "${OPENAI_API_KEY}"}`,
+		"pricing.json":        `{"gpt-4o": {"input_per_1k": 0.0025}}`,
+	})
+
+	files, err := Dir(dir)
+	if err != nil {
+		t.Fatalf("Dir() error: %v", err)
+	}
+	byPath := filesByPath(files)
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(files))
+	}
+
+	fallback := byPath["models/fallback.json"]
+	wantChanges := []string{
+		"renamed options to targets",
+		"renamed retry_settings to retry",
+		"moved mode to strategy.mode",
+		"renamed retry.onStatusCodes to retry.on_status_codes",
+		"renamed targets[0].apiKey to targets[0].api_key",
+		"renamed targets[0].overrideParams to targets[0].override_params",
+	}
+	if !reflect.DeepEqual(fallback.Changes, wantChanges) {
+		t.Errorf("changes = %q, want %q", fallback.Changes, wantChanges)
+	}
+	var migrated map[string]interface{}
+	if err := json.Unmarshal(fallback.New, &migrated); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"strategy": map[string]interface{}{"mode": "fallback"},
+		"retry":    map[string]interface{}{"attempts": 3.0, "on_status_codes": []interface{}{429.0}},
+		"targets": []interface{}{
+			map[string]interface{}{"provider": "openai", "api_key": "${OPENAI_API_KEY}", "override_params": map[string]interface{}{"model": "gpt-4o"}},
+			map[string]interface{}{"provider": "anthropic", "api_key": "${ANTHROPIC_API_KEY}"},
+		},
+	}
+	if !reflect.DeepEqual(migrated, want) {
+		t.Errorf("migrated config = %v, want %v", migrated, want)
+	}
+
+	// Current files are carried over byte for byte
+	for _, path := range []string{"models/current.json", "pricing.json"} {
+		if file := byPath[path]; file.Changed() || string(file.New) != string(file.Old) {
+			t.Errorf("expected %s to be unchanged", path)
+		}
+	}
+}
+
+func TestDir_SplitsCombinedFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := writeDir(t, map[string]string{
+		"config.json": `{
+			"models": {"claude": {"provider": "anthropic", "apiKey": "${ANTHROPIC_API_KEY}"}},
+			"defaults": {"request_timeout": 60000},
+			"budgets": {"search": {"daily_usd": 10}}
+		}`,
+		"models.json": `{"gpt-4o": {"provider": "openai", "api_key": "${OPENAI_API_KEY}"}}`,
+	})
+
+	files, err := Dir(dir)
+	if err != nil {
+		t.Fatalf("Dir() error: %v", err)
+	}
+	byPath := filesByPath(files)
+	wantPaths := []string{"budgets.json", "models/_defaults.json", "models/claude.json", "models/gpt-4o.json"}
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Fatalf("paths = %v, want %v", paths, wantPaths)
+	}
+
+	claude := byPath["models/claude.json"]
+	wantChanges := []string{"split out of config.json", "renamed apiKey to api_key"}
+	if claude.Old != nil || !reflect.DeepEqual(claude.Changes, wantChanges) {
+		t.Errorf("claude changes = %q, want %q", claude.Changes, wantChanges)
+	}
+	if !strings.Contains(string(claude.New), `"api_key": "${ANTHROPIC_API_KEY}"`) {
+		t.Errorf("expected the split model to be migrated, got %s", claude.New)
+	}
+	if got := string(byPath["budgets.json"].New); !strings.Contains(got, `"daily_usd": 10`) {
+		t.Errorf("expected the budgets section, got %s", got)
+	}
+}
+
+func TestDir_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "old and new field both set",
+			files:   map[string]string{"models/a.json": `{"options": [], "targets": []}`},
+			wantErr: "both options and targets are set",
+		},
+		{
+			name:    "mode and strategy both set",
+			files:   map[string]string{"models/a.json": `{"mode": "fallback", "strategy": {"mode": "loadbalance"}}`},
+			wantErr: "both mode and strategy.mode are set",
+		},
+		{
+			name:    "split conflicts with an existing file",
+			files:   map[string]string{"models.json": `{"a": {"provider": "openai"}}`, "models/a.json": `{"provider": "openai"}`},
+			wantErr: "models/a.json, which already exists",
+		},
+		{
+			name:    "unknown section",
+			files:   map[string]string{"config.json": `{"routes": {}}`},
+			wantErr: `unknown section "routes"`,
+		},
+		{
+			name:    "invalid alias",
+			files:   map[string]string{"models.json": `{"../a": {}}`},
+			wantErr: "invalid alias name",
+		},
+		{
+			name:    "invalid JSON",
+			files:   map[string]string{"models/a.json": `{`},
+			wantErr: "failed to parse models/a.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Dir(writeDir(t, tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Dir() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		file File
+		want string
+	}{
+		{
+			name: "unchanged",
+			file: File{Path: "pricing.json", Old: []byte("{}\n"), New: []byte("{}\n")},
+			want: "",
+		},
+		{
+			name: "changed line",
+			file: File{Path: "models/a.json", Old: []byte("{\n  \"apiKey\": \"k\",\n  \"provider\": \"openai\"\n}\n"), New: []byte("{\n  \"api_key\": \"k\",\n  \"provider\": \"openai\"\n}\n")},
+			want: "--- a/models/a.json\n+++ b/models/a.json\n@@ -1,4 +1,4 @@\n {\n-  \"apiKey\": \"k\",\n+  \"api_key\": \"k\",\n   \"provider\": \"openai\"\n }\n",
+		},
+		{
+			name: "split file",
+			file: File{Path: "models/a.json", New: []byte("{}\n")},
+			want: "--- /dev/null\n+++ b/models/a.json\n@@ -0,0 +1,1 @@\n+{}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := Diff(tt.file); got != tt.want {
+				t.Errorf("Diff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
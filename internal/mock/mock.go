@@ -0,0 +1,371 @@
+// Package mock stands in for the gateway in PORTUS_MOCK_MODE. It answers
+// chat, completions, messages and embeddings requests with canned or echoed
+// responses, including synthetic event streams, so client integration tests
+// can run against Portus without provider API keys.
+package mock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Modes.
+const (
+	// ModeEcho replies with the last user message.
+	ModeEcho = "echo"
+	// ModeCanned replies with a fixed text.
+	ModeCanned = "canned"
+)
+
+// DefaultResponse is the canned reply when none is configured.
+const DefaultResponse = "This is a mock response from Portus."
+
+// embeddingDimensions is the length of mock embedding vectors.
+const embeddingDimensions = 8
+
+// ParseMode validates a PORTUS_MOCK_MODE value; empty disables mock mode.
+func ParseMode(value string) (string, error) {
+	switch value {
+	case "", ModeEcho, ModeCanned:
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown mock mode %q (must be %q or %q)", value, ModeEcho, ModeCanned)
+}
+
+// Gateway is an http.Handler answering gateway requests with mock responses.
+type Gateway struct {
+	mode     string
+	response string
+	nextID   atomic.Uint64
+}
+
+// New creates a mock gateway. In ModeCanned, and in ModeEcho when there is
+// nothing to echo, it replies with response, or DefaultResponse when empty.
+func New(mode, response string) *Gateway {
+	if response == "" {
+		response = DefaultResponse
+	}
+	return &Gateway{mode: mode, response: response}
+}
+
+// request covers the fields mock responses are built from across the OpenAI
+// and Anthropic request formats.
+type request struct {
+	Model         string          `json:"model"`
+	Messages      []message       `json:"messages"`
+	Prompt        json.RawMessage `json:"prompt"`
+	Input         json.RawMessage `json:"input"`
+	Stream        bool            `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+type message struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Path == "/v1/models" {
+		writeJSON(w, map[string]any{"object": "list", "data": []any{}})
+		return
+	}
+
+	var req request
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+		writeError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/chat/completions":
+		g.chat(w, req)
+	case "/v1/completions":
+		g.completion(w, req)
+	case "/v1/messages":
+		g.messages(w, req)
+	case "/v1/embeddings":
+		embeddings(w, req)
+	default:
+		writeError(w, r, http.StatusNotImplemented)
+	}
+}
+
+// reply returns the response text and estimated prompt and completion tokens.
+func (g *Gateway) reply(req request) (string, int, int) {
+	var prompt, lastUser string
+	for _, m := range req.Messages {
+		text := contentText(m.Content)
+		prompt += text + " "
+		if m.Role == "user" {
+			lastUser = text
+		}
+	}
+	if len(req.Messages) == 0 {
+		lastUser = contentText(req.Prompt)
+		prompt = lastUser
+	}
+
+	text := g.response
+	if g.mode == ModeEcho && strings.TrimSpace(lastUser) != "" {
+		text = lastUser
+	}
+	return text, countTokens(prompt), countTokens(text)
+}
+
+func (g *Gateway) chat(w http.ResponseWriter, req request) {
+	text, promptTokens, completionTokens := g.reply(req)
+	id, created := fmt.Sprintf("chatcmpl-mock-%d", g.nextID.Add(1)), time.Now().Unix()
+	usage := map[string]int{"prompt_tokens": promptTokens, "completion_tokens": completionTokens, "total_tokens": promptTokens + completionTokens}
+
+	if !req.Stream {
+		writeJSON(w, map[string]any{
+			"id": id, "object": "chat.completion", "created": created, "model": req.Model,
+			"choices": []any{map[string]any{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": text},
+				"finish_reason": "stop",
+			}},
+			"usage": usage,
+		})
+		return
+	}
+
+	chunk := func(delta map[string]string, finishReason any) map[string]any {
+		return map[string]any{
+			"id": id, "object": "chat.completion.chunk", "created": created, "model": req.Model,
+			"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finishReason}},
+		}
+	}
+	events := []any{chunk(map[string]string{"role": "assistant", "content": ""}, nil)}
+	for _, piece := range split(text) {
+		events = append(events, chunk(map[string]string{"content": piece}, nil))
+	}
+	events = append(events, chunk(map[string]string{}, "stop"))
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		events = append(events, map[string]any{
+			"id": id, "object": "chat.completion.chunk", "created": created, "model": req.Model,
+			"choices": []any{}, "usage": usage,
+		})
+	}
+	writeStream(w, events, true)
+}
+
+func (g *Gateway) completion(w http.ResponseWriter, req request) {
+	text, promptTokens, completionTokens := g.reply(req)
+	id, created := fmt.Sprintf("cmpl-mock-%d", g.nextID.Add(1)), time.Now().Unix()
+	usage := map[string]int{"prompt_tokens": promptTokens, "completion_tokens": completionTokens, "total_tokens": promptTokens + completionTokens}
+
+	response := func(text string, finishReason any) map[string]any {
+		return map[string]any{
+			"id": id, "object": "text_completion", "created": created, "model": req.Model,
+			"choices": []any{map[string]any{"index": 0, "text": text, "finish_reason": finishReason}},
+		}
+	}
+	if !req.Stream {
+		body := response(text, "stop")
+		body["usage"] = usage
+		writeJSON(w, body)
+		return
+	}
+
+	var events []any
+	for _, piece := range split(text) {
+		events = append(events, response(piece, nil))
+	}
+	last := response("", "stop")
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		last["usage"] = usage
+	}
+	writeStream(w, append(events, last), true)
+}
+
+func (g *Gateway) messages(w http.ResponseWriter, req request) {
+	text, promptTokens, completionTokens := g.reply(req)
+	id := fmt.Sprintf("msg_mock_%d", g.nextID.Add(1))
+
+	if !req.Stream {
+		writeJSON(w, map[string]any{
+			"id": id, "type": "message", "role": "assistant", "model": req.Model,
+			"content":     []any{map[string]string{"type": "text", "text": text}},
+			"stop_reason": "end_turn", "stop_sequence": nil,
+			"usage": map[string]int{"input_tokens": promptTokens, "output_tokens": completionTokens},
+		})
+		return
+	}
+
+	events := []any{
+		map[string]any{"type": "message_start", "message": map[string]any{
+			"id": id, "type": "message", "role": "assistant", "model": req.Model,
+			"content": []any{}, "stop_reason": nil, "stop_sequence": nil,
+			"usage": map[string]int{"input_tokens": promptTokens, "output_tokens": 0},
+		}},
+		map[string]any{"type": "content_block_start", "index": 0, "content_block": map[string]string{"type": "text", "text": ""}},
+	}
+	for _, piece := range split(text) {
+		events = append(events, map[string]any{"type": "content_block_delta", "index": 0, "delta": map[string]string{"type": "text_delta", "text": piece}})
+	}
+	events = append(events,
+		map[string]any{"type": "content_block_stop", "index": 0},
+		map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": "end_turn", "stop_sequence": nil}, "usage": map[string]int{"output_tokens": completionTokens}},
+		map[string]any{"type": "message_stop"},
+	)
+	writeStream(w, events, false)
+}
+
+// embeddings returns a deterministic vector per input, so identical inputs
+// embed identically.
+func embeddings(w http.ResponseWriter, req request) {
+	var inputs []string
+	if json.Unmarshal(req.Input, &inputs) != nil {
+		inputs = []string{contentText(req.Input)}
+	}
+
+	data := make([]any, len(inputs))
+	tokens := 0
+	for i, input := range inputs {
+		sum := sha256.Sum256([]byte(input))
+		vector := make([]float64, embeddingDimensions)
+		for j := range vector {
+			vector[j] = float64(sum[j])/127.5 - 1
+		}
+		data[i] = map[string]any{"object": "embedding", "index": i, "embedding": vector}
+		tokens += countTokens(input)
+	}
+	writeJSON(w, map[string]any{
+		"object": "list", "data": data, "model": req.Model,
+		"usage": map[string]int{"prompt_tokens": tokens, "total_tokens": tokens},
+	})
+}
+
+// contentText flattens a string or an array of text content parts.
+func contentText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(raw, &parts)
+	var texts []string
+	for _, part := range parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// split breaks text into word-sized stream deltas that join back to text.
+func split(text string) []string {
+	var pieces []string
+	for _, piece := range strings.SplitAfter(text, " ") {
+		if piece != "" {
+			pieces = append(pieces, piece)
+		}
+	}
+	return pieces
+}
+
+// countTokens roughly estimates tokens as words.
+func countTokens(text string) int {
+	return max(len(strings.Fields(text)), 1)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeStream writes events as server-sent events. Anthropic streams name
+// each event after its type; OpenAI streams end with [DONE].
+func writeStream(w http.ResponseWriter, events []any, openAI bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	var buf bytes.Buffer
+	for _, ev := range events {
+		data, _ := json.Marshal(ev)
+		if !openAI {
+			fmt.Fprintf(&buf, "event: %s\n", ev.(map[string]any)["type"])
+		}
+		fmt.Fprintf(&buf, "data: %s\n\n", data)
+	}
+	if openAI {
+		buf.WriteString("data: [DONE]\n\n")
+	}
+	w.Write(buf.Bytes())
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{
+		"message": fmt.Sprintf("mock mode does not support %s %s", r.Method, r.URL.Path),
+		"type":    "mock_error",
+	}})
+}
+
+// Transport returns a RoundTripper that serves every request with h in
+// process, never touching the network.
+func Transport(h http.Handler) http.RoundTripper {
+	return roundTripper{h}
+}
+
+type roundTripper struct {
+	h http.Handler
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	rec := &recorder{header: make(http.Header)}
+	rt.h.ServeHTTP(rec, req)
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.code, http.StatusText(rec.code)),
+		StatusCode:    rec.code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header,
+		Body:          io.NopCloser(&rec.body),
+		ContentLength: int64(rec.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// recorder buffers a handler's response for roundTripper.
+type recorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
@@ -0,0 +1,167 @@
+package mock
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func post(t *testing.T, g *Gateway, path, body string) (*http.Response, string) {
+	t.Helper()
+	client := &http.Client{Transport: Transport(g)}
+	resp, err := client.Post("http://gateway"+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func TestGateway_Chat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		mode string
+		want string
+	}{
+		{name: "echo", mode: ModeEcho, want: "hello there"},
+		{name: "canned", mode: ModeCanned, want: "fixed reply"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, body := post(t, New(tt.mode, "fixed reply"), "/v1/chat/completions",
+				`{"model":"gpt4","messages":[{"role":"system","content":"be brief"},{"role":"user","content":[{"type":"text","text":"hello there"}]}]}`)
+			var completion struct {
+				Choices []struct {
+					Message struct {
+						Content string `json:"content"`
+					} `json:"message"`
+				} `json:"choices"`
+				Usage struct {
+					PromptTokens     int `json:"prompt_tokens"`
+					CompletionTokens int `json:"completion_tokens"`
+				} `json:"usage"`
+			}
+			if err := json.Unmarshal([]byte(body), &completion); err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected response %d: %s", resp.StatusCode, body)
+			}
+			if len(completion.Choices) != 1 || completion.Choices[0].Message.Content != tt.want {
+				t.Errorf("expected reply %q, got %s", tt.want, body)
+			}
+			if completion.Usage.PromptTokens != 4 || completion.Usage.CompletionTokens != 2 {
+				t.Errorf("unexpected usage: %+v", completion.Usage)
+			}
+		})
+	}
+}
+
+func TestGateway_ChatStream(t *testing.T) {
+	t.Parallel()
+
+	resp, body := post(t, New(ModeEcho, ""), "/v1/chat/completions",
+		`{"model":"gpt4","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"one two three"}]}`)
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	var text strings.Builder
+	var usage, done bool
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *struct {
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		for _, choice := range chunk.Choices {
+			text.WriteString(choice.Delta.Content)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage.CompletionTokens == 3
+		}
+	}
+	if text.String() != "one two three" || !usage || !done {
+		t.Errorf("unexpected stream (text %q, usage %v, done %v):\n%s", text.String(), usage, done, body)
+	}
+}
+
+func TestGateway_Messages(t *testing.T) {
+	t.Parallel()
+
+	_, body := post(t, New(ModeEcho, ""), "/v1/messages",
+		`{"model":"claude","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+	if !strings.Contains(body, `"type":"message"`) || !strings.Contains(body, `"text":"hi"`) || !strings.Contains(body, `"input_tokens":1`) {
+		t.Errorf("unexpected message: %s", body)
+	}
+
+	_, body = post(t, New(ModeEcho, ""), "/v1/messages",
+		`{"model":"claude","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	for _, event := range []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"} {
+		if !strings.Contains(body, "event: "+event+"\n") {
+			t.Errorf("expected %s event in stream:\n%s", event, body)
+		}
+	}
+}
+
+func TestGateway_Embeddings(t *testing.T) {
+	t.Parallel()
+
+	g := New(ModeCanned, "")
+	_, first := post(t, g, "/v1/embeddings", `{"model":"embed","input":["a","b"]}`)
+	_, second := post(t, g, "/v1/embeddings", `{"model":"embed","input":["a","b"]}`)
+	var resp struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(first), &resp); err != nil || len(resp.Data) != 2 || len(resp.Data[0].Embedding) != embeddingDimensions {
+		t.Fatalf("unexpected embeddings: %s", first)
+	}
+	if first != second {
+		t.Error("expected identical inputs to embed identically")
+	}
+}
+
+func TestGateway_Unsupported(t *testing.T) {
+	t.Parallel()
+
+	resp, body := post(t, New(ModeEcho, ""), "/v1/images/generations", `{"model":"dalle","prompt":"cat"}`)
+	if resp.StatusCode != http.StatusNotImplemented || !strings.Contains(body, "mock mode does not support") {
+		t.Errorf("expected 501, got %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{"", ModeEcho, ModeCanned} {
+		if _, err := ParseMode(value); err != nil {
+			t.Errorf("ParseMode(%q): unexpected error %v", value, err)
+		}
+	}
+	if _, err := ParseMode("record"); err == nil {
+		t.Error("expected unknown mode to be rejected")
+	}
+}
@@ -0,0 +1,89 @@
+// Package modellist caches model lists fetched live from providers, so
+// /v1/models can show what a pass-through alias can actually reach without
+// calling the provider on every request.
+package modellist
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Model is one model reported by a provider.
+type Model struct {
+	ID      string
+	OwnedBy string
+}
+
+// Fetcher lists the models available through alias.
+type Fetcher func(ctx context.Context, alias string) ([]Model, error)
+
+type entry struct {
+	models    []Model
+	fetchedAt time.Time
+}
+
+// Cache remembers each alias's model list for a TTL. It is safe for
+// concurrent use; a nil Cache lists nothing.
+type Cache struct {
+	fetch  Fetcher
+	ttl    time.Duration
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// New creates a cache that refetches an alias's list once it is older than ttl.
+func New(fetch Fetcher, ttl time.Duration, logger *slog.Logger) *Cache {
+	return &Cache{fetch: fetch, ttl: ttl, logger: logger, now: time.Now, entries: make(map[string]entry)}
+}
+
+// List returns the models for each alias, fetching expired lists
+// concurrently. When a fetch fails the last good list is served; an alias
+// that has never been fetched successfully contributes nothing.
+func (c *Cache) List(ctx context.Context, aliases []string) map[string][]Model {
+	result := make(map[string][]Model, len(aliases))
+	if c == nil {
+		return result
+	}
+
+	var stale []string
+	c.mu.Lock()
+	now := c.now()
+	for _, alias := range aliases {
+		e, ok := c.entries[alias]
+		if ok {
+			result[alias] = e.models
+		}
+		if !ok || now.Sub(e.fetchedAt) >= c.ttl {
+			stale = append(stale, alias)
+		}
+	}
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, alias := range stale {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			models, err := c.fetch(ctx, alias)
+			if err != nil {
+				c.logger.Warn("failed to fetch provider model list", "model_alias", alias, "error", err)
+				return
+			}
+			c.mu.Lock()
+			c.entries[alias] = entry{models: models, fetchedAt: c.now()}
+			c.mu.Unlock()
+			mu.Lock()
+			result[alias] = models
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return result
+}
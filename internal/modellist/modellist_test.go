@@ -0,0 +1,62 @@
+package modellist
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache_ListCachesUntilTTL(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	var failing atomic.Bool
+	fetch := func(ctx context.Context, alias string) ([]Model, error) {
+		calls.Add(1)
+		if failing.Load() {
+			return nil, errors.New("gateway unavailable")
+		}
+		return []Model{{ID: alias + "-mini", OwnedBy: "openai"}}, nil
+	}
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := New(fetch, time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c.now = func() time.Time { return now }
+
+	got := c.List(context.Background(), []string{"gpt", "o"})
+	if len(got["gpt"]) != 1 || got["gpt"][0].ID != "gpt-mini" || len(got["o"]) != 1 {
+		t.Fatalf("unexpected lists: %v", got)
+	}
+	c.List(context.Background(), []string{"gpt", "o"})
+	if calls.Load() != 2 {
+		t.Errorf("expected cached lists within the TTL, got %d fetches", calls.Load())
+	}
+
+	// Expired lists are refetched; a failed refetch keeps serving the last good list
+	now = now.Add(2 * time.Minute)
+	failing.Store(true)
+	got = c.List(context.Background(), []string{"gpt"})
+	if calls.Load() != 3 {
+		t.Errorf("expected a refetch after the TTL, got %d fetches", calls.Load())
+	}
+	if len(got["gpt"]) != 1 {
+		t.Errorf("expected stale list on fetch failure, got %v", got["gpt"])
+	}
+
+	if got := c.List(context.Background(), []string{"new"}); len(got["new"]) != 0 {
+		t.Errorf("expected nothing for an alias that never fetched, got %v", got["new"])
+	}
+}
+
+func TestCache_Nil(t *testing.T) {
+	t.Parallel()
+
+	var c *Cache
+	if got := c.List(context.Background(), []string{"gpt"}); len(got) != 0 {
+		t.Errorf("expected empty result from nil cache, got %v", got)
+	}
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// regexPrefix marks a match pattern as a regular expression rather than a glob.
+const regexPrefix = "re:"
+
+// CompileMatch compiles one of an alias's match patterns. Patterns are globs
+// such as "gpt-4*" unless prefixed with "re:", in which case the rest is a
+// regular expression that must match the whole model name.
+func CompileMatch(pattern string) (func(string) bool, error) {
+	if expr, ok := strings.CutPrefix(pattern, regexPrefix); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(name string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}, nil
+}
+
+// aliasPattern is a compiled match pattern and the alias it resolves to.
+type aliasPattern struct {
+	alias string
+	match func(string) bool
+}
+
+// compilePatterns builds the pattern table in alias order, skipping patterns
+// that do not compile; those are rejected by validation.
+func compilePatterns(configs map[string]ModelConfig) []aliasPattern {
+	aliases := make([]string, 0, len(configs))
+	for alias, model := range configs {
+		if len(model.Match) > 0 {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+
+	patterns := []aliasPattern{}
+	for _, alias := range aliases {
+		for _, pattern := range configs[alias].Match {
+			if match, err := CompileMatch(pattern); err == nil {
+				patterns = append(patterns, aliasPattern{alias: alias, match: match})
+			}
+		}
+	}
+	return patterns
+}
+
+// GatewayRoute sends requests for aliases matching Pattern to the gateway at
+// URL instead of the default one.
+type GatewayRoute struct {
+	Pattern string
+	URL     string
+	match   func(string) bool
+}
+
+// NewGatewayRoute compiles a gateway route. Pattern uses the syntax of an
+// alias's match patterns.
+func NewGatewayRoute(pattern, url string) (GatewayRoute, error) {
+	match, err := CompileMatch(pattern)
+	if err != nil {
+		return GatewayRoute{}, err
+	}
+	return GatewayRoute{Pattern: pattern, URL: url, match: match}, nil
+}
+
+// GatewayURLFor returns the gateway base URL serving alias: the URL of the
+// first gateway route matching it, or GatewayURL.
+func (s *ConfigStore) GatewayURLFor(alias string) string {
+	for _, route := range s.GatewayRoutes {
+		if route.match != nil && route.match(alias) {
+			return route.URL
+		}
+	}
+	return s.GatewayURL
+}
+
+// GatewayURLs returns the default gateway URL followed by each distinct
+// gateway route URL.
+func (s *ConfigStore) GatewayURLs() []string {
+	urls := []string{s.GatewayURL}
+	for _, route := range s.GatewayRoutes {
+		if !slices.Contains(urls, route.URL) {
+			urls = append(urls, route.URL)
+		}
+	}
+	return urls
+}
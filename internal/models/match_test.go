@@ -0,0 +1,92 @@
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConfigStore_ModelMatch(t *testing.T) {
+	t.Parallel()
+
+	store := &ConfigStore{Models: map[string]ModelConfig{
+		"gpt-4o":      {Provider: "azure-openai"},
+		"gpt4-family": {Provider: "openai", Match: []string{"gpt-4*"}},
+		"llama":       {Provider: "groq", Match: []string{`re:(meta-)?llama-3\.\d+-\w+`}},
+		"z-catchall":  {Provider: "anthropic", Match: []string{"*"}},
+	}}
+
+	tests := []struct {
+		name         string
+		want         string
+		wantNoConfig bool
+	}{
+		{name: "gpt-4o", want: "azure-openai"}, // exact alias wins
+		{name: "gpt-4o-mini", want: "openai"},
+		{name: "llama-3.1-70b", want: "groq"},
+		{name: "meta-llama-3.3-8b", want: "groq"},
+		{name: "llama-3.1", want: "anthropic"}, // regexes match the whole name
+		{name: "nested/name", wantNoConfig: true},
+	}
+	for _, tt := range tests {
+		model, ok := store.Model(tt.name)
+		if ok == tt.wantNoConfig || model.Provider != tt.want {
+			t.Errorf("Model(%q) = %q, %v; want %q", tt.name, model.Provider, ok, tt.want)
+		}
+	}
+
+	// Changing the alias set rebuilds the match table
+	store.ReplaceModels(map[string]ModelConfig{"claude": {Provider: "anthropic", Match: []string{"claude-*"}}})
+	if _, ok := store.Model("gpt-4o-mini"); ok {
+		t.Error("expected removed pattern to stop matching")
+	}
+	if model, ok := store.Model("claude-3-haiku"); !ok || model.Provider != "anthropic" {
+		t.Errorf("expected new pattern to match, got %+v, %v", model, ok)
+	}
+}
+
+func TestCompileMatch(t *testing.T) {
+	t.Parallel()
+
+	for _, pattern := range []string{"gpt-[4", "re:gpt-(4"} {
+		if _, err := CompileMatch(pattern); err == nil {
+			t.Errorf("CompileMatch(%q) should fail", pattern)
+		}
+	}
+}
+
+func TestConfigStore_GatewayURLFor(t *testing.T) {
+	t.Parallel()
+
+	eu, err := NewGatewayRoute("eu-*", "https://gateway.eu:8787")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apac, err := NewGatewayRoute("re:(ap|au)-.*", "https://gateway.apac:8787")
+	if err != nil {
+		t.Fatal(err)
+	}
+	euAgain, err := NewGatewayRoute("europe-*", "https://gateway.eu:8787")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &ConfigStore{GatewayURL: "http://localhost:8787", GatewayRoutes: []GatewayRoute{eu, apac, euAgain}}
+
+	tests := map[string]string{
+		"eu-claude":     "https://gateway.eu:8787",
+		"europe-gpt":    "https://gateway.eu:8787",
+		"au-gpt":        "https://gateway.apac:8787",
+		"claude":        "http://localhost:8787",
+		"us-eu-claude":  "http://localhost:8787",
+		"apac-internal": "http://localhost:8787",
+	}
+	for alias, want := range tests {
+		if got := store.GatewayURLFor(alias); got != want {
+			t.Errorf("GatewayURLFor(%q) = %q, want %q", alias, got, want)
+		}
+	}
+
+	want := []string{"http://localhost:8787", "https://gateway.eu:8787", "https://gateway.apac:8787"}
+	if got := store.GatewayURLs(); !slices.Equal(got, want) {
+		t.Errorf("GatewayURLs() = %v, want %v", got, want)
+	}
+}
@@ -2,8 +2,21 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/amscotti/portus/internal/privacy"
+	"github.com/amscotti/portus/internal/progress"
+	"github.com/amscotti/portus/internal/usage"
 )
 
 // Version is the current Portus version.
@@ -17,12 +30,92 @@ type ModelConfig struct {
 	Targets         []TargetConfig         `json:"targets,omitempty"`
 	OverrideParams  map[string]interface{} `json:"override_params,omitempty"`
 	Retry           *RetryConfig           `json:"retry,omitempty"`
+	// CustomHost sends requests to a self-hosted or private endpoint speaking
+	// the provider's API, e.g. a vLLM server for provider "openai".
+	CustomHost string `json:"custom_host,omitempty"`
+	// BaseURL is the OpenAI-compatible API root of a ProviderLocal inference
+	// server such as Ollama, e.g. http://localhost:11434/v1.
+	BaseURL string `json:"base_url,omitempty"`
+	// ForwardHeaders names client request headers the gateway passes on to
+	// the provider.
+	ForwardHeaders []string `json:"forward_headers,omitempty"`
+	// CustomHeaders are static headers the gateway passes on to the provider,
+	// e.g. a tenant header required by a private OpenAI-compatible endpoint.
+	CustomHeaders map[string]string `json:"custom_headers,omitempty"`
+	// StrictOpenAICompliance set to false lets the gateway return provider
+	// specific response fields; nil keeps the gateway's default.
+	StrictOpenAICompliance *bool `json:"strict_open_ai_compliance,omitempty"`
 	// RequestTimeout is the request timeout in milliseconds.
 	RequestTimeout int `json:"request_timeout,omitempty"`
+	// ProxyRetries overrides how many times Portus itself retries a failed
+	// gateway attempt for this alias.
+	ProxyRetries *int `json:"proxy_retries,omitempty"`
+	// MaxConcurrent caps in-flight requests for this alias; zero is unlimited.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// MaxQueue is how many requests may wait for a slot before 429s are returned.
+	MaxQueue int `json:"max_queue,omitempty"`
+	// MaxConcurrentBurst is extra headroom above MaxConcurrent; requests using
+	// it are admitted but logged.
+	MaxConcurrentBurst int `json:"max_concurrent_burst,omitempty"`
+	// QueueTimeout is how long a queued request waits, in milliseconds.
+	QueueTimeout int `json:"queue_timeout,omitempty"`
+	// MaxRequestTimeout caps configured and client-requested timeouts, in milliseconds.
+	MaxRequestTimeout int `json:"max_request_timeout,omitempty"`
 	Thinking        *ThinkingConfig        `json:"thinking,omitempty"`
 	BetaHeaders     []string               `json:"beta_headers,omitempty"`
 	ReasoningEffort string                 `json:"reasoning_effort,omitempty"`
 	ThinkingLevel   string                 `json:"thinking_level,omitempty"`
+	Pricing         *PricingConfig         `json:"pricing,omitempty"`
+	// InjectStreamUsage adds stream_options.include_usage to streaming chat and
+	// completions requests; nil means enabled.
+	InjectStreamUsage *bool `json:"inject_stream_usage,omitempty"`
+	// MaxOutputTokens is the largest max_tokens the alias's model accepts.
+	// Anthropic requests that set no max_tokens get it, and it replaces the
+	// built-in output limit in config checks.
+	MaxOutputTokens int `json:"max_output_tokens,omitempty"`
+	// SemanticCache reuses cached answers for similar non-streaming chat prompts.
+	SemanticCache bool `json:"semantic_cache,omitempty"`
+	// APIFormat is the only API the alias's upstream speaks, "openai" or
+	// "anthropic"; chat and messages requests in the other format are
+	// translated. Empty forwards requests unchanged.
+	APIFormat string `json:"api_format,omitempty"`
+	// ListModels merges the provider's live model list into /v1/models.
+	ListModels bool `json:"list_models,omitempty"`
+	// Match routes requested model names matching any of these glob or "re:"
+	// regex patterns to this alias, when no alias has the exact name.
+	Match []string `json:"match,omitempty"`
+	// Canary sends a share of this alias's traffic to another alias.
+	Canary *CanaryConfig `json:"canary,omitempty"`
+	// Experiment splits this alias's traffic between two variant aliases.
+	Experiment *ExperimentConfig `json:"experiment,omitempty"`
+	// UpstreamHeaders are static headers added to the alias's gateway
+	// requests, e.g. credentials for a gateway behind an authenticating proxy.
+	UpstreamHeaders map[string]string `json:"upstream_headers,omitempty"`
+	// UpstreamTLS presents a client certificate, or trusts a CA bundle, on the
+	// alias's gateway connections instead of the server-wide gateway TLS.
+	UpstreamTLS *UpstreamTLSConfig `json:"upstream_tls,omitempty"`
+	// BYOK lets clients send their own provider API key in the
+	// ProviderKeyHeader, used in place of the configured key: BYOKOptional
+	// falls back to the configured key, BYOKRequired rejects requests
+	// without one.
+	BYOK string `json:"byok,omitempty"`
+	// HedgeAfter starts the next target of a fallback alias when a streamed
+	// request's current target has sent nothing within this many milliseconds.
+	HedgeAfter int `json:"hedge_after,omitempty"`
+	// FallbackMessage is returned as a completion when every route has failed.
+	FallbackMessage string `json:"fallback_message,omitempty"`
+	// Guardrails are policy rules a request must pass before it is proxied.
+	Guardrails *GuardrailConfig `json:"guardrails,omitempty"`
+	// PostProcess cleans up the generated text of non-streaming responses.
+	PostProcess *PostProcessConfig `json:"post_process,omitempty"`
+	// Tests are requests "portus verify" sends through the alias.
+	Tests []AliasTest `json:"tests,omitempty"`
+	// Tags are labels such as team or cost center attached to the alias's
+	// logs, metrics, usage records and Portkey metadata.
+	Tags map[string]string `json:"tags,omitempty"`
+	// BannedModels are globs of provider model names the alias must never
+	// reach, in addition to PORTUS_BANNED_MODELS.
+	BannedModels []string `json:"banned_models,omitempty"`
 
 	// AWS Bedrock specific
 	AWSAccessKeyID     string `json:"aws_access_key_id,omitempty"`
@@ -36,6 +129,50 @@ type ModelConfig struct {
 	VertexServiceAccountJSON string `json:"vertex_service_account_json,omitempty"`
 }
 
+// AliasTest is a request sent through an alias by "portus verify", with
+// the checks its response must pass.
+type AliasTest struct {
+	Name   string `json:"name,omitempty"`
+	Prompt string `json:"prompt"`
+	// Expect is a substring the response text must contain.
+	Expect string `json:"expect,omitempty"`
+	// Schema is a JSON Schema the response text must satisfy as JSON.
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// MaxLatency is the longest the response may take, in milliseconds.
+	MaxLatency int `json:"max_latency,omitempty"`
+}
+
+// GuardrailConfig lists policy rules checked against request message content.
+type GuardrailConfig struct {
+	// BannedPhrases are matched case-insensitively anywhere in the content.
+	BannedPhrases []string `json:"banned_phrases,omitempty"`
+	// Patterns are regular expressions that must not match the content.
+	Patterns []string `json:"patterns,omitempty"`
+	// MaxMessages caps the messages (or Responses input items) in a request;
+	// zero is unlimited.
+	MaxMessages int `json:"max_messages,omitempty"`
+}
+
+// PostProcessConfig lists cleanups applied to the generated text of an
+// alias's non-streaming responses, in field order.
+type PostProcessConfig struct {
+	// StopSequences are removed when the text ends with one, ignoring
+	// trailing whitespace.
+	StopSequences []string `json:"stop_sequences,omitempty"`
+	// StripTags unwraps an XML element of one of these names enclosing the
+	// whole text, e.g. "answer" for <answer>...</answer>.
+	StripTags []string `json:"strip_tags,omitempty"`
+	// Whitespace is "trim" or "collapse"; empty leaves whitespace alone.
+	Whitespace string `json:"whitespace,omitempty"`
+}
+
+// BudgetConfig caps an application's estimated spend per UTC day and month.
+// Zero leaves a window unlimited.
+type BudgetConfig struct {
+	DailyUSD   float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD float64 `json:"monthly_usd,omitempty"`
+}
+
 // StrategyConfig defines the routing strategy (single, fallback, loadbalance).
 type StrategyConfig struct {
 	Mode          string `json:"mode"`
@@ -48,6 +185,10 @@ type TargetConfig struct {
 	APIKey         string                 `json:"api_key,omitempty"`
 	OverrideParams map[string]interface{} `json:"override_params,omitempty"`
 	Weight         int                    `json:"weight,omitempty"`
+	CustomHost     string                 `json:"custom_host,omitempty"`
+	BaseURL        string                 `json:"base_url,omitempty"`
+	ForwardHeaders []string               `json:"forward_headers,omitempty"`
+	CustomHeaders  map[string]string      `json:"custom_headers,omitempty"`
 
 	// AWS Bedrock specific
 	AWSAccessKeyID     string `json:"aws_access_key_id,omitempty"`
@@ -56,6 +197,35 @@ type TargetConfig struct {
 	AWSSessionToken    string `json:"aws_session_token,omitempty"`
 }
 
+// CanaryConfig routes a percentage of an alias's requests to another alias,
+// so an upgrade can be evaluated on live traffic before it is flipped.
+type CanaryConfig struct {
+	// Alias receives the canary traffic.
+	Alias string `json:"alias"`
+	// Percent of requests, from 0 to 100, routed to Alias.
+	Percent float64 `json:"percent"`
+}
+
+// ExperimentConfig splits an alias's traffic evenly between two variant
+// aliases for an A/B comparison. Each caller is assigned a variant by a stable
+// hash of the application and its sticky key, so it always sees the same one.
+type ExperimentConfig struct {
+	// Name labels the experiment in logs and headers and salts the assignment.
+	Name string `json:"name"`
+	// A and B are the variant aliases; either may be the experiment's own alias.
+	A string `json:"a"`
+	B string `json:"b"`
+}
+
+// UpstreamTLSConfig names PEM files for an alias's gateway connections. The
+// certificate and key are required together; an empty CA file falls back to
+// PORTUS_GATEWAY_TLS_CA, then the system roots.
+type UpstreamTLSConfig struct {
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	CAFile   string `json:"ca_file,omitempty"`
+}
+
 // RetryConfig defines retry behavior.
 type RetryConfig struct {
 	Attempts      int   `json:"attempts"`
@@ -68,14 +238,171 @@ type ThinkingConfig struct {
 	BudgetTokens int    `json:"budget_tokens"`
 }
 
+// PricingConfig defines token prices used to estimate request cost.
+type PricingConfig struct {
+	// InputPer1K is the price per 1,000 prompt tokens.
+	InputPer1K float64 `json:"input_per_1k"`
+	// OutputPer1K is the price per 1,000 completion tokens.
+	OutputPer1K float64 `json:"output_per_1k"`
+}
+
+// ProviderKeyHeader carries a client's own provider API key to aliases with
+// byok set. It is never forwarded to the gateway as a header or logged.
+const ProviderKeyHeader = "X-Portus-Provider-Key"
+
+// ProviderLocal is the provider of aliases served by a local or self-hosted
+// OpenAI-compatible inference server (Ollama, vLLM, LM Studio) at their
+// base_url. No API key is required.
+const ProviderLocal = "local"
+
+// GatewayProvider returns the gateway provider serving provider: local
+// inference servers are reached as OpenAI with a custom host.
+func GatewayProvider(provider string) string {
+	if provider == ProviderLocal {
+		return "openai"
+	}
+	return provider
+}
+
+// BYOK modes of an alias.
+const (
+	BYOKOptional = "optional"
+	BYOKRequired = "required"
+)
+
+// KeyScope identifies what a proxy key is permitted to do.
+type KeyScope string
+
+const (
+	// ScopeInference keys can call model endpoints and read their own stats.
+	ScopeInference KeyScope = "inference"
+	// ScopeObservability keys can only read stats for their own application.
+	ScopeObservability KeyScope = "observability"
+	// ScopeAdmin keys can use the admin API but cannot call models.
+	ScopeAdmin KeyScope = "admin"
+)
+
 // ProxyKey represents an authorized proxy key with its associated application name.
 type ProxyKey struct {
 	Key         string
 	Application string
+	// Scope defaults to ScopeInference when empty.
+	Scope KeyScope
+	// MaxStreams caps simultaneous streaming responses; zero means unlimited.
+	MaxStreams int
+	// MaxTokens caps the completion tokens a request may ask for; zero means
+	// unlimited.
+	MaxTokens int
+	// ConversationTokens caps the cumulative tokens of each conversation the
+	// key tags with a conversation ID; zero means unlimited.
+	ConversationTokens int
+	// RequestQuota caps the requests the key's application may make per
+	// window, across all its keys; a zero limit means unlimited.
+	RequestQuota RequestQuota
+	// APIVersion is the API version the key's requests are served under
+	// when they do not ask for one; empty uses the server default.
+	APIVersion string
+	// ExpiresAt is when the key stops being accepted; zero means never.
+	ExpiresAt time.Time
+	// Disabled keys are kept but rejected, e.g. after a leak.
+	Disabled bool
+	// Tags label the key's requests like an alias's tags, overriding them
+	// where both set a name.
+	Tags map[string]string
+}
+
+// WebhookTarget is a set of webhook destinations and how they are served.
+type WebhookTarget struct {
+	URLs []string
+	// Secret keys the HMAC signature of each payload; empty sends unsigned.
+	Secret string
+	// RateLimit caps deliveries per minute to each URL; zero is unlimited.
+	RateLimit int
+	// Events are path.Match patterns of event types to send, e.g.
+	// "budget.*"; empty sends every event.
+	Events []string
+}
+
+// RequestQuota is a request count allowed per calendar window.
+type RequestQuota struct {
+	Limit int
+	// Window is "hour", "day" or "month".
+	Window string
+}
+
+// String formats the quota as "limit/window", or "" when unlimited.
+func (q RequestQuota) String() string {
+	if q.Limit <= 0 {
+		return ""
+	}
+	return strconv.Itoa(q.Limit) + "/" + q.Window
+}
+
+// ID identifies the key in the admin API and logs without revealing it: the
+// first 12 hex digits of the key's SHA-256 hash.
+func (pk ProxyKey) ID() string {
+	sum := sha256.Sum256([]byte(pk.Key))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Expired reports whether the key has expired at now.
+func (pk ProxyKey) Expired(now time.Time) bool {
+	return !pk.ExpiresAt.IsZero() && !now.Before(pk.ExpiresAt)
+}
+
+// RequestTags merges a request's alias and key tags, the key's winning
+// where both set a name. It returns nil when neither has tags.
+func RequestTags(model ModelConfig, key ProxyKey) map[string]string {
+	if len(model.Tags) == 0 && len(key.Tags) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(model.Tags)+len(key.Tags))
+	maps.Copy(tags, model.Tags)
+	maps.Copy(tags, key.Tags)
+	return tags
+}
+
+// ProviderModels returns the provider model names a request for the alias
+// can reach: the override_params model of the alias or of each target, or
+// requested, which is forwarded unchanged where none is set.
+func (m ModelConfig) ProviderModels(requested string) []string {
+	override := func(params map[string]interface{}) string {
+		if name, ok := params["model"].(string); ok && name != "" {
+			return name
+		}
+		return requested
+	}
+	if m.Strategy == nil || len(m.Targets) == 0 {
+		return []string{override(m.OverrideParams)}
+	}
+	names := make([]string, 0, len(m.Targets))
+	for _, target := range m.Targets {
+		names = append(names, override(target.OverrideParams))
+	}
+	return names
+}
+
+// BannedModel returns the first provider model the alias can reach that
+// matches one of its banned_models or the global patterns, or "" when none
+// does. Patterns are path.Match globs compared case-insensitively.
+func BannedModel(model ModelConfig, requested string, global []string) string {
+	if len(model.BannedModels) == 0 && len(global) == 0 {
+		return ""
+	}
+	for _, name := range model.ProviderModels(requested) {
+		for _, pattern := range append(slices.Clip(global), model.BannedModels...) {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+				return name
+			}
+		}
+	}
+	return ""
 }
 
 // ConfigStore holds all loaded configuration in memory.
 type ConfigStore struct {
+	// Models is read through Model and ModelAliases once the server is running,
+	// since the admin API can replace entries concurrently.
 	Models     map[string]ModelConfig
 	ProxyKeys  []ProxyKey
 	ServerPort int
@@ -84,10 +411,370 @@ type ConfigStore struct {
 	LogLevel   string
 	StartTime  time.Time
 
+	// LogLevelTimeout is how long a log level changed at runtime lasts
+	// before reverting to LogLevel.
+	LogLevelTimeout time.Duration
+
+	// AdminAddr is the host:port of a second listener serving the health,
+	// stats, pprof and admin endpoints, which are then no longer served on
+	// ServerPort. Empty serves everything on ServerPort, without pprof.
+	AdminAddr string
+
+	// ControlPlaneGRPCAddr is the host:port of a gRPC listener serving the
+	// control plane. Empty serves it on the admin API only.
+	ControlPlaneGRPCAddr string
+
+	// TLSCert and TLSKey enable HTTPS on the listener.
+	TLSCert string
+	TLSKey  string
+
+	// ACMEDomains enable HTTPS on the listener with certificates obtained
+	// from ACMEDirectoryURL (Let's Encrypt when empty) and kept in
+	// ACMECacheDir. ACMEEmail is the optional account contact.
+	ACMEDomains      []string
+	ACMEEmail        string
+	ACMECacheDir     string
+	ACMEDirectoryURL string
+
+	// GatewayRoutes send aliases matching a pattern to another gateway, from
+	// PORTUS_GATEWAY_ROUTES; the first match wins.
+	GatewayRoutes []GatewayRoute
+
+	// GatewayTLSCert, GatewayTLSKey and GatewayTLSCA configure (mutual) TLS to
+	// the gateway.
+	GatewayTLSCert string
+	GatewayTLSKey  string
+	GatewayTLSCA   string
+
+	// TLSReloadInterval is how often certificate files are checked for rotation.
+	TLSReloadInterval time.Duration
+
+	// SecretsRefreshInterval is how often secret references in model configs
+	// are fetched again; zero disables refreshing.
+	SecretsRefreshInterval time.Duration
+
+	// RemoteConfigURL is the s3://, gs://, consul:// or etcd:// location
+	// ConfigPath mirrors; empty for a local config directory.
+	// RemoteConfigRefreshInterval is how often a bucket is checked for
+	// changes, or a failed Consul or etcd watch retried; zero disables
+	// refreshing.
+	RemoteConfigURL             string
+	RemoteConfigRefreshInterval time.Duration
+
+	// ConfigWatchInterval is how often a local config directory is checked
+	// for changed files, such as mounted ConfigMaps and Secrets; zero
+	// disables watching.
+	ConfigWatchInterval time.Duration
+
+	// H2C accepts HTTP/2 without TLS on the listener, from clients with prior
+	// knowledge. GatewayH2C speaks it to a plaintext gateway.
+	H2C        bool
+	GatewayH2C bool
+
+	// ReadOnly starts the server with runtime configuration changes frozen;
+	// only a restart lifts it.
+	ReadOnly bool
+
+	// KeyExpiryWarning is how far ahead of a key's expiry warnings are logged.
+	KeyExpiryWarning time.Duration
+
+	// UsageDBPath is the SQLite database persisting per-request usage; empty disables it.
+	UsageDBPath string
+	// MetricsMode controls how much detail usage metrics keep.
+	MetricsMode privacy.Mode
+	// MetricsAppBuckets is the number of application buckets in aggregate mode.
+	MetricsAppBuckets int
+	// MetricsMinCount is the request count below which aggregates are folded
+	// together in aggregate mode.
+	MetricsMinCount int
+
+	// ReportDir receives a JSON summary report on shutdown; empty only logs it.
+	ReportDir string
+	// RedisURL is the Redis database of the redis storage backend.
+	RedisURL string
+	// RedisStreamLease bounds how long a stream slot kept in a shared storage
+	// backend survives a crashed replica.
+	RedisStreamLease time.Duration
+	// Storage is where budgets, quotas, stream limits, usage totals, circuit
+	// state and the semantic cache are kept: memory, sqlite or redis.
+	Storage string
+	// StoragePath is the database file of the sqlite storage backend.
+	StoragePath string
+
+	// UsageRetention bounds how long usage history is kept at each resolution.
+	UsageRetention usage.Retention
+
+	// StreamProgressInterval is how often in-flight streams are sampled.
+	StreamProgressInterval time.Duration
+	// StreamMaxAge is the age at which open streams are force-closed as
+	// leaked or hung; zero disables the reaper.
+	StreamMaxAge time.Duration
+
+	// CanaryInterval is how often synthetic canaries run; zero disables them.
+	CanaryInterval time.Duration
+	// CanaryAliases limits canaries to these aliases; empty means all aliases.
+	CanaryAliases []string
+
+	// SemanticCacheAlias is the embeddings alias used by the semantic cache;
+	// empty disables caching.
+	SemanticCacheAlias string
+	// SemanticCacheThreshold is the cosine similarity at which a cached answer is reused.
+	SemanticCacheThreshold float64
+	// SemanticCacheTTL is how long cached answers are kept.
+	SemanticCacheTTL time.Duration
+	// SemanticCacheMaxEntries bounds the number of cached answers.
+	SemanticCacheMaxEntries int
+
+	// ModelListTTL is how long live provider model lists are cached.
+	ModelListTTL time.Duration
+
+	// ProxyRetries is how many times Portus retries a gateway attempt that
+	// failed to connect or returned 5xx before any bytes reached the client.
+	ProxyRetries int
+	// ProxyRetryBackoff is the delay before the first retry; it doubles each time.
+	ProxyRetryBackoff time.Duration
+
+	// CircuitBreakerThreshold is the number of consecutive upstream failures
+	// that open an alias's circuit; zero disables circuit breaking.
+	CircuitBreakerThreshold int
+	// CircuitBreakerCooldown is how long an open circuit fails fast before probing.
+	CircuitBreakerCooldown time.Duration
+
+	// CredentialFailureThreshold is the number of consecutive upstream 401
+	// or 403 responses that degrade an alias; zero disables it.
+	CredentialFailureThreshold int
+	// CredentialFailureProbeInterval is how often a degraded alias lets one
+	// request through to test its credentials; zero never probes.
+	CredentialFailureProbeInterval time.Duration
+	// CredentialFailureHideModels leaves degraded aliases out of /v1/models.
+	CredentialFailureHideModels bool
+
+	// MaxRequestTimeout caps every request timeout server-wide; zero disables it.
+	MaxRequestTimeout time.Duration
+
+	// ShutdownDrainDelay is how long readiness fails before the server stops
+	// accepting connections on shutdown.
+	ShutdownDrainDelay time.Duration
+
+	// LogResponseHash records a SHA-256 of each relayed response body, before
+	// any gzip encoding, in the access log, so consumers can verify they
+	// processed the exact output.
+	LogResponseHash bool
+
+	// FallbackMessage is the global static completion served when a gateway
+	// request fails outright; an alias's fallback_message takes precedence.
+	FallbackMessage string
+
+	// NormalizeFinishReasons maps provider finish/stop reasons onto the OpenAI
+	// vocabulary in chat, completion and message responses.
+	NormalizeFinishReasons bool
+
+	// MessageStreamValidation checks the event sequence of Anthropic Messages
+	// streams: "log" reports violations, "repair" also fixes them or ends the
+	// stream with an error event; empty disables it.
+	MessageStreamValidation string
+
+	// DefaultModel is the alias serving requests whose model is empty or not
+	// a known alias; empty rejects them.
+	DefaultModel string
+
+	// BannedModels are globs of provider model names no alias may reach,
+	// from PORTUS_BANNED_MODELS.
+	BannedModels []string
+
+	// StreamBurst is extra headroom above every key's stream cap; streams
+	// using it are admitted but logged.
+	StreamBurst int
+
+	// LimitsGraceUntil makes stream and concurrency limits log-only until
+	// this time, so limits can be rolled out without rejecting requests.
+	LimitsGraceUntil time.Time
+	// MaxTokensAction is what happens to requests asking for more than
+	// their key's MaxTokens: "clamp" or "reject".
+	MaxTokensAction string
+
+	// ConversationTokensAction is what happens to requests in a conversation
+	// past its key's ConversationTokens: "reject" or "warn".
+	ConversationTokensAction string
+	// ConversationIdleTimeout is how long an idle conversation's token count
+	// is kept.
+	ConversationIdleTimeout time.Duration
+
+	// APIVersion is the API version requests are served under when neither
+	// the path, the request nor the key names one.
+	APIVersion string
+
+	// MockMode answers requests locally with "echo" or "canned" responses
+	// instead of contacting the gateway; empty disables it.
+	MockMode string
+	// MockResponse is the canned reply in mock mode.
+	MockResponse string
+
+	// RecordFile is a JSON Lines file receiving sanitized request/response
+	// pairs for "portus replay"; empty disables recording.
+	RecordFile string
+
+	// PIIAction is how PII found in request content is handled by default:
+	// "off", "mask" or "reject".
+	PIIAction string
+	// PIIApplicationActions overrides PIIAction by application name.
+	PIIApplicationActions map[string]string
+	// PIIPatterns names the built-in PII patterns scanned for.
+	PIIPatterns []string
+	// PIICustomPatterns maps custom PII pattern names to regular expressions.
+	PIICustomPatterns map[string]string
+
+	// StatsDAddr is the host:port receiving StatsD request metrics; empty
+	// disables the StatsD sink.
+	StatsDAddr string
+	// StatsDPrefix is prepended to every StatsD metric name.
+	StatsDPrefix string
+	// StatsDFormat is "statsd", or "dogstatsd" to send tags.
+	StatsDFormat string
+
+	// OTLPEndpoint is the OTLP/HTTP collector receiving logs and metrics;
+	// empty disables OpenTelemetry export.
+	OTLPEndpoint string
+	// OTLPHeaders are sent with every export, e.g. for collector auth.
+	OTLPHeaders map[string]string
+	// OTLPServiceName is reported as the service.name resource attribute.
+	OTLPServiceName string
+	// OTLPLogs and OTLPMetrics select the exported signals.
+	OTLPLogs    bool
+	OTLPMetrics bool
+	// OTLPMetricInterval is how often metrics are exported.
+	OTLPMetricInterval time.Duration
+
+	// GzipResponses compresses JSON responses for clients that accept gzip.
+	GzipResponses bool
+
+	// AnnotateResponses adds the alias, provider, target and attempt that
+	// served a request to JSON responses and as a final comment on streams.
+	AnnotateResponses bool
+
+	// BodySpillThreshold is the number of bytes of a request body held in
+	// memory; larger bodies are spilled to a temporary file. Zero keeps
+	// every body in memory.
+	BodySpillThreshold int64
+	// BodySpillDir receives spilled bodies; empty uses the system default.
+	BodySpillDir string
+
+	// ProvenanceKey signs the X-Portus-Provenance header added to inference
+	// responses. Empty disables the header.
+	ProvenanceKey string
+
+	// Finished asynchronous jobs are kept for JobsTTL, and at most
+	// JobsMaxActive run at once on each replica (0 is unlimited).
+	JobsTTL       time.Duration
+	JobsMaxActive int
+	// JobsWebhookHosts are globs of the hosts a job's own webhook URL may
+	// point at; empty rejects per-job webhooks.
+	JobsWebhookHosts []string
+
+	// Webhook receives job, alert and guardrail events for every application,
+	// and ApplicationWebhooks those concerning one application, keyed by
+	// application name. Deliveries are tried WebhookMaxAttempts times,
+	// waiting WebhookRetryBackoff before the first retry and doubling it
+	// after, and failures are appended to WebhookDeadLetterFile when set.
+	Webhook               WebhookTarget
+	ApplicationWebhooks   map[string]WebhookTarget
+	WebhookMaxAttempts    int
+	WebhookRetryBackoff   time.Duration
+	WebhookDeadLetterFile string
+
+	// Guardrails holds per-application rules loaded from guardrails.json,
+	// keyed by application name.
+	Guardrails map[string]GuardrailConfig
+
+	// Budgets holds per-application spend limits loaded from budgets.json,
+	// keyed by application name.
+	Budgets map[string]BudgetConfig
+
+	// Pricing holds the global pricing table loaded from pricing.json, keyed by
+	// model alias or resolved provider model name. Per-alias pricing takes precedence.
+	Pricing map[string]PricingConfig
+
 	// RawConfigs holds the raw (pre-expansion) JSON content of each model config file,
 	// keyed by alias. Used during validation to check for missing env vars without
 	// re-reading files. Cleared after validation.
 	RawConfigs map[string]string
+
+	mu sync.RWMutex
+	// patterns is the compiled match table, rebuilt lazily after the alias
+	// set changes.
+	patterns []aliasPattern
+}
+
+// Model returns the configuration for a model alias. A name that is not an
+// alias resolves to the first alias, in sorted order, with a matching match
+// pattern.
+func (s *ConfigStore) Model(alias string) (ModelConfig, bool) {
+	s.mu.RLock()
+	model, ok := s.Models[alias]
+	patterns := s.patterns
+	s.mu.RUnlock()
+	if ok {
+		return model, true
+	}
+
+	if patterns == nil {
+		s.mu.Lock()
+		if s.patterns == nil {
+			s.patterns = compilePatterns(s.Models)
+		}
+		patterns = s.patterns
+		s.mu.Unlock()
+	}
+	for _, p := range patterns {
+		if p.match(alias) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			model, ok := s.Models[p.alias]
+			return model, ok
+		}
+	}
+	return ModelConfig{}, false
+}
+
+// ModelAliases returns all configured model aliases in sorted order.
+func (s *ConfigStore) ModelAliases() []string {
+	s.mu.RLock()
+	aliases := make([]string, 0, len(s.Models))
+	for alias := range s.Models {
+		aliases = append(aliases, alias)
+	}
+	s.mu.RUnlock()
+	sort.Strings(aliases)
+	return aliases
+}
+
+// SetModels replaces the configuration of the given aliases.
+func (s *ConfigStore) SetModels(updates map[string]ModelConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for alias, model := range updates {
+		s.Models[alias] = model
+	}
+	s.patterns = nil
+}
+
+// ReplaceModels swaps the entire alias set.
+func (s *ConfigStore) ReplaceModels(models map[string]ModelConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Models = models
+	s.patterns = nil
+}
+
+// ModelsSnapshot returns a copy of the current alias set.
+func (s *ConfigStore) ModelsSnapshot() map[string]ModelConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := make(map[string]ModelConfig, len(s.Models))
+	for alias, model := range s.Models {
+		snapshot[alias] = model
+	}
+	return snapshot
 }
 
 // PortkeyConfig is the configuration structure sent to Portkey Gateway.
@@ -99,6 +786,10 @@ type PortkeyConfig struct {
 	OverrideParams map[string]interface{} `json:"override_params,omitempty"`
 	Retry          *RetryConfig           `json:"retry,omitempty"`
 	RequestTimeout int                    `json:"request_timeout,omitempty"`
+	CustomHost     string                 `json:"custom_host,omitempty"`
+	ForwardHeaders []string               `json:"forward_headers,omitempty"`
+	// StrictOpenAICompliance is omitted when nil so the gateway default applies.
+	StrictOpenAICompliance *bool `json:"strict_open_ai_compliance,omitempty"`
 
 	// AWS Bedrock specific
 	AWSAccessKeyID     string `json:"aws_access_key_id,omitempty"`
@@ -128,6 +819,158 @@ type HealthResponse struct {
 	Uptime  string `json:"uptime"`
 }
 
+// StatsResponse represents the usage statistics for the calling application.
+type StatsResponse struct {
+	Application      string         `json:"application"`
+	EstimatedCostUSD float64        `json:"estimated_cost_usd"`
+	Usage            []usage.Totals `json:"usage"`
+
+	// ActiveStreams lists live progress of the application's in-flight streams.
+	ActiveStreams []progress.Sample `json:"active_streams"`
+	// OpenStreams lists every open stream of the application, oldest first,
+	// including those not yet sampled.
+	OpenStreams []progress.StreamInfo `json:"open_streams"`
+	// ReapedStreams counts streams force-closed for exceeding the maximum age.
+	ReapedStreams int64 `json:"reaped_streams"`
+	// Providers reports live streaming throughput per provider.
+	Providers []progress.ProviderThroughput `json:"providers"`
+	// History is the usage time series, present when a resolution is requested.
+	History []usage.Bucket `json:"history,omitempty"`
+}
+
+// WhoamiResponse describes how Portus resolved the caller's credentials.
+type WhoamiResponse struct {
+	Application   string   `json:"application"`
+	Scope         KeyScope `json:"scope"`
+	RequestID     string   `json:"request_id"`
+	AllowedModels []string `json:"allowed_models"`
+	// Limits is the caller's effective limits and budget state, as returned
+	// by /v1/limits; omitted for keys that cannot call models.
+	Limits *LimitsResponse `json:"limits,omitempty"`
+}
+
+// LimitsResponse describes the caller's limits and how much of each remains,
+// so clients can pace themselves before hitting a 429.
+type LimitsResponse struct {
+	Application string `json:"application"`
+	// Streams is omitted when the key's concurrent streams are unlimited.
+	Streams *StreamLimits `json:"streams,omitempty"`
+	// MaxTokens is omitted when completion tokens are unlimited.
+	MaxTokens *MaxTokensLimit `json:"max_tokens,omitempty"`
+	// Requests is omitted when the key has no request quota.
+	Requests *RequestQuotaUsage `json:"requests,omitempty"`
+	Budgets  []BudgetUsage      `json:"budgets"`
+	// GraceUntil is set while stream and concurrency limits only log.
+	GraceUntil *time.Time `json:"grace_until,omitempty"`
+}
+
+// StreamLimits reports a key's concurrent stream cap and current use.
+type StreamLimits struct {
+	Limit     int `json:"limit"`
+	Burst     int `json:"burst"`
+	Active    int `json:"active"`
+	Remaining int `json:"remaining"`
+}
+
+// MaxTokensLimit reports a key's completion token cap and how it is enforced.
+type MaxTokensLimit struct {
+	Limit  int    `json:"limit"`
+	Action string `json:"action"`
+}
+
+// RequestQuotaUsage reports a key's request quota and use in the current
+// window.
+type RequestQuotaUsage struct {
+	Limit     int       `json:"limit"`
+	Window    string    `json:"window"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// BudgetUsage reports spend in one budget window.
+type BudgetUsage struct {
+	Window       string    `json:"window"`
+	LimitUSD     float64   `json:"limit_usd"`
+	SpentUSD     float64   `json:"spent_usd"`
+	RemainingUSD float64   `json:"remaining_usd"`
+	ResetsAt     time.Time `json:"resets_at"`
+}
+
+// StreamLimitError is the 429 response returned when a key has too many
+// concurrent streams.
+type StreamLimitError struct {
+	Error         string `json:"error"`
+	ActiveStreams int    `json:"active_streams"`
+	MaxStreams    int    `json:"max_streams"`
+}
+
+// PolicyViolationError is the 400 response returned when a request breaks a
+// guardrail rule.
+type PolicyViolationError struct {
+	Error string `json:"error"`
+	Type  string `json:"type"`
+	// Rule is the kind of rule broken, e.g. "banned_phrase".
+	Rule string `json:"rule"`
+	// Scope is "model" for alias rules and "application" for the caller's.
+	Scope string `json:"scope"`
+}
+
+// MaxTokensError is the 400 response returned when a request asks for more
+// completion tokens than its key allows.
+type MaxTokensError struct {
+	Error     string `json:"error"`
+	Requested int    `json:"requested"`
+	MaxTokens int    `json:"max_tokens"`
+}
+
+// BudgetExceededError is the 429 response returned when an application has
+// spent its budget for the current window.
+type BudgetExceededError struct {
+	Error string `json:"error"`
+	Type  string `json:"type"`
+	// Window is "daily" or "monthly".
+	Window   string    `json:"window"`
+	LimitUSD float64   `json:"limit_usd"`
+	SpentUSD float64   `json:"spent_usd"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// QuotaExceededError is the 429 response returned when a key has used its
+// request quota for the current window.
+type QuotaExceededError struct {
+	Error    string    `json:"error"`
+	Type     string    `json:"type"`
+	Limit    int       `json:"limit"`
+	Window   string    `json:"window"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// APIVersionError is the 400 response returned when a request asks for an
+// API version this build does not serve.
+type APIVersionError struct {
+	Error     string   `json:"error"`
+	Supported []string `json:"supported"`
+}
+
+// ConversationLimitError is the 400 response returned when a conversation
+// has used its key's cumulative token limit.
+type ConversationLimitError struct {
+	Error          string `json:"error"`
+	Type           string `json:"type"`
+	ConversationID string `json:"conversation_id"`
+	Tokens         int    `json:"tokens"`
+	MaxTokens      int    `json:"max_tokens"`
+}
+
+// ConcurrencyLimitError is the 429 response returned when an alias has too
+// many requests in flight and waiting.
+type ConcurrencyLimitError struct {
+	Error         string `json:"error"`
+	MaxConcurrent int    `json:"max_concurrent"`
+	MaxQueue      int    `json:"max_queue"`
+}
+
 // ModelsListResponse represents the OpenAI-compatible models list.
 type ModelsListResponse struct {
 	Object string        `json:"object"`
@@ -154,6 +997,17 @@ type ChatCompletionRequest struct {
 	// Additional fields can be added as needed
 }
 
+// CompletionRequest represents a legacy OpenAI text completion request.
+type CompletionRequest struct {
+	Model       string      `json:"model"`
+	Prompt      interface{} `json:"prompt"` // Can be string or array of strings
+	Stream      bool        `json:"stream,omitempty"`
+	MaxTokens   int         `json:"max_tokens,omitempty"`
+	Temperature float64     `json:"temperature,omitempty"`
+	TopP        float64     `json:"top_p,omitempty"`
+	Stop        interface{} `json:"stop,omitempty"`
+}
+
 // Message represents a chat message.
 type Message struct {
 	Role      string      `json:"role"`
@@ -189,6 +1043,52 @@ type AnthropicMessage struct {
 	Content interface{} `json:"content"` // Can be string or array of content blocks
 }
 
+// ResponsesRequest represents an OpenAI Responses API request.
+type ResponsesRequest struct {
+	Model              string      `json:"model"`
+	Input              interface{} `json:"input"` // Can be string or array of input items
+	Instructions       string      `json:"instructions,omitempty"`
+	Stream             bool        `json:"stream,omitempty"`
+	MaxOutputTokens    int         `json:"max_output_tokens,omitempty"`
+	PreviousResponseID string      `json:"previous_response_id,omitempty"`
+}
+
+// EmbeddingsRequest represents an OpenAI embeddings request.
+type EmbeddingsRequest struct {
+	Model          string      `json:"model"`
+	Input          interface{} `json:"input"` // Can be string, array of strings, or token arrays
+	EncodingFormat string      `json:"encoding_format,omitempty"`
+	Dimensions     int         `json:"dimensions,omitempty"`
+	User           string      `json:"user,omitempty"`
+}
+
+// ModerationRequest represents an OpenAI moderation request.
+type ModerationRequest struct {
+	Model string      `json:"model"`
+	Input interface{} `json:"input"` // Can be string, array of strings, or multimodal inputs
+}
+
+// ImageGenerationRequest represents an OpenAI image generation request.
+type ImageGenerationRequest struct {
+	Model          string `json:"model"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n,omitempty"`
+	Size           string `json:"size,omitempty"`
+	Quality        string `json:"quality,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+	Style          string `json:"style,omitempty"`
+	User           string `json:"user,omitempty"`
+}
+
+// SpeechRequest represents an OpenAI text-to-speech request.
+type SpeechRequest struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	ResponseFormat string  `json:"response_format,omitempty"`
+	Speed          float64 `json:"speed,omitempty"`
+}
+
 // LogEntry represents a request log entry.
 type LogEntry struct {
 	Timestamp   string `json:"timestamp"`
@@ -198,4 +1098,7 @@ type LogEntry struct {
 	Provider    string `json:"provider"`
 	StatusCode  int    `json:"status_code"`
 	DurationMs  int64  `json:"duration_ms"`
+
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
 }
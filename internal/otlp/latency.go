@@ -0,0 +1,171 @@
+package otlp
+
+import (
+	"encoding/hex"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBounds are the upper bounds, in milliseconds, of the request
+// duration histogram buckets.
+var latencyBounds = []float64{50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000}
+
+// Latency accumulates request durations per application, alias and tag set
+// for the portus.request.duration histogram. Each bucket keeps the latest request
+// that arrived with a sampled W3C trace context as an exemplar, linking the
+// bucket to a representative trace. A nil Latency records nothing.
+type Latency struct {
+	mu     sync.Mutex
+	series map[latencyKey]*latencySeries
+}
+
+type latencyKey struct {
+	application, modelAlias string
+	// tags is the series' tags in canonical "name=value,..." form.
+	tags string
+}
+
+type latencySeries struct {
+	tags      map[string]string
+	counts    []uint64
+	count     uint64
+	sum       float64
+	exemplars []*exemplar
+}
+
+type exemplar struct {
+	TimeUnixNano string  `json:"timeUnixNano"`
+	AsDouble     float64 `json:"asDouble"`
+	TraceID      string  `json:"traceId"`
+	SpanID       string  `json:"spanId"`
+}
+
+// NewLatency creates an empty latency histogram.
+func NewLatency() *Latency {
+	return &Latency{series: make(map[latencyKey]*latencySeries)}
+}
+
+// Record adds a request's duration. tags become attributes of the request's
+// series. traceparent is the request's W3C traceparent header; a sampled
+// trace becomes the bucket's exemplar.
+func (l *Latency) Record(application, modelAlias string, tags map[string]string, d time.Duration, traceparent string) {
+	if l == nil {
+		return
+	}
+	ms := float64(d) / float64(time.Millisecond)
+	bucket := len(latencyBounds)
+	for i, bound := range latencyBounds {
+		if ms <= bound {
+			bucket = i
+			break
+		}
+	}
+	var ex *exemplar
+	if traceID, spanID, ok := ParseTraceparent(traceparent); ok {
+		ex = &exemplar{TimeUnixNano: unixNano(time.Now()), AsDouble: ms, TraceID: traceID, SpanID: spanID}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := slices.Sorted(maps.Keys(tags))
+	canonical := make([]string, len(names))
+	for i, name := range names {
+		canonical[i] = name + "=" + tags[name]
+	}
+	key := latencyKey{application, modelAlias, strings.Join(canonical, ",")}
+	s, ok := l.series[key]
+	if !ok {
+		s = &latencySeries{
+			tags:      maps.Clone(tags),
+			counts:    make([]uint64, len(latencyBounds)+1),
+			exemplars: make([]*exemplar, len(latencyBounds)+1),
+		}
+		l.series[key] = s
+	}
+	s.counts[bucket]++
+	s.count++
+	s.sum += ms
+	if ex != nil {
+		s.exemplars[bucket] = ex
+	}
+}
+
+type histogramDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	Count             string     `json:"count"`
+	Sum               float64    `json:"sum"`
+	BucketCounts      []string   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+	Exemplars         []exemplar `json:"exemplars,omitempty"`
+}
+
+type histogram struct {
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+// points returns the cumulative data points for every series.
+func (l *Latency) points(startNano, now string) []histogramDataPoint {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	points := make([]histogramDataPoint, 0, len(l.series))
+	for key, s := range l.series {
+		p := histogramDataPoint{
+			Attributes: []keyValue{
+				{Key: "application", Value: stringValue(key.application)},
+				{Key: "model_alias", Value: stringValue(key.modelAlias)},
+			},
+			StartTimeUnixNano: startNano,
+			TimeUnixNano:      now,
+			Count:             strconv.FormatUint(s.count, 10),
+			Sum:               s.sum,
+			BucketCounts:      make([]string, len(s.counts)),
+			ExplicitBounds:    latencyBounds,
+		}
+		for _, name := range slices.Sorted(maps.Keys(s.tags)) {
+			p.Attributes = append(p.Attributes, keyValue{Key: name, Value: stringValue(s.tags[name])})
+		}
+		for i, n := range s.counts {
+			p.BucketCounts[i] = strconv.FormatUint(n, 10)
+			if s.exemplars[i] != nil {
+				p.Exemplars = append(p.Exemplars, *s.exemplars[i])
+			}
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// ParseTraceparent returns the trace and parent span IDs of a W3C
+// traceparent header ("00-<trace id>-<span id>-<flags>"). It reports false
+// for malformed headers and for traces that were not sampled, which a
+// tracing backend would not have stored.
+func ParseTraceparent(header string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || parts[0] == "ff" || len(parts[0]) != 2 ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", false
+	}
+	for _, p := range parts[:4] {
+		if _, err := hex.DecodeString(p); err != nil || strings.ToLower(p) != p {
+			return "", "", false
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	if flags[0]&1 == 0 {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
@@ -0,0 +1,117 @@
+package otlp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		header string
+		wantOK bool
+	}{
+		{name: "sampled", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantOK: true},
+		{name: "future version with extra fields", header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantOK: true},
+		{name: "not sampled", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{name: "empty", header: ""},
+		{name: "zero trace ID", header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "zero span ID", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "invalid version", header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "uppercase hex", header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "short trace ID", header: "00-4bf92f3577b34da6-00f067aa0ba902b7-01"},
+		{name: "extra fields in version 00", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			traceID, spanID, ok := ParseTraceparent(tt.header)
+			if ok != tt.wantOK {
+				t.Fatalf("ParseTraceparent(%q) ok = %v, want %v", tt.header, ok, tt.wantOK)
+			}
+			if ok && (traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spanID != "00f067aa0ba902b7") {
+				t.Errorf("got trace %q span %q", traceID, spanID)
+			}
+		})
+	}
+}
+
+func TestLatency_Record(t *testing.T) {
+	t.Parallel()
+
+	var none *Latency
+	none.Record("BACKEND", "gpt4", nil, time.Second, "")
+
+	latency := NewLatency()
+	latency.Record("BACKEND", "gpt4", nil, 30*time.Millisecond, "")
+	latency.Record("BACKEND", "gpt4", nil, 4*time.Second, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	latency.Record("BACKEND", "gpt4", nil, 3*time.Second, "00-11111111111111111111111111111111-2222222222222222-00")
+
+	points := latency.points("1", "2")
+	if len(points) != 1 {
+		t.Fatalf("expected one series, got %d", len(points))
+	}
+	p := points[0]
+	if p.Count != "3" || p.Sum != 7030 {
+		t.Errorf("expected count 3 and sum 7030, got %s and %v", p.Count, p.Sum)
+	}
+	if p.BucketCounts[0] != "1" || p.BucketCounts[6] != "2" {
+		t.Errorf("unexpected bucket counts %v", p.BucketCounts)
+	}
+	if len(p.Exemplars) != 1 || p.Exemplars[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || p.Exemplars[0].AsDouble != 4000 {
+		t.Errorf("expected the sampled trace as the only exemplar, got %+v", p.Exemplars)
+	}
+}
+
+func TestLatency_RecordTags(t *testing.T) {
+	t.Parallel()
+
+	latency := NewLatency()
+	latency.Record("BACKEND", "gpt4", map[string]string{"team": "search", "env": "prod"}, time.Second, "")
+	latency.Record("BACKEND", "gpt4", map[string]string{"env": "prod", "team": "search"}, time.Second, "")
+	latency.Record("BACKEND", "gpt4", nil, time.Second, "")
+
+	points := latency.points("1", "2")
+	if len(points) != 2 {
+		t.Fatalf("expected a series per tag set, got %d", len(points))
+	}
+	for _, p := range points {
+		if len(p.Attributes) == 2 {
+			continue
+		}
+		if p.Count != "2" || len(p.Attributes) != 4 || p.Attributes[2].Key != "env" || p.Attributes[3].Key != "team" {
+			t.Errorf("unexpected tagged series: count %s, attributes %+v", p.Count, p.Attributes)
+		}
+	}
+}
+
+func TestExporter_ExportMetrics_Latency(t *testing.T) {
+	t.Parallel()
+
+	c, server := newCollector(t)
+	exporter := NewExporter(server.URL, nil, "portus", discardLogger())
+	latency := NewLatency()
+	latency.Record("BACKEND", "gpt4", nil, 2*time.Second, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err := exporter.ExportMetrics(context.Background(), Metrics{Latency: latency}, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var duration any
+	for _, m := range dig(c.payloads[0], "resourceMetrics", 0, "scopeMetrics", 0, "metrics").([]any) {
+		if dig(m, "name") == "portus.request.duration" {
+			duration = m
+		}
+	}
+	if got := dig(duration, "histogram", "dataPoints", 0, "count"); got != "1" {
+		t.Errorf("expected count 1, got %v", got)
+	}
+	if got := dig(duration, "histogram", "dataPoints", 0, "exemplars", 0, "traceId"); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected exemplar trace ID, got %v", got)
+	}
+}
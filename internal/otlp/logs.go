@@ -0,0 +1,237 @@
+package otlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxQueuedLogs bounds the records buffered between exports; further records
+// are dropped and counted until the next export.
+const maxQueuedLogs = 4096
+
+type logRecord struct {
+	TimeUnixNano         string     `json:"timeUnixNano"`
+	ObservedTimeUnixNano string     `json:"observedTimeUnixNano"`
+	SeverityNumber       int        `json:"severityNumber"`
+	SeverityText         string     `json:"severityText"`
+	Body                 anyValue   `json:"body"`
+	Attributes           []keyValue `json:"attributes,omitempty"`
+}
+
+// logQueue is shared by a LogHandler and the handlers derived from it.
+type logQueue struct {
+	exporter *Exporter
+
+	mu      sync.Mutex
+	records []logRecord
+	dropped int64
+}
+
+// LogHandler is a slog.Handler that buffers records and exports them in
+// batches. Combine it with the console handler using Tee.
+type LogHandler struct {
+	queue *logQueue
+	level slog.Leveler
+	attrs []keyValue
+	group string
+}
+
+// NewLogHandler creates a handler exporting records at or above level.
+func NewLogHandler(exporter *Exporter, level slog.Leveler) *LogHandler {
+	return &LogHandler{queue: &logQueue{exporter: exporter}, level: level}
+}
+
+// Enabled implements slog.Handler.
+func (h *LogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle implements slog.Handler.
+func (h *LogHandler) Handle(_ context.Context, record slog.Record) error {
+	attrs := append([]keyValue(nil), h.attrs...)
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = appendAttr(attrs, h.group, a)
+		return true
+	})
+	now := time.Now()
+	timestamp := record.Time
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+
+	q := h.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.records) >= maxQueuedLogs {
+		q.dropped++
+		return nil
+	}
+	q.records = append(q.records, logRecord{
+		TimeUnixNano:         unixNano(timestamp),
+		ObservedTimeUnixNano: unixNano(now),
+		SeverityNumber:       severity(record.Level),
+		SeverityText:         record.Level.String(),
+		Body:                 stringValue(record.Message),
+		Attributes:           attrs,
+	})
+	return nil
+}
+
+// WithAttrs implements slog.Handler.
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]keyValue(nil), h.attrs...)
+	for _, a := range attrs {
+		clone.attrs = appendAttr(clone.attrs, h.group, a)
+	}
+	return &clone
+}
+
+// WithGroup implements slog.Handler.
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.group = h.group + name + "."
+	return &clone
+}
+
+// Flush exports the buffered records.
+func (h *LogHandler) Flush(ctx context.Context) error {
+	q := h.queue
+	q.mu.Lock()
+	records, dropped := q.records, q.dropped
+	q.records, q.dropped = nil, 0
+	q.mu.Unlock()
+
+	if dropped > 0 {
+		now := unixNano(time.Now())
+		records = append(records, logRecord{
+			TimeUnixNano:         now,
+			ObservedTimeUnixNano: now,
+			SeverityNumber:       severity(slog.LevelWarn),
+			SeverityText:         slog.LevelWarn.String(),
+			Body:                 stringValue("OTLP log records dropped"),
+			Attributes:           []keyValue{{Key: "dropped", Value: intValue(dropped)}},
+		})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	err := q.exporter.post(ctx, SignalLogs, map[string]any{
+		"resourceLogs": []any{map[string]any{
+			"resource": q.exporter.resource,
+			"scopeLogs": []any{map[string]any{
+				"scope":      portusScope(),
+				"logRecords": records,
+			}},
+		}},
+	})
+	q.exporter.report(SignalLogs, err)
+	return err
+}
+
+// Run exports buffered records every interval until ctx ends. Call Flush
+// afterwards to export what remains.
+func (h *LogHandler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.Flush(ctx)
+		}
+	}
+}
+
+// severity maps a slog level onto the OTLP severity number, where DEBUG is
+// 5, INFO 9, WARN 13 and ERROR 17.
+func severity(level slog.Level) int {
+	return min(max(int(level)+9, 1), 24)
+}
+
+// appendAttr flattens a into OTLP attributes, joining group names with dots.
+func appendAttr(attrs []keyValue, prefix string, a slog.Attr) []keyValue {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, member := range a.Value.Group() {
+			attrs = appendAttr(attrs, prefix, member)
+		}
+		return attrs
+	}
+	return append(attrs, keyValue{Key: prefix + a.Key, Value: attrValue(a.Value)})
+}
+
+func attrValue(v slog.Value) anyValue {
+	switch v.Kind() {
+	case slog.KindString:
+		return stringValue(v.String())
+	case slog.KindInt64:
+		return intValue(v.Int64())
+	case slog.KindUint64:
+		if v.Uint64() > 1<<63-1 {
+			return stringValue(strconv.FormatUint(v.Uint64(), 10))
+		}
+		return intValue(int64(v.Uint64()))
+	case slog.KindFloat64:
+		return doubleValue(v.Float64())
+	case slog.KindBool:
+		return boolValue(v.Bool())
+	case slog.KindDuration:
+		return stringValue(v.Duration().String())
+	case slog.KindTime:
+		return stringValue(v.Time().Format(time.RFC3339Nano))
+	}
+	if err, ok := v.Any().(error); ok {
+		return stringValue(err.Error())
+	}
+	return stringValue(fmt.Sprint(v.Any()))
+}
+
+// Tee returns a handler writing every record to both handlers, such as the
+// console handler and a LogHandler.
+func Tee(a, b slog.Handler) slog.Handler {
+	return tee{a, b}
+}
+
+type tee struct {
+	a, b slog.Handler
+}
+
+func (t tee) Enabled(ctx context.Context, level slog.Level) bool {
+	return t.a.Enabled(ctx, level) || t.b.Enabled(ctx, level)
+}
+
+func (t tee) Handle(ctx context.Context, record slog.Record) error {
+	var errA, errB error
+	if t.a.Enabled(ctx, record.Level) {
+		errA = t.a.Handle(ctx, record.Clone())
+	}
+	if t.b.Enabled(ctx, record.Level) {
+		errB = t.b.Handle(ctx, record)
+	}
+	return errors.Join(errA, errB)
+}
+
+func (t tee) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return tee{t.a.WithAttrs(attrs), t.b.WithAttrs(attrs)}
+}
+
+func (t tee) WithGroup(name string) slog.Handler {
+	return tee{t.a.WithGroup(name), t.b.WithGroup(name)}
+}
@@ -0,0 +1,145 @@
+package otlp
+
+import (
+	"context"
+	"time"
+
+	"github.com/amscotti/portus/internal/usage"
+)
+
+// aggregationCumulative is the OTLP temporality of sums that only grow.
+const aggregationCumulative = 2
+
+// Metrics is a snapshot of the values exported as metrics.
+type Metrics struct {
+	// Totals are the usage aggregates per application and alias.
+	Totals []usage.Totals
+	// ActiveStreams is the number of streaming responses in flight.
+	ActiveStreams int64
+	// Latency holds request durations, exported as a histogram when set.
+	Latency *Latency
+}
+
+type dataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsInt             *string    `json:"asInt,omitempty"`
+	AsDouble          *float64   `json:"asDouble,omitempty"`
+}
+
+type sum struct {
+	DataPoints             []dataPoint `json:"dataPoints"`
+	AggregationTemporality int         `json:"aggregationTemporality"`
+	IsMonotonic            bool        `json:"isMonotonic"`
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+type metric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Unit        string     `json:"unit"`
+	Sum         *sum       `json:"sum,omitempty"`
+	Gauge       *gauge     `json:"gauge,omitempty"`
+	Histogram   *histogram `json:"histogram,omitempty"`
+}
+
+// ExportMetrics exports m as cumulative sums and histograms counted since
+// start.
+func (e *Exporter) ExportMetrics(ctx context.Context, m Metrics, start time.Time) error {
+	startNano, now := unixNano(start), unixNano(time.Now())
+	point := func(attrs []keyValue) dataPoint {
+		return dataPoint{Attributes: attrs, StartTimeUnixNano: startNano, TimeUnixNano: now}
+	}
+	intPoint := func(attrs []keyValue, v int64) dataPoint {
+		p := point(attrs)
+		p.AsInt = intValue(v).IntValue
+		return p
+	}
+
+	var requests, tokens, cost, fallbacks, cacheHits, terminations []dataPoint
+	for _, t := range m.Totals {
+		attrs := []keyValue{
+			{Key: "application", Value: stringValue(t.Application)},
+			{Key: "model_alias", Value: stringValue(t.ModelAlias)},
+		}
+		tokenAttrs := func(kind string) []keyValue {
+			return append(append([]keyValue(nil), attrs...), keyValue{Key: "token.type", Value: stringValue(kind)})
+		}
+		reasonAttrs := func(reason string) []keyValue {
+			return append(append([]keyValue(nil), attrs...), keyValue{Key: "reason", Value: stringValue(reason)})
+		}
+		costPoint := point(attrs)
+		costPoint.AsDouble = &t.EstimatedCostUSD
+
+		requests = append(requests, intPoint(attrs, t.Requests))
+		tokens = append(tokens,
+			intPoint(tokenAttrs("prompt"), t.PromptTokens),
+			intPoint(tokenAttrs("completion"), t.CompletionTokens),
+		)
+		cost = append(cost, costPoint)
+		fallbacks = append(fallbacks, intPoint(attrs, t.FallbackResponses))
+		cacheHits = append(cacheHits, intPoint(attrs, t.CacheHits))
+		terminations = append(terminations,
+			intPoint(reasonAttrs(usage.TerminationClientCancelled), t.ClientCancellations),
+			intPoint(reasonAttrs(usage.TerminationTimeout), t.Timeouts),
+			intPoint(reasonAttrs(usage.TerminationUpstreamError), t.UpstreamErrors),
+		)
+	}
+	counter := func(name, description, unit string, points []dataPoint) metric {
+		return metric{Name: name, Description: description, Unit: unit, Sum: &sum{
+			DataPoints:             points,
+			AggregationTemporality: aggregationCumulative,
+			IsMonotonic:            true,
+		}}
+	}
+
+	metrics := []metric{
+		counter("portus.requests", "Requests served per application and alias", "{request}", requests),
+		counter("portus.tokens", "Tokens used per application and alias", "{token}", tokens),
+		counter("portus.cost", "Estimated provider cost per application and alias", "USD", cost),
+		counter("portus.fallback_responses", "Requests answered with the static fallback message", "{request}", fallbacks),
+		counter("portus.cache_hits", "Requests answered from the semantic cache", "{request}", cacheHits),
+		counter("portus.terminations", "Gateway requests ended early, by reason", "{request}", terminations),
+		{Name: "portus.active_streams", Description: "Streaming responses in flight", Unit: "{stream}", Gauge: &gauge{
+			DataPoints: []dataPoint{{TimeUnixNano: now, AsInt: intValue(m.ActiveStreams).IntValue}},
+		}},
+	}
+	if m.Latency != nil {
+		metrics = append(metrics, metric{
+			Name: "portus.request.duration", Description: "Proxied request duration per application and alias", Unit: "ms",
+			Histogram: &histogram{DataPoints: m.Latency.points(startNano, now), AggregationTemporality: aggregationCumulative},
+		})
+	}
+
+	err := e.post(ctx, SignalMetrics, map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": e.resource,
+			"scopeMetrics": []any{map[string]any{
+				"scope":   portusScope(),
+				"metrics": metrics,
+			}},
+		}},
+	})
+	e.report(SignalMetrics, err)
+	return err
+}
+
+// RunMetrics exports the metrics returned by collect every interval until
+// ctx ends. Sums are counted from when RunMetrics is called.
+func (e *Exporter) RunMetrics(ctx context.Context, interval time.Duration, collect func() Metrics) {
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.ExportMetrics(ctx, collect(), start)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Package otlp exports Portus logs and metrics to an OpenTelemetry collector
+// over OTLP/HTTP with JSON encoding, so deployments standardized on the
+// collector receive every signal through one endpoint configuration.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+// Signals.
+const (
+	SignalLogs    = "logs"
+	SignalMetrics = "metrics"
+)
+
+// scopeName identifies Portus as the instrumentation scope.
+const scopeName = "github.com/amscotti/portus"
+
+// ParseSignals parses a comma-separated list of signals to export.
+func ParseSignals(value string) (logs, metrics bool, err error) {
+	for _, signal := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(signal)) {
+		case SignalLogs:
+			logs = true
+		case SignalMetrics:
+			metrics = true
+		case "":
+		default:
+			return false, false, fmt.Errorf("unknown signal %q (must be %q or %q)", signal, SignalLogs, SignalMetrics)
+		}
+	}
+	return logs, metrics, nil
+}
+
+// ParseHeaders parses OTEL_EXPORTER_OTLP_HEADERS, a comma-separated list of
+// key=value pairs whose values may be URL-encoded.
+func ParseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("header %q must be key=value", pair)
+		}
+		decoded, err := url.QueryUnescape(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w", key, err)
+		}
+		headers[key] = decoded
+	}
+	return headers, nil
+}
+
+// Exporter posts OTLP payloads to a collector. It is safe for concurrent use.
+type Exporter struct {
+	endpoint string
+	headers  map[string]string
+	resource resource
+	client   *http.Client
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	failing map[string]bool
+}
+
+// NewExporter creates an exporter for the collector at endpoint, e.g.
+// "http://otel-collector:4318". Export failures are reported to logger,
+// which must not itself export through OTLP.
+func NewExporter(endpoint string, headers map[string]string, serviceName string, logger *slog.Logger) *Exporter {
+	return &Exporter{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		headers:  headers,
+		resource: resource{Attributes: []keyValue{
+			{Key: "service.name", Value: stringValue(serviceName)},
+			{Key: "service.version", Value: stringValue(models.Version)},
+		}},
+		client:  &http.Client{Timeout: 10 * time.Second},
+		logger:  logger,
+		failing: make(map[string]bool),
+	}
+}
+
+// post sends one export request for signal.
+func (e *Exporter) post(ctx context.Context, signal string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+"/v1/"+signal, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// report logs when exports of signal start failing and when they recover,
+// rather than on every attempt.
+func (e *Exporter) report(signal string, err error) {
+	e.mu.Lock()
+	was := e.failing[signal]
+	e.failing[signal] = err != nil
+	e.mu.Unlock()
+
+	switch {
+	case err != nil && !was:
+		e.logger.Warn("OTLP export failing", "signal", signal, "endpoint", e.endpoint, "error", err)
+	case err == nil && was:
+		e.logger.Info("OTLP export recovered", "signal", signal, "endpoint", e.endpoint)
+	}
+}
+
+// OTLP/JSON wire types. 64-bit integers are encoded as strings.
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+func portusScope() scope {
+	return scope{Name: scopeName, Version: models.Version}
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func stringValue(s string) anyValue {
+	return anyValue{StringValue: &s}
+}
+
+func boolValue(b bool) anyValue {
+	return anyValue{BoolValue: &b}
+}
+
+func doubleValue(f float64) anyValue {
+	return anyValue{DoubleValue: &f}
+}
+
+func intValue(i int64) anyValue {
+	s := strconv.FormatInt(i, 10)
+	return anyValue{IntValue: &s}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
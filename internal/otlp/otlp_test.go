@@ -0,0 +1,295 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/usage"
+)
+
+// collector records the OTLP requests it receives.
+type collector struct {
+	mu       sync.Mutex
+	paths    []string
+	headers  []http.Header
+	payloads []map[string]any
+	status   int
+}
+
+func newCollector(t *testing.T) (*collector, *httptest.Server) {
+	t.Helper()
+	c := &collector{status: http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		json.NewDecoder(r.Body).Decode(&payload)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.paths = append(c.paths, r.URL.Path)
+		c.headers = append(c.headers, r.Header.Clone())
+		c.payloads = append(c.payloads, payload)
+		w.WriteHeader(c.status)
+	}))
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// dig walks a decoded JSON document through object keys and array indexes.
+func dig(v any, path ...any) any {
+	for _, p := range path {
+		switch key := p.(type) {
+		case string:
+			m, _ := v.(map[string]any)
+			v = m[key]
+		case int:
+			a, _ := v.([]any)
+			if key >= len(a) {
+				return nil
+			}
+			v = a[key]
+		}
+	}
+	return v
+}
+
+func TestParseHeaders(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: map[string]string{}},
+		{name: "pairs", value: "api-key=abc, x-scope-orgid = ops", want: map[string]string{"api-key": "abc", "x-scope-orgid": "ops"}},
+		{name: "url encoded", value: "authorization=Basic%20dXNlcjpwYXNz", want: map[string]string{"authorization": "Basic dXNlcjpwYXNz"}},
+		{name: "missing value", value: "api-key", wantErr: true},
+		{name: "bad escape", value: "api-key=%zz", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseHeaders(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHeaders(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseHeaders(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("header %q = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestLogHandler_ExportsRecords(t *testing.T) {
+	t.Parallel()
+
+	c, server := newCollector(t)
+	exporter := NewExporter(server.URL+"/", map[string]string{"X-Api-Key": "secret"}, "portus-test", discardLogger())
+	handler := NewLogHandler(exporter, slog.LevelInfo)
+	logger := slog.New(handler).With("component", "proxy").WithGroup("request")
+
+	logger.Debug("not exported")
+	logger.Warn("slow upstream", "status", 504, slog.Group("latency", "ms", 1.5), "error", errors.New("timeout"))
+	if err := handler.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.paths) != 1 || c.paths[0] != "/v1/logs" {
+		t.Fatalf("expected one export to /v1/logs, got %v", c.paths)
+	}
+	if c.headers[0].Get("X-Api-Key") != "secret" {
+		t.Errorf("expected configured header, got %v", c.headers[0])
+	}
+
+	resource := dig(c.payloads[0], "resourceLogs", 0, "resource", "attributes", 0, "value", "stringValue")
+	if resource != "portus-test" {
+		t.Errorf("expected service.name portus-test, got %v", resource)
+	}
+	records, _ := dig(c.payloads[0], "resourceLogs", 0, "scopeLogs", 0, "logRecords").([]any)
+	if len(records) != 1 {
+		t.Fatalf("expected one record, got %d", len(records))
+	}
+	record := records[0]
+	if dig(record, "body", "stringValue") != "slow upstream" || dig(record, "severityNumber") != float64(13) {
+		t.Errorf("unexpected record: %v", record)
+	}
+
+	attrs := make(map[string]any)
+	for _, a := range dig(record, "attributes").([]any) {
+		value := dig(a, "value").(map[string]any)
+		for _, v := range value {
+			attrs[dig(a, "key").(string)] = v
+		}
+	}
+	want := map[string]any{
+		"component":          "proxy",
+		"request.status":     "504",
+		"request.latency.ms": 1.5,
+		"request.error":      "timeout",
+	}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("attribute %q = %v, want %v (all: %v)", k, attrs[k], v, attrs)
+		}
+	}
+}
+
+func TestLogHandler_DropsWhenFull(t *testing.T) {
+	t.Parallel()
+
+	c, server := newCollector(t)
+	handler := NewLogHandler(NewExporter(server.URL, nil, "portus", discardLogger()), slog.LevelInfo)
+	logger := slog.New(handler)
+	for range maxQueuedLogs + 3 {
+		logger.Info("event")
+	}
+	if err := handler.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	records, _ := dig(c.payloads[0], "resourceLogs", 0, "scopeLogs", 0, "logRecords").([]any)
+	if len(records) != maxQueuedLogs+1 {
+		t.Fatalf("expected %d records including the drop notice, got %d", maxQueuedLogs+1, len(records))
+	}
+	if dig(records[maxQueuedLogs], "attributes", 0, "value", "intValue") != "3" {
+		t.Errorf("expected 3 dropped records, got %v", records[maxQueuedLogs])
+	}
+}
+
+func TestExporter_ReportsFailuresOnce(t *testing.T) {
+	t.Parallel()
+
+	c, server := newCollector(t)
+	c.status = http.StatusServiceUnavailable
+	var out strings.Builder
+	var mu sync.Mutex
+	logger := slog.New(slog.NewTextHandler(writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return out.Write(p)
+	}), nil))
+	handler := NewLogHandler(NewExporter(server.URL, nil, "portus", logger), slog.LevelInfo)
+
+	for range 3 {
+		slog.New(handler).Info("event")
+		if err := handler.Flush(context.Background()); err == nil {
+			t.Fatal("expected export error")
+		}
+	}
+	c.mu.Lock()
+	c.status = http.StatusOK
+	c.mu.Unlock()
+	slog.New(handler).Info("event")
+	if err := handler.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if n := strings.Count(out.String(), "OTLP export failing"); n != 1 {
+		t.Errorf("expected one failure message, got %d:\n%s", n, out.String())
+	}
+	if !strings.Contains(out.String(), "OTLP export recovered") {
+		t.Errorf("expected recovery message:\n%s", out.String())
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestExporter_ExportMetrics(t *testing.T) {
+	t.Parallel()
+
+	c, server := newCollector(t)
+	exporter := NewExporter(server.URL, nil, "portus", discardLogger())
+	err := exporter.ExportMetrics(context.Background(), Metrics{
+		Totals: []usage.Totals{{
+			Application: "BACKEND", ModelAlias: "gpt4",
+			Requests: 3, PromptTokens: 30, CompletionTokens: 12, EstimatedCostUSD: 0.25, CacheHits: 1,
+			Timeouts: 4,
+		}},
+		ActiveStreams: 2,
+	}, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.paths) != 1 || c.paths[0] != "/v1/metrics" {
+		t.Fatalf("expected one export to /v1/metrics, got %v", c.paths)
+	}
+	metrics := make(map[string]any)
+	for _, m := range dig(c.payloads[0], "resourceMetrics", 0, "scopeMetrics", 0, "metrics").([]any) {
+		metrics[dig(m, "name").(string)] = m
+	}
+
+	if got := dig(metrics["portus.requests"], "sum", "dataPoints", 0, "asInt"); got != "3" {
+		t.Errorf("expected 3 requests, got %v", got)
+	}
+	if got := dig(metrics["portus.requests"], "sum", "aggregationTemporality"); got != float64(aggregationCumulative) {
+		t.Errorf("expected cumulative temporality, got %v", got)
+	}
+	if got := dig(metrics["portus.tokens"], "sum", "dataPoints", 1, "asInt"); got != "12" {
+		t.Errorf("expected 12 completion tokens, got %v", got)
+	}
+	if got := dig(metrics["portus.cost"], "sum", "dataPoints", 0, "asDouble"); got != 0.25 {
+		t.Errorf("expected cost 0.25, got %v", got)
+	}
+	if got := dig(metrics["portus.terminations"], "sum", "dataPoints", 1, "asInt"); got != "4" {
+		t.Errorf("expected 4 timeouts, got %v", got)
+	}
+	if got := dig(metrics["portus.active_streams"], "gauge", "dataPoints", 0, "asInt"); got != "2" {
+		t.Errorf("expected 2 active streams, got %v", got)
+	}
+}
+
+func TestTee(t *testing.T) {
+	t.Parallel()
+
+	var info, debug strings.Builder
+	logger := slog.New(Tee(
+		slog.NewTextHandler(&info, &slog.HandlerOptions{Level: slog.LevelInfo}),
+		slog.NewTextHandler(&debug, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	)).With("app", "BACKEND")
+
+	logger.Debug("verbose")
+	logger.Info("started")
+	if strings.Contains(info.String(), "verbose") || !strings.Contains(info.String(), "started") {
+		t.Errorf("unexpected info output: %s", info.String())
+	}
+	if !strings.Contains(debug.String(), "verbose") || !strings.Contains(debug.String(), "app=BACKEND") {
+		t.Errorf("unexpected debug output: %s", debug.String())
+	}
+}
@@ -0,0 +1,95 @@
+// Package override lets operators change an alias's retries and routing for
+// a single request, to reproduce and isolate provider issues during an
+// incident without editing config. Overrides are only honored on requests
+// that also carry an admin key in KeyHeader.
+package override
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Request headers.
+const (
+	// KeyHeader carries the admin key authorizing the overrides. The request
+	// itself is still made, and accounted, with its inference key.
+	KeyHeader = "X-Portus-Override-Key"
+	// RetryHeader sets the gateway's retry attempts; 0 disables retries.
+	RetryHeader = "X-Portus-Override-Retry"
+	// FallbackHeader set to "off" sends the request to the alias's first
+	// target only.
+	FallbackHeader = "X-Portus-Override-Fallback"
+	// TargetHeader pins the request to the target with this zero-based index.
+	TargetHeader = "X-Portus-Override-Target"
+)
+
+// MaxRetryAttempts is the most retry attempts the gateway accepts.
+const MaxRetryAttempts = 5
+
+// Override is the set of overrides requested for one request.
+type Override struct {
+	// Retry replaces the alias's retry attempts when set.
+	Retry *int
+	// NoFallback limits the request to the alias's first target.
+	NoFallback bool
+	// Target pins the request to one target when set.
+	Target *int
+}
+
+// Requested reports whether h asks for any override.
+func Requested(h http.Header) bool {
+	return h.Get(RetryHeader) != "" || h.Get(FallbackHeader) != "" || h.Get(TargetHeader) != ""
+}
+
+// Parse reads the overrides in h.
+func Parse(h http.Header) (Override, error) {
+	var o Override
+	if v := h.Get(RetryHeader); v != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 || n > MaxRetryAttempts {
+			return Override{}, fmt.Errorf("%s must be a number of attempts from 0 to %d", RetryHeader, MaxRetryAttempts)
+		}
+		o.Retry = &n
+	}
+	switch v := strings.ToLower(strings.TrimSpace(h.Get(FallbackHeader))); v {
+	case "", "on":
+	case "off":
+		o.NoFallback = true
+	default:
+		return Override{}, fmt.Errorf("%s must be \"off\" or \"on\"", FallbackHeader)
+	}
+	if v := h.Get(TargetHeader); v != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 {
+			return Override{}, fmt.Errorf("%s must be a target index", TargetHeader)
+		}
+		o.Target = &n
+	}
+	if o.NoFallback && o.Target != nil {
+		return Override{}, fmt.Errorf("%s and %s cannot be combined", FallbackHeader, TargetHeader)
+	}
+	return o, nil
+}
+
+// IsZero reports whether no override was requested.
+func (o Override) IsZero() bool {
+	return o.Retry == nil && !o.NoFallback && o.Target == nil
+}
+
+// String describes the overrides for logs and audit events, e.g.
+// "retry=0 fallback=off".
+func (o Override) String() string {
+	var parts []string
+	if o.Retry != nil {
+		parts = append(parts, "retry="+strconv.Itoa(*o.Retry))
+	}
+	if o.NoFallback {
+		parts = append(parts, "fallback=off")
+	}
+	if o.Target != nil {
+		parts = append(parts, "target="+strconv.Itoa(*o.Target))
+	}
+	return strings.Join(parts, " ")
+}
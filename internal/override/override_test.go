@@ -0,0 +1,48 @@
+package override
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "none", headers: map[string]string{}, want: ""},
+		{name: "no retries", headers: map[string]string{RetryHeader: "0"}, want: "retry=0"},
+		{name: "retries and no fallback", headers: map[string]string{RetryHeader: "3", FallbackHeader: "OFF"}, want: "retry=3 fallback=off"},
+		{name: "fallback on", headers: map[string]string{FallbackHeader: "on"}, want: ""},
+		{name: "pinned target", headers: map[string]string{TargetHeader: "1"}, want: "target=1"},
+		{name: "too many retries", headers: map[string]string{RetryHeader: "6"}, wantErr: true},
+		{name: "negative retries", headers: map[string]string{RetryHeader: "-1"}, wantErr: true},
+		{name: "invalid fallback", headers: map[string]string{FallbackHeader: "maybe"}, wantErr: true},
+		{name: "invalid target", headers: map[string]string{TargetHeader: "primary"}, wantErr: true},
+		{name: "pinned target without fallback", headers: map[string]string{TargetHeader: "1", FallbackHeader: "off"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			h := http.Header{}
+			for name, value := range tt.headers {
+				h.Set(name, value)
+			}
+			o, err := Parse(h)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if o.String() != tt.want || o.IsZero() != (tt.want == "") {
+				t.Errorf("expected %q, got %q", tt.want, o.String())
+			}
+			if Requested(h) != (len(tt.headers) > 0) {
+				t.Errorf("expected Requested to report %v", len(tt.headers) > 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+// Package pii scans request message content for personal data such as email
+// addresses, social security numbers and card numbers, and either masks it
+// before the request is forwarded or rejects the request.
+package pii
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/amscotti/portus/internal/middleware"
+)
+
+// Actions.
+const (
+	// ActionOff forwards requests without scanning them.
+	ActionOff = "off"
+	// ActionMask replaces each match with a placeholder naming its pattern.
+	ActionMask = "mask"
+	// ActionReject refuses requests containing a match.
+	ActionReject = "reject"
+)
+
+// maxBodySize matches the handlers' request limit; larger bodies are passed
+// through unscanned for the handler to reject.
+const maxBodySize = 10 * 1024 * 1024
+
+// ParseAction validates a PORTUS_PII_ACTION value; empty means off.
+func ParseAction(value string) (string, error) {
+	switch value {
+	case "":
+		return ActionOff, nil
+	case ActionOff, ActionMask, ActionReject:
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown PII action %q (must be %q, %q or %q)", value, ActionOff, ActionMask, ActionReject)
+}
+
+// Builtins names the built-in patterns.
+var Builtins = []string{"email", "ssn", "credit_card"}
+
+var builtinPatterns = map[string]pattern{
+	"email":       {re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)},
+	"ssn":         {re: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	"credit_card": {re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhn},
+}
+
+// contentKeys are the JSON fields holding message content. Strings are
+// scanned when their field, or the array containing them, has one of these
+// names, so roles, model names and image data are left alone.
+var contentKeys = map[string]bool{
+	"content":      true,
+	"text":         true,
+	"prompt":       true,
+	"input":        true,
+	"system":       true,
+	"instructions": true,
+}
+
+type pattern struct {
+	name        string
+	placeholder string
+	re          *regexp.Regexp
+	// valid filters out matches that only look like PII, e.g. digit runs
+	// failing the card checksum.
+	valid func(string) bool
+}
+
+// Scanner finds PII in request bodies. It is safe for concurrent use.
+type Scanner struct {
+	patterns []pattern
+}
+
+// NewScanner builds a scanner from built-in pattern names and custom
+// patterns mapping names to regular expressions.
+func NewScanner(builtins []string, custom map[string]string) (*Scanner, error) {
+	s := &Scanner{}
+	for _, name := range builtins {
+		p, ok := builtinPatterns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown PII pattern %q (must be one of %s)", name, strings.Join(Builtins, ", "))
+		}
+		p.name = name
+		s.patterns = append(s.patterns, p)
+	}
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		re, err := regexp.Compile(custom[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid PII pattern %s: %w", name, err)
+		}
+		s.patterns = append(s.patterns, pattern{name: name, re: re})
+	}
+	for i := range s.patterns {
+		s.patterns[i].placeholder = "[" + strings.ToUpper(s.patterns[i].name) + "]"
+	}
+	return s, nil
+}
+
+// Scan returns the names of the patterns found in the message content of a
+// JSON request body, sorted. With mask, it also returns the body with every
+// match replaced; otherwise, or when nothing was found, body is returned
+// unchanged. Bodies that are not JSON objects are not scanned.
+func (s *Scanner) Scan(body []byte, mask bool) ([]byte, []string) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var root map[string]any
+	if err := dec.Decode(&root); err != nil {
+		return body, nil
+	}
+
+	found := make(map[string]bool)
+	masked := s.walk(root, false, mask, found)
+	if len(found) == 0 {
+		return body, nil
+	}
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if !mask {
+		return body, names
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(masked); err != nil {
+		return body, names
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), names
+}
+
+// walk scans the strings below v, returning v with matches masked when mask
+// is set. inContent reports whether v sits under a content field.
+func (s *Scanner) walk(v any, inContent, mask bool, found map[string]bool) any {
+	switch v := v.(type) {
+	case string:
+		if !inContent {
+			return v
+		}
+		return s.scanString(v, mask, found)
+	case []any:
+		for i, item := range v {
+			v[i] = s.walk(item, inContent, mask, found)
+		}
+	case map[string]any:
+		for key, item := range v {
+			v[key] = s.walk(item, contentKeys[key], mask, found)
+		}
+	}
+	return v
+}
+
+func (s *Scanner) scanString(text string, mask bool, found map[string]bool) string {
+	for _, p := range s.patterns {
+		text = p.re.ReplaceAllStringFunc(text, func(match string) string {
+			if p.valid != nil && !p.valid(match) {
+				return match
+			}
+			found[p.name] = true
+			if mask {
+				return p.placeholder
+			}
+			return match
+		})
+	}
+	return text
+}
+
+// luhn reports whether the digits in s pass the card number checksum.
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// Policy decides how each application's requests are handled.
+type Policy struct {
+	scanner       *Scanner
+	defaultAction string
+	applications  map[string]string
+}
+
+// NewPolicy applies defaultAction to every application except those listed
+// in applications, which maps application names to their own action.
+func NewPolicy(scanner *Scanner, defaultAction string, applications map[string]string) *Policy {
+	return &Policy{scanner: scanner, defaultAction: defaultAction, applications: applications}
+}
+
+// Action returns the action for an application's requests.
+func (p *Policy) Action(application string) string {
+	if action, ok := p.applications[application]; ok {
+		return action
+	}
+	if p.defaultAction == "" {
+		return ActionOff
+	}
+	return p.defaultAction
+}
+
+// Middleware applies the policy to request bodies. Place it after
+// authentication and request ID assignment, and before anything that should
+// only see masked content, such as recording.
+func (p *Policy) Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			application, _ := r.Context().Value(middleware.ContextKeyApplication).(string)
+			action := p.Action(application)
+			// Every other body is scanned, so omitting Content-Type cannot
+			// skip the policy; multipart audio uploads carry no text to scan
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); action == ActionOff || strings.HasPrefix(mediaType, "multipart/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+			if err != nil {
+				http.Error(w, `{"error": "Failed to read request body"}`, http.StatusBadRequest)
+				return
+			}
+			if len(body) > maxBodySize {
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scanned, found := p.scanner.Scan(body, action == ActionMask)
+			if len(found) > 0 {
+				requestID, _ := r.Context().Value(middleware.ContextKeyRequestID).(string)
+				if action == ActionReject {
+					logger.Warn("rejected request containing PII",
+						"request_id", requestID,
+						"application", application,
+						"patterns", found,
+					)
+					http.Error(w, fmt.Sprintf(`{"error": "Request content matches PII patterns: %s"}`, strings.Join(found, ", ")), http.StatusBadRequest)
+					return
+				}
+				logger.Info("masked PII in request",
+					"request_id", requestID,
+					"application", application,
+					"patterns", found,
+				)
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(scanned))
+			r.ContentLength = int64(len(scanned))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
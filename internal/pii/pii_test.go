@@ -0,0 +1,158 @@
+package pii
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amscotti/portus/internal/middleware"
+)
+
+func TestScanner_Scan(t *testing.T) {
+	t.Parallel()
+
+	scanner, err := NewScanner(Builtins, map[string]string{"EMPLOYEE_ID": `\bEMP-\d{6}\b`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		body      string
+		wantBody  string
+		wantFound string
+	}{
+		{
+			name:      "chat message",
+			body:      `{"model":"gpt-4o","messages":[{"role":"user","content":"mail jane.doe@example.com about 123-45-6789"}]}`,
+			wantBody:  `{"messages":[{"content":"mail [EMAIL] about [SSN]","role":"user"}],"model":"gpt-4o"}`,
+			wantFound: "email,ssn",
+		},
+		{
+			name:      "content parts and system",
+			body:      `{"system":[{"type":"text","text":"card 4111 1111 1111 1111"}],"messages":[{"role":"user","content":[{"type":"text","text":"badge EMP-123456"}]}]}`,
+			wantBody:  `{"messages":[{"content":[{"text":"badge [EMPLOYEE_ID]","type":"text"}],"role":"user"}],"system":[{"text":"card [CREDIT_CARD]","type":"text"}]}`,
+			wantFound: "EMPLOYEE_ID,credit_card",
+		},
+		{
+			name:      "prompt list",
+			body:      `{"prompt":["hi","write to bob@example.org"],"max_tokens":16}`,
+			wantBody:  `{"max_tokens":16,"prompt":["hi","write to [EMAIL]"]}`,
+			wantFound: "email",
+		},
+		{
+			name: "card number failing checksum",
+			body: `{"input":"order 4111 1111 1111 1112"}`,
+		},
+		{
+			name: "fields outside content",
+			body: `{"model":"bob@example.com","user":"123-45-6789","messages":[]}`,
+		},
+		{
+			name: "not JSON",
+			body: `bob@example.com`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			masked, found := scanner.Scan([]byte(tt.body), true)
+			if got := strings.Join(found, ","); got != tt.wantFound {
+				t.Errorf("found = %q, want %q", got, tt.wantFound)
+			}
+			wantBody := tt.wantBody
+			if wantBody == "" {
+				wantBody = tt.body
+			}
+			if string(masked) != wantBody {
+				t.Errorf("body = %s, want %s", masked, wantBody)
+			}
+
+			unmasked, _ := scanner.Scan([]byte(tt.body), false)
+			if string(unmasked) != tt.body {
+				t.Errorf("expected body unchanged without masking, got %s", unmasked)
+			}
+		})
+	}
+}
+
+func TestNewScanner_Invalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewScanner([]string{"phone"}, nil); err == nil {
+		t.Error("expected unknown built-in pattern to be rejected")
+	}
+	if _, err := NewScanner(nil, map[string]string{"BAD": `(`}); err == nil || !strings.Contains(err.Error(), "BAD") {
+		t.Errorf("expected error naming the invalid pattern, got %v", err)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{"", ActionOff, ActionMask, ActionReject} {
+		if _, err := ParseAction(value); err != nil {
+			t.Errorf("ParseAction(%q): unexpected error %v", value, err)
+		}
+	}
+	if _, err := ParseAction("block"); err == nil {
+		t.Error("expected unknown action to be rejected")
+	}
+}
+
+func TestPolicy_Middleware(t *testing.T) {
+	t.Parallel()
+
+	scanner, err := NewScanner(Builtins, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := NewPolicy(scanner, ActionMask, map[string]string{"STRICT": ActionReject, "TRUSTED": ActionOff})
+
+	var forwarded string
+	handler := policy.Middleware(slog.New(slog.NewTextHandler(io.Discard, nil)))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = string(body)
+	}))
+
+	const body = `{"messages":[{"role":"user","content":"I am bob@example.com"}]}`
+	tests := []struct {
+		application   string
+		contentType   string
+		wantStatus    int
+		wantForwarded string
+	}{
+		{"BACKEND", "application/json", http.StatusOK, `{"messages":[{"content":"I am [EMAIL]","role":"user"}]}`},
+		{"BACKEND", "", http.StatusOK, `{"messages":[{"content":"I am [EMAIL]","role":"user"}]}`},
+		{"STRICT", "application/json", http.StatusBadRequest, ""},
+		{"TRUSTED", "application/json", http.StatusOK, body},
+		{"BACKEND", "multipart/form-data; boundary=x", http.StatusOK, body},
+	}
+
+	for _, tt := range tests {
+		forwarded = ""
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyApplication, tt.application))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s %q: status = %d, want %d", tt.application, tt.contentType, rec.Code, tt.wantStatus)
+		}
+		if forwarded != tt.wantForwarded {
+			t.Errorf("%s %q: forwarded %s, want %s", tt.application, tt.contentType, forwarded, tt.wantForwarded)
+		}
+		if tt.wantStatus == http.StatusBadRequest && (!strings.Contains(rec.Body.String(), "email") || strings.Contains(rec.Body.String(), "bob@")) {
+			t.Errorf("expected rejection to name the pattern but not the match, got %s", rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Package postprocess cleans up the generated text of non-streaming
+// responses: trailing stop sequences, wrapper tags and whitespace.
+package postprocess
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+// Whitespace policies.
+const (
+	// WhitespaceTrim removes leading and trailing whitespace.
+	WhitespaceTrim = "trim"
+	// WhitespaceCollapse also removes trailing spaces from each line and
+	// collapses runs of blank lines into one.
+	WhitespaceCollapse = "collapse"
+)
+
+var (
+	tagName       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.:-]*$`)
+	trailingSpace = regexp.MustCompile(`[ \t]+\n`)
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// Validate checks that a post-processing config is well-formed.
+func Validate(cfg models.PostProcessConfig) error {
+	for _, seq := range cfg.StopSequences {
+		if seq == "" {
+			return fmt.Errorf("stop_sequences must not contain empty sequences")
+		}
+	}
+	for _, tag := range cfg.StripTags {
+		if !tagName.MatchString(tag) {
+			return fmt.Errorf("invalid tag name %q in strip_tags", tag)
+		}
+	}
+	switch cfg.Whitespace {
+	case "", WhitespaceTrim, WhitespaceCollapse:
+	default:
+		return fmt.Errorf("whitespace must be %q or %q", WhitespaceTrim, WhitespaceCollapse)
+	}
+	return nil
+}
+
+// Text applies cfg to one piece of generated text. A stop sequence the text
+// ends with is removed first, then wrapper tags enclosing the whole text are
+// unwrapped, outermost first, and finally the whitespace policy is applied.
+func Text(cfg models.PostProcessConfig, text string) string {
+	for _, seq := range cfg.StopSequences {
+		if trimmed, ok := strings.CutSuffix(strings.TrimRight(text, " \t\r\n"), seq); ok {
+			text = trimmed
+			break
+		}
+	}
+	for unwrapped := true; unwrapped; {
+		unwrapped = false
+		for _, tag := range cfg.StripTags {
+			if inner, ok := unwrap(text, tag); ok {
+				text = inner
+				unwrapped = true
+			}
+		}
+	}
+	switch cfg.Whitespace {
+	case WhitespaceTrim:
+		text = strings.TrimSpace(text)
+	case WhitespaceCollapse:
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		text = trailingSpace.ReplaceAllString(text, "\n")
+		text = blankLines.ReplaceAllString(text, "\n\n")
+		text = strings.TrimSpace(text)
+	}
+	return text
+}
+
+// unwrap returns the content of text when, ignoring surrounding whitespace,
+// it is a single <tag>...</tag> element. The opening tag may carry attributes.
+func unwrap(text, tag string) (string, bool) {
+	s := strings.TrimSpace(text)
+	rest, ok := strings.CutPrefix(s, "<"+tag)
+	if !ok {
+		return text, false
+	}
+	end := strings.IndexByte(rest, '>')
+	if end < 0 || (end > 0 && !strings.ContainsRune(" \t\r\n", rune(rest[0]))) {
+		return text, false
+	}
+	inner, ok := strings.CutSuffix(rest[end+1:], "</"+tag+">")
+	if !ok {
+		return text, false
+	}
+	return inner, true
+}
+
+// Apply post-processes the generated text in a JSON response: chat
+// choices[].message.content, completions choices[].text, Messages API text
+// content blocks and Responses API output_text parts. It reports whether
+// payload was changed; payloads that are not JSON objects are returned as-is.
+func Apply(cfg models.PostProcessConfig, payload []byte) ([]byte, bool) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return payload, false
+	}
+
+	textBlock := func(block map[string]json.RawMessage) bool {
+		if kind, _ := stringField(block, "type"); kind != "text" && kind != "output_text" {
+			return false
+		}
+		return rewriteString(cfg, block, "text")
+	}
+
+	changed := rewriteArray(doc, "choices", func(choice map[string]json.RawMessage) bool {
+		message := rewriteObject(choice, "message", func(msg map[string]json.RawMessage) bool {
+			return rewriteString(cfg, msg, "content")
+		})
+		return rewriteString(cfg, choice, "text") || message
+	})
+	if rewriteArray(doc, "content", textBlock) {
+		changed = true
+	}
+	if rewriteArray(doc, "output", func(item map[string]json.RawMessage) bool {
+		return rewriteArray(item, "content", textBlock)
+	}) {
+		changed = true
+	}
+
+	if !changed {
+		return payload, false
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return payload, false
+	}
+	return out, true
+}
+
+// rewriteArray calls fn on each object in obj[key], re-encoding the array
+// when any call changed its object.
+func rewriteArray(obj map[string]json.RawMessage, key string, fn func(map[string]json.RawMessage) bool) bool {
+	raw, ok := obj[key]
+	if !ok {
+		return false
+	}
+	var items []map[string]json.RawMessage
+	if json.Unmarshal(raw, &items) != nil {
+		return false
+	}
+	changed := false
+	for _, item := range items {
+		if item != nil && fn(item) {
+			changed = true
+		}
+	}
+	if changed {
+		obj[key], _ = json.Marshal(items)
+	}
+	return changed
+}
+
+// rewriteObject calls fn on the object in obj[key], re-encoding it when fn
+// changed it.
+func rewriteObject(obj map[string]json.RawMessage, key string, fn func(map[string]json.RawMessage) bool) bool {
+	raw, ok := obj[key]
+	if !ok {
+		return false
+	}
+	var inner map[string]json.RawMessage
+	if json.Unmarshal(raw, &inner) != nil || inner == nil || !fn(inner) {
+		return false
+	}
+	obj[key], _ = json.Marshal(inner)
+	return true
+}
+
+// rewriteString post-processes the string in obj[key].
+func rewriteString(cfg models.PostProcessConfig, obj map[string]json.RawMessage, key string) bool {
+	value, ok := stringField(obj, key)
+	if !ok {
+		return false
+	}
+	processed := Text(cfg, value)
+	if processed == value {
+		return false
+	}
+	obj[key], _ = json.Marshal(processed)
+	return true
+}
+
+func stringField(obj map[string]json.RawMessage, key string) (string, bool) {
+	raw, ok := obj[key]
+	if !ok {
+		return "", false
+	}
+	var value string
+	if json.Unmarshal(raw, &value) != nil {
+		return "", false
+	}
+	return value, true
+}
@@ -0,0 +1,137 @@
+package postprocess
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		cfg     models.PostProcessConfig
+		wantErr string
+	}{
+		{name: "valid", cfg: models.PostProcessConfig{StopSequences: []string{"</s>"}, StripTags: []string{"answer", "ns:out"}, Whitespace: WhitespaceCollapse}},
+		{name: "empty", cfg: models.PostProcessConfig{}},
+		{name: "empty stop sequence", cfg: models.PostProcessConfig{StopSequences: []string{""}}, wantErr: "empty sequences"},
+		{name: "invalid tag", cfg: models.PostProcessConfig{StripTags: []string{"<answer>"}}, wantErr: "invalid tag name"},
+		{name: "unknown whitespace", cfg: models.PostProcessConfig{Whitespace: "squash"}, wantErr: "whitespace must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := Validate(tt.cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestText(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		cfg  models.PostProcessConfig
+		text string
+		want string
+	}{
+		{name: "trailing stop sequence", cfg: models.PostProcessConfig{StopSequences: []string{"###"}}, text: "done###\n", want: "done"},
+		{name: "stop sequence mid text kept", cfg: models.PostProcessConfig{StopSequences: []string{"###"}}, text: "a ### b", want: "a ### b"},
+		{name: "first matching stop sequence only", cfg: models.PostProcessConfig{StopSequences: []string{"</s>", "END"}}, text: "hiEND</s>", want: "hiEND"},
+		{name: "wrapper tag", cfg: models.PostProcessConfig{StripTags: []string{"answer"}}, text: "\n<answer>42</answer>\n", want: "42"},
+		{name: "wrapper tag with attributes", cfg: models.PostProcessConfig{StripTags: []string{"answer"}}, text: `<answer lang="en">42</answer>`, want: "42"},
+		{name: "nested wrappers", cfg: models.PostProcessConfig{StripTags: []string{"answer", "output"}}, text: "<output><answer>42</answer></output>", want: "42"},
+		{name: "partial wrapper kept", cfg: models.PostProcessConfig{StripTags: []string{"answer"}}, text: "<answer>42</answer> and more", want: "<answer>42</answer> and more"},
+		{name: "prefix tag name kept", cfg: models.PostProcessConfig{StripTags: []string{"a"}}, text: "<answer>42</a>", want: "<answer>42</a>"},
+		{name: "trim", cfg: models.PostProcessConfig{Whitespace: WhitespaceTrim}, text: "  a  \n\n\n\nb  ", want: "a  \n\n\n\nb"},
+		{name: "collapse", cfg: models.PostProcessConfig{Whitespace: WhitespaceCollapse}, text: "  a  \r\n\n\n\nb\t\nc ", want: "a\n\nb\nc"},
+		{
+			name: "all in order",
+			cfg:  models.PostProcessConfig{StopSequences: []string{"<|end|>"}, StripTags: []string{"answer"}, Whitespace: WhitespaceTrim},
+			text: "<answer>\n  42\n</answer><|end|>",
+			want: "42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := Text(tt.cfg, tt.text); got != tt.want {
+				t.Errorf("Text(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	cfg := models.PostProcessConfig{Whitespace: WhitespaceTrim}
+	tests := []struct {
+		name        string
+		payload     string
+		want        string
+		wantChanged bool
+	}{
+		{
+			name:        "chat completion",
+			payload:     `{"choices":[{"index":0,"message":{"role":"assistant","content":" hi "}},{"index":1,"message":{"role":"assistant","content":null,"tool_calls":[]}}]}`,
+			want:        `{"choices":[{"index":0,"message":{"content":"hi","role":"assistant"}},{"index":1,"message":{"role":"assistant","content":null,"tool_calls":[]}}]}`,
+			wantChanged: true,
+		},
+		{
+			name:        "completion",
+			payload:     `{"choices":[{"index":0,"text":" hi "}]}`,
+			want:        `{"choices":[{"index":0,"text":"hi"}]}`,
+			wantChanged: true,
+		},
+		{
+			name:        "messages",
+			payload:     `{"content":[{"type":"text","text":" hi "},{"type":"tool_use","id":"t1","input":{"text":" keep "}}]}`,
+			want:        `{"content":[{"text":"hi","type":"text"},{"id":"t1","input":{"text":" keep "},"type":"tool_use"}]}`,
+			wantChanged: true,
+		},
+		{
+			name:        "responses",
+			payload:     `{"output":[{"type":"message","content":[{"type":"output_text","text":" hi "}]}]}`,
+			want:        `{"output":[{"content":[{"text":"hi","type":"output_text"}],"type":"message"}]}`,
+			wantChanged: true,
+		},
+		{
+			name:    "already clean",
+			payload: `{"choices":[{"index":0,"message":{"content":"hi"}}]}`,
+			want:    `{"choices":[{"index":0,"message":{"content":"hi"}}]}`,
+		},
+		{
+			name:    "not json",
+			payload: `not json`,
+			want:    `not json`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, changed := Apply(cfg, []byte(tt.payload))
+			if string(got) != tt.want || changed != tt.wantChanged {
+				t.Errorf("Apply() = %s, %v, want %s, %v", got, changed, tt.want, tt.wantChanged)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Package privacy applies the metrics privacy mode. In aggregate mode every
+// usage metric is labeled with a hashed application bucket instead of the
+// application name, per-request identifiers are dropped, and aggregates built
+// from too few requests are folded together before they are reported.
+package privacy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/amscotti/portus/internal/usage"
+)
+
+// Mode selects how much detail usage metrics keep.
+type Mode string
+
+const (
+	// Standard labels metrics with application names.
+	Standard Mode = "standard"
+	// Aggregate labels metrics with application buckets and suppresses small
+	// aggregates.
+	Aggregate Mode = "aggregate"
+)
+
+// OtherLabel replaces the application and alias of folded aggregates.
+const OtherLabel = "other"
+
+// ParseMode parses a metrics mode; empty means Standard.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", Standard:
+		return Standard, nil
+	case Aggregate:
+		return Aggregate, nil
+	}
+	return "", fmt.Errorf("unknown metrics mode %q (must be standard or aggregate)", s)
+}
+
+// Policy is the metrics privacy policy. A nil Policy is Standard.
+type Policy struct {
+	buckets  int
+	minCount int64
+}
+
+// New creates a policy. In Aggregate mode applications are hashed into the
+// given number of buckets and aggregates with fewer than minCount requests are
+// folded together. Standard mode returns nil.
+func New(mode Mode, buckets, minCount int) *Policy {
+	if mode != Aggregate {
+		return nil
+	}
+	return &Policy{buckets: max(buckets, 1), minCount: int64(minCount)}
+}
+
+// Aggregate reports whether aggregate-only mode is active.
+func (p *Policy) Aggregate() bool {
+	return p != nil
+}
+
+// Label returns the label under which an application's usage is recorded.
+func (p *Policy) Label(application string) string {
+	if p == nil {
+		return application
+	}
+	h := fnv.New32a()
+	h.Write([]byte(application))
+	return fmt.Sprintf("bucket-%02d", h.Sum32()%uint32(p.buckets))
+}
+
+// Totals folds aggregates with fewer than the minimum requests into a single
+// "other" row. Rows are returned sorted as usage.Tracker.Snapshot sorts them.
+func (p *Policy) Totals(rows []usage.Totals) []usage.Totals {
+	if p == nil {
+		return rows
+	}
+	result := make([]usage.Totals, 0, len(rows))
+	other := usage.Totals{Application: OtherLabel, ModelAlias: OtherLabel}
+	for _, row := range rows {
+		if row.Requests >= p.minCount {
+			result = append(result, row)
+			continue
+		}
+		other.Requests += row.Requests
+		other.PromptTokens += row.PromptTokens
+		other.CompletionTokens += row.CompletionTokens
+		other.TotalTokens += row.TotalTokens
+		other.EstimatedCostUSD += row.EstimatedCostUSD
+		other.FallbackResponses += row.FallbackResponses
+		other.CacheHits += row.CacheHits
+		other.ClientCancellations += row.ClientCancellations
+		other.Timeouts += row.Timeouts
+		other.UpstreamErrors += row.UpstreamErrors
+	}
+	if other != (usage.Totals{Application: OtherLabel, ModelAlias: OtherLabel}) {
+		result = append(result, other)
+	}
+	return result
+}
+
+// History folds history buckets with fewer than the minimum requests into one
+// "other" bucket per time slot.
+func (p *Policy) History(series []usage.Bucket) []usage.Bucket {
+	if p == nil {
+		return series
+	}
+	result := make([]usage.Bucket, 0, len(series))
+	others := map[int64]*usage.Bucket{}
+	for _, b := range series {
+		if b.Requests >= p.minCount {
+			result = append(result, b)
+			continue
+		}
+		other, ok := others[b.Start.Unix()]
+		if !ok {
+			other = &usage.Bucket{Start: b.Start, Application: OtherLabel, ModelAlias: OtherLabel}
+			others[b.Start.Unix()] = other
+		}
+		other.Requests += b.Requests
+		other.PromptTokens += b.PromptTokens
+		other.CompletionTokens += b.CompletionTokens
+		other.TotalTokens += b.TotalTokens
+		other.EstimatedCostUSD += b.EstimatedCostUSD
+	}
+	for _, other := range others {
+		result = append(result, *other)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Start.Equal(result[j].Start) {
+			return result[i].Start.Before(result[j].Start)
+		}
+		if result[i].Application != result[j].Application {
+			return result[i].Application < result[j].Application
+		}
+		return result[i].ModelAlias < result[j].ModelAlias
+	})
+	return result
+}
@@ -0,0 +1,94 @@
+package privacy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/usage"
+)
+
+func TestParseMode(t *testing.T) {
+	t.Parallel()
+
+	for input, want := range map[string]Mode{"": Standard, "standard": Standard, "aggregate": Aggregate} {
+		if got, err := ParseMode(input); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseMode("anonymous"); err == nil {
+		t.Error("expected unknown mode to fail")
+	}
+}
+
+func TestPolicy_Label(t *testing.T) {
+	t.Parallel()
+
+	var standard *Policy
+	if standard.Label("billing") != "billing" || standard.Aggregate() {
+		t.Error("expected standard mode to keep application names")
+	}
+
+	p := New(Aggregate, 4, 5)
+	label := p.Label("billing")
+	if !strings.HasPrefix(label, "bucket-") || label == "billing" {
+		t.Errorf("expected bucket label, got %q", label)
+	}
+	if p.Label("billing") != label {
+		t.Error("expected bucket labels to be stable")
+	}
+	seen := map[string]bool{}
+	for _, app := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		seen[p.Label(app)] = true
+	}
+	if len(seen) > 4 {
+		t.Errorf("expected at most 4 buckets, got %d", len(seen))
+	}
+}
+
+func TestPolicy_Totals(t *testing.T) {
+	t.Parallel()
+
+	rows := []usage.Totals{
+		{Application: "bucket-00", ModelAlias: "gpt4", Requests: 40, TotalTokens: 4000},
+		{Application: "bucket-01", ModelAlias: "gpt4", Requests: 2, TotalTokens: 20},
+		{Application: "bucket-01", ModelAlias: "claude", Requests: 1, TotalTokens: 30, CacheHits: 1},
+	}
+
+	if got := (*Policy)(nil).Totals(rows); len(got) != 3 {
+		t.Errorf("expected standard mode to keep every row, got %d", len(got))
+	}
+
+	got := New(Aggregate, 8, 5).Totals(rows)
+	if len(got) != 2 {
+		t.Fatalf("expected small rows folded into one, got %+v", got)
+	}
+	other := got[1]
+	if other.Application != OtherLabel || other.Requests != 3 || other.TotalTokens != 50 || other.CacheHits != 1 {
+		t.Errorf("unexpected folded row: %+v", other)
+	}
+}
+
+func TestPolicy_History(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	series := []usage.Bucket{
+		{Start: t0, Application: "bucket-00", ModelAlias: "gpt4", Requests: 10},
+		{Start: t0, Application: "bucket-01", ModelAlias: "gpt4", Requests: 1},
+		{Start: t1, Application: "bucket-00", ModelAlias: "gpt4", Requests: 2},
+		{Start: t1, Application: "bucket-01", ModelAlias: "gpt4", Requests: 2},
+	}
+
+	got := New(Aggregate, 8, 5).History(series)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 buckets, got %+v", got)
+	}
+	if got[1].Application != OtherLabel || got[1].Requests != 1 || !got[1].Start.Equal(t0) {
+		t.Errorf("unexpected first folded bucket: %+v", got[1])
+	}
+	if got[2].Application != OtherLabel || got[2].Requests != 4 || !got[2].Start.Equal(t1) {
+		t.Errorf("unexpected second folded bucket: %+v", got[2])
+	}
+}
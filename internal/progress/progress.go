@@ -0,0 +1,392 @@
+// Package progress samples the throughput of in-flight streaming responses and
+// aggregates it per provider so slowdowns are visible while streams are running.
+// It also keeps a registry of every open stream, so leaked or hung streams can
+// be listed and force-closed once they exceed a maximum age.
+package progress
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sampleBuffer is the capacity of the samples channel. Samples are dropped
+// rather than blocking a stream when the consumer falls behind.
+const sampleBuffer = 256
+
+// Sample is a point-in-time measurement of a single stream.
+type Sample struct {
+	RequestID       string    `json:"request_id"`
+	Application     string    `json:"application"`
+	ModelAlias      string    `json:"model_alias"`
+	Provider        string    `json:"provider"`
+	Bytes           int64     `json:"bytes"`
+	Tokens          int64     `json:"tokens"`
+	ElapsedMs       int64     `json:"elapsed_ms"`
+	TokensPerSecond float64   `json:"tokens_per_second"`
+	BytesPerSecond  float64   `json:"bytes_per_second"`
+	Time            time.Time `json:"time"`
+	Final           bool      `json:"final"`
+}
+
+// ProviderThroughput is the live throughput of all active streams for a provider,
+// based on the most recent sample of each stream.
+type ProviderThroughput struct {
+	Provider        string  `json:"provider"`
+	ActiveStreams   int     `json:"active_streams"`
+	TokensPerSecond float64 `json:"tokens_per_second"`
+	BytesPerSecond  float64 `json:"bytes_per_second"`
+}
+
+// StreamInfo describes an open stream from the moment it is tracked until it is
+// closed, unlike samples which only appear after the first sampling interval.
+type StreamInfo struct {
+	RequestID   string    `json:"request_id"`
+	Application string    `json:"application"`
+	ModelAlias  string    `json:"model_alias"`
+	Provider    string    `json:"provider"`
+	StartedAt   time.Time `json:"started_at"`
+	AgeMs       int64     `json:"age_ms"`
+	Bytes       int64     `json:"bytes"`
+	// Reaped is set once the stream was force-closed for exceeding the
+	// maximum age but has not yet finished closing.
+	Reaped bool `json:"reaped,omitempty"`
+}
+
+// Monitor receives progress samples from streams on an internal channel and keeps
+// the latest sample of each active stream. A nil Monitor disables sampling.
+type Monitor struct {
+	interval time.Duration
+	samples  chan Sample
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	active  map[string]Sample
+	streams map[*Stream]struct{}
+	reaped  int64
+}
+
+// NewMonitor creates a monitor that samples streams at the given interval.
+func NewMonitor(interval time.Duration, logger *slog.Logger) *Monitor {
+	return &Monitor{
+		interval: interval,
+		samples:  make(chan Sample, sampleBuffer),
+		logger:   logger,
+		active:   make(map[string]Sample),
+		streams:  make(map[*Stream]struct{}),
+	}
+}
+
+// Run consumes samples until ctx is canceled.
+func (m *Monitor) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-m.samples:
+			m.record(s)
+		}
+	}
+}
+
+func (m *Monitor) record(s Sample) {
+	m.mu.Lock()
+	// Close removes the stream's entry itself; a periodic sample consumed
+	// after that must not bring it back
+	if !s.Final && m.open(s.RequestID) {
+		m.active[s.RequestID] = s
+	}
+	m.mu.Unlock()
+
+	m.logger.Debug("stream progress",
+		"request_id", s.RequestID,
+		"application", s.Application,
+		"model_alias", s.ModelAlias,
+		"provider", s.Provider,
+		"bytes", s.Bytes,
+		"tokens", s.Tokens,
+		"elapsed_ms", s.ElapsedMs,
+		"tokens_per_second", s.TokensPerSecond,
+		"bytes_per_second", s.BytesPerSecond,
+		"final", s.Final,
+	)
+}
+
+// open reports whether a stream for requestID is still tracked. The caller
+// must hold m.mu.
+func (m *Monitor) open(requestID string) bool {
+	for s := range m.streams {
+		if s.base.RequestID == requestID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Monitor) emit(s Sample) {
+	select {
+	case m.samples <- s:
+	default:
+		m.logger.Debug("dropping stream progress sample", "request_id", s.RequestID)
+	}
+}
+
+// ActiveStreams returns the latest sample of each active stream for the given
+// application, or for all applications if application is empty.
+func (m *Monitor) ActiveStreams(application string) []Sample {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	result := make([]Sample, 0, len(m.active))
+	for _, s := range m.active {
+		if application != "" && s.Application != application {
+			continue
+		}
+		result = append(result, s)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].RequestID < result[j].RequestID })
+	return result
+}
+
+// Providers returns the live throughput per provider across all active streams.
+func (m *Monitor) Providers() []ProviderThroughput {
+	if m == nil {
+		return nil
+	}
+	byProvider := make(map[string]*ProviderThroughput)
+	for _, s := range m.ActiveStreams("") {
+		pt, ok := byProvider[s.Provider]
+		if !ok {
+			pt = &ProviderThroughput{Provider: s.Provider}
+			byProvider[s.Provider] = pt
+		}
+		pt.ActiveStreams++
+		pt.TokensPerSecond += s.TokensPerSecond
+		pt.BytesPerSecond += s.BytesPerSecond
+	}
+
+	result := make([]ProviderThroughput, 0, len(byProvider))
+	for _, pt := range byProvider {
+		result = append(result, *pt)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Provider < result[j].Provider })
+	return result
+}
+
+// Streams lists the open streams of the given application, or of all
+// applications if application is empty, oldest first.
+func (m *Monitor) Streams(application string) []StreamInfo {
+	if m == nil {
+		return nil
+	}
+	now := time.Now()
+	m.mu.Lock()
+	result := make([]StreamInfo, 0, len(m.streams))
+	for s := range m.streams {
+		if application != "" && s.base.Application != application {
+			continue
+		}
+		result = append(result, s.info(now))
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(result, func(a, b StreamInfo) int { return a.StartedAt.Compare(b.StartedAt) })
+	return result
+}
+
+// Reaped returns how many streams have been force-closed for exceeding the
+// maximum age.
+func (m *Monitor) Reaped() int64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reaped
+}
+
+// Reap force-closes streams older than maxAge every interval until ctx is
+// canceled.
+func (m *Monitor) Reap(ctx context.Context, maxAge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.reap(maxAge)
+		}
+	}
+}
+
+// reap closes every stream older than maxAge that is not already being closed
+// and returns how many were closed. Closers run under the monitor's mutex so a
+// stream cannot finish, and its response be reused, while it is being closed.
+func (m *Monitor) reap(maxAge time.Duration) int {
+	now := time.Now()
+	var expired []StreamInfo
+	m.mu.Lock()
+	for s := range m.streams {
+		if s.reaped || now.Sub(s.start) <= maxAge {
+			continue
+		}
+		s.reaped = true
+		expired = append(expired, s.info(now))
+		if s.closer != nil {
+			s.closer()
+		}
+	}
+	m.reaped += int64(len(expired))
+	m.mu.Unlock()
+
+	for _, info := range expired {
+		m.logger.Warn("reaped stream exceeding maximum age",
+			"request_id", info.RequestID,
+			"application", info.Application,
+			"model_alias", info.ModelAlias,
+			"provider", info.Provider,
+			"started_at", info.StartedAt,
+			"age_ms", info.AgeMs,
+			"bytes", info.Bytes,
+			"max_age", maxAge.String(),
+		)
+	}
+	return len(expired)
+}
+
+// Stream counts the bytes and tokens relayed for one streaming response and
+// emits a sample every monitor interval until it is closed.
+type Stream struct {
+	monitor *Monitor
+	base    Sample
+	start   time.Time
+	closer  func()
+	bytes   atomic.Int64
+	tokens  atomic.Int64
+	pending []byte
+	done    chan struct{}
+	stopped chan struct{}
+	// reaped is guarded by the monitor's mutex.
+	reaped bool
+}
+
+// Track starts sampling a stream and registers it as open. closer, which may
+// be nil, force-closes the stream if it outlives the maximum age; it must not
+// block. Track
+// returns nil if the monitor is nil; all Stream methods are safe to call on a
+// nil Stream.
+func (m *Monitor) Track(requestID, application, modelAlias, provider string, closer func()) *Stream {
+	if m == nil {
+		return nil
+	}
+	s := &Stream{
+		monitor: m,
+		base: Sample{
+			RequestID:   requestID,
+			Application: application,
+			ModelAlias:  modelAlias,
+			Provider:    provider,
+		},
+		start:   time.Now(),
+		closer:  closer,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.streams[s] = struct{}{}
+	m.mu.Unlock()
+	go s.sampleLoop()
+	return s
+}
+
+// Write counts relayed bytes and SSE data events. Each data event carries one
+// content delta, which is used as the token estimate for live throughput.
+func (s *Stream) Write(b []byte) (int, error) {
+	if s == nil {
+		return len(b), nil
+	}
+	s.bytes.Add(int64(len(b)))
+	s.pending = append(s.pending, b...)
+	for {
+		idx := bytes.IndexByte(s.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		line := bytes.TrimSpace(s.pending[:idx])
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok && !bytes.Equal(bytes.TrimSpace(data), []byte("[DONE]")) {
+			s.tokens.Add(1)
+		}
+		s.pending = s.pending[idx+1:]
+	}
+	return len(b), nil
+}
+
+// Close stops sampling and emits a final sample.
+func (s *Stream) Close() {
+	if s == nil {
+		return
+	}
+	close(s.done)
+	// Wait for the sampler so no periodic sample can arrive after the final one
+	<-s.stopped
+	// The final sample is only logged, and may be dropped, so the active
+	// entry is removed here rather than when it is consumed
+	s.monitor.mu.Lock()
+	delete(s.monitor.streams, s)
+	delete(s.monitor.active, s.base.RequestID)
+	s.monitor.mu.Unlock()
+	final := s.sample()
+	final.Final = true
+	s.monitor.emit(final)
+}
+
+func (s *Stream) sampleLoop() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.monitor.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.monitor.emit(s.sample())
+		}
+	}
+}
+
+// info must be called with the monitor's mutex held.
+func (s *Stream) info(now time.Time) StreamInfo {
+	return StreamInfo{
+		RequestID:   s.base.RequestID,
+		Application: s.base.Application,
+		ModelAlias:  s.base.ModelAlias,
+		Provider:    s.base.Provider,
+		StartedAt:   s.start.UTC(),
+		AgeMs:       now.Sub(s.start).Milliseconds(),
+		Bytes:       s.bytes.Load(),
+		Reaped:      s.reaped,
+	}
+}
+
+func (s *Stream) sample() Sample {
+	sample := s.base
+	elapsed := time.Since(s.start)
+	sample.Bytes = s.bytes.Load()
+	sample.Tokens = s.tokens.Load()
+	sample.ElapsedMs = elapsed.Milliseconds()
+	sample.Time = time.Now()
+	if secs := elapsed.Seconds(); secs > 0 {
+		sample.TokensPerSecond = float64(sample.Tokens) / secs
+		sample.BytesPerSecond = float64(sample.Bytes) / secs
+	}
+	return sample
+}
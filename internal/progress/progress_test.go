@@ -0,0 +1,146 @@
+package progress
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func newTestMonitor() *Monitor {
+	return NewMonitor(10*time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestStream_CountsBytesAndTokens(t *testing.T) {
+	t.Parallel()
+
+	m := newTestMonitor()
+	s := m.Track("req-1", "backend", "gpt4", "openai", nil)
+	defer s.Close()
+
+	s.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\nda"))
+	s.Write([]byte("ta: {\"choices\":[{\"delta\":{\"content\":\"b\"}}]}\n\ndata: [DONE]\n\n"))
+
+	sample := s.sample()
+	if sample.Tokens != 2 {
+		t.Errorf("expected 2 tokens, got %d", sample.Tokens)
+	}
+	if sample.Bytes == 0 {
+		t.Error("expected bytes to be counted")
+	}
+	if sample.RequestID != "req-1" || sample.Provider != "openai" {
+		t.Errorf("unexpected sample labels: %+v", sample)
+	}
+}
+
+func TestMonitor_ActiveStreamsAndProviders(t *testing.T) {
+	t.Parallel()
+
+	m := newTestMonitor()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	a := m.Track("req-a", "backend", "gpt4", "openai", nil)
+	b := m.Track("req-b", "frontend", "claude", "anthropic", nil)
+	a.Write([]byte("data: {}\n"))
+	b.Write([]byte("data: {}\n"))
+
+	waitFor(t, func() bool { return len(m.ActiveStreams("")) == 2 })
+
+	if got := m.ActiveStreams("backend"); len(got) != 1 || got[0].RequestID != "req-a" {
+		t.Errorf("expected only backend stream, got %+v", got)
+	}
+	providers := m.Providers()
+	if len(providers) != 2 || providers[0].Provider != "anthropic" || providers[0].ActiveStreams != 1 {
+		t.Errorf("unexpected provider throughput: %+v", providers)
+	}
+
+	a.Close()
+	b.Close()
+	waitFor(t, func() bool { return len(m.ActiveStreams("")) == 0 })
+}
+
+func TestStream_CloseRemovesActiveEntry(t *testing.T) {
+	t.Parallel()
+
+	// Without a consumer the final sample is never recorded, and samples
+	// queued before Close arrive after it
+	m := newTestMonitor()
+	s := m.Track("req-a", "backend", "gpt4", "openai", nil)
+	m.record(s.sample())
+	s.Close()
+	if got := m.ActiveStreams(""); len(got) != 0 {
+		t.Errorf("expected no active streams after close, got %+v", got)
+	}
+	m.record(s.sample())
+	if got := m.ActiveStreams(""); len(got) != 0 {
+		t.Errorf("expected a late sample not to revive a closed stream, got %+v", got)
+	}
+}
+
+func TestMonitor_NilIsDisabled(t *testing.T) {
+	t.Parallel()
+
+	var m *Monitor
+	s := m.Track("req", "app", "alias", "provider", nil)
+	if s != nil {
+		t.Fatal("expected nil stream from nil monitor")
+	}
+	s.Write([]byte("data: {}\n"))
+	s.Close()
+	if m.ActiveStreams("") != nil || m.Providers() != nil || m.Streams("") != nil || m.Reaped() != 0 {
+		t.Error("expected no data from nil monitor")
+	}
+}
+
+func TestMonitor_StreamsAndReap(t *testing.T) {
+	t.Parallel()
+
+	m := newTestMonitor()
+	closed := 0
+	old := m.Track("req-old", "backend", "gpt4", "openai", func() { closed++ })
+	old.Write([]byte("data: {}\n"))
+	time.Sleep(20 * time.Millisecond)
+	young := m.Track("req-young", "frontend", "claude", "anthropic", nil)
+	defer young.Close()
+
+	// Streams are listed from the moment they are tracked, oldest first
+	streams := m.Streams("")
+	if len(streams) != 2 || streams[0].RequestID != "req-old" || streams[0].Bytes != 9 || streams[0].AgeMs < 20 {
+		t.Fatalf("unexpected open streams: %+v", streams)
+	}
+	if got := m.Streams("frontend"); len(got) != 1 || got[0].RequestID != "req-young" {
+		t.Errorf("expected only the frontend stream, got %+v", got)
+	}
+
+	if n := m.reap(10 * time.Millisecond); n != 1 || closed != 1 {
+		t.Fatalf("expected the old stream to be reaped once, reaped %d and closed %d", n, closed)
+	}
+	if n := m.reap(10 * time.Millisecond); n != 0 || closed != 1 {
+		t.Errorf("a stream being closed must not be reaped again, reaped %d and closed %d", n, closed)
+	}
+	if got := m.Streams("backend"); len(got) != 1 || !got[0].Reaped {
+		t.Errorf("expected the reaped stream to be marked until it closes, got %+v", got)
+	}
+
+	old.Close()
+	if got := m.Streams(""); len(got) != 1 || got[0].RequestID != "req-young" {
+		t.Errorf("expected closed streams to be removed, got %+v", got)
+	}
+	if m.Reaped() != 1 {
+		t.Errorf("expected 1 reaped stream, got %d", m.Reaped())
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,43 @@
+package provenance
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	t.Parallel()
+
+	signer := NewSigner([]byte("secret"), func() string { return "v42" })
+	now := time.Unix(1700000000, 0)
+	value := signer.Sign("req-1", "gpt; sig=x", now)
+
+	rec, err := Verify([]byte("secret"), value)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	want := Record{RequestID: "req-1", Alias: "gpt; sig=x", Time: now, Policy: "v42"}
+	if rec != want {
+		t.Errorf("Verify() = %+v, want %+v", rec, want)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{name: "wrong key", key: "other", value: value},
+		{name: "tampered alias", key: "secret", value: strings.Replace(value, "alias=gpt", "alias=gpu", 1)},
+		{name: "unsigned", key: "secret", value: "v=1; request_id=req-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := Verify([]byte(tt.key), tt.value); err == nil {
+				t.Error("expected verification to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+// Package quota counts requests per key against a quota over calendar
+// windows (an hour, a UTC day or a UTC month). Counts live in a storage
+// backend, so they persist across restarts or are shared by every replica
+// when the backend does.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/storage"
+)
+
+// Quota windows.
+const (
+	Hour  = "hour"
+	Day   = "day"
+	Month = "month"
+)
+
+// Parse reads a quota written as "limit/window", e.g. "10000/day". An empty
+// value means no quota.
+func Parse(value string) (models.RequestQuota, error) {
+	if value == "" {
+		return models.RequestQuota{}, nil
+	}
+	limit, window, ok := strings.Cut(value, "/")
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if !ok || err != nil || n <= 0 {
+		return models.RequestQuota{}, fmt.Errorf("quota %q must be a positive count and window, e.g. 10000/day", value)
+	}
+	switch window = strings.TrimSpace(window); window {
+	case Hour, Day, Month:
+	default:
+		return models.RequestQuota{}, fmt.Errorf("quota %q has unknown window %q (must be %s, %s or %s)", value, window, Hour, Day, Month)
+	}
+	return models.RequestQuota{Limit: n, Window: window}, nil
+}
+
+// Usage is a key's use of its quota in the current window.
+type Usage struct {
+	Limit     int
+	Window    string
+	Used      int
+	Remaining int
+	ResetsAt  time.Time
+}
+
+// Counter tracks request counts per key in a storage backend. It is safe for
+// concurrent use; a nil Counter never limits.
+type Counter struct {
+	backend storage.Backend
+	logger  *slog.Logger
+}
+
+// New creates a counter holding counts in memory, so they start from zero
+// when the process restarts.
+func New(logger *slog.Logger) *Counter {
+	return NewWithBackend(storage.NewMemory(), logger)
+}
+
+// NewWithBackend creates a counter holding counts in backend. With a shared
+// backend, every replica counts against the same quota. If the backend is
+// unavailable, requests are allowed rather than failing.
+func NewWithBackend(backend storage.Backend, logger *slog.Logger) *Counter {
+	return &Counter{backend: backend, logger: logger}
+}
+
+// Take counts one request for key at now if the quota allows it, returning
+// the resulting usage and whether the request may proceed. A zero quota is
+// unlimited.
+func (c *Counter) Take(key string, q models.RequestQuota, now time.Time) (Usage, bool) {
+	if c == nil || q.Limit <= 0 {
+		return Usage{}, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	// Counting first and giving the request back when it went over keeps
+	// concurrent takers from all passing a check made before any counted
+	start, resets := bounds(q.Window, now)
+	k := countKey(key, q.Window, start)
+	used, err := c.backend.Add(ctx, k, 1, resets.Sub(now))
+	if err != nil {
+		c.logger.Warn("request quota unavailable, allowing request", "key", key, "error", err)
+		return Usage{}, true
+	}
+	if int(used) > q.Limit {
+		if _, err := c.backend.Add(ctx, k, -1, resets.Sub(now)); err != nil {
+			c.logger.Warn("failed to release refused request quota", "key", key, "error", err)
+		}
+		return usage(q, q.Limit, resets), false
+	}
+	return usage(q, int(used), resets), true
+}
+
+// Refund gives back a request Take counted for key at now, for requests
+// turned away before they were served. Refunds after the window ended do
+// nothing.
+func (c *Counter) Refund(key string, q models.RequestQuota, now time.Time) {
+	if c == nil || q.Limit <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	start, resets := bounds(q.Window, now)
+	k := countKey(key, q.Window, start)
+	used, err := c.backend.Add(ctx, k, -1, resets.Sub(now))
+	if err == nil && used < 0 {
+		// Nothing was counted in this window; undo rather than go below zero
+		_, err = c.backend.Add(ctx, k, 1, resets.Sub(now))
+	}
+	if err != nil {
+		c.logger.Warn("failed to refund request quota", "key", key, "error", err)
+	}
+}
+
+// Status returns key's usage at now without counting a request.
+func (c *Counter) Status(key string, q models.RequestQuota, now time.Time) Usage {
+	start, resets := bounds(q.Window, now)
+	if c == nil || q.Limit <= 0 {
+		return usage(q, 0, resets)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	value, _, err := c.backend.Get(ctx, countKey(key, q.Window, start))
+	if err != nil {
+		c.logger.Warn("request quota unavailable", "key", key, "error", err)
+	}
+	return usage(q, min(int(storage.Number(value)), q.Limit), resets)
+}
+
+// countKey names key's counter for the window starting at start. Counters
+// expire when their window ends.
+func countKey(key, window string, start time.Time) string {
+	return storage.Key("quota", key, window, strconv.FormatInt(start.Unix(), 10))
+}
+
+func usage(q models.RequestQuota, used int, resets time.Time) Usage {
+	return Usage{Limit: q.Limit, Window: q.Window, Used: used, Remaining: max(q.Limit-used, 0), ResetsAt: resets}
+}
+
+// bounds returns the start of the window containing now and when it ends.
+func bounds(window string, now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	switch window {
+	case Hour:
+		start := now.Truncate(time.Hour)
+		return start, start.Add(time.Hour)
+	case Month:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
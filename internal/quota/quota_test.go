@@ -0,0 +1,163 @@
+package quota
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/storage"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value   string
+		want    models.RequestQuota
+		wantErr bool
+	}{
+		{value: ""},
+		{value: "10000/day", want: models.RequestQuota{Limit: 10000, Window: Day}},
+		{value: "50 / hour", want: models.RequestQuota{Limit: 50, Window: Hour}},
+		{value: "1/month", want: models.RequestQuota{Limit: 1, Window: Month}},
+		{value: "10000", wantErr: true},
+		{value: "0/day", wantErr: true},
+		{value: "10/week", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCounter_Take(t *testing.T) {
+	t.Parallel()
+
+	c := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	q := models.RequestQuota{Limit: 2, Window: Day}
+	now := time.Date(2024, 5, 1, 15, 0, 0, 0, time.UTC)
+
+	for i := 1; i <= 2; i++ {
+		if u, ok := c.Take("app", q, now); !ok || u.Used != i || u.Remaining != 2-i {
+			t.Fatalf("request %d: got %+v ok=%v", i, u, ok)
+		}
+	}
+	u, ok := c.Take("app", q, now)
+	if ok || u.Remaining != 0 || !u.ResetsAt.Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the third request refused until midnight, got %+v ok=%v", u, ok)
+	}
+	if _, ok := c.Take("other", q, now); !ok {
+		t.Error("expected other keys to be unaffected")
+	}
+
+	if u := c.Status("app", q, now); u.Used != 2 {
+		t.Errorf("expected refused requests not to be counted, got %+v", u)
+	}
+
+	// The next window starts from zero
+	if u, ok := c.Take("app", q, now.Add(9*time.Hour)); !ok || u.Used != 1 {
+		t.Errorf("expected a fresh window the next day, got %+v ok=%v", u, ok)
+	}
+}
+
+func TestCounter_Refund(t *testing.T) {
+	t.Parallel()
+
+	c := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	q := models.RequestQuota{Limit: 1, Window: Hour}
+	now := time.Date(2024, 5, 1, 15, 30, 0, 0, time.UTC)
+
+	c.Take("app", q, now)
+	c.Refund("app", q, now)
+	if u, ok := c.Take("app", q, now); !ok || u.Used != 1 {
+		t.Fatalf("expected the refunded request to be available again, got %+v ok=%v", u, ok)
+	}
+
+	// A refund never reaches into the next window or below zero
+	next := now.Add(time.Hour)
+	c.Refund("app", q, next)
+	c.Take("app", q, next)
+	c.Refund("app", q, now)
+	c.Refund("other", q, now)
+	if u := c.Status("app", q, next); u.Used != 1 {
+		t.Errorf("expected 1 request used in the next window, got %+v", u)
+	}
+	if u := c.Status("other", q, now); u.Used != 0 {
+		t.Errorf("expected no requests used, got %+v", u)
+	}
+}
+
+func TestCounter_Unlimited(t *testing.T) {
+	t.Parallel()
+
+	var c *Counter
+	if _, ok := c.Take("app", models.RequestQuota{Limit: 1, Window: Day}, time.Now()); !ok {
+		t.Error("expected a nil counter to allow requests")
+	}
+	c = New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for i := 0; i < 10; i++ {
+		if _, ok := c.Take("app", models.RequestQuota{}, time.Now()); !ok {
+			t.Fatal("expected a zero quota to be unlimited")
+		}
+	}
+}
+
+func TestCounter_SharedBackend(t *testing.T) {
+	t.Parallel()
+
+	// Two replicas sharing a backend count against the same quota
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	backend := storage.NewMemory()
+	a, b := NewWithBackend(backend, logger), NewWithBackend(backend, logger)
+	q := models.RequestQuota{Limit: 1, Window: Hour}
+	now := time.Now()
+
+	if _, ok := a.Take("app", q, now); !ok {
+		t.Fatal("expected the first request allowed")
+	}
+	if u, ok := b.Take("app", q, now); ok || u.Used != 1 {
+		t.Fatalf("expected the quota shared across replicas, got %+v ok=%v", u, ok)
+	}
+	if u := b.Status("app", q, now); u.Used != 1 || u.Remaining != 0 {
+		t.Errorf("unexpected status %+v", u)
+	}
+	a.Refund("app", q, now)
+	if _, ok := b.Take("app", q, now); !ok {
+		t.Error("expected a refund on one replica to free the quota on another")
+	}
+}
+
+func TestCounter_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	c := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	q := models.RequestQuota{Limit: 10, Window: Day}
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := c.Take("app", q, now); ok {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != q.Limit {
+		t.Errorf("expected exactly %d requests granted, got %d", q.Limit, granted)
+	}
+	if u := c.Status("app", q, now); u.Used != q.Limit {
+		t.Errorf("expected refused requests given back, got %+v", u)
+	}
+}
@@ -0,0 +1,341 @@
+// Package recording captures sanitized request/response pairs to a JSON Lines
+// file and replays them against a Portus instance, for regression testing
+// config changes and comparing providers.
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/redact"
+)
+
+// maxBodySize caps each recorded request and response body; longer bodies
+// are truncated and marked as such.
+const maxBodySize = 1 << 20
+
+// Only these headers are recorded, so credentials never are.
+var (
+	requestHeaders = []string{
+		"Content-Type",
+		"Accept",
+		"Anthropic-Version",
+		"Anthropic-Beta",
+		"X-Portkey-Request-Timeout",
+		"X-Portus-Sticky-Key",
+	}
+	responseHeaders = []string{
+		"Content-Type",
+		"X-Portus-Cache",
+		"X-Portus-Experiment",
+		"X-Portus-Fallback",
+	}
+)
+
+// Exchange is one recorded request and its response.
+type Exchange struct {
+	Time           time.Time         `json:"time"`
+	RequestID      string            `json:"request_id,omitempty"`
+	Application    string            `json:"application,omitempty"`
+	Method         string            `json:"method"`
+	Path           string            `json:"path"`
+	RequestHeaders map[string]string `json:"request_headers,omitempty"`
+	RequestBody    string            `json:"request_body"`
+	// RequestTruncated marks a request body cut at the size limit; such
+	// exchanges cannot be replayed.
+	RequestTruncated bool              `json:"request_truncated,omitempty"`
+	Status           int               `json:"status"`
+	ResponseHeaders  map[string]string `json:"response_headers,omitempty"`
+	ResponseBody     string            `json:"response_body"`
+	// ResponseTruncated marks a response body cut at the size limit.
+	ResponseTruncated bool  `json:"response_truncated,omitempty"`
+	DurationMS        int64 `json:"duration_ms"`
+}
+
+// Recorder appends exchanges to a file. It is safe for concurrent use.
+type Recorder struct {
+	redactor *redact.Redactor
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+// Open appends recordings to the file at path, creating it if needed.
+// Bodies are scrubbed of credentials with redactor.
+func Open(path string, redactor *redact.Redactor, logger *slog.Logger) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open recording file: %w", err)
+	}
+	return &Recorder{redactor: redactor, logger: logger, file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// Record appends one exchange.
+func (r *Recorder) Record(ex Exchange) error {
+	line, err := json.Marshal(ex)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Write(line)
+	r.w.WriteByte('\n')
+	return r.w.Flush()
+}
+
+// Close flushes and closes the file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// Middleware records every JSON request passing through it. Place it after
+// authentication and request ID assignment so both are recorded.
+func (r *Recorder) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// Multipart uploads such as audio are not recorded
+			if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/json" {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			start := time.Now()
+			requestBody := &cappedBuffer{limit: maxBodySize}
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(req.Body, requestBody), req.Body}
+			capture := &responseCapture{ResponseWriter: w, body: cappedBuffer{limit: maxBodySize}}
+
+			next.ServeHTTP(capture, req)
+
+			application, _ := req.Context().Value(middleware.ContextKeyApplication).(string)
+			requestID, _ := req.Context().Value(middleware.ContextKeyRequestID).(string)
+			if capture.status == 0 {
+				capture.status = http.StatusOK
+			}
+			err := r.Record(Exchange{
+				Time:              start.UTC(),
+				RequestID:         requestID,
+				Application:       application,
+				Method:            req.Method,
+				Path:              req.URL.RequestURI(),
+				RequestHeaders:    pickHeaders(req.Header, requestHeaders),
+				RequestBody:       r.redactor.String(requestBody.buf.String()),
+				RequestTruncated:  requestBody.truncated,
+				Status:            capture.status,
+				ResponseHeaders:   pickHeaders(w.Header(), responseHeaders),
+				ResponseBody:      r.redactor.String(capture.body.buf.String()),
+				ResponseTruncated: capture.body.truncated,
+				DurationMS:        time.Since(start).Milliseconds(),
+			})
+			if err != nil {
+				r.logger.Warn("failed to record exchange", "request_id", requestID, "error", err)
+			}
+		})
+	}
+}
+
+// pickHeaders returns the named subset of header.
+func pickHeaders(header http.Header, names []string) map[string]string {
+	picked := make(map[string]string)
+	for _, name := range names {
+		if value := header.Get(name); value != "" {
+			picked[name] = value
+		}
+	}
+	return picked
+}
+
+// cappedBuffer keeps the first limit bytes written to it.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if room := c.limit - c.buf.Len(); room < len(p) {
+		c.buf.Write(p[:max(room, 0)])
+		c.truncated = true
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+// responseCapture copies the response body while relaying it.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   cappedBuffer
+}
+
+func (c *responseCapture) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher by delegating to the underlying writer.
+func (c *responseCapture) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, allowing the http package
+// to find additional interface implementations.
+func (c *responseCapture) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// Read parses a recording file's exchanges.
+func Read(r io.Reader) ([]Exchange, error) {
+	var exchanges []Exchange
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*maxBodySize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var ex Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &ex); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges, scanner.Err()
+}
+
+// AccessEntry is one proxied request in a Portus log.
+type AccessEntry struct {
+	// Start is when the request began: the log time less its duration.
+	Start     time.Time
+	RequestID string
+}
+
+// ReadAccessLog parses the "proxy request completed" lines of a Portus JSON
+// log, in order of request start. Other lines, including non-JSON ones, are
+// skipped.
+func ReadAccessLog(r io.Reader) ([]AccessEntry, error) {
+	var entries []AccessEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxBodySize)
+	for scanner.Scan() {
+		var line struct {
+			Time       time.Time `json:"time"`
+			Msg        string    `json:"msg"`
+			RequestID  string    `json:"request_id"`
+			DurationMS int64     `json:"duration_ms"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &line) != nil || line.Msg != "proxy request completed" || line.RequestID == "" {
+			continue
+		}
+		entries = append(entries, AccessEntry{
+			Start:     line.Time.Add(-time.Duration(line.DurationMS) * time.Millisecond),
+			RequestID: line.RequestID,
+		})
+	}
+	slices.SortStableFunc(entries, func(a, b AccessEntry) int { return a.Start.Compare(b.Start) })
+	return entries, scanner.Err()
+}
+
+// Replay re-sends a recorded request to the Portus instance at target,
+// authenticating with key, and returns the new exchange.
+func Replay(ctx context.Context, client *http.Client, target, key string, ex Exchange) (Exchange, error) {
+	if ex.RequestTruncated {
+		return Exchange{}, fmt.Errorf("request body was truncated when recorded")
+	}
+	req, err := http.NewRequestWithContext(ctx, ex.Method, strings.TrimRight(target, "/")+ex.Path, strings.NewReader(ex.RequestBody))
+	if err != nil {
+		return Exchange{}, err
+	}
+	for name, value := range ex.RequestHeaders {
+		req.Header.Set(name, value)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return Exchange{}, err
+	}
+	defer resp.Body.Close()
+	body := &cappedBuffer{limit: maxBodySize}
+	if _, err := io.Copy(body, resp.Body); err != nil {
+		return Exchange{}, err
+	}
+
+	return Exchange{
+		Time:              start.UTC(),
+		RequestID:         resp.Header.Get("X-Request-ID"),
+		Method:            ex.Method,
+		Path:              ex.Path,
+		RequestHeaders:    ex.RequestHeaders,
+		RequestBody:       ex.RequestBody,
+		Status:            resp.StatusCode,
+		ResponseHeaders:   pickHeaders(resp.Header, responseHeaders),
+		ResponseBody:      body.buf.String(),
+		ResponseTruncated: body.truncated,
+		DurationMS:        time.Since(start).Milliseconds(),
+	}, nil
+}
+
+// Model returns the model named in the response, from a JSON body or the
+// first event of a stream that names one; empty when there is none.
+func (ex Exchange) Model() string {
+	var body struct {
+		Model   string `json:"model"`
+		Message struct {
+			Model string `json:"model"`
+		} `json:"message"`
+	}
+	if json.Unmarshal([]byte(ex.ResponseBody), &body) == nil {
+		return body.Model
+	}
+	for line := range strings.Lines(ex.ResponseBody) {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if !ok || json.Unmarshal([]byte(strings.TrimSpace(data)), &body) != nil {
+			continue
+		}
+		if body.Model != "" {
+			return body.Model
+		}
+		if body.Message.Model != "" {
+			return body.Message.Model
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package recording
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/redact"
+)
+
+func TestRecorder_Middleware(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	redactor := redact.NewRedactor()
+	redactor.AddSecrets("provider-secret")
+	recorder, err := Open(path, redactor, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := recorder.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Portus-Cache", "hit")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"model":"gpt-4o","echo":` + string(body) + `}`))
+	}))
+
+	for _, contentType := range []string{"application/json; charset=utf-8", "multipart/form-data; boundary=x"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions?debug=1", strings.NewReader(`{"model":"gpt4","note":"provider-secret"}`))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer pk-client")
+		req.Header.Set("Anthropic-Version", "2023-06-01")
+		ctx := context.WithValue(req.Context(), middleware.ContextKeyApplication, "BACKEND")
+		ctx = context.WithValue(ctx, middleware.ContextKeyRequestID, "req-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "pk-client") || strings.Contains(string(data), "provider-secret") {
+		t.Errorf("expected credentials to be left out of the recording:\n%s", data)
+	}
+
+	exchanges, err := Read(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != 1 {
+		t.Fatalf("expected only the JSON request to be recorded, got %d", len(exchanges))
+	}
+	ex := exchanges[0]
+	if ex.Application != "BACKEND" || ex.RequestID != "req-1" || ex.Path != "/v1/chat/completions?debug=1" || ex.Status != http.StatusCreated {
+		t.Errorf("unexpected exchange: %+v", ex)
+	}
+	if ex.RequestHeaders["Anthropic-Version"] != "2023-06-01" || ex.RequestHeaders["Authorization"] != "" {
+		t.Errorf("unexpected request headers: %v", ex.RequestHeaders)
+	}
+	if ex.ResponseHeaders["X-Portus-Cache"] != "hit" || ex.Model() != "gpt-4o" {
+		t.Errorf("unexpected response: %v %s", ex.ResponseHeaders, ex.ResponseBody)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Bearer pk-replay" || r.Header.Get("Anthropic-Version") != "2023-06-01" || string(body) != `{"model":"claude"}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Request-ID", "req-2")
+		w.Write([]byte("event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"claude-sonnet-4\"}}\n\n"))
+	}))
+	t.Cleanup(target.Close)
+
+	recorded := Exchange{
+		Method:         http.MethodPost,
+		Path:           "/v1/messages",
+		RequestHeaders: map[string]string{"Anthropic-Version": "2023-06-01"},
+		RequestBody:    `{"model":"claude"}`,
+		Status:         http.StatusOK,
+	}
+	replayed, err := Replay(context.Background(), target.Client(), target.URL+"/", "pk-replay", recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Status != http.StatusOK || replayed.RequestID != "req-2" || replayed.Model() != "claude-sonnet-4" {
+		t.Errorf("unexpected replayed exchange: %+v", replayed)
+	}
+
+	recorded.RequestTruncated = true
+	if _, err := Replay(context.Background(), target.Client(), target.URL, "pk-replay", recorded); err == nil {
+		t.Error("expected truncated request to be refused")
+	}
+}
+
+func TestRead_InvalidLine(t *testing.T) {
+	t.Parallel()
+
+	_, err := Read(strings.NewReader("{\"method\":\"POST\"}\n\nnot json\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected error naming line 3, got %v", err)
+	}
+}
+
+func TestReadAccessLog(t *testing.T) {
+	t.Parallel()
+
+	log := `portus starting
+{"time":"2026-01-02T10:00:05Z","level":"INFO","msg":"proxy request completed","request_id":"b","duration_ms":4000}
+{"time":"2026-01-02T10:00:03Z","level":"INFO","msg":"proxy request started","request_id":"c"}
+{"time":"2026-01-02T10:00:03Z","level":"INFO","msg":"proxy request completed","request_id":"a","duration_ms":3000}
+{"time":"2026-01-02T10:00:04Z","level":"INFO","msg":"proxy request completed","duration_ms":10}
+`
+	entries, err := ReadAccessLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("ReadAccessLog() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].RequestID != "a" || entries[1].RequestID != "b" {
+		t.Fatalf("entries = %+v, want a then b", entries)
+	}
+	if got := entries[1].Start.Sub(entries[0].Start); got != time.Second {
+		t.Errorf("start offset = %v, want 1s", got)
+	}
+}
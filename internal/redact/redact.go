@@ -0,0 +1,223 @@
+// Package redact provides a slog.Handler that scrubs secrets from all log output.
+package redact
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Placeholder replaces redacted values.
+const Placeholder = "[REDACTED]"
+
+// defaultKeys are attribute keys whose values are always redacted, compared
+// case-insensitively with '-' treated as '_'.
+var defaultKeys = []string{
+	"api_key",
+	"authorization",
+	"x_api_key",
+	"password",
+	"secret",
+	"token",
+	"aws_secret_access_key",
+	"aws_session_token",
+	"vertex_service_account_json",
+	"x_portkey_config",
+	"upstream_headers",
+	"custom_headers",
+}
+
+// defaultPatterns match well-known credential formats anywhere in a value.
+var defaultPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_\-]{16,}`),                // OpenAI / Anthropic keys
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),         // AWS access key IDs
+	regexp.MustCompile(`AIza[0-9A-Za-z_\-]{35}`),                // Google API keys
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/\-]+=*`),  // Bearer tokens
+	regexp.MustCompile(`"private_key"\s*:\s*"(?:[^"\\]|\\.)*"`), // Service account private keys
+}
+
+// Redactor decides which attribute keys and value patterns are sensitive. It is
+// safe for concurrent use and may be extended after the logger is created.
+type Redactor struct {
+	mu       sync.RWMutex
+	keys     map[string]struct{}
+	patterns []*regexp.Regexp
+	secrets  []string
+}
+
+// NewRedactor creates a redactor with the built-in keys and patterns plus any
+// additional attribute keys.
+func NewRedactor(extraKeys ...string) *Redactor {
+	r := &Redactor{
+		keys:     make(map[string]struct{}),
+		patterns: append([]*regexp.Regexp(nil), defaultPatterns...),
+	}
+	for _, k := range defaultKeys {
+		r.keys[normalizeKey(k)] = struct{}{}
+	}
+	for _, k := range extraKeys {
+		if k = strings.TrimSpace(k); k != "" {
+			r.keys[normalizeKey(k)] = struct{}{}
+		}
+	}
+	return r
+}
+
+// AddPattern registers an additional value pattern to redact.
+func (r *Redactor) AddPattern(re *regexp.Regexp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.patterns = append(r.patterns, re)
+}
+
+// AddSecrets registers exact values (such as loaded proxy keys) to redact
+// wherever they appear.
+func (r *Redactor) AddSecrets(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range secrets {
+		// Very short values would redact ordinary words
+		if len(s) >= 8 {
+			r.secrets = append(r.secrets, s)
+		}
+	}
+}
+
+// IsSensitiveKey reports whether values logged under key are always redacted.
+func (r *Redactor) IsSensitiveKey(key string) bool {
+	_, ok := r.keys[normalizeKey(key)]
+	return ok
+}
+
+// String redacts known secrets and credential patterns from s.
+func (r *Redactor) String(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Placeholder)
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, Placeholder)
+	}
+	return s
+}
+
+// Attr returns a redacted copy of a, recursing into groups. Maps and slices
+// keep their structure with their elements redacted; errors, panic values and
+// other values are rendered to strings before scanning so secrets inside
+// error chains are caught.
+func (r *Redactor) Attr(a slog.Attr) slog.Attr {
+	if r.IsSensitiveKey(a.Key) {
+		return slog.String(a.Key, Placeholder)
+	}
+
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.String(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]any, len(group))
+		for i, ga := range group {
+			redacted[i] = r.Attr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		return slog.Any(a.Key, r.value(reflect.ValueOf(v.Any())))
+	default:
+		return slog.Attr{Key: a.Key, Value: v}
+	}
+}
+
+// value returns a redacted copy of v. Maps with string keys become
+// map[string]any, with sensitive keys redacted, and slices become []any.
+func (r *Redactor) value(v reflect.Value) any {
+	if !v.IsValid() || ((v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil()) {
+		return nil
+	}
+	if err, ok := v.Interface().(error); ok {
+		return r.String(err.Error())
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return r.value(v.Elem())
+	case reflect.String:
+		return r.String(v.String())
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return v.Interface()
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		m := make(map[string]any, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			key := iter.Key().String()
+			if r.IsSensitiveKey(key) {
+				m[key] = Placeholder
+			} else {
+				m[key] = r.value(iter.Value())
+			}
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		items := make([]any, v.Len())
+		for i := range items {
+			items[i] = r.value(v.Index(i))
+		}
+		return items
+	}
+	return r.String(fmt.Sprint(v.Interface()))
+}
+
+func normalizeKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "-", "_")
+}
+
+// Handler wraps another slog.Handler and redacts every record before it is
+// written.
+type Handler struct {
+	inner    slog.Handler
+	redactor *Redactor
+}
+
+// NewHandler wraps inner with redaction.
+func NewHandler(inner slog.Handler, redactor *Redactor) *Handler {
+	return &Handler{inner: inner, redactor: redactor}
+}
+
+// Enabled implements slog.Handler.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.redactor.String(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.redactor.Attr(a))
+		return true
+	})
+	return h.inner.Handle(ctx, redacted)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactor.Attr(a)
+	}
+	return &Handler{inner: h.inner.WithAttrs(redacted), redactor: h.redactor}
+}
+
+// WithGroup implements slog.Handler.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{inner: h.inner.WithGroup(name), redactor: h.redactor}
+}
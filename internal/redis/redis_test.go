@@ -0,0 +1,117 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/amscotti/portus/internal/redis"
+	"github.com/amscotti/portus/internal/redis/redistest"
+)
+
+func TestNewClient_URLs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"redis://localhost", false},
+		{"redis://:secret@redis.internal:6380/2", false},
+		{"http://localhost:6379", true},
+		{"redis://localhost/notanumber", true},
+		{"redis://", true},
+	}
+	for _, tt := range tests {
+		if _, err := redis.NewClient(tt.url); (err != nil) != tt.wantErr {
+			t.Errorf("NewClient(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestClient_Do(t *testing.T) {
+	t.Parallel()
+
+	server := redistest.NewServer()
+	defer server.Close()
+
+	var mu sync.Mutex
+	counters := map[string]int64{}
+	server.Handle("AUTH", func(args []string) any {
+		if args[0] != "secret" {
+			return redis.Error("WRONGPASS invalid password")
+		}
+		return "OK"
+	})
+	server.Handle("SELECT", func([]string) any { return "OK" })
+	server.Handle("INCR", func(args []string) any {
+		mu.Lock()
+		defer mu.Unlock()
+		counters[args[0]]++
+		return counters[args[0]]
+	})
+	server.Handle("MGET", func(args []string) any {
+		mu.Lock()
+		defer mu.Unlock()
+		values := make([]any, len(args))
+		for i, key := range args {
+			if n, ok := counters[key]; ok {
+				values[i] = strconv.FormatInt(n, 10)
+			}
+		}
+		return values
+	})
+
+	client, err := redis.NewClient("redis://:secret@" + server.Addr + "/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		reply, err := client.Do(ctx, "INCR", "hits")
+		if err != nil || reply != int64(i) {
+			t.Fatalf("INCR = %v, %v; want %d", reply, err, i)
+		}
+	}
+
+	reply, err := client.Do(ctx, "MGET", "hits", "missing")
+	if err != nil {
+		t.Fatalf("MGET error: %v", err)
+	}
+	if values := reply.([]any); values[0] != "3" || values[1] != nil {
+		t.Errorf("unexpected MGET reply: %#v", values)
+	}
+
+	// Server errors are returned as redis.Error and keep the connection usable
+	_, err = client.Do(ctx, "FLUSHALL")
+	var serverErr redis.Error
+	if !errors.As(err, &serverErr) {
+		t.Errorf("expected redis.Error, got %v", err)
+	}
+	if reply, err := client.Do(ctx, "PING"); err != nil || reply != "PONG" {
+		t.Errorf("PING = %v, %v", reply, err)
+	}
+
+	// One connection handled every command, authenticating once
+	if auths := slices.Index(server.Commands(), "AUTH"); auths != 0 {
+		t.Errorf("expected AUTH first, got commands %v", server.Commands())
+	}
+}
+
+func TestClient_WrongPassword(t *testing.T) {
+	t.Parallel()
+
+	server := redistest.NewServer()
+	defer server.Close()
+	server.Handle("AUTH", func([]string) any { return redis.Error("WRONGPASS invalid password") })
+
+	client, _ := redis.NewClient("redis://:nope@" + server.Addr)
+	if _, err := client.Do(context.Background(), "PING"); err == nil {
+		t.Error("expected authentication failure")
+	}
+}
@@ -0,0 +1,144 @@
+// Package redistest provides a scriptable in-process RESP server for tests.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/amscotti/portus/internal/redis"
+)
+
+// HandlerFunc answers a command. args excludes the command name. It may
+// return string, int64, int, nil, []any or redis.Error.
+type HandlerFunc func(args []string) any
+
+// Server is a fake Redis server. Unhandled commands receive an error reply.
+type Server struct {
+	// Addr is the listening address, usable as redis://<Addr>.
+	Addr string
+
+	listener net.Listener
+	mu       sync.Mutex
+	handlers map[string]HandlerFunc
+	commands []string
+}
+
+// NewServer starts a server on a random local port.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("redistest: failed to listen: %v", err))
+	}
+	s := &Server{Addr: l.Addr().String(), listener: l, handlers: make(map[string]HandlerFunc)}
+	s.Handle("PING", func([]string) any { return "PONG" })
+	go s.serve()
+	return s
+}
+
+// URL returns the redis:// URL of the server.
+func (s *Server) URL() string { return "redis://" + s.Addr }
+
+// Handle registers fn for a command name (case-insensitive).
+func (s *Server) Handle(command string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[strings.ToUpper(command)] = fn
+}
+
+// Commands returns the names of the commands received so far.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Close stops the server.
+func (s *Server) Close() { s.listener.Close() }
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, name)
+		fn, ok := s.handlers[name]
+		s.mu.Unlock()
+
+		var reply any = redis.Error("ERR unknown command '" + args[0] + "'")
+		if ok {
+			reply = fn(args[1:])
+		}
+		io.WriteString(conn, encode(reply))
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("redistest: bad command header %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func encode(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "$-1\r\n"
+	case redis.Error:
+		return "-" + string(v) + "\r\n"
+	case int:
+		return fmt.Sprintf(":%d\r\n", v)
+	case int64:
+		return fmt.Sprintf(":%d\r\n", v)
+	case string:
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case []any:
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(v))
+		for _, item := range v {
+			b.WriteString(encode(item))
+		}
+		return b.String()
+	}
+	panic(fmt.Sprintf("redistest: cannot encode %T", v))
+}
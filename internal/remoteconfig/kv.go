@@ -0,0 +1,219 @@
+package remoteconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// watchTimeout bounds one Consul blocking query or etcd watch; Wait returns
+// when it ends even without a change.
+const watchTimeout = 5 * time.Minute
+
+// kvBase returns the HTTP base URL of a Consul or etcd agent.
+func (m *Mirror) kvBase() string {
+	if m.secure {
+		return "https://" + m.bucket
+	}
+	return "http://" + m.bucket
+}
+
+// listConsul reads the prefix recursively, values included. With index set
+// it is a blocking query returning once the prefix changes past index or
+// wait elapses.
+func (m *Mirror) listConsul(ctx context.Context, index string, wait time.Duration) ([]object, error) {
+	query := url.Values{"recurse": {"true"}}
+	if index != "" {
+		query.Set("index", index)
+		query.Set("wait", strconv.Itoa(int(wait.Seconds()))+"s")
+	}
+	target := m.kvBase() + "/v1/kv/" + (&url.URL{Path: m.prefix}).EscapedPath() + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Consul's own CLI variable
+	if token := m.getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	client := m.client
+	if index != "" {
+		client = m.watchClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", m.url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", m.url, err)
+	}
+	m.index = resp.Header.Get("X-Consul-Index")
+	// An empty prefix is a 404
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list %s: %d: %s", m.url, resp.StatusCode, bytes.TrimSpace(data))
+	}
+
+	var entries []struct {
+		Key         string
+		Value       []byte
+		ModifyIndex uint64
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", m.url, err)
+	}
+	objects := make([]object, 0, len(entries))
+	for _, e := range entries {
+		objects = append(objects, object{name: e.Key, etag: strconv.FormatUint(e.ModifyIndex, 10), size: int64(len(e.Value)), data: e.Value})
+	}
+	return objects, nil
+}
+
+// etcdRange returns the key range covering the prefix, as the etcd v3 JSON
+// gateway expects it.
+func (m *Mirror) etcdRange() map[string]string {
+	if m.prefix == "" {
+		// From the first key to the end of the keyspace
+		zero := base64.StdEncoding.EncodeToString([]byte{0})
+		return map[string]string{"key": zero, "range_end": zero}
+	}
+	end := []byte(m.prefix)
+	end[len(end)-1]++
+	return map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(m.prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(end),
+	}
+}
+
+// etcdRequest builds a POST to the etcd v3 JSON gateway. With
+// ETCD_USERNAME set, it first authenticates for a token.
+func (m *Mirror) etcdRequest(ctx context.Context, endpoint string, body any) (*http.Request, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.kvBase()+endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if user := m.getenv("ETCD_USERNAME"); user != "" {
+		credentials, _ := json.Marshal(map[string]string{"name": user, "password": m.getenv("ETCD_PASSWORD")})
+		auth, err := http.NewRequestWithContext(ctx, http.MethodPost, m.kvBase()+"/v3/auth/authenticate", bytes.NewReader(credentials))
+		if err != nil {
+			return nil, err
+		}
+		data, err := m.do(auth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate to etcd: %w", err)
+		}
+		var out struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(data, &out); err != nil || out.Token == "" {
+			return nil, errors.New("failed to authenticate to etcd: no token returned")
+		}
+		req.Header.Set("Authorization", out.Token)
+	}
+	return req, nil
+}
+
+// listEtcd reads the prefix with a range request, values included.
+func (m *Mirror) listEtcd(ctx context.Context) ([]object, error) {
+	req, err := m.etcdRequest(ctx, "/v3/kv/range", m.etcdRange())
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", m.url, err)
+	}
+	// The gateway encodes 64-bit integers as strings and bytes as base64
+	var out struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		KVs []struct {
+			Key         []byte `json:"key"`
+			Value       []byte `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", m.url, err)
+	}
+	m.index = out.Header.Revision
+	objects := make([]object, 0, len(out.KVs))
+	for _, kv := range out.KVs {
+		objects = append(objects, object{name: string(kv.Key), etag: kv.ModRevision, size: int64(len(kv.Value)), data: kv.Value})
+	}
+	return objects, nil
+}
+
+// watchEtcd blocks until a key below the prefix changes after the revision
+// of the last listing, or ctx ends.
+func (m *Mirror) watchEtcd(ctx context.Context) error {
+	revision, err := strconv.ParseInt(m.index, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid etcd revision %q", m.index)
+	}
+	create := m.etcdRange()
+	watch := map[string]any{"create_request": map[string]any{
+		"key":            create["key"],
+		"range_end":      create["range_end"],
+		"start_revision": strconv.FormatInt(revision+1, 10),
+	}}
+	req, err := m.etcdRequest(ctx, "/v3/watch", watch)
+	if err != nil {
+		return err
+	}
+	resp, err := m.watchClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", m.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to watch %s: status %d", m.url, resp.StatusCode)
+	}
+
+	// The response is a stream of JSON messages: the watch being created,
+	// then batches of events
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Canceled bool              `json:"canceled"`
+				Events   []json.RawMessage `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to watch %s: %w", m.url, err)
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("failed to watch %s: %s", m.url, msg.Error.Message)
+		}
+		// A canceled watch, e.g. after compaction, needs a fresh listing
+		if len(msg.Result.Events) > 0 || msg.Result.Canceled {
+			return nil
+		}
+	}
+}
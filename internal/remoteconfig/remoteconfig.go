@@ -0,0 +1,485 @@
+// Package remoteconfig mirrors a configuration directory kept in an S3 or
+// GCS bucket, or under a Consul or etcd key prefix, into a local directory,
+// so many instances can share one centrally managed config. Each sync
+// downloads only the objects whose ETag or modify index changed and removes
+// files whose objects were deleted. Consul and etcd can also be watched, so
+// changes are picked up as soon as they are made.
+package remoteconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Limits on a mirrored directory.
+const (
+	maxObjects    = 1000
+	maxObjectSize = 1 << 20
+	// maxResponseSize caps listings, which carry every value for Consul and
+	// etcd
+	maxResponseSize = 32 << 20
+)
+
+// gcsEndpoint is the Cloud Storage JSON API.
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// Signer signs AWS requests, as secrets.AWS does.
+type Signer interface {
+	Sign(ctx context.Context, req *http.Request, body []byte, service, region string) error
+	Region() string
+}
+
+// TokenSource returns Google access tokens, as secrets.GCP does.
+type TokenSource interface {
+	AccessToken(ctx context.Context) (string, error)
+}
+
+// schemes maps each supported URL scheme to its backend, and whether a
+// Consul or etcd agent is reached over HTTPS.
+var schemes = map[string]struct {
+	backend string
+	secure  bool
+}{
+	"s3":           {"s3", false},
+	"gs":           {"gs", false},
+	"consul":       {"consul", false},
+	"consul+https": {"consul", true},
+	"etcd":         {"etcd", false},
+	"etcd+https":   {"etcd", true},
+}
+
+// IsRemote reports whether path is a remote config URL, such as s3:// or
+// consul://, rather than a local directory.
+func IsRemote(path string) bool {
+	scheme, _, ok := strings.Cut(path, "://")
+	_, known := schemes[scheme]
+	return ok && known
+}
+
+// object is one file in the bucket, keyed by its path below the prefix.
+type object struct {
+	key  string
+	name string
+	etag string
+	size int64
+	// data is the content for key-value stores, which list values inline
+	data []byte
+}
+
+// Mirror keeps a local directory in step with a bucket prefix. It is not
+// safe for concurrent use.
+type Mirror struct {
+	url    string
+	scheme string
+	// bucket is the bucket name, or the host:port of a Consul or etcd agent
+	bucket string
+	secure bool
+	prefix string
+	dir    string
+
+	aws         Signer
+	gcp         TokenSource
+	client      *http.Client
+	watchClient *http.Client
+	getenv      func(string) string
+
+	// etags holds the ETag of each mirrored file, by relative path; nil
+	// before the first sync.
+	etags map[string]string
+	// index is the Consul index or etcd revision of the last listing
+	index string
+}
+
+// New creates a mirror of the s3://bucket/prefix, gs://bucket/prefix,
+// consul://host:port/prefix or etcd://host:port/prefix URL in dir; the
+// consul+https and etcd+https schemes reach the agent over TLS. S3 requests
+// are signed with aws, and GCS requests authorized with gcp.
+func New(rawURL, dir string, aws Signer, gcp TokenSource) (*Mirror, error) {
+	u, err := url.Parse(rawURL)
+	scheme, ok := schemes[u.Scheme]
+	if err != nil || !ok || u.Host == "" {
+		return nil, fmt.Errorf("invalid remote config URL %q: want s3://<bucket>/<prefix>, gs://<bucket>/<prefix>, consul://<host:port>/<prefix> or etcd://<host:port>/<prefix>", rawURL)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &Mirror{
+		url:    rawURL,
+		scheme: scheme.backend,
+		bucket: u.Host,
+		secure: scheme.secure,
+		prefix: prefix,
+		dir:    dir,
+		aws:    aws,
+		gcp:    gcp,
+		client: &http.Client{Timeout: 30 * time.Second},
+		// Watches are bounded by their context instead
+		watchClient: &http.Client{},
+		getenv:      os.Getenv,
+	}, nil
+}
+
+// URL returns the mirrored location.
+func (m *Mirror) URL() string {
+	return m.url
+}
+
+// Watches reports whether Wait can block until the source changes. Buckets
+// cannot be watched and are polled instead.
+func (m *Mirror) Watches() bool {
+	return m.scheme == "consul" || m.scheme == "etcd"
+}
+
+// Wait blocks until the Consul or etcd prefix may have changed since the
+// last sync, returning after at most five minutes regardless. It returns
+// at once before the first sync or for a bucket.
+func (m *Mirror) Wait(ctx context.Context) error {
+	if !m.Watches() || m.index == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, watchTimeout+time.Minute)
+	defer cancel()
+	if m.scheme == "consul" {
+		index := m.index
+		_, err := m.listConsul(ctx, index, watchTimeout)
+		// Keep the synced index so the next Sync sees the change
+		m.index = index
+		return err
+	}
+	ctx, cancelWatch := context.WithTimeout(ctx, watchTimeout)
+	defer cancelWatch()
+	return m.watchEtcd(ctx)
+}
+
+// Sync brings the local directory up to date with the bucket. It reports
+// whether any file changed. On error the directory may be partly updated;
+// the next sync finishes the job.
+func (m *Mirror) Sync(ctx context.Context) (bool, error) {
+	objects, err := m.list(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(objects) > maxObjects {
+		return false, fmt.Errorf("%s has more than %d objects", m.url, maxObjects)
+	}
+	current := make(map[string]string, len(objects))
+	for _, obj := range objects {
+		if obj.size > maxObjectSize {
+			return false, fmt.Errorf("%s is larger than %d bytes", obj.name, maxObjectSize)
+		}
+		current[obj.key] = obj.etag
+	}
+	if m.etags != nil && maps.Equal(current, m.etags) {
+		return false, nil
+	}
+
+	// m.etags tracks what is on disk as files change, so a failed sync is
+	// picked up where it stopped
+	if m.etags == nil {
+		m.etags = make(map[string]string, len(current))
+	}
+	for _, obj := range objects {
+		if etag, ok := m.etags[obj.key]; ok && etag == obj.etag {
+			continue
+		}
+		data, err := m.download(ctx, obj)
+		if err != nil {
+			return false, err
+		}
+		if err := m.write(obj.key, data); err != nil {
+			return false, err
+		}
+		m.etags[obj.key] = obj.etag
+	}
+	for key := range m.etags {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(m.dir, filepath.FromSlash(key))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		delete(m.etags, key)
+	}
+	return true, nil
+}
+
+// write replaces a mirrored file atomically.
+func (m *Mirror) write(key string, data []byte) error {
+	file := filepath.Join(m.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".remote-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// list returns the objects below the prefix, skipping folder placeholders
+// and keys that would escape the local directory.
+func (m *Mirror) list(ctx context.Context) ([]object, error) {
+	var objects []object
+	var err error
+	switch m.scheme {
+	case "s3":
+		objects, err = m.listS3(ctx)
+	case "gs":
+		objects, err = m.listGCS(ctx)
+	case "consul":
+		objects, err = m.listConsul(ctx, "", 0)
+	case "etcd":
+		objects, err = m.listEtcd(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	kept := objects[:0]
+	for _, obj := range objects {
+		key := strings.TrimPrefix(obj.name, m.prefix)
+		if key == "" || strings.HasSuffix(key, "/") {
+			continue
+		}
+		if clean := path.Clean(key); clean != key || strings.HasPrefix(clean, "../") || path.IsAbs(clean) {
+			return nil, fmt.Errorf("object %s has an unsafe name", obj.name)
+		}
+		obj.key = key
+		kept = append(kept, obj)
+	}
+	return kept, nil
+}
+
+// download fetches an object's contents.
+func (m *Mirror) download(ctx context.Context, obj object) ([]byte, error) {
+	if m.Watches() {
+		return obj.data, nil
+	}
+	var req *http.Request
+	var err error
+	if m.scheme == "s3" {
+		req, err = m.s3Request(ctx, s3Escape(obj.name), nil)
+	} else {
+		req, err = m.gcsRequest(ctx, "/storage/v1/b/"+url.PathEscape(m.bucket)+"/o/"+url.PathEscape(obj.name), url.Values{"alt": {"media"}})
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", obj.name, err)
+	}
+	return data, nil
+}
+
+// s3Request builds a signed GET of the bucket's escaped key. Requests go to
+// the virtual-hosted bucket endpoint, or path-style to AWS_ENDPOINT_URL_S3
+// or AWS_ENDPOINT_URL when set, e.g. for MinIO.
+func (m *Mirror) s3Request(ctx context.Context, escapedKey string, query url.Values) (*http.Request, error) {
+	region := m.aws.Region()
+	if region == "" {
+		return nil, errors.New("no AWS region: set AWS_REGION to read config from S3")
+	}
+	endpoint := m.getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = m.getenv("AWS_ENDPOINT_URL")
+	}
+	var target string
+	if endpoint != "" {
+		target = strings.TrimSuffix(endpoint, "/") + "/" + s3Escape(m.bucket) + "/" + escapedKey
+	} else {
+		target = "https://" + m.bucket + ".s3." + region + ".amazonaws.com/" + escapedKey
+	}
+	if len(query) > 0 {
+		target += "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	emptyHash := sha256.Sum256(nil)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(emptyHash[:]))
+	if err := m.aws.Sign(ctx, req, nil, "s3", region); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// listS3 lists the prefix with ListObjectsV2.
+func (m *Mirror) listS3(ctx context.Context) ([]object, error) {
+	var objects []object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {m.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := m.s3Request(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+		data, err := m.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", m.url, err)
+		}
+		var out struct {
+			Contents []struct {
+				Key  string
+				ETag string
+				Size int64
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(data, &out); err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", m.url, err)
+		}
+		for _, c := range out.Contents {
+			objects = append(objects, object{name: c.Key, etag: c.ETag, size: c.Size})
+		}
+		if !out.IsTruncated || out.NextContinuationToken == "" || len(objects) > maxObjects {
+			return objects, nil
+		}
+		token = out.NextContinuationToken
+	}
+}
+
+// gcsRequest builds an authorized GET of the Cloud Storage JSON API. With
+// STORAGE_EMULATOR_HOST set, requests go to the emulator unauthenticated,
+// as Google's client libraries do.
+func (m *Mirror) gcsRequest(ctx context.Context, escapedPath string, query url.Values) (*http.Request, error) {
+	endpoint := gcsEndpoint
+	emulator := m.getenv("STORAGE_EMULATOR_HOST")
+	if emulator != "" {
+		endpoint = emulator
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+	}
+	target := strings.TrimSuffix(endpoint, "/") + escapedPath
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if emulator == "" {
+		token, err := m.gcp.AccessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// listGCS lists the prefix with the Cloud Storage objects.list call.
+func (m *Mirror) listGCS(ctx context.Context) ([]object, error) {
+	var objects []object
+	token := ""
+	for {
+		query := url.Values{"prefix": {m.prefix}, "fields": {"items(name,etag,size),nextPageToken"}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		req, err := m.gcsRequest(ctx, "/storage/v1/b/"+url.PathEscape(m.bucket)+"/o", query)
+		if err != nil {
+			return nil, err
+		}
+		data, err := m.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", m.url, err)
+		}
+		var out struct {
+			Items []struct {
+				Name string `json:"name"`
+				ETag string `json:"etag"`
+				// The JSON API encodes 64-bit integers as strings
+				Size int64 `json:"size,string"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(data, &out); err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", m.url, err)
+		}
+		for _, item := range out.Items {
+			objects = append(objects, object{name: item.Name, etag: item.ETag, size: item.Size})
+		}
+		if out.NextPageToken == "" || len(objects) > maxObjects {
+			return objects, nil
+		}
+		token = out.NextPageToken
+	}
+}
+
+// do performs req and returns its body, describing S3 and GCS errors.
+func (m *Mirror) do(req *http.Request) ([]byte, error) {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return data, nil
+	}
+
+	var s3Err struct {
+		Code    string
+		Message string
+	}
+	var gcsErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	switch {
+	case xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "":
+		return nil, fmt.Errorf("%s: %s", s3Err.Code, s3Err.Message)
+	case json.Unmarshal(data, &gcsErr) == nil && gcsErr.Error.Message != "":
+		return nil, fmt.Errorf("%d: %s", resp.StatusCode, gcsErr.Error.Message)
+	}
+	return nil, fmt.Errorf("request returned %d", resp.StatusCode)
+}
+
+// s3Escape encodes an object key as SigV4 requires: every byte but
+// unreserved characters and "/".
+func s3Escape(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
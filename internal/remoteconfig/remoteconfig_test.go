@@ -0,0 +1,475 @@
+package remoteconfig
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSigner marks requests as signed.
+type fakeSigner struct{ region string }
+
+func (f fakeSigner) Sign(ctx context.Context, req *http.Request, body []byte, service, region string) error {
+	req.Header.Set("Authorization", "signed "+service+" "+region)
+	return nil
+}
+
+func (f fakeSigner) Region() string { return f.region }
+
+// fakeTokens hands out a fixed access token.
+type fakeTokens struct{}
+
+func (fakeTokens) AccessToken(ctx context.Context) (string, error) { return "gcs-token", nil }
+
+// bucket is an in-memory bucket with per-object ETags and download counts.
+// For key-value stores it also keeps a store-wide revision, each key's
+// modify revision, and a channel closed on every change.
+type bucket struct {
+	mu        sync.Mutex
+	objects   map[string]string
+	downloads map[string]int
+	revision  int
+	modified  map[string]int
+	changed   chan struct{}
+}
+
+func newBucket(objects map[string]string) *bucket {
+	b := &bucket{objects: objects, downloads: make(map[string]int), revision: 1, modified: make(map[string]int), changed: make(chan struct{})}
+	for name := range objects {
+		b.modified[name] = 1
+	}
+	return b
+}
+
+func (b *bucket) set(name, content string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.revision++
+	if content == "" {
+		delete(b.objects, name)
+		delete(b.modified, name)
+	} else {
+		b.objects[name] = content
+		b.modified[name] = b.revision
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func etag(content string) string {
+	return fmt.Sprintf(`"%x"`, len(content)*31+int(content[0]))
+}
+
+// s3Server serves ListObjectsV2 and GetObject path-style for bucket "cfg".
+func s3Server(t *testing.T, b *bucket) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "signed s3 us-east-1" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+			return
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		key, _ := strings.CutPrefix(r.URL.Path, "/cfg/")
+		if key == "" {
+			prefix := r.URL.Query().Get("prefix")
+			type content struct {
+				Key  string
+				ETag string
+				Size int
+			}
+			var out struct {
+				XMLName  xml.Name `xml:"ListBucketResult"`
+				Contents []content
+			}
+			for name, data := range b.objects {
+				if strings.HasPrefix(name, prefix) {
+					out.Contents = append(out.Contents, content{Key: name, ETag: etag(data), Size: len(data)})
+				}
+			}
+			xml.NewEncoder(w).Encode(out)
+			return
+		}
+		data, ok := b.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+			return
+		}
+		b.downloads[key]++
+		w.Write([]byte(data))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// gcsServer serves objects.list and objects.get media for bucket "cfg".
+func gcsServer(t *testing.T, b *bucket) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if r.URL.Path == "/storage/v1/b/cfg/o" {
+			type item struct {
+				Name string `json:"name"`
+				ETag string `json:"etag"`
+				Size string `json:"size"`
+			}
+			var items []item
+			for name, data := range b.objects {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					items = append(items, item{Name: name, ETag: etag(data), Size: fmt.Sprint(len(data))})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{"items": items})
+			return
+		}
+		name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1/b/cfg/o/"))
+		data, ok := b.objects[name]
+		if !ok || r.URL.Query().Get("alt") != "media" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"No such object: cfg/` + name + `"}}`))
+			return
+		}
+		b.downloads[name]++
+		w.Write([]byte(data))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// consulServer serves recursive KV reads, including blocking queries, for
+// keys below the requested prefix.
+func consulServer(t *testing.T, b *bucket) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Consul-Token") != "consul-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Permission denied"))
+			return
+		}
+		prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		b.mu.Lock()
+		if index := r.URL.Query().Get("index"); index == fmt.Sprint(b.revision) {
+			changed := b.changed
+			b.mu.Unlock()
+			select {
+			case <-changed:
+			case <-time.After(time.Second):
+			}
+			b.mu.Lock()
+		}
+		defer b.mu.Unlock()
+		w.Header().Set("X-Consul-Index", fmt.Sprint(b.revision))
+		type entry struct {
+			Key         string
+			Value       []byte
+			ModifyIndex int
+		}
+		var entries []entry
+		for name, data := range b.objects {
+			if strings.HasPrefix(name, prefix) {
+				entries = append(entries, entry{Key: name, Value: []byte(data), ModifyIndex: b.modified[name]})
+			}
+		}
+		if len(entries) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(entries)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// etcdServer serves the v3 JSON gateway's range and watch calls, requiring
+// a token from the authenticate call.
+func etcdServer(t *testing.T, b *bucket) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v3/auth/authenticate" {
+			var creds struct{ Name, Password string }
+			json.NewDecoder(r.Body).Decode(&creds)
+			if creds.Name != "portus" || creds.Password != "etcd-password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":{"message":"authentication failed"}}`))
+				return
+			}
+			w.Write([]byte(`{"token":"etcd-token"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "etcd-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Key           []byte `json:"key"`
+			RangeEnd      []byte `json:"range_end"`
+			CreateRequest *struct {
+				Key           []byte `json:"key"`
+				RangeEnd      []byte `json:"range_end"`
+				StartRevision int    `json:"start_revision,string"`
+			} `json:"create_request"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if r.URL.Path == "/v3/watch" {
+			b.mu.Lock()
+			changed, revision := b.changed, b.revision
+			b.mu.Unlock()
+			w.Write([]byte(`{"result":{"created":true}}` + "\n"))
+			w.(http.Flusher).Flush()
+			if revision < req.CreateRequest.StartRevision {
+				select {
+				case <-changed:
+				case <-r.Context().Done():
+					return
+				}
+			}
+			w.Write([]byte(`{"result":{"events":[{"type":"PUT"}]}}` + "\n"))
+			return
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		type kv struct {
+			Key         []byte `json:"key"`
+			Value       []byte `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		}
+		var out struct {
+			Header struct {
+				Revision string `json:"revision"`
+			} `json:"header"`
+			KVs []kv `json:"kvs"`
+		}
+		out.Header.Revision = fmt.Sprint(b.revision)
+		for name, data := range b.objects {
+			if name >= string(req.Key) && name < string(req.RangeEnd) {
+				out.KVs = append(out.KVs, kv{Key: []byte(name), Value: []byte(data), ModRevision: fmt.Sprint(b.modified[name])})
+			}
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			data, _ := os.ReadFile(path)
+			rel, _ := filepath.Rel(dir, path)
+			files[filepath.ToSlash(rel)] = string(data)
+		}
+		return nil
+	})
+	return files
+}
+
+func TestMirror_Sync(t *testing.T) {
+	t.Parallel()
+
+	for _, scheme := range []string{"s3", "gs"} {
+		t.Run(scheme, func(t *testing.T) {
+			t.Parallel()
+			b := newBucket(map[string]string{
+				"portus/models/gpt.json":    `{"provider":"openai"}`,
+				"portus/models/claude.json": `{"provider":"anthropic"}`,
+				"portus/models/":            "placeholder",
+				"portus/pricing.json":       `{}`,
+				"other/ignored.json":        `{}`,
+			})
+			var env map[string]string
+			if scheme == "s3" {
+				env = map[string]string{"AWS_ENDPOINT_URL_S3": s3Server(t, b).URL}
+			} else {
+				env = map[string]string{"STORAGE_EMULATOR_HOST": gcsServer(t, b).URL}
+			}
+
+			dir := t.TempDir()
+			m, err := New(scheme+"://cfg/portus", dir, fakeSigner{region: "us-east-1"}, fakeTokens{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.getenv = func(name string) string { return env[name] }
+
+			if changed, err := m.Sync(context.Background()); err != nil || !changed {
+				t.Fatalf("first Sync() = %v, %v", changed, err)
+			}
+			want := map[string]string{
+				"models/gpt.json":    `{"provider":"openai"}`,
+				"models/claude.json": `{"provider":"anthropic"}`,
+				"pricing.json":       `{}`,
+			}
+			if got := readDir(t, dir); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("mirrored files = %v, want %v", got, want)
+			}
+
+			// Nothing changed
+			if changed, err := m.Sync(context.Background()); err != nil || changed {
+				t.Fatalf("unchanged Sync() = %v, %v", changed, err)
+			}
+
+			// One edit, one deletion: only the edited object is downloaded
+			b.set("portus/models/gpt.json", `{"provider":"openai","tags":{"team":"ml"}}`)
+			b.set("portus/models/claude.json", "")
+			if changed, err := m.Sync(context.Background()); err != nil || !changed {
+				t.Fatalf("Sync() after edits = %v, %v", changed, err)
+			}
+			want = map[string]string{
+				"models/gpt.json": `{"provider":"openai","tags":{"team":"ml"}}`,
+				"pricing.json":    `{}`,
+			}
+			if got := readDir(t, dir); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("mirrored files = %v, want %v", got, want)
+			}
+			if n := b.downloads["portus/pricing.json"]; n != 1 {
+				t.Errorf("expected an unchanged object to be downloaded once, got %d", n)
+			}
+		})
+	}
+}
+
+func TestMirror_SyncAndWaitKV(t *testing.T) {
+	t.Parallel()
+
+	for _, scheme := range []string{"consul", "etcd"} {
+		t.Run(scheme, func(t *testing.T) {
+			t.Parallel()
+			b := newBucket(map[string]string{
+				"portus/models/gpt.json": `{"provider":"openai"}`,
+				"portus/keys/BACKEND":    "pk-backend",
+				"portus/models/":         "",
+				"portusx/ignored.json":   `{}`,
+			})
+			var server *httptest.Server
+			if scheme == "consul" {
+				server = consulServer(t, b)
+			} else {
+				server = etcdServer(t, b)
+			}
+			env := map[string]string{"CONSUL_HTTP_TOKEN": "consul-token", "ETCD_USERNAME": "portus", "ETCD_PASSWORD": "etcd-password"}
+
+			dir := t.TempDir()
+			m, err := New(scheme+"://"+strings.TrimPrefix(server.URL, "http://")+"/portus", dir, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.getenv = func(name string) string { return env[name] }
+			if !m.Watches() {
+				t.Fatal("expected a key-value store to be watchable")
+			}
+
+			if changed, err := m.Sync(context.Background()); err != nil || !changed {
+				t.Fatalf("first Sync() = %v, %v", changed, err)
+			}
+			want := map[string]string{"models/gpt.json": `{"provider":"openai"}`, "keys/BACKEND": "pk-backend"}
+			if got := readDir(t, dir); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("mirrored files = %v, want %v", got, want)
+			}
+
+			// Wait returns once a key changes
+			waited := make(chan error, 1)
+			go func() { waited <- m.Wait(context.Background()) }()
+			time.Sleep(50 * time.Millisecond)
+			b.set("portus/models/gpt.json", `{"provider":"openai","tags":{"team":"ml"}}`)
+			select {
+			case err := <-waited:
+				if err != nil {
+					t.Fatalf("Wait() error: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected Wait to return after a change")
+			}
+
+			if changed, err := m.Sync(context.Background()); err != nil || !changed {
+				t.Fatalf("Sync() after the change = %v, %v", changed, err)
+			}
+			if got := readDir(t, dir)["models/gpt.json"]; got != `{"provider":"openai","tags":{"team":"ml"}}` {
+				t.Errorf("expected the edited value, got %s", got)
+			}
+			if changed, err := m.Sync(context.Background()); err != nil || changed {
+				t.Errorf("unchanged Sync() = %v, %v", changed, err)
+			}
+
+			// Credentials are required
+			env = nil
+			if _, err := m.Sync(context.Background()); err == nil {
+				t.Error("expected an error without credentials")
+			}
+		})
+	}
+}
+
+func TestMirror_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		url     string
+		objects map[string]string
+		region  string
+		wantErr string
+	}{
+		{name: "invalid url", url: "https://cfg/portus", wantErr: "invalid remote config URL"},
+		{name: "no region", url: "s3://cfg/portus", wantErr: "no AWS region"},
+		{name: "denied", url: "s3://cfg/portus", region: "eu-west-1", wantErr: "AccessDenied: Access Denied"},
+		{name: "unsafe name", url: "s3://cfg/portus", region: "us-east-1", objects: map[string]string{"portus/../etc/passwd": "x"}, wantErr: "unsafe name"},
+		{name: "too large", url: "s3://cfg/portus", region: "us-east-1", objects: map[string]string{"portus/big.json": strings.Repeat("x", maxObjectSize+1)}, wantErr: "larger than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := s3Server(t, newBucket(tt.objects))
+			m, err := New(tt.url, t.TempDir(), fakeSigner{region: tt.region}, fakeTokens{})
+			if err == nil {
+				m.getenv = func(name string) string { return map[string]string{"AWS_ENDPOINT_URL": server.URL}[name] }
+				_, err = m.Sync(context.Background())
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestS3Escape(t *testing.T) {
+	t.Parallel()
+
+	if got := s3Escape("models/gpt 4+o(1).json"); got != "models/gpt%204%2Bo%281%29.json" {
+		t.Errorf("s3Escape() = %q", got)
+	}
+}
+
+func TestIsRemote(t *testing.T) {
+	t.Parallel()
+
+	for path, want := range map[string]bool{
+		"s3://cfg/portus":                   true,
+		"gs://cfg/portus":                   true,
+		"consul://127.0.0.1:8500/portus":    true,
+		"etcd+https://etcd.internal/portus": true,
+		"./config":                          false,
+		"/etc/portus":                       false,
+		"https://config.example.com/portus": false,
+	} {
+		if got := IsRemote(path); got != want {
+			t.Errorf("IsRemote(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
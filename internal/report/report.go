@@ -0,0 +1,158 @@
+// Package report summarizes what a Portus process served over its lifetime,
+// for the shutdown log and an optional JSON file.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Recorder counts proxied requests and in-flight streams. It is safe for
+// concurrent use; a nil Recorder records nothing.
+type Recorder struct {
+	mu           sync.Mutex
+	requests     int64
+	aliases      map[string]int64
+	clientErrors int64
+	serverErrors int64
+
+	activeStreams atomic.Int64
+}
+
+// NewRecorder creates an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{aliases: make(map[string]int64)}
+}
+
+// RecordRequest counts a completed request for a model alias by its final
+// response status.
+func (r *Recorder) RecordRequest(modelAlias string, status int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests++
+	r.aliases[modelAlias]++
+	switch {
+	case status >= http.StatusInternalServerError:
+		r.serverErrors++
+	case status >= http.StatusBadRequest:
+		r.clientErrors++
+	}
+}
+
+// StreamStarted counts a streaming response as in flight. The returned
+// function must be called when the stream ends.
+func (r *Recorder) StreamStarted() (done func()) {
+	if r == nil {
+		return func() {}
+	}
+	r.activeStreams.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { r.activeStreams.Add(-1) })
+	}
+}
+
+// ActiveStreams returns the number of streams currently in flight.
+func (r *Recorder) ActiveStreams() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.activeStreams.Load()
+}
+
+// Report is the summary produced at shutdown.
+type Report struct {
+	StartedAt    time.Time        `json:"started_at"`
+	StoppedAt    time.Time        `json:"stopped_at"`
+	Uptime       string           `json:"uptime"`
+	Requests     int64            `json:"requests"`
+	Aliases      map[string]int64 `json:"aliases"`
+	ClientErrors int64            `json:"client_errors"`
+	ServerErrors int64            `json:"server_errors"`
+	// DrainedStreams finished on their own after shutdown began.
+	DrainedStreams int64 `json:"drained_streams"`
+	// ForcedClosedStreams were still running when the shutdown timeout expired.
+	ForcedClosedStreams int64 `json:"forced_closed_streams"`
+}
+
+// Report builds the summary. streamsAtShutdown is the number of streams in
+// flight when shutdown began and forcedClosed the number still running when
+// the remaining connections were closed.
+func (r *Recorder) Report(startedAt, stoppedAt time.Time, streamsAtShutdown, forcedClosed int64) Report {
+	rep := Report{
+		StartedAt:           startedAt,
+		StoppedAt:           stoppedAt,
+		Uptime:              stoppedAt.Sub(startedAt).Round(time.Second).String(),
+		Aliases:             map[string]int64{},
+		DrainedStreams:      max(streamsAtShutdown-forcedClosed, 0),
+		ForcedClosedStreams: forcedClosed,
+	}
+	if r == nil {
+		return rep
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep.Requests = r.requests
+	rep.ClientErrors = r.clientErrors
+	rep.ServerErrors = r.serverErrors
+	for alias, count := range r.aliases {
+		rep.Aliases[alias] = count
+	}
+	return rep
+}
+
+// LogAttrs returns the report as slog key-value pairs.
+func (rep Report) LogAttrs() []any {
+	return []any{
+		"uptime", rep.Uptime,
+		"requests", rep.Requests,
+		"aliases", rep.Aliases,
+		"client_errors", rep.ClientErrors,
+		"server_errors", rep.ServerErrors,
+		"drained_streams", rep.DrainedStreams,
+		"forced_closed_streams", rep.ForcedClosedStreams,
+	}
+}
+
+// Write saves the report as portus-report-<stop time>.json in dir, creating
+// the directory if needed, and returns the file path. The file is renamed into
+// place so collectors never read a partial report.
+func Write(dir string, rep Report) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create report directory: %w", err)
+	}
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("portus-report-%s.json", rep.StoppedAt.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(dir, name)
+	tmp, err := os.CreateTemp(dir, name+".*")
+	if err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	return path, nil
+}
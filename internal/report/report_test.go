@@ -0,0 +1,88 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorder_Report(t *testing.T) {
+	t.Parallel()
+
+	r := NewRecorder()
+	r.RecordRequest("claude-sonnet", 200)
+	r.RecordRequest("claude-sonnet", 429)
+	r.RecordRequest("gpt-4o", 502)
+	r.RecordRequest("gpt-4o", 200)
+
+	done1 := r.StreamStarted()
+	r.StreamStarted()
+	r.StreamStarted()
+	done1()
+	done1() // Ending a stream twice must not undercount
+	if n := r.ActiveStreams(); n != 2 {
+		t.Fatalf("expected 2 active streams, got %d", n)
+	}
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	rep := r.Report(start, start.Add(90*time.Minute), 3, 2)
+
+	if rep.Requests != 4 || rep.ClientErrors != 1 || rep.ServerErrors != 1 {
+		t.Errorf("unexpected totals: %+v", rep)
+	}
+	if rep.Aliases["claude-sonnet"] != 2 || rep.Aliases["gpt-4o"] != 2 {
+		t.Errorf("unexpected alias counts: %v", rep.Aliases)
+	}
+	if rep.DrainedStreams != 1 || rep.ForcedClosedStreams != 2 {
+		t.Errorf("expected 1 drained and 2 forced-closed streams, got %d and %d", rep.DrainedStreams, rep.ForcedClosedStreams)
+	}
+	if rep.Uptime != "1h30m0s" {
+		t.Errorf("expected uptime 1h30m0s, got %s", rep.Uptime)
+	}
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	t.Parallel()
+
+	var r *Recorder
+	r.RecordRequest("gpt-4o", 200)
+	r.StreamStarted()()
+	if rep := r.Report(time.Now(), time.Now(), 0, 0); rep.Requests != 0 || rep.Aliases == nil {
+		t.Errorf("unexpected report from nil recorder: %+v", rep)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "reports")
+	stopped := time.Date(2026, 3, 1, 13, 30, 0, 0, time.UTC)
+	rep := NewRecorder().Report(stopped.Add(-time.Hour), stopped, 0, 0)
+
+	path, err := Write(dir, rep)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if filepath.Base(path) != "portus-report-20260301T133000Z.json" {
+		t.Errorf("unexpected report file name: %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+	if got.Uptime != "1h0m0s" {
+		t.Errorf("unexpected uptime in file: %s", got.Uptime)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected only the report in the directory, got %d entries", len(entries))
+	}
+}
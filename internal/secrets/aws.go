@@ -0,0 +1,345 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialRefreshMargin is how long before expiry temporary credentials
+// are replaced.
+const credentialRefreshMargin = 5 * time.Minute
+
+// Well-known AWS credential endpoints.
+const (
+	containerCredentialsHost = "http://169.254.170.2"
+	imdsEndpoint             = "http://169.254.169.254"
+)
+
+// AWS fetches secrets from AWS Secrets Manager and SSM Parameter Store. Its
+// credentials come from the standard chain: AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, a web identity token (EKS IRSA), the ECS or EKS Pod
+// Identity container endpoint, then the EC2 instance metadata service.
+type AWS struct {
+	client *http.Client
+	getenv func(string) string
+	// imds is the instance metadata endpoint, replaced in tests.
+	imds string
+
+	mu    sync.Mutex
+	creds awsCredentials
+}
+
+// NewAWS creates an AWS secret client configured from the environment.
+func NewAWS() *AWS {
+	return &AWS{client: &http.Client{Timeout: 10 * time.Second}, getenv: os.Getenv, imds: imdsEndpoint}
+}
+
+// SecretsManager returns the source for "${awssm:<secret>}" references. The
+// secret is a name or ARN, optionally followed by "#<key>" to select a field
+// of a JSON secret.
+func (a *AWS) SecretsManager() Source {
+	return SourceFunc(func(ctx context.Context, ref string) (string, error) {
+		id, key, _ := strings.Cut(ref, "#")
+		var out struct {
+			SecretString string
+		}
+		if err := a.call(ctx, "secretsmanager", "SECRETS_MANAGER", "secretsmanager.GetSecretValue", regionOf(id),
+			map[string]any{"SecretId": id}, &out); err != nil {
+			return "", err
+		}
+		return jsonField(out.SecretString, key)
+	})
+}
+
+// ParameterStore returns the source for "${ssm:<parameter>}" references. The
+// parameter is a name or ARN; SecureString parameters are decrypted.
+func (a *AWS) ParameterStore() Source {
+	return SourceFunc(func(ctx context.Context, ref string) (string, error) {
+		var out struct {
+			Parameter struct {
+				Value string
+			}
+		}
+		if err := a.call(ctx, "ssm", "SSM", "AmazonSSM.GetParameter", regionOf(ref),
+			map[string]any{"Name": ref, "WithDecryption": true}, &out); err != nil {
+			return "", err
+		}
+		return out.Parameter.Value, nil
+	})
+}
+
+// regionOf returns the region of an ARN, or "" for a plain name.
+func regionOf(ref string) string {
+	parts := strings.SplitN(ref, ":", 5)
+	if len(parts) == 5 && parts[0] == "arn" {
+		return parts[3]
+	}
+	return ""
+}
+
+// call makes a signed JSON API call to service. endpointName is the
+// service's suffix in AWS_ENDPOINT_URL_<NAME>.
+func (a *AWS) call(ctx context.Context, service, endpointName, target, region string, in, out any) error {
+	if region == "" {
+		region = a.Region()
+	}
+	if region == "" {
+		return errors.New("no AWS region: set AWS_REGION or reference the secret by ARN")
+	}
+	endpoint := a.getenv("AWS_ENDPOINT_URL_" + endpointName)
+	if endpoint == "" {
+		endpoint = a.getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://" + service + "." + region + ".amazonaws.com"
+	}
+	creds, err := a.credentials(ctx, region)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signV4(req, body, creds, region, service, time.Now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string
+		}
+		json.Unmarshal(data, &apiErr)
+		if apiErr.Type == "" {
+			return fmt.Errorf("%s returned %d", target, resp.StatusCode)
+		}
+		// The type may be prefixed with a namespace
+		_, name, _ := strings.Cut(apiErr.Type, "#")
+		if name == "" {
+			name = apiErr.Type
+		}
+		return fmt.Errorf("%s: %s: %s", target, name, apiErr.Message)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Sign signs req for service in region with the current credentials, for
+// callers making their own AWS API requests. body is the request body.
+func (a *AWS) Sign(ctx context.Context, req *http.Request, body []byte, service, region string) error {
+	creds, err := a.credentials(ctx, region)
+	if err != nil {
+		return err
+	}
+	signV4(req, body, creds, region, service, time.Now())
+	return nil
+}
+
+// Region returns the region set by AWS_REGION or AWS_DEFAULT_REGION.
+func (a *AWS) Region() string {
+	if region := a.getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return a.getenv("AWS_DEFAULT_REGION")
+}
+
+// credentials returns the current credentials, fetching new ones when there
+// are none or they are about to expire.
+func (a *AWS) credentials(ctx context.Context, region string) (awsCredentials, error) {
+	if id, secret := a.getenv("AWS_ACCESS_KEY_ID"), a.getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: a.getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.creds.AccessKeyID != "" && time.Until(a.creds.Expires) > credentialRefreshMargin {
+		return a.creds, nil
+	}
+	var creds awsCredentials
+	var err error
+	switch {
+	case a.getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && a.getenv("AWS_ROLE_ARN") != "":
+		creds, err = a.webIdentityCredentials(ctx, region)
+	case a.getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || a.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+		creds, err = a.containerCredentials(ctx)
+	default:
+		creds, err = a.instanceCredentials(ctx)
+	}
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+	a.creds = creds
+	return creds, nil
+}
+
+// webIdentityCredentials exchanges the web identity token for role
+// credentials with STS, as EKS IAM roles for service accounts do.
+func (a *AWS) webIdentityCredentials(ctx context.Context, region string) (awsCredentials, error) {
+	token, err := os.ReadFile(a.getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	sessionName := a.getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "portus"
+	}
+	endpoint := a.getenv("AWS_ENDPOINT_URL_STS")
+	if endpoint == "" {
+		endpoint = a.getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://sts." + region + ".amazonaws.com"
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {a.getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(endpoint, "/")+"/?"+query.Encode(), nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	data, err := a.get(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("AssumeRoleWithWebIdentity: %w", err)
+	}
+	var out struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(data, &out); err != nil {
+		return awsCredentials{}, fmt.Errorf("AssumeRoleWithWebIdentity: %w", err)
+	}
+	c := out.Credentials
+	return awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken, Expires: c.Expiration}, nil
+}
+
+// containerCredentials reads credentials from the ECS task or EKS Pod
+// Identity credentials endpoint.
+func (a *AWS) containerCredentials(ctx context.Context) (awsCredentials, error) {
+	endpoint := a.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := a.getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = containerCredentialsHost + relative
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	token := a.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := a.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	data, err := a.get(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("container credentials: %w", err)
+	}
+	return parseCredentialsJSON(data)
+}
+
+// instanceCredentials reads the instance role's credentials from the EC2
+// instance metadata service, using IMDSv2 session tokens.
+func (a *AWS) instanceCredentials(ctx context.Context) (awsCredentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, a.imds+"/latest/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	token, err := a.get(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no credentials in the environment and instance metadata is unavailable: %w", err)
+	}
+
+	metadata := func(path string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.imds+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+		return a.get(req)
+	}
+	role, err := metadata("")
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("instance has no IAM role: %w", err)
+	}
+	data, err := metadata(strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]))
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("instance credentials: %w", err)
+	}
+	return parseCredentialsJSON(data)
+}
+
+// get performs req and returns its body, failing on a non-200 status.
+func (a *AWS) get(req *http.Request) ([]byte, error) {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d", req.URL.Path, resp.StatusCode)
+	}
+	return data, nil
+}
+
+// parseCredentialsJSON parses the credentials document served by the
+// container and instance metadata endpoints.
+func parseCredentialsJSON(data []byte) (awsCredentials, error) {
+	var c struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return awsCredentials{}, err
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return awsCredentials{}, errors.New("credentials response has no access key")
+	}
+	return awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token, Expires: c.Expiration}, nil
+}
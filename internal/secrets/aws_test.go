@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeAWS serves GetSecretValue and GetParameter, requiring signed requests.
+func fakeAWS(t *testing.T, wantKeyID string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential="+wantKeyID+"/") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"__type":"UnrecognizedClientException","message":"bad signature"}`))
+			return
+		}
+		var in map[string]any
+		json.NewDecoder(r.Body).Decode(&in)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.GetSecretValue":
+			if in["SecretId"] != "arn:aws:secretsmanager:eu-west-1:123456789012:secret:portus-AbCdEf" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"com.amazonaws.secretsmanager#ResourceNotFoundException","Message":"Secrets Manager can't find the specified secret."}`))
+				return
+			}
+			w.Write([]byte(`{"SecretString":"{\"openai\":\"sk-from-sm\"}"}`))
+		case "AmazonSSM.GetParameter":
+			if in["Name"] != "/portus/anthropic" || in["WithDecryption"] != true {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"ParameterNotFound"}`))
+				return
+			}
+			w.Write([]byte(`{"Parameter":{"Name":"/portus/anthropic","Value":"sk-from-ssm"}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAWS_Sources(t *testing.T) {
+	t.Parallel()
+
+	server := fakeAWS(t, "AKIDENV")
+	env := map[string]string{
+		"AWS_REGION":            "us-east-1",
+		"AWS_ENDPOINT_URL":      server.URL,
+		"AWS_ACCESS_KEY_ID":     "AKIDENV",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+	}
+	aws := &AWS{client: server.Client(), getenv: func(name string) string { return env[name] }}
+	ctx := context.Background()
+
+	got, err := aws.SecretsManager().Fetch(ctx, "arn:aws:secretsmanager:eu-west-1:123456789012:secret:portus-AbCdEf#openai")
+	if err != nil || got != "sk-from-sm" {
+		t.Errorf("SecretsManager = %q, %v", got, err)
+	}
+	got, err = aws.ParameterStore().Fetch(ctx, "/portus/anthropic")
+	if err != nil || got != "sk-from-ssm" {
+		t.Errorf("ParameterStore = %q, %v", got, err)
+	}
+	_, err = aws.SecretsManager().Fetch(ctx, "missing")
+	if err == nil || !strings.Contains(err.Error(), "ResourceNotFoundException") {
+		t.Errorf("expected ResourceNotFoundException, got %v", err)
+	}
+
+	delete(env, "AWS_REGION")
+	if _, err := aws.ParameterStore().Fetch(ctx, "/portus/anthropic"); err == nil || !strings.Contains(err.Error(), "AWS_REGION") {
+		t.Errorf("expected a missing region error, got %v", err)
+	}
+}
+
+func TestAWS_InstanceCredentials(t *testing.T) {
+	t.Parallel()
+
+	server := fakeAWS(t, "AKIDINSTANCE")
+	tokenRequests := 0
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			tokenRequests++
+			w.Write([]byte("imds-token"))
+		case r.Header.Get("X-Aws-Ec2-Metadata-Token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("portus-role\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/portus-role":
+			w.Write([]byte(`{"AccessKeyId":"AKIDINSTANCE","SecretAccessKey":"secret","Token":"session","Expiration":"2999-01-01T00:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(imds.Close)
+
+	env := map[string]string{"AWS_REGION": "us-east-1", "AWS_ENDPOINT_URL": server.URL}
+	aws := &AWS{client: server.Client(), getenv: func(name string) string { return env[name] }, imds: imds.URL}
+	for range 2 {
+		if got, err := aws.ParameterStore().Fetch(context.Background(), "/portus/anthropic"); err != nil || got != "sk-from-ssm" {
+			t.Fatalf("ParameterStore = %q, %v", got, err)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("expected instance credentials to be cached, got %d token requests", tokenRequests)
+	}
+}
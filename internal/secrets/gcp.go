@@ -0,0 +1,326 @@
+package secrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Google API endpoints.
+const (
+	secretManagerEndpoint = "https://secretmanager.googleapis.com"
+	googleTokenEndpoint   = "https://oauth2.googleapis.com/token"
+	gceMetadataHost       = "metadata.google.internal"
+	cloudPlatformScope    = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// GCP fetches secrets from Google Cloud Secret Manager. Its access tokens come
+// from Application Default Credentials: the GOOGLE_APPLICATION_CREDENTIALS
+// file, the gcloud user credentials file, then the GCE or GKE metadata server.
+type GCP struct {
+	client *http.Client
+	getenv func(string) string
+	// endpoint is the Secret Manager API and tokenEndpoint the OAuth token
+	// endpoint of user credentials, replaced in tests.
+	endpoint      string
+	tokenEndpoint string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewGCP creates a GCP secret client configured from the environment.
+func NewGCP() *GCP {
+	return &GCP{
+		client:        &http.Client{Timeout: 10 * time.Second},
+		getenv:        os.Getenv,
+		endpoint:      secretManagerEndpoint,
+		tokenEndpoint: googleTokenEndpoint,
+	}
+}
+
+// SecretManager returns the source for "${gcpsm:<secret>}" references. The
+// secret is "projects/<project>/secrets/<name>", reading the latest version,
+// or a full version name ending in "/versions/<version>". Like "${awssm:...}"
+// it may be followed by "#<key>" to select a field of a JSON secret.
+func (g *GCP) SecretManager() Source {
+	return SourceFunc(func(ctx context.Context, ref string) (string, error) {
+		name, key, _ := strings.Cut(ref, "#")
+		parts := strings.Split(name, "/")
+		switch {
+		case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+			name += "/versions/latest"
+		case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+		default:
+			return "", fmt.Errorf("invalid secret name %q: want projects/<project>/secrets/<secret>[/versions/<version>]", name)
+		}
+
+		token, err := g.accessToken(ctx)
+		if err != nil {
+			return "", err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.endpoint+"/v1/"+name+":access", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := g.client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", googleError("AccessSecretVersion", resp.StatusCode, data)
+		}
+
+		var out struct {
+			Payload struct {
+				Data       []byte `json:"data"`
+				DataCrc32c string `json:"dataCrc32c"`
+			} `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &out); err != nil {
+			return "", fmt.Errorf("AccessSecretVersion: %w", err)
+		}
+		if out.Payload.DataCrc32c != "" {
+			want, err := strconv.ParseUint(out.Payload.DataCrc32c, 10, 32)
+			if err != nil || crc32.Checksum(out.Payload.Data, crc32.MakeTable(crc32.Castagnoli)) != uint32(want) {
+				return "", errors.New("AccessSecretVersion: payload checksum mismatch")
+			}
+		}
+		return jsonField(string(out.Payload.Data), key)
+	})
+}
+
+// googleError describes a failed Google API call from its error body.
+func googleError(call string, status int, data []byte) error {
+	var apiErr struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		} `json:"error"`
+	}
+	json.Unmarshal(data, &apiErr)
+	if apiErr.Error.Status == "" {
+		return fmt.Errorf("%s returned %d", call, status)
+	}
+	return fmt.Errorf("%s: %s: %s", call, apiErr.Error.Status, apiErr.Error.Message)
+}
+
+// credentialsFile is a Google credentials JSON file, either a service account
+// key or gcloud user credentials.
+type credentialsFile struct {
+	Type string `json:"type"`
+
+	// Service account keys
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+
+	// gcloud user credentials
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// AccessToken returns an access token with the cloud-platform scope, for
+// callers making their own Google API requests.
+func (g *GCP) AccessToken(ctx context.Context) (string, error) {
+	return g.accessToken(ctx)
+}
+
+// accessToken returns the current access token, fetching a new one when there
+// is none or it is about to expire.
+func (g *GCP) accessToken(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Until(g.expires) > credentialRefreshMargin {
+		return g.token, nil
+	}
+
+	var token string
+	var expires time.Time
+	var err error
+	if path := g.credentialsPath(); path != "" {
+		token, expires, err = g.fileToken(ctx, path)
+	} else {
+		token, expires, err = g.metadataToken(ctx)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get Google credentials: %w", err)
+	}
+	g.token, g.expires = token, expires
+	return token, nil
+}
+
+// credentialsPath returns the credentials file to use: the one named by
+// GOOGLE_APPLICATION_CREDENTIALS, else gcloud's application default
+// credentials if they exist, else "" for the metadata server.
+func (g *GCP) credentialsPath() string {
+	if path := g.getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return path
+	}
+	dir := g.getenv("CLOUDSDK_CONFIG")
+	if dir == "" {
+		home := g.getenv("HOME")
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".config", "gcloud")
+	}
+	path := filepath.Join(dir, "application_default_credentials.json")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// fileToken exchanges a credentials file for an access token.
+func (g *GCP) fileToken(ctx context.Context, path string) (string, time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var creds credentialsFile
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	tokenURI := creds.TokenURI
+	if tokenURI == "" {
+		tokenURI = g.tokenEndpoint
+	}
+	var form url.Values
+	switch creds.Type {
+	case "service_account":
+		assertion, err := signJWT(creds, tokenURI, time.Now())
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("%s: %w", path, err)
+		}
+		form = url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+	case "authorized_user":
+		form = url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		}
+	default:
+		return "", time.Time{}, fmt.Errorf("%s: unsupported credentials type %q", path, creds.Type)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return g.tokenResponse(req)
+}
+
+// metadataToken fetches the attached service account's access token from the
+// GCE metadata server, which GKE Workload Identity also serves.
+func (g *GCP) metadataToken(ctx context.Context) (string, time.Time, error) {
+	host := g.getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = gceMetadataHost
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	token, expires, err := g.tokenResponse(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("no credentials file and the metadata server is unavailable: %w", err)
+	}
+	return token, expires, nil
+}
+
+// tokenResponse performs an OAuth token request.
+func (g *GCP) tokenResponse(req *http.Request) (string, time.Time, error) {
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var out struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	json.Unmarshal(data, &out)
+	if resp.StatusCode != http.StatusOK || out.AccessToken == "" {
+		if out.Error != "" {
+			return "", time.Time{}, fmt.Errorf("token request failed: %s: %s", out.Error, out.ErrorDescription)
+		}
+		return "", time.Time{}, fmt.Errorf("token request returned %d", resp.StatusCode)
+	}
+	return out.AccessToken, time.Now().Add(time.Duration(out.ExpiresIn) * time.Second), nil
+}
+
+// signJWT creates the RS256-signed assertion a service account exchanges for
+// an access token.
+func signJWT(creds credentialsFile, audience string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", errors.New("service account private key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return "", fmt.Errorf("invalid service account private key: %w", err)
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("service account private key is not RSA")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": creds.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   creds.ClientEmail,
+		"scope": cloudPlatformScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,200 @@
+package secrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSecretManager serves AccessSecretVersion for two secrets, requiring the
+// given access token.
+func fakeSecretManager(t *testing.T, wantToken string) *httptest.Server {
+	t.Helper()
+	payloads := map[string]string{
+		"/v1/projects/p/secrets/openai/versions/latest:access": "sk-latest",
+		"/v1/projects/p/secrets/openai/versions/3:access":      "sk-v3",
+		"/v1/projects/p/secrets/keys/versions/latest:access":   `{"anthropic":"sk-ant"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+wantToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":401,"message":"Request had invalid authentication credentials.","status":"UNAUTHENTICATED"}}`))
+			return
+		}
+		switch payload, ok := payloads[r.URL.Path]; {
+		case ok:
+			checksum := crc32.Checksum([]byte(payload), crc32.MakeTable(crc32.Castagnoli))
+			json.NewEncoder(w).Encode(map[string]any{
+				"payload": map[string]any{"data": []byte(payload), "dataCrc32c": fmt.Sprint(checksum)},
+			})
+		case r.URL.Path == "/v1/projects/p/secrets/corrupt/versions/latest:access":
+			w.Write([]byte(`{"payload":{"data":"c2stbGl2ZQ==","dataCrc32c":"1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"Secret [projects/p/secrets/missing] not found or has no versions.","status":"NOT_FOUND"}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGCP_SecretManager(t *testing.T) {
+	t.Parallel()
+
+	server := fakeSecretManager(t, "metadata-token")
+	metadataRequests := 0
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		metadataRequests++
+		w.Write([]byte(`{"access_token":"metadata-token","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	t.Cleanup(metadata.Close)
+
+	env := map[string]string{"GCE_METADATA_HOST": strings.TrimPrefix(metadata.URL, "http://")}
+	gcp := &GCP{client: server.Client(), getenv: func(name string) string { return env[name] }, endpoint: server.URL}
+	source := gcp.SecretManager()
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{ref: "projects/p/secrets/openai", want: "sk-latest"},
+		{ref: "projects/p/secrets/openai/versions/3", want: "sk-v3"},
+		{ref: "projects/p/secrets/keys#anthropic", want: "sk-ant"},
+		{ref: "projects/p/secrets/missing", wantErr: "NOT_FOUND: Secret [projects/p/secrets/missing] not found"},
+		{ref: "projects/p/secrets/corrupt", wantErr: "checksum mismatch"},
+		{ref: "openai", wantErr: "invalid secret name"},
+	}
+	for _, tt := range tests {
+		got, err := source.Fetch(context.Background(), tt.ref)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Fetch(%s) error = %v, want %q", tt.ref, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Fetch(%s) = %q, %v; want %q", tt.ref, got, err, tt.want)
+		}
+	}
+	if metadataRequests != 1 {
+		t.Errorf("expected the access token to be cached, got %d metadata requests", metadataRequests)
+	}
+}
+
+func TestGCP_CredentialsFiles(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token endpoint checks the service account's signed assertion or the
+	// user's refresh token
+	var tokenURL string
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("grant_type") {
+		case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+			parts := strings.Split(r.Form.Get("assertion"), ".")
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"Invalid JWT Signature."}`))
+				return
+			}
+			var claims map[string]any
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			json.Unmarshal(payload, &claims)
+			if claims["iss"] != "portus@p.iam.gserviceaccount.com" || claims["aud"] != tokenURL || claims["scope"] != cloudPlatformScope {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"Invalid claims."}`))
+				return
+			}
+			w.Write([]byte(`{"access_token":"service-account-token","expires_in":3599}`))
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`))
+				return
+			}
+			w.Write([]byte(`{"access_token":"user-token","expires_in":3599}`))
+		}
+	}))
+	t.Cleanup(tokens.Close)
+	tokenURL = tokens.URL + "/token"
+
+	dir := t.TempDir()
+	writeJSON := func(name string, v any) string {
+		data, _ := json.Marshal(v)
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	serviceAccount := writeJSON("sa.json", map[string]string{
+		"type":           "service_account",
+		"client_email":   "portus@p.iam.gserviceaccount.com",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"private_key_id": "key-1",
+		"token_uri":      tokenURL,
+	})
+	gcloudDir := filepath.Join(dir, "gcloud")
+	os.Mkdir(gcloudDir, 0o755)
+	data, _ := json.Marshal(map[string]string{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "refresh"})
+	if err := os.WriteFile(filepath.Join(gcloudDir, "application_default_credentials.json"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantToken string
+		wantErr   string
+	}{
+		{name: "service account key", env: map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": serviceAccount}, wantToken: "service-account-token"},
+		{name: "gcloud user credentials", env: map[string]string{"CLOUDSDK_CONFIG": gcloudDir}, wantToken: "user-token"},
+		{name: "unsupported type", env: map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": writeJSON("ext.json", map[string]string{"type": "external_account"})}, wantErr: `unsupported credentials type "external_account"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			gcp := &GCP{client: tokens.Client(), getenv: func(name string) string { return tt.env[name] }, tokenEndpoint: tokenURL}
+			token, err := gcp.accessToken(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("accessToken() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || token != tt.wantToken {
+				t.Errorf("accessToken() = %q, %v; want %q", token, err, tt.wantToken)
+			}
+		})
+	}
+}
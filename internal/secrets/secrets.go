@@ -0,0 +1,149 @@
+// Package secrets resolves ${scheme:reference} placeholders in config files
+// from external secret stores, so provider credentials never need to pass
+// through the environment. Values are fetched once at load time and can be
+// refreshed periodically to pick up rotations.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Source fetches the value of a secret reference.
+type Source interface {
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func(ctx context.Context, ref string) (string, error)
+
+// Fetch calls f.
+func (f SourceFunc) Fetch(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// referenceRegex matches ${scheme:reference}. Environment variable names
+// cannot contain a colon, so references never collide with ${VAR}.
+var referenceRegex = regexp.MustCompile(`\$\{([a-z][a-z0-9]*):([^}]+)\}`)
+
+// Resolver expands secret references using a Source per scheme. It caches
+// every value it has fetched. It is safe for concurrent use.
+type Resolver struct {
+	sources map[string]Source
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// NewResolver creates a resolver for the given sources, keyed by scheme.
+func NewResolver(sources map[string]Source) *Resolver {
+	return &Resolver{sources: sources, values: make(map[string]string)}
+}
+
+// Expand replaces the references in a JSON document with their values,
+// escaped for use inside JSON strings. A reference to a scheme without a
+// source is an error. Values fetched before are reused; Refresh updates them.
+func (r *Resolver) Expand(ctx context.Context, content string) (string, error) {
+	var firstErr error
+	expanded := referenceRegex.ReplaceAllStringFunc(content, func(match string) string {
+		parts := referenceRegex.FindStringSubmatch(match)
+		if firstErr != nil {
+			return match
+		}
+		source, ok := r.sources[parts[1]]
+		if !ok {
+			firstErr = fmt.Errorf("unknown secret source %q in %s", parts[1], match)
+			return match
+		}
+		key := parts[1] + ":" + parts[2]
+		r.mu.Lock()
+		value, cached := r.values[key]
+		r.mu.Unlock()
+		if !cached {
+			var err error
+			if value, err = source.Fetch(ctx, parts[2]); err != nil {
+				firstErr = fmt.Errorf("failed to resolve %s: %w", match, err)
+				return match
+			}
+			r.mu.Lock()
+			r.values[key] = value
+			r.mu.Unlock()
+		}
+		// Strings always marshal; the quotes are already in the document
+		encoded, _ := json.Marshal(value)
+		return string(encoded[1 : len(encoded)-1])
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return expanded, nil
+}
+
+// Refresh fetches every value Expand has resolved again and reports whether
+// any changed. A reference that fails keeps its previous value; the first
+// error is returned after the others are refreshed.
+func (r *Resolver) Refresh(ctx context.Context) (changed bool, err error) {
+	r.mu.Lock()
+	keys := make([]string, 0, len(r.values))
+	for key := range r.values {
+		keys = append(keys, key)
+	}
+	r.mu.Unlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		scheme, ref, _ := strings.Cut(key, ":")
+		value, fetchErr := r.sources[scheme].Fetch(ctx, ref)
+		if fetchErr != nil {
+			if err == nil {
+				err = fmt.Errorf("failed to refresh ${%s}: %w", key, fetchErr)
+			}
+			continue
+		}
+		r.mu.Lock()
+		if r.values[key] != value {
+			r.values[key] = value
+			changed = true
+		}
+		r.mu.Unlock()
+	}
+	return changed, err
+}
+
+// Values returns every resolved value, so they can be redacted from logs.
+func (r *Resolver) Values() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	values := make([]string, 0, len(r.values))
+	for _, value := range r.values {
+		values = append(values, value)
+	}
+	return values
+}
+
+// jsonField returns the value of key in a JSON object secret, for references
+// of the form "<secret>#<key>". A reference without a key returns the secret
+// unchanged.
+func jsonField(secret, key string) (string, error) {
+	if key == "" {
+		return secret, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, so it has no key %q", key)
+	}
+	switch v := fields[key].(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("secret has no key %q", key)
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded), nil
+	}
+}
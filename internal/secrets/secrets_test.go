@@ -0,0 +1,120 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeSource serves values from a map, counting fetches.
+type fakeSource struct {
+	values  map[string]string
+	fetches int
+}
+
+func (f *fakeSource) Fetch(_ context.Context, ref string) (string, error) {
+	f.fetches++
+	value, ok := f.values[ref]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return value, nil
+}
+
+func TestResolver_Expand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{name: "reference", content: `{"api_key": "${test:openai}"}`, want: `{"api_key": "sk-live"}`},
+		{name: "value is escaped for JSON", content: `{"api_key": "${test:quoted}"}`, want: `{"api_key": "a\"b\\c"}`},
+		{name: "environment variables are left alone", content: `{"api_key": "${OPENAI_API_KEY}"}`, want: `{"api_key": "${OPENAI_API_KEY}"}`},
+		{name: "unknown scheme", content: `{"api_key": "${vault:openai}"}`, wantErr: true},
+		{name: "missing secret", content: `{"api_key": "${test:missing}"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			source := &fakeSource{values: map[string]string{"openai": "sk-live", "quoted": `a"b\c`}}
+			resolver := NewResolver(map[string]Source{"test": source})
+
+			got, err := resolver.Expand(context.Background(), tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Expand() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_Refresh(t *testing.T) {
+	t.Parallel()
+
+	source := &fakeSource{values: map[string]string{"openai": "sk-1"}}
+	resolver := NewResolver(map[string]Source{"test": source})
+	ctx := context.Background()
+
+	for range 2 {
+		if _, err := resolver.Expand(ctx, `"${test:openai}"`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if source.fetches != 1 {
+		t.Errorf("expected the value to be fetched once, got %d fetches", source.fetches)
+	}
+
+	if changed, err := resolver.Refresh(ctx); changed || err != nil {
+		t.Errorf("Refresh() = %v, %v; want unchanged", changed, err)
+	}
+	source.values["openai"] = "sk-2"
+	if changed, err := resolver.Refresh(ctx); !changed || err != nil {
+		t.Errorf("Refresh() = %v, %v; want changed", changed, err)
+	}
+	if got, _ := resolver.Expand(ctx, `"${test:openai}"`); got != `"sk-2"` {
+		t.Errorf("expected the refreshed value, got %s", got)
+	}
+
+	// A failed refresh keeps the last value
+	delete(source.values, "openai")
+	if _, err := resolver.Refresh(ctx); err == nil {
+		t.Error("expected the failed refresh to be reported")
+	}
+	if values := resolver.Values(); len(values) != 1 || values[0] != "sk-2" {
+		t.Errorf("expected the previous value to be kept, got %v", values)
+	}
+}
+
+func TestJSONField(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		secret  string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{name: "whole secret", secret: "sk-live", want: "sk-live"},
+		{name: "string field", secret: `{"openai":"sk-live","port":5432}`, key: "openai", want: "sk-live"},
+		{name: "number field", secret: `{"openai":"sk-live","port":5432}`, key: "port", want: "5432"},
+		{name: "missing field", secret: `{"openai":"sk-live"}`, key: "anthropic", wantErr: true},
+		{name: "not JSON", secret: "sk-live", key: "openai", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := jsonField(tt.secret, tt.key)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("jsonField() = %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Package semcache caches model responses by prompt meaning: prompts are
+// embedded and a cached response is reused when a new prompt's embedding is
+// close enough to a previous one.
+package semcache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/amscotti/portus/internal/storage"
+)
+
+// Embedder turns text into an embedding vector.
+type Embedder func(ctx context.Context, text string) ([]float64, error)
+
+// Entry is a cached response.
+type Entry struct {
+	Body        []byte
+	ContentType string
+	StoredAt    time.Time
+}
+
+// Store holds embedded entries and finds the nearest one. Scopes partition
+// entries so responses are never shared across them. Implementations must be
+// safe for concurrent use.
+type Store interface {
+	// Nearest returns the entry in scope most similar to vector.
+	Nearest(scope string, vector []float64) (entry Entry, similarity float64, ok bool)
+	// Add stores an entry under scope.
+	Add(scope string, vector []float64, entry Entry)
+}
+
+// Match is the outcome of a lookup. Vector is the prompt's embedding, to be
+// passed to Add when the lookup missed.
+type Match struct {
+	Vector     []float64
+	Entry      Entry
+	Similarity float64
+	Hit        bool
+}
+
+// Cache pairs an embedder with a store. A nil Cache never hits.
+type Cache struct {
+	embed     Embedder
+	store     Store
+	threshold float64
+}
+
+// New creates a cache that hits when cosine similarity is at least threshold.
+func New(embed Embedder, store Store, threshold float64) *Cache {
+	return &Cache{embed: embed, store: store, threshold: threshold}
+}
+
+// Lookup embeds prompt and returns the closest cached entry in scope.
+func (c *Cache) Lookup(ctx context.Context, scope, prompt string) (Match, error) {
+	if c == nil {
+		return Match{}, nil
+	}
+	vector, err := c.embed(ctx, prompt)
+	if err != nil {
+		return Match{}, err
+	}
+	match := Match{Vector: vector}
+	if entry, similarity, ok := c.store.Nearest(scope, vector); ok {
+		match.Similarity = similarity
+		if similarity >= c.threshold {
+			match.Entry = entry
+			match.Hit = true
+		}
+	}
+	return match, nil
+}
+
+// Add caches a response for a previously looked-up prompt.
+func (c *Cache) Add(scope string, vector []float64, entry Entry) {
+	if c == nil || len(vector) == 0 {
+		return
+	}
+	c.store.Add(scope, vector, entry)
+}
+
+// Cosine returns the cosine similarity of two vectors, or 0 if their lengths
+// differ or either is zero.
+func Cosine(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Memory is an in-process Store with a size bound and expiry. Lookups scan
+// every live entry in the scope, which is fast for the few thousand entries a
+// single replica holds.
+type Memory struct {
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries []memoryEntry // oldest first
+}
+
+type memoryEntry struct {
+	scope  string
+	vector []float64
+	entry  Entry
+}
+
+// NewMemory creates a store holding at most maxEntries entries, each for ttl.
+// The oldest entries are evicted first.
+func NewMemory(maxEntries int, ttl time.Duration) *Memory {
+	return &Memory{maxEntries: maxEntries, ttl: ttl, now: time.Now}
+}
+
+// Nearest implements Store.
+func (m *Memory) Nearest(scope string, vector []float64) (Entry, float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := m.now().Add(-m.ttl)
+	var best Entry
+	bestSimilarity := math.Inf(-1)
+	for _, e := range m.entries {
+		if e.scope != scope || e.entry.StoredAt.Before(cutoff) {
+			continue
+		}
+		if similarity := Cosine(vector, e.vector); similarity > bestSimilarity {
+			best, bestSimilarity = e.entry, similarity
+		}
+	}
+	if math.IsInf(bestSimilarity, -1) {
+		return Entry{}, 0, false
+	}
+	return best, bestSimilarity, true
+}
+
+// Add implements Store.
+func (m *Memory) Add(scope string, vector []float64, entry Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry.StoredAt.IsZero() {
+		entry.StoredAt = m.now()
+	}
+
+	// Drop expired entries, then the oldest ones beyond the bound
+	cutoff := m.now().Add(-m.ttl)
+	live := m.entries[:0]
+	for _, e := range m.entries {
+		if !e.entry.StoredAt.Before(cutoff) {
+			live = append(live, e)
+		}
+	}
+	live = append(live, memoryEntry{scope: scope, vector: vector, entry: entry})
+	if excess := len(live) - m.maxEntries; m.maxEntries > 0 && excess > 0 {
+		live = append(live[:0], live[excess:]...)
+	}
+	m.entries = live
+}
+
+// Len returns the number of stored entries, including expired ones not yet
+// pruned.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// Shared is a Store kept in a storage backend, so replicas sharing the
+// backend share cached responses. Entries expire after the TTL rather than
+// being bounded in number. Lookups fetch every entry in the scope, and backend
+// errors count as misses.
+type Shared struct {
+	backend storage.Backend
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// sharedEntry is the stored form of an entry.
+type sharedEntry struct {
+	Vector      []float64 `json:"vector"`
+	Body        []byte    `json:"body"`
+	ContentType string    `json:"content_type"`
+	StoredAt    time.Time `json:"stored_at"`
+}
+
+// NewShared creates a store holding entries in backend, each for ttl.
+func NewShared(backend storage.Backend, ttl time.Duration) *Shared {
+	return &Shared{backend: backend, ttl: ttl, now: time.Now}
+}
+
+// Nearest implements Store.
+func (s *Shared) Nearest(scope string, vector []float64) (Entry, float64, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	values, err := s.backend.Scan(ctx, storage.Prefix("semcache", scope))
+	if err != nil {
+		return Entry{}, 0, false
+	}
+	cutoff := s.now().Add(-s.ttl)
+	var best Entry
+	bestSimilarity := math.Inf(-1)
+	for _, value := range values {
+		var e sharedEntry
+		if json.Unmarshal(value, &e) != nil || e.StoredAt.Before(cutoff) {
+			continue
+		}
+		if similarity := Cosine(vector, e.Vector); similarity > bestSimilarity {
+			best = Entry{Body: e.Body, ContentType: e.ContentType, StoredAt: e.StoredAt}
+			bestSimilarity = similarity
+		}
+	}
+	if math.IsInf(bestSimilarity, -1) {
+		return Entry{}, 0, false
+	}
+	return best, bestSimilarity, true
+}
+
+// Add implements Store.
+func (s *Shared) Add(scope string, vector []float64, entry Entry) {
+	if entry.StoredAt.IsZero() {
+		entry.StoredAt = s.now()
+	}
+	value, err := json.Marshal(sharedEntry{
+		Vector:      vector,
+		Body:        entry.Body,
+		ContentType: entry.ContentType,
+		StoredAt:    entry.StoredAt,
+	})
+	if err != nil {
+		return
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+	s.backend.Set(ctx, storage.Key("semcache", scope, hex.EncodeToString(id)), value, s.ttl)
+}
@@ -0,0 +1,133 @@
+package semcache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/storage"
+)
+
+func TestCosine(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		{"identical", []float64{1, 2, 3}, []float64{1, 2, 3}, 1},
+		{"scaled", []float64{1, 2, 3}, []float64{2, 4, 6}, 1},
+		{"orthogonal", []float64{1, 0}, []float64{0, 1}, 0},
+		{"opposite", []float64{1, 0}, []float64{-1, 0}, -1},
+		{"length mismatch", []float64{1, 0}, []float64{1}, 0},
+		{"zero vector", []float64{0, 0}, []float64{1, 0}, 0},
+	}
+	for _, tt := range tests {
+		if got := Cosine(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: Cosine = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCache_Lookup(t *testing.T) {
+	t.Parallel()
+
+	vectors := map[string][]float64{
+		"what is the refund policy?":        {1, 0.1, 0},
+		"what's the refund policy?":         {1, 0.12, 0},
+		"how do I reset my password?":       {0, 1, 0.2},
+		"embedding service is unavailable!": nil,
+	}
+	embed := func(_ context.Context, text string) ([]float64, error) {
+		if vectors[text] == nil {
+			return nil, errors.New("unavailable")
+		}
+		return vectors[text], nil
+	}
+	cache := New(embed, NewMemory(10, time.Hour), 0.95)
+	ctx := context.Background()
+
+	match, err := cache.Lookup(ctx, "app/gpt4", "what is the refund policy?")
+	if err != nil || match.Hit {
+		t.Fatalf("expected miss on empty cache, got %+v, %v", match, err)
+	}
+	cache.Add("app/gpt4", match.Vector, Entry{Body: []byte(`{"answer":"30 days"}`)})
+
+	match, _ = cache.Lookup(ctx, "app/gpt4", "what's the refund policy?")
+	if !match.Hit || string(match.Entry.Body) != `{"answer":"30 days"}` {
+		t.Errorf("expected near-duplicate to hit, got %+v", match)
+	}
+	if match, _ := cache.Lookup(ctx, "app/gpt4", "how do I reset my password?"); match.Hit {
+		t.Errorf("expected unrelated prompt to miss, similarity %v", match.Similarity)
+	}
+	if match, _ := cache.Lookup(ctx, "other/gpt4", "what is the refund policy?"); match.Hit {
+		t.Error("expected entries not to be shared across scopes")
+	}
+	if _, err := cache.Lookup(ctx, "app/gpt4", "embedding service is unavailable!"); err == nil {
+		t.Error("expected embedding error to be returned")
+	}
+
+	var nilCache *Cache
+	if match, err := nilCache.Lookup(ctx, "app/gpt4", "anything"); match.Hit || err != nil {
+		t.Error("expected nil cache to miss")
+	}
+}
+
+func TestMemory_Eviction(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory(2, time.Hour)
+	m.now = func() time.Time { return now }
+
+	m.Add("s", []float64{1, 0}, Entry{Body: []byte("a")})
+	m.Add("s", []float64{0, 1}, Entry{Body: []byte("b")})
+	m.Add("s", []float64{1, 1}, Entry{Body: []byte("c")})
+	if m.Len() != 2 {
+		t.Fatalf("expected size bound of 2, got %d", m.Len())
+	}
+	if entry, similarity, _ := m.Nearest("s", []float64{1, 0}); string(entry.Body) == "a" || similarity > 0.99 {
+		t.Error("expected oldest entry to be evicted")
+	}
+
+	// Entries expire after the TTL
+	now = now.Add(2 * time.Hour)
+	if _, _, ok := m.Nearest("s", []float64{1, 1}); ok {
+		t.Error("expected expired entries to be ignored")
+	}
+	m.Add("s", []float64{1, 0}, Entry{Body: []byte("d")})
+	if m.Len() != 1 {
+		t.Errorf("expected expired entries to be pruned on add, got %d", m.Len())
+	}
+}
+
+func TestShared(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	backend := storage.NewMemory()
+	first, second := NewShared(backend, time.Hour), NewShared(backend, time.Hour)
+	first.now = func() time.Time { return now }
+	second.now = func() time.Time { return now }
+
+	first.Add("s", []float64{1, 0}, Entry{Body: []byte("a"), ContentType: "application/json"})
+	first.Add("s", []float64{0, 1}, Entry{Body: []byte("b")})
+	first.Add("other", []float64{1, 0}, Entry{Body: []byte("c")})
+
+	// Entries added on one replica are found on another, within their scope
+	entry, similarity, ok := second.Nearest("s", []float64{1, 0.1})
+	if !ok || string(entry.Body) != "a" || entry.ContentType != "application/json" || similarity < 0.99 {
+		t.Errorf("unexpected nearest entry %q (%s), similarity %v, ok %v", entry.Body, entry.ContentType, similarity, ok)
+	}
+	if _, _, ok := second.Nearest("missing", []float64{1, 0}); ok {
+		t.Error("expected no entries in an empty scope")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, _, ok := second.Nearest("s", []float64{1, 0}); ok {
+		t.Error("expected expired entries to be ignored")
+	}
+}
@@ -0,0 +1,191 @@
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"strconv"
+)
+
+// MinSpilledValue is the shortest base64 run a skeleton leaves on disk;
+// shorter runs cost less to keep in memory than to splice back in.
+const MinSpilledValue = 4 << 10
+
+// maxDataURLPrefix bounds how far into a string the ";base64," of a data:
+// URL is looked for.
+const maxDataURLPrefix = 256
+
+// span is a run of a spilled body left out of its skeleton.
+type span struct {
+	off, n int64
+}
+
+// Skeleton returns a JSON body for the request stages to read and rewrite
+// while the body itself stays on disk. The base64 payload of each data: URL,
+// and each base64 string under a "data" field (Anthropic and Gemini inline
+// sources, OpenAI input_audio), is replaced by a short placeholder when it is
+// at least MinSpilledValue bytes long, so multimodal payloads cost memory only
+// for their text. Text fields are kept whole for the stages that inspect
+// them. Expand splices the runs back in. A body held in memory is returned as
+// is.
+func (b *Body) Skeleton() ([]byte, error) {
+	if b.file == nil {
+		return b.data, nil
+	}
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	b.token = "portus-spool-" + hex.EncodeToString(nonce[:]) + "-"
+	b.spans = nil
+
+	var skel bytes.Buffer
+	r := bufio.NewReader(io.NewSectionReader(b.file, 0, b.size))
+	var off int64
+	// last is the most recent string, while it can still turn out to be a
+	// key; key is the field whose value comes next
+	var last, key []byte
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			return skel.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		off++
+		skel.WriteByte(c)
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case ':':
+			key, last = last, nil
+			continue
+		case '"':
+		default:
+			key, last = nil, nil
+			continue
+		}
+
+		start := off
+		run, end, head, err := scanString(r, &off)
+		if err == io.EOF {
+			// An unterminated string is left for the JSON decoder to reject
+			if _, err := io.Copy(&skel, io.NewSectionReader(b.file, start, b.size-start)); err != nil {
+				return nil, err
+			}
+			return skel.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		keep := end
+		if run >= 0 && end-run >= MinSpilledValue && (run > start || string(key) == "data") {
+			keep = run
+		}
+		if _, err := io.Copy(&skel, io.NewSectionReader(b.file, start, keep-start)); err != nil {
+			return nil, err
+		}
+		if keep != end {
+			skel.WriteString(b.token + strconv.Itoa(len(b.spans)))
+			b.spans = append(b.spans, span{off: run, n: end - run})
+		}
+		skel.WriteByte('"')
+		key, last = nil, nil
+		if end-start <= maxDataURLPrefix {
+			last = head
+		}
+	}
+}
+
+// scanString reads a JSON string up to and including its closing quote,
+// advancing *off past it. It returns the offset of the closing quote, the
+// string's first bytes, and, when the string ends in an unbroken base64 run,
+// the offset the run starts at; run is -1 otherwise. The run is the whole
+// string, or the payload of a base64 data: URL.
+func scanString(r *bufio.Reader, off *int64) (run, end int64, head []byte, err error) {
+	run = *off
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return -1, 0, nil, err
+		}
+		*off++
+		switch {
+		case c == '"':
+			return run, *off - 1, head, nil
+		case c == '\\':
+			// JSON encoders may escape the base64 alphabet's '/'
+			e, err := r.ReadByte()
+			if err != nil {
+				return -1, 0, nil, err
+			}
+			*off++
+			if e != '/' {
+				run = -1
+			}
+			head = appendHead(head, c, e)
+		case !isBase64(c):
+			run = -1
+			head = appendHead(head, c)
+		default:
+			head = appendHead(head, c)
+		}
+		if len(head) <= maxDataURLPrefix && bytes.HasPrefix(head, []byte("data:")) && bytes.HasSuffix(head, []byte(";base64,")) {
+			run = *off
+		}
+	}
+}
+
+// appendHead appends c to the head of a string, up to one byte past
+// maxDataURLPrefix.
+func appendHead(head []byte, c ...byte) []byte {
+	if len(head) > maxDataURLPrefix {
+		return head
+	}
+	return append(head, c...)
+}
+
+// isBase64 reports whether c belongs to the standard or URL-safe base64
+// alphabets.
+func isBase64(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+		c == '+' || c == '/' || c == '=' || c == '-' || c == '_'
+}
+
+// Expand returns a reader over skel, a skeleton from Skeleton as rewritten by
+// the request stages, with each placeholder replaced by the run it stands
+// for, and the reader's length. Runs are read from the temporary file, so
+// the reader must be consumed before Close.
+func (b *Body) Expand(skel []byte) (io.ReadCloser, int64) {
+	var parts []io.Reader
+	var size int64
+	rest := skel
+	for b.token != "" {
+		i := bytes.Index(rest, []byte(b.token))
+		if i < 0 {
+			break
+		}
+		j := i + len(b.token)
+		k := j
+		for k < len(rest) && '0' <= rest[k] && rest[k] <= '9' {
+			k++
+		}
+		n, err := strconv.Atoi(string(rest[j:k]))
+		if err != nil || n >= len(b.spans) {
+			parts = append(parts, bytes.NewReader(rest[:j]))
+			size += int64(j)
+			rest = rest[j:]
+			continue
+		}
+		s := b.spans[n]
+		parts = append(parts, bytes.NewReader(rest[:i]), io.NewSectionReader(b.file, s.off, s.n))
+		size += int64(i) + s.n
+		rest = rest[k:]
+	}
+	parts = append(parts, bytes.NewReader(rest))
+	size += int64(len(rest))
+	return io.NopCloser(io.MultiReader(parts...)), size
+}
@@ -0,0 +1,155 @@
+package spool
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBody_SkeletonExpand(t *testing.T) {
+	t.Parallel()
+
+	image := strings.Repeat("iVBORw0KGgo+/A==", MinSpilledValue/8)
+	audio := strings.Repeat(`UklGR\/`, MinSpilledValue/4)
+	text := strings.Repeat("a long prompt ", MinSpilledValue/8)
+	word := strings.Repeat("x", 2*MinSpilledValue)
+
+	tests := []struct {
+		name      string
+		body      string
+		wantSpans int
+		wantKept  []string
+	}{
+		{
+			name:      "data URL payload",
+			body:      `{"messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"data:image/png;base64,` + image + `"}}]}]}`,
+			wantSpans: 1,
+			wantKept:  []string{`"url":"data:image/png;base64,portus-spool-`},
+		},
+		{
+			name:      "base64 data field with escaped slashes",
+			body:      `{"messages":[{"role":"user","content":[{"type":"input_audio","input_audio":{"data":"` + audio + `","format":"wav"}}]}]}`,
+			wantSpans: 1,
+			wantKept:  []string{`"format":"wav"`},
+		},
+		{
+			name:     "long text is kept",
+			body:     `{"messages":[{"role":"user","content":"` + text + `"}]}`,
+			wantKept: []string{text},
+		},
+		{
+			name:     "base64-like text outside a data field is kept",
+			body:     `{"prompt":"` + word + `","data":"` + word[:MinSpilledValue-1] + `"}`,
+			wantKept: []string{word},
+		},
+		{
+			name:     "short data URL is kept",
+			body:     `{"url":"data:image/png;base64,iVBORw0KGgo="}`,
+			wantKept: []string{"iVBORw0KGgo="},
+		},
+		{
+			name:     "escaped quotes do not end strings",
+			body:     `{"content":"say \"data\":","data":"x"}`,
+			wantKept: []string{`say \"data\":`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			body, err := Read(strings.NewReader(tt.body), 16, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer body.Close()
+
+			skel, err := body.Skeleton()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(body.spans) != tt.wantSpans {
+				t.Errorf("spans = %d, want %d", len(body.spans), tt.wantSpans)
+			}
+			if !json.Valid(skel) {
+				t.Errorf("skeleton is not valid JSON: %.200s", skel)
+			}
+			for _, kept := range tt.wantKept {
+				if !bytes.Contains(skel, []byte(kept)) {
+					t.Errorf("skeleton lacks %.60q", kept)
+				}
+			}
+
+			expanded, size := body.Expand(skel)
+			got, err := io.ReadAll(expanded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("Expand() = %.200q, want the original body", got)
+			}
+			if size != int64(len(got)) {
+				t.Errorf("Expand() size = %d, read %d bytes", size, len(got))
+			}
+		})
+	}
+}
+
+func TestBody_ExpandRewrittenSkeleton(t *testing.T) {
+	t.Parallel()
+
+	image := strings.Repeat("AAAA", MinSpilledValue)
+	original := `{"model":"a","max_tokens":9000,"messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + image + `"}}]}]}`
+	body, err := Read(strings.NewReader(original), 16, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	skel, err := body.Skeleton()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skel) > 512 {
+		t.Fatalf("skeleton holds %d bytes, want the image left on disk", len(skel))
+	}
+
+	// A request stage decodes, rewrites and re-encodes the skeleton
+	var req map[string]any
+	if err := json.Unmarshal(skel, &req); err != nil {
+		t.Fatal(err)
+	}
+	req["max_tokens"] = 1000
+	rewritten, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expanded, size := body.Expand(rewritten)
+	got, err := io.ReadAll(expanded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(got)) {
+		t.Errorf("Expand() size = %d, read %d bytes", size, len(got))
+	}
+	var sent struct {
+		MaxTokens int `json:"max_tokens"`
+		Messages  []struct {
+			Content []struct {
+				Source struct {
+					Data string `json:"data"`
+				} `json:"source"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(got, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.MaxTokens != 1000 {
+		t.Errorf("max_tokens = %d, want the rewritten 1000", sent.MaxTokens)
+	}
+	if sent.Messages[0].Content[0].Source.Data != image {
+		t.Error("image data was not restored")
+	}
+}
@@ -0,0 +1,92 @@
+// Package spool buffers request bodies, moving those over a size threshold
+// to a temporary file so concurrent large uploads do not each hold their
+// whole payload in memory.
+package spool
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Body is a buffered request body, held in memory or in a temporary file.
+// Readers from Open are independent, so the body can be sent more than once
+// (retries, hedged attempts). Close removes the temporary file.
+type Body struct {
+	data []byte
+	file *os.File
+	size int64
+	// token prefixes the placeholders of the body's skeleton, and spans
+	// holds the runs they stand for
+	token string
+	spans []span
+}
+
+// Read buffers r. The first threshold bytes are kept in memory; a body larger
+// than that is written to a temporary file in dir (the system default when
+// empty). A threshold of 0 keeps every body in memory.
+func Read(r io.Reader, threshold int64, dir string) (*Body, error) {
+	if threshold <= 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return &Body{data: data, size: int64(len(data))}, nil
+	}
+
+	var head bytes.Buffer
+	n, err := io.CopyN(&head, r, threshold+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n <= threshold {
+		return &Body{data: head.Bytes(), size: n}, nil
+	}
+
+	file, err := os.CreateTemp(dir, "portus-body-*")
+	if err != nil {
+		return nil, err
+	}
+	body := &Body{file: file}
+	if body.size, err = io.Copy(file, io.MultiReader(&head, r)); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return body, nil
+}
+
+// Len returns the body's size in bytes.
+func (b *Body) Len() int64 {
+	return b.size
+}
+
+// Spilled reports whether the body is held in a temporary file.
+func (b *Body) Spilled() bool {
+	return b.file != nil
+}
+
+// Bytes returns the body in memory, reading it back from its temporary file
+// if it was spilled.
+func (b *Body) Bytes() ([]byte, error) {
+	if b.file == nil {
+		return b.data, nil
+	}
+	return io.ReadAll(io.NewSectionReader(b.file, 0, b.size))
+}
+
+// Open returns a reader over the whole body.
+func (b *Body) Open() io.ReadCloser {
+	if b.file == nil {
+		return io.NopCloser(bytes.NewReader(b.data))
+	}
+	return io.NopCloser(io.NewSectionReader(b.file, 0, b.size))
+}
+
+// Close removes the temporary file, if any.
+func (b *Body) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
@@ -0,0 +1,72 @@
+package spool
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		body        string
+		threshold   int64
+		wantSpilled bool
+	}{
+		{name: "under threshold stays in memory", body: "small", threshold: 16},
+		{name: "at threshold stays in memory", body: "exactly16bytes!!", threshold: 16},
+		{name: "over threshold spills", body: strings.Repeat("x", 100), threshold: 16, wantSpilled: true},
+		{name: "zero threshold never spills", body: strings.Repeat("x", 100), threshold: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			body, err := Read(strings.NewReader(tt.body), tt.threshold, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer body.Close()
+
+			if body.Spilled() != tt.wantSpilled {
+				t.Errorf("Spilled() = %v, want %v", body.Spilled(), tt.wantSpilled)
+			}
+			if body.Len() != int64(len(tt.body)) {
+				t.Errorf("Len() = %d, want %d", body.Len(), len(tt.body))
+			}
+			data, err := body.Bytes()
+			if err != nil || string(data) != tt.body {
+				t.Errorf("Bytes() = %q, %v", data, err)
+			}
+			// Readers are independent and can be opened repeatedly
+			for range 2 {
+				got, err := io.ReadAll(body.Open())
+				if err != nil || string(got) != tt.body {
+					t.Errorf("Open() read %q, %v", got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestBody_CloseRemovesFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	body, err := Read(strings.NewReader(strings.Repeat("x", 100)), 10, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("expected one temporary file, found %d", len(entries))
+	}
+	if err := body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected temporary file to be removed, found %d entries", len(entries))
+	}
+}
@@ -0,0 +1,106 @@
+// Package statsd emits request metrics over UDP to a StatsD server or a
+// Datadog agent's DogStatsD listener.
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Wire formats.
+const (
+	// FormatStatsD is plain StatsD, which has no tags.
+	FormatStatsD = "statsd"
+	// FormatDogStatsD appends tags in the DogStatsD "|#key:value" form.
+	FormatDogStatsD = "dogstatsd"
+)
+
+// ParseFormat validates a PORTUS_STATSD_FORMAT value; empty means DogStatsD.
+func ParseFormat(value string) (string, error) {
+	switch value {
+	case "":
+		return FormatDogStatsD, nil
+	case FormatStatsD, FormatDogStatsD:
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown format %q (must be %q or %q)", value, FormatStatsD, FormatDogStatsD)
+}
+
+// Client sends metrics as UDP datagrams, one per metric. Sends are
+// fire-and-forget: a missing agent never slows or fails requests. A nil
+// Client sends nothing.
+type Client struct {
+	conn   net.Conn
+	prefix string
+	tags   bool
+}
+
+// New creates a client sending to addr (host:port). Every metric name is
+// prefixed with prefix.
+func New(addr, prefix, format string) (*Client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, prefix: prefix, tags: format == FormatDogStatsD}, nil
+}
+
+// Count adds value to a counter.
+func (c *Client) Count(name string, value int64, tags ...string) {
+	c.send(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+// Timing records a duration in milliseconds.
+func (c *Client) Timing(name string, d time.Duration, tags ...string) {
+	c.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// Close releases the client's socket.
+func (c *Client) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+func (c *Client) send(name, value, kind string, tags []string) {
+	if c == nil {
+		return
+	}
+	c.conn.Write([]byte(c.line(name, value, kind, tags)))
+}
+
+// line formats one metric, dropping tags in plain StatsD.
+func (c *Client) line(name, value, kind string, tags []string) string {
+	var b strings.Builder
+	b.WriteString(c.prefix)
+	b.WriteString(sanitize(name))
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if c.tags && len(tags) > 0 {
+		b.WriteString("|#")
+		for i, tag := range tags {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(sanitize(tag))
+		}
+	}
+	return b.String()
+}
+
+// sanitize replaces the characters the line protocol reserves.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', ',', '#', '\n', '@':
+			return '_'
+		}
+		return r
+	}, s)
+}
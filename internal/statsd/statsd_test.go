@@ -0,0 +1,97 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		format string
+		send   func(c *Client)
+		want   string
+	}{
+		{
+			name:   "dogstatsd counter with tags",
+			format: FormatDogStatsD,
+			send:   func(c *Client) { c.Count("requests", 1, "application:web", "status:200") },
+			want:   "portus.requests:1|c|#application:web,status:200",
+		},
+		{
+			name:   "statsd drops tags",
+			format: FormatStatsD,
+			send:   func(c *Client) { c.Count("requests", 1, "application:web") },
+			want:   "portus.requests:1|c",
+		},
+		{
+			name:   "timing in milliseconds",
+			format: FormatDogStatsD,
+			send:   func(c *Client) { c.Timing("request.duration", 1500*time.Microsecond) },
+			want:   "portus.request.duration:1.5|ms",
+		},
+		{
+			name:   "reserved characters in tags",
+			format: FormatDogStatsD,
+			send:   func(c *Client) { c.Count("tokens", 42, "model_alias:a|b,c#d") },
+			want:   "portus.tokens:42|c|#model_alias:a_b_c_d",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			client, err := New(listener.LocalAddr().String(), "portus.", tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			tt.send(client)
+
+			buf := make([]byte, 512)
+			listener.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := listener.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(buf[:n]); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Nil(t *testing.T) {
+	t.Parallel()
+
+	var client *Client
+	client.Count("requests", 1)
+	client.Timing("request.duration", time.Second)
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() error: %v", err)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+
+	if got, err := ParseFormat(""); err != nil || got != FormatDogStatsD {
+		t.Errorf("ParseFormat(\"\") = %q, %v", got, err)
+	}
+	if got, err := ParseFormat("statsd"); err != nil || got != FormatStatsD {
+		t.Errorf("ParseFormat(\"statsd\") = %q, %v", got, err)
+	}
+	if _, err := ParseFormat("graphite"); err == nil {
+		t.Error("expected unknown format to be rejected")
+	}
+}
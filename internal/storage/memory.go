@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process Backend. Its state is lost when the process exits
+// and is not shared with other replicas.
+type Memory struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time // zero never expires
+}
+
+// NewMemory creates an empty in-memory backend.
+func NewMemory() *Memory {
+	return &Memory{now: time.Now, entries: make(map[string]memoryEntry)}
+}
+
+// Add implements Backend.
+func (m *Memory) Add(_ context.Context, key string, delta float64, ttl time.Duration) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.add(key, delta, ttl), nil
+}
+
+// AddAll implements Backend.
+func (m *Memory) AddAll(_ context.Context, deltas map[string]float64, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, delta := range deltas {
+		m.add(key, delta, ttl)
+	}
+	return nil
+}
+
+// Get implements Backend.
+func (m *Memory) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.live(key)
+	return e.value, ok, nil
+}
+
+// Set implements Backend.
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = memoryEntry{value: append([]byte(nil), value...), expires: m.expiry(ttl)}
+	return nil
+}
+
+// Expire implements Backend.
+func (m *Memory) Expire(_ context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.live(key); ok {
+		e.expires = m.expiry(ttl)
+		m.entries[key] = e
+	}
+	return nil
+}
+
+// Delete implements Backend.
+func (m *Memory) Delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// Scan implements Backend. Expired entries found on the way are dropped.
+func (m *Memory) Scan(_ context.Context, prefix string) (map[string][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[string][]byte)
+	for key := range m.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if e, ok := m.live(key); ok {
+			result[key] = e.value
+		}
+	}
+	return result, nil
+}
+
+// Close implements Backend.
+func (m *Memory) Close() error { return nil }
+
+// live returns the entry at key unless it has expired, in which case it is
+// removed. The caller must hold m.mu.
+func (m *Memory) live(key string) (memoryEntry, bool) {
+	e, ok := m.entries[key]
+	if ok && !e.expires.IsZero() && !m.now().Before(e.expires) {
+		delete(m.entries, key)
+		return memoryEntry{}, false
+	}
+	return e, ok
+}
+
+// add implements Add. The caller must hold m.mu.
+func (m *Memory) add(key string, delta float64, ttl time.Duration) float64 {
+	e, ok := m.live(key)
+	if !ok {
+		e = memoryEntry{expires: m.expiry(ttl)}
+	}
+	n := Number(e.value) + delta
+	e.value = formatNumber(n)
+	m.entries[key] = e
+	return n
+}
+
+func (m *Memory) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(ttl)
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amscotti/portus/internal/redis"
+)
+
+// redisKeyPrefix namespaces the backend's keys from the other Portus keys in
+// the same Redis database.
+const redisKeyPrefix = "portus:state:"
+
+// scanBatch is the SCAN COUNT hint and the most keys fetched per MGET.
+const scanBatch = 200
+
+// addScript increments a number and sets its expiry only when it created it,
+// so later adds do not extend a window counter or a probe lease.
+const addScript = `local n = redis.call('INCRBYFLOAT', KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call('PTTL', KEYS[1]) == -1 and tonumber(n) == tonumber(ARGV[1]) then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return n`
+
+// addAllScript runs addScript's steps for every key in KEYS, with the expiry
+// in ARGV[1] and the deltas after it, so a batch is applied in one round trip.
+const addAllScript = `local ttl = tonumber(ARGV[1])
+for i, key in ipairs(KEYS) do
+  local n = redis.call('INCRBYFLOAT', key, ARGV[i + 1])
+  if ttl > 0 and redis.call('PTTL', key) == -1 and tonumber(n) == tonumber(ARGV[i + 1]) then
+    redis.call('PEXPIRE', key, ARGV[1])
+  end
+end
+return #KEYS`
+
+// Redis is a Backend shared by every replica using the same Redis database.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis creates a backend storing its keys through client. Closing the
+// backend leaves the client open.
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client}
+}
+
+// Add implements Backend.
+func (r *Redis) Add(ctx context.Context, key string, delta float64, ttl time.Duration) (float64, error) {
+	reply, err := r.client.Do(ctx, "EVAL", addScript, 1, redisKeyPrefix+key, string(formatNumber(delta)), ttl.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply %v to INCRBYFLOAT", reply)
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// AddAll implements Backend. Redis runs a script without interleaving other
+// commands, so the batch is applied as a whole.
+func (r *Redis) AddAll(ctx context.Context, deltas map[string]float64, ttl time.Duration) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	keys := slices.Sorted(maps.Keys(deltas))
+	args := []any{"EVAL", addAllScript, len(keys)}
+	for _, key := range keys {
+		args = append(args, redisKeyPrefix+key)
+	}
+	args = append(args, ttl.Milliseconds())
+	for _, key := range keys {
+		args = append(args, string(formatNumber(deltas[key])))
+	}
+	_, err := r.client.Do(ctx, args...)
+	return err
+}
+
+// Get implements Backend.
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := r.client.Do(ctx, "GET", redisKeyPrefix+key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	s, _ := reply.(string)
+	return []byte(s), true, nil
+}
+
+// Set implements Backend.
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []any{"SET", redisKeyPrefix + key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", ttl.Milliseconds())
+	}
+	_, err := r.client.Do(ctx, args...)
+	return err
+}
+
+// Expire implements Backend.
+func (r *Redis) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	var err error
+	if ttl > 0 {
+		_, err = r.client.Do(ctx, "PEXPIRE", redisKeyPrefix+key, ttl.Milliseconds())
+	} else {
+		_, err = r.client.Do(ctx, "PERSIST", redisKeyPrefix+key)
+	}
+	return err
+}
+
+// Delete implements Backend.
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := []any{"DEL"}
+	for _, key := range keys {
+		args = append(args, redisKeyPrefix+key)
+	}
+	_, err := r.client.Do(ctx, args...)
+	return err
+}
+
+// Scan implements Backend. Keys built with Key contain no glob characters,
+// so prefix is used in the SCAN pattern as is.
+func (r *Redis) Scan(ctx context.Context, prefix string) (map[string][]byte, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := r.client.Do(ctx, "SCAN", cursor, "MATCH", redisKeyPrefix+prefix+"*", "COUNT", scanBatch)
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("redis: unexpected reply %v to SCAN", reply)
+		}
+		cursor, _ = page[0].(string)
+		batch, _ := page[1].([]any)
+		for _, key := range batch {
+			if s, ok := key.(string); ok {
+				keys = append(keys, s)
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+
+	result := make(map[string][]byte, len(keys))
+	for start := 0; start < len(keys); start += scanBatch {
+		batch := keys[start:min(start+scanBatch, len(keys))]
+		args := []any{"MGET"}
+		for _, key := range batch {
+			args = append(args, key)
+		}
+		reply, err := r.client.Do(ctx, args...)
+		if err != nil {
+			return nil, err
+		}
+		values, _ := reply.([]any)
+		for i, value := range values {
+			// Keys that expired since the SCAN come back nil
+			if s, ok := value.(string); ok && i < len(batch) {
+				result[strings.TrimPrefix(batch[i], redisKeyPrefix)] = []byte(s)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Close implements Backend.
+func (r *Redis) Close() error { return nil }
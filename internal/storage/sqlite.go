@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// SQLiteDriver is the database/sql driver the SQLite backend opens. Like the
+// usage database's, it is only linked into binaries built with the "sqlite"
+// build tag.
+const SQLiteDriver = "sqlite"
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS state (
+	key        TEXT    PRIMARY KEY,
+	value      BLOB    NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0
+)`
+
+// SQLite is a Backend persisted to a SQLite database, so state survives
+// restarts of a single replica. Expiry times are Unix milliseconds, zero for
+// keys that never expire.
+type SQLite struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// SQLiteAvailable reports whether the binary was built with the SQLite driver.
+func SQLiteAvailable() bool {
+	return slices.Contains(sql.Drivers(), SQLiteDriver)
+}
+
+// OpenSQLite opens, creating if needed, the database at path.
+func OpenSQLite(path string) (*SQLite, error) {
+	if !SQLiteAvailable() {
+		return nil, fmt.Errorf("this binary was built without SQLite support; rebuild with -tags sqlite")
+	}
+	db, err := sql.Open(SQLiteDriver, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state database: %w", err)
+	}
+	// SQLite allows a single writer; one connection also serializes Add
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize state database: %w", err)
+	}
+	s := &SQLite{db: db, now: time.Now}
+	if _, err := db.Exec(`DELETE FROM state WHERE expires_at > 0 AND expires_at <= ?`, s.nowMillis()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prune state database: %w", err)
+	}
+	return s, nil
+}
+
+// Add implements Backend.
+func (s *SQLite) Add(ctx context.Context, key string, delta float64, ttl time.Duration) (float64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := s.add(ctx, tx, key, delta, ttl)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// AddAll implements Backend.
+func (s *SQLite) AddAll(ctx context.Context, deltas map[string]float64, ttl time.Duration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, delta := range deltas {
+		if _, err := s.add(ctx, tx, key, delta, ttl); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// add implements Add inside tx.
+func (s *SQLite) add(ctx context.Context, tx *sql.Tx, key string, delta float64, ttl time.Duration) (float64, error) {
+	var value []byte
+	var expires int64
+	err := tx.QueryRowContext(ctx, `SELECT value, expires_at FROM state WHERE key = ?`, key).Scan(&value, &expires)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) || (err == nil && expires > 0 && expires <= s.nowMillis()):
+		value, expires = nil, s.expiry(ttl)
+	case err != nil:
+		return 0, err
+	}
+	n := Number(value) + delta
+	if err := upsert(ctx, tx, key, formatNumber(n), expires); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Get implements Backend.
+func (s *SQLite) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM state WHERE key = ? AND (expires_at = 0 OR expires_at > ?)`, key, s.nowMillis()).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+// Set implements Backend.
+func (s *SQLite) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return upsert(ctx, s.db, key, value, s.expiry(ttl))
+}
+
+// Expire implements Backend.
+func (s *SQLite) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE state SET expires_at = ? WHERE key = ? AND (expires_at = 0 OR expires_at > ?)`, s.expiry(ttl), key, s.nowMillis())
+	return err
+}
+
+// Delete implements Backend.
+func (s *SQLite) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM state WHERE key = ?`, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan implements Backend.
+func (s *SQLite) Scan(ctx context.Context, prefix string) (map[string][]byte, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key, value FROM state
+		WHERE substr(key, 1, length(?)) = ? AND (expires_at = 0 OR expires_at > ?)`, prefix, prefix, s.nowMillis())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string][]byte)
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, rows.Err()
+}
+
+// Close implements Backend.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func upsert(ctx context.Context, db execer, key string, value []byte, expires int64) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO state (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`, key, value, expires)
+	return err
+}
+
+func (s *SQLite) nowMillis() int64 {
+	return s.now().UnixMilli()
+}
+
+func (s *SQLite) expiry(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return s.now().Add(ttl).UnixMilli()
+}
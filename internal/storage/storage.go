@@ -0,0 +1,128 @@
+// Package storage is the state store behind Portus's stateful subsystems:
+// spend budgets, request quotas, concurrent stream limits, conversation token
+// totals, asynchronous jobs, runtime key overrides, usage totals, circuit
+// breaker state and the semantic cache.
+// Those subsystems only use the Backend interface, so their state can live
+// in process memory, in a SQLite file that survives restarts, or in Redis
+// shared by every replica, and further backends only need to implement it.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amscotti/portus/internal/redis"
+)
+
+// Backend kinds, selected with PORTUS_STORAGE.
+const (
+	KindMemory = "memory"
+	KindSQLite = "sqlite"
+	KindRedis  = "redis"
+)
+
+// Timeout bounds a single backend operation made on the request path.
+const Timeout = 2 * time.Second
+
+// Backend stores numbers and small values by key. Keys are built with Key.
+// Implementations must be safe for concurrent use, and Add must be atomic
+// across every process sharing the backend.
+type Backend interface {
+	// Add adds delta to the number at key, treating a missing key as zero,
+	// and returns the result. When Add creates the key and ttl is above
+	// zero, the key expires after ttl.
+	Add(ctx context.Context, key string, delta float64, ttl time.Duration) (float64, error)
+	// AddAll applies Add to every key in deltas as one atomic write, so a
+	// failure leaves none of the keys changed.
+	AddAll(ctx context.Context, deltas map[string]float64, ttl time.Duration) error
+	// Get returns the value at key and whether it exists.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value at key, expiring after ttl when it is above zero.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Expire makes key expire after ttl from now, or never when ttl is zero,
+	// for state that lives as long as it keeps being used. A missing key is
+	// ignored.
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	// Delete removes keys; missing keys are ignored.
+	Delete(ctx context.Context, keys ...string) error
+	// Scan returns every live key starting with prefix, with its value.
+	Scan(ctx context.Context, prefix string) (map[string][]byte, error)
+	// Close releases the backend's resources.
+	Close() error
+}
+
+// ParseKind validates a PORTUS_STORAGE value; empty means KindMemory.
+func ParseKind(kind string) (string, error) {
+	switch kind {
+	case "":
+		return KindMemory, nil
+	case KindMemory, KindSQLite, KindRedis:
+		return kind, nil
+	}
+	return "", fmt.Errorf("unknown storage backend %q (must be %s, %s or %s)", kind, KindMemory, KindSQLite, KindRedis)
+}
+
+// Open returns the backend of kind: a new in-memory one, the SQLite database
+// at path, or Redis through client.
+func Open(kind, path string, client *redis.Client) (Backend, error) {
+	switch kind {
+	case KindMemory:
+		return NewMemory(), nil
+	case KindSQLite:
+		if path == "" {
+			return nil, fmt.Errorf("the %s storage backend needs a database path", KindSQLite)
+		}
+		db, err := OpenSQLite(path)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case KindRedis:
+		if client == nil {
+			return nil, fmt.Errorf("the %s storage backend needs a Redis URL", KindRedis)
+		}
+		return NewRedis(client), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", kind)
+}
+
+// Key joins parts into a key. Each part is escaped, so parts containing the
+// separator, or glob characters, cannot collide with other keys.
+func Key(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = url.QueryEscape(part)
+	}
+	return strings.Join(escaped, ":")
+}
+
+// Prefix returns the prefix Scan needs to find every key built from parts
+// followed by at least one more part.
+func Prefix(parts ...string) string {
+	return Key(parts...) + ":"
+}
+
+// Split returns the parts of a key built with Key.
+func Split(key string) []string {
+	parts := strings.Split(key, ":")
+	for i, part := range parts {
+		if unescaped, err := url.QueryUnescape(part); err == nil {
+			parts[i] = unescaped
+		}
+	}
+	return parts
+}
+
+// Number reads a value written by Add, or zero.
+func Number(value []byte) float64 {
+	n, _ := strconv.ParseFloat(string(value), 64)
+	return n
+}
+
+func formatNumber(n float64) []byte {
+	return strconv.AppendFloat(nil, n, 'g', -1, 64)
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/redis"
+	"github.com/amscotti/portus/internal/redis/redistest"
+)
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	// The TTL is set when Add creates the key and not extended afterwards
+	if n, _ := m.Add(ctx, "a", 1.5, time.Minute); n != 1.5 {
+		t.Fatalf("expected 1.5, got %v", n)
+	}
+	now = now.Add(30 * time.Second)
+	if n, _ := m.Add(ctx, "a", 2, time.Minute); n != 3.5 {
+		t.Fatalf("expected 3.5, got %v", n)
+	}
+	now = now.Add(31 * time.Second)
+	if _, ok, _ := m.Get(ctx, "a"); ok {
+		t.Error("expected counter to expire one minute after it was created")
+	}
+	if n, _ := m.Add(ctx, "a", 1, 0); n != 1 {
+		t.Errorf("expected an expired counter to restart from zero, got %v", n)
+	}
+
+	if err := m.AddAll(ctx, map[string]float64{"a": 2, "b": 0.5}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := m.Scan(ctx, ""); Number(got["a"]) != 3 || Number(got["b"]) != 0.5 {
+		t.Errorf("unexpected values after AddAll %q", got)
+	}
+
+	m.Set(ctx, "p:1", []byte("one"), 0)
+	m.Set(ctx, "p:2", []byte("two"), time.Second)
+	m.Set(ctx, "q:1", []byte("other"), 0)
+	if got, _ := m.Scan(ctx, "p:"); len(got) != 2 || string(got["p:1"]) != "one" || string(got["p:2"]) != "two" {
+		t.Errorf("unexpected scan result %q", got)
+	}
+	now = now.Add(time.Second)
+	if got, _ := m.Scan(ctx, "p:"); !slices.Equal(slices.Sorted(maps.Keys(got)), []string{"p:1"}) {
+		t.Errorf("expected expired entries to be skipped, got %q", got)
+	}
+
+	// Expire pushes an entry's expiry back, or removes it
+	m.Set(ctx, "s", []byte("x"), time.Second)
+	now = now.Add(900 * time.Millisecond)
+	m.Expire(ctx, "s", time.Second)
+	now = now.Add(900 * time.Millisecond)
+	if _, ok, _ := m.Get(ctx, "s"); !ok {
+		t.Error("expected Expire to extend the entry")
+	}
+	m.Expire(ctx, "s", 0)
+	now = now.Add(time.Hour)
+	if _, ok, _ := m.Get(ctx, "s"); !ok {
+		t.Error("expected Expire with zero TTL to keep the entry")
+	}
+	m.Expire(ctx, "missing", time.Second)
+	if _, ok, _ := m.Get(ctx, "missing"); ok {
+		t.Error("expected Expire to ignore missing keys")
+	}
+
+	m.Delete(ctx, "p:1", "missing")
+	if _, ok, _ := m.Get(ctx, "p:1"); ok {
+		t.Error("expected deleted key to be gone")
+	}
+}
+
+func TestKey(t *testing.T) {
+	t.Parallel()
+
+	key := Key("budget", "team:a", "daily*")
+	if key != "budget:team%3Aa:daily%2A" {
+		t.Errorf("unexpected key %q", key)
+	}
+	if parts := Split(key); !slices.Equal(parts, []string{"budget", "team:a", "daily*"}) {
+		t.Errorf("expected Split to undo Key, got %q", parts)
+	}
+	if !strings.HasPrefix(key, Prefix("budget", "team:a")) || strings.HasPrefix(Key("budget", "team:ab"), Prefix("budget", "team:a")) {
+		t.Error("expected Prefix to match only keys with the same leading parts")
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		kind    string
+		want    string
+		wantErr bool
+	}{
+		{kind: "", want: KindMemory},
+		{kind: "memory", want: KindMemory},
+		{kind: "sqlite", want: KindSQLite},
+		{kind: "redis", want: KindRedis},
+		{kind: "etcd", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseKind(tt.kind)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseKind(%q) = %q, %v; want %q, error %v", tt.kind, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+
+	if b, err := Open(KindMemory, "", nil); err != nil || b == nil {
+		t.Errorf("expected memory backend, got %v, %v", b, err)
+	}
+	if _, err := Open(KindSQLite, "", nil); err == nil {
+		t.Error("expected error for sqlite without a path")
+	}
+	if _, err := Open(KindRedis, "", nil); err == nil {
+		t.Error("expected error for redis without a client")
+	}
+	if !SQLiteAvailable() {
+		if _, err := Open(KindSQLite, t.TempDir()+"/state.db", nil); err == nil {
+			t.Error("expected error when built without the SQLite driver")
+		}
+	}
+}
+
+func TestRedis(t *testing.T) {
+	t.Parallel()
+
+	server := redistest.NewServer()
+	t.Cleanup(server.Close)
+	var mu sync.Mutex
+	values := map[string]string{}
+	evals := 0
+	server.Handle("EVAL", func(args []string) any {
+		mu.Lock()
+		defer mu.Unlock()
+		evals++
+		// KEYS follow the key count; addAllScript puts the TTL before the deltas
+		numKeys, _ := strconv.Atoi(args[1])
+		keys, argv := args[2:2+numKeys], args[2+numKeys:]
+		if numKeys > 1 {
+			argv = argv[1:]
+		}
+		for i, key := range keys {
+			delta, _ := strconv.ParseFloat(argv[i], 64)
+			n, _ := strconv.ParseFloat(values[key], 64)
+			values[key] = strconv.FormatFloat(n+delta, 'g', -1, 64)
+		}
+		return values[keys[0]]
+	})
+	server.Handle("SET", func(args []string) any {
+		mu.Lock()
+		defer mu.Unlock()
+		values[args[0]] = args[1]
+		return "OK"
+	})
+	server.Handle("GET", func(args []string) any {
+		mu.Lock()
+		defer mu.Unlock()
+		if v, ok := values[args[0]]; ok {
+			return v
+		}
+		return nil
+	})
+	expiries := map[string]string{}
+	server.Handle("PEXPIRE", func(args []string) any {
+		mu.Lock()
+		defer mu.Unlock()
+		expiries[args[0]] = args[1]
+		return 1
+	})
+	server.Handle("PERSIST", func(args []string) any {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(expiries, args[0])
+		return 1
+	})
+	server.Handle("DEL", func(args []string) any {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range args {
+			delete(values, key)
+		}
+		return len(args)
+	})
+	server.Handle("SCAN", func(args []string) any {
+		mu.Lock()
+		defer mu.Unlock()
+		prefix := strings.TrimSuffix(args[2], "*")
+		var keys []any
+		for key := range values {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		return []any{"0", keys}
+	})
+	server.Handle("MGET", func(args []string) any {
+		mu.Lock()
+		defer mu.Unlock()
+		result := make([]any, len(args))
+		for i, key := range args {
+			if v, ok := values[key]; ok {
+				result[i] = v
+			}
+		}
+		return result
+	})
+
+	client, err := redis.NewClient(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	r := NewRedis(client)
+	ctx := context.Background()
+
+	r.Add(ctx, "usage:a:requests", 1, 0)
+	if n, err := r.Add(ctx, "usage:a:requests", 0.25, 0); err != nil || n != 1.25 {
+		t.Fatalf("expected 1.25, got %v, %v", n, err)
+	}
+	mu.Lock()
+	evals = 0
+	mu.Unlock()
+	if err := r.AddAll(ctx, map[string]float64{"usage:a:requests": 1, "usage:a:tokens": 3}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if evals != 1 || values["portus:state:usage:a:requests"] != "2.25" || values["portus:state:usage:a:tokens"] != "3" {
+		t.Errorf("expected one EVAL applying both deltas, got %d and %v", evals, values)
+	}
+	r.Delete(ctx, "usage:a:tokens")
+
+	if err := r.Set(ctx, "usage:b:requests", []byte("7"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := values["portus:state:usage:b:requests"]; !ok {
+		t.Errorf("expected keys to be namespaced, got %v", slices.Collect(maps.Keys(values)))
+	}
+	if got, ok, err := r.Get(ctx, "usage:b:requests"); err != nil || !ok || string(got) != "7" {
+		t.Errorf("expected 7, got %q, %v, %v", got, ok, err)
+	}
+	if _, ok, err := r.Get(ctx, "missing"); err != nil || ok {
+		t.Errorf("expected missing key, got %v, %v", ok, err)
+	}
+	if err := r.Expire(ctx, "usage:b:requests", 90*time.Second); err != nil || expiries["portus:state:usage:b:requests"] != "90000" {
+		t.Errorf("expected PEXPIRE in milliseconds, got %v, %v", expiries, err)
+	}
+	if err := r.Expire(ctx, "usage:b:requests", 0); err != nil || len(expiries) != 0 {
+		t.Errorf("expected PERSIST for a zero TTL, got %v, %v", expiries, err)
+	}
+
+	got, err := r.Scan(ctx, "usage:")
+	if err != nil || len(got) != 2 || Number(got["usage:a:requests"]) != 2.25 || string(got["usage:b:requests"]) != "7" {
+		t.Errorf("unexpected scan result %q, %v", got, err)
+	}
+
+	r.Delete(ctx, "usage:a:requests")
+	if _, ok, _ := r.Get(ctx, "usage:a:requests"); ok {
+		t.Error("expected deleted key to be gone")
+	}
+}
@@ -0,0 +1,133 @@
+// Package streamlimit caps the number of simultaneous streaming responses per
+// proxy key, either per process or shared across replicas through a storage
+// backend.
+package streamlimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/amscotti/portus/internal/storage"
+)
+
+// Limiter counts active streams per key. It is safe for concurrent use; a nil
+// Limiter never limits.
+type Limiter struct {
+	mu     sync.Mutex
+	active map[string]int
+
+	// Shared mode: each stream holds a lease in the backend, so slots held by
+	// a crashed replica expire instead of leaking forever.
+	backend storage.Backend
+	lease   time.Duration
+	logger  *slog.Logger
+}
+
+// New creates an empty in-process limiter.
+func New() *Limiter {
+	return &Limiter{active: make(map[string]int)}
+}
+
+// NewShared creates a limiter whose counts are kept in backend and shared by
+// every replica using it. Leases must outlive the longest stream. If the
+// backend is unavailable, streams are allowed rather than failing requests.
+func NewShared(backend storage.Backend, lease time.Duration, logger *slog.Logger) *Limiter {
+	return &Limiter{backend: backend, lease: lease, logger: logger}
+}
+
+// Acquire reserves a stream slot for key if fewer than limit are active. A
+// limit of zero or less means unlimited. It returns the number of active
+// streams (including the new one on success) and whether the slot was granted.
+// On success, release must be called exactly once when the stream ends.
+func (l *Limiter) Acquire(key string, limit int) (release func(), active int, ok bool) {
+	if l == nil {
+		return func() {}, 0, true
+	}
+	if l.backend != nil {
+		return l.acquireShared(key, limit)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current := l.active[key]
+	if limit > 0 && current >= limit {
+		return nil, current, false
+	}
+	l.active[key] = current + 1
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.active[key] <= 1 {
+				delete(l.active, key)
+			} else {
+				l.active[key]--
+			}
+		})
+	}, current + 1, true
+}
+
+// acquireShared takes a lease first and counts the leases afterwards, giving
+// it back when that is over the limit. Replicas racing for the last slot may
+// all give theirs back, but never all keep them.
+func (l *Limiter) acquireShared(key string, limit int) (func(), int, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	lease := storage.Key("streams", key, newLeaseID())
+	if err := l.backend.Set(ctx, lease, []byte{'1'}, l.lease); err != nil {
+		l.logger.Warn("shared stream limit unavailable, allowing stream", "key", key, "error", err)
+		return func() {}, 0, true
+	}
+	leases, err := l.backend.Scan(ctx, storage.Prefix("streams", key))
+	if err != nil {
+		l.logger.Warn("shared stream limit unavailable, allowing stream", "key", key, "error", err)
+	}
+	active := max(len(leases), 1)
+	if limit > 0 && active > limit {
+		if err := l.backend.Delete(ctx, lease); err != nil {
+			l.logger.Warn("failed to release shared stream slot", "key", key, "error", err)
+		}
+		return nil, active - 1, false
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+			defer cancel()
+			if err := l.backend.Delete(ctx, lease); err != nil {
+				l.logger.Warn("failed to release shared stream slot", "key", key, "error", err)
+			}
+		})
+	}, active, true
+}
+
+// Active returns the number of active streams for key.
+func (l *Limiter) Active(key string) int {
+	if l == nil {
+		return 0
+	}
+	if l.backend != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+		defer cancel()
+		leases, _ := l.backend.Scan(ctx, storage.Prefix("streams", key))
+		return len(leases)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active[key]
+}
+
+func newLeaseID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,127 @@
+package streamlimit
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/redis"
+	"github.com/amscotti/portus/internal/redis/redistest"
+	"github.com/amscotti/portus/internal/storage"
+)
+
+func TestLimiter_Acquire(t *testing.T) {
+	t.Parallel()
+
+	l := New()
+
+	release1, active, ok := l.Acquire("app", 2)
+	if !ok || active != 1 {
+		t.Fatalf("expected first stream granted with 1 active, got ok=%v active=%d", ok, active)
+	}
+	release2, active, ok := l.Acquire("app", 2)
+	if !ok || active != 2 {
+		t.Fatalf("expected second stream granted with 2 active, got ok=%v active=%d", ok, active)
+	}
+	if _, active, ok := l.Acquire("app", 2); ok || active != 2 {
+		t.Fatalf("expected third stream rejected at 2 active, got ok=%v active=%d", ok, active)
+	}
+
+	// Other keys are independent
+	if _, _, ok := l.Acquire("other", 2); !ok {
+		t.Error("expected other key to be unaffected")
+	}
+
+	release1()
+	release1() // Releasing twice must not free a second slot
+	if l.Active("app") != 1 {
+		t.Errorf("expected 1 active stream after release, got %d", l.Active("app"))
+	}
+	release2()
+	if l.Active("app") != 0 {
+		t.Errorf("expected 0 active streams, got %d", l.Active("app"))
+	}
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	t.Parallel()
+
+	l := New()
+	for i := 0; i < 100; i++ {
+		if _, _, ok := l.Acquire("app", 0); !ok {
+			t.Fatal("expected zero limit to be unlimited")
+		}
+	}
+
+	var nilLimiter *Limiter
+	release, _, ok := nilLimiter.Acquire("app", 1)
+	if !ok {
+		t.Fatal("expected nil limiter to grant every stream")
+	}
+	release()
+}
+
+func TestLimiter_Shared(t *testing.T) {
+	t.Parallel()
+
+	// Two replicas share one backend
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	backend := storage.NewMemory()
+	a, b := NewShared(backend, time.Minute, logger), NewShared(backend, time.Minute, logger)
+
+	release, active, ok := a.Acquire("app", 2)
+	if !ok || active != 1 {
+		t.Fatalf("expected first stream granted with 1 active, got ok=%v active=%d", ok, active)
+	}
+	if _, active, ok := b.Acquire("app", 2); !ok || active != 2 {
+		t.Fatalf("expected second stream granted with 2 active, got ok=%v active=%d", ok, active)
+	}
+	if _, active, ok := a.Acquire("app", 2); ok || active != 2 {
+		t.Fatalf("expected third stream rejected across replicas, got ok=%v active=%d", ok, active)
+	}
+
+	release()
+	release()
+	if n := b.Active("app"); n != 1 {
+		t.Errorf("expected 1 active stream after release, got %d", n)
+	}
+	if n := b.Active("other"); n != 0 {
+		t.Errorf("expected other keys to be unaffected, got %d", n)
+	}
+}
+
+func TestLimiter_SharedLeaseExpires(t *testing.T) {
+	t.Parallel()
+
+	// A slot held by a replica that died without releasing it frees itself
+	l := NewShared(storage.NewMemory(), 50*time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if _, _, ok := l.Acquire("app", 1); !ok {
+		t.Fatal("expected first stream granted")
+	}
+	if _, _, ok := l.Acquire("app", 1); ok {
+		t.Fatal("expected second stream rejected while the lease is held")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, _, ok := l.Acquire("app", 1); !ok {
+		t.Error("expected the slot back once the lease expired")
+	}
+}
+
+func TestLimiter_SharedFailsOpen(t *testing.T) {
+	t.Parallel()
+
+	server := redistest.NewServer()
+	server.Close()
+
+	client, err := redis.NewClient(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewShared(storage.NewRedis(client), time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	release, _, ok := l.Acquire("app", 1)
+	if !ok {
+		t.Fatal("expected stream to be allowed when the backend is unreachable")
+	}
+	release()
+}
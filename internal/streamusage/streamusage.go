@@ -0,0 +1,104 @@
+// Package streamusage asks OpenAI-compatible endpoints to report token usage
+// at the end of a stream, and hides that extra chunk from clients that did not
+// request it.
+package streamusage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// Inject adds stream_options.include_usage to a streaming request body. It
+// reports false, leaving the body unchanged, when the request is not
+// streaming, already asks for usage, or is not a JSON object.
+func Inject(body []byte) ([]byte, bool) {
+	var req map[string]json.RawMessage
+	if json.Unmarshal(body, &req) != nil {
+		return body, false
+	}
+	var stream bool
+	if json.Unmarshal(req["stream"], &stream) != nil || !stream {
+		return body, false
+	}
+
+	options := map[string]json.RawMessage{}
+	if raw, ok := req["stream_options"]; ok && string(raw) != "null" {
+		if json.Unmarshal(raw, &options) != nil {
+			return body, false
+		}
+	}
+	var includeUsage bool
+	if json.Unmarshal(options["include_usage"], &includeUsage) == nil && includeUsage {
+		return body, false
+	}
+
+	options["include_usage"] = json.RawMessage("true")
+	rawOptions, err := json.Marshal(options)
+	if err != nil {
+		return body, false
+	}
+	req["stream_options"] = rawOptions
+	out, err := json.Marshal(req)
+	if err != nil {
+		return body, false
+	}
+	return out, true
+}
+
+// IsUsageChunk reports whether an event payload is the usage-only chunk sent
+// when include_usage is set: an empty choices array with non-null usage.
+func IsUsageChunk(payload []byte) bool {
+	var chunk struct {
+		Choices []json.RawMessage `json:"choices"`
+		Usage   json.RawMessage   `json:"usage"`
+	}
+	if json.Unmarshal(payload, &chunk) != nil {
+		return false
+	}
+	return chunk.Choices != nil && len(chunk.Choices) == 0 &&
+		len(chunk.Usage) > 0 && string(chunk.Usage) != "null"
+}
+
+// NewStripReader wraps a server-sent event stream, dropping usage-only chunks
+// together with the blank line that ends their event. Everything else passes
+// through unchanged.
+func NewStripReader(r io.Reader) io.Reader {
+	return &stripReader{src: bufio.NewReader(r)}
+}
+
+type stripReader struct {
+	src       *bufio.Reader
+	pending   []byte
+	skipBlank bool
+	err       error
+}
+
+func (s *stripReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		var line []byte
+		line, s.err = s.src.ReadBytes('\n')
+		s.pending = s.filter(line)
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *stripReader) filter(line []byte) []byte {
+	if s.skipBlank {
+		s.skipBlank = false
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return nil
+		}
+	}
+	if data, ok := bytes.CutPrefix(line, []byte("data:")); ok && IsUsageChunk(bytes.TrimSpace(data)) {
+		s.skipBlank = true
+		return nil
+	}
+	return line
+}
@@ -0,0 +1,114 @@
+package streamusage
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInject(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		body         string
+		wantInjected bool
+		wantOptions  string
+	}{
+		{
+			name:         "streaming request",
+			body:         `{"model":"gpt4","stream":true}`,
+			wantInjected: true,
+			wantOptions:  `{"include_usage":true}`,
+		},
+		{
+			name:         "keeps other stream options",
+			body:         `{"model":"gpt4","stream":true,"stream_options":{"include_obfuscation":false}}`,
+			wantInjected: true,
+			wantOptions:  `{"include_obfuscation":false,"include_usage":true}`,
+		},
+		{
+			name:         "explicit false is overridden",
+			body:         `{"model":"gpt4","stream":true,"stream_options":{"include_usage":false}}`,
+			wantInjected: true,
+			wantOptions:  `{"include_usage":true}`,
+		},
+		{
+			name: "client already asked for usage",
+			body: `{"model":"gpt4","stream":true,"stream_options":{"include_usage":true}}`,
+		},
+		{
+			name: "not streaming",
+			body: `{"model":"gpt4"}`,
+		},
+		{
+			name: "invalid JSON",
+			body: `{"model":`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			out, injected := Inject([]byte(tt.body))
+			if injected != tt.wantInjected {
+				t.Fatalf("expected injected=%v, got %v", tt.wantInjected, injected)
+			}
+			if !injected {
+				if string(out) != tt.body {
+					t.Errorf("expected body unchanged, got %s", out)
+				}
+				return
+			}
+			var req struct {
+				Model         string          `json:"model"`
+				StreamOptions json.RawMessage `json:"stream_options"`
+			}
+			if err := json.Unmarshal(out, &req); err != nil {
+				t.Fatalf("invalid body: %v", err)
+			}
+			if req.Model != "gpt4" || string(req.StreamOptions) != tt.wantOptions {
+				t.Errorf("unexpected body: %s", out)
+			}
+		})
+	}
+}
+
+func TestIsUsageChunk(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		payload string
+		want    bool
+	}{
+		{`{"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":1}}`, true},
+		{`{"choices":[{"delta":{"content":"hi"}}],"usage":null}`, false},
+		{`{"choices":[],"usage":null}`, false},
+		{`{"usage":{"prompt_tokens":3}}`, false},
+		{`[DONE]`, false},
+	}
+	for _, tt := range tests {
+		if got := IsUsageChunk([]byte(tt.payload)); got != tt.want {
+			t.Errorf("IsUsageChunk(%s) = %v, want %v", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestNewStripReader(t *testing.T) {
+	t.Parallel()
+
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}],\"usage\":null}\n\n" +
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":1}}\r\n\r\n" +
+		"data: [DONE]\n\n"
+
+	out, err := io.ReadAll(NewStripReader(strings.NewReader(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}],\"usage\":null}\n\n" +
+		"data: [DONE]\n\n"
+	if string(out) != want {
+		t.Errorf("unexpected stream:\n%q", out)
+	}
+}
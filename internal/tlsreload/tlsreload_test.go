@@ -0,0 +1,232 @@
+package tlsreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM-encoded certificate and key signed by the CA.
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "portus"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	os.Chtimes(path, modTime, modTime)
+}
+
+func TestReloader_MutualTLSAndRotation(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	serverCertPEM, serverKeyPEM := ca.issue(t, 10, x509.ExtKeyUsageServerAuth)
+	serverCert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].SerialNumber.String()))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	past := time.Now().Add(-time.Minute)
+	clientCert, clientKey := ca.issue(t, 100, x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, clientCert, past)
+	writeFile(t, keyFile, clientKey, past)
+	writeFile(t, caFile, ca.pem, past)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	reloader, err := New(certFile, keyFile, caFile, logger)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   reloader.ClientConfig(),
+		DisableKeepAlives: true,
+	}}
+
+	serial := func() string {
+		t.Helper()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got := serial(); got != "100" {
+		t.Fatalf("expected client certificate serial 100, got %s", got)
+	}
+
+	// Rotate the client certificate on disk
+	clientCert, clientKey = ca.issue(t, 200, x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, clientCert, time.Now())
+	writeFile(t, keyFile, clientKey, time.Now())
+	reloader.reloadIfChanged()
+
+	if got := serial(); got != "200" {
+		t.Errorf("expected rotated certificate serial 200, got %s", got)
+	}
+
+	// A broken rotation keeps the previous credentials
+	writeFile(t, certFile, []byte("not a certificate"), time.Now().Add(time.Minute))
+	reloader.reloadIfChanged()
+	if got := serial(); got != "200" {
+		t.Errorf("expected previous certificate to remain in use, got %s", got)
+	}
+}
+
+func TestReloader_RejectsUntrustedServer(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	writeFile(t, caFile, newTestCA(t).pem, time.Now())
+
+	reloader, err := New("", "", caFile, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: reloader.ClientConfig()}}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("expected verification failure for server outside the CA bundle")
+	}
+}
+
+func TestNew_RequiresCertAndKeyTogether(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New("client.crt", "", "", slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("expected error when key is missing")
+	}
+}
+
+func TestReloader_ServerConfig(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	serverCert, serverKey := ca.issue(t, 300, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, serverCert, time.Now().Add(-time.Minute))
+	writeFile(t, keyFile, serverKey, time.Now().Add(-time.Minute))
+
+	reloader, err := New(certFile, keyFile, "", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	// StartTLS would install its own certificate, so wrap the listener directly
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = tls.NewListener(server.Listener, reloader.ServerConfig())
+	server.Start()
+	defer server.Close()
+	url := "https://" + server.Listener.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots},
+		DisableKeepAlives: true,
+	}}
+
+	servedSerial := func() int64 {
+		t.Helper()
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	if got := servedSerial(); got != 300 {
+		t.Fatalf("expected serial 300, got %d", got)
+	}
+
+	serverCert, serverKey = ca.issue(t, 400, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, serverCert, time.Now())
+	writeFile(t, keyFile, serverKey, time.Now())
+	reloader.reloadIfChanged()
+
+	if got := servedSerial(); got != 400 {
+		t.Errorf("expected renewed serial 400, got %d", got)
+	}
+}
@@ -0,0 +1,95 @@
+// Package tokenlimit enforces a ceiling on the completion tokens a request may
+// ask for, clamping the endpoint's max tokens field or reporting the excess.
+package tokenlimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Actions taken when a request asks for more than the limit.
+const (
+	// ActionClamp lowers the request's value to the limit.
+	ActionClamp = "clamp"
+	// ActionReject refuses the request.
+	ActionReject = "reject"
+)
+
+// ParseAction validates a PORTUS_MAX_TOKENS_ACTION value; empty means clamp.
+func ParseAction(value string) (string, error) {
+	switch value {
+	case "":
+		return ActionClamp, nil
+	case ActionClamp, ActionReject:
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown action %q (must be %q or %q)", value, ActionClamp, ActionReject)
+}
+
+// fields lists the max tokens fields of each endpoint. The first is set when
+// a request names none.
+var fields = map[string][]string{
+	"/v1/chat/completions": {"max_tokens", "max_completion_tokens"},
+	"/v1/completions":      {"max_tokens"},
+	"/v1/messages":         {"max_tokens"},
+	"/v1/responses":        {"max_output_tokens"},
+}
+
+// Apply caps the max tokens fields of a request body for path at limit. A
+// request setting none gets the limit, using max_completion_tokens for chat
+// requests to reasoning models, which reject max_tokens. It returns the new
+// body and the largest value the request asked for, zero when it set none.
+// Bodies of other endpoints, or that are not JSON objects, are returned
+// unchanged.
+func Apply(body []byte, path string, limit int, reasoning bool) ([]byte, int) {
+	names, ok := fields[path]
+	if !ok || limit <= 0 {
+		return body, 0
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var req map[string]any
+	if err := dec.Decode(&req); err != nil {
+		return body, 0
+	}
+
+	requested, changed := 0, false
+	for _, name := range names {
+		value, ok := req[name].(json.Number)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value.String())
+		if err != nil {
+			continue
+		}
+		requested = max(requested, n)
+		if n > limit {
+			req[name] = limit
+			changed = true
+		}
+	}
+	if requested == 0 {
+		name := names[0]
+		if reasoning && path == "/v1/chat/completions" {
+			name = "max_completion_tokens"
+		}
+		if _, set := req[name]; !set {
+			req[name] = limit
+			changed = true
+		}
+	}
+	if !changed {
+		return body, requested
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(req); err != nil {
+		return body, requested
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), requested
+}
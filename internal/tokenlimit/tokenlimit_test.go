@@ -0,0 +1,91 @@
+package tokenlimit
+
+import "testing"
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		body          string
+		path          string
+		reasoning     bool
+		wantBody      string
+		wantRequested int
+	}{
+		{
+			name:          "clamped",
+			body:          `{"model":"gpt4","max_tokens":100000,"temperature":0.5}`,
+			path:          "/v1/chat/completions",
+			wantBody:      `{"max_tokens":4000,"model":"gpt4","temperature":0.5}`,
+			wantRequested: 100000,
+		},
+		{
+			name:          "within limit",
+			body:          `{"model":"gpt4","max_completion_tokens":100}`,
+			path:          "/v1/chat/completions",
+			wantBody:      `{"model":"gpt4","max_completion_tokens":100}`,
+			wantRequested: 100,
+		},
+		{
+			name:     "injected when missing",
+			body:     `{"model":"claude","messages":[]}`,
+			path:     "/v1/messages",
+			wantBody: `{"max_tokens":4000,"messages":[],"model":"claude"}`,
+		},
+		{
+			name:      "reasoning model",
+			body:      `{"model":"o3"}`,
+			path:      "/v1/chat/completions",
+			reasoning: true,
+			wantBody:  `{"max_completion_tokens":4000,"model":"o3"}`,
+		},
+		{
+			name:          "responses",
+			body:          `{"model":"gpt4","max_output_tokens":9000}`,
+			path:          "/v1/responses",
+			wantBody:      `{"max_output_tokens":4000,"model":"gpt4"}`,
+			wantRequested: 9000,
+		},
+		{
+			name:     "other endpoint",
+			body:     `{"model":"embed","input":"hi"}`,
+			path:     "/v1/embeddings",
+			wantBody: `{"model":"embed","input":"hi"}`,
+		},
+		{
+			name:     "not JSON",
+			body:     `not json`,
+			path:     "/v1/completions",
+			wantBody: `not json`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			body, requested := Apply([]byte(tt.body), tt.path, 4000, tt.reasoning)
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+			if requested != tt.wantRequested {
+				t.Errorf("requested = %d, want %d", requested, tt.wantRequested)
+			}
+		})
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	t.Parallel()
+
+	if action, err := ParseAction(""); err != nil || action != ActionClamp {
+		t.Errorf("expected empty to mean clamp, got %q %v", action, err)
+	}
+	if _, err := ParseAction(ActionReject); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := ParseAction("truncate"); err == nil {
+		t.Error("expected unknown action to be rejected")
+	}
+}
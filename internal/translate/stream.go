@@ -0,0 +1,343 @@
+package translate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/amscotti/portus/internal/finishreason"
+)
+
+// Stream rewrites a server-sent event stream from one format into the other
+// as it is written. Events may be split across writes; comment-only events
+// are passed through unchanged. Close must be called once the upstream stream
+// ends.
+type Stream struct {
+	buf     []byte
+	dst     io.Writer
+	convert func(event string, data []byte) error
+	finish  func() error
+	err     error
+}
+
+// Write buffers p and converts every complete event in it.
+func (s *Stream) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.buf = append(s.buf, p...)
+	for {
+		end, next := eventBoundary(s.buf)
+		if end < 0 {
+			break
+		}
+		if s.err = s.handle(s.buf[:end]); s.err != nil {
+			return 0, s.err
+		}
+		s.buf = s.buf[next:]
+	}
+	return len(p), nil
+}
+
+// Close converts any trailing event and completes the translated stream.
+func (s *Stream) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(bytes.TrimSpace(s.buf)) > 0 {
+		if err := s.handle(s.buf); err != nil {
+			return err
+		}
+	}
+	s.buf = nil
+	if s.finish != nil {
+		return s.finish()
+	}
+	return nil
+}
+
+func (s *Stream) handle(raw []byte) error {
+	var event string
+	var data, comments [][]byte
+	for _, line := range bytes.Split(raw, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if v, ok := bytes.CutPrefix(line, []byte("event:")); ok {
+			event = string(bytes.TrimSpace(v))
+		} else if v, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			data = append(data, bytes.TrimPrefix(v, []byte(" ")))
+		} else if bytes.HasPrefix(line, []byte(":")) {
+			comments = append(comments, line)
+		}
+	}
+	if len(data) == 0 {
+		if len(comments) == 0 {
+			return nil
+		}
+		_, err := fmt.Fprintf(s.dst, "%s\n\n", bytes.Join(comments, []byte("\n")))
+		return err
+	}
+	return s.convert(event, bytes.Join(data, []byte("\n")))
+}
+
+// eventBoundary finds the blank line ending the first event in b, returning
+// the end of the event and the start of the next, or -1 if b holds no
+// complete event.
+func eventBoundary(b []byte) (end, next int) {
+	for i := 0; i < len(b); i++ {
+		if b[i] != '\n' {
+			continue
+		}
+		j := i + 1
+		if j < len(b) && b[j] == '\r' {
+			j++
+		}
+		if j < len(b) && b[j] == '\n' {
+			return i, j + 1
+		}
+	}
+	return -1, 0
+}
+
+// NewMessagesToChatStream returns a stream that turns Anthropic message
+// events into OpenAI chat completion chunks written to dst. A final usage
+// chunk is emitted when includeUsage is set, matching stream_options.
+func NewMessagesToChatStream(dst io.Writer, includeUsage bool) *Stream {
+	var (
+		id, model string
+		created   = time.Now().Unix()
+		usage     chatUsage
+		toolIndex = map[int]int{}
+	)
+
+	s := &Stream{dst: dst}
+	chunk := func(delta chatDelta, finish *string) error {
+		return writeData(dst, chatResponse{
+			ID: id, Object: "chat.completion.chunk", Created: created, Model: model,
+			Choices: []chatChoice{{Delta: &delta, FinishReason: finish}},
+		})
+	}
+
+	s.convert = func(event string, data []byte) error {
+		var ev struct {
+			Type    string `json:"type"`
+			Index   int    `json:"index"`
+			Message *struct {
+				ID    string         `json:"id"`
+				Model string         `json:"model"`
+				Usage anthropicUsage `json:"usage"`
+			} `json:"message"`
+			ContentBlock *block `json:"content_block"`
+			Delta        *struct {
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
+			} `json:"delta"`
+			Usage *anthropicUsage `json:"usage"`
+			Error json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return fmt.Errorf("invalid message event: %w", err)
+		}
+
+		switch ev.Type {
+		case "message_start":
+			if ev.Message != nil {
+				id, model = ev.Message.ID, ev.Message.Model
+				usage.PromptTokens = ev.Message.Usage.InputTokens
+			}
+			empty := ""
+			return chunk(chatDelta{Role: "assistant", Content: &empty}, nil)
+		case "content_block_start":
+			if ev.ContentBlock != nil && ev.ContentBlock.Type == "tool_use" {
+				index := len(toolIndex)
+				toolIndex[ev.Index] = index
+				return chunk(chatDelta{ToolCalls: []chatToolCall{{
+					Index: &index, ID: ev.ContentBlock.ID, Type: "function",
+					Function: chatFunction{Name: ev.ContentBlock.Name},
+				}}}, nil)
+			}
+		case "content_block_delta":
+			if ev.Delta == nil {
+				return nil
+			}
+			switch ev.Delta.Type {
+			case "text_delta":
+				return chunk(chatDelta{Content: &ev.Delta.Text}, nil)
+			case "input_json_delta":
+				index := toolIndex[ev.Index]
+				return chunk(chatDelta{ToolCalls: []chatToolCall{{
+					Index: &index, Function: chatFunction{Arguments: ev.Delta.PartialJSON},
+				}}}, nil)
+			}
+		case "message_delta":
+			if ev.Usage != nil {
+				usage.CompletionTokens = ev.Usage.OutputTokens
+				if ev.Usage.InputTokens > 0 {
+					usage.PromptTokens = ev.Usage.InputTokens
+				}
+			}
+			if ev.Delta != nil && ev.Delta.StopReason != "" {
+				finish := finishreason.Normalize(ev.Delta.StopReason)
+				return chunk(chatDelta{}, &finish)
+			}
+		case "message_stop":
+			if includeUsage {
+				usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+				final := usage
+				if err := writeData(dst, chatResponse{
+					ID: id, Object: "chat.completion.chunk", Created: created, Model: model,
+					Choices: []chatChoice{}, Usage: &final,
+				}); err != nil {
+					return err
+				}
+			}
+			_, err := io.WriteString(dst, "data: [DONE]\n\n")
+			return err
+		case "error":
+			return writeData(dst, map[string]json.RawMessage{"error": ev.Error})
+		}
+		return nil
+	}
+	return s
+}
+
+// NewChatToMessagesStream returns a stream that turns OpenAI chat completion
+// chunks into Anthropic message events written to dst. The upstream stream
+// should include usage so token counts can be reported.
+func NewChatToMessagesStream(dst io.Writer) *Stream {
+	var (
+		started  bool
+		done     bool
+		open     = -1 // index of the open content block
+		openType string
+		blocks   int
+		stop     = "end_turn"
+		usage    anthropicUsage
+		toolCall = map[int]int{} // OpenAI tool call index to block index
+	)
+
+	s := &Stream{dst: dst}
+	closeBlock := func() error {
+		if open < 0 {
+			return nil
+		}
+		err := writeEvent(dst, "content_block_stop", map[string]any{"type": "content_block_stop", "index": open})
+		open = -1
+		return err
+	}
+	openBlock := func(kind string, content any) error {
+		if err := closeBlock(); err != nil {
+			return err
+		}
+		open, openType = blocks, kind
+		blocks++
+		return writeEvent(dst, "content_block_start", map[string]any{"type": "content_block_start", "index": open, "content_block": content})
+	}
+	end := func() error {
+		if done || !started {
+			return nil
+		}
+		done = true
+		if err := closeBlock(); err != nil {
+			return err
+		}
+		if err := writeEvent(dst, "message_delta", map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": stop, "stop_sequence": nil},
+			"usage": usage,
+		}); err != nil {
+			return err
+		}
+		return writeEvent(dst, "message_stop", map[string]any{"type": "message_stop"})
+	}
+
+	s.convert = func(event string, data []byte) error {
+		if bytes.Equal(bytes.TrimSpace(data), []byte("[DONE]")) {
+			return end()
+		}
+		var c chatResponse
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("invalid chat chunk: %w", err)
+		}
+
+		if !started {
+			started = true
+			if err := writeEvent(dst, "message_start", map[string]any{"type": "message_start", "message": messagesResponse{
+				ID: c.ID, Type: "message", Role: "assistant", Model: c.Model, Content: []block{},
+			}}); err != nil {
+				return err
+			}
+		}
+		if c.Usage != nil {
+			usage = anthropicUsage{InputTokens: c.Usage.PromptTokens, OutputTokens: c.Usage.CompletionTokens}
+		}
+		if len(c.Choices) == 0 {
+			return nil
+		}
+
+		choice := c.Choices[0]
+		if d := choice.Delta; d != nil {
+			if d.Content != nil && *d.Content != "" {
+				if open < 0 || openType != "text" {
+					if err := openBlock("text", map[string]string{"type": "text", "text": ""}); err != nil {
+						return err
+					}
+				}
+				if err := writeEvent(dst, "content_block_delta", map[string]any{
+					"type": "content_block_delta", "index": open,
+					"delta": map[string]string{"type": "text_delta", "text": *d.Content},
+				}); err != nil {
+					return err
+				}
+			}
+			for _, call := range d.ToolCalls {
+				index := 0
+				if call.Index != nil {
+					index = *call.Index
+				}
+				if _, seen := toolCall[index]; !seen || call.ID != "" {
+					if err := openBlock("tool_use", block{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: json.RawMessage(`{}`)}); err != nil {
+						return err
+					}
+					toolCall[index] = open
+				}
+				if call.Function.Arguments != "" {
+					if err := writeEvent(dst, "content_block_delta", map[string]any{
+						"type": "content_block_delta", "index": toolCall[index],
+						"delta": map[string]string{"type": "input_json_delta", "partial_json": call.Function.Arguments},
+					}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if choice.FinishReason != nil {
+			stop = stopReason(*choice.FinishReason)
+		}
+		return nil
+	}
+	// Streams cut short of [DONE] are still closed off
+	s.finish = end
+	return s
+}
+
+func writeData(dst io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(dst, "data: %s\n\n", data)
+	return err
+}
+
+func writeEvent(dst io.Writer, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(dst, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
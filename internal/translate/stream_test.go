@@ -0,0 +1,153 @@
+package translate
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// events splits an SSE stream into (event, data) pairs.
+func events(t *testing.T, stream string) [][2]string {
+	t.Helper()
+	var out [][2]string
+	for _, raw := range strings.Split(strings.TrimSpace(stream), "\n\n") {
+		var event, data string
+		for _, line := range strings.Split(raw, "\n") {
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				event = v
+			} else if v, ok := strings.CutPrefix(line, "data: "); ok {
+				data = v
+			}
+		}
+		out = append(out, [2]string{event, data})
+	}
+	return out
+}
+
+func TestMessagesToChatStream(t *testing.T) {
+	t.Parallel()
+
+	upstream := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude-sonnet\",\"usage\":{\"input_tokens\":12,\"output_tokens\":1}}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+		"event: ping\ndata: {\"type\":\"ping\"}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hel\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"lo\"}}\n\n" +
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"tu_1\",\"name\":\"weather\",\"input\":{}}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"city\\\":\\\"Oslo\\\"}\"}}\n\n" +
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":1}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":7}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+
+	var out bytes.Buffer
+	s := NewMessagesToChatStream(&out, true)
+	// Write in small pieces to exercise events split across writes
+	for i := 0; i < len(upstream); i += 7 {
+		if _, err := s.Write([]byte(upstream[i:min(i+7, len(upstream))])); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	got := events(t, out.String())
+	if n := len(got); n != 8 || got[n-1][1] != "[DONE]" {
+		t.Fatalf("expected 8 chunks ending in [DONE], got %d:\n%s", n, out.String())
+	}
+
+	var text, args string
+	var finish string
+	var usage *chatUsage
+	for _, ev := range got[:len(got)-1] {
+		var c chatResponse
+		if err := json.Unmarshal([]byte(ev[1]), &c); err != nil {
+			t.Fatalf("invalid chunk %s: %v", ev[1], err)
+		}
+		if c.Object != "chat.completion.chunk" || c.ID != "msg_1" || c.Model != "claude-sonnet" {
+			t.Errorf("unexpected chunk envelope: %s", ev[1])
+		}
+		if c.Usage != nil {
+			usage = c.Usage
+		}
+		for _, choice := range c.Choices {
+			if choice.Delta.Content != nil {
+				text += *choice.Delta.Content
+			}
+			for _, call := range choice.Delta.ToolCalls {
+				args += call.Function.Arguments
+			}
+			if choice.FinishReason != nil {
+				finish = *choice.FinishReason
+			}
+		}
+	}
+	if text != "Hello" || args != `{"city":"Oslo"}` || finish != "tool_calls" {
+		t.Errorf("unexpected stream content: text=%q args=%q finish=%q", text, args, finish)
+	}
+	if usage == nil || usage.PromptTokens != 12 || usage.CompletionTokens != 7 || usage.TotalTokens != 19 {
+		t.Errorf("unexpected usage chunk: %+v", usage)
+	}
+}
+
+func TestChatToMessagesStream(t *testing.T) {
+	t.Parallel()
+
+	upstream := `data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}` + "\n\n" +
+		`data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":null}]}` + "\n\n" +
+		`data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":""}}]},"finish_reason":null}]}` + "\n\n" +
+		`data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}}]},"finish_reason":null}]}` + "\n\n" +
+		`data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}` + "\n\n" +
+		`data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[],"usage":{"prompt_tokens":9,"completion_tokens":4,"total_tokens":13}}` + "\n\n" +
+		"data: [DONE]\n\n"
+
+	var out bytes.Buffer
+	s := NewChatToMessagesStream(&out)
+	if _, err := s.Write([]byte(upstream)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	var names []string
+	for _, ev := range events(t, out.String()) {
+		names = append(names, ev[0])
+	}
+	want := "message_start content_block_start content_block_delta content_block_stop content_block_start content_block_delta content_block_stop message_delta message_stop"
+	if got := strings.Join(names, " "); got != want {
+		t.Fatalf("unexpected events:\n got %s\nwant %s", got, want)
+	}
+	if !strings.Contains(out.String(), `"stop_reason":"tool_use"`) || !strings.Contains(out.String(), `"usage":{"input_tokens":9,"output_tokens":4}`) {
+		t.Errorf("expected stop reason and usage in message_delta:\n%s", out.String())
+	}
+}
+
+func TestChatToMessagesStream_ClosesTruncatedStream(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	s := NewChatToMessagesStream(&out)
+	s.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"Hi"}}]}` + "\n\n"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(out.String()), `data: {"type":"message_stop"}`) {
+		t.Errorf("expected stream to be closed with message_stop:\n%s", out.String())
+	}
+}
+
+func TestStream_PassesCommentsThrough(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	s := NewMessagesToChatStream(&out, false)
+	s.Write([]byte("event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n: portus {\"alias\":\"m\"}\n\n"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if want := "data: [DONE]\n\n: portus {\"alias\":\"m\"}\n\n"; out.String() != want {
+		t.Errorf("unexpected stream:\n got %q\nwant %q", out.String(), want)
+	}
+}
@@ -0,0 +1,611 @@
+// Package translate converts between the OpenAI Chat Completions and Anthropic
+// Messages wire formats, so a client can use either API against an alias
+// whose upstream only speaks the other. Requests, JSON responses and
+// server-sent event streams are covered; fields with no counterpart in the
+// other format are dropped.
+package translate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amscotti/portus/internal/finishreason"
+)
+
+// Format names a wire protocol.
+type Format string
+
+// Supported formats.
+const (
+	OpenAI    Format = "openai"
+	Anthropic Format = "anthropic"
+)
+
+// ParseFormat validates a configured format name. The empty string means the
+// alias is called in whichever format the client used.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "", OpenAI, Anthropic:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown API format %q (want %q or %q)", s, OpenAI, Anthropic)
+}
+
+// OpenAI chat wire types.
+type (
+	chatRequest struct {
+		Model               string          `json:"model"`
+		Messages            []chatMessage   `json:"messages"`
+		MaxTokens           int             `json:"max_tokens,omitempty"`
+		MaxCompletionTokens int             `json:"max_completion_tokens,omitempty"`
+		Temperature         *float64        `json:"temperature,omitempty"`
+		TopP                *float64        `json:"top_p,omitempty"`
+		Stop                json.RawMessage `json:"stop,omitempty"`
+		Stream              bool            `json:"stream,omitempty"`
+		StreamOptions       *streamOptions  `json:"stream_options,omitempty"`
+		User                string          `json:"user,omitempty"`
+		Tools               []chatTool      `json:"tools,omitempty"`
+		ToolChoice          json.RawMessage `json:"tool_choice,omitempty"`
+	}
+
+	streamOptions struct {
+		IncludeUsage bool `json:"include_usage"`
+	}
+
+	chatMessage struct {
+		Role       string          `json:"role"`
+		Content    json.RawMessage `json:"content"`
+		ToolCalls  []chatToolCall  `json:"tool_calls,omitempty"`
+		ToolCallID string          `json:"tool_call_id,omitempty"`
+	}
+
+	chatPart struct {
+		Type     string `json:"type"`
+		Text     string `json:"text,omitempty"`
+		ImageURL *struct {
+			URL string `json:"url"`
+		} `json:"image_url,omitempty"`
+	}
+
+	chatToolCall struct {
+		Index    *int         `json:"index,omitempty"`
+		ID       string       `json:"id,omitempty"`
+		Type     string       `json:"type,omitempty"`
+		Function chatFunction `json:"function"`
+	}
+
+	chatFunction struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	}
+
+	chatTool struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name        string          `json:"name"`
+			Description string          `json:"description,omitempty"`
+			Parameters  json.RawMessage `json:"parameters,omitempty"`
+		} `json:"function"`
+	}
+
+	chatResponse struct {
+		ID      string       `json:"id"`
+		Object  string       `json:"object"`
+		Created int64        `json:"created"`
+		Model   string       `json:"model"`
+		Choices []chatChoice `json:"choices"`
+		Usage   *chatUsage   `json:"usage,omitempty"`
+	}
+
+	chatChoice struct {
+		Index        int          `json:"index"`
+		Message      *chatMessage `json:"message,omitempty"`
+		Delta        *chatDelta   `json:"delta,omitempty"`
+		FinishReason *string      `json:"finish_reason"`
+	}
+
+	chatDelta struct {
+		Role      string         `json:"role,omitempty"`
+		Content   *string        `json:"content,omitempty"`
+		ToolCalls []chatToolCall `json:"tool_calls,omitempty"`
+	}
+
+	chatUsage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	}
+)
+
+// Anthropic messages wire types.
+type (
+	messagesRequest struct {
+		Model         string             `json:"model"`
+		System        json.RawMessage    `json:"system,omitempty"`
+		Messages      []anthropicMessage `json:"messages"`
+		MaxTokens     int                `json:"max_tokens"`
+		Temperature   *float64           `json:"temperature,omitempty"`
+		TopP          *float64           `json:"top_p,omitempty"`
+		StopSequences []string           `json:"stop_sequences,omitempty"`
+		Stream        bool               `json:"stream,omitempty"`
+		Metadata      *struct {
+			UserID string `json:"user_id,omitempty"`
+		} `json:"metadata,omitempty"`
+		Tools      []anthropicTool `json:"tools,omitempty"`
+		ToolChoice *toolChoice     `json:"tool_choice,omitempty"`
+	}
+
+	anthropicMessage struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+
+	block struct {
+		Type      string          `json:"type"`
+		Text      string          `json:"text,omitempty"`
+		ID        string          `json:"id,omitempty"`
+		Name      string          `json:"name,omitempty"`
+		Input     json.RawMessage `json:"input,omitempty"`
+		ToolUseID string          `json:"tool_use_id,omitempty"`
+		Content   json.RawMessage `json:"content,omitempty"`
+		Source    *imageSource    `json:"source,omitempty"`
+	}
+
+	imageSource struct {
+		Type      string `json:"type"`
+		MediaType string `json:"media_type,omitempty"`
+		Data      string `json:"data,omitempty"`
+		URL       string `json:"url,omitempty"`
+	}
+
+	anthropicTool struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		InputSchema json.RawMessage `json:"input_schema"`
+	}
+
+	toolChoice struct {
+		Type string `json:"type"`
+		Name string `json:"name,omitempty"`
+	}
+
+	messagesResponse struct {
+		ID           string         `json:"id"`
+		Type         string         `json:"type"`
+		Role         string         `json:"role"`
+		Model        string         `json:"model"`
+		Content      []block        `json:"content"`
+		StopReason   *string        `json:"stop_reason"`
+		StopSequence *string        `json:"stop_sequence"`
+		Usage        anthropicUsage `json:"usage"`
+	}
+
+	anthropicUsage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	}
+)
+
+// ChatRequestToMessages converts an OpenAI chat completions request into an
+// Anthropic messages request. Anthropic requires max_tokens, so
+// defaultMaxTokens is used when the request sets neither max_tokens nor
+// max_completion_tokens.
+func ChatRequestToMessages(body []byte, defaultMaxTokens int) ([]byte, error) {
+	var in chatRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	out := messagesRequest{
+		Model:       in.Model,
+		MaxTokens:   in.MaxTokens,
+		Temperature: in.Temperature,
+		TopP:        in.TopP,
+		Stream:      in.Stream,
+	}
+	if in.MaxCompletionTokens > 0 {
+		out.MaxTokens = in.MaxCompletionTokens
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = defaultMaxTokens
+	}
+	if in.User != "" {
+		out.Metadata = &struct {
+			UserID string `json:"user_id,omitempty"`
+		}{UserID: in.User}
+	}
+	if len(in.Stop) > 0 {
+		var one string
+		if err := json.Unmarshal(in.Stop, &one); err == nil {
+			out.StopSequences = []string{one}
+		} else if err := json.Unmarshal(in.Stop, &out.StopSequences); err != nil {
+			return nil, fmt.Errorf("invalid stop: %w", err)
+		}
+	}
+	for _, tool := range in.Tools {
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object"}`)
+		}
+		out.Tools = append(out.Tools, anthropicTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+	}
+	if len(in.ToolChoice) > 0 {
+		choice, err := chatToolChoiceToAnthropic(in.ToolChoice)
+		if err != nil {
+			return nil, err
+		}
+		out.ToolChoice = choice
+	}
+
+	var system []string
+	for _, m := range in.Messages {
+		switch m.Role {
+		case "system", "developer":
+			system = append(system, chatText(m.Content))
+		case "tool":
+			result, _ := json.Marshal(chatText(m.Content))
+			out.Messages = appendBlocks(out.Messages, "user", block{Type: "tool_result", ToolUseID: m.ToolCallID, Content: result})
+		case "assistant":
+			blocks := chatContentToBlocks(m.Content)
+			for _, call := range m.ToolCalls {
+				blocks = append(blocks, toolUseBlock(call))
+			}
+			out.Messages = appendBlocks(out.Messages, "assistant", blocks...)
+		default:
+			out.Messages = appendBlocks(out.Messages, "user", chatContentToBlocks(m.Content)...)
+		}
+	}
+	if len(system) > 0 {
+		out.System, _ = json.Marshal(strings.Join(system, "\n\n"))
+	}
+
+	return json.Marshal(out)
+}
+
+// MessagesRequestToChat converts an Anthropic messages request into an OpenAI
+// chat completions request. Streams ask for usage so the translated stream
+// can report token counts.
+func MessagesRequestToChat(body []byte) ([]byte, error) {
+	var in messagesRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	out := chatRequest{
+		Model:       in.Model,
+		MaxTokens:   in.MaxTokens,
+		Temperature: in.Temperature,
+		TopP:        in.TopP,
+		Stream:      in.Stream,
+	}
+	if in.Stream {
+		out.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	if in.Metadata != nil {
+		out.User = in.Metadata.UserID
+	}
+	if len(in.StopSequences) > 0 {
+		out.Stop, _ = json.Marshal(in.StopSequences)
+	}
+	for _, tool := range in.Tools {
+		var t chatTool
+		t.Type = "function"
+		t.Function.Name = tool.Name
+		t.Function.Description = tool.Description
+		t.Function.Parameters = tool.InputSchema
+		out.Tools = append(out.Tools, t)
+	}
+	if in.ToolChoice != nil {
+		out.ToolChoice = anthropicToolChoiceToChat(in.ToolChoice)
+	}
+
+	if system := anthropicText(in.System); system != "" {
+		out.Messages = append(out.Messages, chatMessage{Role: "system", Content: jsonString(system)})
+	}
+	for _, m := range in.Messages {
+		blocks, err := anthropicBlocks(m.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		var parts []chatPart
+		var calls []chatToolCall
+		for _, b := range blocks {
+			switch b.Type {
+			case "text":
+				parts = append(parts, chatPart{Type: "text", Text: b.Text})
+			case "image":
+				if url := imageURL(b.Source); url != "" {
+					part := chatPart{Type: "image_url"}
+					part.ImageURL = &struct {
+						URL string `json:"url"`
+					}{URL: url}
+					parts = append(parts, part)
+				}
+			case "tool_use":
+				input := string(b.Input)
+				if input == "" {
+					input = "{}"
+				}
+				calls = append(calls, chatToolCall{ID: b.ID, Type: "function", Function: chatFunction{Name: b.Name, Arguments: input}})
+			case "tool_result":
+				// Tool results become their own messages, ahead of any text
+				out.Messages = append(out.Messages, chatMessage{Role: "tool", ToolCallID: b.ToolUseID, Content: jsonString(anthropicText(b.Content))})
+			}
+		}
+
+		if len(parts) == 0 && len(calls) == 0 {
+			continue
+		}
+		msg := chatMessage{Role: m.Role, ToolCalls: calls}
+		switch {
+		case len(parts) == 0:
+			msg.Content = json.RawMessage("null")
+		case len(parts) == 1 && parts[0].Type == "text":
+			msg.Content = jsonString(parts[0].Text)
+		default:
+			msg.Content, _ = json.Marshal(parts)
+		}
+		out.Messages = append(out.Messages, msg)
+	}
+
+	return json.Marshal(out)
+}
+
+// MessagesResponseToChat converts an Anthropic messages response into an
+// OpenAI chat completion.
+func MessagesResponseToChat(body []byte) ([]byte, error) {
+	var in messagesResponse
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	msg := chatMessage{Role: "assistant", Content: json.RawMessage("null")}
+	var text strings.Builder
+	for _, b := range in.Content {
+		switch b.Type {
+		case "text":
+			text.WriteString(b.Text)
+		case "tool_use":
+			input := string(b.Input)
+			if input == "" {
+				input = "{}"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, chatToolCall{ID: b.ID, Type: "function", Function: chatFunction{Name: b.Name, Arguments: input}})
+		}
+	}
+	if text.Len() > 0 || len(msg.ToolCalls) == 0 {
+		msg.Content = jsonString(text.String())
+	}
+
+	var finish *string
+	if in.StopReason != nil {
+		reason := finishreason.Normalize(*in.StopReason)
+		finish = &reason
+	}
+
+	return json.Marshal(chatResponse{
+		ID:      in.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   in.Model,
+		Choices: []chatChoice{{Message: &msg, FinishReason: finish}},
+		Usage: &chatUsage{
+			PromptTokens:     in.Usage.InputTokens,
+			CompletionTokens: in.Usage.OutputTokens,
+			TotalTokens:      in.Usage.InputTokens + in.Usage.OutputTokens,
+		},
+	})
+}
+
+// ChatResponseToMessages converts an OpenAI chat completion into an Anthropic
+// messages response. Only the first choice is kept.
+func ChatResponseToMessages(body []byte) ([]byte, error) {
+	var in chatResponse
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	out := messagesResponse{
+		ID:      in.ID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   in.Model,
+		Content: []block{},
+	}
+	if in.Usage != nil {
+		out.Usage = anthropicUsage{InputTokens: in.Usage.PromptTokens, OutputTokens: in.Usage.CompletionTokens}
+	}
+	if len(in.Choices) > 0 {
+		choice := in.Choices[0]
+		if choice.Message != nil {
+			if text := chatText(choice.Message.Content); text != "" {
+				out.Content = append(out.Content, block{Type: "text", Text: text})
+			}
+			for _, call := range choice.Message.ToolCalls {
+				out.Content = append(out.Content, toolUseBlock(call))
+			}
+		}
+		if choice.FinishReason != nil {
+			reason := stopReason(*choice.FinishReason)
+			out.StopReason = &reason
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// stopReason maps an OpenAI finish reason onto Anthropic's vocabulary.
+func stopReason(finish string) string {
+	switch finishreason.Normalize(finish) {
+	case finishreason.Length:
+		return "max_tokens"
+	case finishreason.ToolCalls:
+		return "tool_use"
+	case finishreason.ContentFilter:
+		return "refusal"
+	}
+	return "end_turn"
+}
+
+func toolUseBlock(call chatToolCall) block {
+	input := json.RawMessage(call.Function.Arguments)
+	if !json.Valid(input) {
+		input = json.RawMessage(`{}`)
+	}
+	return block{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input}
+}
+
+// appendBlocks adds blocks as a message from role, merging into the previous
+// message when it has the same role because Anthropic expects turns to
+// alternate.
+func appendBlocks(msgs []anthropicMessage, role string, blocks ...block) []anthropicMessage {
+	if len(blocks) == 0 {
+		return msgs
+	}
+	if n := len(msgs); n > 0 && msgs[n-1].Role == role {
+		var prev []block
+		json.Unmarshal(msgs[n-1].Content, &prev)
+		msgs[n-1].Content, _ = json.Marshal(append(prev, blocks...))
+		return msgs
+	}
+	content, _ := json.Marshal(blocks)
+	return append(msgs, anthropicMessage{Role: role, Content: content})
+}
+
+// chatContentToBlocks converts string or part-array chat content into
+// Anthropic content blocks.
+func chatContentToBlocks(content json.RawMessage) []block {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		if text == "" {
+			return nil
+		}
+		return []block{{Type: "text", Text: text}}
+	}
+
+	var parts []chatPart
+	json.Unmarshal(content, &parts)
+	var blocks []block
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			blocks = append(blocks, block{Type: "text", Text: p.Text})
+		case "image_url":
+			if p.ImageURL != nil {
+				blocks = append(blocks, block{Type: "image", Source: imageSourceFromURL(p.ImageURL.URL)})
+			}
+		}
+	}
+	return blocks
+}
+
+// chatText flattens string or part-array chat content into text.
+func chatText(content json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text
+	}
+	var parts []chatPart
+	json.Unmarshal(content, &parts)
+	var b strings.Builder
+	for _, p := range parts {
+		if p.Type == "text" {
+			b.WriteString(p.Text)
+		}
+	}
+	return b.String()
+}
+
+// anthropicBlocks decodes string or block-array Anthropic content.
+func anthropicBlocks(content json.RawMessage) ([]block, error) {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return []block{{Type: "text", Text: text}}, nil
+	}
+	var blocks []block
+	if err := json.Unmarshal(content, &blocks); err != nil {
+		return nil, fmt.Errorf("invalid message content: %w", err)
+	}
+	return blocks, nil
+}
+
+// anthropicText flattens string or block-array Anthropic content into text.
+func anthropicText(content json.RawMessage) string {
+	if len(content) == 0 {
+		return ""
+	}
+	blocks, _ := anthropicBlocks(content)
+	var texts []string
+	for _, b := range blocks {
+		if b.Type == "text" {
+			texts = append(texts, b.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// imageSourceFromURL turns a data: URL into an inline image and anything else
+// into a URL reference.
+func imageSourceFromURL(url string) *imageSource {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		if meta, data, ok := strings.Cut(rest, ","); ok {
+			if mediaType, ok := strings.CutSuffix(meta, ";base64"); ok {
+				return &imageSource{Type: "base64", MediaType: mediaType, Data: data}
+			}
+		}
+	}
+	return &imageSource{Type: "url", URL: url}
+}
+
+func imageURL(source *imageSource) string {
+	if source == nil {
+		return ""
+	}
+	if source.Type == "base64" {
+		return "data:" + source.MediaType + ";base64," + source.Data
+	}
+	return source.URL
+}
+
+func chatToolChoiceToAnthropic(raw json.RawMessage) (*toolChoice, error) {
+	var mode string
+	if err := json.Unmarshal(raw, &mode); err == nil {
+		switch mode {
+		case "none":
+			return &toolChoice{Type: "none"}, nil
+		case "required":
+			return &toolChoice{Type: "any"}, nil
+		}
+		return &toolChoice{Type: "auto"}, nil
+	}
+	var named struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, fmt.Errorf("invalid tool_choice: %w", err)
+	}
+	return &toolChoice{Type: "tool", Name: named.Function.Name}, nil
+}
+
+func anthropicToolChoiceToChat(choice *toolChoice) json.RawMessage {
+	switch choice.Type {
+	case "none":
+		return jsonString("none")
+	case "any":
+		return jsonString("required")
+	case "tool":
+		raw, _ := json.Marshal(map[string]any{"type": "function", "function": map[string]string{"name": choice.Name}})
+		return raw
+	}
+	return jsonString("auto")
+}
+
+func jsonString(s string) json.RawMessage {
+	raw, _ := json.Marshal(s)
+	return raw
+}
@@ -0,0 +1,135 @@
+package translate
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decode unmarshals JSON into a generic value for comparison.
+func decode(t *testing.T, data []byte) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	return v
+}
+
+func TestChatRequestToMessages(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "system, stop and default max_tokens",
+			in:   `{"model":"claude","messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"Hi"}],"stop":"END","temperature":0.2,"stream":true,"user":"u1"}`,
+			want: `{"model":"claude","system":"Be brief.","messages":[{"role":"user","content":[{"type":"text","text":"Hi"}]}],"max_tokens":4096,"temperature":0.2,"stop_sequences":["END"],"stream":true,"metadata":{"user_id":"u1"}}`,
+		},
+		{
+			name: "tools and tool results",
+			in: `{"model":"claude","max_completion_tokens":100,"tool_choice":"required",` +
+				`"tools":[{"type":"function","function":{"name":"weather","parameters":{"type":"object"}}}],` +
+				`"messages":[{"role":"user","content":"Weather?"},` +
+				`{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Oslo\"}"}}]},` +
+				`{"role":"tool","tool_call_id":"call_1","content":"Sunny"},{"role":"user","content":"Thanks"}]}`,
+			want: `{"model":"claude","max_tokens":100,"tool_choice":{"type":"any"},` +
+				`"tools":[{"name":"weather","input_schema":{"type":"object"}}],` +
+				`"messages":[{"role":"user","content":[{"type":"text","text":"Weather?"}]},` +
+				`{"role":"assistant","content":[{"type":"tool_use","id":"call_1","name":"weather","input":{"city":"Oslo"}}]},` +
+				`{"role":"user","content":[{"type":"tool_result","tool_use_id":"call_1","content":"Sunny"},{"type":"text","text":"Thanks"}]}]}`,
+		},
+		{
+			name: "image parts",
+			in:   `{"model":"claude","messages":[{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBO"}}]}]}`,
+			want: `{"model":"claude","max_tokens":4096,"messages":[{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBO"}}]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ChatRequestToMessages([]byte(tt.in), 4096)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(decode(t, got), decode(t, []byte(tt.want))) {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagesRequestToChat(t *testing.T) {
+	t.Parallel()
+
+	in := `{"model":"gpt4","system":[{"type":"text","text":"Be brief."}],"max_tokens":50,"stream":true,` +
+		`"tool_choice":{"type":"tool","name":"weather"},` +
+		`"tools":[{"name":"weather","input_schema":{"type":"object"}}],` +
+		`"messages":[{"role":"user","content":"Weather?"},` +
+		`{"role":"assistant","content":[{"type":"text","text":"Checking."},{"type":"tool_use","id":"tu_1","name":"weather","input":{"city":"Oslo"}}]},` +
+		`{"role":"user","content":[{"type":"tool_result","tool_use_id":"tu_1","content":[{"type":"text","text":"Sunny"}]}]}]}`
+	want := `{"model":"gpt4","max_tokens":50,"stream":true,"stream_options":{"include_usage":true},` +
+		`"tool_choice":{"type":"function","function":{"name":"weather"}},` +
+		`"tools":[{"type":"function","function":{"name":"weather","parameters":{"type":"object"}}}],` +
+		`"messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"Weather?"},` +
+		`{"role":"assistant","content":"Checking.","tool_calls":[{"id":"tu_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Oslo\"}"}}]},` +
+		`{"role":"tool","tool_call_id":"tu_1","content":"Sunny"}]}`
+
+	got, err := MessagesRequestToChat([]byte(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decode(t, got), decode(t, []byte(want))) {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestResponses(t *testing.T) {
+	t.Parallel()
+
+	anthropic := `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet","content":[{"type":"text","text":"Hello"},{"type":"tool_use","id":"tu_1","name":"weather","input":{"city":"Oslo"}}],"stop_reason":"tool_use","stop_sequence":null,"usage":{"input_tokens":10,"output_tokens":5}}`
+
+	chat, err := MessagesResponseToChat([]byte(anthropic))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var resp chatResponse
+	json.Unmarshal(chat, &resp)
+	if resp.Object != "chat.completion" || resp.ID != "msg_1" || len(resp.Choices) != 1 {
+		t.Fatalf("unexpected chat response: %s", chat)
+	}
+	choice := resp.Choices[0]
+	if *choice.FinishReason != "tool_calls" || chatText(choice.Message.Content) != "Hello" || len(choice.Message.ToolCalls) != 1 {
+		t.Errorf("unexpected choice: %s", chat)
+	}
+	if resp.Usage.PromptTokens != 10 || resp.Usage.CompletionTokens != 5 || resp.Usage.TotalTokens != 15 {
+		t.Errorf("unexpected usage: %+v", resp.Usage)
+	}
+
+	// Round-tripping back to Anthropic restores the content
+	back, err := ChatResponseToMessages(chat)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decode(t, back), decode(t, []byte(anthropic))) {
+		t.Errorf("got  %s\nwant %s", back, anthropic)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, valid := range []string{"", "openai", "anthropic"} {
+		if _, err := ParseFormat(valid); err != nil {
+			t.Errorf("expected %q to be valid, got %v", valid, err)
+		}
+	}
+	if _, err := ParseFormat("gemini"); err == nil || !strings.Contains(err.Error(), "gemini") {
+		t.Errorf("expected error naming the format, got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Resolution is the bucket width of a usage history series.
+type Resolution string
+
+const (
+	Minute Resolution = "minute"
+	Hour   Resolution = "hour"
+	Day    Resolution = "day"
+)
+
+// width returns the bucket duration for the resolution.
+func (r Resolution) width() time.Duration {
+	switch r {
+	case Minute:
+		return time.Minute
+	case Hour:
+		return time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+// ParseResolution validates a resolution name.
+func ParseResolution(s string) (Resolution, error) {
+	switch r := Resolution(s); r {
+	case Minute, Hour, Day:
+		return r, nil
+	}
+	return "", fmt.Errorf("invalid resolution %q: must be minute, hour or day", s)
+}
+
+// Retention bounds how long each resolution is kept. Minute buckets older
+// than Raw are folded into hourly buckets, hourly buckets older than Hourly
+// into daily buckets, and daily buckets older than Daily are dropped.
+type Retention struct {
+	Raw    time.Duration
+	Hourly time.Duration
+	Daily  time.Duration
+}
+
+// Bucket is the usage of one application and alias within a time window.
+type Bucket struct {
+	Start            time.Time `json:"start"`
+	Application      string    `json:"application"`
+	ModelAlias       string    `json:"model_alias"`
+	Requests         int64     `json:"requests"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	TotalTokens      int64     `json:"total_tokens"`
+	EstimatedCostUSD float64   `json:"estimated_cost_usd"`
+}
+
+func (b *Bucket) add(other *Bucket) {
+	b.Requests += other.Requests
+	b.PromptTokens += other.PromptTokens
+	b.CompletionTokens += other.CompletionTokens
+	b.TotalTokens += other.TotalTokens
+	b.EstimatedCostUSD += other.EstimatedCostUSD
+}
+
+type bucketKey struct {
+	application string
+	modelAlias  string
+	start       int64
+}
+
+// History keeps time-bucketed usage, downsampling older data so memory stays
+// bounded while long-term trends remain available. A nil History discards
+// records. It is safe for concurrent use.
+type History struct {
+	retention Retention
+
+	mu    sync.Mutex
+	tiers map[Resolution]map[bucketKey]*Bucket
+}
+
+// NewHistory creates an empty history with the given retention.
+func NewHistory(retention Retention) *History {
+	return &History{
+		retention: retention,
+		tiers: map[Resolution]map[bucketKey]*Bucket{
+			Minute: {},
+			Hour:   {},
+			Day:    {},
+		},
+	}
+}
+
+// Record adds a request's usage to the minute bucket containing at.
+func (h *History) Record(application, modelAlias string, u Usage, cost float64, at time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.merge(Minute, &Bucket{
+		Start:            at,
+		Application:      application,
+		ModelAlias:       modelAlias,
+		Requests:         1,
+		PromptTokens:     int64(u.PromptTokens),
+		CompletionTokens: int64(u.CompletionTokens),
+		TotalTokens:      int64(u.TotalTokens()),
+		EstimatedCostUSD: cost,
+	})
+}
+
+// merge adds b into the bucket of resolution r that contains b.Start.
+// The caller must hold h.mu.
+func (h *History) merge(r Resolution, b *Bucket) {
+	start := b.Start.UTC().Truncate(r.width())
+	key := bucketKey{application: b.Application, modelAlias: b.ModelAlias, start: start.Unix()}
+	entry, ok := h.tiers[r][key]
+	if !ok {
+		entry = &Bucket{Start: start, Application: b.Application, ModelAlias: b.ModelAlias}
+		h.tiers[r][key] = entry
+	}
+	entry.add(b)
+}
+
+// Compact downsamples and expires buckets relative to now.
+func (h *History) Compact(now time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.rollUp(Minute, Hour, now.Add(-h.retention.Raw))
+	h.rollUp(Hour, Day, now.Add(-h.retention.Hourly))
+	for key, b := range h.tiers[Day] {
+		if b.Start.Add(Day.width()).Before(now.Add(-h.retention.Daily)) {
+			delete(h.tiers[Day], key)
+		}
+	}
+}
+
+// rollUp moves buckets of tier from that ended before cutoff into tier to.
+func (h *History) rollUp(from, to Resolution, cutoff time.Time) {
+	for key, b := range h.tiers[from] {
+		if b.Start.Add(from.width()).After(cutoff) {
+			continue
+		}
+		h.merge(to, b)
+		delete(h.tiers[from], key)
+	}
+}
+
+// Series returns buckets at resolution r for an application (all applications
+// if empty) starting at or after since. Finer-grained buckets that have not
+// been downsampled yet are aggregated into r, so a series is always complete.
+// Buckets are sorted by start time, application and alias.
+func (h *History) Series(application string, r Resolution, since time.Time) []Bucket {
+	if h == nil {
+		return []Bucket{}
+	}
+	result := NewHistory(Retention{})
+	h.mu.Lock()
+	for _, tier := range []Resolution{Minute, Hour, Day} {
+		if tier.width() > r.width() {
+			break
+		}
+		for _, b := range h.tiers[tier] {
+			if application != "" && b.Application != application {
+				continue
+			}
+			if b.Start.Before(since.UTC().Truncate(tier.width())) {
+				continue
+			}
+			result.merge(r, b)
+		}
+	}
+	h.mu.Unlock()
+
+	series := make([]Bucket, 0, len(result.tiers[r]))
+	for _, b := range result.tiers[r] {
+		series = append(series, *b)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if !series[i].Start.Equal(series[j].Start) {
+			return series[i].Start.Before(series[j].Start)
+		}
+		if series[i].Application != series[j].Application {
+			return series[i].Application < series[j].Application
+		}
+		return series[i].ModelAlias < series[j].ModelAlias
+	})
+	return series
+}
+
+// Run compacts the history every interval until ctx is canceled.
+func (h *History) Run(ctx context.Context, interval time.Duration) {
+	if h == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.Compact(now)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package usage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory_DownsamplesAndExpires(t *testing.T) {
+	t.Parallel()
+
+	h := NewHistory(Retention{Raw: time.Hour, Hourly: 48 * time.Hour, Daily: 10 * 24 * time.Hour})
+	now := time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)
+	u := Usage{PromptTokens: 10, CompletionTokens: 5}
+
+	h.Record("web", "gpt4", u, 0.1, now.Add(-5*time.Minute))
+	h.Record("web", "gpt4", u, 0.1, now.Add(-3*time.Hour))
+	h.Record("web", "gpt4", u, 0.1, now.Add(-3*time.Hour+time.Minute))
+	h.Record("web", "gpt4", u, 0.1, now.Add(-5*24*time.Hour))
+	h.Record("web", "gpt4", u, 0.1, now.Add(-30*24*time.Hour))
+	h.Compact(now)
+
+	if got := len(h.tiers[Minute]); got != 1 {
+		t.Errorf("expected 1 recent minute bucket, got %d", got)
+	}
+	if got := len(h.tiers[Hour]); got != 1 {
+		t.Errorf("expected 2 minutes 3h ago folded into 1 hourly bucket, got %d", got)
+	}
+	if got := len(h.tiers[Day]); got != 1 {
+		t.Errorf("expected the 5-day-old record as a daily bucket and the 30-day-old one dropped, got %d", got)
+	}
+
+	daily := h.Series("", Day, time.Time{})
+	var requests, tokens int64
+	for _, b := range daily {
+		requests += b.Requests
+		tokens += b.TotalTokens
+	}
+	if requests != 4 || tokens != 60 {
+		t.Errorf("expected daily series to include every retained record, got %d requests, %d tokens", requests, tokens)
+	}
+}
+
+func TestHistory_Series(t *testing.T) {
+	t.Parallel()
+
+	h := NewHistory(Retention{Raw: time.Hour, Hourly: time.Hour, Daily: time.Hour})
+	start := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	h.Record("web", "gpt4", Usage{PromptTokens: 1}, 0, start.Add(10*time.Minute))
+	h.Record("web", "gpt4", Usage{PromptTokens: 2}, 0, start.Add(20*time.Minute))
+	h.Record("web", "claude", Usage{PromptTokens: 4}, 0, start.Add(90*time.Minute))
+	h.Record("batch", "gpt4", Usage{PromptTokens: 8}, 0, start.Add(10*time.Minute))
+
+	hourly := h.Series("web", Hour, time.Time{})
+	if len(hourly) != 2 {
+		t.Fatalf("expected 2 hourly buckets, got %+v", hourly)
+	}
+	if !hourly[0].Start.Equal(start) || hourly[0].Requests != 2 || hourly[0].PromptTokens != 3 {
+		t.Errorf("unexpected first bucket: %+v", hourly[0])
+	}
+	if hourly[1].ModelAlias != "claude" {
+		t.Errorf("expected second bucket for claude, got %+v", hourly[1])
+	}
+
+	if recent := h.Series("web", Minute, start.Add(15*time.Minute)); len(recent) != 2 {
+		t.Errorf("expected since to exclude the first minute, got %+v", recent)
+	}
+
+	var nilHistory *History
+	nilHistory.Record("web", "gpt4", Usage{}, 0, start)
+	if got := nilHistory.Series("", Hour, time.Time{}); len(got) != 0 {
+		t.Errorf("expected empty series from nil history, got %+v", got)
+	}
+}
@@ -0,0 +1,334 @@
+// Package usage extracts token usage from upstream responses and aggregates it
+// per application and model alias.
+package usage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/amscotti/portus/internal/storage"
+)
+
+// Usage holds the token counts reported for a single request.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// TotalTokens returns the sum of prompt and completion tokens.
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// IsZero reports whether no token counts were recorded.
+func (u Usage) IsZero() bool {
+	return u.PromptTokens == 0 && u.CompletionTokens == 0
+}
+
+// merge overwrites fields with any non-zero values from other. Anthropic streams
+// report input tokens in message_start and output tokens in message_delta, so
+// partial updates must not clear earlier values.
+func (u *Usage) merge(other Usage) {
+	if other.PromptTokens > 0 {
+		u.PromptTokens = other.PromptTokens
+	}
+	if other.CompletionTokens > 0 {
+		u.CompletionTokens = other.CompletionTokens
+	}
+}
+
+// rawUsage covers both the OpenAI and Anthropic usage object shapes.
+type rawUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+}
+
+func (r *rawUsage) toUsage() Usage {
+	if r == nil {
+		return Usage{}
+	}
+	u := Usage{PromptTokens: r.PromptTokens, CompletionTokens: r.CompletionTokens}
+	if u.PromptTokens == 0 {
+		u.PromptTokens = r.InputTokens
+	}
+	if u.CompletionTokens == 0 {
+		u.CompletionTokens = r.OutputTokens
+	}
+	return u
+}
+
+// nestedUsage is an object wrapping a usage field.
+type nestedUsage struct {
+	Usage *rawUsage `json:"usage"`
+}
+
+// usageEnvelope matches a response body or stream event that may carry usage,
+// either at the top level, nested in an Anthropic message_start "message", or
+// nested in an OpenAI Responses API response.completed "response".
+type usageEnvelope struct {
+	Usage    *rawUsage    `json:"usage"`
+	Message  *nestedUsage `json:"message"`
+	Response *nestedUsage `json:"response"`
+}
+
+func (e *usageEnvelope) toUsage() Usage {
+	u := e.Usage.toUsage()
+	if e.Message != nil {
+		u.merge(e.Message.Usage.toUsage())
+	}
+	if e.Response != nil {
+		u.merge(e.Response.Usage.toUsage())
+	}
+	return u
+}
+
+// ParseResponse extracts usage from a non-streaming JSON response body.
+func ParseResponse(body []byte) (Usage, bool) {
+	var env usageEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return Usage{}, false
+	}
+	u := env.toUsage()
+	return u, !u.IsZero()
+}
+
+// StreamParser scans a server-sent event stream for usage data. It is fed the
+// raw bytes relayed to the client and tolerates events split across writes.
+type StreamParser struct {
+	pending []byte
+	usage   Usage
+}
+
+// Write consumes a chunk of the stream. It never returns an error so it can be
+// used alongside the client writer without affecting the relay.
+func (p *StreamParser) Write(b []byte) (int, error) {
+	p.pending = append(p.pending, b...)
+	for {
+		idx := bytes.IndexByte(p.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		p.parseLine(p.pending[:idx])
+		p.pending = p.pending[idx+1:]
+	}
+	return len(b), nil
+}
+
+// Usage returns the usage accumulated from the stream so far.
+func (p *StreamParser) Usage() Usage {
+	return p.usage
+}
+
+func (p *StreamParser) parseLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return
+	}
+	data = bytes.TrimSpace(data)
+	// Only decode events that can carry usage; most chunks are content deltas.
+	if !bytes.Contains(data, []byte(`"usage"`)) {
+		return
+	}
+	var env usageEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return
+	}
+	p.usage.merge(env.toUsage())
+}
+
+// Totals holds aggregated usage for one application and model alias.
+type Totals struct {
+	Application      string `json:"application"`
+	ModelAlias       string `json:"model_alias"`
+	Requests         int64  `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+	// EstimatedCostUSD is the accumulated estimated cost based on configured pricing.
+	EstimatedCostUSD float64 `json:"estimated_cost_usd"`
+	// FallbackResponses counts requests answered with the static fallback message.
+	FallbackResponses int64 `json:"fallback_responses"`
+	// CacheHits counts requests answered from the semantic cache.
+	CacheHits int64 `json:"cache_hits"`
+	// ClientCancellations, Timeouts and UpstreamErrors count gateway requests
+	// that ended early, by the reason passed to RecordTermination.
+	ClientCancellations int64 `json:"client_cancellations"`
+	Timeouts            int64 `json:"timeouts"`
+	UpstreamErrors      int64 `json:"upstream_errors"`
+}
+
+// Reasons a gateway request ended before its response was complete.
+const (
+	// TerminationClientCancelled means the client disconnected or cancelled.
+	TerminationClientCancelled = "client_cancelled"
+	// TerminationTimeout means the request's timeout or deadline passed.
+	TerminationTimeout = "timeout"
+	// TerminationUpstreamError means the gateway could not be reached or its
+	// response broke off.
+	TerminationUpstreamError = "upstream_error"
+)
+
+// Fields of Totals kept as counters, named after their JSON keys.
+const (
+	fieldRequests            = "requests"
+	fieldPromptTokens        = "prompt_tokens"
+	fieldCompletionTokens    = "completion_tokens"
+	fieldTotalTokens         = "total_tokens"
+	fieldEstimatedCostUSD    = "estimated_cost_usd"
+	fieldFallbackResponses   = "fallback_responses"
+	fieldCacheHits           = "cache_hits"
+	fieldClientCancellations = "client_cancellations"
+	fieldTimeouts            = "timeouts"
+	fieldUpstreamErrors      = "upstream_errors"
+)
+
+// Tracker aggregates token usage in a storage backend. It is safe for
+// concurrent use. Usage is best effort: counts that cannot be written to the
+// backend are dropped rather than failing the request.
+type Tracker struct {
+	backend storage.Backend
+}
+
+// NewTracker creates an empty usage tracker held in memory.
+func NewTracker() *Tracker {
+	return NewTrackerWithBackend(storage.NewMemory())
+}
+
+// NewTrackerWithBackend creates a usage tracker held in backend, which
+// replicas sharing it aggregate into together.
+func NewTrackerWithBackend(backend storage.Backend) *Tracker {
+	return &Tracker{backend: backend}
+}
+
+// Record adds the usage and estimated cost of a single request to the aggregates.
+func (t *Tracker) Record(application, modelAlias string, u Usage, cost float64) {
+	t.add(application, modelAlias, map[string]float64{
+		fieldRequests:         1,
+		fieldPromptTokens:     float64(u.PromptTokens),
+		fieldCompletionTokens: float64(u.CompletionTokens),
+		fieldTotalTokens:      float64(u.TotalTokens()),
+		fieldEstimatedCostUSD: cost,
+	})
+}
+
+// RecordFallback counts a request that was answered with the static fallback
+// message instead of a provider response.
+func (t *Tracker) RecordFallback(application, modelAlias string) {
+	t.add(application, modelAlias, map[string]float64{fieldRequests: 1, fieldFallbackResponses: 1})
+}
+
+// RecordCacheHit counts a request that was answered from the semantic cache
+// without reaching a provider.
+func (t *Tracker) RecordCacheHit(application, modelAlias string) {
+	t.add(application, modelAlias, map[string]float64{fieldRequests: 1, fieldCacheHits: 1})
+}
+
+// RecordTermination counts a gateway request that ended early for reason, one
+// of the Termination constants.
+func (t *Tracker) RecordTermination(application, modelAlias, reason string) {
+	var field string
+	switch reason {
+	case TerminationClientCancelled:
+		field = fieldClientCancellations
+	case TerminationTimeout:
+		field = fieldTimeouts
+	case TerminationUpstreamError:
+		field = fieldUpstreamErrors
+	default:
+		return
+	}
+	t.add(application, modelAlias, map[string]float64{field: 1})
+}
+
+// add increments the counters of an application and alias in one backend
+// write, so a failure drops the whole request rather than part of it. Every
+// aggregate has a request count, so Snapshot lists it even when its other
+// counters are zero.
+func (t *Tracker) add(application, modelAlias string, deltas map[string]float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	if _, ok := deltas[fieldRequests]; !ok {
+		deltas[fieldRequests] = 0
+	}
+	keyed := make(map[string]float64, len(deltas))
+	for field, delta := range deltas {
+		if delta == 0 && field != fieldRequests {
+			continue
+		}
+		keyed[storage.Key("usage", application, modelAlias, field)] = delta
+	}
+	t.backend.AddAll(ctx, keyed, 0)
+}
+
+// Snapshot returns a copy of the aggregates for the given application, or for
+// all applications if application is empty. Results are sorted by application
+// and alias.
+func (t *Tracker) Snapshot(application string) []Totals {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.Timeout)
+	defer cancel()
+
+	prefix := storage.Prefix("usage")
+	if application != "" {
+		prefix = storage.Prefix("usage", application)
+	}
+	values, err := t.backend.Scan(ctx, prefix)
+	if err != nil {
+		return []Totals{}
+	}
+
+	type totalsKey struct{ application, modelAlias string }
+	totals := make(map[totalsKey]*Totals)
+	for key, value := range values {
+		parts := storage.Split(key)
+		if len(parts) != 4 {
+			continue
+		}
+		k := totalsKey{application: parts[1], modelAlias: parts[2]}
+		entry, ok := totals[k]
+		if !ok {
+			entry = &Totals{Application: k.application, ModelAlias: k.modelAlias}
+			totals[k] = entry
+		}
+		n := storage.Number(value)
+		switch parts[3] {
+		case fieldRequests:
+			entry.Requests = int64(n)
+		case fieldPromptTokens:
+			entry.PromptTokens = int64(n)
+		case fieldCompletionTokens:
+			entry.CompletionTokens = int64(n)
+		case fieldTotalTokens:
+			entry.TotalTokens = int64(n)
+		case fieldEstimatedCostUSD:
+			entry.EstimatedCostUSD = n
+		case fieldFallbackResponses:
+			entry.FallbackResponses = int64(n)
+		case fieldCacheHits:
+			entry.CacheHits = int64(n)
+		case fieldClientCancellations:
+			entry.ClientCancellations = int64(n)
+		case fieldTimeouts:
+			entry.Timeouts = int64(n)
+		case fieldUpstreamErrors:
+			entry.UpstreamErrors = int64(n)
+		}
+	}
+
+	result := make([]Totals, 0, len(totals))
+	for _, entry := range totals {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Application != result[j].Application {
+			return result[i].Application < result[j].Application
+		}
+		return result[i].ModelAlias < result[j].ModelAlias
+	})
+	return result
+}
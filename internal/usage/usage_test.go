@@ -0,0 +1,222 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/storage"
+)
+
+func TestParseResponse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		body   string
+		want   Usage
+		wantOK bool
+	}{
+		{
+			name:   "openai usage",
+			body:   `{"id":"chatcmpl-1","usage":{"prompt_tokens":12,"completion_tokens":34,"total_tokens":46}}`,
+			want:   Usage{PromptTokens: 12, CompletionTokens: 34},
+			wantOK: true,
+		},
+		{
+			name:   "anthropic usage",
+			body:   `{"id":"msg_1","type":"message","usage":{"input_tokens":5,"output_tokens":7}}`,
+			want:   Usage{PromptTokens: 5, CompletionTokens: 7},
+			wantOK: true,
+		},
+		{
+			name:   "no usage",
+			body:   `{"error":"bad request"}`,
+			wantOK: false,
+		},
+		{
+			name:   "invalid json",
+			body:   `not json`,
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := ParseResponse([]byte(tt.body))
+			if ok != tt.wantOK {
+				t.Fatalf("expected ok=%v, got %v", tt.wantOK, ok)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStreamParser_OpenAI(t *testing.T) {
+	t.Parallel()
+
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n" +
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":10,\"completion_tokens\":2,\"total_tokens\":12}}\n\n" +
+		"data: [DONE]\n\n"
+
+	p := &StreamParser{}
+	// Feed in small chunks to exercise events split across writes
+	for i := 0; i < len(stream); i += 7 {
+		end := min(i+7, len(stream))
+		p.Write([]byte(stream[i:end]))
+	}
+
+	want := Usage{PromptTokens: 10, CompletionTokens: 2}
+	if p.Usage() != want {
+		t.Errorf("expected %+v, got %+v", want, p.Usage())
+	}
+}
+
+func TestStreamParser_Anthropic(t *testing.T) {
+	t.Parallel()
+
+	stream := strings.Join([]string{
+		"event: message_start",
+		`data: {"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":25,"output_tokens":1}}}`,
+		"",
+		"event: content_block_delta",
+		`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"usage"}}`,
+		"",
+		"event: message_delta",
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":15}}`,
+		"",
+	}, "\n")
+
+	p := &StreamParser{}
+	p.Write([]byte(stream))
+
+	want := Usage{PromptTokens: 25, CompletionTokens: 15}
+	if p.Usage() != want {
+		t.Errorf("expected %+v, got %+v", want, p.Usage())
+	}
+}
+
+func TestStreamParser_Responses(t *testing.T) {
+	t.Parallel()
+
+	stream := strings.Join([]string{
+		"event: response.output_text.delta",
+		`data: {"type":"response.output_text.delta","delta":"Hi"}`,
+		"",
+		"event: response.completed",
+		`data: {"type":"response.completed","response":{"id":"resp_1","usage":{"input_tokens":8,"output_tokens":3,"total_tokens":11}}}`,
+		"",
+	}, "\n")
+
+	p := &StreamParser{}
+	p.Write([]byte(stream))
+
+	want := Usage{PromptTokens: 8, CompletionTokens: 3}
+	if p.Usage() != want {
+		t.Errorf("expected %+v, got %+v", want, p.Usage())
+	}
+}
+
+func TestTracker_RecordAndSnapshot(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewTracker()
+	tracker.Record("backend", "gpt4", Usage{PromptTokens: 10, CompletionTokens: 5}, 0.5)
+	tracker.Record("backend", "gpt4", Usage{PromptTokens: 1, CompletionTokens: 2}, 0.25)
+	tracker.Record("backend", "claude", Usage{PromptTokens: 3, CompletionTokens: 4}, 0)
+	tracker.Record("frontend", "gpt4", Usage{PromptTokens: 100}, 0)
+
+	all := tracker.Snapshot("")
+	if len(all) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(all))
+	}
+
+	backend := tracker.Snapshot("backend")
+	if len(backend) != 2 {
+		t.Fatalf("expected 2 backend entries, got %d", len(backend))
+	}
+	// Sorted by alias: claude before gpt4
+	gpt4 := backend[1]
+	if gpt4.ModelAlias != "gpt4" {
+		t.Fatalf("expected gpt4 entry, got %q", gpt4.ModelAlias)
+	}
+	if gpt4.Requests != 2 || gpt4.PromptTokens != 11 || gpt4.CompletionTokens != 7 || gpt4.TotalTokens != 18 {
+		t.Errorf("unexpected totals: %+v", gpt4)
+	}
+	if gpt4.EstimatedCostUSD != 0.75 {
+		t.Errorf("expected estimated cost 0.75, got %v", gpt4.EstimatedCostUSD)
+	}
+}
+
+// batchBackend counts AddAll calls and fails them while fail is set.
+type batchBackend struct {
+	*storage.Memory
+	calls atomic.Int32
+	fail  atomic.Bool
+}
+
+func (b *batchBackend) AddAll(ctx context.Context, deltas map[string]float64, ttl time.Duration) error {
+	b.calls.Add(1)
+	if b.fail.Load() {
+		return errors.New("backend unavailable")
+	}
+	return b.Memory.AddAll(ctx, deltas, ttl)
+}
+
+func TestTracker_RecordIsOneWrite(t *testing.T) {
+	t.Parallel()
+
+	backend := &batchBackend{Memory: storage.NewMemory()}
+	tracker := NewTrackerWithBackend(backend)
+	tracker.Record("web", "gpt4", Usage{PromptTokens: 5, CompletionTokens: 2}, 0.1)
+	if got := backend.calls.Load(); got != 1 {
+		t.Fatalf("expected one backend write per request, got %d", got)
+	}
+
+	backend.fail.Store(true)
+	tracker.Record("web", "gpt4", Usage{PromptTokens: 5, CompletionTokens: 2}, 0.1)
+	totals := tracker.Snapshot("web")
+	if len(totals) != 1 || totals[0].Requests != 1 || totals[0].PromptTokens != 5 || totals[0].TotalTokens != 7 {
+		t.Errorf("expected a failed write to leave every counter unchanged, got %+v", totals)
+	}
+}
+
+func TestTracker_RecordFallback(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewTracker()
+	tracker.Record("web", "gpt4", Usage{PromptTokens: 5, CompletionTokens: 2}, 0)
+	tracker.RecordFallback("web", "gpt4")
+
+	totals := tracker.Snapshot("web")
+	if len(totals) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(totals))
+	}
+	if totals[0].Requests != 2 || totals[0].FallbackResponses != 1 || totals[0].TotalTokens != 7 {
+		t.Errorf("unexpected totals: %+v", totals[0])
+	}
+}
+
+func TestTracker_RecordTermination(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewTracker()
+	tracker.RecordTermination("web", "gpt4", TerminationClientCancelled)
+	tracker.RecordTermination("web", "gpt4", TerminationClientCancelled)
+	tracker.RecordTermination("web", "gpt4", TerminationTimeout)
+	tracker.RecordTermination("web", "gpt4", TerminationUpstreamError)
+
+	totals := tracker.Snapshot("web")
+	if len(totals) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(totals))
+	}
+	if got := totals[0]; got.Requests != 0 || got.ClientCancellations != 2 || got.Timeouts != 1 || got.UpstreamErrors != 1 {
+		t.Errorf("unexpected totals: %+v", got)
+	}
+}
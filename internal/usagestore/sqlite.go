@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package usagestore
+
+// Registers the pure-Go SQLite driver as "sqlite".
+import _ "modernc.org/sqlite"
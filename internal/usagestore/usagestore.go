@@ -0,0 +1,221 @@
+// Package usagestore persists per-request usage records to an embedded SQL
+// database so usage survives restarts and can be queried later.
+//
+// The store is written against database/sql. The SQLite driver is only linked
+// into binaries built with the "sqlite" build tag, keeping the default build
+// free of third-party dependencies.
+package usagestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DriverName is the database/sql driver the store opens.
+const DriverName = "sqlite"
+
+// bufferSize is how many records may be queued before new ones are dropped.
+const bufferSize = 4096
+
+// batchSize is the most records written in a single transaction.
+const batchSize = 256
+
+const schema = `CREATE TABLE IF NOT EXISTS requests (
+	id                INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp         TEXT    NOT NULL,
+	request_id        TEXT    NOT NULL,
+	application       TEXT    NOT NULL,
+	model_alias       TEXT    NOT NULL,
+	provider          TEXT    NOT NULL,
+	resolved_model    TEXT    NOT NULL,
+	endpoint          TEXT    NOT NULL,
+	status            INTEGER NOT NULL,
+	duration_ms       INTEGER NOT NULL,
+	prompt_tokens     INTEGER NOT NULL,
+	completion_tokens INTEGER NOT NULL,
+	total_tokens      INTEGER NOT NULL,
+	estimated_cost_usd REAL   NOT NULL,
+	tags              TEXT    NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS requests_app_time ON requests (application, timestamp);`
+
+// migrations bring databases created by earlier versions up to the schema.
+// A migration that fails because it was already applied is skipped.
+var migrations = []string{
+	`ALTER TABLE requests ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+}
+
+const insertSQL = `INSERT INTO requests (timestamp, request_id, application, model_alias, provider,
+	resolved_model, endpoint, status, duration_ms, prompt_tokens, completion_tokens, total_tokens,
+	estimated_cost_usd, tags) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// Record is one proxied request.
+type Record struct {
+	Timestamp        time.Time
+	RequestID        string
+	Application      string
+	ModelAlias       string
+	Provider         string
+	ResolvedModel    string
+	Endpoint         string
+	Status           int
+	Duration         time.Duration
+	PromptTokens     int
+	CompletionTokens int
+	EstimatedCostUSD float64
+	// Tags are the request's alias and key tags, stored as a JSON object.
+	Tags map[string]string
+}
+
+// Store writes records asynchronously in batches so the request path never
+// waits on disk. A nil Store discards records.
+type Store struct {
+	db     *sql.DB
+	logger *slog.Logger
+
+	queue   chan Record
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Int64
+}
+
+// Available reports whether the binary was built with the SQLite driver.
+func Available() bool {
+	return slices.Contains(sql.Drivers(), DriverName)
+}
+
+// Open opens (creating if needed) the database at path and starts the writer.
+func Open(path string, logger *slog.Logger) (*Store, error) {
+	if !Available() {
+		return nil, fmt.Errorf("this binary was built without SQLite support; rebuild with -tags sqlite")
+	}
+	db, err := sql.Open(DriverName, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage database: %w", err)
+	}
+	return newStore(db, logger)
+}
+
+func newStore(db *sql.DB, logger *slog.Logger) (*Store, error) {
+	// SQLite allows a single writer; one connection avoids lock contention
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize usage database: %w", err)
+	}
+	for _, migration := range migrations {
+		if _, err := db.Exec(migration); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate usage database: %w", err)
+		}
+	}
+	s := &Store{
+		db:     db,
+		logger: logger,
+		queue:  make(chan Record, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Record queues a record for writing. When the queue is full the record is
+// dropped and counted rather than blocking the request.
+func (s *Store) Record(r Record) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.queue <- r:
+	default:
+		dropped := s.dropped.Add(1)
+		s.logger.Warn("usage store queue full, dropping record", "request_id", r.RequestID, "dropped", dropped)
+	}
+}
+
+// Close flushes queued records and closes the database.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.once.Do(func() { close(s.queue) })
+	<-s.done
+	return s.db.Close()
+}
+
+func (s *Store) run() {
+	defer close(s.done)
+	batch := make([]Record, 0, batchSize)
+	for r := range s.queue {
+		batch = append(batch[:0], r)
+		// Drain whatever else is already queued into the same transaction
+	drain:
+		for len(batch) < batchSize {
+			select {
+			case next, ok := <-s.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		if err := s.write(batch); err != nil {
+			s.logger.Error("failed to write usage records", "error", err, "records", len(batch))
+		}
+	}
+}
+
+func (s *Store) write(batch []Record) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, insertSQL)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range batch {
+		var tags []byte
+		if len(r.Tags) > 0 {
+			// Maps of strings always marshal
+			tags, _ = json.Marshal(r.Tags)
+		}
+		_, err := stmt.ExecContext(ctx,
+			r.Timestamp.UTC().Format(time.RFC3339Nano),
+			r.RequestID,
+			r.Application,
+			r.ModelAlias,
+			r.Provider,
+			r.ResolvedModel,
+			r.Endpoint,
+			r.Status,
+			r.Duration.Milliseconds(),
+			r.PromptTokens,
+			r.CompletionTokens,
+			r.PromptTokens+r.CompletionTokens,
+			r.EstimatedCostUSD,
+			string(tags),
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
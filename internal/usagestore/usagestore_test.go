@@ -0,0 +1,125 @@
+package usagestore
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingDriver is a minimal database/sql driver that records executed
+// statements, so the store can be tested without linking SQLite.
+type recordingDriver struct {
+	mu    sync.Mutex
+	execs [][]driver.Value
+	ddl   []string
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) { return &recordingConn{d: d}, nil }
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{d: c.d, query: query}, nil
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return recordingTx{}, nil }
+
+type recordingTx struct{}
+
+func (recordingTx) Commit() error   { return nil }
+func (recordingTx) Rollback() error { return nil }
+
+type recordingStmt struct {
+	d     *recordingDriver
+	query string
+}
+
+func (s *recordingStmt) Close() error  { return nil }
+func (s *recordingStmt) NumInput() int { return -1 }
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if strings.HasPrefix(s.query, "INSERT") {
+		s.d.execs = append(s.d.execs, args)
+	} else {
+		s.d.ddl = append(s.d.ddl, s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s *recordingStmt) Query([]driver.Value) (driver.Rows, error) { return nil, io.EOF }
+
+var testDriver = &recordingDriver{}
+
+func init() {
+	sql.Register("usagestore-recording", testDriver)
+}
+
+func TestStore_RecordAndClose(t *testing.T) {
+	db, err := sql.Open("usagestore-recording", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := newStore(db, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("newStore() error: %v", err)
+	}
+
+	at := time.Date(2026, 3, 25, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		store.Record(Record{
+			Timestamp:        at,
+			RequestID:        "req",
+			Application:      "web",
+			ModelAlias:       "gpt4",
+			Provider:         "openai",
+			Endpoint:         "/v1/chat/completions",
+			Status:           200,
+			Duration:         1500 * time.Millisecond,
+			PromptTokens:     10,
+			CompletionTokens: 5,
+			Tags:             map[string]string{"team": "search"},
+		})
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	testDriver.mu.Lock()
+	defer testDriver.mu.Unlock()
+	if len(testDriver.ddl) == 0 || !strings.Contains(testDriver.ddl[0], "CREATE TABLE IF NOT EXISTS requests") {
+		t.Errorf("expected schema to be created, got %v", testDriver.ddl)
+	}
+	if len(testDriver.execs) != 3 {
+		t.Fatalf("expected 3 inserts flushed on close, got %d", len(testDriver.execs))
+	}
+	args := testDriver.execs[0]
+	if args[0] != "2026-03-25T12:00:00Z" || args[2] != "web" || args[8] != int64(1500) || args[11] != int64(15) || args[13] != `{"team":"search"}` {
+		t.Errorf("unexpected insert arguments: %v", args)
+	}
+}
+
+func TestStore_NilIsNoop(t *testing.T) {
+	t.Parallel()
+
+	var store *Store
+	store.Record(Record{RequestID: "req"})
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() on nil store returned %v", err)
+	}
+}
+
+func TestOpen_WithoutDriver(t *testing.T) {
+	t.Parallel()
+
+	if Available() {
+		t.Skip("binary built with SQLite support")
+	}
+	if _, err := Open("usage.db", slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("expected error when SQLite support is not compiled in")
+	}
+}
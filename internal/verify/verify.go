@@ -0,0 +1,253 @@
+// Package verify checks model responses against the test cases declared in
+// an alias's config, for "portus verify".
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+// Check returns the ways a response's text and latency fail a test case, or
+// nil when it passes.
+func Check(test models.AliasTest, content string, latency time.Duration) []string {
+	var failures []string
+	if test.Expect != "" && !strings.Contains(content, test.Expect) {
+		failures = append(failures, fmt.Sprintf("response does not contain %q", test.Expect))
+	}
+	if len(test.Schema) > 0 {
+		if err := checkJSON(test.Schema, content); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if limit := time.Duration(test.MaxLatency) * time.Millisecond; limit > 0 && latency > limit {
+		failures = append(failures, fmt.Sprintf("took %dms, over the %dms limit", latency.Milliseconds(), test.MaxLatency))
+	}
+	return failures
+}
+
+// Name returns a test case's display name: its name, or its position.
+func Name(test models.AliasTest, index int) string {
+	if test.Name != "" {
+		return test.Name
+	}
+	return fmt.Sprintf("test %d", index+1)
+}
+
+// checkJSON parses content as JSON, allowing a Markdown code fence around
+// it, and validates it against schema.
+func checkJSON(schema json.RawMessage, content string) error {
+	s, err := ParseSchema(schema)
+	if err != nil {
+		return err
+	}
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(strings.TrimSpace(content), "```")
+	}
+	var value any
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return fmt.Errorf("response is not valid JSON: %v", err)
+	}
+	if err := s.validate(value, "$"); err != nil {
+		return fmt.Errorf("response does not match schema: %v", err)
+	}
+	return nil
+}
+
+// Schema is the subset of JSON Schema supported by test cases and admin API
+// payloads: type, enum, properties, required, additionalProperties (false
+// only), items and minLength.
+type Schema struct {
+	Type                 schemaTypes        `json:"type,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	MinLength            int                `json:"minLength,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+// schemaTypes accepts "type" as a single name or a list of names.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = many
+	return nil
+}
+
+var knownTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// ParseSchema parses a test case's schema, rejecting unknown types.
+func ParseSchema(raw json.RawMessage) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	if err := s.check(); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	return &s, nil
+}
+
+func (s *Schema) check() error {
+	for _, t := range s.Type {
+		if !knownTypes[t] {
+			return fmt.Errorf("unknown type %q", t)
+		}
+	}
+	for _, p := range s.Properties {
+		if p == nil {
+			continue
+		}
+		if err := p.check(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check()
+	}
+	return nil
+}
+
+func (s *Schema) validate(value any, path string) error {
+	var first error
+	s.walk(value, path, func(path, message string) {
+		if first == nil {
+			first = fmt.Errorf("%s: %s", path, message)
+		}
+	})
+	return first
+}
+
+// FieldError is one place where a value does not match a schema.
+type FieldError struct {
+	// Field is a path such as "selector.aliases[0]", empty for the value
+	// itself.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors returns every place value fails the schema, sorted by field, or nil
+// when it matches.
+func (s *Schema) Errors(value any) []FieldError {
+	var errs []FieldError
+	s.walk(value, "$", func(path, message string) {
+		field := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+		errs = append(errs, FieldError{Field: field, Message: message})
+	})
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Field != errs[j].Field {
+			return errs[i].Field < errs[j].Field
+		}
+		return errs[i].Message < errs[j].Message
+	})
+	return errs
+}
+
+// walk reports each failure below path. A value of the wrong type or outside
+// the enum is not looked into further.
+func (s *Schema) walk(value any, path string, report func(path, message string)) {
+	if s == nil {
+		return
+	}
+	if len(s.Type) > 0 && !s.matchesType(value) {
+		report(path, "expected "+strings.Join(s.Type, " or "))
+		return
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if equal(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report(path, "value is not one of the allowed values")
+			return
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				report(path, fmt.Sprintf("missing required property %q", name))
+			}
+		}
+		for name, property := range v {
+			if p, ok := s.Properties[name]; ok {
+				p.walk(property, path+"."+name, report)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				report(path, fmt.Sprintf("unexpected property %q", name))
+			}
+		}
+	case []any:
+		for i, item := range v {
+			s.Items.walk(item, fmt.Sprintf("%s[%d]", path, i), report)
+		}
+	case string:
+		if s.MinLength > 0 && utf8.RuneCountInString(v) < s.MinLength {
+			report(path, fmt.Sprintf("shorter than the minimum length %d", s.MinLength))
+		}
+	}
+}
+
+func (s *Schema) matchesType(value any) bool {
+	for _, t := range s.Type {
+		switch v := value.(type) {
+		case map[string]any:
+			if t == "object" {
+				return true
+			}
+		case []any:
+			if t == "array" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && v == float64(int64(v))) {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case nil:
+			if t == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// equal compares decoded JSON values.
+func equal(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
@@ -0,0 +1,160 @@
+package verify
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/models"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	schema := json.RawMessage(`{
+		"type": "object",
+		"required": ["city", "population"],
+		"additionalProperties": false,
+		"properties": {
+			"city": {"type": "string", "enum": ["Paris", "Lyon"]},
+			"population": {"type": "integer"},
+			"districts": {"type": "array", "items": {"type": "string"}}
+		}
+	}`)
+
+	tests := []struct {
+		name    string
+		test    models.AliasTest
+		content string
+		latency time.Duration
+		want    []string
+	}{
+		{
+			name:    "substring present",
+			test:    models.AliasTest{Expect: "Paris"},
+			content: "The capital of France is Paris.",
+		},
+		{
+			name:    "substring missing",
+			test:    models.AliasTest{Expect: "Paris"},
+			content: "I don't know.",
+			want:    []string{`does not contain "Paris"`},
+		},
+		{
+			name:    "matching JSON in a code fence",
+			test:    models.AliasTest{Schema: schema},
+			content: "```json\n{\"city\": \"Paris\", \"population\": 2100000, \"districts\": [\"Marais\"]}\n```",
+		},
+		{
+			name:    "not JSON",
+			test:    models.AliasTest{Schema: schema},
+			content: "Paris",
+			want:    []string{"not valid JSON"},
+		},
+		{
+			name:    "missing required property",
+			test:    models.AliasTest{Schema: schema},
+			content: `{"city": "Paris"}`,
+			want:    []string{`missing required property "population"`},
+		},
+		{
+			name:    "wrong property type",
+			test:    models.AliasTest{Schema: schema},
+			content: `{"city": "Paris", "population": 2.5}`,
+			want:    []string{"$.population: expected integer"},
+		},
+		{
+			name:    "value outside enum",
+			test:    models.AliasTest{Schema: schema},
+			content: `{"city": "Nice", "population": 1}`,
+			want:    []string{"$.city: value is not one of the allowed values"},
+		},
+		{
+			name:    "unexpected property",
+			test:    models.AliasTest{Schema: schema},
+			content: `{"city": "Paris", "population": 1, "country": "FR"}`,
+			want:    []string{`unexpected property "country"`},
+		},
+		{
+			name:    "wrong item type",
+			test:    models.AliasTest{Schema: schema},
+			content: `{"city": "Paris", "population": 1, "districts": [4]}`,
+			want:    []string{"$.districts[0]: expected string"},
+		},
+		{
+			name:    "too slow",
+			test:    models.AliasTest{Expect: "Paris", MaxLatency: 1000},
+			content: "Paris",
+			latency: 1500 * time.Millisecond,
+			want:    []string{"took 1500ms, over the 1000ms limit"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := Check(tt.test, tt.content, tt.latency)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Check() = %q, want %d failures", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("failure %d = %q, want it to contain %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	t.Parallel()
+
+	if _, err := ParseSchema(json.RawMessage(`{"type": ["string", "null"]}`)); err != nil {
+		t.Errorf("expected type list to parse, got %v", err)
+	}
+	if _, err := ParseSchema(json.RawMessage(`{"properties": {"a": {"type": "text"}}}`)); err == nil {
+		t.Error("expected unknown nested type to be rejected")
+	}
+	if _, err := ParseSchema(json.RawMessage(`{"type": 3}`)); err == nil {
+		t.Error("expected non-string type to be rejected")
+	}
+}
+
+func TestSchema_Errors(t *testing.T) {
+	t.Parallel()
+
+	s, err := ParseSchema(json.RawMessage(`{
+		"type": "object",
+		"required": ["id"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "string", "minLength": 1},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var value any
+	json.Unmarshal([]byte(`{"id": "", "tags": ["a", 2], "extra": true}`), &value)
+	want := []FieldError{
+		{Field: "", Message: `unexpected property "extra"`},
+		{Field: "id", Message: "shorter than the minimum length 1"},
+		{Field: "tags[1]", Message: "expected string"},
+	}
+	if got := s.Errors(value); !reflect.DeepEqual(got, want) {
+		t.Errorf("Errors() = %+v, want %+v", got, want)
+	}
+
+	json.Unmarshal([]byte(`{"tags": []}`), &value)
+	if got := s.Errors(value); len(got) != 1 || got[0].Message != `missing required property "id"` {
+		t.Errorf("expected a missing property error, got %+v", got)
+	}
+	json.Unmarshal([]byte(`{"id": "k"}`), &value)
+	if got := s.Errors(value); got != nil {
+		t.Errorf("expected no errors, got %+v", got)
+	}
+}
@@ -0,0 +1,471 @@
+// Package webhook delivers events to HTTP endpoints. Every payload is signed
+// with HMAC-SHA256, failed deliveries are retried with exponential backoff,
+// and deliveries that still fail are logged as dead letters. Each destination
+// has its own queue and rate limit, so a slow or failing receiver never
+// delays the others.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/models"
+)
+
+// Headers sent with every delivery.
+const (
+	// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>" over
+	// "<t>.<body>", keyed with the destination's secret.
+	SignatureHeader = "X-Portus-Signature"
+	EventHeader     = "X-Portus-Event"
+	// DeliveryHeader identifies a delivery; retries reuse it so receivers
+	// can drop duplicates.
+	DeliveryHeader = "X-Portus-Delivery"
+)
+
+// queueSize is how many deliveries may wait for one destination before
+// further ones are dead-lettered.
+const queueSize = 256
+
+// idleTimeout is how long a destination's worker waits for deliveries
+// before it stops, so one-off URLs such as jobs' own webhooks don't keep a
+// worker each.
+const idleTimeout = 5 * time.Minute
+
+// maxBackoff caps the delay between attempts.
+const maxBackoff = 5 * time.Minute
+
+// Config configures a Dispatcher.
+type Config struct {
+	// Global receives events for every application.
+	Global models.WebhookTarget
+	// Applications receive events concerning one application, keyed by
+	// application name.
+	Applications map[string]models.WebhookTarget
+	// MaxAttempts is how many times a delivery is tried, at least once.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles each time.
+	Backoff time.Duration
+	// DeadLetterFile, when set, receives each failed delivery as a JSON line.
+	DeadLetterFile string
+}
+
+// ValidateURL reports whether raw is an http or https URL with a host.
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", raw)
+	}
+	return nil
+}
+
+// HostAllowed reports whether raw's host matches one of hosts, path.Match
+// globs such as "*.example.com" compared case-insensitively.
+func HostAllowed(raw string, hosts []string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range hosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether an event type matches the target's event patterns;
+// a target without patterns matches every event.
+func Matches(target models.WebhookTarget, eventType string) bool {
+	if len(target.Events) == 0 {
+		return true
+	}
+	for _, pattern := range target.Events {
+		if ok, _ := path.Match(pattern, eventType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// delivery is one event on its way to one destination.
+type delivery struct {
+	id        string
+	eventType string
+	url       string
+	secret    string
+	payload   []byte
+}
+
+// deadLetter is the record written for a delivery that failed.
+type deadLetter struct {
+	DeliveryID string          `json:"delivery_id"`
+	URL        string          `json:"url"`
+	Attempts   int             `json:"attempts"`
+	Error      string          `json:"error"`
+	FailedAt   time.Time       `json:"failed_at"`
+	Event      json.RawMessage `json:"event"`
+}
+
+// destination is a receiver's queue and rate limit.
+type destination struct {
+	url      string
+	queue    chan delivery
+	interval time.Duration
+	next     time.Time
+}
+
+// Dispatcher queues and delivers events. It is safe for concurrent use; a
+// nil Dispatcher discards events.
+type Dispatcher struct {
+	cfg    Config
+	logger *slog.Logger
+	client *http.Client
+
+	// ctx ends in-flight attempts and backoffs when Close runs out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu           sync.Mutex
+	destinations map[string]*destination
+	closed       bool
+	idleTimeout  time.Duration
+
+	deadMu sync.Mutex
+	dead   *os.File
+}
+
+// New creates a dispatcher, opening the dead-letter file if one is set.
+func New(cfg Config, logger *slog.Logger) (*Dispatcher, error) {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	d := &Dispatcher{
+		cfg:    cfg,
+		logger: logger,
+		// Redirects are not followed: a receiver's host is what was checked
+		// against the allowed hosts, and a 3xx counts as a failed attempt
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		destinations: make(map[string]*destination),
+		idleTimeout:  idleTimeout,
+	}
+	if cfg.DeadLetterFile != "" {
+		f, err := os.OpenFile(cfg.DeadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open webhook dead-letter file: %w", err)
+		}
+		d.dead = f
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	return d, nil
+}
+
+// Publish delivers an event to the global destinations and to those of the
+// application named by its "application" data, when their event patterns
+// match, and to any extra URLs given, such as a job's own webhook. Each URL
+// receives the event once. Extra URLs are signed and rate limited like the
+// application's destinations; without an application target they are rate
+// limited like the global ones but sent unsigned, since callers choose them.
+func (d *Dispatcher) Publish(ev events.Event, extra ...string) {
+	if d == nil {
+		return
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		d.logger.Error("failed to encode webhook event", "event", ev.Type, "error", err)
+		return
+	}
+
+	application, _ := ev.Data["application"].(string)
+	appTarget, hasApp := d.cfg.Applications[application]
+	seen := make(map[string]bool)
+	send := func(target models.WebhookTarget, urls []string) {
+		for _, u := range urls {
+			if seen[u] {
+				continue
+			}
+			seen[u] = true
+			d.enqueue(delivery{id: newID(), eventType: ev.Type, url: u, secret: target.Secret, payload: payload}, target.RateLimit)
+		}
+	}
+
+	if hasApp && Matches(appTarget, ev.Type) {
+		send(appTarget, appTarget.URLs)
+	}
+	if Matches(d.cfg.Global, ev.Type) {
+		send(d.cfg.Global, d.cfg.Global.URLs)
+	}
+	if hasApp {
+		send(appTarget, extra)
+	} else {
+		send(models.WebhookTarget{RateLimit: d.cfg.Global.RateLimit}, extra)
+	}
+}
+
+// Run publishes events from the hub until ctx ends.
+func (d *Dispatcher) Run(ctx context.Context, hub *events.Hub) {
+	if d == nil {
+		return
+	}
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			d.Publish(ev)
+		}
+	}
+}
+
+// Close stops accepting events and waits for queued deliveries until ctx
+// ends. Deliveries still pending then are dead-lettered.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, dest := range d.destinations {
+			close(dest.queue)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+	}
+	d.cancel()
+
+	d.deadMu.Lock()
+	defer d.deadMu.Unlock()
+	if d.dead == nil {
+		return nil
+	}
+	err := d.dead.Close()
+	d.dead = nil
+	return err
+}
+
+// enqueue queues a delivery for its destination, starting the destination's
+// worker on first use. rate is the destination's deliveries per minute
+// (0 is unlimited), fixed by the first delivery queued for it.
+func (d *Dispatcher) enqueue(del delivery, rate int) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		d.deadLetter(del, 0, "dispatcher is shutting down")
+		return
+	}
+	dest, ok := d.destinations[del.url]
+	if !ok {
+		dest = &destination{url: del.url, queue: make(chan delivery, queueSize)}
+		if rate > 0 {
+			dest.interval = time.Minute / time.Duration(rate)
+		}
+		d.destinations[del.url] = dest
+		d.wg.Add(1)
+		go d.work(dest)
+	}
+	select {
+	case dest.queue <- del:
+		d.mu.Unlock()
+	default:
+		d.mu.Unlock()
+		d.deadLetter(del, 0, "destination queue is full")
+	}
+}
+
+// work delivers a destination's queue in order, spacing deliveries to its
+// rate limit. It stops once the queue is closed, or has stayed empty for the
+// idle timeout and the rate limit allows another delivery; the next delivery
+// for the URL then starts a new worker.
+func (d *Dispatcher) work(dest *destination) {
+	defer d.wg.Done()
+	idle := time.NewTimer(d.idleTimeout)
+	defer idle.Stop()
+	for {
+		var del delivery
+		select {
+		case next, ok := <-dest.queue:
+			if !ok {
+				return
+			}
+			del = next
+		case <-idle.C:
+			// enqueue sends under d.mu, so nothing can arrive once removed
+			d.mu.Lock()
+			if !d.closed && len(dest.queue) == 0 && !time.Now().Before(dest.next) {
+				delete(d.destinations, dest.url)
+				d.mu.Unlock()
+				return
+			}
+			d.mu.Unlock()
+			idle.Reset(d.idleTimeout)
+			continue
+		}
+		if dest.interval > 0 {
+			now := time.Now()
+			if wait := dest.next.Sub(now); wait > 0 {
+				if !sleep(d.ctx, wait) {
+					d.deadLetter(del, 0, "dispatcher shut down before delivery")
+					continue
+				}
+				now = dest.next
+			}
+			dest.next = now.Add(dest.interval)
+		}
+		d.deliver(del)
+		idle.Reset(d.idleTimeout)
+	}
+}
+
+// deliver makes up to MaxAttempts attempts, backing off between them.
+// Client errors other than 408 and 429 are not retried.
+func (d *Dispatcher) deliver(del delivery) {
+	backoff := d.cfg.Backoff
+	var lastErr string
+	for attempt := 1; ; attempt++ {
+		status, retryAfter, err := d.attempt(del)
+		if err == nil {
+			d.logger.Debug("webhook delivered", "delivery_id", del.id, "event", del.eventType, "url", del.url, "attempts", attempt)
+			return
+		}
+		lastErr = err.Error()
+		retryable := status == 0 || status >= http.StatusInternalServerError || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+		if !retryable || attempt >= d.cfg.MaxAttempts || d.ctx.Err() != nil {
+			d.deadLetter(del, attempt, lastErr)
+			return
+		}
+		d.logger.Warn("webhook delivery failed, retrying", "delivery_id", del.id, "event", del.eventType, "url", del.url, "attempt", attempt, "error", lastErr)
+
+		wait := max(backoff, retryAfter)
+		if !sleep(d.ctx, min(wait, maxBackoff)) {
+			d.deadLetter(del, attempt, lastErr)
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// attempt posts the delivery once, returning the response status (0 when
+// there was none) and any Retry-After delay it asked for.
+func (d *Dispatcher) attempt(del delivery) (int, time.Duration, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, del.url, bytes.NewReader(del.payload))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, del.eventType)
+	req.Header.Set(DeliveryHeader, del.id)
+	if del.secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(del.secret), time.Now(), del.payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return resp.StatusCode, 0, nil
+	}
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return resp.StatusCode, retryAfter, fmt.Errorf("receiver returned %d", resp.StatusCode)
+}
+
+// deadLetter logs a delivery that will not be retried and appends it to the
+// dead-letter file.
+func (d *Dispatcher) deadLetter(del delivery, attempts int, reason string) {
+	d.logger.Error("webhook delivery failed, dead-lettered",
+		"delivery_id", del.id,
+		"event", del.eventType,
+		"url", del.url,
+		"attempts", attempts,
+		"error", reason,
+	)
+	line, _ := json.Marshal(deadLetter{
+		DeliveryID: del.id,
+		URL:        del.url,
+		Attempts:   attempts,
+		Error:      reason,
+		FailedAt:   time.Now().UTC(),
+		Event:      del.payload,
+	})
+	d.deadMu.Lock()
+	defer d.deadMu.Unlock()
+	if d.dead == nil {
+		return
+	}
+	if _, err := d.dead.Write(append(line, '\n')); err != nil {
+		d.logger.Error("failed to write webhook dead letter", "delivery_id", del.id, "error", err)
+	}
+}
+
+// Sign returns the signature header value for a payload sent at t.
+func Sign(secret []byte, t time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sleep waits for d, returning false if ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "whd_" + hex.EncodeToString(b)
+}
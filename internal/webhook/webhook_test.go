@@ -0,0 +1,356 @@
+package webhook
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/models"
+)
+
+// received is one request seen by a receiver.
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver records requests and answers them with the next status from
+// statuses, then 200.
+type receiver struct {
+	mu       sync.Mutex
+	requests []received
+	statuses []int
+	server   *httptest.Server
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+	rec := &receiver{statuses: statuses}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, received{header: r.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(rec.statuses) > 0 {
+			status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+		}
+		rec.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rec.server.Close)
+	return rec
+}
+
+func (rec *receiver) received() []received {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]received(nil), rec.requests...)
+}
+
+func newTestDispatcher(t *testing.T, cfg Config) *Dispatcher {
+	t.Helper()
+	d, err := New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// closeDispatcher waits for every queued delivery to finish.
+func closeDispatcher(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDispatcher_Routing(t *testing.T) {
+	t.Parallel()
+
+	global, app, other, extra, adhoc := newReceiver(t), newReceiver(t), newReceiver(t), newReceiver(t), newReceiver(t)
+	d := newTestDispatcher(t, Config{
+		Global: models.WebhookTarget{URLs: []string{global.server.URL}, Secret: "global-secret", Events: []string{"budget.*"}},
+		Applications: map[string]models.WebhookTarget{
+			"tool":  {URLs: []string{app.server.URL}, Secret: "tool-secret"},
+			"other": {URLs: []string{other.server.URL}},
+		},
+		MaxAttempts: 1,
+	})
+
+	d.Publish(events.Event{Type: events.BudgetWarning, Data: map[string]any{"application": "tool"}})
+	d.Publish(events.Event{Type: events.GuardrailBlocked, Data: map[string]any{"application": "tool"}})
+	// The application's own URL given again is delivered once
+	d.Publish(events.Event{Type: events.JobSucceeded, Data: map[string]any{"application": "tool"}}, extra.server.URL, app.server.URL)
+	d.Publish(events.Event{Type: events.JobFailed, Data: map[string]any{"application": "unconfigured"}}, adhoc.server.URL)
+	closeDispatcher(t, d)
+
+	tests := []struct {
+		name   string
+		rec    *receiver
+		events []string
+		secret string
+	}{
+		{name: "global only gets matching events", rec: global, events: []string{events.BudgetWarning}, secret: "global-secret"},
+		{name: "application gets all its events", rec: app, events: []string{events.BudgetWarning, events.GuardrailBlocked, events.JobSucceeded}, secret: "tool-secret"},
+		{name: "other application gets none", rec: other},
+		{name: "extra URL uses the application's secret", rec: extra, events: []string{events.JobSucceeded}, secret: "tool-secret"},
+		{name: "extra URL without an application target is unsigned", rec: adhoc, events: []string{events.JobFailed}},
+	}
+	for _, tt := range tests {
+		got := tt.rec.received()
+		if len(got) != len(tt.events) {
+			t.Errorf("%s: expected %d deliveries, got %d", tt.name, len(tt.events), len(got))
+			continue
+		}
+		for i, req := range got {
+			if req.header.Get(EventHeader) != tt.events[i] || !strings.HasPrefix(req.header.Get(DeliveryHeader), "whd_") {
+				t.Errorf("%s: unexpected headers %v", tt.name, req.header)
+			}
+			if tt.secret == "" {
+				if req.header.Get(SignatureHeader) != "" {
+					t.Errorf("%s: expected no signature, got %q", tt.name, req.header.Get(SignatureHeader))
+				}
+				continue
+			}
+			if !validSignature(req.header.Get(SignatureHeader), tt.secret, req.body) {
+				t.Errorf("%s: signature %q does not verify", tt.name, req.header.Get(SignatureHeader))
+			}
+		}
+	}
+}
+
+// validSignature verifies a signature header the way a receiver would.
+func validSignature(header, secret string, body []byte) bool {
+	timestamp, signature, ok := strings.Cut(strings.TrimPrefix(header, "t="), ",v1=")
+	if !ok {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	return hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil))))
+}
+
+func TestDispatcher_Retries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxAttempts  int
+		wantAttempts int
+		wantDead     bool
+	}{
+		{name: "succeeds after retries", statuses: []int{503, 429}, maxAttempts: 5, wantAttempts: 3},
+		{name: "attempts exhausted", statuses: []int{500, 500, 500}, maxAttempts: 2, wantAttempts: 2, wantDead: true},
+		{name: "client error not retried", statuses: []int{400}, maxAttempts: 5, wantAttempts: 1, wantDead: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			rec := newReceiver(t, tt.statuses...)
+			deadFile := filepath.Join(t.TempDir(), "dead.jsonl")
+			d := newTestDispatcher(t, Config{
+				Global:         models.WebhookTarget{URLs: []string{rec.server.URL}},
+				MaxAttempts:    tt.maxAttempts,
+				Backoff:        time.Millisecond,
+				DeadLetterFile: deadFile,
+			})
+			d.Publish(events.Event{Type: events.BudgetExceeded})
+			closeDispatcher(t, d)
+
+			got := rec.received()
+			if len(got) != tt.wantAttempts {
+				t.Fatalf("expected %d attempts, got %d", tt.wantAttempts, len(got))
+			}
+			if id := got[0].header.Get(DeliveryHeader); got[len(got)-1].header.Get(DeliveryHeader) != id {
+				t.Error("expected retries to reuse the delivery ID")
+			}
+
+			data, _ := os.ReadFile(deadFile)
+			var letters []deadLetter
+			scanner := bufio.NewScanner(strings.NewReader(string(data)))
+			for scanner.Scan() {
+				var letter deadLetter
+				json.Unmarshal(scanner.Bytes(), &letter)
+				letters = append(letters, letter)
+			}
+			if tt.wantDead != (len(letters) == 1) {
+				t.Fatalf("expected dead letter %v, got %s", tt.wantDead, data)
+			}
+			if tt.wantDead && (letters[0].Attempts != tt.wantAttempts || letters[0].URL != rec.server.URL || !strings.Contains(string(letters[0].Event), events.BudgetExceeded)) {
+				t.Errorf("unexpected dead letter: %+v", letters[0])
+			}
+		})
+	}
+}
+
+func TestDispatcher_DoesNotFollowRedirects(t *testing.T) {
+	t.Parallel()
+
+	internal := newReceiver(t)
+	var attempts int
+	var mu sync.Mutex
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		http.Redirect(w, r, internal.server.URL, http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	// The redirecting receiver's host passes the job's allowlist
+	if !HostAllowed(redirector.URL, []string{"127.0.0.1"}) {
+		t.Fatalf("expected %s to be allowed", redirector.URL)
+	}
+	deadLetters := filepath.Join(t.TempDir(), "dead.jsonl")
+	d := newTestDispatcher(t, Config{
+		Applications:   map[string]models.WebhookTarget{"tool": {Secret: "tool-secret"}},
+		MaxAttempts:    1,
+		DeadLetterFile: deadLetters,
+	})
+	d.Publish(events.Event{Type: events.JobSucceeded, Data: map[string]any{"application": "tool"}}, redirector.URL)
+	closeDispatcher(t, d)
+
+	if got := internal.received(); len(got) != 0 {
+		t.Errorf("expected the redirect target to receive nothing, got %d deliveries", len(got))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 1 {
+		t.Errorf("expected one attempt at the redirecting receiver, got %d", attempts)
+	}
+	data, err := os.ReadFile(deadLetters)
+	if err != nil || !strings.Contains(string(data), "302") {
+		t.Errorf("expected the redirect to be dead-lettered as a failure, got %q, %v", data, err)
+	}
+}
+
+func TestDispatcher_RateLimit(t *testing.T) {
+	t.Parallel()
+
+	rec := newReceiver(t)
+	// 6000 a minute spaces deliveries 10ms apart
+	d := newTestDispatcher(t, Config{Global: models.WebhookTarget{URLs: []string{rec.server.URL}, RateLimit: 6000}, MaxAttempts: 1})
+	began := time.Now()
+	for range 4 {
+		d.Publish(events.Event{Type: events.BudgetWarning})
+	}
+	closeDispatcher(t, d)
+	if elapsed := time.Since(began); elapsed < 30*time.Millisecond {
+		t.Errorf("expected deliveries to be spaced out, took %v", elapsed)
+	}
+	if n := len(rec.received()); n != 4 {
+		t.Errorf("expected 4 deliveries, got %d", n)
+	}
+}
+
+func TestDispatcher_ReapsIdleDestinations(t *testing.T) {
+	t.Parallel()
+
+	rec := newReceiver(t)
+	d := newTestDispatcher(t, Config{MaxAttempts: 1})
+	d.idleTimeout = 10 * time.Millisecond
+	destinations := func() int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.destinations)
+	}
+
+	d.Publish(events.Event{Type: events.JobSucceeded}, rec.server.URL)
+	deadline := time.Now().Add(5 * time.Second)
+	for destinations() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the idle destination to be removed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A later delivery to the same URL starts a new worker
+	d.Publish(events.Event{Type: events.JobFailed}, rec.server.URL)
+	closeDispatcher(t, d)
+	if n := len(rec.received()); n != 2 {
+		t.Errorf("expected 2 deliveries, got %d", n)
+	}
+}
+
+func TestDispatcher_CloseDeadLettersPending(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	deadFile := filepath.Join(t.TempDir(), "dead.jsonl")
+	d := newTestDispatcher(t, Config{Global: models.WebhookTarget{URLs: []string{server.URL}}, MaxAttempts: 3, Backoff: time.Millisecond, DeadLetterFile: deadFile})
+	d.Publish(events.Event{Type: events.BudgetWarning})
+	d.Publish(events.Event{Type: events.BudgetExceeded})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(events.Event{Type: events.BudgetWarning})
+
+	data, _ := os.ReadFile(deadFile)
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("expected both pending deliveries to be dead-lettered, got %d: %s", n, data)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	t.Parallel()
+
+	for raw, valid := range map[string]bool{
+		"https://hooks.example.com/portus": true,
+		"http://localhost:9000":            true,
+		"ftp://example.com":                false,
+		"https://":                         false,
+		"not a url":                        false,
+	} {
+		if err := ValidateURL(raw); (err == nil) != valid {
+			t.Errorf("ValidateURL(%q) = %v, want valid %v", raw, err, valid)
+		}
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	t.Parallel()
+
+	hosts := []string{"hooks.example.com", "*.internal.example.com"}
+	for raw, allowed := range map[string]bool{
+		"https://hooks.example.com/portus":        true,
+		"https://HOOKS.example.com:8443/portus":   true,
+		"https://jobs.internal.example.com/done":  true,
+		"https://internal.example.com/done":       false,
+		"http://169.254.169.254/latest/meta-data": false,
+		"http://localhost:9000":                   false,
+	} {
+		if got := HostAllowed(raw, hosts); got != allowed {
+			t.Errorf("HostAllowed(%q) = %v, want %v", raw, got, allowed)
+		}
+	}
+	if HostAllowed("https://hooks.example.com", nil) {
+		t.Error("expected no host to be allowed without an allowlist")
+	}
+}
@@ -0,0 +1,401 @@
+// Package client is a typed Go client for the Portus admin and usage APIs:
+// model aliases, proxy keys, config bundles, canaries, usage statistics and
+// the admin event stream.
+//
+//	c := client.New("http://portus:8080", os.Getenv("PORTUS_ADMIN_KEY"))
+//	aliases, err := c.Models(ctx)
+//
+// Admin methods need an admin key. Stats and Whoami report on the calling
+// key's own application, so use an inference or observability key for them;
+// Limits and the job methods need an inference key.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/amscotti/portus/internal/admin"
+	"github.com/amscotti/portus/internal/canary"
+	"github.com/amscotti/portus/internal/config"
+	"github.com/amscotti/portus/internal/controlplane"
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/freeze"
+	"github.com/amscotti/portus/internal/jobs"
+	"github.com/amscotti/portus/internal/loglevel"
+	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/verify"
+)
+
+// Wire types shared with the server, so requests and responses always match.
+type (
+	ModelSummary  = admin.ModelSummary
+	KeySummary    = admin.KeySummary
+	ModelSelector = config.ModelSelector
+	PatchResult   = admin.PatchModelsResponse
+	ConfigStatus  = controlplane.Status
+	Bundle        = controlplane.Bundle
+	BundleKey     = controlplane.Key
+	CanaryStats   = canary.Stats
+	Stats         = models.StatsResponse
+	Whoami        = models.WhoamiResponse
+	Limits        = models.LimitsResponse
+	Event         = events.Event
+	LogLevel      = loglevel.Status
+	FreezeStatus  = freeze.Status
+	Job           = jobs.Job
+	FieldError    = verify.FieldError
+)
+
+// Event types delivered by Subscribe.
+const (
+	EventModelsPatched    = events.ModelsPatched
+	EventConfigApplied    = events.ConfigApplied
+	EventConfigRolledBack = events.ConfigRolledBack
+	EventKeyDisabled      = events.KeyDisabled
+	EventKeyEnabled       = events.KeyEnabled
+	EventDisabledKeyUsed  = events.DisabledKeyUsed
+	EventConfigFrozen     = events.ConfigFrozen
+	EventConfigUnfrozen   = events.ConfigUnfrozen
+	EventBudgetWarning    = events.BudgetWarning
+	EventBudgetExceeded   = events.BudgetExceeded
+)
+
+// Error is a non-2xx response from Portus.
+type Error struct {
+	StatusCode int
+	Message    string
+	// SupportedSchemaVersions is set when a pushed bundle's schema version
+	// was rejected.
+	SupportedSchemaVersions []int
+	// Fields lists what was wrong with a rejected request body.
+	Fields []FieldError
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("portus: %d %s", e.StatusCode, e.Message)
+}
+
+// Client calls one Portus instance. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	key        string
+	httpClient *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests, e.g. to configure
+// TLS. It must not have a timeout if Subscribe is used; bound requests with
+// their context instead.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// New creates a client for the Portus instance at baseURL that authenticates
+// with key.
+func New(baseURL, key string, opts ...Option) *Client {
+	c := &Client{baseURL: strings.TrimRight(baseURL, "/"), key: key, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Models lists model aliases. Credentials are never included.
+func (c *Client) Models(ctx context.Context) ([]ModelSummary, error) {
+	var resp struct {
+		Models []ModelSummary `json:"models"`
+	}
+	err := c.do(ctx, http.MethodGet, "/admin/models", nil, &resp)
+	return resp.Models, err
+}
+
+// PatchModels applies a JSON merge patch to every alias matching selector.
+// With dryRun, it only reports which aliases would change.
+func (c *Client) PatchModels(ctx context.Context, selector ModelSelector, patch map[string]any, dryRun bool) (PatchResult, error) {
+	var resp PatchResult
+	err := c.do(ctx, http.MethodPatch, "/admin/models", admin.PatchModelsRequest{Selector: selector, Patch: patch, DryRun: dryRun}, &resp)
+	return resp, err
+}
+
+// Keys lists the accepted proxy keys. Key values are never included.
+func (c *Client) Keys(ctx context.Context) ([]KeySummary, error) {
+	var resp struct {
+		Keys []KeySummary `json:"keys"`
+	}
+	err := c.do(ctx, http.MethodGet, "/admin/keys", nil, &resp)
+	return resp.Keys, err
+}
+
+// DisableKey rejects the proxy key with the given ID until it is re-enabled
+// or the server restarts.
+func (c *Client) DisableKey(ctx context.Context, id string) (KeySummary, error) {
+	return c.patchKey(ctx, id, true)
+}
+
+// EnableKey accepts the proxy key with the given ID again.
+func (c *Client) EnableKey(ctx context.Context, id string) (KeySummary, error) {
+	return c.patchKey(ctx, id, false)
+}
+
+func (c *Client) patchKey(ctx context.Context, id string, disabled bool) (KeySummary, error) {
+	var resp KeySummary
+	err := c.do(ctx, http.MethodPatch, "/admin/keys", admin.PatchKeyRequest{ID: id, Disabled: disabled}, &resp)
+	return resp, err
+}
+
+// ConfigStatus reports the active config version and rollback history.
+func (c *Client) ConfigStatus(ctx context.Context) (ConfigStatus, error) {
+	var resp ConfigStatus
+	err := c.do(ctx, http.MethodGet, "/admin/config", nil, &resp)
+	return resp, err
+}
+
+// PushConfig replaces the instance's aliases, and its keys when the bundle
+// has any, with bundle. With dryRun, the bundle is only validated.
+func (c *Client) PushConfig(ctx context.Context, bundle Bundle, dryRun bool) (ConfigStatus, error) {
+	path := "/admin/config"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+	var resp ConfigStatus
+	err := c.do(ctx, http.MethodPost, path, bundle, &resp)
+	return resp, err
+}
+
+// Rollback reactivates the previously active config.
+func (c *Client) Rollback(ctx context.Context) (ConfigStatus, error) {
+	var resp ConfigStatus
+	err := c.do(ctx, http.MethodPost, "/admin/config/rollback", nil, &resp)
+	return resp, err
+}
+
+// Canaries reports synthetic canary results per alias.
+func (c *Client) Canaries(ctx context.Context) ([]CanaryStats, error) {
+	var resp struct {
+		Canaries []CanaryStats `json:"canaries"`
+	}
+	err := c.do(ctx, http.MethodGet, "/admin/canaries", nil, &resp)
+	return resp.Canaries, err
+}
+
+// LogLevel reports the server's current log level and when it reverts.
+func (c *Client) LogLevel(ctx context.Context) (LogLevel, error) {
+	var resp LogLevel
+	err := c.do(ctx, http.MethodGet, "/admin/log-level", nil, &resp)
+	return resp, err
+}
+
+// SetLogLevel changes the server's log level (e.g. "debug") for duration,
+// after which it reverts. A zero duration uses the server's default.
+func (c *Client) SetLogLevel(ctx context.Context, level string, duration time.Duration) (LogLevel, error) {
+	req := admin.LogLevelRequest{Level: level}
+	if duration > 0 {
+		req.Duration = duration.String()
+	}
+	var resp LogLevel
+	err := c.do(ctx, http.MethodPut, "/admin/log-level", req, &resp)
+	return resp, err
+}
+
+// ResetLogLevel restores the server's configured log level.
+func (c *Client) ResetLogLevel(ctx context.Context) (LogLevel, error) {
+	var resp LogLevel
+	err := c.do(ctx, http.MethodDelete, "/admin/log-level", nil, &resp)
+	return resp, err
+}
+
+// ReadOnly reports whether the server's configuration is frozen.
+func (c *Client) ReadOnly(ctx context.Context) (FreezeStatus, error) {
+	var resp FreezeStatus
+	err := c.do(ctx, http.MethodGet, "/admin/freeze", nil, &resp)
+	return resp, err
+}
+
+// Freeze switches on read-only mode, refusing configuration changes until
+// Unfreeze. The reason is recorded with the freeze.
+func (c *Client) Freeze(ctx context.Context, reason string) (FreezeStatus, error) {
+	var resp FreezeStatus
+	err := c.do(ctx, http.MethodPut, "/admin/freeze", admin.FreezeRequest{Reason: reason}, &resp)
+	return resp, err
+}
+
+// Unfreeze lifts read-only mode. A freeze set by PORTUS_READ_ONLY returns a
+// 409 error.
+func (c *Client) Unfreeze(ctx context.Context) (FreezeStatus, error) {
+	var resp FreezeStatus
+	err := c.do(ctx, http.MethodDelete, "/admin/freeze", nil, &resp)
+	return resp, err
+}
+
+// StatsOptions requests a usage time series alongside the totals.
+type StatsOptions struct {
+	// Resolution is "minute", "hour" or "day"; empty omits the history.
+	Resolution string
+	// Since limits the history to buckets at or after this time.
+	Since time.Time
+}
+
+// Stats returns usage statistics for the calling key's application.
+func (c *Client) Stats(ctx context.Context, opts StatsOptions) (Stats, error) {
+	query := url.Values{}
+	if opts.Resolution != "" {
+		query.Set("resolution", opts.Resolution)
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339))
+	}
+	path := "/stats"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var resp Stats
+	err := c.do(ctx, http.MethodGet, path, nil, &resp)
+	return resp, err
+}
+
+// Whoami reports how Portus resolved the client's key.
+func (c *Client) Whoami(ctx context.Context) (Whoami, error) {
+	var resp Whoami
+	err := c.do(ctx, http.MethodGet, "/debug/whoami", nil, &resp)
+	return resp, err
+}
+
+// Limits returns the calling key's limits and remaining headroom. It needs an
+// inference key.
+func (c *Client) Limits(ctx context.Context) (Limits, error) {
+	var resp Limits
+	err := c.do(ctx, http.MethodGet, "/v1/limits", nil, &resp)
+	return resp, err
+}
+
+// Job returns an asynchronous job submitted by the calling key's application,
+// with its response once it has finished. It needs an inference key.
+func (c *Client) Job(ctx context.Context, id string) (Job, error) {
+	var resp Job
+	err := c.do(ctx, http.MethodGet, "/v1/jobs/"+url.PathEscape(id), nil, &resp)
+	return resp, err
+}
+
+// Jobs lists the calling key's application's asynchronous jobs, newest
+// first and without their responses. It needs an inference key.
+func (c *Client) Jobs(ctx context.Context) ([]Job, error) {
+	var resp struct {
+		Jobs []Job `json:"jobs"`
+	}
+	err := c.do(ctx, http.MethodGet, "/v1/jobs", nil, &resp)
+	return resp.Jobs, err
+}
+
+// CancelJob cancels a running asynchronous job, or deletes a finished one.
+func (c *Client) CancelJob(ctx context.Context, id string) (Job, error) {
+	var resp Job
+	err := c.do(ctx, http.MethodDelete, "/v1/jobs/"+url.PathEscape(id), nil, &resp)
+	return resp, err
+}
+
+// Subscribe streams admin events to fn until ctx ends, the stream is closed
+// or fn returns an error. It returns ctx's error when ctx ends and fn's error
+// when fn stops the subscription. Events are delivered in order; a gap in
+// Event.ID means events were dropped because fn was too slow.
+func (c *Client) Subscribe(ctx context.Context, fn func(Event) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/admin/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("portus: invalid event: %w", err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.key)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("portus: invalid response: %w", err)
+	}
+	return nil
+}
+
+// responseError builds an Error from a failed response's JSON error body.
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error                   string       `json:"error"`
+		SupportedSchemaVersions []int        `json:"supported_schema_versions"`
+		Fields                  []FieldError `json:"fields"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body)
+	if body.Error == "" {
+		body.Error = http.StatusText(resp.StatusCode)
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: body.Error, SupportedSchemaVersions: body.SupportedSchemaVersions, Fields: body.Fields}
+}
@@ -0,0 +1,267 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amscotti/portus/internal/admin"
+	"github.com/amscotti/portus/internal/controlplane"
+	"github.com/amscotti/portus/internal/events"
+	"github.com/amscotti/portus/internal/freeze"
+	"github.com/amscotti/portus/internal/handlers"
+	"github.com/amscotti/portus/internal/jobs"
+	"github.com/amscotti/portus/internal/loglevel"
+	"github.com/amscotti/portus/internal/middleware"
+	"github.com/amscotti/portus/internal/models"
+	"github.com/amscotti/portus/internal/storage"
+	"github.com/amscotti/portus/internal/usage"
+)
+
+// newServer serves the real admin and stats handlers behind key auth.
+func newServer(t *testing.T) (*httptest.Server, *events.Hub) {
+	t.Helper()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := &models.ConfigStore{
+		Models: map[string]models.ModelConfig{
+			"gpt4": {Provider: "openai", APIKey: "sk-secret"},
+		},
+		Budgets: map[string]models.BudgetConfig{"BACKEND": {MonthlyUSD: 100}},
+	}
+	keyring := middleware.NewKeyring([]models.ProxyKey{
+		{Key: "admin-key", Application: "OPS", Scope: models.ScopeAdmin},
+		{Key: "pk-backend", Application: "BACKEND", MaxTokens: 2000},
+	})
+	plane := controlplane.New(store, keyring)
+	hub := events.NewHub()
+	svc := &handlers.Services{Usage: usage.NewTracker()}
+	svc.Usage.Record("BACKEND", "gpt4", usage.Usage{PromptTokens: 10, CompletionTokens: 5}, 0.01)
+
+	auth := middleware.AuthMiddleware(keyring, hub, logger)
+	mux := http.NewServeMux()
+	mux.Handle("/admin/models", auth(admin.ModelsHandler(store, hub, logger)))
+	mux.Handle("/admin/keys", auth(admin.KeysHandler(keyring, hub, logger)))
+	mux.Handle("/admin/events", auth(admin.EventsHandler(hub)))
+	mux.Handle("/admin/config", auth(admin.ConfigHandler(plane, hub, logger)))
+	mux.Handle("/admin/config/rollback", auth(admin.ConfigRollbackHandler(plane, hub, logger)))
+	mux.Handle("/admin/log-level", auth(admin.LogLevelHandler(loglevel.New(slog.LevelInfo), time.Minute)))
+	mux.Handle("/admin/freeze", auth(admin.FreezeHandler(freeze.New(false), hub, logger)))
+	mux.Handle("/stats", auth(handlers.StatsHandler(svc)))
+	mux.Handle("/v1/limits", auth(handlers.LimitsHandler(store, svc)))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, hub
+}
+
+func TestClient_AdminAPI(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newServer(t)
+	c := New(server.URL+"/", "admin-key")
+	ctx := context.Background()
+
+	aliases, err := c.Models(ctx)
+	if err != nil || len(aliases) != 1 || aliases[0].Alias != "gpt4" || aliases[0].Provider != "openai" {
+		t.Fatalf("unexpected models: %+v, %v", aliases, err)
+	}
+
+	keys, err := c.Keys(ctx)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("unexpected keys: %+v, %v", keys, err)
+	}
+
+	var backendID string
+	for _, key := range keys {
+		if key.Application == "BACKEND" {
+			backendID = key.ID
+		}
+	}
+	if key, err := c.DisableKey(ctx, backendID); err != nil || !key.Disabled {
+		t.Fatalf("unexpected disable result: %+v, %v", key, err)
+	}
+	if _, err := New(server.URL, "pk-backend").Stats(ctx, StatsOptions{}); err == nil {
+		t.Error("expected the disabled key to be rejected")
+	}
+	if key, err := c.EnableKey(ctx, backendID); err != nil || key.Disabled {
+		t.Fatalf("unexpected enable result: %+v, %v", key, err)
+	}
+
+	status, err := c.PushConfig(ctx, Bundle{
+		SchemaVersion: 1,
+		Version:       "v2",
+		Models:        map[string]json.RawMessage{"claude": json.RawMessage(`{"provider":"anthropic","api_key":"sk-ant"}`)},
+	}, false)
+	if err != nil || status.Version != "v2" || status.Models != 1 {
+		t.Fatalf("unexpected push result: %+v, %v", status, err)
+	}
+
+	status, err = c.Rollback(ctx)
+	if err != nil || status.Version != controlplane.LocalVersion {
+		t.Fatalf("unexpected rollback result: %+v, %v", status, err)
+	}
+
+	level, err := c.SetLogLevel(ctx, "debug", 5*time.Minute)
+	if err != nil || level.Level != "debug" || level.RevertsAt == nil {
+		t.Fatalf("unexpected log level: %+v, %v", level, err)
+	}
+	if level, err = c.ResetLogLevel(ctx); err != nil || level.Level != "info" {
+		t.Fatalf("unexpected log level after reset: %+v, %v", level, err)
+	}
+
+	frozen, err := c.Freeze(ctx, "incident 42")
+	if err != nil || !frozen.Frozen || frozen.Reason != "incident 42" || frozen.Source != "admin:OPS" {
+		t.Fatalf("unexpected freeze result: %+v, %v", frozen, err)
+	}
+	if frozen, err = c.ReadOnly(ctx); err != nil || !frozen.Frozen {
+		t.Fatalf("unexpected read-only status: %+v, %v", frozen, err)
+	}
+	if frozen, err = c.Unfreeze(ctx); err != nil || frozen.Frozen {
+		t.Fatalf("unexpected unfreeze result: %+v, %v", frozen, err)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newServer(t)
+	ctx := context.Background()
+
+	_, err := New(server.URL, "wrong-key").Models(ctx)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 error, got %v", err)
+	}
+
+	_, err = New(server.URL, "admin-key").PushConfig(ctx, Bundle{SchemaVersion: 99, Version: "v3"}, true)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict || len(apiErr.SupportedSchemaVersions) == 0 {
+		t.Errorf("expected schema negotiation error, got %v", err)
+	}
+
+	_, err = New(server.URL, "admin-key").DisableKey(ctx, "")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "id" {
+		t.Errorf("expected a field-level error, got %v", err)
+	}
+}
+
+func TestClient_Stats(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newServer(t)
+	stats, err := New(server.URL, "pk-backend").Stats(context.Background(), StatsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Application != "BACKEND" || len(stats.Usage) != 1 || stats.Usage[0].TotalTokens != 15 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestClient_Limits(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newServer(t)
+	limits, err := New(server.URL, "pk-backend").Limits(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.Application != "BACKEND" || limits.MaxTokens == nil || limits.MaxTokens.Limit != 2000 || len(limits.Budgets) != 1 || limits.Budgets[0].RemainingUSD != 100 {
+		t.Errorf("unexpected limits: %+v", limits)
+	}
+}
+
+func TestClient_Jobs(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := jobs.New(storage.NewMemory(), time.Hour, 0, nil, nil, logger)
+	keyring := middleware.NewKeyring([]models.ProxyKey{{Key: "pk-backend", Application: "BACKEND"}})
+	auth := middleware.AuthMiddleware(keyring, events.NewHub(), logger)
+	mux := http.NewServeMux()
+	mux.Handle("/v1/chat/completions", auth(manager.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[]}`))
+	}))))
+	mux.Handle("/v1/jobs/", auth(manager.Handler()))
+	mux.Handle("/v1/jobs", auth(manager.Handler()))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions?async=1", nil)
+	req.Header.Set("Authorization", "Bearer pk-backend")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accepted Job
+	json.NewDecoder(resp.Body).Decode(&accepted)
+	resp.Body.Close()
+
+	c := New(server.URL, "pk-backend")
+	ctx := context.Background()
+	job, err := c.Job(ctx, accepted.ID)
+	for err == nil && !job.Finished() {
+		time.Sleep(5 * time.Millisecond)
+		job, err = c.Job(ctx, accepted.ID)
+	}
+	if err != nil || job.Status != jobs.Succeeded || string(job.Response) != `{"choices":[]}` {
+		t.Fatalf("unexpected job: %+v, %v", job, err)
+	}
+	if list, err := c.Jobs(ctx); err != nil || len(list) != 1 || list[0].ID != accepted.ID {
+		t.Errorf("unexpected jobs: %+v, %v", list, err)
+	}
+	if _, err := c.CancelJob(ctx, accepted.ID); err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *Error
+	if _, err := c.Job(ctx, accepted.ID); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted job, got %v", err)
+	}
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	t.Parallel()
+
+	server, hub := newServer(t)
+	c := New(server.URL, "admin-key")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan Event)
+	done := make(chan error)
+	go func() {
+		done <- c.Subscribe(ctx, func(ev Event) error {
+			select {
+			case received <- ev:
+			case <-ctx.Done():
+			}
+			return nil
+		})
+	}()
+
+	// Publish until the subscription is connected
+	var ev Event
+	for ev.Type == "" {
+		hub.Publish(EventConfigApplied, "ops", map[string]any{"version": "v2"})
+		select {
+		case ev = <-received:
+		case err := <-done:
+			t.Fatalf("subscription ended early: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if ev.Type != EventConfigApplied || ev.Operator != "ops" || ev.Data["version"] != "v2" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}